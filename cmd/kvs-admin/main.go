@@ -0,0 +1,339 @@
+// kvs-admin runs operational commands against a kvs-server:
+//
+//	kvs-admin [-addr localhost:8081] snapshot
+//	kvs-admin bgsave [status]
+//	kvs-admin rewrite-wal [status]
+//	kvs-admin [-restore-mode replace|merge|missing] [-restore-keys patterns] restore [file]
+//	kvs-admin verify-backup [file]
+//	kvs-admin backups
+//	kvs-admin stats
+//	kvs-admin flush-cache
+//	kvs-admin clients
+//	kvs-admin slowlog [reset]
+//	kvs-admin log-level [debug|info|warn|error]
+//	kvs-admin read-only [on|off]
+//	kvs-admin namespaces
+//	kvs-admin memory [samples]
+//	kvs-admin keyspace [samples]
+//	kvs-admin freeze [duration|off]
+//	kvs-admin replica-of [host:port|no-one]
+//	kvs-admin trash
+//	kvs-admin purge [key]
+//	kvs-admin diagnose [dir]
+//	kvs-admin [-format json|csv] dump file
+//	kvs-admin [-format json|csv] load file
+//	kvs-admin commands
+//	kvs-admin [-freeze 10s] cluster-backup file
+//	kvs-admin cluster-restore file
+//	kvs-admin reshard first-last host:port
+//
+// cluster-backup writes every server of the cluster -addr belongs to into
+// one bundle, refusing writes for at most -freeze meanwhile so it is one
+// point in time; cluster-restore loads a bundle back into the cluster,
+// giving each server the keys it owns now. reshard moves the slots first
+// to last, with their keys, to the server at host:port while the cluster
+// serves them.
+//
+// keyspace samples the server's keys and prints histograms of their value
+// sizes and TTLs left, the commonest prefixes and the keys requested most,
+// to plan eviction and sharding.
+//
+// trash lists the keys the server soft deleted that UNDELETE can still
+// bring back; purge drops one of them, or all, for good.
+//
+// dump writes every key of the server, with its value, type, TTL and
+// checksum, to a portable JSON or CSV file, for audits or to clone the
+// data into another environment with load, which writes the file's keys
+// over the server's. -format defaults to the file's extension.
+//
+// commands prints the server's description of every action it understands
+// as JSON, for tools that generate clients or documentation from it.
+//
+// It exits with status 1 if the command fails.
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// subcommands maps each command line name to its ADMIN subcommand and
+// whether it takes an argument
+var subcommands = map[string]struct {
+	admin  string
+	hasArg bool
+}{
+	"snapshot":      {protocol.AdminSnapshot, false},
+	"bgsave":        {protocol.AdminBGSave, true},
+	"rewrite-wal":   {protocol.AdminRewriteWAL, true},
+	"restore":       {protocol.AdminRestore, true},
+	"verify-backup": {protocol.AdminVerifyBackup, true},
+	"backups":       {protocol.AdminBackups, false},
+	"stats":         {protocol.AdminStats, false},
+	"flush-cache":   {protocol.AdminFlushCache, false},
+	"clients":       {protocol.AdminClients, false},
+	"slowlog":       {protocol.AdminSlowLog, true},
+	"log-level":     {protocol.AdminLogLevel, true},
+	"read-only":     {protocol.AdminReadOnly, true},
+	"namespaces":    {protocol.AdminNamespaces, false},
+	"memory":        {protocol.AdminMemory, true},
+	"keyspace":      {protocol.AdminKeyspace, true},
+	"freeze":        {protocol.AdminFreeze, true},
+	"replica-of":    {protocol.AdminReplicaOf, true},
+	"trash":         {protocol.AdminTrash, false},
+	"purge":         {protocol.AdminPurge, true},
+}
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "server address")
+	timeout := flag.Duration("timeout", 30*time.Second, "timeout for the command")
+	token := flag.String("token", os.Getenv("KVS_ADMIN_TOKEN"), "admin token of a server started with -admin-token, $KVS_ADMIN_TOKEN by default")
+	restoreMode := flag.String("restore-mode", "replace", "how restore loads the backup: replace the data, merge its keys over the data, or add only the keys that are missing")
+	restoreKeys := flag.String("restore-keys", "", "comma-separated key patterns, e.g. \"users/*\", that a restore merge is limited to")
+	format := flag.String("format", "", "file format of dump and load, json or csv; by default the file's extension")
+	freeze := flag.Duration("freeze", 10*time.Second, "longest cluster-backup may refuse writes for, 0 to back up without refusing them")
+	flag.Usage = func() {
+		fmt.Fprintln(flag.CommandLine.Output(), "usage: kvs-admin [flags] snapshot | bgsave [status] | rewrite-wal [status] | restore [file] | verify-backup [file] | backups | stats | flush-cache | clients | slowlog [reset] | log-level [level] | read-only [on|off] | namespaces | memory [samples] | keyspace [samples] | freeze [duration|off] | replica-of [host:port|no-one] | diagnose [dir] | dump file | load file | commands | cluster-backup file | cluster-restore file | reshard first-last host:port")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() == 0 || flag.NArg() > 2 && flag.Arg(0) != "reshard" || flag.NArg() > 3 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	opts := []kvsclient.Option{kvsclient.WithTimeout(*timeout), kvsclient.WithToken(*token)}
+	ctx := context.Background()
+
+	switch flag.Arg(0) {
+	case "cluster-backup", "cluster-restore":
+		cc := kvsclient.NewClusterClient([]string{*addr}, opts...)
+		if err := runCluster(ctx, cc, flag.Arg(0), flag.Arg(1), *freeze); err != nil {
+			fmt.Fprintln(os.Stderr, "kvs-admin:", err)
+			cc.Close()
+			os.Exit(1)
+		}
+		cc.Close()
+		return
+	case "reshard":
+		cc := kvsclient.NewClusterClient([]string{*addr}, opts...)
+		if err := reshard(ctx, cc, flag.Arg(1), flag.Arg(2)); err != nil {
+			fmt.Fprintln(os.Stderr, "kvs-admin:", err)
+			cc.Close()
+			os.Exit(1)
+		}
+		cc.Close()
+		return
+	}
+	client := kvsclient.NewClient(*addr, opts...)
+	defer client.Close()
+	request := protocol.Request{Action: protocol.ActionAdmin, Key: flag.Arg(1)}
+	if flag.Arg(0) == "restore" {
+		request.Values = []string{*restoreMode}
+		if *restoreKeys != "" {
+			request.Keys = strings.Split(*restoreKeys, ",")
+		}
+	}
+	if flag.Arg(0) == "dump" || flag.Arg(0) == "load" {
+		f := *format
+		if f == "" {
+			f = strings.TrimPrefix(strings.ToLower(filepath.Ext(flag.Arg(1))), ".")
+		}
+		request.Values = []string{f}
+	}
+	if err := run(ctx, client, flag.Arg(0), request); err != nil {
+		fmt.Fprintln(os.Stderr, "kvs-admin:", err)
+		client.Close()
+		os.Exit(1)
+	}
+}
+
+// run sends request, its Key the argument given, as the command name
+func run(ctx context.Context, client *kvsclient.Client, name string, request protocol.Request) error {
+	arg := request.Key
+	if name == "diagnose" {
+		if arg == "" {
+			arg = "."
+		}
+		path, err := client.Diagnose(ctx, arg)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Diagnostics saved to", path)
+		return nil
+	}
+	if name == "dump" || name == "load" {
+		if arg == "" {
+			return fmt.Errorf("%s needs a file", name)
+		}
+		return transfer(ctx, client, name, arg, request.Values[0])
+	}
+	if name == "commands" {
+		specs, err := client.Commands(ctx)
+		if err != nil {
+			return err
+		}
+		out := json.NewEncoder(os.Stdout)
+		out.SetIndent("", "  ")
+		return out.Encode(specs)
+	}
+	sub, ok := subcommands[name]
+	if !ok {
+		return fmt.Errorf("unknown command %q", name)
+	}
+	if arg != "" && !sub.hasArg {
+		return fmt.Errorf("%s takes no argument", name)
+	}
+	request.Value = sub.admin
+	response, err := client.Do(ctx, request)
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		if response.Message == protocol.MsgInvalidAction {
+			return errors.New("the server does not support " + name)
+		}
+		return errors.New(response.Message)
+	}
+	switch sub.admin {
+	case protocol.AdminSnapshot:
+		fmt.Println("Snapshot written to", response.Value)
+	case protocol.AdminBGSave:
+		switch response.Message {
+		case protocol.MsgSnapshotStarted:
+			fmt.Println("Snapshot of", response.Value, "started")
+		case protocol.MsgSnapshotRunning:
+			fmt.Println("Snapshot of", response.Value, "already running")
+		}
+		for _, line := range response.Values {
+			fmt.Println(line)
+		}
+	case protocol.AdminRewriteWAL:
+		switch response.Message {
+		case protocol.MsgRewriteStarted:
+			fmt.Println("Rewrite of", response.Value, "started")
+		case protocol.MsgRewriteRunning:
+			fmt.Println("Rewrite of", response.Value, "already running")
+		}
+		for _, line := range response.Values {
+			fmt.Println(line)
+		}
+	case protocol.AdminFlushCache:
+		fmt.Println("Flushed", response.Value, "cached keys")
+	case protocol.AdminSlowLog:
+		if request.Key != "" {
+			fmt.Println("Cleared", response.Value, "slow requests")
+		}
+		for _, line := range response.Values {
+			fmt.Println(line)
+		}
+	case protocol.AdminPurge:
+		fmt.Println("Purged", response.Value, "deleted keys")
+	case protocol.AdminLogLevel, protocol.AdminReadOnly, protocol.AdminFreeze:
+		fmt.Println(response.Value)
+	default:
+		for _, line := range response.Values {
+			fmt.Println(line)
+		}
+	}
+	return nil
+}
+
+// transfer runs dump or load of file in format
+func transfer(ctx context.Context, client *kvsclient.Client, name, file, format string) error {
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown format %q, use -format json or csv", format)
+	}
+	if name == "load" {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		response, err := client.Do(ctx, protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminImport, Key: string(data), Values: []string{format}})
+		if err != nil {
+			return err
+		}
+		if !response.Success {
+			return errors.New(response.Message)
+		}
+		for _, line := range response.Values {
+			fmt.Println(line)
+		}
+		return nil
+	}
+	response, err := client.Do(ctx, protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminExport, Key: format})
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return errors.New(response.Message)
+	}
+	if err := os.WriteFile(file, []byte(response.Value), 0o600); err != nil {
+		return err
+	}
+	for _, line := range response.Values {
+		fmt.Println(line)
+	}
+	fmt.Println("Keys exported to", file)
+	return nil
+}
+
+func runCluster(ctx context.Context, cc *kvsclient.ClusterClient, name, file string, freeze time.Duration) error {
+	if file == "" {
+		return fmt.Errorf("%s needs a file", name)
+	}
+	if name == "cluster-restore" {
+		f, err := os.Open(file)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		results, err := cc.Restore(ctx, f)
+		for _, r := range results {
+			fmt.Printf("%s loaded=%d expired=%d damaged=%d\n", r.Addr, r.Loaded, r.Expired, r.Damaged)
+		}
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	manifest, err := cc.Backup(ctx, f, freeze)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(file)
+		return err
+	}
+	for _, node := range manifest.Nodes {
+		fmt.Printf("%s revision=%d keys=%d\n", node.Addr, node.Revision, node.Keys)
+	}
+	fmt.Println("Cluster backup saved to", file)
+	return nil
+}
+
+func reshard(ctx context.Context, cc *kvsclient.ClusterClient, slots, target string) error {
+	var first, last int
+	if _, err := fmt.Sscanf(slots, "%d-%d", &first, &last); err != nil || target == "" {
+		return errors.New("reshard needs a slot range, e.g. 0-255, and the server to move it to")
+	}
+	moved, err := cc.Reshard(ctx, first, last, target, func(moved int) {
+		fmt.Printf("\r%d keys moved", moved)
+	})
+	fmt.Println()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Slots %d-%d moved to %s with %d keys\n", first, last, target, moved)
+	return nil
+}
@@ -0,0 +1,304 @@
+// kvs-bench measures how fast a kvs-server, or the store and proxy in
+// process, serves a mix of GETs and SETs:
+//
+//	kvs-bench [-addr localhost:8081] [-clients 50] [-duration 10s] [-gets 0.9]
+//	kvs-bench -keys 100000 -dist zipf -value-size 100
+//	kvs-bench -target proxy|store
+//
+// Each of -clients goroutines sends one request at a time, a GET with
+// probability -gets and otherwise a SET of a -value-size byte value, of a
+// key picked from -keys by -dist: uniform, or zipf for a few hot keys. The
+// keys are written once before the clock starts unless -fill=false. It
+// runs for -duration, or until -requests requests if that is set, and
+// prints the throughput and the latency percentiles of each action.
+//
+// -target proxy and -target store run against a ServerProxy over a
+// KeyValueStore, or the store alone, in this process, leaving out the
+// network and the server, to tell their cost from the rest.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// target is what the benchmark sends requests to
+type target interface {
+	get(ctx context.Context, key string) (found bool, err error)
+	set(ctx context.Context, key, value string, ttl time.Duration) error
+	close()
+}
+
+// serverTarget is a kvs-server over the network
+type serverTarget struct{ client *kvsclient.Client }
+
+func (t serverTarget) get(ctx context.Context, key string) (bool, error) {
+	_, err := t.client.Get(ctx, key)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (t serverTarget) set(ctx context.Context, key, value string, ttl time.Duration) error {
+	return t.client.SetWithTTL(ctx, key, value, ttl)
+}
+
+func (t serverTarget) close() { t.client.Close() }
+
+// proxyTarget is a ServerProxy over a KeyValueStore in this process
+type proxyTarget struct{ sp *kvstore.ServerProxy }
+
+func (t proxyTarget) get(ctx context.Context, key string) (bool, error) {
+	_, found, err := t.sp.GETKVContext(ctx, key)
+	return found, err
+}
+
+func (t proxyTarget) set(_ context.Context, key, value string, ttl time.Duration) error {
+	if _, message, ok := t.sp.SETSUM(key, value, ttl, 0); !ok {
+		return errors.New(message)
+	}
+	return nil
+}
+
+func (t proxyTarget) close() {}
+
+// storeTarget is a KeyValueStore in this process
+type storeTarget struct{ kvs *kvstore.KeyValueStore }
+
+func (t storeTarget) get(_ context.Context, key string) (bool, error) {
+	_, found := t.kvs.GETKV(key)
+	return found, nil
+}
+
+func (t storeTarget) set(_ context.Context, key, value string, ttl time.Duration) error {
+	if _, message, ok := t.kvs.SETSUM(key, value, ttl, 0); !ok {
+		return errors.New(message)
+	}
+	return nil
+}
+
+func (t storeTarget) close() {}
+
+// config is the benchmark the flags describe
+type config struct {
+	clients   int
+	duration  time.Duration
+	requests  int64
+	gets      float64
+	keys      int
+	dist      string
+	valueSize int
+	prefix    string
+	ttl       time.Duration
+	fill      bool
+}
+
+// result is what one client measured
+type result struct {
+	gets, sets []time.Duration
+	misses     int64
+	errors     int64
+	lastErr    error
+}
+
+func main() {
+	var cfg config
+	addr := flag.String("addr", "localhost:8081", "server address")
+	targetName := flag.String("target", "server", "what to benchmark: server over the network, or proxy or store in this process")
+	codecName := flag.String("codec", protocol.Gob.Name(), "wire encoding: gob, or json for a json:// listener")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each request")
+	flag.IntVar(&cfg.clients, "clients", 50, "how many clients send requests at once")
+	flag.DurationVar(&cfg.duration, "duration", 10*time.Second, "how long to run")
+	flag.Int64Var(&cfg.requests, "requests", 0, "stop after this many requests instead of after -duration")
+	flag.Float64Var(&cfg.gets, "gets", 0.9, "share of the requests that are GETs, the rest are SETs")
+	flag.IntVar(&cfg.keys, "keys", 100000, "how many distinct keys to use")
+	flag.StringVar(&cfg.dist, "dist", "uniform", "how keys are picked: uniform, or zipf for a few hot keys")
+	flag.IntVar(&cfg.valueSize, "value-size", 100, "bytes in each value SET")
+	flag.StringVar(&cfg.prefix, "prefix", "bench/", "prefix of the keys used")
+	flag.DurationVar(&cfg.ttl, "ttl", time.Hour, "TTL of the keys SET")
+	flag.BoolVar(&cfg.fill, "fill", true, "write every key once before measuring, so GETs find them")
+	flag.Parse()
+
+	if cfg.clients <= 0 || cfg.keys <= 0 || cfg.valueSize < 0 || cfg.gets < 0 || cfg.gets > 1 {
+		fmt.Fprintln(os.Stderr, "kvs-bench: -clients and -keys must be positive and -gets between 0 and 1")
+		os.Exit(2)
+	}
+	if cfg.dist != "uniform" && cfg.dist != "zipf" {
+		fmt.Println("Error in -dist:", fmt.Errorf("unknown distribution %q, expected uniform or zipf", cfg.dist))
+		return
+	}
+	var t target
+	switch *targetName {
+	case "server":
+		codec, err := protocol.CodecByName(*codecName)
+		if err != nil {
+			fmt.Println("Error in -codec:", err)
+			return
+		}
+		t = serverTarget{kvsclient.NewClient(*addr, kvsclient.WithTimeout(*timeout), kvsclient.WithCodec(codec),
+			kvsclient.WithMaxIdle(cfg.clients))}
+	case "proxy":
+		t = proxyTarget{kvstore.NewServerProxy(kvstore.NewKeyValueStore())}
+	case "store":
+		t = storeTarget{kvstore.NewKeyValueStore()}
+	default:
+		fmt.Println("Error in -target:", fmt.Errorf("unknown target %q, expected server, proxy or store", *targetName))
+		return
+	}
+	defer t.close()
+
+	ctx := context.Background()
+	if cfg.fill {
+		if err := fill(ctx, t, cfg); err != nil {
+			fmt.Fprintln(os.Stderr, "kvs-bench: filling the keys:", err)
+			t.close()
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("%s: %d clients, %.0f%% GETs, %d keys (%s), %d-byte values\n",
+		*targetName, cfg.clients, cfg.gets*100, cfg.keys, cfg.dist, cfg.valueSize)
+	elapsed, results := run(ctx, t, cfg)
+	report(elapsed, results)
+}
+
+// fill writes every key once, from cfg.clients goroutines
+func fill(ctx context.Context, t target, cfg config) error {
+	var next atomic.Int64
+	errs := make(chan error, cfg.clients)
+	var wg sync.WaitGroup
+	for c := 0; c < cfg.clients; c++ {
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			value := newValue(rand.New(rand.NewSource(int64(c))), cfg.valueSize)
+			for i := next.Add(1) - 1; i < int64(cfg.keys); i = next.Add(1) - 1 {
+				if err := t.set(ctx, cfg.prefix+fmt.Sprint(i), value, cfg.ttl); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	close(errs)
+	return <-errs
+}
+
+// run sends requests from cfg.clients goroutines until cfg says to stop,
+// and returns how long they took and what each client measured
+func run(ctx context.Context, t target, cfg config) (time.Duration, []*result) {
+	results := make([]*result, cfg.clients)
+	var sent atomic.Int64
+	deadline := time.Now().Add(cfg.duration)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for c := range results {
+		r := &result{}
+		results[c] = r
+		wg.Add(1)
+		go func(c int) {
+			defer wg.Done()
+			rng := rand.New(rand.NewSource(time.Now().UnixNano() + int64(c)))
+			pick := keyPicker(rng, cfg)
+			value := newValue(rng, cfg.valueSize)
+			for {
+				if cfg.requests > 0 {
+					if sent.Add(1) > cfg.requests {
+						return
+					}
+				} else if time.Now().After(deadline) {
+					return
+				}
+				key := cfg.prefix + fmt.Sprint(pick())
+				began := time.Now()
+				var err error
+				if rng.Float64() < cfg.gets {
+					var found bool
+					found, err = t.get(ctx, key)
+					r.gets = append(r.gets, time.Since(began))
+					if err == nil && !found {
+						r.misses++
+					}
+				} else {
+					err = t.set(ctx, key, value, cfg.ttl)
+					r.sets = append(r.sets, time.Since(began))
+				}
+				if err != nil {
+					r.errors++
+					r.lastErr = err
+				}
+			}
+		}(c)
+	}
+	wg.Wait()
+	return time.Since(start), results
+}
+
+// keyPicker returns a function picking key numbers below cfg.keys by
+// cfg.dist
+func keyPicker(rng *rand.Rand, cfg config) func() uint64 {
+	if cfg.dist == "zipf" {
+		return rand.NewZipf(rng, 1.1, 1, uint64(cfg.keys-1)).Uint64
+	}
+	return func() uint64 { return uint64(rng.Intn(cfg.keys)) }
+}
+
+// newValue returns size random printable bytes
+func newValue(rng *rand.Rand, size int) string {
+	const letters = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	b := make([]byte, size)
+	for i := range b {
+		b[i] = letters[rng.Intn(len(letters))]
+	}
+	return string(b)
+}
+
+// report prints the throughput and latencies the clients measured
+func report(elapsed time.Duration, results []*result) {
+	var gets, sets []time.Duration
+	var misses, errs int64
+	var lastErr error
+	for _, r := range results {
+		gets, sets = append(gets, r.gets...), append(sets, r.sets...)
+		misses += r.misses
+		errs += r.errors
+		if r.lastErr != nil {
+			lastErr = r.lastErr
+		}
+	}
+	total := len(gets) + len(sets)
+	fmt.Printf("%d requests in %s, %.0f requests/sec, %d errors\n",
+		total, elapsed.Round(time.Millisecond), float64(total)/elapsed.Seconds(), errs)
+	latencies("GET", gets)
+	latencies("SET", sets)
+	if len(gets) > 0 && misses > 0 {
+		fmt.Printf("%d GETs (%.1f%%) found no key\n", misses, 100*float64(misses)/float64(len(gets)))
+	}
+	if lastErr != nil {
+		fmt.Println("Last error:", lastErr)
+	}
+}
+
+// latencies prints the percentiles of the latencies of action
+func latencies(action string, d []time.Duration) {
+	if len(d) == 0 {
+		return
+	}
+	slices.Sort(d)
+	at := func(p float64) time.Duration { return d[min(int(p*float64(len(d))), len(d)-1)] }
+	fmt.Printf("%s %9d  p50 %-9s p90 %-9s p99 %-9s p99.9 %-9s max %s\n", action, len(d),
+		at(0.5), at(0.9), at(0.99), at(0.999), d[len(d)-1])
+}
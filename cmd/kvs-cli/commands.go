@@ -0,0 +1,1550 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// command is one thing the CLI can run; args excludes the command name and
+// max < 0 means any number of arguments
+type command struct {
+	usage    string
+	help     string
+	min, max int
+	run      func(ctx context.Context, c *kvsclient.Client, args []string) (string, error)
+}
+
+var commands map[string]command
+
+func init() {
+	// assigned here because HELP refers back to the table
+	commands = map[string]command{
+		"GET":           {"GET key", "get the value of key", 1, 1, get},
+		"GETREV":        {"GETREV key", "get the value of key and its revision", 1, 1, getRevision},
+		"GETAT":         {"GETAT key revision", "get the value key had right after the write at revision", 2, 2, getAt},
+		"HISTORY":       {"HISTORY key [limit]", "list the values key has had, newest first, as far as the server keeps them", 1, 2, history},
+		"DIFF":          {"DIFF key revision [revision]", "compare the values key had at two revisions, or at one and now, line by line", 2, 3, diff},
+		"SET":           {"SET key value [EX seconds | PX milliseconds]", "set key, expiring after the given time or the server's default TTL", 2, 4, set},
+		"SETNX":         {"SETNX key value [EX seconds | PX milliseconds]", "set key only if it does not exist, showing 1 if it was set and 0 if not", 2, 4, setnx},
+		"CAS":           {"CAS key expected value [EX seconds | PX milliseconds]", "set key only if its value is expected, showing the current value if not", 3, 5, cas},
+		"CASREV":        {"CASREV key revision value [EX seconds | PX milliseconds]", "set key only if it is at revision, showing the new revision, or the current value and revision if not", 3, 5, casRevision},
+		"BEGINREAD":     {"BEGINREAD [EX seconds]", "open a read transaction, showing its id and revision", 0, 2, beginRead},
+		"TXGET":         {"TXGET id key [key ...]", "get keys as they were when read transaction id began", 2, -1, txGet},
+		"ENDREAD":       {"ENDREAD id", "close a read transaction", 1, 1, endRead},
+		"GETSET":        {"GETSET key value", "set key and show the value it replaced", 2, 2, getset},
+		"GETDEL":        {"GETDEL key", "delete key and show the value it had", 1, 1, getdel},
+		"TYPE":          {"TYPE key", "show the type of the value at key, none if it does not exist", 1, 1, keyType},
+		"OBJECT":        {"OBJECT key", "show the type, revision, size, TTL and last access of key", 1, 1, object},
+		"MEMORYUSAGE":   {"MEMORYUSAGE key", "show the memory key takes: key, value and overhead bytes and their total", 1, 1, memoryUsage},
+		"TOUCH":         {"TOUCH key [EX seconds | PX milliseconds]", "restart the lifetime of key, for the given time or the TTL it has, showing 1 if it exists and 0 if not", 1, 3, touch},
+		"UNDELETE":      {"UNDELETE key", "bring back key, soft deleted, with its value and TTL", 1, 1, undelete},
+		"UPDATE":        {"UPDATE key value [KEEPTTL | RESET | EX seconds | PX milliseconds]", "replace the value of an existing key, keeping or restarting its TTL or setting a new one", 2, 4, update},
+		"MGET":          {"MGET key [key ...]", "get the values of several keys in one request", 1, -1, mget},
+		"MSET":          {"MSET key value [key value ...]", "set several keys with the server's default TTL in one request", 2, -1, mset},
+		"BATCH":         {"BATCH SET key value | DEL key ...", "set and delete several keys in one step, all or none", 2, -1, batch},
+		"EVAL":          {"EVAL script numkeys [key ...] [arg ...]", "run script on the server in one step, with the keys as KEYS and the rest as ARGV", 2, -1, eval},
+		"HSET":          {"HSET key field value [field value ...]", "set fields of the hash at key, showing how many are new", 3, -1, hset},
+		"HGET":          {"HGET key field", "get a field of the hash at key", 2, 2, hget},
+		"HGETALL":       {"HGETALL key", "get every field of the hash at key", 1, 1, hgetall},
+		"HDEL":          {"HDEL key field [field ...]", "remove fields of the hash at key, showing how many it had", 2, -1, hdel},
+		"LPUSH":         {"LPUSH key item [item ...]", "add items to the head of the list at key, the last first, showing its length", 2, -1, lpush},
+		"RPUSH":         {"RPUSH key item [item ...]", "add items to the tail of the list at key, showing its length", 2, -1, rpush},
+		"LPOP":          {"LPOP key [count]", "remove items from the head of the list at key, one by default", 1, 2, lpop},
+		"RPOP":          {"RPOP key [count]", "remove items from the tail of the list at key, one by default", 1, 2, rpop},
+		"BLPOP":         {"BLPOP key [seconds]", "remove the first item of the list at key, waiting for one if it is empty", 1, 2, blpop},
+		"LRANGE":        {"LRANGE key start stop", "get the items of the list at key from start to stop, -1 being the last", 3, 3, lrange},
+		"SADD":          {"SADD key member [member ...]", "add members to the set at key, showing how many are new", 2, -1, sadd},
+		"SREM":          {"SREM key member [member ...]", "remove members of the set at key, showing how many it had", 2, -1, srem},
+		"SMEMBERS":      {"SMEMBERS key", "get the members of the set at key", 1, 1, smembers},
+		"SISMEMBER":     {"SISMEMBER key member", "show 1 if the set at key has member, else 0", 2, 2, sismember},
+		"SUNION":        {"SUNION key [key ...]", "get the members of any of the sets", 1, -1, sunion},
+		"SINTER":        {"SINTER key [key ...]", "get the members of all of the sets", 1, -1, sinter},
+		"SDIFF":         {"SDIFF key [key ...]", "get the members of the first set that none of the others has", 1, -1, sdiff},
+		"ZADD":          {"ZADD key score member [score member ...]", "set scores of members of the sorted set at key, showing how many are new", 3, -1, zadd},
+		"ZREM":          {"ZREM key member [member ...]", "remove members of the sorted set at key, showing how many it had", 2, -1, zrem},
+		"ZRANGE":        {"ZRANGE key start stop", "get the members of the sorted set at key from start to stop, lowest score first", 3, 3, zrange},
+		"ZRANGEBYSCORE": {"ZRANGEBYSCORE key min max [LIMIT n]", "get the members of the sorted set at key scored from min to max, -inf and +inf for no bound", 3, 5, zrangeByScore},
+		"ZRANK":         {"ZRANK key member", "show the index of member in the sorted set at key, from 0 for the lowest score", 2, 2, zrank},
+		"GEOADD":        {"GEOADD key lon lat member [lon lat member ...]", "set locations of members of the geo set at key, showing how many are new", 4, -1, geoAdd},
+		"GEOSEARCH":     {"GEOSEARCH key lon lat BYRADIUS r m|km|mi|ft | BYBOX w h m|km|mi|ft [COUNT n]", "find members of the geo set at key within a circle or box, nearest first, with their distance and location", 6, 9, geoSearch},
+		"JSON.SET":      {"JSON.SET key path json", "set the part of the JSON document at key that path, such as $.a[0], selects; $ for the whole document", 3, 3, jsonSet},
+		"JSON.GET":      {"JSON.GET key [path]", "get the part of the JSON document at key that path selects, the whole document by default", 1, 2, jsonGet},
+		"XADD":          {"XADD key [MAXLEN n] field value [field value ...]", "append an entry to the stream at key, keeping the last n entries, showing its ID", 3, -1, xadd},
+		"XREAD":         {"XREAD key [after] [COUNT n]", "get the entries of the stream at key with an ID after after, from the start by default", 1, 4, xread},
+		"XGROUP":        {"XGROUP key group [start]", "create a consumer group on the stream at key, delivering entries after start, 0 for all, or only new ones by default", 2, 3, xgroup},
+		"XREADGROUP":    {"XREADGROUP key group consumer [after] [COUNT n]", "deliver new entries of the stream at key to consumer, or with after re-read those pending for it", 3, 6, xreadGroup},
+		"XACK":          {"XACK key group id [id ...]", "acknowledge entries delivered to group, showing how many were pending", 3, -1, xack},
+		"INCR":          {"INCR key", "add one to the integer in key, starting from 0", 1, 1, incr},
+		"INCRBY":        {"INCRBY key n", "add n to the integer in key, starting from 0", 2, 2, incrBy},
+		"NEXTID":        {"NEXTID key [count]", "hand out the next count IDs, one by default, of the sequence in key and show the first", 1, 2, nextID},
+		"DECR":          {"DECR key", "subtract one from the integer in key, starting from 0", 1, 1, decr},
+		"APPEND":        {"APPEND key value", "add value to the end of key, creating it if missing, and show the new length", 2, 2, appendValue},
+		"STRLEN":        {"STRLEN key", "show the length of the value of key in bytes, 0 if missing", 1, 1, strlen},
+		"SETBIT":        {"SETBIT key offset 0|1", "set a bit of the value of key, 0 being the first byte's highest, showing what it was", 3, 3, setBit},
+		"GETBIT":        {"GETBIT key offset", "show a bit of the value of key, 0 past its end", 2, 2, getBit},
+		"BITCOUNT":      {"BITCOUNT key", "show how many bits of the value of key are set", 1, 1, bitCount},
+		"DEL":           {"DEL key [key ...]", "delete keys and count the ones that existed", 1, -1, del},
+		"RENAME":        {"RENAME key newkey", "move the value of key, with its TTL, to newkey", 2, 2, rename},
+		"COPY":          {"COPY key newkey [EX seconds | PX milliseconds]", "copy the value of key to newkey, expiring with key or after the given time", 2, 4, copyKey},
+		"LIST":          {"LIST [dir]", "list the keys and sub-directories directly under dir, the root by default", 0, 1, listDir},
+		"DBSIZE":        {"DBSIZE", "count the live keys, in all and by namespace", 0, 0, dbsize},
+		"SCAN":          {"SCAN pattern [COUNT n]", "list keys matching a glob pattern such as user:*, n keys examined per request", 1, 3, scan},
+		"RANGE":         {"RANGE start [end] [LIMIT n]", "list the keys from start up to but not including end, in order", 1, 4, keyRange},
+		"SEARCH":        {"SEARCH query [LIMIT n] [OFFSET n]", "list the keys whose values hold every word of query, best match first", 1, 5, search},
+		"PIN":           {"PIN key", "protect key from eviction", 1, 1, pin},
+		"UNPIN":         {"UNPIN key", "remove a pin", 1, 1, unpin},
+		"PUBLISH":       {"PUBLISH channel message", "queue message for every durable subscriber of channel", 2, 2, publish},
+		"JOURNAL":       {"JOURNAL [after [limit]]", "list committed writes after a revision", 0, 2, journal},
+		"LOCKS":         {"LOCKS", "list the advisory locks held", 0, 0, locks},
+		"LOCK":          {"LOCK key owner [EX seconds | PX milliseconds]", "take a lease lock on key, showing its fencing token", 2, 4, lock},
+		"RENEW":         {"RENEW key token [EX seconds | PX milliseconds]", "restart the lease of the lock with token", 2, 4, renew},
+		"UNLOCK":        {"UNLOCK key token", "release the lease lock with token", 2, 2, unlock},
+		"CLUSTER":       {"CLUSTER INFO|MIGRATIONS", "show the cluster slot map, or the slots moving to or from the server", 1, 1, cluster},
+		"WAIT":          {"WAIT replicas timeout-ms", "wait for that many replicas to have every write so far, showing how many do", 2, 2, wait},
+		"PING":          {"PING [message]", "check the server is serving, showing message or PONG", 0, 1, ping},
+		"HELLO":         {"HELLO", "list the server's capabilities", 0, 0, hello},
+		"COMMANDS":      {"COMMANDS [action]", "describe the protocol actions the server understands", 0, 1, describe},
+		"HELP":          {"HELP [command]", "describe the commands", 0, 1, help},
+		"QUIT":          {"QUIT", "leave the prompt", 0, 0, nil},
+	}
+}
+
+// execute runs args[0] with the rest of args
+func execute(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	name := strings.ToUpper(args[0])
+	cmd, ok := commands[name]
+	if !ok && custom(ctx, c, name) {
+		return runCustom(ctx, c, name, args[1:])
+	}
+	if !ok || cmd.run == nil {
+		return "", fmt.Errorf("unknown command %q, try HELP", args[0])
+	}
+	args = args[1:]
+	if len(args) < cmd.min || (cmd.max >= 0 && len(args) > cmd.max) {
+		return "", fmt.Errorf("usage: %s", cmd.usage)
+	}
+	return cmd.run(ctx, c, args)
+}
+
+// completeCommand returns the command names that complete prefix
+func completeCommand(prefix string) []string {
+	var names []string
+	for name := range commands {
+		if strings.HasPrefix(name, strings.ToUpper(prefix)) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// custom reports whether the server announces name as a custom command
+func custom(ctx context.Context, c *kvsclient.Client, name string) bool {
+	caps, err := c.ServerCapabilities(ctx)
+	if err != nil {
+		return false
+	}
+	for _, capability := range caps {
+		if capability == protocol.CommandCapability(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// runCustom sends a custom command as action key value..., the value being
+// the remaining words joined by spaces
+func runCustom(ctx context.Context, c *kvsclient.Client, name string, args []string) (string, error) {
+	request := protocol.Request{Action: name}
+	if len(args) > 0 {
+		request.Key = args[0]
+		request.Value = strings.Join(args[1:], " ")
+	}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", errors.New(response.Message)
+	}
+	switch {
+	case len(response.Values) > 0:
+		return list(response.Values), nil
+	case response.Value != "":
+		return strconv.Quote(response.Value), nil
+	case response.Message != "":
+		return response.Message, nil
+	}
+	return "OK", nil
+}
+
+func get(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	value, err := c.Get(ctx, args[0])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(value), nil
+}
+
+func getRevision(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	value, rev, err := c.GetRevision(ctx, args[0])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return list([]string{strconv.Quote(value), fmt.Sprintf("(integer) %d", rev)}), nil
+}
+
+func getAt(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	rev, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || rev == 0 {
+		return "", fmt.Errorf("invalid revision %q", args[1])
+	}
+	value, err := c.GetAt(ctx, args[0], rev)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(value), nil
+}
+
+func history(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	limit := 0
+	if len(args) > 1 {
+		var err error
+		if limit, err = strconv.Atoi(args[1]); err != nil {
+			return "", fmt.Errorf("invalid limit %q", args[1])
+		}
+	}
+	entries, err := c.History(ctx, args[0], limit)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("%d %s %s", e.Revision, e.Time.Format(time.RFC3339), e.Op)
+		if e.Op != protocol.ActionDelete {
+			lines[i] += " " + strconv.Quote(e.Value)
+		}
+	}
+	return list(lines), nil
+}
+
+func diff(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var revs [2]uint64
+	for i, arg := range args[1:] {
+		rev, err := strconv.ParseUint(arg, 10, 64)
+		if err != nil || rev == 0 {
+			return "", fmt.Errorf("invalid revision %q", arg)
+		}
+		revs[i] = rev
+	}
+	lines, err := c.Diff(ctx, args[0], revs[0], revs[1])
+	if err != nil {
+		return "", err
+	}
+	if len(lines) == 0 {
+		return "(empty)", nil
+	}
+	return strings.Join(lines, "\n"), nil
+}
+
+func set(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			return "", errors.New("usage: " + commands["SET"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[2], args[3]); err != nil {
+			return "", err
+		}
+	}
+	if err := c.SetWithTTL(ctx, args[0], args[1], ttl); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+// expiry parses EX seconds or PX milliseconds
+func expiry(option, n string) (time.Duration, error) {
+	v, err := strconv.ParseInt(n, 10, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid expire time %q", n)
+	}
+	switch strings.ToUpper(option) {
+	case "EX":
+		return time.Duration(v) * time.Second, nil
+	case "PX":
+		return time.Duration(v) * time.Millisecond, nil
+	}
+	return 0, fmt.Errorf("unknown option %q, expected EX or PX", option)
+}
+
+func update(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	switch {
+	case len(args) == 3 && strings.EqualFold(args[2], "KEEPTTL"):
+		ttl = kvsclient.KeepTTL
+	case len(args) == 3 && strings.EqualFold(args[2], "RESET"):
+		ttl = kvsclient.ResetTTL
+	case len(args) == 4:
+		var err error
+		if ttl, err = expiry(args[2], args[3]); err != nil {
+			return "", err
+		}
+	case len(args) != 2:
+		return "", errors.New("usage: " + commands["UPDATE"].usage)
+	}
+	err := c.UpdateWithTTL(ctx, args[0], args[1], ttl)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func mget(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	values, err := c.MGet(ctx, args...)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(args))
+	for i, key := range args {
+		lines[i] = "(nil)"
+		if value, ok := values[key]; ok {
+			lines[i] = strconv.Quote(value)
+		}
+	}
+	return list(lines), nil
+}
+
+func mset(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if len(args)%2 != 0 {
+		return "", errors.New("usage: " + commands["MSET"].usage)
+	}
+	values := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		values[args[i]] = args[i+1]
+	}
+	if err := c.MSet(ctx, values, 0); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func batch(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	b := c.WriteBatch(0)
+	for len(args) > 0 {
+		switch {
+		case strings.EqualFold(args[0], "SET") && len(args) >= 3:
+			b.Set(args[1], args[2])
+			args = args[3:]
+		case strings.EqualFold(args[0], "DEL") && len(args) >= 2:
+			b.Delete(args[1])
+			args = args[2:]
+		default:
+			return "", errors.New("usage: " + commands["BATCH"].usage)
+		}
+	}
+	if _, err := b.Commit(ctx); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func eval(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n < 0 || n > len(args)-2 {
+		return "", errors.New("usage: " + commands["EVAL"].usage)
+	}
+	values, err := c.Eval(ctx, args[0], args[2:2+n], args[2+n:]...)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 1 {
+		return strconv.Quote(values[0]), nil
+	}
+	lines := make([]string, len(values))
+	for i, value := range values {
+		lines[i] = strconv.Quote(value)
+	}
+	return list(lines), nil
+}
+
+func hset(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if len(args)%2 != 1 {
+		return "", errors.New("usage: " + commands["HSET"].usage)
+	}
+	fields := make(map[string]string, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	n, err := c.HSet(ctx, args[0], fields, 0)
+	return integer(int64(n), err)
+}
+
+func hget(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return quoted(c.HGet(ctx, args[0], args[1]))
+}
+
+func hgetall(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	fields, err := c.HGetAll(ctx, args[0])
+	if err != nil && !errors.Is(err, kvsclient.ErrNotFound) {
+		return "", err
+	}
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var lines []string
+	for _, name := range names {
+		lines = append(lines, strconv.Quote(name), strconv.Quote(fields[name]))
+	}
+	return list(lines), nil
+}
+
+func hdel(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.HDel(ctx, args[0], args[1:]...)
+	return integer(int64(n), err)
+}
+
+func lpush(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.LPush(ctx, args[0], args[1:], 0)
+	return integer(int64(n), err)
+}
+
+func rpush(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.RPush(ctx, args[0], args[1:], 0)
+	return integer(int64(n), err)
+}
+
+func lpop(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return pop(ctx, args, c.LPop, c.LPopN)
+}
+
+func rpop(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return pop(ctx, args, c.RPop, c.RPopN)
+}
+
+// pop runs LPOP or RPOP: one item, or with a count up to that many
+func pop(ctx context.Context, args []string, one func(context.Context, string) (string, error), many func(context.Context, string, int) ([]string, error)) (string, error) {
+	if len(args) == 1 {
+		return quoted(one(ctx, args[0]))
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return "", fmt.Errorf("invalid count %q", args[1])
+	}
+	items, err := many(ctx, args[0], n)
+	if err != nil {
+		return "", err
+	}
+	return quotedList(items), nil
+}
+
+func blpop(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var wait time.Duration
+	if len(args) == 2 {
+		n, err := strconv.ParseFloat(args[1], 64)
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid timeout %q", args[1])
+		}
+		wait = time.Duration(n * float64(time.Second))
+	}
+	return quoted(c.BLPop(ctx, args[0], wait))
+}
+
+func lrange(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid index %q", args[1])
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid index %q", args[2])
+	}
+	items, err := c.LRange(ctx, args[0], start, stop)
+	if err != nil && !errors.Is(err, kvsclient.ErrNotFound) {
+		return "", err
+	}
+	return quotedList(items), nil
+}
+
+func sadd(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.SAdd(ctx, args[0], args[1:], 0)
+	return integer(int64(n), err)
+}
+
+func srem(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.SRem(ctx, args[0], args[1:]...)
+	return integer(int64(n), err)
+}
+
+func smembers(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	members, err := c.SMembers(ctx, args[0])
+	if err != nil && !errors.Is(err, kvsclient.ErrNotFound) {
+		return "", err
+	}
+	return quotedList(members), nil
+}
+
+func sismember(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	in, err := c.SIsMember(ctx, args[0], args[1])
+	if in {
+		return integer(1, err)
+	}
+	return integer(0, err)
+}
+
+func sunion(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return members(c.SUnion(ctx, args...))
+}
+
+func sinter(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return members(c.SInter(ctx, args...))
+}
+
+func sdiff(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return members(c.SDiff(ctx, args...))
+}
+
+// members shows the result of a set operation
+func members(members []string, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return quotedList(members), nil
+}
+
+func zadd(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if len(args)%2 == 0 {
+		return "", errors.New("usage: " + commands["ZADD"].usage)
+	}
+	scores := make(map[string]float64, len(args)/2)
+	for i := 1; i < len(args); i += 2 {
+		score, err := protocol.ParseScore(args[i])
+		if err != nil {
+			return "", fmt.Errorf("invalid score %q", args[i])
+		}
+		scores[args[i+1]] = score
+	}
+	n, err := c.ZAdd(ctx, args[0], scores, 0)
+	return integer(int64(n), err)
+}
+
+func zrem(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.ZRem(ctx, args[0], args[1:]...)
+	return integer(int64(n), err)
+}
+
+func zrange(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	start, err := strconv.Atoi(args[1])
+	if err != nil {
+		return "", fmt.Errorf("invalid index %q", args[1])
+	}
+	stop, err := strconv.Atoi(args[2])
+	if err != nil {
+		return "", fmt.Errorf("invalid index %q", args[2])
+	}
+	return scoredList(c.ZRange(ctx, args[0], start, stop))
+}
+
+func zrangeByScore(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var bounds [2]float64
+	for i := range bounds {
+		score, err := protocol.ParseScore(args[1+i])
+		if err != nil {
+			return "", fmt.Errorf("invalid score %q", args[1+i])
+		}
+		bounds[i] = score
+	}
+	limit := 0
+	if len(args) > 3 {
+		if len(args) != 5 || !strings.EqualFold(args[3], "LIMIT") {
+			return "", errors.New("usage: " + commands["ZRANGEBYSCORE"].usage)
+		}
+		var err error
+		if limit, err = strconv.Atoi(args[4]); err != nil || limit <= 0 {
+			return "", fmt.Errorf("invalid limit %q", args[4])
+		}
+	}
+	return scoredList(c.ZRangeByScore(ctx, args[0], bounds[0], bounds[1], limit))
+}
+
+func zrank(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	rank, err := c.ZRank(ctx, args[0], args[1])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	return integer(int64(rank), err)
+}
+
+func geoAdd(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if len(args)%3 != 1 {
+		return "", errors.New("usage: " + commands["GEOADD"].usage)
+	}
+	locations := make([]kvsclient.GeoLocation, 0, len(args)/3)
+	for i := 1; i < len(args); i += 3 {
+		lon, err := strconv.ParseFloat(args[i], 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid longitude %q", args[i])
+		}
+		lat, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid latitude %q", args[i+1])
+		}
+		locations = append(locations, kvsclient.GeoLocation{Member: args[i+2], Lon: lon, Lat: lat})
+	}
+	n, err := c.GeoAdd(ctx, args[0], locations, 0)
+	return integer(int64(n), err)
+}
+
+// geoUnits are the distance units GEOSEARCH takes, in metres
+var geoUnits = map[string]float64{"m": 1, "km": 1000, "mi": 1609.34, "ft": 0.3048}
+
+func geoSearch(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	usage := errors.New("usage: " + commands["GEOSEARCH"].usage)
+	key := args[0]
+	var center [2]float64
+	for i := range center {
+		n, err := strconv.ParseFloat(args[1+i], 64)
+		if err != nil {
+			return "", fmt.Errorf("invalid coordinate %q", args[1+i])
+		}
+		center[i] = n
+	}
+	shape, args := strings.ToUpper(args[3]), args[4:]
+	sizes := 1
+	if shape == "BYBOX" {
+		sizes = 2
+	} else if shape != "BYRADIUS" {
+		return "", usage
+	}
+	if len(args) < sizes+1 {
+		return "", usage
+	}
+	unit, ok := geoUnits[strings.ToLower(args[sizes])]
+	if !ok {
+		return "", fmt.Errorf("invalid unit %q", args[sizes])
+	}
+	size := make([]float64, sizes)
+	for i := range size {
+		n, err := strconv.ParseFloat(args[i], 64)
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid size %q", args[i])
+		}
+		size[i] = n * unit
+	}
+	args = args[sizes+1:]
+	count := 0
+	if len(args) > 0 {
+		if len(args) != 2 || !strings.EqualFold(args[0], "COUNT") {
+			return "", usage
+		}
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil || count <= 0 {
+			return "", fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	var results []kvsclient.GeoResult
+	var err error
+	if sizes == 1 {
+		results, err = c.GeoSearchRadius(ctx, key, center[0], center[1], size[0], count)
+	} else {
+		results, err = c.GeoSearchBox(ctx, key, center[0], center[1], size[0], size[1], count)
+	}
+	if err != nil && !errors.Is(err, kvsclient.ErrNotFound) {
+		return "", err
+	}
+	lines := make([]string, len(results))
+	for i, r := range results {
+		lines[i] = fmt.Sprintf("%q %.4f %s %s", r.Member, r.Distance/unit, protocol.FormatScore(r.Lon), protocol.FormatScore(r.Lat))
+	}
+	return list(lines), nil
+}
+
+func jsonSet(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if !json.Valid([]byte(args[2])) {
+		return "", fmt.Errorf("invalid JSON %q", args[2])
+	}
+	if err := c.JSONSet(ctx, args[0], args[1], json.RawMessage(args[2]), 0); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func jsonGet(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	path := ""
+	if len(args) == 2 {
+		path = args[1]
+	}
+	value, err := c.JSONGetRaw(ctx, args[0], path)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	return value, err
+}
+
+func xadd(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	key, args := args[0], args[1:]
+	maxLen := 0
+	if strings.EqualFold(args[0], "MAXLEN") {
+		n, err := strconv.Atoi(args[1])
+		if err != nil || n <= 0 {
+			return "", fmt.Errorf("invalid length %q", args[1])
+		}
+		maxLen, args = n, args[2:]
+	}
+	if len(args) == 0 || len(args)%2 != 0 {
+		return "", errors.New("usage: " + commands["XADD"].usage)
+	}
+	fields := make(map[string]string, len(args)/2)
+	for i := 0; i < len(args); i += 2 {
+		fields[args[i]] = args[i+1]
+	}
+	return quoted(c.XAdd(ctx, key, fields, maxLen, 0))
+}
+
+func xread(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	after, count, err := streamArgs(args[1:], "XREAD")
+	if err != nil {
+		return "", err
+	}
+	return streamList(c.XRead(ctx, args[0], after, count))
+}
+
+func xgroup(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	start := ""
+	if len(args) == 3 {
+		start = args[2]
+	}
+	if err := c.XGroupCreate(ctx, args[0], args[1], start); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func xreadGroup(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	after, count, err := streamArgs(args[3:], "XREADGROUP")
+	if err != nil {
+		return "", err
+	}
+	return streamList(c.XReadGroup(ctx, args[0], args[1], args[2], after, count))
+}
+
+func xack(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.XAck(ctx, args[0], args[1], args[2:]...)
+	return integer(int64(n), err)
+}
+
+// streamArgs parses the [after] [COUNT n] that XREAD and XREADGROUP end with
+func streamArgs(args []string, name string) (after string, count int, err error) {
+	if len(args) == 1 || len(args) == 3 {
+		after, args = args[0], args[1:]
+	}
+	if len(args) == 0 {
+		return after, 0, nil
+	}
+	if len(args) != 2 || !strings.EqualFold(args[0], "COUNT") {
+		return "", 0, errors.New("usage: " + commands[name].usage)
+	}
+	if count, err = strconv.Atoi(args[1]); err != nil || count <= 0 {
+		return "", 0, fmt.Errorf("invalid count %q", args[1])
+	}
+	return after, count, nil
+}
+
+// streamList shows stream entries, one per line: the ID, then each field
+// and its value
+func streamList(entries []protocol.StreamEntry, err error) (string, error) {
+	if err != nil && !errors.Is(err, kvsclient.ErrNotFound) {
+		return "", err
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		parts := []string{e.ID}
+		for _, f := range e.Fields {
+			parts = append(parts, strconv.Quote(f))
+		}
+		lines[i] = strings.Join(parts, " ")
+	}
+	return list(lines), nil
+}
+
+// scoredList shows sorted set members with their scores, one per line
+func scoredList(members []kvsclient.ScoredMember, err error) (string, error) {
+	if err != nil && !errors.Is(err, kvsclient.ErrNotFound) {
+		return "", err
+	}
+	lines := make([]string, len(members))
+	for i, m := range members {
+		lines[i] = strconv.Quote(m.Member) + " " + protocol.FormatScore(m.Score)
+	}
+	return list(lines), nil
+}
+
+// quoted shows value quoted, or (nil) if it was not found
+func quoted(value string, err error) (string, error) {
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(value), nil
+}
+
+// quotedList shows items quoted, one per line
+func quotedList(items []string) string {
+	lines := make([]string, len(items))
+	for i, item := range items {
+		lines[i] = strconv.Quote(item)
+	}
+	return list(lines)
+}
+
+func incr(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return integer(c.Incr(ctx, args[0]))
+}
+
+func decr(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return integer(c.Decr(ctx, args[0]))
+}
+
+func incrBy(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	delta, err := strconv.ParseInt(args[1], 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid increment %q", args[1])
+	}
+	return integer(c.IncrBy(ctx, args[0], delta))
+}
+
+func nextID(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	count := 1
+	if len(args) > 1 {
+		var err error
+		if count, err = strconv.Atoi(args[1]); err != nil || count < 1 {
+			return "", fmt.Errorf("invalid count %q", args[1])
+		}
+	}
+	return integer(c.NextID(ctx, args[0], count))
+}
+
+func cas(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 3 {
+		if len(args) != 5 {
+			return "", errors.New("usage: " + commands["CAS"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[3], args[4]); err != nil {
+			return "", err
+		}
+	}
+	current, found, err := c.CompareAndSwap(ctx, args[0], args[1], args[2], ttl)
+	if errors.Is(err, kvsclient.ErrConflict) {
+		if !found {
+			return "", errors.New("conflict, key does not exist")
+		}
+		return "", fmt.Errorf("conflict, value is %q", current)
+	}
+	if err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func casRevision(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	expect, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || expect == 0 {
+		return "", fmt.Errorf("invalid revision %q", args[1])
+	}
+	var ttl time.Duration
+	if len(args) > 3 {
+		if len(args) != 5 {
+			return "", errors.New("usage: " + commands["CASREV"].usage)
+		}
+		if ttl, err = expiry(args[3], args[4]); err != nil {
+			return "", err
+		}
+	}
+	current, rev, err := c.CompareAndSwapRevision(ctx, args[0], expect, args[2], ttl)
+	if errors.Is(err, kvsclient.ErrConflict) {
+		if rev == 0 {
+			return "", errors.New("conflict, key does not exist")
+		}
+		return "", fmt.Errorf("conflict, value is %q at revision %d", current, rev)
+	}
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(integer) %d", rev), nil
+}
+
+func beginRead(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 0 {
+		if len(args) != 2 || !strings.EqualFold(args[0], "EX") {
+			return "", errors.New("usage: " + commands["BEGINREAD"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[0], args[1]); err != nil {
+			return "", err
+		}
+	}
+	tx, err := c.BeginRead(ctx, ttl)
+	if err != nil {
+		return "", err
+	}
+	return list([]string{strconv.Quote(tx.ID), fmt.Sprintf("(integer) %d", tx.Revision)}), nil
+}
+
+func txGet(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	keys := args[1:]
+	values, err := c.JoinRead(args[0]).MGet(ctx, keys...)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(keys))
+	for i, key := range keys {
+		lines[i] = "(nil)"
+		if value, ok := values[key]; ok {
+			lines[i] = strconv.Quote(value)
+		}
+	}
+	return list(lines), nil
+}
+
+func endRead(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if err := c.JoinRead(args[0]).End(ctx); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func getset(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	old, found, err := c.GetSet(ctx, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	if !found {
+		return "(nil)", nil
+	}
+	return strconv.Quote(old), nil
+}
+
+func getdel(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	value, err := c.GetDel(ctx, args[0])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strconv.Quote(value), nil
+}
+
+func keyType(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	t, err := c.Type(ctx, args[0])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "none", nil
+	}
+	return t, err
+}
+
+func object(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionObject, Key: args[0]})
+	if err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", errors.New(response.Message)
+	}
+	if !response.Found {
+		return "(nil)", nil
+	}
+	return strings.Join(response.Values, "\n"), nil
+}
+
+func memoryUsage(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionMemoryUsage, Key: args[0]})
+	if err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", errors.New(response.Message)
+	}
+	if !response.Found {
+		return "(nil)", nil
+	}
+	return strings.Join(response.Values, "\n"), nil
+}
+
+func touch(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 1 {
+		if len(args) != 3 {
+			return "", errors.New("usage: " + commands["TOUCH"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[1], args[2]); err != nil {
+			return "", err
+		}
+	}
+	err := c.Touch(ctx, args[0], ttl)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(integer) 0", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "(integer) 1", nil
+}
+
+func undelete(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	err := c.Undelete(ctx, args[0])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func setnx(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			return "", errors.New("usage: " + commands["SETNX"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[2], args[3]); err != nil {
+			return "", err
+		}
+	}
+	won, err := c.SetNX(ctx, args[0], args[1], ttl)
+	if err != nil {
+		return "", err
+	}
+	if won {
+		return integer(1, nil)
+	}
+	return integer(0, nil)
+}
+
+func appendValue(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	length, err := c.Append(ctx, args[0], args[1])
+	return integer(int64(length), err)
+}
+
+func strlen(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	length, err := c.Strlen(ctx, args[0])
+	return integer(int64(length), err)
+}
+
+func integer(n int64, err error) (string, error) {
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(integer) %d", n), nil
+}
+
+func setBit(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	offset, err := bitOffset(args[1])
+	if err != nil {
+		return "", err
+	}
+	if args[2] != "0" && args[2] != "1" {
+		return "", fmt.Errorf("invalid bit %q", args[2])
+	}
+	return bit(c.SetBit(ctx, args[0], offset, args[2] == "1"))
+}
+
+func getBit(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	offset, err := bitOffset(args[1])
+	if err != nil {
+		return "", err
+	}
+	return bit(c.GetBit(ctx, args[0], offset))
+}
+
+func bitCount(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.BitCount(ctx, args[0])
+	return integer(int64(n), err)
+}
+
+func bitOffset(arg string) (int, error) {
+	offset, err := strconv.Atoi(arg)
+	if err != nil || offset < 0 || offset > protocol.MaxBitOffset {
+		return 0, fmt.Errorf("invalid offset %q", arg)
+	}
+	return offset, nil
+}
+
+// bit shows a bit as an integer
+func bit(set bool, err error) (string, error) {
+	if set {
+		return integer(1, err)
+	}
+	return integer(0, err)
+}
+
+func del(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	deleted := 0
+	for _, key := range args {
+		err := c.Delete(ctx, key)
+		if errors.Is(err, kvsclient.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		deleted++
+	}
+	return fmt.Sprintf("(integer) %d", deleted), nil
+}
+
+func rename(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	err := c.Rename(ctx, args[0], args[1])
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func copyKey(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			return "", errors.New("usage: " + commands["COPY"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[2], args[3]); err != nil {
+			return "", err
+		}
+	}
+	err := c.Copy(ctx, args[0], args[1], ttl)
+	if errors.Is(err, kvsclient.ErrNotFound) {
+		return "(nil)", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func scan(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	count := 0
+	if len(args) > 1 {
+		if len(args) != 3 || !strings.EqualFold(args[1], "COUNT") {
+			return "", errors.New("usage: " + commands["SCAN"].usage)
+		}
+		var err error
+		if count, err = strconv.Atoi(args[2]); err != nil || count <= 0 {
+			return "", fmt.Errorf("invalid count %q", args[2])
+		}
+	}
+	var lines []string
+	for page := (kvsclient.Page{More: true}); page.More; {
+		var err error
+		if page, err = c.Scan(ctx, args[0], count, page.Continue); err != nil {
+			return "", err
+		}
+		for _, key := range page.Keys {
+			lines = append(lines, strconv.Quote(key))
+		}
+	}
+	return list(lines), nil
+}
+
+func keyRange(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	start, end, limit := args[0], "", 0
+	opts := args[1:]
+	if len(args)%2 == 0 {
+		end, opts = args[1], args[2:]
+	}
+	if len(opts) > 0 {
+		if len(opts) != 2 || !strings.EqualFold(opts[0], "LIMIT") {
+			return "", errors.New("usage: " + commands["RANGE"].usage)
+		}
+		var err error
+		if limit, err = strconv.Atoi(opts[1]); err != nil || limit <= 0 {
+			return "", fmt.Errorf("invalid limit %q", opts[1])
+		}
+	}
+	page, err := c.Range(ctx, start, end, limit, "")
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(page.Keys))
+	for i, key := range page.Keys {
+		lines[i] = strconv.Quote(key)
+	}
+	if page.More {
+		// the token is the key the next page starts at
+		return list(lines) + "\n(more from " + strconv.Quote(page.Continue) + ")", nil
+	}
+	return list(lines), nil
+}
+
+func search(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	query, opts := args[0], args[1:]
+	limit, offset := 0, 0
+	for len(opts) > 0 {
+		if len(opts) < 2 {
+			return "", errors.New("usage: " + commands["SEARCH"].usage)
+		}
+		n, err := strconv.Atoi(opts[1])
+		switch {
+		case strings.EqualFold(opts[0], "LIMIT") && err == nil && n > 0:
+			limit = n
+		case strings.EqualFold(opts[0], "OFFSET") && err == nil && n >= 0:
+			offset = n
+		default:
+			return "", errors.New("usage: " + commands["SEARCH"].usage)
+		}
+		opts = opts[2:]
+	}
+	hits, more, err := c.Search(ctx, query, offset, limit)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(hits))
+	for i, hit := range hits {
+		lines[i] = fmt.Sprintf("%q %.4f", hit.Key, hit.Score)
+	}
+	if more {
+		return list(lines) + fmt.Sprintf("\n(more from OFFSET %d)", offset+len(hits)), nil
+	}
+	return list(lines), nil
+}
+
+func listDir(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	dir := ""
+	if len(args) > 0 {
+		dir = args[0]
+	}
+	entries, err := c.List(ctx, dir, 0)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = strconv.Quote(e.Name)
+		switch {
+		case e.Dir && e.Keys == 1:
+			lines[i] += " (1 key)"
+		case e.Dir:
+			lines[i] += fmt.Sprintf(" (%d keys)", e.Keys)
+		}
+	}
+	return list(lines), nil
+}
+
+func dbsize(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	total, byNamespace, err := c.DBSize(ctx)
+	if err != nil {
+		return "", err
+	}
+	prefixes := make([]string, 0, len(byNamespace))
+	for prefix := range byNamespace {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+	out := fmt.Sprintf("(integer) %d", total)
+	for _, prefix := range prefixes {
+		out += fmt.Sprintf("\n%s: %d", strconv.Quote(prefix), byNamespace[prefix])
+	}
+	return out, nil
+}
+
+func pin(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if err := c.Pin(ctx, args[0]); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func unpin(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	if err := c.Unpin(ctx, args[0]); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func publish(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	n, err := c.Publish(ctx, args[0], args[1])
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(integer) %d", n), nil
+}
+
+func journal(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var after uint64
+	limit := 0
+	var err error
+	if len(args) > 0 {
+		if after, err = strconv.ParseUint(args[0], 10, 64); err != nil {
+			return "", fmt.Errorf("invalid revision %q", args[0])
+		}
+	}
+	if len(args) > 1 {
+		if limit, err = strconv.Atoi(args[1]); err != nil {
+			return "", fmt.Errorf("invalid limit %q", args[1])
+		}
+	}
+	entries, latest, err := c.Journal(ctx, after, limit)
+	if err != nil {
+		return "", err
+	}
+	lines := make([]string, len(entries))
+	for i, e := range entries {
+		lines[i] = fmt.Sprintf("%d %s %s %s %s by %s", e.Revision, e.Time.Format(time.RFC3339), e.Op, e.Key, strconv.Quote(e.Value), e.Identity)
+	}
+	return list(lines) + fmt.Sprintf("\n(latest revision %d)", latest), nil
+}
+
+func lock(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			return "", errors.New("usage: " + commands["LOCK"].usage)
+		}
+		var err error
+		if ttl, err = expiry(args[2], args[3]); err != nil {
+			return "", err
+		}
+	}
+	lease, err := c.AcquireLease(ctx, args[0], args[1], ttl)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(integer) %d", lease.Token), nil
+}
+
+func renew(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	lease, err := joinLease(c, args)
+	if err != nil {
+		return "", err
+	}
+	var ttl time.Duration
+	if len(args) > 2 {
+		if len(args) != 4 {
+			return "", errors.New("usage: " + commands["RENEW"].usage)
+		}
+		if ttl, err = expiry(args[2], args[3]); err != nil {
+			return "", err
+		}
+	}
+	if err := lease.Renew(ctx, ttl); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+func unlock(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	lease, err := joinLease(c, args)
+	if err != nil {
+		return "", err
+	}
+	if err := lease.Release(ctx); err != nil {
+		return "", err
+	}
+	return "OK", nil
+}
+
+// joinLease is the lease named by key and token in args
+func joinLease(c *kvsclient.Client, args []string) (*kvsclient.Lease, error) {
+	token, err := strconv.ParseUint(args[1], 10, 64)
+	if err != nil || token == 0 {
+		return nil, fmt.Errorf("invalid token %q", args[1])
+	}
+	return c.JoinLease(args[0], "", token), nil
+}
+
+func locks(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return values(ctx, c, protocol.Request{Action: protocol.ActionLocks, Value: "LIST"})
+}
+
+func cluster(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	return values(ctx, c, protocol.Request{Action: protocol.ActionCluster, Value: strings.ToUpper(args[0])})
+}
+
+func wait(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	replicas, err := strconv.Atoi(args[0])
+	if err != nil || replicas < 0 {
+		return "", fmt.Errorf("invalid replica count %q", args[0])
+	}
+	ms, err := strconv.Atoi(args[1])
+	if err != nil || ms < 0 {
+		return "", fmt.Errorf("invalid timeout %q", args[1])
+	}
+	n, err := c.Wait(ctx, replicas, time.Duration(ms)*time.Millisecond)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("(integer) %d", n), nil
+}
+
+func ping(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	request := protocol.Request{Action: protocol.ActionPing}
+	if len(args) > 0 {
+		request.Value = args[0]
+	}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", errors.New(response.Message)
+	}
+	return response.Value, nil
+}
+
+func hello(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	caps, err := c.ServerCapabilities(ctx)
+	if err != nil {
+		return "", err
+	}
+	return list(caps), nil
+}
+
+func describe(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	specs, err := c.Commands(ctx)
+	if err != nil {
+		return "", err
+	}
+	var lines []string
+	for _, spec := range specs {
+		if len(args) > 0 && !strings.EqualFold(args[0], spec.Action) {
+			continue
+		}
+		line := spec.Action + ": " + spec.Summary
+		for _, arg := range spec.Args {
+			required := ""
+			if arg.Required {
+				required = ", required"
+			}
+			line += fmt.Sprintf("\n     %s%s: %s", arg.Field, required, arg.Summary)
+		}
+		if len(spec.Messages) > 0 {
+			line += "\n     replies: " + strings.Join(spec.Messages, " ")
+		}
+		lines = append(lines, line)
+	}
+	return list(lines), nil
+}
+
+func help(ctx context.Context, c *kvsclient.Client, args []string) (string, error) {
+	names := completeCommand("")
+	if len(args) > 0 {
+		cmd, ok := commands[strings.ToUpper(args[0])]
+		if !ok {
+			return "", fmt.Errorf("unknown command %q", args[0])
+		}
+		return cmd.usage + "\n  " + cmd.help, nil
+	}
+	var b strings.Builder
+	for _, name := range names {
+		cmd := commands[name]
+		fmt.Fprintf(&b, "%-46s %s\n", cmd.usage, cmd.help)
+	}
+	return strings.TrimSuffix(b.String(), "\n"), nil
+}
+
+// values sends request and lists the Values of the reply
+func values(ctx context.Context, c *kvsclient.Client, request protocol.Request) (string, error) {
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", errors.New(response.Message)
+	}
+	return list(response.Values), nil
+}
+
+// list numbers lines the way redis-cli prints arrays
+func list(lines []string) string {
+	if len(lines) == 0 {
+		return "(empty list)"
+	}
+	var b strings.Builder
+	for i, line := range lines {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		fmt.Fprintf(&b, "%d) %s", i+1, line)
+	}
+	return b.String()
+}
+
+// splitArgs splits a command line into words; single or double quotes keep
+// spaces in a word, and inside double quotes \" and \\ are escapes
+func splitArgs(line string) ([]string, error) {
+	var args []string
+	var word strings.Builder
+	inWord := false
+	var quote rune
+	escaped := false
+	for _, r := range line {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case quote == '"' && r == '\\':
+			escaped = true
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote != 0:
+			word.WriteRune(r)
+		case r == '"' || r == '\'':
+			quote = r
+			inWord = true
+		case r == ' ' || r == '\t':
+			if inWord {
+				args = append(args, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, errors.New("unbalanced quotes")
+	}
+	if inWord {
+		args = append(args, word.String())
+	}
+	return args, nil
+}
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// lineEditor reads lines from a terminal in raw mode with cursor movement,
+// history on the arrow keys and tab completion of the first word
+type lineEditor struct {
+	in       *bufio.Reader
+	fd       int
+	out      io.Writer
+	history  []string
+	complete func(prefix string) []string
+}
+
+func newLineEditor(in *os.File, out io.Writer, complete func(prefix string) []string) *lineEditor {
+	return &lineEditor{in: bufio.NewReader(in), fd: int(in.Fd()), out: out, complete: complete}
+}
+
+// readLine shows prompt and returns the line typed, without the newline.
+// Ctrl-D, or Ctrl-C, on an empty line returns io.EOF.
+func (e *lineEditor) readLine(prompt string) (string, error) {
+	state, err := makeRaw(e.fd)
+	if err != nil {
+		return "", err
+	}
+	defer restore(e.fd, state)
+
+	var line []rune
+	pos := 0
+	hist := len(e.history) // history entry shown, len means the new line
+	var pending []rune     // the new line, kept while browsing history
+	redraw := func() {
+		fmt.Fprintf(e.out, "\r%s%s\x1b[K", prompt, string(line))
+		if back := len(line) - pos; back > 0 {
+			fmt.Fprintf(e.out, "\x1b[%dD", back)
+		}
+	}
+	show := func(i int) {
+		if hist == len(e.history) {
+			pending = line
+		}
+		hist = i
+		if i == len(e.history) {
+			line = pending
+		} else {
+			line = []rune(e.history[i])
+		}
+		pos = len(line)
+		redraw()
+	}
+	fmt.Fprint(e.out, prompt)
+
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return "", err
+		}
+		switch r {
+		case '\r', '\n':
+			fmt.Fprint(e.out, "\r\n")
+			return string(line), nil
+		case 3: // Ctrl-C
+			fmt.Fprint(e.out, "^C\r\n")
+			if len(line) == 0 {
+				return "", io.EOF
+			}
+			line, pos = nil, 0
+			fmt.Fprint(e.out, prompt)
+		case 4: // Ctrl-D
+			if len(line) == 0 {
+				fmt.Fprint(e.out, "\r\n")
+				return "", io.EOF
+			}
+		case 1: // Ctrl-A
+			pos = 0
+			redraw()
+		case 5: // Ctrl-E
+			pos = len(line)
+			redraw()
+		case 21: // Ctrl-U
+			line, pos = line[pos:], 0
+			redraw()
+		case 127, 8: // Backspace
+			if pos > 0 {
+				line = append(line[:pos-1], line[pos:]...)
+				pos--
+				redraw()
+			}
+		case '\t':
+			line, pos = e.completeLine(line, pos)
+			redraw()
+		case 27: // escape sequence: arrows, Home, End, Delete
+			seq := e.escape()
+			switch seq {
+			case "[A":
+				if hist > 0 {
+					show(hist - 1)
+				}
+			case "[B":
+				if hist < len(e.history) {
+					show(hist + 1)
+				}
+			case "[C":
+				if pos < len(line) {
+					pos++
+					redraw()
+				}
+			case "[D":
+				if pos > 0 {
+					pos--
+					redraw()
+				}
+			case "[H", "OH", "[1~":
+				pos = 0
+				redraw()
+			case "[F", "OF", "[4~":
+				pos = len(line)
+				redraw()
+			case "[3~":
+				if pos < len(line) {
+					line = append(line[:pos], line[pos+1:]...)
+					redraw()
+				}
+			}
+		default:
+			if r < ' ' {
+				continue
+			}
+			line = append(line[:pos], append([]rune{r}, line[pos:]...)...)
+			pos++
+			redraw()
+		}
+	}
+}
+
+// escape reads the rest of an escape sequence after ESC
+func (e *lineEditor) escape() string {
+	var seq strings.Builder
+	for {
+		r, _, err := e.in.ReadRune()
+		if err != nil {
+			return seq.String()
+		}
+		seq.WriteRune(r)
+		// a sequence ends with a letter or ~, after the [ or O that opens it
+		if seq.Len() > 1 && (r == '~' || (r >= 'A' && r <= 'Z') || (r >= 'a' && r <= 'z')) {
+			return seq.String()
+		}
+		if seq.Len() == 1 && r != '[' && r != 'O' {
+			return seq.String()
+		}
+	}
+}
+
+// completeLine completes the first word of line when the cursor is in it:
+// a single candidate is filled in, several are extended to their common
+// prefix or listed
+func (e *lineEditor) completeLine(line []rune, pos int) ([]rune, int) {
+	prefix := string(line[:pos])
+	if e.complete == nil || strings.ContainsAny(prefix, " \t") {
+		return line, pos
+	}
+	candidates := e.complete(prefix)
+	switch len(candidates) {
+	case 0:
+		return line, pos
+	case 1:
+		word := []rune(candidates[0] + " ")
+		return append(word, line[pos:]...), len(word)
+	}
+	common := candidates[0]
+	for _, c := range candidates[1:] {
+		for !strings.HasPrefix(c, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	if len(common) > len(prefix) {
+		word := []rune(common)
+		return append(word, line[pos:]...), len(word)
+	}
+	fmt.Fprintf(e.out, "\r\n%s\r\n", strings.Join(candidates, "  "))
+	return line, pos
+}
+
+// addHistory appends line to the history, skipping a repeat of the last one
+func (e *lineEditor) addHistory(line string) {
+	if n := len(e.history); n > 0 && e.history[n-1] == line {
+		return
+	}
+	e.history = append(e.history, line)
+	if len(e.history) > maxHistory {
+		e.history = e.history[len(e.history)-maxHistory:]
+	}
+}
+
+// loadHistory reads the history saved by earlier sessions
+func (e *lineEditor) loadHistory(path string) {
+	if path == "" {
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if line != "" {
+			e.addHistory(line)
+		}
+	}
+}
+
+// appendHistory saves line for later sessions
+func (e *lineEditor) appendHistory(path, line string) {
+	if path == "" {
+		return
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+	fmt.Fprintln(file, line)
+}
@@ -0,0 +1,121 @@
+// kvs-cli is an interactive command-line client for kvs-server, in the
+// spirit of redis-cli:
+//
+//	kvs-cli -addr localhost:8081
+//	localhost:8081> SET greeting "hello world" EX 30
+//	OK
+//
+// Given a command as arguments it runs just that one and exits, e.g.
+// kvs-cli GET greeting.
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// maxHistory is how many lines the history file keeps
+const maxHistory = 1000
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "server address")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each command")
+	codecName := flag.String("codec", protocol.Gob.Name(), "wire encoding: gob, or json for a json:// listener")
+	flag.Parse()
+	codec, err := protocol.CodecByName(*codecName)
+	if err != nil {
+		fmt.Println("Error in -codec:", err)
+		os.Exit(2)
+	}
+	client := kvsclient.NewClient(*addr, kvsclient.WithTimeout(*timeout), kvsclient.WithCodec(codec))
+	defer client.Close()
+	ctx := context.Background()
+
+	if flag.NArg() > 0 {
+		out, err := execute(ctx, client, flag.Args())
+		if err != nil {
+			fmt.Println("(error)", err)
+			os.Exit(1)
+		}
+		fmt.Println(out)
+		return
+	}
+
+	if !isTerminal(int(os.Stdin.Fd())) {
+		// piped input: one command per line, no prompt
+		scanner := bufio.NewScanner(os.Stdin)
+		for scanner.Scan() {
+			if !runLine(ctx, client, scanner.Text()) {
+				return
+			}
+		}
+		return
+	}
+
+	editor := newLineEditor(os.Stdin, os.Stdout, completeCommand)
+	historyFile := historyPath()
+	editor.loadHistory(historyFile)
+	prompt := *addr + "> "
+	for {
+		line, err := editor.readLine(prompt)
+		if errors.Is(err, io.EOF) {
+			return
+		}
+		if err != nil {
+			fmt.Println("Error reading input:", err)
+			return
+		}
+		if strings.TrimSpace(line) != "" {
+			editor.addHistory(line)
+			editor.appendHistory(historyFile, line)
+		}
+		if !runLine(ctx, client, line) {
+			return
+		}
+	}
+}
+
+// runLine runs one command line and prints its result; it returns false
+// when the user asked to quit
+func runLine(ctx context.Context, client *kvsclient.Client, line string) bool {
+	args, err := splitArgs(line)
+	if err != nil {
+		fmt.Println("(error)", err)
+		return true
+	}
+	if len(args) == 0 {
+		return true
+	}
+	switch strings.ToUpper(args[0]) {
+	case "QUIT", "EXIT":
+		return false
+	}
+	out, err := execute(ctx, client, args)
+	if err != nil {
+		fmt.Println("(error)", err)
+		return true
+	}
+	fmt.Println(out)
+	return true
+}
+
+// historyPath is where the history is kept across sessions, empty if there
+// is no home directory
+func historyPath() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(home, ".kvs_cli_history")
+}
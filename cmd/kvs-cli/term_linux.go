@@ -0,0 +1,55 @@
+//go:build linux
+
+package main
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+type termState syscall.Termios
+
+func getTermios(fd int) (*syscall.Termios, error) {
+	var t syscall.Termios
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&t))); errno != 0 {
+		return nil, errno
+	}
+	return &t, nil
+}
+
+func setTermios(fd int, t *syscall.Termios) error {
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCSETS, uintptr(unsafe.Pointer(t))); errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// isTerminal reports whether fd is a terminal
+func isTerminal(fd int) bool {
+	_, err := getTermios(fd)
+	return err == nil
+}
+
+// makeRaw turns off line buffering, echo and signal keys on fd so the line
+// editor sees every key, and returns the state to restore
+func makeRaw(fd int) (*termState, error) {
+	t, err := getTermios(fd)
+	if err != nil {
+		return nil, err
+	}
+	old := termState(*t)
+	t.Iflag &^= syscall.ICRNL | syscall.IXON | syscall.INLCR | syscall.IGNCR
+	t.Lflag &^= syscall.ECHO | syscall.ICANON | syscall.ISIG | syscall.IEXTEN
+	t.Cc[syscall.VMIN] = 1
+	t.Cc[syscall.VTIME] = 0
+	if err := setTermios(fd, t); err != nil {
+		return nil, err
+	}
+	return &old, nil
+}
+
+// restore puts fd back the way makeRaw found it
+func restore(fd int, state *termState) error {
+	t := syscall.Termios(*state)
+	return setTermios(fd, &t)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+type termState struct{}
+
+// isTerminal is false where raw mode is not implemented, so the CLI reads
+// plain lines instead of using the line editor
+func isTerminal(fd int) bool { return false }
+
+func makeRaw(fd int) (*termState, error) {
+	return nil, errors.New("raw terminal mode is not supported on this system")
+}
+
+func restore(fd int, state *termState) error { return nil }
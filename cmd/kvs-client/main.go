@@ -0,0 +1,45 @@
+// kvs-client is a small example client for kvs-server.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "server address")
+	flag.Parse()
+	client := kvsclient.NewClient(*addr)
+	defer client.Close()
+	ctx := context.Background()
+
+	if flag.Arg(0) == "diagnose" {
+		path, err := client.Diagnose(ctx, ".")
+		if err != nil {
+			fmt.Println("Error collecting diagnostics:", err)
+			return
+		}
+		fmt.Println("Diagnostics saved to", path)
+		return
+	}
+
+	// Example usage
+	//client.Set(ctx, "name", "John")
+	//client.Set(ctx, "name8", "paytm")
+	//client.SetWithTTL(ctx, "name7", "facebook", time.Minute)
+	for _, key := range []string{"name", "name8"} {
+		value, err := client.Get(ctx, key)
+		switch {
+		case err == nil:
+			fmt.Println("Name:", value)
+		case errors.Is(err, kvsclient.ErrNotFound):
+			fmt.Println("Name not found")
+		default:
+			fmt.Println("Error talking to server:", err)
+		}
+	}
+}
@@ -0,0 +1,211 @@
+// kvs-fuzz throws random and malformed requests at a kvs-server and checks
+// that it keeps answering:
+//
+//	kvs-fuzz [-addr localhost:8081] [-codec gob|json] [-duration 30s] [-seed n]
+//
+// It mixes well-formed requests of every action the server lists, and of
+// unknown ones, with random field values, and raw frames that are garbage,
+// damaged encodings of real requests or longer than any server accepts.
+// After each it checks that the server still answers PING, and it prints
+// how the server answered each kind. It exits with status 1 as soon as
+// the server stops answering. Admin actions are left out unless -admin is
+// set; point it at a throwaway server all the same, since it writes keys.
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"flag"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// values random fields are picked from, edge cases over realistic ones
+var (
+	fuzzStrings = []string{"", "k", "fuzz/a", "fuzz/a/b", "*", "[", "\x00", "\xff\xfe", "-1", "0", "1",
+		"99999999999999999999", "1.5", "NaN", "on", "off", "{", "{\"a\":1}", "$.a", "$[", "x y", "EX", "10s", "-5s"}
+	fuzzInts      = []int{0, 1, -1, 2, -2, 3, 100, 1 << 30, -(1 << 30), 1 << 62, -(1 << 62)}
+	fuzzDurations = []time.Duration{0, 1, -1, time.Millisecond, time.Second, -time.Hour, 1 << 62, -(1 << 62)}
+)
+
+func pick[T any](rng *rand.Rand, xs []T) T { return xs[rng.Intn(len(xs))] }
+
+func main() {
+	addr := flag.String("addr", "localhost:8081", "server address")
+	codecName := flag.String("codec", protocol.Gob.Name(), "wire encoding: gob, or json for a json:// listener")
+	duration := flag.Duration("duration", 30*time.Second, "how long to run")
+	seed := flag.Int64("seed", 0, "random seed, 0 for one from the clock; printed so a run can be repeated")
+	admin := flag.Bool("admin", false, "also send admin actions, which may restore, freeze or reconfigure the server")
+	flag.Parse()
+
+	codec, err := protocol.CodecByName(*codecName)
+	if err != nil {
+		fmt.Println("Error in -codec:", err)
+		return
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	fmt.Println("seed", *seed)
+	rng := rand.New(rand.NewSource(*seed))
+	client := kvsclient.NewClient(*addr, kvsclient.WithCodec(codec), kvsclient.WithTimeout(time.Second), kvsclient.WithoutProbe())
+	defer client.Close()
+	ctx := context.Background()
+	specs, err := client.Commands(ctx)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "kvs-fuzz: listing the server's actions:", err)
+		client.Close()
+		os.Exit(1)
+	}
+	actions := []string{"NO_SUCH_ACTION", "", "get", "\x00"}
+	for _, spec := range specs {
+		if !spec.Admin || *admin {
+			actions = append(actions, spec.Action)
+		}
+	}
+
+	outcomes := make(map[string]int)
+	deadline := time.Now().Add(*duration)
+	for n := 0; time.Now().Before(deadline); n++ {
+		var kind, outcome string
+		if rng.Intn(4) == 0 {
+			kind, outcome = rawFrame(rng, *addr, codec)
+		} else {
+			kind, outcome = "request", request(ctx, rng, client, actions)
+		}
+		outcomes[kind+": "+outcome]++
+		if _, err := client.Do(ctx, protocol.Request{Action: protocol.ActionPing}); err != nil {
+			fmt.Fprintf(os.Stderr, "kvs-fuzz: the server stopped answering after %d requests, the last %s (%s): %v\n", n+1, kind, outcome, err)
+			report(outcomes)
+			client.Close()
+			os.Exit(1)
+		}
+	}
+	report(outcomes)
+}
+
+// request sends a well-formed request with random fields and returns how
+// the server answered it
+func request(ctx context.Context, rng *rand.Rand, client *kvsclient.Client, actions []string) string {
+	strs := func() []string {
+		if rng.Intn(8) == 0 {
+			return nil
+		}
+		s := make([]string, rng.Intn(5))
+		for i := range s {
+			s[i] = pick(rng, fuzzStrings)
+		}
+		return s
+	}
+	r := protocol.Request{
+		Action:   pick(rng, actions),
+		Key:      pick(rng, fuzzStrings),
+		Value:    pick(rng, fuzzStrings),
+		Owner:    pick(rng, fuzzStrings),
+		Timeout:  max(pick(rng, fuzzDurations), 0) % (50 * time.Millisecond), // don't sit on blocking actions
+		TTL:      pick(rng, fuzzDurations),
+		Limit:    pick(rng, fuzzInts),
+		Checksum: uint32(rng.Intn(3)),
+		TTLMode:  pick(rng, []string{"", protocol.TTLKeep, protocol.TTLReset, "bogus"}),
+		Budget:   time.Duration(rng.Intn(2)) * 50 * time.Millisecond,
+		Continue: pick(rng, fuzzStrings),
+		Keys:     strs(),
+		Values:   strs(),
+		Expect:   pick(rng, fuzzStrings),
+		Revision: uint64(rng.Intn(5)),
+		ReadTx:   pick(rng, fuzzStrings),
+		Start:    pick(rng, fuzzInts),
+		Stop:     pick(rng, fuzzInts),
+		Group:    pick(rng, fuzzStrings),
+		Path:     pick(rng, fuzzStrings),
+	}
+	if rng.Intn(3) == 0 {
+		r.Checksums = make([]uint32, rng.Intn(5))
+	}
+	if rng.Intn(3) == 0 {
+		r.Deletes = make([]bool, rng.Intn(5))
+	}
+	response, err := client.Do(ctx, r)
+	switch {
+	case err != nil:
+		return "error"
+	case response.Success:
+		return "ok"
+	}
+	return response.Message
+}
+
+// rawFrame sends a malformed frame on a connection of its own and returns
+// what kind it was and how the server answered
+func rawFrame(rng *rand.Rand, addr string, codec protocol.Codec) (kind, outcome string) {
+	var valid bytes.Buffer
+	codec.NewEncoder(&valid).Encode(protocol.Request{Action: protocol.ActionGet, Key: "fuzz/a"})
+	var frame []byte
+	switch rng.Intn(4) {
+	case 0:
+		kind = "garbage"
+		frame = make([]byte, 1+rng.Intn(64))
+		rng.Read(frame)
+	case 1:
+		kind = "damaged"
+		frame = bytes.Clone(valid.Bytes())
+		for i := 0; i <= rng.Intn(3); i++ {
+			frame[rng.Intn(len(frame))] ^= byte(1 + rng.Intn(255))
+		}
+	case 2:
+		kind = "truncated"
+		frame = valid.Bytes()[:rng.Intn(valid.Len())]
+	default:
+		kind = "oversized"
+		if codec.Name() == protocol.Gob.Name() {
+			// a gob length prefix of 8 bytes claiming an exabyte
+			frame = append([]byte{0xf8}, binary.BigEndian.AppendUint64(nil, 1<<60)...)
+		} else {
+			frame = []byte(`{"Action":"SET","Key":"fuzz/a","Value":"` + string(bytes.Repeat([]byte{'x'}, 1<<20)))
+		}
+	}
+	conn, err := net.DialTimeout("tcp", addr, time.Second)
+	if err != nil {
+		return kind, "dial failed"
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(500 * time.Millisecond))
+	if _, err := conn.Write(frame); err != nil {
+		return kind, "closed while writing"
+	}
+	if kind == "truncated" {
+		conn.(*net.TCPConn).CloseWrite()
+	}
+	var response protocol.Response
+	if err := codec.NewDecoder(conn).Decode(&response); err != nil {
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			// a frame that could still be completed is waited for
+			return kind, "waiting for more"
+		}
+		return kind, "closed"
+	}
+	if response.Success {
+		return kind, "ok"
+	}
+	return kind, response.Message
+}
+
+// report prints how many times each kind of input got each answer
+func report(outcomes map[string]int) {
+	lines := make([]string, 0, len(outcomes))
+	for line := range outcomes {
+		lines = append(lines, line)
+	}
+	sort.Strings(lines)
+	for _, line := range lines {
+		fmt.Printf("%8d  %s\n", outcomes[line], line)
+	}
+}
@@ -0,0 +1,329 @@
+// kvs-proxy fronts several independent kvs-servers as one, spreading the
+// keys over them by consistent hashing as kvsclient.ShardedClient does:
+//
+//	kvs-proxy -shards host1:8081,host2:8081,host3:8081 [-addr :8090,json://:8091]
+//	kvs-proxy -shards ... -down-policy reroute -down-for 5s
+//
+// Clients talk to it as to one server. A request for one key goes to the
+// server that owns it; MGET and MSET are split by owner and their Results
+// merged, SCAN walks the servers one after another and DBSIZE adds them
+// up. A request for keys on different servers, such as a BATCH, a RENAME
+// to a key another server owns or an SUNION, is answered with CROSSSLOT,
+// and one no single server can answer, such as MULTI, SUBSCRIBE or ADMIN,
+// with INVALID_ACTION; HELLO leaves their capabilities out.
+//
+// A server that fails to answer fails the requests for its keys with
+// SERVER_ERROR, which clients retry, and only those: the other servers'
+// keys are served on. With -down-policy reroute its keys go to the next
+// server on the ring until -down-for has passed, and writes made
+// meanwhile are not seen once it is back.
+//
+// Where keys live follows from the addresses in -shards, and SCAN's
+// Continue tokens from their order, so every proxy in front of the same
+// servers is given the same list.
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// unrouted are the capabilities of the servers whose actions span every
+// key or hold state on one connection, which the proxy can't pass on
+var unrouted = map[string]bool{
+	protocol.CapPubSub:    true,
+	protocol.CapJournal:   true,
+	protocol.CapDiagnose:  true,
+	protocol.CapCluster:   true,
+	protocol.CapAdmin:     true,
+	protocol.CapList:      true,
+	protocol.CapRange:     true,
+	protocol.CapReadTx:    true,
+	protocol.CapMulti:     true,
+	protocol.CapWatch:     true,
+	protocol.CapScripting: true,
+	protocol.CapSearch:    true,
+	protocol.CapTracking:  true,
+	protocol.CapReplicas:  true,
+	protocol.CapFailover:  true,
+}
+
+// shardRetry is the backoff clients are told to wait before retrying a
+// request whose server failed, as a server tells them for SERVER_ERROR
+const shardRetry = 100 * time.Millisecond
+
+// proxy routes requests to the servers of sc
+type proxy struct {
+	sc    *kvsclient.ShardedClient
+	keyed map[string]bool // actions routed by Key, from the servers' COMMANDS
+}
+
+func main() {
+	addrs := flag.String("addr", ":8090", "comma-separated addresses to listen on; json://ADDR speaks JSON instead of gob")
+	shards := flag.String("shards", "", "comma-separated addresses of the servers to spread keys over, the same list in the same order for every proxy")
+	downPolicy := flag.String("down-policy", "fail", "what requests for the keys of a server that is down do: fail, or reroute to the next server on the ring")
+	downFor := flag.Duration("down-for", kvsclient.DefaultNodeDownFor, "how long a server that failed to answer is considered down")
+	vnodes := flag.Int("vnodes", kvsclient.DefaultVirtualNodes, "points each server gets on the hash ring")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for each request to a server")
+	token := flag.String("token", "", "token the proxy authenticates to the servers with")
+	logLevel := flag.String("log-level", kvstore.LogInfo.String(), "least severe log messages printed: debug, info, warn or error")
+	flag.Parse()
+
+	level, err := kvstore.ParseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Println("Error in -log-level:", err)
+		return
+	}
+	kvstore.SetLogLevel(level)
+	if *shards == "" {
+		fmt.Println("Error in -shards:", errors.New("no servers given"))
+		return
+	}
+	var policy kvsclient.DownPolicy
+	switch *downPolicy {
+	case "fail":
+		policy = kvsclient.FailFast
+	case "reroute":
+		policy = kvsclient.Reroute
+	default:
+		fmt.Println("Error in -down-policy:", fmt.Errorf("unknown policy %q, expected fail or reroute", *downPolicy))
+		return
+	}
+	nodeOpts := []kvsclient.Option{kvsclient.WithTimeout(*timeout)}
+	if *token != "" {
+		nodeOpts = append(nodeOpts, kvsclient.WithToken(*token))
+	}
+	sc := kvsclient.NewShardedClient(strings.Split(*shards, ","), kvsclient.WithVirtualNodes(*vnodes),
+		kvsclient.WithDownPolicy(policy, *downFor), kvsclient.WithNodeOptions(nodeOpts...))
+	defer sc.Close()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	p := &proxy{sc: sc}
+	if err := p.loadCommands(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, "kvs-proxy: listing the servers' actions:", err)
+		sc.Close()
+		os.Exit(1)
+	}
+
+	var wg sync.WaitGroup
+	for _, addr := range strings.Split(*addrs, ",") {
+		codec, addr, err := protocol.SplitAddr(addr)
+		if err != nil {
+			fmt.Println("Error in -addr:", err)
+			return
+		}
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "kvs-proxy:", err)
+			sc.Close()
+			os.Exit(1)
+		}
+		kvstore.Logf(kvstore.LogInfo, "Listening on %s (%s), routing to %s", ln.Addr(), codec.Name(), *shards)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.serve(ctx, ln, codec)
+		}()
+		go func() {
+			<-ctx.Done()
+			ln.Close()
+		}()
+	}
+	wg.Wait()
+}
+
+// loadCommands learns which actions are routed by Key from the servers,
+// so custom commands are routed too
+func (p *proxy) loadCommands(ctx context.Context) error {
+	response, err := p.sc.Do(ctx, protocol.Request{Action: protocol.ActionCommands})
+	if err != nil {
+		return err
+	}
+	if !response.Success {
+		return errors.New(response.Message)
+	}
+	p.keyed = make(map[string]bool)
+	for _, spec := range response.Commands {
+		if spec.Keyed && !spec.Admin {
+			p.keyed[spec.Action] = true
+		}
+	}
+	return nil
+}
+
+// serve accepts connections on ln until ctx is done
+func (p *proxy) serve(ctx context.Context, ln net.Listener, codec protocol.Codec) {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			kvstore.RecordError("Error accepting connection:", err)
+			continue
+		}
+		go p.handleConnection(ctx, conn, codec)
+	}
+}
+
+// handleConnection answers the requests on conn, one at a time, until the
+// client hangs up
+func (p *proxy) handleConnection(ctx context.Context, conn net.Conn, codec protocol.Codec) {
+	defer conn.Close()
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+	decoder, encoder := codec.NewDecoder(conn), codec.NewEncoder(conn)
+	for {
+		var request protocol.Request
+		if err := decoder.Decode(&request); err != nil {
+			if !errors.Is(err, io.EOF) && !errors.Is(err, net.ErrClosed) {
+				kvstore.RecordError("Error decoding request:", err)
+			}
+			return
+		}
+		if err := encoder.Encode(p.route(ctx, request)); err != nil {
+			if ctx.Err() == nil {
+				kvstore.RecordError("Error encoding response:", err)
+			}
+			return
+		}
+	}
+}
+
+// route sends request to the servers it concerns and returns the response
+// to pass back
+func (p *proxy) route(ctx context.Context, request protocol.Request) protocol.Response {
+	if request.Budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, request.Budget)
+		defer cancel()
+	}
+	switch request.Action {
+	case protocol.ActionPing:
+		return protocol.Response{Success: true, Value: cmp.Or(request.Value, "PONG")}
+	case protocol.ActionHello:
+		response, err := p.sc.Do(ctx, request)
+		if err != nil {
+			return shardDown(p.sc.Addr(request.Key), err)
+		}
+		caps := response.Values[:0:0]
+		for _, c := range response.Values {
+			if !unrouted[c] {
+				caps = append(caps, c)
+			}
+		}
+		response.Values = caps
+		return response
+	case protocol.ActionCommands:
+		response, err := p.sc.Do(ctx, request)
+		if err != nil {
+			return shardDown(p.sc.Addr(request.Key), err)
+		}
+		return response
+	case protocol.ActionScan:
+		page, err := p.sc.Scan(ctx, request.Key, request.Limit, cmp.Or(request.Continue, request.Value))
+		if err != nil {
+			return failed(err)
+		}
+		response := protocol.Response{Success: true, Values: page.Keys, More: page.More, Continue: page.Continue, Value: page.Continue}
+		if !page.More {
+			response.Value = "0"
+		}
+		return response
+	case protocol.ActionDBSize:
+		total, byNamespace, err := p.sc.DBSize(ctx)
+		if err != nil {
+			return failed(err)
+		}
+		response := protocol.Response{Success: true, Value: strconv.Itoa(total)}
+		for prefix, n := range byNamespace {
+			response.Values = append(response.Values, fmt.Sprintf("%s: %d", prefix, n))
+		}
+		sort.Strings(response.Values)
+		return response
+	case protocol.ActionMGet, protocol.ActionMSet:
+		if request.ReadTx != "" {
+			return protocol.Response{Message: protocol.MsgNoReadTx}
+		}
+		if len(request.Keys) > protocol.MaxBatchKeys || request.Action == protocol.ActionMSet && len(request.Values) != len(request.Keys) {
+			return protocol.Response{Message: protocol.MsgInvalidArgument}
+		}
+		response, err := p.sc.DoKeys(ctx, request)
+		if err != nil {
+			return failed(err)
+		}
+		return response
+	case protocol.ActionBatch, protocol.ActionSUnion, protocol.ActionSInter, protocol.ActionSDiff:
+		if len(request.Keys) == 0 {
+			return protocol.Response{Message: protocol.MsgInvalidArgument}
+		}
+		return p.forward(ctx, request.Keys[0], request, request.Keys[1:]...)
+	case protocol.ActionRename, protocol.ActionCopy:
+		return p.forward(ctx, request.Key, request, request.Value)
+	}
+	if !p.keyed[request.Action] {
+		return protocol.Response{Message: protocol.MsgInvalidAction}
+	}
+	return p.forward(ctx, request.Key, request)
+}
+
+// forward sends request to the server that owns key, or answers CROSSSLOT
+// if another server owns any of others
+func (p *proxy) forward(ctx context.Context, key string, request protocol.Request, others ...string) protocol.Response {
+	owner := p.sc.Addr(key)
+	for _, other := range others {
+		if p.sc.Addr(other) != owner {
+			return protocol.Response{Message: protocol.MsgCrossSlot}
+		}
+	}
+	response, err := p.sc.DoKey(ctx, key, request)
+	if err != nil {
+		return shardDown(owner, err)
+	}
+	return response
+}
+
+// failed is the response to a request spanning the servers that failed
+// with err
+func failed(err error) protocol.Response {
+	var kvsErr *kvsclient.KVSError
+	switch {
+	case errors.Is(err, kvsclient.ErrBadCursor):
+		return protocol.Response{Message: protocol.MsgInvalidArgument}
+	case errors.As(err, &kvsErr):
+		// a server answered, and its answer holds for the whole request
+		return protocol.Response{Message: kvsErr.Code, Error: &protocol.ErrorInfo{
+			Code: kvsErr.Code, Retryable: kvsErr.Retryable, Backoff: kvsErr.Backoff}}
+	}
+	return shardDown("", err)
+}
+
+// shardDown is the response to a request the server at addr failed to
+// answer with err; clients retry it
+func shardDown(addr string, err error) protocol.Response {
+	kvstore.RecordError("Error from shard:", err)
+	value := err.Error()
+	if addr != "" {
+		value = addr + ": " + value
+	}
+	return protocol.Response{Message: protocol.MsgServerError, Value: value, Error: &protocol.ErrorInfo{
+		Code: protocol.MsgServerError, Retryable: true, Backoff: shardRetry}}
+}
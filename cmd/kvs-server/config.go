@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// loadConfig sets the flags of fs that were not given on the command line
+// from the TOML file at path. Every key is a flag name, with "_" allowed for
+// "-"; keys under a [table] get its name as a prefix, so log-level, or
+// level under [log], is -log-level. Arrays are joined with commas. Only the
+// TOML a flag can hold is understood: strings, numbers, booleans and
+// one-line arrays of them.
+func loadConfig(fs *flag.FlagSet, path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	seen := make(map[string]bool)
+	table := ""
+	scanner := bufio.NewScanner(file)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(stripComment(scanner.Text()))
+		if line == "" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			if !strings.HasSuffix(line, "]") || strings.HasPrefix(line, "[[") {
+				return fmt.Errorf("%s:%d: bad table header %q", path, n, line)
+			}
+			table = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		key, raw, ok := strings.Cut(line, "=")
+		if !ok {
+			return fmt.Errorf("%s:%d: expected key = value", path, n)
+		}
+		name := strings.ReplaceAll(strings.TrimSpace(key), "_", "-")
+		if table != "" {
+			name = strings.ReplaceAll(table, "_", "-") + "-" + name
+		}
+		if fs.Lookup(name) == nil || name == "config" {
+			return fmt.Errorf("%s:%d: unknown setting %q", path, n, name)
+		}
+		if seen[name] {
+			return fmt.Errorf("%s:%d: %q set twice", path, n, name)
+		}
+		seen[name] = true
+		value, err := parseValue(strings.TrimSpace(raw))
+		if err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, n, name, err)
+		}
+		if explicit[name] {
+			continue
+		}
+		if err := fs.Set(name, value); err != nil {
+			return fmt.Errorf("%s:%d: %s: %v", path, n, name, err)
+		}
+	}
+	return scanner.Err()
+}
+
+// parseValue turns a TOML value into flag syntax
+func parseValue(raw string) (string, error) {
+	if strings.HasPrefix(raw, "[") {
+		if !strings.HasSuffix(raw, "]") {
+			return "", fmt.Errorf("arrays must be on one line")
+		}
+		var items []string
+		for _, item := range splitArray(raw[1 : len(raw)-1]) {
+			value, err := parseValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, value)
+		}
+		return strings.Join(items, ","), nil
+	}
+	switch {
+	case raw == "":
+		return "", fmt.Errorf("missing value")
+	case strings.HasPrefix(raw, `"`):
+		return strconv.Unquote(raw)
+	case strings.HasPrefix(raw, "'"):
+		if len(raw) < 2 || !strings.HasSuffix(raw, "'") {
+			return "", fmt.Errorf("unterminated string %s", raw)
+		}
+		return raw[1 : len(raw)-1], nil
+	}
+	// numbers and booleans; TOML allows _ between digits
+	return strings.ReplaceAll(raw, "_", ""), nil
+}
+
+// splitArray splits the inside of an array at the commas outside strings,
+// dropping a trailing comma
+func splitArray(s string) []string {
+	var items []string
+	var quote byte
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == ',':
+			items = append(items, strings.TrimSpace(s[start:i]))
+			start = i + 1
+		}
+	}
+	if last := strings.TrimSpace(s[start:]); last != "" {
+		items = append(items, last)
+	}
+	return items
+}
+
+// stripComment removes a # comment that is not inside a string
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		switch c := line[i]; {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// loadFileCipher builds the cipher for the server's files from the base64
+// key in $currentEnv and the comma-separated old keys in $oldEnv; nil if
+// there is no current key
+func loadFileCipher(currentEnv, oldEnv string) (*kvstore.FileCipher, error) {
+	secret := os.Getenv(currentEnv)
+	if secret == "" {
+		return nil, nil
+	}
+	current, err := kvstore.ParseEncryptionKey(secret)
+	if err != nil {
+		return nil, fmt.Errorf("$%s: %w", currentEnv, err)
+	}
+	var old [][]byte
+	for _, s := range strings.Split(os.Getenv(oldEnv), ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		key, err := kvstore.ParseEncryptionKey(s)
+		if err != nil {
+			return nil, fmt.Errorf("$%s: %w", oldEnv, err)
+		}
+		old = append(old, key)
+	}
+	return kvstore.NewFileCipher(current, old...)
+}
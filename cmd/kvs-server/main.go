@@ -0,0 +1,401 @@
+// kvs-server serves the key-value store over TCP. Its settings come from
+// flags and, with -config, from a TOML file whose keys are the flag names,
+// e.g. kvs.example.toml; flags given on the command line win.
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"plugin"
+	"strings"
+	"syscall"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/server"
+)
+
+func main() {
+	config := flag.String("config", "", "TOML file with settings, named like the flags")
+	addrs := flag.String("addr", strings.Join(server.DefaultAddrs, ","), "comma-separated addresses to listen on; json://ADDR speaks JSON instead of gob")
+	pins := flag.String("pin", "", "comma-separated key patterns that are never evicted, e.g. \"config/*\"")
+	nodes := flag.String("cluster", "", "comma-separated addresses of every server in the cluster, in slot order")
+	clusterState := flag.String("cluster-state", server.ClusterStateFile, "file keeping the -cluster slot map across restarts once slots move")
+	self := flag.String("self", "", "this server's address as listed in -cluster, -failover-group or -multi-primary")
+	engine := flag.String("engine", kvstore.EngineMap, "storage engine: map, arena for large read-mostly datasets, mmap[:DIR] for large values off the Go heap, or disk[:DIR] or tiered[:DIR] for datasets larger than memory")
+	hotKeys := flag.Int("hot-keys", kvstore.DefaultHotKeys, "most keys -engine tiered keeps in memory, demoting the least recently used to disk")
+	drain := flag.Duration("drain", server.DefaultShutdownTimeouts.Drain, "how long shutdown waits for in-flight requests")
+	updateTTL := flag.String("update-ttl", kvstore.TTLReset.String(), "what UPDATE does to a key's expiry by default: reset restarts its TTL, keep preserves its remaining lifetime")
+	plugins := flag.String("plugin", "", "comma-separated Go plugins (.so) that register custom commands")
+	logLevel := flag.String("log-level", kvstore.LogInfo.String(), "least severe log messages printed: debug, info, warn or error; kvs-admin log-level changes it at run time")
+	warmup := flag.Duration("warmup", 0, "after start, throttle cache misses reaching the store for this long, e.g. 30s")
+	warmupFrom := flag.Float64("warmup-from", 100, "misses per second let through when -warmup starts")
+	warmupTo := flag.Float64("warmup-to", 10000, "misses per second let through when -warmup ends, after which they are not limited")
+	ttl := flag.Duration("ttl", kvstore.DefaultTTL, "how long keys set without their own TTL live")
+	cacheSize := flag.Int("cache-size", 0, "most keys the read cache holds, 0 for no limit")
+	cacheMB := flag.Int64("cache-mb", 0, "most MiB of keys and values the read cache holds, 0 for no limit")
+	maxMemoryMB := flag.Int64("maxmemory-mb", 0, "most MiB of keys and values the store holds, 0 for no limit")
+	maxMemoryPolicy := flag.String("maxmemory-policy", kvstore.MaxMemoryReject.String(), "what a write that would exceed -maxmemory-mb does: reject it, or evict lru, the least recently used keys, or ttl, the keys closest to expiry")
+	cacheTTL := flag.Duration("cache-ttl", 0, "read values cached longer than this from the store again, even for keys that live longer, 0 for never")
+	cachePreload := flag.Int("cache-preload", 0, "on start, once the data is restored, cache this many of the most recently written keys")
+	cachePreloadPrefixes := flag.String("cache-preload-prefixes", "", "on start, once the data is restored, cache every key starting with one of these comma-separated prefixes, e.g. \"session:,config:\"")
+	cachePolicy := flag.String("cache-policy", kvstore.EvictLRU, "keys the full read cache evicts: lru, the least recently used, lfu, the least often used, or fifo, the first cached")
+	cacheStrategy := flag.String("cache-strategy", kvstore.CacheAside.String(), "what SET and UPDATE do with the read cache: cache-aside drops the key, write-through caches the value written, write-back caches it and writes it to the store later, and cannot be used with -wal-fsync always")
+	cacheWriteBackDelay := flag.Duration("cache-write-back-delay", kvstore.DefaultWriteBackDelay, "under -cache-strategy write-back, longest a buffered write waits for the store")
+	cacheWriteBackMax := flag.Int("cache-write-back-max", kvstore.DefaultWriteBackPending, "under -cache-strategy write-back, most keys buffered at once")
+	restore := flag.Bool("restore", true, "on start, load the latest snapshot that restores from the backup file, the timestamped snapshots or -backup-sink, before accepting connections")
+	backupFile := flag.String("backup-file", kvstore.BackupFileName, "where snapshots are written")
+	backupCompress := flag.String("backup-compress", kvstore.SnapshotUncompressed, "compress snapshots with gzip, or none; restores read either")
+	backupKeep := flag.Int("backup-keep", 0, "keep this many timestamped snapshots besides overwriting the backup file, 0 for no limit by count")
+	backupKeepFor := flag.Duration("backup-keep-for", 0, "keep timestamped snapshots this long, 0 for no limit by age; with -backup-keep also 0, only the backup file is kept")
+	backupFullEvery := flag.Int("backup-full-every", 0, "write a full snapshot every this many snapshots and, in between, deltas of the keys changed since the one before; 0 for only full snapshots")
+	backupInterval := flag.Duration("backup-interval", kvstore.BackupInterval, "how often a snapshot is written")
+	backupSink := flag.String("backup-sink", "", "also copy each snapshot to s3://bucket/prefix, gs://bucket/prefix or a directory; S3 and GCS take credentials from $AWS_ACCESS_KEY_ID and $AWS_SECRET_ACCESS_KEY")
+	backupCron := flag.String("backup-cron", "", "write snapshots on this cron schedule, e.g. \"*/15 * * * *\", in local time, instead of every -backup-interval")
+	backupQuiet := flag.String("backup-quiet", "", "skip scheduled snapshots in these local hours, e.g. 09:00-17:00")
+	journalFile := flag.String("journal-file", server.DefaultFiles.Journal, "write journal file, empty to keep it in memory only")
+	pubsubFile := flag.String("pubsub-file", server.DefaultFiles.PubSub, "durable pub/sub log, empty to keep it in memory only")
+	persistence := flag.String("persistence", "snapshot", "how the data survives a restart: none, snapshot, wal, or snapshot+wal")
+	walFile := flag.String("wal-file", kvstore.WALFile, "write-ahead log of every change, with -persistence wal or snapshot+wal")
+	walRewritePercent := flag.Int("wal-rewrite-percent", 100, "rewrite the WAL once it has grown by this percentage since the last rewrite; 0 to only rewrite on kvs-admin rewrite-wal")
+	walRewriteMin := flag.Int64("wal-rewrite-min-mb", 64, "smallest WAL, in MiB, that is rewritten for having grown")
+	walCorrupt := flag.String("wal-corrupt", "refuse", "what to do on start with a WAL damaged before its end: refuse to start, or truncate it there after copying it to <wal-file>.corrupt")
+	walFsync := flag.String("wal-fsync", "1s", "when to fsync the WAL: always, os to leave it to the OS, or at most every interval such as 1s")
+	separator := flag.String("separator", "/", "splits keys into directories for LIST, empty to turn LIST off")
+	ordered := flag.Bool("ordered", true, "keep keys sorted for RANGE; false saves memory and a little time per new key")
+	keyEnv := flag.String("encryption-key-env", kvstore.EncryptionKeyEnv, "environment variable holding a base64 AES key, of 16, 24 or 32 bytes, to encrypt values with in memory and in snapshots; unset or empty for none")
+	fileKeyEnv := flag.String("file-key-env", kvstore.FileKeyEnv, "environment variable holding a base64 AES key to encrypt snapshots, the journal, the pub/sub log and the WAL with; unset or empty for none")
+	fileOldKeysEnv := flag.String("file-old-keys-env", kvstore.FileOldKeysEnv, "environment variable holding the comma-separated base64 keys files were encrypted with before a rotation")
+	compress := flag.Int("compress-above", 0, "keep values of at least this many bytes DEFLATE-compressed when that saves space, 0 for none")
+	bloom := flag.Bool("bloom-filter", false, "keep a Bloom filter over the keys, so reads of missing keys skip the store's lock and disk engines, at about 10 bits per key")
+	search := flag.Bool("search", false, "keep an inverted index of the words in values for SEARCH, at some memory and time per write")
+	history := flag.Int("history", 0, "keep the last this many values of each key in memory, for HISTORY, DIFF and GET at a revision; 0 for none")
+	historyKeys := flag.String("history-keys", "", "comma-separated key patterns, e.g. \"config/*\", that -history applies to; empty for every key")
+	softDelete := flag.Duration("soft-delete", 0, "keep deleted keys this long for UNDELETE before purging them, 0 to delete for good")
+	readOnly := flag.Bool("read-only", false, "refuse SET, UPDATE and DELETE while serving reads; kvs-admin read-only off lifts it")
+	coalesce := flag.String("coalesce", "", "journal SETs to matching keys at most once per window, last value winning, e.g. \"metrics/*=100ms,telemetry/*=50ms\"")
+	minFree := flag.Uint64("min-free-mb", 0, "free MiB the backup, journal and pub/sub volumes must keep; below it snapshots pause and -disk-policy applies, 0 to not check")
+	diskPolicy := flag.String("disk-policy", server.DiskPauseSnapshots.String(), "what else happens below -min-free-mb: snapshots (nothing else), read-only (until lifted) or reject (writes, until space is back)")
+	diskInterval := flag.Duration("disk-check-interval", server.DefaultDiskCheckInterval, "how often free disk space is checked")
+	namespaces := flag.String("namespaces", "", "key prefixes with limits and engine, e.g. \"tenant-a/=max-keys=1000 max-bytes=1048576 ttl=1h,tenant-b/=ttl=5m engine=arena\"")
+	slos := flag.String("slo", "", "latency objectives, e.g. \"GET:p99<5ms,SET:p99<10ms\"; while one is missed -degrade applies")
+	degrade := flag.String("degrade", "listings,scan,shed", "what is given up while an -slo is missed: listings (refuse LIST, DBSIZE and RANGE), scan (shorter SCAN pages), shed (refuse low-priority requests)")
+	sloInterval := flag.Duration("slo-check-interval", server.DefaultSLOCheckInterval, "how often -slo is checked, against the requests since the last check")
+	adminAddrs := flag.String("admin-addr", "", "comma-separated addresses of the admin listeners; ADMIN and DIAGNOSE are then refused on -addr")
+	adminAllow := flag.String("admin-allow", "", "comma-separated CIDRs or addresses allowed to connect to -admin-addr, e.g. \"127.0.0.1,10.0.0.0/8\"; empty allows all")
+	slowThreshold := flag.Duration("slowlog-threshold", server.DefaultSlowLogThreshold, "requests taking at least this long are kept for kvs-admin slowlog; 0 to keep none")
+	slowSize := flag.Int("slowlog-size", server.DefaultSlowLogSize, "how many of the latest slow requests are kept")
+	healthAddr := flag.String("health-addr", "", "address to answer HTTP GET /healthz and /readyz on, e.g. :8090; empty for none")
+	metricsPush := flag.String("metrics-push", "", "push the numbers of kvs-admin stats to statsd://HOST:PORT over UDP or graphite://HOST:PORT over TCP; empty for none")
+	metricsPrefix := flag.String("metrics-prefix", "kvs.", "prefix of every pushed metric name")
+	metricsInterval := flag.Duration("metrics-interval", server.DefaultMetricsInterval, "how often metrics are pushed")
+	cdc := flag.String("cdc", "", "publish every change to the keys to kafka://BROKER:9092[,BROKER...]/TOPIC, keyed by key, for change data capture; empty for none")
+	cdcBuffer := flag.Int("cdc-buffer", kvstore.DefaultEventBuffer, "changes buffered while -cdc falls behind")
+	cdcPolicy := flag.String("cdc-policy", kvstore.EventsBlock.String(), "what happens once -cdc-buffer is full: block (writes wait, nothing lost), drop-oldest or coalesce (only each key's latest change kept)")
+	rateLimit := flag.Float64("rate-limit", 0, "requests per second each client host may send to -addr, the rest refused with RATE_LIMITED; 0 for no limit")
+	rateBurst := flag.Int("rate-burst", 100, "requests a client host may send at once before -rate-limit applies")
+	keyHits := flag.Int("key-hits", server.DefaultKeyHits, "how many keys to count the requests of, for the hottest in kvs-admin keyspace; 0 to count none")
+	dedupWindow := flag.Duration("dedup-window", server.DefaultDedupWindow, "how long the response to a request with a RequestID is remembered for resends, 0 to not remember")
+	maxKeyLen := flag.Int("max-key-len", server.DefaultMaxKeyLen, "most bytes a key may have; longer ones are refused with BAD_REQUEST")
+	maxRequestMB := flag.Int64("max-request-mb", server.DefaultMaxRequestSize>>20, "most MiB one request may take on the wire; larger ones are refused with REQUEST_TOO_LARGE")
+	replicaOf := flag.String("replica-of", "", "address of a primary to replicate, its admin listener if it has one: its keys replace these and writes are refused; kvs-admin replica-of no-one promotes")
+	failoverGroup := flag.String("failover-group", "", "comma-separated addresses of a primary and its replicas, this one included, that elect the primary among themselves; needs -self")
+	failoverTimeout := flag.Duration("failover-timeout", server.DefaultFailoverTimeout, "how long the replicas of -failover-group go without hearing from the primary before electing another")
+	failoverState := flag.String("failover-state", server.FailoverStateFile, "file keeping this server's -failover-group epoch and vote across restarts")
+	multiPrimary := flag.String("multi-primary", "", "comma-separated addresses of peers, this one included, that all take writes and merge each other's, the last writer winning; needs -self")
+	replicas := flag.Int("replicas", 0, "replicas of this primary that writes with Consistency QUORUM or ALL count; 0 for the rest of -failover-group, or else those that have connected lately")
+	replBacklog := flag.Int("replication-backlog", kvstore.DefaultReplicationBacklog, "writes kept for replicas that fell behind to catch up from; one further behind loads a snapshot again")
+	adminToken := flag.String("admin-token", os.Getenv("KVS_ADMIN_TOKEN"), "token admin actions must carry, $KVS_ADMIN_TOKEN by default; empty for none")
+	flag.Parse()
+	if *config != "" {
+		if err := loadConfig(flag.CommandLine, *config); err != nil {
+			fmt.Println("Error in -config:", err)
+			return
+		}
+	}
+	level, err := kvstore.ParseLogLevel(*logLevel)
+	if err != nil {
+		fmt.Println("Error in -log-level:", err)
+		return
+	}
+	kvstore.SetLogLevel(level)
+
+	fmt.Println("KEY-VALUE-STORE THAT CACHE KEY-VALUES, IT FETCHES VALUES FROM CACHE IF NOT IN CACHE THEN IT FETCHES FROM KEY-VALUE-STORE")
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if *plugins != "" {
+		for _, path := range strings.Split(*plugins, ",") {
+			// the plugin's init functions call server.RegisterCommand
+			if _, err := plugin.Open(path); err != nil {
+				fmt.Println("Error in -plugin:", err)
+				return
+			}
+		}
+		fmt.Println("Custom commands:", strings.Join(server.Commands(), " "))
+	}
+	kvs, err := kvstore.NewKeyValueStoreWithEngine(*engine)
+	if err != nil {
+		fmt.Println("Error in -engine:", err)
+		return
+	}
+	kvs.SetHotKeys(*hotKeys)
+	mode, err := kvstore.ParseTTLMode(*updateTTL)
+	if err != nil {
+		fmt.Println("Error in -update-ttl:", err)
+		return
+	}
+	kvs.SetUpdateTTLMode(mode)
+	walSync, err := kvstore.ParseWALSync(*walFsync)
+	if err != nil {
+		fmt.Println("Error in -wal-fsync:", err)
+		return
+	}
+	kvs.SetWALSync(walSync)
+	recovery, err := kvstore.ParseWALRecovery(*walCorrupt)
+	if err != nil {
+		fmt.Println("Error in -wal-corrupt:", err)
+		return
+	}
+	kvs.SetWALRecovery(recovery)
+	kvs.SetWALRewrite(*walRewritePercent, *walRewriteMin<<20)
+	kvs.SetTTL(*ttl)
+	kvs.SetSeparator(*separator)
+	kvs.SetOrdered(*ordered)
+	kvs.SetSearch(*search)
+	kvs.SetBloomFilter(*bloom)
+	if secret := os.Getenv(*keyEnv); secret != "" {
+		key, err := kvstore.ParseEncryptionKey(secret)
+		if err == nil {
+			err = kvs.SetEncryption(key)
+		}
+		if err != nil {
+			fmt.Printf("Error in $%s: %v\n", *keyEnv, err)
+			return
+		}
+	}
+	if err := kvs.SetCompression(*compress); err != nil {
+		fmt.Println("Error in -compress-above:", err)
+		return
+	}
+	kvs.SetBackup(*backupFile, *backupInterval)
+	kvs.SetRetention(*backupKeep, *backupKeepFor)
+	kvs.SetIncremental(*backupFullEvery)
+	var cron *kvstore.Cron
+	if *backupCron != "" {
+		if cron, err = kvstore.ParseCron(*backupCron); err != nil {
+			fmt.Println("Error in -backup-cron:", err)
+			return
+		}
+	}
+	quiet, err := kvstore.ParseQuietHours(*backupQuiet)
+	if err != nil {
+		fmt.Println("Error in -backup-quiet:", err)
+		return
+	}
+	kvs.SetBackupSchedule(cron, quiet)
+	if *backupSink != "" {
+		sink, err := kvstore.ParseSink(*backupSink)
+		if err != nil {
+			fmt.Println("Error in -backup-sink:", err)
+			return
+		}
+		kvs.SetSnapshotSink(sink)
+	}
+	if err := kvs.SetSnapshotCompression(*backupCompress); err != nil {
+		fmt.Println("Error in -backup-compress:", err)
+		return
+	}
+	nsList, err := kvstore.ParseNamespaces(*namespaces)
+	if err == nil {
+		err = kvs.SetNamespaces(nsList)
+	}
+	if err != nil {
+		fmt.Println("Error in -namespaces:", err)
+		return
+	}
+	memoryPolicy, err := kvstore.ParseMaxMemoryPolicy(*maxMemoryPolicy)
+	if err != nil {
+		fmt.Println("Error in -maxmemory-policy:", err)
+		return
+	}
+	kvs.SetMaxMemory(*maxMemoryMB<<20, memoryPolicy)
+	var historyPatterns []string
+	if *historyKeys != "" {
+		historyPatterns = strings.Split(*historyKeys, ",")
+	}
+	if err := kvs.SetVersionHistory(*history, historyPatterns); err != nil {
+		fmt.Println("Error in -history-keys:", err)
+		return
+	}
+	kvs.SetSoftDelete(*softDelete)
+	if *pins != "" {
+		if err := kvs.SetPinPatterns(strings.Split(*pins, ",")); err != nil {
+			fmt.Println("Error in -pin:", err)
+			return
+		}
+	}
+	srv := server.NewServerWithStore(kvs, strings.Split(*addrs, ",")...)
+	srv.SetFlags(flag.CommandLine)
+	srv.SetSlowLog(*slowThreshold, *slowSize)
+	srv.SetHealthAddr(*healthAddr)
+	if err := srv.SetMetricsPush(server.MetricsPush{Addr: *metricsPush, Prefix: *metricsPrefix, Interval: *metricsInterval}); err != nil {
+		fmt.Println("Error in -metrics-push:", err)
+		return
+	}
+	if *cdc != "" {
+		sink, err := kvstore.ParseChangeSink(*cdc)
+		if err != nil {
+			fmt.Println("Error in -cdc:", err)
+			return
+		}
+		policy, err := kvstore.ParseEventPolicy(*cdcPolicy)
+		if err != nil {
+			fmt.Println("Error in -cdc-policy:", err)
+			return
+		}
+		kvs.SetEventBuffer(*cdcBuffer, policy)
+		srv.SetChangeSink(sink)
+	}
+	if *rateLimit > 0 {
+		// one allowance across the data listeners; the admin listeners stay
+		// reachable however busy a client is
+		limit := server.RateLimit(*rateLimit, *rateBurst)
+		for _, addr := range strings.Split(*addrs, ",") {
+			srv.UseOn(addr, limit)
+		}
+	}
+	if *nodes != "" {
+		if err := srv.SetCluster(*self, strings.Split(*nodes, ",")); err != nil {
+			fmt.Println("Error in -cluster:", err)
+			return
+		}
+		if err := srv.SetClusterState(*clusterState); err != nil {
+			fmt.Println("Error in -cluster-state:", err)
+			return
+		}
+	}
+	allow, err := server.ParseAllow(*adminAllow)
+	if err != nil {
+		fmt.Println("Error in -admin-allow:", err)
+		return
+	}
+	plane := server.AdminPlane{Allow: allow, Token: *adminToken}
+	if *adminAddrs != "" {
+		plane.Addrs = strings.Split(*adminAddrs, ",")
+	}
+	srv.SetAdminPlane(plane)
+	fileCipher, err := loadFileCipher(*fileKeyEnv, *fileOldKeysEnv)
+	if err != nil {
+		fmt.Println("Error in file keys:", err)
+		return
+	}
+	kvs.SetFileCipher(fileCipher)
+	srv.SetFiles(server.Files{Journal: *journalFile, PubSub: *pubsubFile, Cipher: fileCipher})
+	persister, err := kvstore.ParsePersistence(*persistence, *walFile, *restore)
+	if err != nil {
+		fmt.Println("Error in -persistence:", err)
+		return
+	}
+	srv.SetPersister(persister)
+	srv.SetMaxRequestSize(*maxRequestMB << 20)
+	srv.SetMaxKeyLen(*maxKeyLen)
+	srv.SetDedupWindow(*dedupWindow)
+	srv.SetKeyHits(*keyHits)
+	srv.SetCacheSize(*cacheSize)
+	srv.SetCacheBytes(*cacheMB << 20)
+	srv.SetCacheTTL(*cacheTTL)
+	preload := kvstore.Preload{Recent: *cachePreload}
+	if *cachePreloadPrefixes != "" {
+		preload.Prefixes = strings.Split(*cachePreloadPrefixes, ",")
+	}
+	srv.SetPreload(preload)
+	if err := srv.SetEvictionPolicy(*cachePolicy); err != nil {
+		fmt.Println("Error in -cache-policy:", err)
+		return
+	}
+	strategy, err := kvstore.ParseCacheStrategy(*cacheStrategy)
+	if err != nil {
+		fmt.Println("Error in -cache-strategy:", err)
+		return
+	}
+	if strategy == kvstore.WriteBack && walSync == kvstore.WALSyncAlways {
+		fmt.Println("Error in -cache-strategy:", errors.New("write-back answers writes before the WAL has them, which -wal-fsync always promises not to"))
+		return
+	}
+	srv.SetCacheStrategy(strategy, kvstore.WriteBackLimits{Delay: *cacheWriteBackDelay, MaxPending: *cacheWriteBackMax})
+	srv.SetReadOnly(*readOnly)
+	kvs.SetReplicationBacklog(*replBacklog)
+	srv.SetReplicas(*replicas)
+	if *failoverGroup != "" {
+		if *replicaOf != "" {
+			fmt.Println("Error in -replica-of:", errors.New("the -failover-group elects the primary"))
+			return
+		}
+		g := server.FailoverGroup{Self: *self, Members: strings.Split(*failoverGroup, ","), Timeout: *failoverTimeout, StateFile: *failoverState}
+		if err := srv.SetFailoverGroup(g); err != nil {
+			fmt.Println("Error in -failover-group:", err)
+			return
+		}
+	}
+	if *multiPrimary != "" {
+		if *replicaOf != "" || *failoverGroup != "" {
+			fmt.Println("Error in -multi-primary:", errors.New("every peer is a primary, so it takes no -replica-of or -failover-group"))
+			return
+		}
+		g := server.MultiPrimary{Self: *self, Peers: strings.Split(*multiPrimary, ",")}
+		if err := srv.SetMultiPrimary(g); err != nil {
+			fmt.Println("Error in -multi-primary:", err)
+			return
+		}
+	}
+	srv.ReplicaOf(*replicaOf)
+	rules, err := server.ParseCoalesceRules(*coalesce)
+	if err == nil {
+		err = srv.SetCoalescing(rules)
+	}
+	if err != nil {
+		fmt.Println("Error in -coalesce:", err)
+		return
+	}
+	policy, err := server.ParseDiskPolicy(*diskPolicy)
+	if err != nil {
+		fmt.Println("Error in -disk-policy:", err)
+		return
+	}
+	srv.SetDiskGuard(server.DiskGuard{MinFree: *minFree << 20, Policy: policy, Interval: *diskInterval})
+	sloList, err := server.ParseSLOs(*slos)
+	if err != nil {
+		fmt.Println("Error in -slo:", err)
+		return
+	}
+	degradations, err := server.ParseDegradations(*degrade)
+	if err != nil {
+		fmt.Println("Error in -degrade:", err)
+		return
+	}
+	srv.SetSLOGuard(server.SLOGuard{SLOs: sloList, Degrade: degradations, Interval: *sloInterval})
+	timeouts := server.DefaultShutdownTimeouts
+	timeouts.Drain = *drain
+	srv.SetShutdownTimeouts(timeouts)
+	srv.SetWarmup(*warmup, *warmupFrom, *warmupTo)
+	if err := srv.Start(ctx); err != nil {
+		if errors.Is(err, kvstore.ErrRestore) {
+			// starting empty would overwrite the backup on the next snapshot
+			fmt.Println("Error restoring backup, start with -restore=false to start empty:", err)
+			return
+		}
+		if errors.Is(err, kvstore.ErrWALCorrupt) {
+			fmt.Println("Error replaying WAL, start with -wal-corrupt truncate to drop what follows the damage:", err)
+			return
+		}
+		fmt.Println("Error starting server:", err)
+		return
+	}
+	<-ctx.Done()
+	fmt.Println("Shutting down")
+	srv.Stop()
+}
@@ -0,0 +1,3 @@
+module github.com/nishantpratap1/key-value-store-golang
+
+go 1.22
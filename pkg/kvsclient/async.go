@@ -0,0 +1,226 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrNoPipelining is returned by the async calls when the server answers
+// only one request per connection.
+var ErrNoPipelining = errors.New("kvsclient: server does not support pipelining")
+
+// Future is the pending reply to an asynchronous request.
+type Future struct {
+	done     chan struct{}
+	request  protocol.Request
+	response protocol.Response
+	err      error
+	result   func(protocol.Response) (string, error)
+}
+
+// Done is closed once the reply has arrived or the request failed.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Wait blocks until the reply arrives or ctx is done and returns what the
+// matching synchronous call would, e.g. ErrNotFound for a missing key.
+// Giving up on ctx does not withdraw the request, which was already sent.
+func (f *Future) Wait(ctx context.Context) (string, error) {
+	select {
+	case <-f.done:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	if f.err != nil {
+		return "", f.err
+	}
+	return f.result(f.response)
+}
+
+// Response returns the full reply once Done is closed.
+func (f *Future) Response() (protocol.Response, error) {
+	<-f.done
+	return f.response, f.err
+}
+
+func (f *Future) complete(response protocol.Response, err error) {
+	f.response, f.err = response, err
+	close(f.done)
+}
+
+// pipeline is one connection that many requests are written to without
+// waiting for replies; the server answers in order, so replies are matched
+// to the queue of pending futures. wmu orders the writes and is held while
+// one blocks; mu guards the rest, so replies keep being read meanwhile.
+type pipeline struct {
+	wmu     sync.Mutex
+	mu      sync.Mutex
+	cn      *conn
+	pending []*Future
+	closed  bool
+}
+
+// DoAsync sends request on the client's pipelined connection and returns at
+// once; ctx only bounds connecting and sending. Requests sent this way are
+// answered in order and are not retried.
+func (c *Client) DoAsync(ctx context.Context, request protocol.Request) *Future {
+	return c.async(ctx, request, simpleResult)
+}
+
+// GetAsync is the asynchronous Get.
+func (c *Client) GetAsync(ctx context.Context, key string) *Future {
+	return c.async(ctx, protocol.Request{Action: protocol.ActionGet, Key: key}, getResult)
+}
+
+// SetAsync is the asynchronous SetWithTTL; a zero ttl means the server's default.
+func (c *Client) SetAsync(ctx context.Context, key, value string, ttl time.Duration) *Future {
+	return c.async(ctx, protocol.Request{Action: protocol.ActionSet, Key: key, Value: value, TTL: ttl}, simpleResult)
+}
+
+// UpdateAsync is the asynchronous Update.
+func (c *Client) UpdateAsync(ctx context.Context, key, value string) *Future {
+	return c.async(ctx, protocol.Request{Action: protocol.ActionUpdate, Key: key, Value: value}, keyedResult)
+}
+
+// DeleteAsync is the asynchronous Delete.
+func (c *Client) DeleteAsync(ctx context.Context, key string) *Future {
+	return c.async(ctx, protocol.Request{Action: protocol.ActionDelete, Key: key}, keyedResult)
+}
+
+func (c *Client) async(ctx context.Context, request protocol.Request, result func(protocol.Response) (string, error)) *Future {
+	request = c.withChecksum(request)
+	f := &Future{done: make(chan struct{}), request: request, result: result}
+	if c.probe {
+		if _, err := c.hello(ctx); err != nil {
+			f.complete(protocol.Response{}, err)
+			return f
+		}
+	}
+	if !c.supports(protocol.CapPersistent) {
+		f.complete(protocol.Response{}, ErrNoPipelining)
+		return f
+	}
+
+	p := &c.pipe
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		f.complete(protocol.Response{}, ErrClosed)
+		return f
+	}
+	cn := p.cn
+	p.mu.Unlock()
+	if cn == nil {
+		var err error
+		if cn, err = c.dial(ctx, c.codec); err != nil {
+			f.complete(protocol.Response{}, err)
+			return f
+		}
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			cn.Close()
+			f.complete(protocol.Response{}, ErrClosed)
+			return f
+		}
+		p.cn = cn
+		p.mu.Unlock()
+		go c.readReplies(cn)
+	}
+
+	p.mu.Lock()
+	if p.cn != cn {
+		// failed between the two critical sections
+		p.mu.Unlock()
+		f.complete(protocol.Response{}, errors.New("kvsclient: pipelined connection lost"))
+		return f
+	}
+	if len(p.pending) == 0 {
+		cn.SetReadDeadline(c.replyDeadline(request))
+	}
+	p.pending = append(p.pending, f)
+	p.mu.Unlock()
+
+	// the write is bounded like a synchronous request, and a failed write
+	// leaves the stream unusable for everything queued behind it
+	request.Budget = c.budget(ctx, request)
+	stop := watchDeadline(ctx, c.timeout, cn.SetWriteDeadline)
+	err := cn.enc.Encode(request)
+	if !stop() || err != nil {
+		p.mu.Lock()
+		p.fail(cn, contextErr(ctx, err))
+		p.mu.Unlock()
+		return f
+	}
+	cn.SetWriteDeadline(time.Time{})
+	return f
+}
+
+// replyDeadline is when the reply to request is overdue, zero for never
+func (c *Client) replyDeadline(request protocol.Request) time.Time {
+	if timeout := c.requestTimeout(request); timeout > 0 {
+		return time.Now().Add(timeout)
+	}
+	return time.Time{}
+}
+
+// readReplies hands each reply on cn to the oldest pending future until
+// the connection fails
+func (c *Client) readReplies(cn *conn) {
+	p := &c.pipe
+	for {
+		var response protocol.Response
+		err := cn.dec.Decode(&response)
+		p.mu.Lock()
+		if err != nil {
+			p.fail(cn, err)
+			p.mu.Unlock()
+			return
+		}
+		if p.cn != cn || len(p.pending) == 0 {
+			// a reply nobody asked for; the stream is out of step
+			p.fail(cn, errors.New("kvsclient: unexpected reply"))
+			p.mu.Unlock()
+			return
+		}
+		f := p.pending[0]
+		p.pending = p.pending[1:]
+		if len(p.pending) > 0 {
+			cn.SetReadDeadline(c.replyDeadline(p.pending[0].request))
+		} else {
+			cn.SetReadDeadline(time.Time{})
+		}
+		p.mu.Unlock()
+		f.complete(response, nil)
+	}
+}
+
+// fail closes cn and fails every request waiting on it, caller must hold p.mu
+func (p *pipeline) fail(cn *conn, err error) {
+	if p.cn != cn {
+		return
+	}
+	cn.Close()
+	p.cn = nil
+	for _, f := range p.pending {
+		f.complete(protocol.Response{}, err)
+	}
+	p.pending = nil
+}
+
+// close fails the pending requests and stops new ones
+func (p *pipeline) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	if p.cn != nil {
+		p.fail(p.cn, ErrClosed)
+	}
+}
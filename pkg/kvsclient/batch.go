@@ -0,0 +1,65 @@
+package kvsclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// WriteBatch is a set of writes that Commit applies in one step: the
+// server applies every one of them or, if it refuses any, none, so related
+// keys never disagree. A WriteBatch is not safe for concurrent use.
+type WriteBatch struct {
+	c       *Client
+	request protocol.Request
+}
+
+// WriteBatch starts a batch whose keys live for ttl, or the server's
+// default if zero.
+func (c *Client) WriteBatch(ttl time.Duration) *WriteBatch {
+	return &WriteBatch{c: c, request: protocol.Request{Action: protocol.ActionBatch, TTL: ttl}}
+}
+
+// Set adds a SET of key to value.
+func (b *WriteBatch) Set(key, value string) *WriteBatch {
+	return b.add(key, value, false)
+}
+
+// Delete adds a DELETE of key; deleting a missing key does not fail the
+// batch.
+func (b *WriteBatch) Delete(key string) *WriteBatch {
+	return b.add(key, "", true)
+}
+
+func (b *WriteBatch) add(key, value string, del bool) *WriteBatch {
+	b.request.Keys = append(b.request.Keys, key)
+	b.request.Values = append(b.request.Values, value)
+	b.request.Deletes = append(b.request.Deletes, del)
+	return b
+}
+
+// Commit sends the batch, at most protocol.MaxBatchKeys writes, and
+// returns the revision of each write in order, zero for the delete of a
+// missing key. If the server refuses a write, for example with
+// ErrQuotaExceeded, none is applied and err names its key.
+func (b *WriteBatch) Commit(ctx context.Context) ([]uint64, error) {
+	response, err := b.c.Do(ctx, b.request)
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		for _, r := range response.Results {
+			if r.Message != "" {
+				return nil, fmt.Errorf("%s: %w", r.Key, newKVSError(response))
+			}
+		}
+		return nil, newKVSError(response)
+	}
+	revisions := make([]uint64, len(response.Results))
+	for i, r := range response.Results {
+		revisions[i] = r.Revision
+	}
+	return revisions, nil
+}
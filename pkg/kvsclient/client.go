@@ -0,0 +1,931 @@
+// Package kvsclient is the Go client for the kvs server.
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// default timeouts, a zero option means no timeout
+const (
+	DefaultDialTimeout = 5 * time.Second
+	DefaultTimeout     = 10 * time.Second
+)
+
+// Client represents a client that communicates with the server.
+//
+// A Client keeps a pool of connections to the server and is safe for use by
+// many goroutines; Close it when done.
+type Client struct {
+	addr        string
+	dialTimeout time.Duration
+	timeout     time.Duration
+	codec       protocol.Codec
+	pool        pool
+	probe       bool
+	checksums   bool
+	token       string
+	lowPriority bool
+	retry       RetryPolicy
+	requestIDs  bool // see WithRequestIDs
+	pipe        pipeline
+
+	interceptors        []Interceptor
+	handler             Handler
+	attemptInterceptors []Interceptor
+	attempt             Handler
+
+	helloMu sync.Mutex
+	info    *serverInfo
+
+	cache *clientCache // see WithClientCache
+}
+
+// Option configures a Client.
+type Option func(*Client)
+
+// WithToken sends token with every request, for servers that want an
+// admin token before ADMIN, DIAGNOSE and other admin actions.
+func WithToken(token string) Option {
+	return func(c *Client) { c.token = token }
+}
+
+// WithLowPriority marks every request as work that can wait, such as a
+// batch job or a cache warmer, which a server missing its latency
+// objectives may refuse with protocol.MsgDegraded before other traffic.
+func WithLowPriority() Option {
+	return func(c *Client) { c.lowPriority = true }
+}
+
+// WithDialTimeout bounds how long connecting to the server may take.
+func WithDialTimeout(d time.Duration) Option {
+	return func(c *Client) { c.dialTimeout = d }
+}
+
+// WithTimeout bounds how long one request, from send to reply, may take.
+func WithTimeout(d time.Duration) Option {
+	return func(c *Client) { c.timeout = d }
+}
+
+// WithCodec sets the preferred wire encoding, protocol.Gob or
+// protocol.JSON. If the server does not speak it, or its listener at addr
+// speaks another, the client falls back to gob.
+func WithCodec(codec protocol.Codec) Option {
+	return func(c *Client) { c.codec = codec }
+}
+
+// WithChecksums sends a checksum with every value written by SET or
+// UPDATE, including through clients spanning servers built with it. The server refuses a value
+// damaged on the way and keeps the checksum with it, so damage done later,
+// in memory or on disk, is reported as ErrIntegrity instead of returned.
+// A checksum that comes back with a value is always verified.
+func WithChecksums() Option {
+	return func(c *Client) { c.checksums = true }
+}
+
+// NewClient returns a client for the server at addr, e.g. "localhost:8081".
+// The first request probes the server with HELLO so the client can work
+// with older servers too, see WithoutProbe.
+func NewClient(addr string, opts ...Option) *Client {
+	c := &Client{
+		addr:        addr,
+		dialTimeout: DefaultDialTimeout,
+		timeout:     DefaultTimeout,
+		codec:       protocol.Gob,
+		pool:        pool{maxIdle: DefaultMaxIdle, idleTimeout: DefaultIdleTimeout},
+		probe:       true,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	c.handler = chain(c.send, c.interceptors)
+	c.attempt = chain(c.roundTrip, c.attemptInterceptors)
+	return c
+}
+
+// ErrNotFound is returned when the key does not exist.
+var ErrNotFound = errors.New("kvsclient: key not found")
+
+// ErrIntegrity is returned when a value does not match its checksum.
+var ErrIntegrity = errors.New("kvsclient: value fails its checksum")
+
+// Get returns the value of key, or ErrNotFound.
+func (c *Client) Get(ctx context.Context, key string) (string, error) {
+	if c.cache != nil {
+		return c.cachedGet(ctx, key)
+	}
+	return call(ctx, c, protocol.Request{Action: protocol.ActionGet, Key: key}, getResult)
+}
+
+// GetRevision is Get that also returns the key's revision, which changes
+// exactly when the key is written; see CompareAndSwapRevision.
+func (c *Client) GetRevision(ctx context.Context, key string) (value string, rev uint64, err error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionGet, Key: key})
+	if err != nil {
+		return "", 0, err
+	}
+	if value, err = getResult(response); err != nil {
+		return "", 0, err
+	}
+	return value, response.Revision, nil
+}
+
+// Set sets key to value with the server's default TTL.
+func (c *Client) Set(ctx context.Context, key, value string) error {
+	return c.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets key to value and expires it after ttl.
+func (c *Client) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionSet, Key: key, Value: value, TTL: ttl})
+}
+
+// SetNX sets key to value and expires it after ttl, the server's default
+// if zero, only if key does not exist, and reports whether it did. The
+// check and the write are one step, so of several clients racing for the
+// same key exactly one wins.
+func (c *Client) SetNX(ctx context.Context, key, value string, ttl time.Duration) (bool, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionSetNX, Key: key, Value: value, TTL: ttl})
+	if err != nil {
+		return false, err
+	}
+	if response.Found {
+		return false, nil
+	}
+	if _, err := simpleResult(response); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// CompareAndSwap sets key to value and expires it after ttl, the server's
+// default if zero, only if its value is expect. Otherwise it returns
+// ErrConflict, with the key's current value and whether it exists, so the
+// caller can recompute value from it and try again.
+func (c *Client) CompareAndSwap(ctx context.Context, key, expect, value string, ttl time.Duration) (current string, found bool, err error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionCAS, Key: key, Expect: expect, Value: value, TTL: ttl})
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return response.Value, response.Found, err
+	}
+	return value, true, nil
+}
+
+// CompareAndSwapRevision is CompareAndSwap comparing the key's revision,
+// as GetRevision returned it, rather than its value, so it also notices
+// writes that set the value it had. It returns the revision of the write,
+// or with ErrConflict the key's current value and revision, zero if the
+// key does not exist.
+func (c *Client) CompareAndSwapRevision(ctx context.Context, key string, expect uint64, value string, ttl time.Duration) (current string, rev uint64, err error) {
+	if expect == 0 {
+		return "", 0, errors.New("kvsclient: CompareAndSwapRevision needs a revision above zero")
+	}
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionCAS, Key: key, Revision: expect, Value: value, TTL: ttl})
+	if err != nil {
+		return "", 0, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return response.Value, response.Revision, err
+	}
+	return value, response.Revision, nil
+}
+
+// GetSet sets key to value with the server's default TTL and returns the
+// value it replaced; found is false if there was none. No other write can
+// land between the read and the write.
+func (c *Client) GetSet(ctx context.Context, key, value string) (old string, found bool, err error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionGetSet, Key: key, Value: value})
+	if err != nil {
+		return "", false, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return "", false, err
+	}
+	return response.Value, response.Found, nil
+}
+
+// GetDel deletes key and returns the value it had, or ErrNotFound, in one
+// step, so of several clients taking the same key only one gets it.
+func (c *Client) GetDel(ctx context.Context, key string) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionGetDel, Key: key}, getResult)
+}
+
+// Update replaces the value of an existing key, or returns ErrNotFound. The
+// server's default decides whether this restarts the key's TTL.
+func (c *Client) Update(ctx context.Context, key, value string) error {
+	return c.keyed(ctx, protocol.Request{Action: protocol.ActionUpdate, Key: key, Value: value})
+}
+
+// ttl choices for UpdateWithTTL besides a new TTL above zero
+const (
+	KeepTTL  time.Duration = -1 // keep the key's remaining lifetime
+	ResetTTL time.Duration = -2 // restart the key's current TTL from now
+)
+
+// ErrNoUpdateTTL is returned by UpdateWithTTL when the server would ignore
+// the ttl and apply its default.
+var ErrNoUpdateTTL = errors.New("kvsclient: server does not support a TTL on UPDATE")
+
+// UpdateWithTTL is Update with the key's expiry chosen by ttl: KeepTTL,
+// ResetTTL, a new TTL counted from now, or zero for the server's default.
+func (c *Client) UpdateWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	request := protocol.Request{Action: protocol.ActionUpdate, Key: key, Value: value}
+	switch {
+	case ttl == KeepTTL:
+		request.TTLMode = protocol.TTLKeep
+	case ttl == ResetTTL:
+		request.TTLMode = protocol.TTLReset
+	case ttl > 0:
+		request.TTL = ttl
+	}
+	if ttl != 0 {
+		if c.probe {
+			if _, err := c.hello(ctx); err != nil {
+				return err
+			}
+		}
+		if !c.supports(protocol.CapUpdateTTL) {
+			return ErrNoUpdateTTL
+		}
+	}
+	return c.keyed(ctx, request)
+}
+
+// withChecksum adds the value's checksum to SET and UPDATE requests, and
+// each value's to MSET and BATCH, if the client was created WithChecksums
+func (c *Client) withChecksum(request protocol.Request) protocol.Request {
+	if c.checksums && request.Checksum == 0 && (request.Action == protocol.ActionSet || request.Action == protocol.ActionSetNX || request.Action == protocol.ActionCAS || request.Action == protocol.ActionGetSet || request.Action == protocol.ActionUpdate) {
+		request.Checksum = protocol.Checksum(request.Value)
+	}
+	if c.checksums && request.Checksums == nil && (request.Action == protocol.ActionMSet || request.Action == protocol.ActionBatch) {
+		request.Checksums = make([]uint32, len(request.Values))
+		for i, value := range request.Values {
+			request.Checksums[i] = protocol.Checksum(value)
+		}
+	}
+	return request
+}
+
+// Incr adds one to the integer in key, creating it as 0 first if it does
+// not exist, and returns the result; the key keeps its remaining lifetime.
+// A value that is not an integer fails with ErrNotInteger.
+func (c *Client) Incr(ctx context.Context, key string) (int64, error) {
+	return c.counter(ctx, protocol.Request{Action: protocol.ActionIncr, Key: key})
+}
+
+// Decr subtracts one from the integer in key, see Incr.
+func (c *Client) Decr(ctx context.Context, key string) (int64, error) {
+	return c.counter(ctx, protocol.Request{Action: protocol.ActionDecr, Key: key})
+}
+
+// IncrBy adds delta, which may be negative, to the integer in key, see
+// Incr.
+func (c *Client) IncrBy(ctx context.Context, key string, delta int64) (int64, error) {
+	return c.counter(ctx, protocol.Request{Action: protocol.ActionIncrBy, Key: key, Value: strconv.FormatInt(delta, 10)})
+}
+
+// NextID hands out the next count IDs, at least one, of the sequence at
+// key and returns the first: the IDs from it to first+count-1 are the
+// caller's alone, and greater than any the sequence handed out before. A
+// missing key starts the sequence at 1.
+func (c *Client) NextID(ctx context.Context, key string, count int) (int64, error) {
+	return c.counter(ctx, protocol.Request{Action: protocol.ActionNextID, Key: key, Limit: max(count, 1)})
+}
+
+// counter sends an INCR, DECR, INCRBY or NEXTID request
+func (c *Client) counter(ctx context.Context, request protocol.Request) (int64, error) {
+	value, err := call(ctx, c, request, simpleResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(value, 10, 64)
+}
+
+// Append adds value to the end of the value of key, creating key if it
+// does not exist, and returns the new length in bytes; the key keeps its
+// remaining lifetime.
+func (c *Client) Append(ctx context.Context, key, value string) (int, error) {
+	length, err := call(ctx, c, protocol.Request{Action: protocol.ActionAppend, Key: key, Value: value}, simpleResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(length)
+}
+
+// Strlen returns the length in bytes of the value of key, 0 if it does
+// not exist.
+func (c *Client) Strlen(ctx context.Context, key string) (int, error) {
+	length, err := call(ctx, c, protocol.Request{Action: protocol.ActionStrlen, Key: key}, simpleResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(length)
+}
+
+// SetBit sets the bit at offset in the value of key, the most significant
+// bit of the first byte being offset 0, and returns whether it was set
+// before. A value too short is padded with zero bytes and a missing key is
+// created; an existing key keeps its remaining lifetime.
+func (c *Client) SetBit(ctx context.Context, key string, offset int, set bool) (bool, error) {
+	bit := "0"
+	if set {
+		bit = "1"
+	}
+	old, err := call(ctx, c, protocol.Request{Action: protocol.ActionSetBit, Key: key, Start: offset, Value: bit}, simpleResult)
+	return old == "1", err
+}
+
+// GetBit reports whether the bit at offset in the value of key is set;
+// bits past the end, and of a missing key, are not.
+func (c *Client) GetBit(ctx context.Context, key string, offset int) (bool, error) {
+	bit, err := call(ctx, c, protocol.Request{Action: protocol.ActionGetBit, Key: key, Start: offset}, simpleResult)
+	return bit == "1", err
+}
+
+// BitCount returns how many bits of the value of key are set, 0 if it
+// does not exist.
+func (c *Client) BitCount(ctx context.Context, key string) (int, error) {
+	n, err := call(ctx, c, protocol.Request{Action: protocol.ActionBitCount, Key: key}, simpleResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(n)
+}
+
+// MGet reads keys in as few round trips as protocol.MaxBatchKeys allows
+// and returns the values of those that exist. Keys that fail, such as one
+// whose value fails its checksum or, in a cluster, one another server
+// owns, are left out, and err says which and why. Writes may land between
+// the reads of two keys; ReadTx.MGet reads them all at one point in time.
+func (c *Client) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	return c.mget(ctx, "", keys)
+}
+
+// mget is MGet in the read transaction tx, if set
+func (c *Client) mget(ctx context.Context, tx string, keys []string) (map[string]string, error) {
+	values := make(map[string]string, len(keys))
+	var errs []error
+	for len(keys) > 0 {
+		batch := keys[:min(len(keys), protocol.MaxBatchKeys)]
+		keys = keys[len(batch):]
+		response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionMGet, Keys: batch, ReadTx: tx})
+		if err != nil {
+			return values, err
+		}
+		if !response.Success {
+			return values, newKVSError(response)
+		}
+		for _, r := range response.Results {
+			if r.Message == protocol.MsgMoved {
+				errs = append(errs, fmt.Errorf("%s: %w", r.Key, newKVSError(keyResponse(r))))
+				continue
+			}
+			value, err := getResult(keyResponse(r))
+			switch {
+			case err == nil:
+				values[r.Key] = value
+			case !errors.Is(err, ErrNotFound):
+				errs = append(errs, fmt.Errorf("%s: %w", r.Key, err))
+			}
+		}
+	}
+	return values, errors.Join(errs...)
+}
+
+// MSet sets every key in values with the server's default TTL if ttl is
+// zero, in as few round trips as protocol.MaxBatchKeys allows. Each key is
+// set or not on its own; err says which failed and why.
+func (c *Client) MSet(ctx context.Context, values map[string]string, ttl time.Duration) error {
+	request := protocol.Request{Action: protocol.ActionMSet, TTL: ttl}
+	var errs []error
+	flush := func() error {
+		response, err := c.Do(ctx, request)
+		if err != nil {
+			return err
+		}
+		if !response.Success {
+			return newKVSError(response)
+		}
+		for _, r := range response.Results {
+			if _, err := simpleResult(keyResponse(r)); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", r.Key, err))
+			}
+		}
+		request.Keys, request.Values = nil, nil
+		return nil
+	}
+	for key, value := range values {
+		request.Keys = append(request.Keys, key)
+		request.Values = append(request.Values, value)
+		if len(request.Keys) == protocol.MaxBatchKeys {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if len(request.Keys) > 0 {
+		if err := flush(); err != nil {
+			return err
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// keyResponse is the response a request for r's key alone would have had
+func keyResponse(r protocol.KeyResult) protocol.Response {
+	response := protocol.Response{Value: r.Value, Message: r.Message, Found: r.Found, Success: r.Success, Checksum: r.Checksum}
+	if r.Message == protocol.MsgMoved {
+		response.Error = &protocol.ErrorInfo{Code: r.Message, Leader: r.Value}
+	}
+	return response
+}
+
+// Delete removes key, or returns ErrNotFound.
+func (c *Client) Delete(ctx context.Context, key string) error {
+	return c.keyed(ctx, protocol.Request{Action: protocol.ActionDelete, Key: key})
+}
+
+// Touch restarts the lifetime of key from now, for ttl if it is above zero
+// or else for the TTL it has, without resending its value, or returns
+// ErrNotFound if key does not exist.
+func (c *Client) Touch(ctx context.Context, key string, ttl time.Duration) error {
+	return c.keyed(ctx, protocol.Request{Action: protocol.ActionTouch, Key: key, TTL: ttl})
+}
+
+// Undelete brings back key, soft deleted by a server that keeps deleted
+// keys for a while, with the value, type and remaining TTL it had. It
+// returns ErrNotFound if key was not soft deleted, or has been purged
+// since, and a *KVSError with Code VALUE_EXISTS if key has been set again.
+func (c *Client) Undelete(ctx context.Context, key string) error {
+	return c.keyed(ctx, protocol.Request{Action: protocol.ActionUndelete, Key: key})
+}
+
+// Rename moves the value of src, with its TTL, to dst in one step,
+// replacing dst if it exists, or returns ErrNotFound if src does not exist.
+// In a cluster both keys must belong to the same server.
+func (c *Client) Rename(ctx context.Context, src, dst string) error {
+	return c.keyed(ctx, protocol.Request{Action: protocol.ActionRename, Key: src, Value: dst})
+}
+
+// Copy sets dst to the value of src, replacing dst if it exists, or returns
+// ErrNotFound if src does not exist. A ttl above zero gives the copy that
+// TTL, otherwise it expires with src.
+func (c *Client) Copy(ctx context.Context, src, dst string, ttl time.Duration) error {
+	return c.keyed(ctx, protocol.Request{Action: protocol.ActionCopy, Key: src, Value: dst, TTL: ttl})
+}
+
+// keyed is simple for actions on a key that must exist
+func (c *Client) keyed(ctx context.Context, request protocol.Request) error {
+	_, err := call(ctx, c, request, keyedResult)
+	return err
+}
+
+// doer sends requests: a Client, or one of the clients spanning servers
+type doer interface {
+	Do(ctx context.Context, request protocol.Request) (protocol.Response, error)
+}
+
+// call sends request through d and interprets the reply with result
+func call(ctx context.Context, d doer, request protocol.Request, result func(protocol.Response) (string, error)) (string, error) {
+	response, err := d.Do(ctx, request)
+	if err != nil {
+		return "", err
+	}
+	return result(response)
+}
+
+// ErrReadOnly is returned for writes while the server is in read-only mode.
+var ErrReadOnly = errors.New("kvsclient: server is read-only")
+
+// ErrQuotaExceeded is returned for writes that would take the key's
+// namespace over its limits.
+var ErrQuotaExceeded = errors.New("kvsclient: namespace quota exceeded")
+
+// ErrOutOfMemory is returned for writes that would take the server over
+// its maxmemory and for which it could not, or may not, evict keys.
+var ErrOutOfMemory = errors.New("kvsclient: server out of memory")
+
+// ErrNotInteger is returned by Incr, IncrBy and Decr when the key's value
+// is not a 64-bit integer or the result would overflow one.
+var ErrNotInteger = errors.New("kvsclient: value is not an integer or would overflow")
+
+// ErrConflict is returned by CompareAndSwap when the key does not hold the
+// expected value, by CompareAndSwapRevision when it is not at the expected
+// revision, and by Multi.Exec when a watched key has changed.
+var ErrConflict = errors.New("kvsclient: conflict, the value has changed")
+
+// ErrWrongType is returned for an action on a key that holds the other
+// kind of value: a hash for GET or INCR, a plain value for HSET or HGET.
+var ErrWrongType = errors.New("kvsclient: key holds the wrong kind of value")
+
+// ErrReadEnded is returned by the reads of a ReadTx that has ended.
+var ErrReadEnded = errors.New("kvsclient: read transaction has ended")
+
+// ErrDiskFull is returned for writes the server refuses because its disk
+// is nearly full.
+var ErrDiskFull = errors.New("kvsclient: server disk is nearly full")
+
+// ErrRateLimited is returned for requests the server refuses because the
+// client sent too many; the KVSError's Backoff says when to send the next.
+var ErrRateLimited = errors.New("kvsclient: rate limited by the server")
+
+// ErrBadRequest is returned for requests the server refuses as malformed,
+// such as one naming an empty key, a key over its length limit or a key
+// with control characters.
+var ErrBadRequest = errors.New("kvsclient: bad request")
+
+// ErrServerError is returned when the server failed to carry out a request
+// for reasons of its own, such as a kvs-proxy whose server for the key is
+// down; it is retried by default.
+var ErrServerError = errors.New("kvsclient: server error")
+
+// getResult is the outcome of a GET response
+func getResult(response protocol.Response) (string, error) {
+	if err := messageErr(response); err != nil {
+		return "", err
+	}
+	if !response.Found {
+		return "", ErrNotFound
+	}
+	if response.Checksum != 0 && protocol.Checksum(response.Value) != response.Checksum {
+		return "", ErrIntegrity
+	}
+	return response.Value, nil
+}
+
+// keyedResult is the outcome of a response to an action on a key that must exist
+func keyedResult(response protocol.Response) (string, error) {
+	if err := messageErr(response); err != nil {
+		return "", err
+	}
+	if !response.Found {
+		return "", ErrNotFound
+	}
+	return simpleResult(response)
+}
+
+// simpleResult turns an unsuccessful response into an error
+func simpleResult(response protocol.Response) (string, error) {
+	if err := messageErr(response); err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", newKVSError(response)
+	}
+	return response.Value, nil
+}
+
+// Do sends any request to the server and returns the full response. It
+// gives up when ctx is cancelled or its deadline passes, whichever comes
+// before the client's own timeout. Idempotent requests are retried as set
+// by WithRetry, and every request passes through the interceptors set by
+// WithInterceptors.
+func (c *Client) Do(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	response, err := c.handler(ctx, c.withChecksum(request))
+	if c.cache != nil && request.Action != protocol.ActionGet && (request.Key != "" || len(request.Keys) > 0) {
+		// a write may have changed them, whatever came back; other
+		// actions on them cost a Get a miss at worst
+		c.cache.drop(request.Key, request.Keys)
+	}
+	return response, err
+}
+
+// send is the innermost Handler: it sends request, retrying if allowed
+func (c *Client) send(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	if request.Token == "" {
+		request.Token = c.token
+	}
+	request.LowPriority = request.LowPriority || c.lowPriority
+	if c.requestIDs && request.RequestID == "" && !retryable(request) && !connActions[request.Action] {
+		request.RequestID = newRequestID()
+	}
+	if c.retry.MaxAttempts <= 1 || !retryable(request) && request.RequestID == "" {
+		return c.try(ctx, request, 1)
+	}
+	return c.doWithRetry(ctx, request)
+}
+
+// roundTrip sends request once on a pooled connection
+func (c *Client) roundTrip(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	var response protocol.Response
+	cn, err := c.get(ctx)
+	if err != nil {
+		return response, err
+	}
+
+	request.Budget = c.budget(ctx, request)
+	stop := cn.watch(ctx, c.requestTimeout(request))
+	err = cn.enc.Encode(request)
+	if err == nil {
+		err = cn.dec.Decode(&response)
+	}
+	// a cancelled request may have left a reply in flight, so the
+	// connection cannot be reused even if the round trip finished
+	if !stop() || err != nil {
+		c.put(cn, true)
+		return response, contextErr(ctx, err)
+	}
+	cn.SetDeadline(time.Time{})
+	c.put(cn, false)
+	return response, nil
+}
+
+// requestTimeout leaves room for actions that wait on the server side,
+// zero means no timeout
+func (c *Client) requestTimeout(request protocol.Request) time.Duration {
+	if c.timeout <= 0 {
+		return 0
+	}
+	return c.timeout + request.Timeout
+}
+
+// budget is how long the server has to answer request before the client
+// gives up on it, the sooner of ctx's deadline and the client's timeout;
+// zero means no limit
+func (c *Client) budget(ctx context.Context, request protocol.Request) time.Duration {
+	budget := c.requestTimeout(request)
+	if deadline, ok := ctx.Deadline(); ok {
+		if left := max(time.Until(deadline), time.Nanosecond); budget <= 0 || left < budget {
+			budget = left
+		}
+	}
+	return budget
+}
+
+// contextErr prefers the context's error over the I/O error it caused
+func contextErr(ctx context.Context, err error) error {
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
+}
+
+// Lock takes an advisory read or write lock on key for owner.
+func (c *Client) Lock(ctx context.Context, key, owner string, write bool, wait, lease time.Duration) (bool, error) {
+	action := protocol.ActionRLock
+	if write {
+		action = protocol.ActionWLock
+	}
+	response, err := c.Do(ctx, protocol.Request{Action: action, Key: key, Owner: owner, Timeout: wait, TTL: lease})
+	return response.Success, err
+}
+
+// Unlock releases the advisory locks owner holds on key.
+func (c *Client) Unlock(ctx context.Context, key, owner string) (bool, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionUnlock, Key: key, Owner: owner})
+	return response.Success, err
+}
+
+// Publish sends payload to every durable subscriber of channel and returns
+// how many subscribers it was queued for.
+func (c *Client) Publish(ctx context.Context, channel, payload string) (int, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionPublish, Key: channel, Value: payload})
+	if err != nil {
+		return 0, err
+	}
+	if !response.Success {
+		return 0, newKVSError(response)
+	}
+	return strconv.Atoi(response.Value)
+}
+
+// Subscribe adds channel to the durable subscription called name. Messages
+// for it are kept on the server until acknowledged, even while the
+// subscriber is offline.
+func (c *Client) Subscribe(ctx context.Context, name, channel string) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionSubscribe, Key: channel, Owner: name})
+}
+
+// Unsubscribe removes channel from the durable subscription called name.
+func (c *Client) Unsubscribe(ctx context.Context, name, channel string) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionUnsubscribe, Key: channel, Owner: name})
+}
+
+// Fetch returns the messages name has not acknowledged yet, waiting up to
+// wait for one if there are none. Messages are delivered at least once:
+// until Ack is called they come back on every Fetch.
+func (c *Client) Fetch(ctx context.Context, name string, wait time.Duration) ([]protocol.Message, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionFetch, Owner: name, Timeout: wait})
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, newKVSError(response)
+	}
+	return response.Messages, nil
+}
+
+// Ack acknowledges every message for name up to and including id.
+func (c *Client) Ack(ctx context.Context, name string, id uint64) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionAck, Owner: name, Value: strconv.FormatUint(id, 10)})
+}
+
+// Journal returns up to limit journal entries after revision after, oldest
+// first, and the server's latest revision. Call it again with the last
+// returned revision to page through the history.
+func (c *Client) Journal(ctx context.Context, after uint64, limit int) ([]protocol.JournalEntry, uint64, error) {
+	return c.journal(ctx, after, limit, 0)
+}
+
+// journal is Journal that waits up to wait for a write when there is none
+func (c *Client) journal(ctx context.Context, after uint64, limit int, wait time.Duration) ([]protocol.JournalEntry, uint64, error) {
+	request := protocol.Request{Action: protocol.ActionJournal, Value: strconv.FormatUint(after, 10), Limit: limit, Timeout: wait}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, 0, err
+	}
+	if !response.Success {
+		return nil, 0, newKVSError(response)
+	}
+	latest, err := strconv.ParseUint(response.Value, 10, 64)
+	return response.Journal, latest, err
+}
+
+// List returns the keys and sub-directories directly under dir, "" for the
+// root, when the server splits keys into directories at a separator such
+// as "/"; limit > 0 caps the number of entries.
+func (c *Client) List(ctx context.Context, dir string, limit int) ([]protocol.DirEntry, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionList, Key: dir, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, newKVSError(response)
+	}
+	return response.Entries, nil
+}
+
+// Page is a page of keys from Scan or Range. While More is set, passing
+// Continue to the same call gets the next page.
+type Page struct {
+	Keys     []string
+	More     bool
+	Continue string
+}
+
+// Scan returns a page of the keys matching pattern, in path.Match syntax
+// such as "user:*", "" for all keys; cont is the previous page's Continue,
+// "" for the first. count is how many keys the server examines, its
+// default if zero, so a page may hold fewer keys or none before the end.
+// Keys present for the whole scan are returned exactly once, whatever is
+// written meanwhile.
+func (c *Client) Scan(ctx context.Context, pattern string, count int, cont string) (Page, error) {
+	return c.page(ctx, protocol.Request{Action: protocol.ActionScan, Key: pattern, Limit: count, Continue: cont})
+}
+
+// page sends a SCAN or RANGE request
+func (c *Client) page(ctx context.Context, request protocol.Request) (Page, error) {
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return Page{}, err
+	}
+	if !response.Success {
+		return Page{}, newKVSError(response)
+	}
+	return Page{Keys: response.Values, More: response.More, Continue: response.Continue}, nil
+}
+
+// Range returns, in order, a page of the keys from start up to but not
+// including end, "" for no end; limit > 0 caps the keys in a page, and
+// cont is the previous page's Continue, "" for the first. Servers that
+// don't keep their keys sorted fail with UNORDERED.
+func (c *Client) Range(ctx context.Context, start, end string, limit int, cont string) (Page, error) {
+	return c.page(ctx, protocol.Request{Action: protocol.ActionRange, Key: start, Value: end, Limit: limit, Continue: cont})
+}
+
+// SearchHit is a key Search found and how well its value matched, higher
+// being better.
+type SearchHit struct {
+	Key   string
+	Score float64
+}
+
+// Search returns the keys whose values hold every word of query, in any
+// case, best match first, skipping offset of them; limit > 0 caps the
+// keys in a page, else the server's default does. more reports whether
+// there are matches past the page. Servers without a search index fail
+// with NO_INDEX.
+func (c *Client) Search(ctx context.Context, query string, offset, limit int) (hits []SearchHit, more bool, err error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionSearch, Value: query, Start: offset, Limit: limit})
+	if err != nil {
+		return nil, false, err
+	}
+	if !response.Success {
+		return nil, false, newKVSError(response)
+	}
+	if len(response.Values)%2 != 0 {
+		return nil, false, errors.New("kvsclient: malformed SEARCH reply")
+	}
+	hits = make([]SearchHit, len(response.Values)/2)
+	for i := range hits {
+		score, err := protocol.ParseScore(response.Values[2*i+1])
+		if err != nil {
+			return nil, false, err
+		}
+		hits[i] = SearchHit{response.Values[2*i], score}
+	}
+	return hits, response.More, nil
+}
+
+// DBSize counts the live keys on the server, in all and by namespace
+// prefix.
+func (c *Client) DBSize(ctx context.Context) (total int, byNamespace map[string]int, err error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionDBSize})
+	if err != nil {
+		return 0, nil, err
+	}
+	if !response.Success {
+		return 0, nil, newKVSError(response)
+	}
+	if total, err = strconv.Atoi(response.Value); err != nil {
+		return 0, nil, err
+	}
+	byNamespace = make(map[string]int, len(response.Values))
+	for _, line := range response.Values {
+		i := strings.LastIndex(line, ": ")
+		if i < 0 {
+			return 0, nil, fmt.Errorf("kvsclient: bad DBSIZE line %q", line)
+		}
+		n, err := strconv.Atoi(line[i+2:])
+		if err != nil {
+			return 0, nil, err
+		}
+		byNamespace[line[:i]] = n
+	}
+	return total, byNamespace, nil
+}
+
+// Commands describes the actions the server understands, as generated from
+// its command registry.
+func (c *Client) Commands(ctx context.Context) ([]protocol.CommandSpec, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionCommands})
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		return nil, newKVSError(response)
+	}
+	return response.Commands, nil
+}
+
+// Ping checks the server is up and serving requests.
+func (c *Client) Ping(ctx context.Context) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionPing})
+}
+
+// Wait waits up to timeout for replicas of the primary to have every
+// write before it, this client's included, and returns how many have
+// them, which may be fewer.
+func (c *Client) Wait(ctx context.Context, replicas int, timeout time.Duration) (int, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionWait, Limit: replicas, Timeout: timeout})
+	if err != nil {
+		return 0, err
+	}
+	if !response.Success {
+		return 0, newKVSError(response)
+	}
+	return strconv.Atoi(response.Value)
+}
+
+// Pin protects key from eviction under memory pressure; TTL still applies.
+func (c *Client) Pin(ctx context.Context, key string) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionPin, Key: key})
+}
+
+// Unpin removes a pin set with Pin.
+func (c *Client) Unpin(ctx context.Context, key string) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionUnpin, Key: key})
+}
+
+// simple sends request and turns an unsuccessful response into an error
+func (c *Client) simple(ctx context.Context, request protocol.Request) error {
+	_, err := call(ctx, c, request, simpleResult)
+	return err
+}
+
+// Diagnose asks the server for a DIAGNOSE bundle and saves the tar.gz under dir.
+func (c *Client) Diagnose(ctx context.Context, dir string) (string, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionDiagnose})
+	if err != nil {
+		return "", err
+	}
+	if !response.Success {
+		return "", fmt.Errorf("diagnose failed: %w", newKVSError(response))
+	}
+	path := filepath.Join(dir, response.Message)
+	if err := os.WriteFile(path, []byte(response.Value), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,176 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// maxRedirects bounds how often one request follows MOVED
+const maxRedirects = 3
+
+// ClusterClient talks to a cluster of servers that split the keyspace into
+// hash slots. It learns the slot map from any server with CLUSTER INFO,
+// sends each key straight to its owner, and reloads the map when a server
+// answers MOVED because the map changed. Reshard moves slots between
+// servers while they serve.
+type ClusterClient struct {
+	seeds []string
+	opts  []Option
+
+	mu      sync.Mutex
+	slots   [protocol.NumSlots]string
+	home    string
+	loaded  bool
+	clients map[string]*Client
+}
+
+// NewClusterClient returns a client for the cluster that seeds belong to;
+// any one of them is enough to find the rest. opts configure the Client
+// used for each server.
+func NewClusterClient(seeds []string, opts ...Option) *ClusterClient {
+	return &ClusterClient{seeds: seeds, opts: opts, clients: make(map[string]*Client)}
+}
+
+// Refresh reloads the slot map, asking the known servers in turn.
+func (cc *ClusterClient) Refresh(ctx context.Context) error {
+	cc.mu.Lock()
+	addrs := append([]string(nil), cc.seeds...)
+	for addr := range cc.clients {
+		addrs = append(addrs, addr)
+	}
+	cc.mu.Unlock()
+
+	err := errors.New("kvsclient: no seed servers")
+	for _, addr := range addrs {
+		var response protocol.Response
+		response, err = cc.client(addr).Do(ctx, protocol.Request{Action: protocol.ActionCluster, Value: "INFO"})
+		if err == nil && !response.Success {
+			err = newKVSError(response)
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			continue
+		}
+		var slots [protocol.NumSlots]string
+		for _, line := range response.Values {
+			r, perr := protocol.ParseSlotRange(line)
+			if perr != nil {
+				return perr
+			}
+			for slot := r.First; slot <= r.Last; slot++ {
+				slots[slot] = r.Addr
+			}
+		}
+		cc.mu.Lock()
+		cc.slots, cc.home, cc.loaded = slots, addr, true
+		cc.mu.Unlock()
+		return nil
+	}
+	return err
+}
+
+// Do sends request to the server that owns request.Key, following MOVED,
+// and ASK while the key's slot moves to another server. Requests without a
+// key go to the server the map was loaded from.
+func (cc *ClusterClient) Do(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	addr, err := cc.route(ctx, request.Key)
+	if err != nil {
+		return protocol.Response{}, err
+	}
+	for redirects := 0; ; redirects++ {
+		response, err := cc.client(addr).Do(ctx, request)
+		if err != nil || redirects == maxRedirects {
+			return response, err
+		}
+		switch response.Message {
+		case protocol.MsgAsk:
+			// only this request goes there; the slot stays the old
+			// owner's until it has moved
+			addr, request.Asking = response.Value, true
+		case protocol.MsgMoved:
+			// the owner is in the reply; the map is reloaded for the
+			// next keys
+			addr, request.Asking = response.Value, false
+			if err := cc.Refresh(ctx); err != nil {
+				return response, err
+			}
+		default:
+			return response, err
+		}
+	}
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (cc *ClusterClient) Get(ctx context.Context, key string) (string, error) {
+	return call(ctx, cc, protocol.Request{Action: protocol.ActionGet, Key: key}, getResult)
+}
+
+// Set sets key to value with the server's default TTL.
+func (cc *ClusterClient) Set(ctx context.Context, key, value string) error {
+	return cc.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets key to value and expires it after ttl.
+func (cc *ClusterClient) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := call(ctx, cc, protocol.Request{Action: protocol.ActionSet, Key: key, Value: value, TTL: ttl}, simpleResult)
+	return err
+}
+
+// Update replaces the value of an existing key, or returns ErrNotFound.
+func (cc *ClusterClient) Update(ctx context.Context, key, value string) error {
+	_, err := call(ctx, cc, protocol.Request{Action: protocol.ActionUpdate, Key: key, Value: value}, keyedResult)
+	return err
+}
+
+// Delete removes key, or returns ErrNotFound.
+func (cc *ClusterClient) Delete(ctx context.Context, key string) error {
+	_, err := call(ctx, cc, protocol.Request{Action: protocol.ActionDelete, Key: key}, keyedResult)
+	return err
+}
+
+// Close closes the client of every server contacted.
+func (cc *ClusterClient) Close() error {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	for _, c := range cc.clients {
+		c.Close()
+	}
+	return nil
+}
+
+// route returns the server for key, loading the slot map the first time
+func (cc *ClusterClient) route(ctx context.Context, key string) (string, error) {
+	cc.mu.Lock()
+	loaded := cc.loaded
+	cc.mu.Unlock()
+	if !loaded {
+		if err := cc.Refresh(ctx); err != nil {
+			return "", err
+		}
+	}
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if addr := cc.slots[protocol.Slot(key)]; addr != "" && key != "" {
+		return addr, nil
+	}
+	// a standalone server, or a slot nobody owns
+	return cc.home, nil
+}
+
+// client returns the Client for addr, creating it on first use
+func (cc *ClusterClient) client(addr string) *Client {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	c, ok := cc.clients[addr]
+	if !ok {
+		c = NewClient(addr, cc.opts...)
+		cc.clients[addr] = c
+	}
+	return c
+}
@@ -0,0 +1,300 @@
+package kvsclient
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// BackupManifestFile is the name of the manifest in a cluster backup bundle
+const BackupManifestFile = "manifest.json"
+
+// BackupManifest describes a cluster backup bundle written by
+// ClusterClient.Backup: one snapshot per server, all taken while writes
+// were frozen across the cluster if Frozen is set.
+type BackupManifest struct {
+	Created time.Time    `json:"created"`
+	Frozen  bool         `json:"frozen"`
+	Nodes   []BackupNode `json:"nodes"`
+}
+
+// BackupNode is one server's snapshot in a bundle. Slots are the
+// "first-last" slot ranges it owned, none if standalone, and Revision is
+// its journal revision the snapshot matches.
+type BackupNode struct {
+	Addr     string   `json:"addr"`
+	Slots    []string `json:"slots,omitempty"`
+	File     string   `json:"file"`
+	Revision uint64   `json:"revision"`
+	Keys     int      `json:"keys"`
+}
+
+// RestoreResult is what one server loaded in ClusterClient.Restore
+type RestoreResult struct {
+	Addr    string
+	Loaded  int
+	Expired int // already past their TTL, dropped
+	Damaged int // failing their checksum, dropped
+}
+
+// snapshot is a server's backup file format, with the values left alone
+type snapshot struct {
+	Data map[string]json.RawMessage `json:"data"`
+}
+
+// Backup writes a gzipped tar of every server's keys to w, with a manifest
+// naming each server's snapshot and the journal revision it matches.
+// With freeze above zero the servers refuse writes while their snapshots
+// are taken, so the bundle is one consistent point of the whole cluster;
+// freeze bounds how long that may last should Backup not get to thaw
+// them. Without it each server's snapshot is consistent on its own but
+// they are taken at slightly different times.
+//
+// The servers must accept ADMIN on their cluster addresses, with the token
+// set by WithToken if they want one.
+func (cc *ClusterClient) Backup(ctx context.Context, w io.Writer, freeze time.Duration) (manifest BackupManifest, err error) {
+	manifest = BackupManifest{Created: time.Now().UTC(), Frozen: freeze > 0}
+	if err := cc.Refresh(ctx); err != nil {
+		return manifest, err
+	}
+	nodes := cc.nodes()
+	if freeze > 0 {
+		var frozen []string
+		defer func() {
+			// thaw even if ctx is done, or writes stay refused until the
+			// lease runs out
+			ctx := context.WithoutCancel(ctx)
+			for _, addr := range frozen {
+				if _, thawErr := cc.admin(ctx, addr, protocol.AdminFreeze, "off"); thawErr != nil {
+					err = errors.Join(err, fmt.Errorf("thawing %s: %w", addr, thawErr))
+				}
+			}
+		}()
+		for _, node := range nodes {
+			if _, err := cc.admin(ctx, node.Addr, protocol.AdminFreeze, freeze.String()); err != nil {
+				return manifest, fmt.Errorf("freezing %s: %w", node.Addr, err)
+			}
+			frozen = append(frozen, node.Addr)
+		}
+	}
+
+	dumps := make([]protocol.Response, len(nodes))
+	errs := make([]error, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			dumps[i], errs[i] = cc.admin(ctx, node.Addr, protocol.AdminDump, "")
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("dumping %s: %w", node.Addr, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+	if err := errors.Join(errs...); err != nil {
+		return manifest, err
+	}
+
+	files := make(map[string][]byte)
+	for i, node := range nodes {
+		node.File = fmt.Sprintf("node-%d.json", i)
+		for _, line := range dumps[i].Values {
+			name, value, _ := strings.Cut(line, ": ")
+			switch name {
+			case "revision":
+				node.Revision, _ = strconv.ParseUint(value, 10, 64)
+			case "keys":
+				node.Keys, _ = strconv.Atoi(value)
+			}
+		}
+		manifest.Nodes = append(manifest.Nodes, node)
+		files[node.File] = []byte(dumps[i].Value)
+	}
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	write := func(name string, body []byte) error {
+		if err := tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(body)), ModTime: manifest.Created}); err != nil {
+			return err
+		}
+		_, err := tw.Write(body)
+		return err
+	}
+	if err := write(BackupManifestFile, data); err != nil {
+		return manifest, err
+	}
+	for _, node := range manifest.Nodes {
+		if err := write(node.File, files[node.File]); err != nil {
+			return manifest, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return manifest, err
+	}
+	return manifest, gz.Close()
+}
+
+// Restore replaces the keys of every server of the cluster with those of
+// a bundle written by Backup. Keys go to the servers that own them now, so
+// the cluster may have been resized since; servers owning none of them are
+// emptied. Each server is replaced at once, but not all at the same time,
+// so stop writers or FREEZE the cluster first. Expired keys are dropped.
+func (cc *ClusterClient) Restore(ctx context.Context, r io.Reader) ([]RestoreResult, error) {
+	data, err := readBundle(r)
+	if err != nil {
+		return nil, err
+	}
+	if err := cc.Refresh(ctx); err != nil {
+		return nil, err
+	}
+	nodes := cc.nodes()
+	parts := make(map[string]map[string]json.RawMessage, len(nodes))
+	for _, node := range nodes {
+		parts[node.Addr] = make(map[string]json.RawMessage)
+	}
+	for key, value := range data {
+		addr, err := cc.route(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if parts[addr] == nil {
+			return nil, fmt.Errorf("kvsclient: no server owns the slot of %q", key)
+		}
+		parts[addr][key] = value
+	}
+
+	var results []RestoreResult
+	for _, node := range nodes {
+		body, err := json.Marshal(snapshot{Data: parts[node.Addr]})
+		if err != nil {
+			return results, err
+		}
+		response, err := cc.admin(ctx, node.Addr, protocol.AdminLoad, string(body))
+		if err != nil {
+			return results, fmt.Errorf("loading %s: %w", node.Addr, err)
+		}
+		result := RestoreResult{Addr: node.Addr}
+		for _, line := range response.Values {
+			name, value, _ := strings.Cut(line, ": ")
+			n, _ := strconv.Atoi(value)
+			switch name {
+			case "loaded":
+				result.Loaded = n
+			case "expired":
+				result.Expired = n
+			case "damaged":
+				result.Damaged = n
+			}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// readBundle reads the keys of every snapshot in a bundle written by Backup
+func readBundle(r io.Reader) (map[string]json.RawMessage, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	defer gz.Close()
+	var manifest BackupManifest
+	files := make(map[string][]byte)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			return nil, err
+		}
+		files[header.Name] = body
+	}
+	body, ok := files[BackupManifestFile]
+	if !ok {
+		return nil, errors.New("kvsclient: bundle has no " + BackupManifestFile)
+	}
+	if err := json.Unmarshal(body, &manifest); err != nil {
+		return nil, fmt.Errorf("reading %s: %w", BackupManifestFile, err)
+	}
+	data := make(map[string]json.RawMessage)
+	for _, node := range manifest.Nodes {
+		body, ok := files[node.File]
+		if !ok {
+			return nil, fmt.Errorf("kvsclient: bundle has no %s for %s", node.File, node.Addr)
+		}
+		var s snapshot
+		if err := json.Unmarshal(body, &s); err != nil {
+			return nil, fmt.Errorf("reading %s: %w", node.File, err)
+		}
+		for key, value := range s.Data {
+			data[key] = value
+		}
+	}
+	return data, nil
+}
+
+// nodes lists the servers of the cluster in slot order with the slots each
+// owns, or the one server the map came from if it is standalone
+func (cc *ClusterClient) nodes() []BackupNode {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	var nodes []BackupNode
+	index := make(map[string]int)
+	for first := 0; first < len(cc.slots); {
+		addr := cc.slots[first]
+		last := first
+		for last+1 < len(cc.slots) && cc.slots[last+1] == addr {
+			last++
+		}
+		if addr != "" {
+			i, ok := index[addr]
+			if !ok {
+				i = len(nodes)
+				index[addr] = i
+				nodes = append(nodes, BackupNode{Addr: addr})
+			}
+			nodes[i].Slots = append(nodes[i].Slots, fmt.Sprintf("%d-%d", first, last))
+		}
+		first = last + 1
+	}
+	if len(nodes) == 0 {
+		nodes = append(nodes, BackupNode{Addr: cc.home})
+	}
+	return nodes
+}
+
+// admin runs an ADMIN subcommand on the server at addr
+func (cc *ClusterClient) admin(ctx context.Context, addr, sub, arg string) (protocol.Response, error) {
+	return cc.adminRequest(ctx, addr, protocol.Request{Value: sub, Key: arg})
+}
+
+// adminRequest runs request as ADMIN on the server at addr
+func (cc *ClusterClient) adminRequest(ctx context.Context, addr string, request protocol.Request) (protocol.Response, error) {
+	request.Action = protocol.ActionAdmin
+	response, err := cc.client(addr).Do(ctx, request)
+	if err == nil && !response.Success {
+		err = newKVSError(response)
+	}
+	return response, err
+}
@@ -0,0 +1,87 @@
+package kvsclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// KVSError is a request the server did not carry out, with the details it
+// sent: Code is the protocol message, such as protocol.MsgReadOnly, and
+// Retryable, Backoff and Leader are as in protocol.ErrorInfo. Servers too
+// old to send them leave those zero. Detail is the reason the server gave
+// for a BAD_REQUEST.
+//
+// Failures the package has its own error for, such as ErrReadOnly, unwrap
+// to it, so errors.Is works with either; errors.As gets the details.
+type KVSError struct {
+	Code      string
+	Retryable bool
+	Backoff   time.Duration
+	Leader    string
+	Detail    string
+
+	err error
+}
+
+func (e *KVSError) Error() string {
+	msg := e.Code
+	if e.err != nil {
+		msg = e.err.Error()
+	}
+	if e.Detail != "" {
+		msg += ": " + e.Detail
+	}
+	return msg
+}
+
+func (e *KVSError) Unwrap() error {
+	return e.err
+}
+
+// codeErrs are the failures with an error of their own
+var codeErrs = map[string]error{
+	protocol.MsgIntegrity:         ErrIntegrity,
+	protocol.MsgReadOnly:          ErrReadOnly,
+	protocol.MsgDiskFull:          ErrDiskFull,
+	protocol.MsgRateLimited:       ErrRateLimited,
+	protocol.MsgServerError:       ErrServerError,
+	protocol.MsgQuotaExceeded:     ErrQuotaExceeded,
+	protocol.MsgOutOfMemory:       ErrOutOfMemory,
+	protocol.MsgNotInteger:        ErrNotInteger,
+	protocol.MsgConflict:          ErrConflict,
+	protocol.MsgNoReadTx:          ErrReadEnded,
+	protocol.MsgWrongType:         ErrWrongType,
+	protocol.MsgNoGroup:           ErrNoGroup,
+	protocol.MsgGroupExists:       ErrGroupExists,
+	protocol.MsgNoPath:            ErrNoPath,
+	protocol.MsgInvalidJSON:       ErrInvalidJSON,
+	protocol.MsgNoQuorum:          ErrNoQuorum,
+	protocol.MsgNotEnoughReplicas: ErrNotEnoughReplicas,
+	protocol.MsgVersionGone:       ErrVersionGone,
+	protocol.MsgBadRequest:        ErrBadRequest,
+	// the request's budget ran out on the server, see protocol.Request
+	protocol.MsgCanceled: context.DeadlineExceeded,
+}
+
+// newKVSError describes the failed response
+func newKVSError(response protocol.Response) *KVSError {
+	e := &KVSError{Code: response.Message, err: codeErrs[response.Message]}
+	if response.Message == protocol.MsgBadRequest {
+		e.Detail = response.Value
+	}
+	if info := response.Error; info != nil {
+		e.Code, e.Retryable, e.Backoff, e.Leader = info.Code, info.Retryable, info.Backoff, info.Leader
+	}
+	return e
+}
+
+// messageErr is the error for the failures that have one of their own,
+// else nil
+func messageErr(response protocol.Response) error {
+	if codeErrs[response.Message] == nil {
+		return nil
+	}
+	return newKVSError(response)
+}
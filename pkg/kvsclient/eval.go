@@ -0,0 +1,40 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrScript is returned by Eval for a script that did not parse or failed
+// on the server; the error carries the reason.
+var ErrScript = errors.New("kvsclient: script failed")
+
+// Eval runs script on the server with no other client's request on keys
+// in between, see protocol.ActionEval: keys are KEYS in the script and
+// args are ARGV. It returns what the script returned, one string per
+// value, or ErrNotFound if it returned nil or false. Eval is never
+// retried, since a script may write.
+func (c *Client) Eval(ctx context.Context, script string, keys []string, args ...string) ([]string, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionEval, Value: script, Keys: keys, Values: args})
+	if err != nil {
+		return nil, err
+	}
+	if response.Message == protocol.MsgScriptError {
+		e := newKVSError(response)
+		e.err = fmt.Errorf("%w: %s", ErrScript, response.Value)
+		return nil, e
+	}
+	if _, err := simpleResult(response); err != nil {
+		return nil, err
+	}
+	if !response.Found {
+		return nil, ErrNotFound
+	}
+	if len(response.Values) > 0 {
+		return response.Values, nil
+	}
+	return []string{response.Value}, nil
+}
@@ -0,0 +1,411 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// failover waits: a client finding no primary waits failoverBackoff,
+// doubled each time up to failoverMaxBackoff, for maxFailoverAttempts
+// tries, which outlasts an election under the default failover timeout
+const (
+	failoverBackoff     = 100 * time.Millisecond
+	failoverMaxBackoff  = time.Second
+	maxFailoverAttempts = 12
+)
+
+// ReadPreference is where a FailoverClient sends reads.
+type ReadPreference int
+
+const (
+	// ReadPrimary sends reads to the primary, like writes.
+	ReadPrimary ReadPreference = iota
+	// ReadRoundRobin spreads reads over the replicas in turn.
+	ReadRoundRobin
+	// ReadNearest sends reads to the replica that has answered them
+	// fastest lately.
+	ReadNearest
+)
+
+// replicaReads are the actions a FailoverClient may send to a replica
+var replicaReads = map[string]bool{
+	protocol.ActionGet:           true,
+	protocol.ActionMGet:          true,
+	protocol.ActionStrlen:        true,
+	protocol.ActionGetBit:        true,
+	protocol.ActionBitCount:      true,
+	protocol.ActionHGet:          true,
+	protocol.ActionHGetAll:       true,
+	protocol.ActionLRange:        true,
+	protocol.ActionSMembers:      true,
+	protocol.ActionSIsMember:     true,
+	protocol.ActionSUnion:        true,
+	protocol.ActionSInter:        true,
+	protocol.ActionSDiff:         true,
+	protocol.ActionZRange:        true,
+	protocol.ActionZRangeByScore: true,
+	protocol.ActionZRank:         true,
+	protocol.ActionGeoSearch:     true,
+	protocol.ActionJSONGet:       true,
+	protocol.ActionScan:          true,
+	protocol.ActionRange:         true,
+	protocol.ActionSearch:        true,
+	protocol.ActionDBSize:        true,
+}
+
+// ErrNoPrimary is returned when none of a FailoverClient's servers is
+// primary, e.g. in the middle of an election that takes too long.
+var ErrNoPrimary = errors.New("kvsclient: no primary")
+
+// ErrNoQuorum is returned for a read with Consistency QUORUM or ALL when
+// fewer servers than that answer it, and for a write that too few have
+// in time, which the primary keeps all the same.
+var ErrNoQuorum = errors.New("kvsclient: too few servers answered")
+
+// ErrNotEnoughReplicas is returned for a write with Consistency QUORUM or
+// ALL when fewer of the primary's replicas are up than that needs, which
+// the primary keeps all the same.
+var ErrNotEnoughReplicas = errors.New("kvsclient: not enough replicas")
+
+// FailoverClient talks to the primary of a group of servers that fail
+// over among themselves, see server.FailoverGroup. It finds the primary
+// with ROLE and sends every request to it. A replica's READONLY names the
+// primary, which it follows; when the primary stops answering it asks the
+// group again, waiting out the election. Requests that may have run are
+// only sent again if they are idempotent. Reads go to the primary too,
+// unless SetReadPreference sends them to the replicas, or a Consistency
+// of QUORUM or ALL to that many servers.
+type FailoverClient struct {
+	addrs []string
+	opts  []Option
+
+	mu      sync.Mutex
+	primary string
+	clients map[string]*Client
+
+	reads        ReadPreference
+	maxStaleness time.Duration
+	readLevel    string                   // Consistency of reads that name none
+	writeLevel   string                   // and of writes
+	next         int                      // turn of the next round-robin read
+	rtt          map[string]time.Duration // smoothed time each replica took to read
+}
+
+// NewFailoverClient returns a client for the group of servers at addrs.
+// opts configure the Client used for each server.
+func NewFailoverClient(addrs []string, opts ...Option) *FailoverClient {
+	return &FailoverClient{addrs: addrs, opts: opts, clients: make(map[string]*Client), rtt: make(map[string]time.Duration)}
+}
+
+// SetReadPreference sends reads to the replicas as pref says, and writes
+// still to the primary. With maxStaleness above zero a replica further
+// behind the primary than that refuses a read with STALE, and replicas
+// that do not announce max-staleness are passed over. A read no replica
+// serves goes to the primary.
+func (fc *FailoverClient) SetReadPreference(pref ReadPreference, maxStaleness time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.reads, fc.maxStaleness = pref, maxStaleness
+}
+
+// SetConsistency sets the Consistency of the reads and the writes sent
+// without one, see protocol.Request.
+func (fc *FailoverClient) SetConsistency(read, write string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.readLevel, fc.writeLevel = read, write
+}
+
+// Primary returns the address of the primary, asking the servers in turn
+// if it is not known yet.
+func (fc *FailoverClient) Primary(ctx context.Context) (string, error) {
+	fc.mu.Lock()
+	primary := fc.primary
+	fc.mu.Unlock()
+	if primary != "" {
+		return primary, nil
+	}
+	return fc.Refresh(ctx)
+}
+
+// Refresh asks the servers which of them is primary, trusting the one
+// that says so in the latest epoch over what others say of it.
+func (fc *FailoverClient) Refresh(ctx context.Context) (string, error) {
+	var primary, named string
+	var epoch, namedEpoch uint64
+	for _, addr := range fc.addrs {
+		response, rerr := fc.client(addr).Do(ctx, protocol.Request{Action: protocol.ActionRole})
+		if rerr != nil {
+			if ctx.Err() != nil {
+				return "", rerr
+			}
+			continue
+		}
+		switch {
+		case response.Found && (primary == "" || response.Epoch > epoch):
+			primary, epoch = addr, response.Epoch
+		case response.Value != "" && (named == "" || response.Epoch > namedEpoch):
+			named, namedEpoch = response.Value, response.Epoch
+		}
+	}
+	if primary == "" && named != "" {
+		primary = named
+	}
+	if primary == "" {
+		return "", ErrNoPrimary
+	}
+	fc.mu.Lock()
+	fc.primary = primary
+	fc.mu.Unlock()
+	return primary, nil
+}
+
+// Do sends request to the primary, following READONLY to the one a
+// replica names and finding the primary again when it does not answer.
+// Reads go to a replica first under a read preference, and to every
+// server with a Consistency of QUORUM or ALL.
+func (fc *FailoverClient) Do(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	read := replicaReads[request.Action]
+	if request.Consistency == "" {
+		fc.mu.Lock()
+		request.Consistency = fc.writeLevel
+		if read {
+			request.Consistency = fc.readLevel
+		}
+		fc.mu.Unlock()
+	}
+	if read && (request.Consistency == protocol.ConsistencyQuorum || request.Consistency == protocol.ConsistencyAll) {
+		return fc.readQuorum(ctx, request)
+	}
+	if response, ok := fc.readReplica(ctx, request); ok {
+		return response, nil
+	}
+	backoff := failoverBackoff
+	for attempt := 1; ; attempt++ {
+		addr, err := fc.Primary(ctx)
+		var response protocol.Response
+		if err == nil {
+			response, err = fc.client(addr).Do(ctx, request)
+		}
+		var retry bool
+		switch {
+		case ctx.Err() != nil:
+		case errors.Is(err, ErrNoPrimary):
+			retry = true
+		case err != nil:
+			// the primary is gone, or the request went missing on the way
+			fc.forget(addr)
+			retry = classify(err) == RetryConnRefused || retryable(request)
+		case response.Message == protocol.MsgReadOnly && response.Error != nil && response.Error.Leader != "":
+			if response.Error.Leader != addr {
+				fc.setPrimary(response.Error.Leader)
+				if attempt < maxFailoverAttempts {
+					continue
+				}
+			}
+			retry = true
+		case response.Message == protocol.MsgReadOnly:
+			// a primary cut off from the group, or a replica that knows of
+			// none: an election is under way
+			fc.forget(addr)
+			retry = true
+		}
+		if !retry || attempt == maxFailoverAttempts {
+			return response, err
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return response, ctx.Err()
+		case <-timer.C:
+		}
+		backoff = min(backoff*2, failoverMaxBackoff)
+	}
+}
+
+// readReplica sends a read to the replicas in the order of the read
+// preference, reporting whether one served it
+func (fc *FailoverClient) readReplica(ctx context.Context, request protocol.Request) (protocol.Response, bool) {
+	fc.mu.Lock()
+	pref := fc.reads
+	request.MaxStaleness = fc.maxStaleness
+	fc.mu.Unlock()
+	if pref == ReadPrimary || !replicaReads[request.Action] {
+		return protocol.Response{}, false
+	}
+	for _, addr := range fc.replicas(pref) {
+		c := fc.client(addr)
+		if request.MaxStaleness > 0 && c.probe {
+			// an older server would serve the read however far behind
+			if _, err := c.hello(ctx); err != nil || !c.supports(protocol.CapStaleness) {
+				continue
+			}
+		}
+		start := time.Now()
+		response, err := c.Do(ctx, request)
+		fc.measure(addr, time.Since(start))
+		if ctx.Err() != nil {
+			break
+		}
+		if err == nil && response.Message != protocol.MsgStale {
+			return response, true
+		}
+	}
+	return protocol.Response{}, false
+}
+
+// readQuorum sends a read to every server and returns, once as many as
+// its Consistency needs have answered, the answer of the one furthest
+// along
+func (fc *FailoverClient) readQuorum(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	need := len(fc.addrs)
+	if request.Consistency == protocol.ConsistencyQuorum {
+		need = len(fc.addrs)/2 + 1
+	}
+	type answer struct {
+		response protocol.Response
+		err      error
+	}
+	answers := make(chan answer, len(fc.addrs))
+	for _, addr := range fc.addrs {
+		c := fc.client(addr)
+		go func() {
+			response, err := c.Do(ctx, request)
+			answers <- answer{response, err}
+		}()
+	}
+	var best protocol.Response
+	var lastErr error
+	got := 0
+	for range fc.addrs {
+		a := <-answers
+		switch {
+		case a.err != nil:
+			lastErr = a.err
+			continue
+		case !a.response.Success:
+			// e.g. an older server refusing the Consistency
+			lastErr = newKVSError(a.response)
+			continue
+		}
+		if got == 0 || a.response.Position > best.Position {
+			best = a.response
+		}
+		if got++; got == need {
+			return best, nil
+		}
+	}
+	if ctx.Err() != nil {
+		return best, ctx.Err()
+	}
+	if lastErr != nil {
+		return best, errors.Join(ErrNoQuorum, lastErr)
+	}
+	return best, ErrNoQuorum
+}
+
+// replicas returns the servers other than the primary, in the order pref
+// tries them
+func (fc *FailoverClient) replicas(pref ReadPreference) []string {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	var replicas []string
+	for _, addr := range fc.addrs {
+		if addr != fc.primary {
+			replicas = append(replicas, addr)
+		}
+	}
+	switch {
+	case len(replicas) == 0:
+	case pref == ReadRoundRobin:
+		first := fc.next % len(replicas)
+		fc.next++
+		replicas = append(replicas[first:], replicas[:first]...)
+	case pref == ReadNearest:
+		// replicas not measured yet come first, so each gets measured
+		sort.SliceStable(replicas, func(i, j int) bool { return fc.rtt[replicas[i]] < fc.rtt[replicas[j]] })
+	}
+	return replicas
+}
+
+// measure adds a read that took d to addr's round trip time
+func (fc *FailoverClient) measure(addr string, d time.Duration) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if rtt, ok := fc.rtt[addr]; ok {
+		d = (7*rtt + d) / 8
+	}
+	fc.rtt[addr] = d
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (fc *FailoverClient) Get(ctx context.Context, key string) (string, error) {
+	return call(ctx, fc, protocol.Request{Action: protocol.ActionGet, Key: key}, getResult)
+}
+
+// Set sets key to value with the server's default TTL.
+func (fc *FailoverClient) Set(ctx context.Context, key, value string) error {
+	return fc.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets key to value and expires it after ttl.
+func (fc *FailoverClient) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := call(ctx, fc, protocol.Request{Action: protocol.ActionSet, Key: key, Value: value, TTL: ttl}, simpleResult)
+	return err
+}
+
+// Update replaces the value of an existing key, or returns ErrNotFound.
+func (fc *FailoverClient) Update(ctx context.Context, key, value string) error {
+	_, err := call(ctx, fc, protocol.Request{Action: protocol.ActionUpdate, Key: key, Value: value}, keyedResult)
+	return err
+}
+
+// Delete removes key, or returns ErrNotFound.
+func (fc *FailoverClient) Delete(ctx context.Context, key string) error {
+	_, err := call(ctx, fc, protocol.Request{Action: protocol.ActionDelete, Key: key}, keyedResult)
+	return err
+}
+
+// Close closes the client of every server contacted.
+func (fc *FailoverClient) Close() error {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	for _, c := range fc.clients {
+		c.Close()
+	}
+	return nil
+}
+
+// setPrimary makes addr the server requests go to
+func (fc *FailoverClient) setPrimary(addr string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	fc.primary = addr
+}
+
+// forget drops addr as the primary, so the next request looks for it
+func (fc *FailoverClient) forget(addr string) {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	if fc.primary == addr {
+		fc.primary = ""
+	}
+}
+
+// client returns the Client for addr, creating it on first use
+func (fc *FailoverClient) client(addr string) *Client {
+	fc.mu.Lock()
+	defer fc.mu.Unlock()
+	c, ok := fc.clients[addr]
+	if !ok {
+		c = NewClient(addr, fc.opts...)
+		fc.clients[addr] = c
+	}
+	return c
+}
@@ -0,0 +1,78 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// GeoLocation is a member of a geo set with its longitude and latitude in
+// degrees.
+type GeoLocation struct {
+	Member   string
+	Lon, Lat float64
+}
+
+// GeoResult is a member GeoSearch found, with its distance in metres from
+// the centre of the search.
+type GeoResult struct {
+	GeoLocation
+	Distance float64
+}
+
+// GeoAdd sets the location of each member in locations in the geo set at
+// key, creating it with ttl, or the server's default if zero, if it is
+// missing, and returns how many of the members are new. Latitudes must be
+// within ±85.05112878 degrees. A geo set is a sorted set, so ZRem removes
+// members and a key that holds another type fails with ErrWrongType.
+func (c *Client) GeoAdd(ctx context.Context, key string, locations []GeoLocation, ttl time.Duration) (int, error) {
+	sorted := append([]GeoLocation(nil), locations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Member < sorted[j].Member })
+	request := protocol.Request{Action: protocol.ActionGeoAdd, Key: key, TTL: ttl}
+	for _, l := range sorted {
+		request.Keys = append(request.Keys, l.Member)
+		request.Values = append(request.Values, protocol.FormatScore(l.Lon), protocol.FormatScore(l.Lat))
+	}
+	return c.count(ctx, request)
+}
+
+// GeoSearchRadius returns the members of the geo set at key within radius
+// metres of lon and lat, nearest first, at most limit of them if limit is
+// above zero, or ErrNotFound if the set is missing.
+func (c *Client) GeoSearchRadius(ctx context.Context, key string, lon, lat, radius float64, limit int) ([]GeoResult, error) {
+	return c.geoSearch(ctx, key, limit, lon, lat, radius)
+}
+
+// GeoSearchBox is GeoSearchRadius for a box centred on lon and lat, width
+// metres east to west and height metres north to south.
+func (c *Client) GeoSearchBox(ctx context.Context, key string, lon, lat, width, height float64, limit int) ([]GeoResult, error) {
+	return c.geoSearch(ctx, key, limit, lon, lat, width, height)
+}
+
+func (c *Client) geoSearch(ctx context.Context, key string, limit int, area ...float64) ([]GeoResult, error) {
+	request := protocol.Request{Action: protocol.ActionGeoSearch, Key: key, Limit: limit}
+	for _, v := range area {
+		request.Values = append(request.Values, protocol.FormatScore(v))
+	}
+	values, err := c.items(ctx, request, getResult)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%4 != 0 {
+		return nil, errors.New("kvsclient: malformed geo reply")
+	}
+	results := make([]GeoResult, len(values)/4)
+	for i := range results {
+		var numbers [3]float64
+		for j := range numbers {
+			if numbers[j], err = protocol.ParseScore(values[4*i+1+j]); err != nil {
+				return nil, err
+			}
+		}
+		results[i] = GeoResult{GeoLocation{values[4*i], numbers[1], numbers[2]}, numbers[0]}
+	}
+	return results, nil
+}
@@ -0,0 +1,63 @@
+package kvsclient
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// HSet sets fields of the hash at key, creating it with ttl, or the
+// server's default if zero, if it is missing, and returns how many of the
+// fields are new. A key that holds a plain value fails with ErrWrongType.
+func (c *Client) HSet(ctx context.Context, key string, fields map[string]string, ttl time.Duration) (int, error) {
+	request := protocol.Request{Action: protocol.ActionHSet, Key: key, TTL: ttl}
+	for field := range fields {
+		request.Keys = append(request.Keys, field)
+	}
+	sort.Strings(request.Keys)
+	for _, field := range request.Keys {
+		request.Values = append(request.Values, fields[field])
+	}
+	return c.count(ctx, request)
+}
+
+// HGet returns field of the hash at key, or ErrNotFound if either is
+// missing.
+func (c *Client) HGet(ctx context.Context, key, field string) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionHGet, Key: key, Value: field}, getResult)
+}
+
+// HGetAll returns every field of the hash at key, or ErrNotFound if it is
+// missing.
+func (c *Client) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionHGetAll, Key: key})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := getResult(response); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]string, len(response.Values)/2)
+	for i := 0; i+1 < len(response.Values); i += 2 {
+		fields[response.Values[i]] = response.Values[i+1]
+	}
+	return fields, nil
+}
+
+// HDel removes fields from the hash at key and returns how many it had;
+// removing the last one deletes the key.
+func (c *Client) HDel(ctx context.Context, key string, fields ...string) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionHDel, Key: key, Keys: fields})
+}
+
+// count is simple for actions that return a count in Value
+func (c *Client) count(ctx context.Context, request protocol.Request) (int, error) {
+	value, err := call(ctx, c, request, simpleResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
@@ -0,0 +1,105 @@
+package kvsclient
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// serverInfo is what HELLO told the client about the server
+type serverInfo struct {
+	version int
+	caps    map[string]bool
+}
+
+// WithoutProbe skips the HELLO probe and assumes the server supports
+// everything this client does, e.g. when every server is known to be current.
+func WithoutProbe() Option {
+	return func(c *Client) { c.probe = false }
+}
+
+// ServerCapabilities returns what the server announced in HELLO, probing it
+// first if needed. An older server that does not know HELLO has none.
+func (c *Client) ServerCapabilities(ctx context.Context) ([]string, error) {
+	info, err := c.hello(ctx)
+	if err != nil {
+		return nil, err
+	}
+	caps := make([]string, 0, len(info.caps))
+	for capability := range info.caps {
+		caps = append(caps, capability)
+	}
+	return caps, nil
+}
+
+// supports reports whether the server announced capability; without a
+// probe everything is assumed supported
+func (c *Client) supports(capability string) bool {
+	c.helloMu.Lock()
+	defer c.helloMu.Unlock()
+	return c.info == nil || c.info.caps[capability]
+}
+
+// hello probes the server once and adapts the client to it: servers that
+// close the connection after each request are not pooled, and a codec the
+// server does not speak falls back to gob. The probe uses the client's
+// codec, so it works on a listener that speaks only that, and is retried
+// in gob, which every server version speaks, if the server hangs up on it.
+func (c *Client) hello(ctx context.Context) (*serverInfo, error) {
+	c.helloMu.Lock()
+	defer c.helloMu.Unlock()
+	if c.info != nil {
+		return c.info, nil
+	}
+
+	codec := c.codec
+	cn, response, err := c.sayHello(ctx, codec)
+	if err != nil && codec != protocol.Gob && ctx.Err() == nil {
+		codec = protocol.Gob
+		cn, response, err = c.sayHello(ctx, codec)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	info := &serverInfo{caps: make(map[string]bool)}
+	if response.Success {
+		info.version, _ = strconv.Atoi(response.Value)
+		for _, capability := range response.Values {
+			info.caps[capability] = true
+		}
+	}
+	if codec != c.codec || !info.caps[protocol.CodecCapability(c.codec.Name())] {
+		c.codec = protocol.Gob
+	}
+	c.info = info
+
+	// the probe connection is as good as any other if it can be reused
+	if !info.caps[protocol.CapPersistent] || codec != c.codec || !c.pool.adopt(cn) {
+		cn.Close()
+	}
+	return info, nil
+}
+
+// sayHello sends HELLO in codec on a new connection and returns it with the
+// answer
+func (c *Client) sayHello(ctx context.Context, codec protocol.Codec) (*conn, protocol.Response, error) {
+	var response protocol.Response
+	cn, err := c.dial(ctx, codec)
+	if err != nil {
+		return nil, response, err
+	}
+	stop := cn.watch(ctx, c.timeout)
+	err = cn.enc.Encode(protocol.Request{Action: protocol.ActionHello, Value: strconv.Itoa(protocol.Version)})
+	if err == nil {
+		err = cn.dec.Decode(&response)
+	}
+	if !stop() || err != nil {
+		cn.Close()
+		return nil, response, contextErr(ctx, err)
+	}
+	cn.SetDeadline(time.Time{})
+	return cn, response, nil
+}
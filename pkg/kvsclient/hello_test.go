@@ -0,0 +1,79 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// legacyServer serves one gob request per connection, as servers did
+// before HELLO, answering it with handle, and returns its address
+func legacyServer(t *testing.T, handle func(protocol.Request) protocol.Response) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				var request protocol.Request
+				if err := protocol.Gob.NewDecoder(conn).Decode(&request); err != nil {
+					return
+				}
+				protocol.Gob.NewEncoder(conn).Encode(handle(request))
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestClientFallsBackForServersWithoutHello(t *testing.T) {
+	var hellos, gets atomic.Int32
+	addr := legacyServer(t, func(request protocol.Request) protocol.Response {
+		switch request.Action {
+		case protocol.ActionHello:
+			hellos.Add(1)
+			return protocol.Response{Message: protocol.MsgInvalidAction}
+		case protocol.ActionGet:
+			gets.Add(1)
+			return protocol.Response{Success: true, Found: true, Value: "v"}
+		}
+		return protocol.Response{Message: protocol.MsgInvalidAction}
+	})
+	// the JSON probe can't be read by a gob server, which hangs up on it
+	c := NewClient(addr, WithCodec(protocol.JSON))
+	defer c.Close()
+	ctx := context.Background()
+	for i := 0; i < 3; i++ {
+		if value, err := c.Get(ctx, "k"); err != nil || value != "v" {
+			t.Fatalf("Get %d = %q, %v", i, value, err)
+		}
+	}
+	if c.codec != protocol.Gob {
+		t.Errorf("codec %s, want the gob fallback", c.codec.Name())
+	}
+	caps, err := c.ServerCapabilities(ctx)
+	if err != nil || len(caps) != 0 {
+		t.Errorf("ServerCapabilities = %v, %v; want none", caps, err)
+	}
+	if _, err := c.GetAsync(ctx, "k").Wait(ctx); !errors.Is(err, ErrNoPipelining) {
+		t.Errorf("GetAsync = %v, want ErrNoPipelining", err)
+	}
+	if n := hellos.Load(); n != 1 {
+		t.Errorf("probed %d times, want once", n)
+	}
+	if n := gets.Load(); n != 3 {
+		t.Errorf("server saw %d GETs, want 3", n)
+	}
+}
@@ -0,0 +1,55 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"strconv"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrVersionGone is returned by GetAt and Diff for a revision the server
+// no longer keeps the key's value at, or for a key whose history it does
+// not keep.
+var ErrVersionGone = errors.New("kvsclient: version no longer kept")
+
+// GetAt returns the value key had right after the write at revision rev,
+// from the history the server keeps, or ErrNotFound if it did not exist
+// then.
+func (c *Client) GetAt(ctx context.Context, key string, rev uint64) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionGet, Key: key, Revision: rev}, getResult)
+}
+
+// History returns the values key has had, newest first, as the writes that
+// gave them: its current value, then those the server keeps, and a DELETE
+// wherever the key was removed before it was set again; at most limit if
+// it is above zero.
+func (c *Client) History(ctx context.Context, key string, limit int) ([]protocol.JournalEntry, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionHistory, Key: key, Limit: limit})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return nil, err
+	}
+	return response.Journal, nil
+}
+
+// Diff compares the value key had at revision from with the one it had at
+// revision to, or has now if to is zero, line by line: the lines only the
+// first has are prefixed "-", those only the second has "+" and the ones
+// they share " ".
+func (c *Client) Diff(ctx context.Context, key string, from, to uint64) ([]string, error) {
+	request := protocol.Request{Action: protocol.ActionDiff, Key: key, Revision: from}
+	if to > 0 {
+		request.Value = strconv.FormatUint(to, 10)
+	}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return nil, err
+	}
+	return response.Values, nil
+}
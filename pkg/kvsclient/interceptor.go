@@ -0,0 +1,64 @@
+package kvsclient
+
+import (
+	"context"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Handler sends one request and returns the server's response.
+type Handler func(ctx context.Context, request protocol.Request) (protocol.Response, error)
+
+// Interceptor wraps a Handler to add behaviour around every request, e.g.
+// logging, metrics or tracing. It may change the request or the response,
+// or answer without calling next at all.
+type Interceptor func(next Handler) Handler
+
+// WithInterceptors runs every request sent through Do, and so through the
+// typed methods, through interceptors; the first one is the outermost. An
+// interceptor sees one call however often it is retried. The async calls
+// write to the pipelined connection directly and skip interceptors, which
+// could not run without breaking the order of the pipeline.
+func WithInterceptors(interceptors ...Interceptor) Option {
+	return func(c *Client) { c.interceptors = append(c.interceptors, interceptors...) }
+}
+
+// WithAttemptInterceptors runs every network attempt through interceptors,
+// the first one outermost: unlike WithInterceptors they see each retry on
+// its own, so they suit per-attempt latency metrics and trace spans.
+// AttemptFromContext tells them which server and which try it is.
+func WithAttemptInterceptors(interceptors ...Interceptor) Option {
+	return func(c *Client) { c.attemptInterceptors = append(c.attemptInterceptors, interceptors...) }
+}
+
+// Attempt is one try at sending a request, see WithAttemptInterceptors.
+type Attempt struct {
+	Addr string // the server it goes to
+	N    int    // 1 for the first try, 2 for the first retry and so on
+}
+
+type attemptKey struct{}
+
+// AttemptFromContext returns the attempt an attempt interceptor is
+// running; ok is false in a context that is not one's.
+func AttemptFromContext(ctx context.Context) (attempt Attempt, ok bool) {
+	attempt, ok = ctx.Value(attemptKey{}).(Attempt)
+	return attempt, ok
+}
+
+// chain wraps h in interceptors, the first one outermost
+func chain(h Handler, interceptors []Interceptor) Handler {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		h = interceptors[i](h)
+	}
+	return h
+}
+
+// try makes attempt n at request through the attempt interceptors
+func (c *Client) try(ctx context.Context, request protocol.Request, n int) (protocol.Response, error) {
+	if len(c.attemptInterceptors) == 0 {
+		return c.roundTrip(ctx, request)
+	}
+	ctx = context.WithValue(ctx, attemptKey{}, Attempt{Addr: c.addr, N: n})
+	return c.attempt(ctx, request)
+}
@@ -0,0 +1,70 @@
+package kvsclient
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestInterceptorsWrapEveryCall(t *testing.T) {
+	// the first GET fails in a way worth retrying, the second succeeds
+	var gets atomic.Int32
+	addr := legacyServer(t, func(request protocol.Request) protocol.Response {
+		if request.Action == protocol.ActionGet && gets.Add(1) == 1 {
+			return protocol.Response{Message: protocol.MsgServerError, Error: &protocol.ErrorInfo{Code: protocol.MsgServerError, Retryable: true}}
+		}
+		return protocol.Response{Success: true, Found: true, Value: "v"}
+	})
+	var mu sync.Mutex
+	var trace []string
+	record := func(name string) Interceptor {
+		return func(next Handler) Handler {
+			return func(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+				mu.Lock()
+				trace = append(trace, name+" "+request.Action)
+				mu.Unlock()
+				return next(ctx, request)
+			}
+		}
+	}
+	var attempts []int
+	attempt := func(next Handler) Handler {
+		return func(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+			if a, ok := AttemptFromContext(ctx); ok && a.Addr == addr {
+				attempts = append(attempts, a.N)
+			}
+			return next(ctx, request)
+		}
+	}
+	c := NewClient(addr,
+		WithInterceptors(record("outer"), record("inner")),
+		WithAttemptInterceptors(attempt),
+		WithRetry(RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond, RetryOn: RetryServerHint}))
+	defer c.Close()
+	if value, err := c.Get(context.Background(), "k"); err != nil || value != "v" {
+		t.Fatalf("Get = %q, %v", value, err)
+	}
+	if want := []string{"outer GET", "inner GET"}; !slices.Equal(trace, want) {
+		t.Errorf("interceptors saw %v, want %v once across the retry", trace, want)
+	}
+	if !slices.Equal(attempts, []int{1, 2}) {
+		t.Errorf("attempt interceptor saw attempts %v, want [1 2]", attempts)
+	}
+}
+
+func TestInterceptorCanAnswer(t *testing.T) {
+	c := NewClient("127.0.0.1:1", WithoutProbe(), WithInterceptors(func(next Handler) Handler {
+		return func(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+			return protocol.Response{Success: true, Found: true, Value: "from the interceptor"}, nil
+		}
+	}))
+	defer c.Close()
+	if value, err := c.Get(context.Background(), "k"); err != nil || value != "from the interceptor" {
+		t.Errorf("Get = %q, %v", value, err)
+	}
+}
@@ -0,0 +1,57 @@
+package kvsclient
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrNoPath is returned by JSONSet for a path whose parent is not in the
+// document.
+var ErrNoPath = errors.New("kvsclient: no such path in the document")
+
+// ErrInvalidJSON is returned by JSONSet for a value that is not JSON.
+var ErrInvalidJSON = errors.New("kvsclient: invalid JSON")
+
+// JSONSet sets the part of the JSON document at key that path selects,
+// such as $.users[0].name, to v encoded as JSON, in one step on the
+// server. The root path, "$" or "", replaces the whole document, creating
+// key with ttl, or the server's default if zero, if it is missing. Any
+// other path adds or replaces a member of an existing object, or replaces
+// an element of an existing array, and fails with ErrNoPath otherwise. A
+// key that holds another type fails with ErrWrongType.
+func (c *Client) JSONSet(ctx context.Context, key, path string, v any, ttl time.Duration) error {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionJSONSet, Key: key, Path: path, Value: string(value), TTL: ttl})
+}
+
+// JSONGet decodes the part of the JSON document at key that path selects
+// into v, as json.Unmarshal does, or returns ErrNotFound if the key is
+// missing or the path selects nothing.
+func (c *Client) JSONGet(ctx context.Context, key, path string, v any) error {
+	value, err := c.JSONGetRaw(ctx, key, path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal([]byte(value), v)
+}
+
+// JSONGetRaw is JSONGet returning the JSON as the server sent it.
+func (c *Client) JSONGetRaw(ctx context.Context, key, path string) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionJSONGet, Key: key, Path: path}, jsonResult)
+}
+
+// jsonResult is getResult that also fails on a path the server couldn't
+// parse
+func jsonResult(response protocol.Response) (string, error) {
+	if !response.Success {
+		return simpleResult(response)
+	}
+	return getResult(response)
+}
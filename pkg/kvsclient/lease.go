@@ -0,0 +1,90 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrLocked is returned by AcquireLease when another owner holds the lock.
+var ErrLocked = errors.New("kvsclient: locked by another owner")
+
+// ErrLeaseLost is returned by Renew and Release once the lease has lapsed
+// or the lock's key was written by someone else.
+var ErrLeaseLost = errors.New("kvsclient: lease lost")
+
+// Lease is a lease lock held on Key. Token is its fencing token: tokens
+// grow with every lock the server grants, so pass it along with every
+// write to the resource the lock guards, and have the resource refuse any
+// token lower than the highest it has seen.
+type Lease struct {
+	Key   string
+	Owner string
+	Token uint64
+	c     *Client
+}
+
+// AcquireLease takes the lease lock on key for owner, for ttl or the
+// server's lock lease if zero. It does not wait: a lock another owner
+// holds fails at once with ErrLocked, wrapped in a *LockedError naming
+// the holder.
+func (c *Client) AcquireLease(ctx context.Context, key, owner string, ttl time.Duration) (*Lease, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionLock, Key: key, Owner: owner, TTL: ttl})
+	if err != nil {
+		return nil, err
+	}
+	if !response.Success {
+		if response.Message == protocol.MsgLocked {
+			return nil, &LockedError{Holder: response.Value, Token: response.Revision}
+		}
+		return nil, newKVSError(response)
+	}
+	return &Lease{Key: key, Owner: owner, Token: response.Revision, c: c}, nil
+}
+
+// JoinLease returns the lease with token that owner holds on key, for
+// example one AcquireLease returned in another process.
+func (c *Client) JoinLease(key, owner string, token uint64) *Lease {
+	return &Lease{Key: key, Owner: owner, Token: token, c: c}
+}
+
+// LockedError is the lock AcquireLease found held.
+type LockedError struct {
+	Holder string
+	Token  uint64
+}
+
+func (e *LockedError) Error() string {
+	return "kvsclient: locked by " + e.Holder
+}
+
+func (e *LockedError) Unwrap() error {
+	return ErrLocked
+}
+
+// Renew restarts the lease, for ttl or the lease it had if zero. Renew
+// well before the lease lapses: after that it fails with ErrLeaseLost and
+// the lock may already be someone else's.
+func (l *Lease) Renew(ctx context.Context, ttl time.Duration) error {
+	return l.send(ctx, protocol.Request{Action: protocol.ActionRenew, Key: l.Key, Revision: l.Token, TTL: ttl})
+}
+
+// Release gives the lock up, or fails with ErrLeaseLost if it had already
+// lapsed.
+func (l *Lease) Release(ctx context.Context) error {
+	return l.send(ctx, protocol.Request{Action: protocol.ActionUnlock, Key: l.Key, Owner: l.Owner, Revision: l.Token})
+}
+
+func (l *Lease) send(ctx context.Context, request protocol.Request) error {
+	response, err := l.c.Do(ctx, request)
+	if err != nil {
+		return err
+	}
+	if response.Message == protocol.MsgLockNotHeld {
+		return ErrLeaseLost
+	}
+	_, err = simpleResult(response)
+	return err
+}
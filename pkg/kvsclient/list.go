@@ -0,0 +1,71 @@
+package kvsclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// LPush adds items to the head of the list at key, one after another so
+// the last ends up first, creating it with ttl, or the server's default if
+// zero, if it is missing, and returns the list's length. A key that holds
+// another type fails with ErrWrongType.
+func (c *Client) LPush(ctx context.Context, key string, items []string, ttl time.Duration) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionLPush, Key: key, Values: items, TTL: ttl})
+}
+
+// RPush adds items to the tail of the list at key, in order, see LPush.
+func (c *Client) RPush(ctx context.Context, key string, items []string, ttl time.Duration) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionRPush, Key: key, Values: items, TTL: ttl})
+}
+
+// LPop removes and returns the first item of the list at key, or
+// ErrNotFound if it is empty; removing the last item deletes the key.
+func (c *Client) LPop(ctx context.Context, key string) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionLPop, Key: key}, getResult)
+}
+
+// RPop removes and returns the last item of the list at key, see LPop.
+func (c *Client) RPop(ctx context.Context, key string) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionRPop, Key: key}, getResult)
+}
+
+// LPopN removes and returns up to n items from the head of the list at
+// key, none if it is empty.
+func (c *Client) LPopN(ctx context.Context, key string, n int) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionLPop, Key: key, Limit: max(n, 1)}, simpleResult)
+}
+
+// RPopN removes and returns up to n items from the tail of the list at
+// key, last first, see LPopN.
+func (c *Client) RPopN(ctx context.Context, key string, n int) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionRPop, Key: key, Limit: max(n, 1)}, simpleResult)
+}
+
+// BLPop is LPop that, while the list is empty, waits up to wait, or the
+// server's default if zero, for an item; ErrNotFound means none came. The
+// server caps the wait, see server.MaxPopWait.
+func (c *Client) BLPop(ctx context.Context, key string, wait time.Duration) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionBLPop, Key: key, Timeout: wait}, getResult)
+}
+
+// LRange returns the items of the list at key from index start to stop,
+// both included, or ErrNotFound if it is missing. Negative indexes count
+// from the end, so LRange(ctx, key, 0, -1) returns the whole list.
+func (c *Client) LRange(ctx context.Context, key string, start, stop int) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionLRange, Key: key, Start: start, Stop: stop}, getResult)
+}
+
+// items sends request and returns the Values of its response, once result
+// found no error in it
+func (c *Client) items(ctx context.Context, request protocol.Request, result func(protocol.Response) (string, error)) ([]string, error) {
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := result(response); err != nil {
+		return nil, err
+	}
+	return response.Values, nil
+}
@@ -0,0 +1,83 @@
+package kvsclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Listen subscribes the durable subscriber name to patterns, channel names
+// or patterns like "news.*", and delivers its messages on the returned
+// channel until ctx is done; then the channel is closed. Messages are
+// acknowledged once they have been received from the channel, so anything
+// not taken yet comes back on the next Listen. When the connection fails
+// Listen reconnects, subscribes again in case the server lost the
+// subscription, and carries on. The error is for the first subscribe only.
+func (c *Client) Listen(ctx context.Context, name string, patterns ...string) (<-chan protocol.Message, error) {
+	if err := c.subscribeAll(ctx, name, patterns); err != nil {
+		return nil, err
+	}
+	msgs := make(chan protocol.Message)
+	go c.listen(ctx, name, patterns, msgs)
+	return msgs, nil
+}
+
+func (c *Client) listen(ctx context.Context, name string, patterns []string, msgs chan<- protocol.Message) {
+	defer close(msgs)
+	backoff := watchBackoff
+	resubscribe := false
+	for ctx.Err() == nil {
+		if resubscribe {
+			if err := c.subscribeAll(ctx, name, patterns); err != nil {
+				sleepCtx(ctx, backoff)
+				backoff = min(backoff*2, watchMaxBackoff)
+				continue
+			}
+			resubscribe = false
+		}
+		batch, err := c.Fetch(ctx, name, watchPoll)
+		if err != nil {
+			resubscribe = true
+			sleepCtx(ctx, backoff)
+			backoff = min(backoff*2, watchMaxBackoff)
+			continue
+		}
+		backoff = watchBackoff
+		if len(batch) == 0 {
+			// the poll timed out, or the server is going away
+			sleepCtx(ctx, watchBackoff)
+			continue
+		}
+		for _, msg := range batch {
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+		if err := c.Ack(ctx, name, batch[len(batch)-1].ID); err != nil {
+			// unacknowledged messages are fetched again, so a retry is safe
+			resubscribe = true
+		}
+	}
+}
+
+func (c *Client) subscribeAll(ctx context.Context, name string, patterns []string) error {
+	for _, pattern := range patterns {
+		if err := c.Subscribe(ctx, name, pattern); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sleepCtx waits for d, or less if ctx is done first
+func sleepCtx(ctx context.Context, d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+	case <-timer.C:
+	}
+}
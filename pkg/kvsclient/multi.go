@@ -0,0 +1,135 @@
+package kvsclient
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Multi is a transaction: requests queued on it are sent together by Exec,
+// and the server runs them one after another with no other client's
+// request in between. A request that fails does not stop the rest, and
+// writes are not rolled back. A Multi is not safe for concurrent use.
+type Multi struct {
+	c        *Client
+	session  *Session // runs it after the session's WATCH, if set
+	requests []protocol.Request
+}
+
+// Multi starts a transaction.
+func (c *Client) Multi() *Multi {
+	return &Multi{c: c}
+}
+
+// Queue adds request to the transaction. Only requests on keys, such as
+// GET, SET, INCR or MSET, can be queued; Exec fails for any other.
+func (m *Multi) Queue(request protocol.Request) *Multi {
+	m.requests = append(m.requests, request)
+	return m
+}
+
+// Get queues a GET of key.
+func (m *Multi) Get(key string) *Multi {
+	return m.Queue(protocol.Request{Action: protocol.ActionGet, Key: key})
+}
+
+// Set queues a SET of key to value with the server's default TTL.
+func (m *Multi) Set(key, value string) *Multi {
+	return m.Queue(protocol.Request{Action: protocol.ActionSet, Key: key, Value: value})
+}
+
+// Delete queues a DELETE of key.
+func (m *Multi) Delete(key string) *Multi {
+	return m.Queue(protocol.Request{Action: protocol.ActionDelete, Key: key})
+}
+
+// IncrBy queues an INCRBY of key by delta.
+func (m *Multi) IncrBy(key string, delta int64) *Multi {
+	return m.Queue(protocol.Request{Action: protocol.ActionIncrBy, Key: key, Value: fmt.Sprint(delta)})
+}
+
+// Exec runs the queued requests and returns their responses, in order. It
+// fails, running none of them, if the server refuses to queue one, or with
+// ErrConflict if a key the session watches was written since WATCH.
+func (m *Multi) Exec(ctx context.Context) ([]protocol.Response, error) {
+	requests := make([]protocol.Request, 0, len(m.requests)+2)
+	requests = append(requests, protocol.Request{Action: protocol.ActionMulti})
+	for _, request := range m.requests {
+		requests = append(requests, m.c.withChecksum(request))
+	}
+	requests = append(requests, protocol.Request{Action: protocol.ActionExec})
+	var responses []protocol.Response
+	var err error
+	if m.session != nil {
+		responses, err = m.session.exchange(ctx, requests)
+	} else {
+		responses, err = m.c.exchange(ctx, requests)
+	}
+	if err != nil {
+		return nil, err
+	}
+	for i, response := range responses[:len(responses)-1] {
+		if !response.Success {
+			return nil, fmt.Errorf("%s: %w", requests[i].Action, newKVSError(response))
+		}
+	}
+	exec := responses[len(responses)-1]
+	if !exec.Success {
+		return nil, newKVSError(exec)
+	}
+	return exec.Replies, nil
+}
+
+// exchange sends requests in order on one pooled connection, which no other
+// request uses meanwhile, and returns their responses. The requests are
+// written while the responses are read, so neither side's buffers fill up.
+func (c *Client) exchange(ctx context.Context, requests []protocol.Request) ([]protocol.Response, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	responses, err := c.exchangeOn(ctx, cn, requests)
+	c.put(cn, err != nil)
+	return responses, err
+}
+
+// exchangeOn is exchange on cn, which must not be reused if it fails
+func (c *Client) exchangeOn(ctx context.Context, cn *conn, requests []protocol.Request) ([]protocol.Response, error) {
+	budget := c.budget(ctx, protocol.Request{})
+	stop := cn.watch(ctx, c.requestTimeout(protocol.Request{}))
+	sent := make(chan error, 1)
+	go func() {
+		for _, request := range requests {
+			if request.Token == "" {
+				request.Token = c.token
+			}
+			request.Budget = budget
+			if err := cn.enc.Encode(request); err != nil {
+				sent <- err
+				return
+			}
+		}
+		sent <- nil
+	}()
+	responses := make([]protocol.Response, len(requests))
+	var err error
+	for i := range responses {
+		if err = cn.dec.Decode(&responses[i]); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = <-sent
+	}
+	if !stop() && err == nil {
+		err = ctx.Err()
+	}
+	if err != nil {
+		// the writer stops too once the connection is closed
+		return nil, contextErr(ctx, err)
+	}
+	cn.SetDeadline(time.Time{})
+	return responses, nil
+}
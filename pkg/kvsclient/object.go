@@ -0,0 +1,71 @@
+package kvsclient
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// KeyInfo is what the server knows of a key besides its value, see Object.
+// LastAccess is zero unless the server tracks it for its maxmemory policy.
+type KeyInfo struct {
+	Type       string
+	Revision   uint64
+	Size       int64
+	Written    time.Time
+	Expires    time.Time
+	TTL        time.Duration
+	LastAccess time.Time
+}
+
+// Type returns the type of the value at key: string, hash, list, set,
+// zset, stream, json or sequence, or ErrNotFound.
+func (c *Client) Type(ctx context.Context, key string) (string, error) {
+	return call(ctx, c, protocol.Request{Action: protocol.ActionType, Key: key}, keyedResult)
+}
+
+// MemoryUsage estimates the bytes key takes on the server: key, value and
+// the storage engine's overhead for the entry, or returns ErrNotFound.
+func (c *Client) MemoryUsage(ctx context.Context, key string) (int64, error) {
+	total, err := call(ctx, c, protocol.Request{Action: protocol.ActionMemoryUsage, Key: key}, keyedResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(total, 10, 64)
+}
+
+// Object describes key without reading its value, or returns ErrNotFound.
+func (c *Client) Object(ctx context.Context, key string) (KeyInfo, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionObject, Key: key})
+	if err != nil {
+		return KeyInfo{}, err
+	}
+	if _, err := keyedResult(response); err != nil {
+		return KeyInfo{}, err
+	}
+	var info KeyInfo
+	for _, line := range response.Values {
+		name, value, _ := strings.Cut(line, ": ")
+		switch name {
+		case "type":
+			info.Type = value
+		case "revision":
+			info.Revision, _ = strconv.ParseUint(value, 10, 64)
+		case "bytes":
+			info.Size, _ = strconv.ParseInt(value, 10, 64)
+		case "written":
+			info.Written, _ = time.Parse(time.RFC3339Nano, value)
+		case "expires":
+			info.Expires, _ = time.Parse(time.RFC3339Nano, value)
+		case "ttl_ms":
+			ms, _ := strconv.ParseInt(value, 10, 64)
+			info.TTL = time.Duration(ms) * time.Millisecond
+		case "last_access":
+			info.LastAccess, _ = time.Parse(time.RFC3339Nano, value)
+		}
+	}
+	return info, nil
+}
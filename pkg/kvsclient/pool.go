@@ -0,0 +1,235 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// default pool limits
+const (
+	DefaultMaxIdle     = 8
+	DefaultIdleTimeout = time.Minute
+)
+
+// connections idle for less than this are reused without a liveness probe
+const healthCheckAfter = time.Second
+
+// ErrClosed is returned by calls on a closed Client.
+var ErrClosed = errors.New("kvsclient: client closed")
+
+// WithMaxIdle sets how many idle connections are kept for reuse.
+func WithMaxIdle(n int) Option {
+	return func(c *Client) { c.pool.maxIdle = n }
+}
+
+// WithMaxActive caps the connections open at once, idle or busy; callers
+// wait for a free one when the cap is reached. Zero means no cap.
+func WithMaxActive(n int) Option {
+	return func(c *Client) { c.pool.maxActive = n }
+}
+
+// WithIdleTimeout sets how long an idle connection may be reused.
+func WithIdleTimeout(d time.Duration) Option {
+	return func(c *Client) { c.pool.idleTimeout = d }
+}
+
+// conn is a pooled connection with the codec streams bound to it
+type conn struct {
+	net.Conn
+	enc      protocol.Encoder
+	dec      protocol.Decoder
+	lastUsed time.Time
+}
+
+// pool keeps connections to one server for reuse
+type pool struct {
+	maxIdle     int
+	maxActive   int
+	idleTimeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*conn
+	active int
+	closed bool
+	freed  chan struct{}
+}
+
+// get returns a healthy idle connection or dials a new one
+func (c *Client) get(ctx context.Context) (*conn, error) {
+	if c.probe {
+		if _, err := c.hello(ctx); err != nil {
+			return nil, err
+		}
+	}
+	p := &c.pool
+	var deadline <-chan time.Time
+	for {
+		p.mu.Lock()
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrClosed
+		}
+		for len(p.idle) > 0 {
+			cn := p.idle[len(p.idle)-1]
+			p.idle = p.idle[:len(p.idle)-1]
+			if p.healthy(cn) {
+				p.mu.Unlock()
+				return cn, nil
+			}
+			cn.Close()
+			p.active--
+		}
+		if p.maxActive <= 0 || p.active < p.maxActive {
+			p.active++
+			p.mu.Unlock()
+			cn, err := c.dial(ctx, c.codec)
+			if err != nil {
+				p.release()
+			}
+			return cn, err
+		}
+		if p.freed == nil {
+			p.freed = make(chan struct{})
+		}
+		freed := p.freed
+		p.mu.Unlock()
+
+		// wait for a connection to come back, but no longer than a dial may take
+		if deadline == nil && c.dialTimeout > 0 {
+			deadline = time.After(c.dialTimeout)
+		}
+		select {
+		case <-freed:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-deadline:
+			return nil, errors.New("kvsclient: timed out waiting for a free connection")
+		}
+	}
+}
+
+// put returns cn to the pool, or closes it if it broke or the pool is full
+func (c *Client) put(cn *conn, broken bool) {
+	p := &c.pool
+	p.mu.Lock()
+	if broken || p.closed || len(p.idle) >= p.maxIdle || !c.supports(protocol.CapPersistent) {
+		p.mu.Unlock()
+		cn.Close()
+		p.release()
+		return
+	}
+	cn.lastUsed = time.Now()
+	p.idle = append(p.idle, cn)
+	p.signal()
+	p.mu.Unlock()
+}
+
+// dial connects to the server, giving up when ctx is done
+func (c *Client) dial(ctx context.Context, codec protocol.Codec) (*conn, error) {
+	d := net.Dialer{Timeout: c.dialTimeout}
+	nc, err := d.DialContext(ctx, "tcp", c.addr)
+	if err != nil {
+		return nil, err
+	}
+	return &conn{Conn: nc, enc: codec.NewEncoder(nc), dec: codec.NewDecoder(nc)}, nil
+}
+
+// watch bounds the next round trip on cn by timeout and by ctx's deadline,
+// and interrupts it if ctx is cancelled. The returned stop reports false if
+// ctx interrupted the connection, which then must not be reused.
+func (cn *conn) watch(ctx context.Context, timeout time.Duration) (stop func() bool) {
+	return watchDeadline(ctx, timeout, cn.SetDeadline)
+}
+
+// watchDeadline is watch for one direction of the connection, set is the
+// matching SetDeadline method
+func watchDeadline(ctx context.Context, timeout time.Duration, set func(time.Time) error) (stop func() bool) {
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if d, ok := ctx.Deadline(); ok && (deadline.IsZero() || d.Before(deadline)) {
+		deadline = d
+	}
+	set(deadline)
+	return context.AfterFunc(ctx, func() {
+		// a deadline in the past wakes any blocked read or write at once
+		set(time.Unix(1, 0))
+	})
+}
+
+// Close closes the idle connections and the pipelined one; busy ones are
+// closed as they come back.
+func (c *Client) Close() error {
+	p := &c.pool
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.active -= len(idle)
+	p.signal()
+	p.mu.Unlock()
+	for _, cn := range idle {
+		cn.Close()
+	}
+	c.pipe.close()
+	if c.cache != nil {
+		c.cache.close()
+	}
+	return nil
+}
+
+// adopt adds a connection dialed outside the pool as idle, if there is room
+func (p *pool) adopt(cn *conn) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed || len(p.idle) >= p.maxIdle || p.maxActive > 0 && p.active >= p.maxActive {
+		return false
+	}
+	p.active++
+	cn.lastUsed = time.Now()
+	p.idle = append(p.idle, cn)
+	p.signal()
+	return true
+}
+
+// release gives up one active slot
+func (p *pool) release() {
+	p.mu.Lock()
+	p.active--
+	p.signal()
+	p.mu.Unlock()
+}
+
+// signal wakes callers waiting for a slot, caller must hold p.mu
+func (p *pool) signal() {
+	if p.freed != nil {
+		close(p.freed)
+		p.freed = nil
+	}
+}
+
+// healthy reports whether an idle connection can be reused: it must not
+// have sat idle too long, and the server must not have closed it. Nothing
+// is expected from the server while idle, so any byte or error other than
+// a timeout means the connection is dead.
+func (p *pool) healthy(cn *conn) bool {
+	idle := time.Since(cn.lastUsed)
+	if p.idleTimeout > 0 && idle > p.idleTimeout {
+		return false
+	}
+	if idle < healthCheckAfter {
+		return true
+	}
+	var one [1]byte
+	cn.SetReadDeadline(time.Now().Add(time.Millisecond))
+	n, err := cn.Read(one[:])
+	cn.SetReadDeadline(time.Time{})
+	return n == 0 && errors.Is(err, os.ErrDeadlineExceeded)
+}
@@ -0,0 +1,60 @@
+package kvsclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ReadTx is a read transaction, a point-in-time view of the server's keys:
+// its reads see every key as it was at Revision, however many writes land
+// meanwhile. It ends with End or after the TTL it was opened with, and
+// then its reads return ErrReadEnded. In a cluster it covers the keys of
+// one server.
+//
+// ID names the transaction on the server, so other processes can read in
+// it too, see JoinRead.
+type ReadTx struct {
+	ID       string
+	Revision uint64
+
+	c *Client
+}
+
+// BeginRead opens a read transaction that stays open for ttl, the
+// server's default if zero, unless ended first.
+func (c *Client) BeginRead(ctx context.Context, ttl time.Duration) (*ReadTx, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionBeginRead, TTL: ttl})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return nil, err
+	}
+	return &ReadTx{ID: response.Value, Revision: response.Revision, c: c}, nil
+}
+
+// JoinRead reads in the read transaction id, opened by BeginRead on
+// another Client; its Revision is left zero.
+func (c *Client) JoinRead(id string) *ReadTx {
+	return &ReadTx{ID: id, c: c}
+}
+
+// Get returns the value key had at the transaction's revision, or
+// ErrNotFound.
+func (tx *ReadTx) Get(ctx context.Context, key string) (string, error) {
+	return call(ctx, tx.c, protocol.Request{Action: protocol.ActionGet, Key: key, ReadTx: tx.ID}, getResult)
+}
+
+// MGet is Client.MGet at the transaction's revision: however many round
+// trips it takes, no write lands between the reads of any two keys.
+func (tx *ReadTx) MGet(ctx context.Context, keys ...string) (map[string]string, error) {
+	return tx.c.mget(ctx, tx.ID, keys)
+}
+
+// End closes the transaction, so the server can drop the old values it
+// kept for it.
+func (tx *ReadTx) End(ctx context.Context) error {
+	return tx.c.simple(ctx, protocol.Request{Action: protocol.ActionEndRead, ReadTx: tx.ID})
+}
@@ -0,0 +1,34 @@
+package kvsclient
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// WithRequestIDs gives every request that is not idempotent, such as INCR,
+// APPEND or CAS, a random protocol.Request.RequestID, unless it has one,
+// and retries it as WithRetry sets like an idempotent one: the server
+// answers a resend with the response to the first send, so a retry never
+// applies a write twice. Servers too old to know RequestID run every send.
+func WithRequestIDs() Option {
+	return func(c *Client) { c.requestIDs = true }
+}
+
+// newRequestID returns a random RequestID
+func newRequestID() string {
+	var id [16]byte
+	rand.Read(id[:])
+	return hex.EncodeToString(id[:])
+}
+
+// connActions are the actions on the state of the connection they are sent
+// on, which a resend on another connection can't repeat
+var connActions = map[string]bool{
+	protocol.ActionMulti:   true,
+	protocol.ActionExec:    true,
+	protocol.ActionDiscard: true,
+	protocol.ActionWatch:   true,
+	protocol.ActionUnwatch: true,
+}
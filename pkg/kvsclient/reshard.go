@@ -0,0 +1,103 @@
+package kvsclient
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ReshardBatch is how many keys Reshard moves per MIGRATE
+const ReshardBatch = 100
+
+// Reshard moves the slots first to last to the server at target, which may
+// be a server that owns none yet, while the cluster serves them, and
+// returns how many keys it moved. For each run of the slots one server
+// owns, target is marked IMPORTING them and the owner MIGRATING them; the
+// owner moves its keys over in batches with MIGRATE, answering ASK for
+// the keys it no longer holds meanwhile, and then every server is told
+// target owns them. progress, if not nil, is called after each batch with
+// the keys moved so far.
+//
+// A Reshard that fails half-way leaves the slots moving, each key served
+// by one server or the other, and running it again finishes the move. The
+// servers must accept ADMIN on their cluster addresses, as for Backup.
+func (cc *ClusterClient) Reshard(ctx context.Context, first, last int, target string, progress func(moved int)) (int, error) {
+	if first < 0 || last >= protocol.NumSlots || first > last {
+		return 0, fmt.Errorf("kvsclient: bad slot range %d-%d", first, last)
+	}
+	if err := cc.Refresh(ctx); err != nil {
+		return 0, err
+	}
+	nodes := cc.nodes()
+	// target learns the map first, in case it has just joined
+	for _, node := range nodes {
+		for _, slots := range node.Slots {
+			if _, err := cc.setSlot(ctx, target, slots, "NODE", node.Addr); err != nil {
+				return 0, fmt.Errorf("setting up %s: %w", target, err)
+			}
+		}
+	}
+
+	moved := 0
+	for _, r := range cc.owners(first, last) {
+		if r.Addr == target {
+			continue
+		}
+		slots := fmt.Sprintf("%d-%d", r.First, r.Last)
+		if _, err := cc.setSlot(ctx, target, slots, "IMPORTING", r.Addr); err != nil {
+			return moved, fmt.Errorf("importing %s on %s: %w", slots, target, err)
+		}
+		if _, err := cc.setSlot(ctx, r.Addr, slots, "MIGRATING", target); err != nil {
+			return moved, fmt.Errorf("migrating %s on %s: %w", slots, r.Addr, err)
+		}
+		for more := true; more; {
+			response, err := cc.adminRequest(ctx, r.Addr, protocol.Request{Value: protocol.AdminMigrate, Key: slots, Limit: ReshardBatch})
+			if err != nil {
+				return moved, fmt.Errorf("moving %s from %s: %w", slots, r.Addr, err)
+			}
+			n, _ := strconv.Atoi(response.Value)
+			moved += n
+			more = response.More
+			if progress != nil {
+				progress(moved)
+			}
+		}
+		// the new owner first, then the old, so some server always serves
+		// the slots; the rest learn of it from them or from this
+		owners := []string{target, r.Addr}
+		for _, node := range nodes {
+			if node.Addr != target && node.Addr != r.Addr {
+				owners = append(owners, node.Addr)
+			}
+		}
+		for _, addr := range owners {
+			if _, err := cc.setSlot(ctx, addr, slots, "NODE", target); err != nil {
+				return moved, fmt.Errorf("handing %s over on %s: %w", slots, addr, err)
+			}
+		}
+	}
+	return moved, cc.Refresh(ctx)
+}
+
+// owners splits the slots first to last into runs that one server owns,
+// by the slot map
+func (cc *ClusterClient) owners(first, last int) []protocol.SlotRange {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	var ranges []protocol.SlotRange
+	for slot := first; slot <= last; slot++ {
+		if n := len(ranges); n > 0 && ranges[n-1].Addr == cc.slots[slot] {
+			ranges[n-1].Last = slot
+			continue
+		}
+		ranges = append(ranges, protocol.SlotRange{First: slot, Last: slot, Addr: cc.slots[slot]})
+	}
+	return ranges
+}
+
+// setSlot runs ADMIN SETSLOT on the server at addr
+func (cc *ClusterClient) setSlot(ctx context.Context, addr, slots, state, node string) (protocol.Response, error) {
+	return cc.adminRequest(ctx, addr, protocol.Request{Value: protocol.AdminSetSlot, Key: slots, Values: []string{state, node}})
+}
@@ -0,0 +1,221 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// RetryClass is a set of failures worth retrying.
+type RetryClass int
+
+// failure classes for RetryPolicy.RetryOn
+const (
+	// RetryConnRefused retries when nothing is listening, e.g. while the
+	// server restarts.
+	RetryConnRefused RetryClass = 1 << iota
+	// RetryConnReset retries when the connection drops mid-request.
+	RetryConnReset
+	// RetryTimeout retries when the client's own timeout expires. A
+	// deadline or cancellation of the caller's context is never retried.
+	RetryTimeout
+	// RetryServerHint retries failures the server marks retryable, such
+	// as SERVER_ERROR or READONLY, waiting at least the backoff it
+	// suggests. MOVED is left to ClusterClient.
+	RetryServerHint
+)
+
+// RetryPolicy controls how idempotent requests are retried. Only the
+// failure classes in RetryOn are retried; the wait before attempt n+1 is
+// Backoff doubled n-1 times, capped at MaxBackoff, and spread by Jitter
+// (0.2 means ±20%).
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+	Jitter      float64
+	RetryOn     RetryClass
+}
+
+// DefaultRetryPolicy rides out a server restart of a few seconds.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 5,
+	Backoff:     100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+	Jitter:      0.2,
+	RetryOn:     RetryConnRefused | RetryConnReset | RetryTimeout,
+}
+
+// WithRetry retries idempotent requests that fail as described by policy.
+// Without it every failure is returned to the caller at once.
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *Client) { c.retry = policy }
+}
+
+// idempotent lists the actions that are safe to send twice: repeating them
+// leaves the server as a single send would
+var idempotent = map[string]bool{
+	protocol.ActionHello:         true,
+	protocol.ActionPing:          true,
+	protocol.ActionGet:           true,
+	protocol.ActionSet:           true,
+	protocol.ActionUpdate:        true,
+	protocol.ActionDelete:        true,
+	protocol.ActionDiagnose:      true,
+	protocol.ActionLocks:         true,
+	protocol.ActionWindowSum:     true,
+	protocol.ActionSubscribe:     true,
+	protocol.ActionUnsubscribe:   true,
+	protocol.ActionFetch:         true,
+	protocol.ActionAck:           true,
+	protocol.ActionJournal:       true,
+	protocol.ActionPin:           true,
+	protocol.ActionUnpin:         true,
+	protocol.ActionList:          true,
+	protocol.ActionCommands:      true,
+	protocol.ActionDBSize:        true,
+	protocol.ActionScan:          true,
+	protocol.ActionRange:         true,
+	protocol.ActionSearch:        true,
+	protocol.ActionMGet:          true,
+	protocol.ActionMSet:          true,
+	protocol.ActionBatch:         true,
+	protocol.ActionRenew:         true,
+	protocol.ActionHSet:          true,
+	protocol.ActionHGet:          true,
+	protocol.ActionHGetAll:       true,
+	protocol.ActionHDel:          true,
+	protocol.ActionLRange:        true,
+	protocol.ActionSAdd:          true,
+	protocol.ActionSRem:          true,
+	protocol.ActionSMembers:      true,
+	protocol.ActionSIsMember:     true,
+	protocol.ActionSUnion:        true,
+	protocol.ActionSInter:        true,
+	protocol.ActionSDiff:         true,
+	protocol.ActionZAdd:          true,
+	protocol.ActionZRem:          true,
+	protocol.ActionZRange:        true,
+	protocol.ActionZRangeByScore: true,
+	protocol.ActionZRank:         true,
+	protocol.ActionGeoAdd:        true,
+	protocol.ActionGeoSearch:     true,
+	protocol.ActionXRead:         true,
+	protocol.ActionXAck:          true,
+	protocol.ActionJSONSet:       true,
+	protocol.ActionJSONGet:       true,
+	protocol.ActionStrlen:        true,
+	protocol.ActionSetBit:        true,
+	protocol.ActionGetBit:        true,
+	protocol.ActionBitCount:      true,
+	// a second RENAME would find the key gone, but a second COPY copies
+	// the same value again
+	protocol.ActionCopy: true,
+}
+
+// adminReports lists the ADMIN subcommands that only report, and so are
+// safe to send twice; true marks those that only report with no Key, and
+// change something given one
+var adminReports = map[string]bool{
+	protocol.AdminStats:        false,
+	protocol.AdminClients:      false,
+	protocol.AdminNamespaces:   false,
+	protocol.AdminMemory:       false,
+	protocol.AdminKeyspace:     false,
+	protocol.AdminBackups:      false,
+	protocol.AdminVerifyBackup: false,
+	protocol.AdminDump:         false,
+	protocol.AdminExport:       false,
+	protocol.AdminTrash:        false,
+	protocol.AdminSlowLog:      true,
+	protocol.AdminLogLevel:     true,
+	protocol.AdminReadOnly:     true,
+	protocol.AdminFreeze:       true,
+	protocol.AdminReplicaOf:    true,
+}
+
+// retryable reports whether request is safe to send twice: an idempotent
+// action, or an ADMIN subcommand that only reports. RESTORE, LOAD,
+// IMPORT, MIGRATE and the like are not.
+func retryable(request protocol.Request) bool {
+	if request.Action != protocol.ActionAdmin {
+		return idempotent[request.Action]
+	}
+	switch sub := strings.ToUpper(request.Value); sub {
+	case protocol.AdminBGSave, protocol.AdminRewriteWAL:
+		return request.Key == "status"
+	default:
+		keyed, ok := adminReports[sub]
+		return ok && (!keyed || request.Key == "")
+	}
+}
+
+// doWithRetry sends request until it succeeds, fails in a way the policy
+// does not retry, or runs out of attempts
+func (c *Client) doWithRetry(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	p := c.retry
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		response, err := c.try(ctx, request, attempt)
+		class := classify(err)
+		if err == nil {
+			class = classifyResponse(response)
+		}
+		if class&p.RetryOn == 0 || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return response, err
+		}
+		wait := backoff
+		if p.Jitter > 0 {
+			wait += time.Duration((rand.Float64()*2 - 1) * p.Jitter * float64(wait))
+		}
+		if info := response.Error; err == nil && info.Backoff > wait {
+			wait = info.Backoff
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return response, ctx.Err()
+		case <-timer.C:
+		}
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// classifyResponse is RetryServerHint for a response the server marks
+// retryable, else zero
+func classifyResponse(response protocol.Response) RetryClass {
+	if info := response.Error; info != nil && info.Retryable && info.Leader == "" {
+		return RetryServerHint
+	}
+	return 0
+}
+
+// classify sorts a round trip error into its retry class, zero if it
+// should not be retried
+func classify(err error) RetryClass {
+	var netErr net.Error
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded), errors.Is(err, ErrClosed):
+		return 0
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return RetryConnRefused
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF),
+		errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.EPIPE):
+		return RetryConnReset
+	case errors.Is(err, os.ErrDeadlineExceeded), errors.As(err, &netErr) && netErr.Timeout():
+		return RetryTimeout
+	}
+	return 0
+}
@@ -0,0 +1,34 @@
+package kvsclient
+
+import (
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestRetryable(t *testing.T) {
+	for _, c := range []struct {
+		request protocol.Request
+		want    bool
+	}{
+		{protocol.Request{Action: protocol.ActionGet}, true},
+		{protocol.Request{Action: protocol.ActionIncr}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminStats}, true},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: "stats"}, true},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminDump}, true},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminReplicaOf}, true},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminReplicaOf, Key: "primary:8081"}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminSlowLog, Key: "reset"}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminBGSave, Key: "status"}, true},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminBGSave}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminRestore}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminLoad}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminImport}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminMigrate}, false},
+		{protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminPurge}, false},
+	} {
+		if got := retryable(c.request); got != c.want {
+			t.Errorf("retryable(%s %s %q) = %v, want %v", c.request.Action, c.request.Value, c.request.Key, got, c.want)
+		}
+	}
+}
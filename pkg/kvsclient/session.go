@@ -0,0 +1,126 @@
+package kvsclient
+
+import (
+	"context"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Session holds one connection of the pool for requests that depend on
+// the connection they are sent on, such as WATCH, the reads it guards and
+// the transaction after them:
+//
+//	s, err := client.Session(ctx)
+//	...
+//	defer s.Close()
+//	s.Watch(ctx, "balance")
+//	balance, err := s.Get(ctx, "balance")
+//	...
+//	_, err = s.Multi().Set("balance", newBalance).Exec(ctx)
+//	if errors.Is(err, kvsclient.ErrConflict) {
+//		// balance changed since Watch: read it again and retry
+//	}
+//
+// Requests sent through a Session skip the client's interceptors and are
+// never retried. A Session is not safe for concurrent use.
+type Session struct {
+	c        *Client
+	cn       *conn
+	broken   bool
+	watching bool
+}
+
+// Session takes a connection from the pool until Close.
+func (c *Client) Session(ctx context.Context) (*Session, error) {
+	cn, err := c.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{c: c, cn: cn}, nil
+}
+
+// Do sends request on the session's connection.
+func (s *Session) Do(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	if s.cn == nil {
+		return protocol.Response{}, ErrClosed
+	}
+	request = s.c.withChecksum(request)
+	if request.Token == "" {
+		request.Token = s.c.token
+	}
+	request.LowPriority = request.LowPriority || s.c.lowPriority
+	responses, err := s.exchange(ctx, []protocol.Request{request})
+	if err != nil {
+		return protocol.Response{}, err
+	}
+	switch request.Action {
+	case protocol.ActionWatch:
+		s.watching = s.watching || responses[0].Success
+	case protocol.ActionUnwatch, protocol.ActionExec, protocol.ActionDiscard:
+		s.watching = false
+	case protocol.ActionMulti:
+		// Exec sends MULTI with its requests; one sent alone would leave
+		// the connection queueing
+		s.broken = true
+	}
+	return responses[0], nil
+}
+
+// exchange is Client.exchange on the session's connection
+func (s *Session) exchange(ctx context.Context, requests []protocol.Request) ([]protocol.Response, error) {
+	if s.cn == nil {
+		return nil, ErrClosed
+	}
+	responses, err := s.c.exchangeOn(ctx, s.cn, requests)
+	if err != nil {
+		s.broken = true
+		return nil, err
+	}
+	if requests[len(requests)-1].Action == protocol.ActionExec {
+		s.watching = false
+	}
+	return responses, nil
+}
+
+// Watch makes the next Exec of a transaction from Multi fail with
+// ErrConflict if any of keys is written, or deleted, before it runs.
+func (s *Session) Watch(ctx context.Context, keys ...string) error {
+	response, err := s.Do(ctx, protocol.Request{Action: protocol.ActionWatch, Keys: keys})
+	if err != nil {
+		return err
+	}
+	_, err = simpleResult(response)
+	return err
+}
+
+// Unwatch stops watching every key.
+func (s *Session) Unwatch(ctx context.Context) error {
+	response, err := s.Do(ctx, protocol.Request{Action: protocol.ActionUnwatch})
+	if err != nil {
+		return err
+	}
+	_, err = simpleResult(response)
+	return err
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (s *Session) Get(ctx context.Context, key string) (string, error) {
+	return call(ctx, s, protocol.Request{Action: protocol.ActionGet, Key: key}, getResult)
+}
+
+// Multi starts a transaction run on the session's connection, after its
+// Watch.
+func (s *Session) Multi() *Multi {
+	return &Multi{c: s.c, session: s}
+}
+
+// Close gives the connection back to the pool, or closes it if it may
+// still be watching keys or queueing requests.
+func (s *Session) Close() error {
+	if s.cn == nil {
+		return nil
+	}
+	s.c.put(s.cn, s.broken || s.watching)
+	s.cn = nil
+	return nil
+}
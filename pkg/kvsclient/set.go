@@ -0,0 +1,59 @@
+package kvsclient
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// SAdd adds members to the set at key, creating it with ttl, or the
+// server's default if zero, if it is missing, and returns how many of them
+// are new. A key that holds another type fails with ErrWrongType.
+func (c *Client) SAdd(ctx context.Context, key string, members []string, ttl time.Duration) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionSAdd, Key: key, Values: members, TTL: ttl})
+}
+
+// SRem removes members from the set at key and returns how many it had;
+// removing the last one deletes the key.
+func (c *Client) SRem(ctx context.Context, key string, members ...string) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionSRem, Key: key, Values: members})
+}
+
+// SMembers returns the members of the set at key in order, or ErrNotFound
+// if it is missing.
+func (c *Client) SMembers(ctx context.Context, key string) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionSMembers, Key: key}, getResult)
+}
+
+// SIsMember reports whether the set at key has member; a missing set has
+// none.
+func (c *Client) SIsMember(ctx context.Context, key, member string) (bool, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionSIsMember, Key: key, Value: member})
+	if err != nil {
+		return false, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return false, err
+	}
+	return response.Found, nil
+}
+
+// SUnion returns, in order, the members of any of the sets at keys,
+// computed on the server; a missing key is an empty set. In a cluster the
+// keys must live on one server, see protocol.Slot.
+func (c *Client) SUnion(ctx context.Context, keys ...string) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionSUnion, Keys: keys}, simpleResult)
+}
+
+// SInter returns, in order, the members of all of the sets at keys, see
+// SUnion.
+func (c *Client) SInter(ctx context.Context, keys ...string) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionSInter, Keys: keys}, simpleResult)
+}
+
+// SDiff returns, in order, the members of the set at the first of keys
+// that none of the others has, see SUnion.
+func (c *Client) SDiff(ctx context.Context, keys ...string) ([]string, error) {
+	return c.items(ctx, protocol.Request{Action: protocol.ActionSDiff, Keys: keys}, simpleResult)
+}
@@ -0,0 +1,354 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// sharding defaults
+const (
+	DefaultVirtualNodes = 160
+	DefaultNodeDownFor  = 5 * time.Second
+)
+
+// ErrNoNodes is returned when no server is available for a key.
+var ErrNoNodes = errors.New("kvsclient: no server available")
+
+// DownPolicy is what a ShardedClient does with a key whose server is down.
+type DownPolicy int
+
+const (
+	// FailFast returns the error; the key stays on its server.
+	FailFast DownPolicy = iota
+	// Reroute moves the key to the next server on the ring while its own
+	// is down. Writes made meanwhile live on that other server and are not
+	// seen once the owner is back.
+	Reroute
+)
+
+// ShardOption configures a ShardedClient.
+type ShardOption func(*ShardedClient)
+
+// WithVirtualNodes sets how many points each server gets on the ring; more
+// spreads keys more evenly.
+func WithVirtualNodes(n int) ShardOption {
+	return func(sc *ShardedClient) { sc.vnodes = n }
+}
+
+// WithDownPolicy sets what happens to keys of a server that is down, and
+// for how long a server that failed to answer is considered down.
+func WithDownPolicy(policy DownPolicy, downFor time.Duration) ShardOption {
+	return func(sc *ShardedClient) { sc.policy, sc.downFor = policy, downFor }
+}
+
+// WithNodeOptions configures the Client used for every server.
+func WithNodeOptions(opts ...Option) ShardOption {
+	return func(sc *ShardedClient) { sc.nodeOpts = append(sc.nodeOpts, opts...) }
+}
+
+// ShardedClient spreads keys over several independent servers by
+// consistent hashing, so adding or removing a server only moves the keys
+// next to it on the ring. The servers need no knowledge of each other.
+type ShardedClient struct {
+	vnodes   int
+	policy   DownPolicy
+	downFor  time.Duration
+	nodeOpts []Option
+
+	nodes  []*shardNode
+	points []ringPoint
+}
+
+// shardNode is one server on the ring
+type shardNode struct {
+	addr   string
+	client *Client
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+// ringPoint is one virtual node: a hash and the server it belongs to
+type ringPoint struct {
+	hash uint64
+	node int
+}
+
+// NewShardedClient returns a client for the servers at addrs.
+func NewShardedClient(addrs []string, opts ...ShardOption) *ShardedClient {
+	sc := &ShardedClient{vnodes: DefaultVirtualNodes, downFor: DefaultNodeDownFor}
+	for _, opt := range opts {
+		opt(sc)
+	}
+	if sc.vnodes <= 0 {
+		sc.vnodes = 1
+	}
+	for i, addr := range addrs {
+		sc.nodes = append(sc.nodes, &shardNode{addr: addr, client: NewClient(addr, sc.nodeOpts...)})
+		for v := 0; v < sc.vnodes; v++ {
+			sc.points = append(sc.points, ringPoint{hash: hashKey(addr + "#" + strconv.Itoa(v)), node: i})
+		}
+	}
+	sort.Slice(sc.points, func(i, j int) bool { return sc.points[i].hash < sc.points[j].hash })
+	return sc
+}
+
+// Addr returns the server that owns key, whether or not it is up.
+func (sc *ShardedClient) Addr(key string) string {
+	if len(sc.points) == 0 {
+		return ""
+	}
+	return sc.nodes[sc.owners(key)[0]].addr
+}
+
+// Do sends request to the server that owns request.Key.
+func (sc *ShardedClient) Do(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	return sc.DoKey(ctx, request.Key, request)
+}
+
+// DoKey sends request to the server that owns key, for a request such as
+// BATCH whose keys are not in Key; the caller checks that one server owns
+// them all.
+func (sc *ShardedClient) DoKey(ctx context.Context, key string, request protocol.Request) (protocol.Response, error) {
+	if len(sc.points) == 0 {
+		return protocol.Response{}, ErrNoNodes
+	}
+	err := ErrNoNodes
+	for _, i := range sc.owners(key) {
+		node := sc.nodes[i]
+		if sc.policy == Reroute && node.down() {
+			continue
+		}
+		var response protocol.Response
+		response, err = node.client.Do(ctx, request)
+		if err == nil || classify(err) == 0 {
+			return response, err
+		}
+		node.markDown(sc.downFor)
+		if sc.policy == FailFast {
+			return response, err
+		}
+	}
+	return protocol.Response{}, err
+}
+
+// DoKeys sends a request for the keys in Keys, such as MGET or MSET, to
+// the servers that own them, each with its share of Keys and of Values and
+// Checksums where those have one entry per key, and merges the Results in
+// the order of Keys. The keys of a server that fails have a result of
+// SERVER_ERROR with the error in Value, so the others still succeed. A
+// request that must succeed or fail as a whole, such as BATCH, is not one
+// to split.
+func (sc *ShardedClient) DoKeys(ctx context.Context, request protocol.Request) (protocol.Response, error) {
+	if len(sc.points) == 0 {
+		return protocol.Response{}, ErrNoNodes
+	}
+	byNode := make(map[int][]int) // node to the indexes of its keys
+	for i, key := range request.Keys {
+		n := sc.target(key)
+		byNode[n] = append(byNode[n], i)
+	}
+	results := make([]protocol.KeyResult, len(request.Keys))
+	var wg sync.WaitGroup
+	for n, idx := range byNode {
+		part := request
+		part.Keys = pick(request.Keys, idx)
+		part.Values = pick(request.Values, idx)
+		part.Checksums = pick(request.Checksums, idx)
+		wg.Add(1)
+		go func(node *shardNode, idx []int, part protocol.Request) {
+			defer wg.Done()
+			response, err := node.client.Do(ctx, part)
+			if err != nil && classify(err) != 0 {
+				node.markDown(sc.downFor)
+			}
+			for j, i := range idx {
+				switch {
+				case err != nil:
+					results[i] = protocol.KeyResult{Key: part.Keys[j], Message: protocol.MsgServerError, Value: fmt.Sprintf("%s: %v", node.addr, err)}
+				case !response.Success:
+					results[i] = protocol.KeyResult{Key: part.Keys[j], Message: response.Message, Value: response.Value}
+				case j < len(response.Results):
+					results[i] = response.Results[j]
+				}
+			}
+		}(sc.nodes[n], idx, part)
+	}
+	wg.Wait()
+	return protocol.Response{Success: true, Results: results}, nil
+}
+
+// pick returns the entries of xs at idx, or nil if xs has none, as a field
+// with one entry per key may be left out
+func pick[T any](xs []T, idx []int) []T {
+	if len(xs) == 0 {
+		return nil
+	}
+	out := make([]T, len(idx))
+	for j, i := range idx {
+		if i < len(xs) {
+			out[j] = xs[i]
+		}
+	}
+	return out
+}
+
+// Scan returns a page of the keys matching pattern, as Client.Scan does,
+// from one server after another in the order NewShardedClient was given
+// them; the Continue token names the server and its own token, so it only
+// means the same thing to a client with the servers in the same order. A
+// server that is down fails the scan whatever the DownPolicy, as leaving
+// its keys out would pass for a complete scan.
+func (sc *ShardedClient) Scan(ctx context.Context, pattern string, count int, cont string) (Page, error) {
+	if len(sc.nodes) == 0 {
+		return Page{}, ErrNoNodes
+	}
+	i, cursor := 0, ""
+	if cont != "" && cont != "0" {
+		n, rest, ok := strings.Cut(cont, "/")
+		var err error
+		if i, err = strconv.Atoi(n); !ok || err != nil || i < 0 || i >= len(sc.nodes) {
+			return Page{}, fmt.Errorf("%w: %q", ErrBadCursor, cont)
+		}
+		cursor = rest
+	}
+	node := sc.nodes[i]
+	page, err := node.client.Scan(ctx, pattern, count, cursor)
+	if err != nil {
+		if classify(err) != 0 {
+			node.markDown(sc.downFor)
+		}
+		return Page{}, fmt.Errorf("%s: %w", node.addr, err)
+	}
+	if page.More {
+		page.Continue = strconv.Itoa(i) + "/" + page.Continue
+	} else if i+1 < len(sc.nodes) {
+		page.More, page.Continue = true, strconv.Itoa(i+1)+"/"
+	}
+	return page, nil
+}
+
+// ErrBadCursor is returned by ShardedClient.Scan for a Continue token it
+// did not make.
+var ErrBadCursor = errors.New("kvsclient: bad scan cursor")
+
+// DBSize returns the keys on all the servers, as Client.DBSize does for
+// one; a server that is down fails it.
+func (sc *ShardedClient) DBSize(ctx context.Context) (total int, byNamespace map[string]int, err error) {
+	byNamespace = make(map[string]int)
+	for _, node := range sc.nodes {
+		n, namespaces, err := node.client.DBSize(ctx)
+		if err != nil {
+			if classify(err) != 0 {
+				node.markDown(sc.downFor)
+			}
+			return 0, nil, fmt.Errorf("%s: %w", node.addr, err)
+		}
+		total += n
+		for prefix, n := range namespaces {
+			byNamespace[prefix] += n
+		}
+	}
+	return total, byNamespace, nil
+}
+
+// Get returns the value of key, or ErrNotFound.
+func (sc *ShardedClient) Get(ctx context.Context, key string) (string, error) {
+	return call(ctx, sc, protocol.Request{Action: protocol.ActionGet, Key: key}, getResult)
+}
+
+// Set sets key to value with the server's default TTL.
+func (sc *ShardedClient) Set(ctx context.Context, key, value string) error {
+	return sc.SetWithTTL(ctx, key, value, 0)
+}
+
+// SetWithTTL sets key to value and expires it after ttl.
+func (sc *ShardedClient) SetWithTTL(ctx context.Context, key, value string, ttl time.Duration) error {
+	_, err := call(ctx, sc, protocol.Request{Action: protocol.ActionSet, Key: key, Value: value, TTL: ttl}, simpleResult)
+	return err
+}
+
+// Update replaces the value of an existing key, or returns ErrNotFound.
+func (sc *ShardedClient) Update(ctx context.Context, key, value string) error {
+	_, err := call(ctx, sc, protocol.Request{Action: protocol.ActionUpdate, Key: key, Value: value}, keyedResult)
+	return err
+}
+
+// Delete removes key, or returns ErrNotFound.
+func (sc *ShardedClient) Delete(ctx context.Context, key string) error {
+	_, err := call(ctx, sc, protocol.Request{Action: protocol.ActionDelete, Key: key}, keyedResult)
+	return err
+}
+
+// Close closes the client of every server.
+func (sc *ShardedClient) Close() error {
+	for _, node := range sc.nodes {
+		node.client.Close()
+	}
+	return nil
+}
+
+// owners returns the servers in ring order starting at key's owner, each once
+func (sc *ShardedClient) owners(key string) []int {
+	h := hashKey(key)
+	start := sort.Search(len(sc.points), func(i int) bool { return sc.points[i].hash >= h })
+	seen := make([]bool, len(sc.nodes))
+	order := make([]int, 0, len(sc.nodes))
+	for i := 0; i < len(sc.points) && len(order) < len(sc.nodes); i++ {
+		p := sc.points[(start+i)%len(sc.points)]
+		if !seen[p.node] {
+			seen[p.node] = true
+			order = append(order, p.node)
+		}
+	}
+	return order
+}
+
+// target returns the server a request for key goes to: its owner, or
+// with Reroute the first one after it on the ring that is up
+func (sc *ShardedClient) target(key string) int {
+	owners := sc.owners(key)
+	if sc.policy == Reroute {
+		for _, i := range owners {
+			if !sc.nodes[i].down() {
+				return i
+			}
+		}
+	}
+	return owners[0]
+}
+
+func (n *shardNode) down() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return time.Now().Before(n.downUntil)
+}
+
+func (n *shardNode) markDown(d time.Duration) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.downUntil = time.Now().Add(d)
+}
+
+// hashKey is FNV-1a with a final mix, since FNV alone clusters the
+// near-identical virtual node names
+func hashKey(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	x := h.Sum64()
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
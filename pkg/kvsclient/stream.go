@@ -0,0 +1,84 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrNoGroup is returned by XReadGroup and XAck for a consumer group the
+// stream doesn't have.
+var ErrNoGroup = errors.New("kvsclient: no such consumer group")
+
+// ErrGroupExists is returned by XGroupCreate for a group the stream
+// already has.
+var ErrGroupExists = errors.New("kvsclient: consumer group exists")
+
+// XAdd appends an entry with fields to the stream at key, creating it
+// with ttl, or the server's default if zero, if it is missing, and
+// returns the ID the server gave it. A maxLen above zero caps the stream
+// at that many entries from then on, dropping the oldest. A key that
+// holds another type fails with ErrWrongType.
+func (c *Client) XAdd(ctx context.Context, key string, fields map[string]string, maxLen int, ttl time.Duration) (string, error) {
+	request := protocol.Request{Action: protocol.ActionXAdd, Key: key, Limit: maxLen, TTL: ttl}
+	for field := range fields {
+		request.Keys = append(request.Keys, field)
+	}
+	sort.Strings(request.Keys)
+	for _, field := range request.Keys {
+		request.Values = append(request.Values, fields[field])
+	}
+	return call(ctx, c, request, simpleResult)
+}
+
+// XRead returns up to count entries, all if zero, of the stream at key
+// with an ID after after, from the start if it is "", or ErrNotFound if
+// the stream is missing. Pass the last ID returned to read on.
+func (c *Client) XRead(ctx context.Context, key, after string, count int) ([]protocol.StreamEntry, error) {
+	response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionXRead, Key: key, Value: after, Limit: count})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return nil, err
+	}
+	if !response.Found {
+		return nil, ErrNotFound
+	}
+	return response.Stream, nil
+}
+
+// XGroupCreate creates the consumer group group on the stream at key,
+// creating the stream if it is missing. The group delivers the entries
+// with an ID after start, "0" for all of them, or only those added from
+// now on if start is "". A group that exists fails with ErrGroupExists.
+func (c *Client) XGroupCreate(ctx context.Context, key, group, start string) error {
+	return c.simple(ctx, protocol.Request{Action: protocol.ActionXGroup, Key: key, Group: group, Value: start})
+}
+
+// XReadGroup delivers up to count entries, all if zero, of the stream at
+// key to consumer of group. With after "" they are the next entries the
+// group has delivered to no one; otherwise they are those after after
+// that were delivered to consumer and not acknowledged, so a restarted
+// consumer passes "0" to pick up where it crashed. Entries stay pending
+// until XAck.
+func (c *Client) XReadGroup(ctx context.Context, key, group, consumer, after string, count int) ([]protocol.StreamEntry, error) {
+	request := protocol.Request{Action: protocol.ActionXReadGroup, Key: key, Group: group, Owner: consumer, Value: after, Limit: count}
+	response, err := c.Do(ctx, request)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := simpleResult(response); err != nil {
+		return nil, err
+	}
+	return response.Stream, nil
+}
+
+// XAck acknowledges the entries with ids for group of the stream at key
+// and returns how many of them were pending.
+func (c *Client) XAck(ctx context.Context, key, group string, ids ...string) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionXAck, Key: key, Group: group, Keys: ids})
+}
@@ -0,0 +1,217 @@
+package kvsclient
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultClientCacheKeys is how many values WithClientCache keeps unless
+// told otherwise
+const DefaultClientCacheKeys = 10000
+
+// WithClientCache keeps up to maxKeys values Get reads in the client, or
+// DefaultClientCacheKeys if maxKeys is zero, so reading them again takes
+// no round trip. The server tracks which keys the client caches and
+// reports every change to them, by any client, to a poll the client keeps
+// open on one connection of its pool; a changed key is dropped as soon as
+// the report arrives, and a write through this client drops the key at
+// once. While the poll is down, e.g. the server restarted, and with
+// servers that don't track keys, the cache is emptied and bypassed.
+func WithClientCache(maxKeys int) Option {
+	if maxKeys <= 0 {
+		maxKeys = DefaultClientCacheKeys
+	}
+	return func(c *Client) { c.cache = &clientCache{maxKeys: maxKeys} }
+}
+
+// ClientCacheStats is how the cache of WithClientCache has fared: Hits are
+// Gets it answered, Misses Gets that went to the server, Invalidations the
+// cached keys the server reported changed and Keys what it holds now
+type ClientCacheStats struct {
+	Hits          uint64
+	Misses        uint64
+	Invalidations uint64
+	Keys          int
+}
+
+// clientCache is the cache of WithClientCache
+type clientCache struct {
+	maxKeys int
+	id      string // the client ID the server tracks keys for
+
+	mu      sync.Mutex
+	values  map[string]string
+	live    bool   // the poll is up, so the values are current
+	epoch   uint64 // changes whenever values may have gone stale
+	started bool
+	cancel  context.CancelFunc
+	stats   ClientCacheStats
+}
+
+// start starts polling for invalidations, the first time it is called
+func (cc *clientCache) start(c *Client) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.started {
+		return
+	}
+	cc.started = true
+	var id [16]byte
+	rand.Read(id[:])
+	cc.id = hex.EncodeToString(id[:])
+	ctx, cancel := context.WithCancel(context.Background())
+	cc.cancel = cancel
+	go c.invalidations(ctx)
+}
+
+// close stops polling
+func (cc *clientCache) close() {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if cc.cancel != nil {
+		cc.cancel()
+	}
+	cc.down()
+}
+
+// get returns the cached value of key, if the cache is live
+func (cc *clientCache) get(key string) (string, bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	value, ok := cc.values[key]
+	if ok && cc.live {
+		cc.stats.Hits++
+		return value, true
+	}
+	cc.stats.Misses++
+	return "", false
+}
+
+// begin returns what a Get that misses needs to cache its result: the
+// client ID to track the key for, empty if the cache is not live, and the
+// epoch
+func (cc *clientCache) begin() (id string, epoch uint64) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if !cc.live {
+		return "", cc.epoch
+	}
+	return cc.id, cc.epoch
+}
+
+// put caches value for key, read at epoch, unless an invalidation arrived
+// since, which may have been for it
+func (cc *clientCache) put(key, value string, epoch uint64) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if !cc.live || cc.epoch != epoch {
+		return
+	}
+	if _, ok := cc.values[key]; !ok && len(cc.values) >= cc.maxKeys {
+		// the server still reports a change to the one dropped, which is
+		// then ignored
+		for k := range cc.values {
+			delete(cc.values, k)
+			break
+		}
+	}
+	cc.values[key] = value
+}
+
+// drop forgets key and keys, which this client is writing
+func (cc *clientCache) drop(key string, keys []string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.epoch++
+	delete(cc.values, key)
+	for _, key := range keys {
+		delete(cc.values, key)
+	}
+}
+
+// invalidate forgets the keys the server reported changed, or every key
+// if all, and marks the cache live
+func (cc *clientCache) invalidate(keys []string, all bool) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	if all || len(keys) > 0 || !cc.live {
+		cc.epoch++
+	}
+	if all || cc.values == nil {
+		cc.stats.Invalidations += uint64(len(cc.values))
+		cc.values = make(map[string]string)
+	}
+	for _, key := range keys {
+		if _, ok := cc.values[key]; ok {
+			cc.stats.Invalidations++
+			delete(cc.values, key)
+		}
+	}
+	cc.live = true
+}
+
+// down empties the cache and bypasses it until the poll is up again;
+// caller must hold cc.mu
+func (cc *clientCache) down() {
+	cc.epoch++
+	cc.values = nil
+	cc.live = false
+}
+
+// invalidations polls the server for the keys to drop until ctx is done
+func (c *Client) invalidations(ctx context.Context) {
+	cc := c.cache
+	backoff := watchBackoff
+	for ctx.Err() == nil {
+		response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionInvalidations, Owner: cc.id, Timeout: watchPoll})
+		if err == nil && !response.Success {
+			err = newKVSError(response)
+		}
+		if err != nil {
+			cc.mu.Lock()
+			cc.down()
+			cc.mu.Unlock()
+			if !c.supports(protocol.CapTracking) {
+				return
+			}
+			sleepCtx(ctx, backoff)
+			backoff = min(backoff*2, watchMaxBackoff)
+			continue
+		}
+		backoff = watchBackoff
+		cc.invalidate(response.Values, response.Message == protocol.MsgInvalidateAll)
+	}
+}
+
+// cachedGet is Get through the cache of WithClientCache
+func (c *Client) cachedGet(ctx context.Context, key string) (string, error) {
+	cc := c.cache
+	cc.start(c)
+	if value, ok := cc.get(key); ok {
+		return value, nil
+	}
+	id, epoch := cc.begin()
+	value, err := call(ctx, c, protocol.Request{Action: protocol.ActionGet, Key: key, Owner: id}, getResult)
+	if err == nil && id != "" {
+		cc.put(key, value, epoch)
+	}
+	return value, err
+}
+
+// ClientCacheStats returns how the cache of WithClientCache has fared,
+// zero without one
+func (c *Client) ClientCacheStats() ClientCacheStats {
+	if c.cache == nil {
+		return ClientCacheStats{}
+	}
+	cc := c.cache
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	stats := cc.stats
+	stats.Keys = len(cc.values)
+	return stats
+}
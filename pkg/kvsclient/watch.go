@@ -0,0 +1,67 @@
+package kvsclient
+
+import (
+	"context"
+	"strings"
+	"time"
+)
+
+// tuning for Watch and Listen
+const (
+	watchPoll       = 25 * time.Second
+	watchBackoff    = 100 * time.Millisecond
+	watchMaxBackoff = 10 * time.Second
+)
+
+// Event is one committed write seen by Watch.
+type Event struct {
+	Revision uint64
+	Time     time.Time
+	Op       string
+	Key      string
+	Value    string
+}
+
+// Watch delivers every write to a key starting with prefix, in commit order,
+// from the moment it is called until ctx is done; then the channel is
+// closed. It follows the server's write journal, so when the connection
+// fails it reconnects and resumes after the last revision it delivered,
+// without gaps or repeats. The error is for the first contact only.
+func (c *Client) Watch(ctx context.Context, prefix string) (<-chan Event, error) {
+	_, latest, err := c.journal(ctx, 0, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	events := make(chan Event)
+	go c.watch(ctx, prefix, latest, events)
+	return events, nil
+}
+
+func (c *Client) watch(ctx context.Context, prefix string, after uint64, events chan<- Event) {
+	defer close(events)
+	backoff := watchBackoff
+	for ctx.Err() == nil {
+		entries, _, err := c.journal(ctx, after, 0, watchPoll)
+		if err != nil {
+			sleepCtx(ctx, backoff)
+			backoff = min(backoff*2, watchMaxBackoff)
+			continue
+		}
+		backoff = watchBackoff
+		if len(entries) == 0 {
+			// the poll timed out, or an older server does not wait at all
+			sleepCtx(ctx, watchBackoff)
+		}
+		for _, e := range entries {
+			after = e.Revision
+			if !strings.HasPrefix(e.Key, prefix) {
+				continue
+			}
+			select {
+			case events <- Event{Revision: e.Revision, Time: e.Time, Op: e.Op, Key: e.Key, Value: e.Value}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,87 @@
+package kvsclient
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ScoredMember is a member of a sorted set with its score.
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// ZAdd sets the score of each member in scores in the sorted set at key,
+// creating it with ttl, or the server's default if zero, if it is
+// missing, and returns how many of the members are new. A key that holds
+// another type fails with ErrWrongType.
+func (c *Client) ZAdd(ctx context.Context, key string, scores map[string]float64, ttl time.Duration) (int, error) {
+	request := protocol.Request{Action: protocol.ActionZAdd, Key: key, TTL: ttl}
+	for member := range scores {
+		request.Keys = append(request.Keys, member)
+	}
+	sort.Strings(request.Keys)
+	for _, member := range request.Keys {
+		request.Values = append(request.Values, protocol.FormatScore(scores[member]))
+	}
+	return c.count(ctx, request)
+}
+
+// ZRem removes members from the sorted set at key and returns how many it
+// had; removing the last one deletes the key.
+func (c *Client) ZRem(ctx context.Context, key string, members ...string) (int, error) {
+	return c.count(ctx, protocol.Request{Action: protocol.ActionZRem, Key: key, Keys: members})
+}
+
+// ZRange returns the members of the sorted set at key from index start to
+// stop, both included, lowest score first, or ErrNotFound if it is
+// missing. Negative indexes count from the end, so ZRange(ctx, key, -10,
+// -1) returns the ten highest scores.
+func (c *Client) ZRange(ctx context.Context, key string, start, stop int) ([]ScoredMember, error) {
+	return c.scored(ctx, protocol.Request{Action: protocol.ActionZRange, Key: key, Start: start, Stop: stop})
+}
+
+// ZRangeByScore returns the members of the sorted set at key scored from
+// min to max, both included, lowest first, at most limit of them if limit
+// is above zero, or ErrNotFound if it is missing. Pass math.Inf for an
+// unbounded end.
+func (c *Client) ZRangeByScore(ctx context.Context, key string, min, max float64, limit int) ([]ScoredMember, error) {
+	bounds := []string{protocol.FormatScore(min), protocol.FormatScore(max)}
+	return c.scored(ctx, protocol.Request{Action: protocol.ActionZRangeByScore, Key: key, Values: bounds, Limit: limit})
+}
+
+// ZRank returns the index of member in the sorted set at key, from 0 for
+// the lowest score, or ErrNotFound if either is missing.
+func (c *Client) ZRank(ctx context.Context, key, member string) (int, error) {
+	value, err := call(ctx, c, protocol.Request{Action: protocol.ActionZRank, Key: key, Value: member}, getResult)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+// scored is items for responses that list members each followed by its
+// score
+func (c *Client) scored(ctx context.Context, request protocol.Request) ([]ScoredMember, error) {
+	values, err := c.items(ctx, request, getResult)
+	if err != nil {
+		return nil, err
+	}
+	if len(values)%2 != 0 {
+		return nil, errors.New("kvsclient: malformed sorted set reply")
+	}
+	members := make([]ScoredMember, len(values)/2)
+	for i := range members {
+		score, err := protocol.ParseScore(values[2*i+1])
+		if err != nil {
+			return nil, err
+		}
+		members[i] = ScoredMember{values[2*i], score}
+	}
+	return members, nil
+}
@@ -0,0 +1,212 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"time"
+	"unsafe"
+)
+
+// ArenaSegmentSize is the size of one arena segment; larger values get a
+// segment of their own
+const ArenaSegmentSize = 4 << 20
+
+// arenaRef locates a value in the arena. It holds no pointers, so the
+// garbage collector does not scan the index entries' values.
+type arenaRef struct {
+	seg       uint32
+	off       uint32
+	n         uint32
+	timestamp int64
+	ttl       time.Duration
+	sum       uint32
+	rev       uint64
+	typ       ValueType
+	packed    bool
+	sealed    bool
+}
+
+// arenaEngine stores values back to back in large append-only byte
+// segments with a map from key to location. Millions of small values then
+// cost the garbage collector a handful of segments instead of millions of
+// strings, which suits large read-mostly datasets. Overwritten and deleted
+// values stay in their segment until compact copies the live ones out.
+//
+// With a dir, the segments are files there mapped into memory rather than
+// heap: the Go heap then holds only the index, and the kernel can page
+// values out to the files under memory pressure, which suits large values.
+type arenaEngine struct {
+	spec  string // the engine name it was made with, if not EngineArena
+	dir   string // where mapped segments go, "" for heap segments
+	index buckets[arenaRef]
+	segs  [][]byte
+	maps  [][]byte // the segments that are mapped, to unmap
+	live  int
+	dead  int
+}
+
+func newArenaEngine() *arenaEngine {
+	return &arenaEngine{}
+}
+
+// newMappedArena returns an empty arena whose segments are mapped files in
+// dir, the system's temporary directory if dir is empty
+func newMappedArena(spec, dir string) (*arenaEngine, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	a := &arenaEngine{spec: spec, dir: dir}
+	seg, err := mapSegment(dir, ArenaSegmentSize)
+	if err != nil {
+		return nil, fmt.Errorf("mmap engine: %w", err)
+	}
+	a.segs, a.maps = append(a.segs, seg[:0]), append(a.maps, seg)
+	return a, nil
+}
+
+func (a *arenaEngine) name() string {
+	if a.spec != "" {
+		return a.spec
+	}
+	return EngineArena
+}
+
+func (a *arenaEngine) get(key string) (KeyValue, bool) {
+	ref, ok := a.index.get(key)
+	if !ok {
+		return KeyValue{}, false
+	}
+	return a.value(ref), true
+}
+
+func (a *arenaEngine) set(key string, kv KeyValue) {
+	if old, ok := a.index.get(key); ok {
+		a.release(old)
+	}
+	a.index.set(key, a.store(kv))
+}
+
+func (a *arenaEngine) delete(key string) {
+	if old, ok := a.index.get(key); ok {
+		a.release(old)
+		a.index.delete(key)
+	}
+}
+
+func (a *arenaEngine) len() int { return a.index.len() }
+
+// an arena entry is the index's string header and arenaRef and the
+// bucket's share; values are packed, so they cost nothing more
+func (a *arenaEngine) overhead() int64 {
+	return int64(unsafe.Sizeof("") + unsafe.Sizeof(arenaRef{}) + 8)
+}
+
+func (a *arenaEngine) each(fn func(key string, kv KeyValue) bool) {
+	a.index.each(func(key string, ref arenaRef) bool {
+		return fn(key, a.value(ref))
+	})
+}
+
+func (a *arenaEngine) eachExpiry(fn func(key string, kv KeyValue) bool) {
+	a.index.each(func(key string, ref arenaRef) bool {
+		return fn(key, expiryOf(ref))
+	})
+}
+
+func (a *arenaEngine) eachExpiryIn(bucket int, fn func(key string, kv KeyValue) bool) {
+	a.index.eachIn(bucket, func(key string, ref arenaRef) bool {
+		return fn(key, expiryOf(ref))
+	})
+}
+
+// expiryOf is the KeyValue of ref with only Timestamp and TTL filled in
+func expiryOf(ref arenaRef) KeyValue {
+	return KeyValue{Timestamp: time.Unix(0, ref.timestamp), TTL: ref.ttl}
+}
+
+// compact copies the live values into fresh segments once at least half of
+// the arena is garbage
+func (a *arenaEngine) compact() {
+	if a.dead < ArenaSegmentSize || a.dead < a.live {
+		return
+	}
+	old, maps := a.segs, a.maps
+	a.segs, a.maps, a.live, a.dead = nil, nil, 0, 0
+	a.index.each(func(key string, ref arenaRef) bool {
+		b := old[ref.seg][ref.off : ref.off+ref.n]
+		ref.seg, ref.off = a.alloc(len(b))
+		copy(a.segs[ref.seg][ref.off:], b)
+		a.live += int(ref.n)
+		a.index.set(key, ref)
+		return true
+	})
+	unmap(maps)
+}
+
+// close unmaps the segments of a mapped arena, which frees their files
+func (a *arenaEngine) close() {
+	unmap(a.maps)
+	a.segs, a.maps = nil, nil
+}
+
+func unmap(segs [][]byte) {
+	for _, seg := range segs {
+		if err := unmapSegment(seg); err != nil {
+			RecordError("Error unmapping arena segment:", err)
+		}
+	}
+}
+
+func (a *arenaEngine) value(ref arenaRef) KeyValue {
+	return KeyValue{
+		Value:      string(a.segs[ref.seg][ref.off : ref.off+ref.n]),
+		Timestamp:  time.Unix(0, ref.timestamp),
+		TTL:        ref.ttl,
+		Checksum:   ref.sum,
+		Revision:   ref.rev,
+		Type:       ref.typ,
+		Compressed: ref.packed,
+		Encrypted:  ref.sealed,
+	}
+}
+
+func (a *arenaEngine) store(kv KeyValue) arenaRef {
+	ref := arenaRef{n: uint32(len(kv.Value)), timestamp: kv.Timestamp.UnixNano(), ttl: kv.TTL, sum: kv.Checksum, rev: kv.Revision, typ: kv.Type, packed: kv.Compressed, sealed: kv.Encrypted}
+	ref.seg, ref.off = a.alloc(len(kv.Value))
+	copy(a.segs[ref.seg][ref.off:], kv.Value)
+	a.live += len(kv.Value)
+	return ref
+}
+
+func (a *arenaEngine) release(ref arenaRef) {
+	a.live -= int(ref.n)
+	a.dead += int(ref.n)
+}
+
+// alloc reserves n bytes at the end of the last segment, starting a new
+// segment when they don't fit
+func (a *arenaEngine) alloc(n int) (seg, off uint32) {
+	if len(a.segs) > 0 {
+		last := a.segs[len(a.segs)-1]
+		if len(last)+n <= cap(last) {
+			a.segs[len(a.segs)-1] = last[:len(last)+n]
+			return uint32(len(a.segs) - 1), uint32(len(last))
+		}
+	}
+	a.segs = append(a.segs, a.segment(max(n, ArenaSegmentSize))[:n])
+	return uint32(len(a.segs) - 1), 0
+}
+
+// segment returns an empty segment of size bytes: mapped if the arena has
+// a dir and that works, from the heap otherwise
+func (a *arenaEngine) segment(size int) []byte {
+	if a.dir != "" {
+		seg, err := mapSegment(a.dir, size)
+		if err == nil {
+			a.maps = append(a.maps, seg)
+			return seg[:0]
+		}
+		RecordError("Error mapping arena segment:", err)
+	}
+	return make([]byte, 0, size)
+}
@@ -0,0 +1,66 @@
+package kvstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArenaKeepsWhatItStores(t *testing.T) {
+	a := newArenaEngine()
+	at := time.Unix(1700000000, 0)
+	kv := KeyValue{Value: "v", Timestamp: at, TTL: time.Minute, Checksum: 7, Revision: 3, Type: TypeHash, Compressed: true}
+	a.set("k", kv)
+	a.set("other", KeyValue{Value: "x", Timestamp: at})
+	if got, ok := a.get("k"); !ok || got.Value != kv.Value || !got.Timestamp.Equal(at) || got.TTL != kv.TTL ||
+		got.Checksum != kv.Checksum || got.Revision != kv.Revision || got.Type != kv.Type || !got.Compressed || got.Encrypted {
+		t.Errorf("get = %+v, %v; want %+v", got, ok, kv)
+	}
+	a.set("k", KeyValue{Value: "longer value", Timestamp: at})
+	if got, _ := a.get("k"); got.Value != "longer value" {
+		t.Errorf("get after overwrite = %q", got.Value)
+	}
+	a.delete("k")
+	if _, ok := a.get("k"); ok || a.len() != 1 {
+		t.Errorf("deleted key still there, len %d", a.len())
+	}
+}
+
+func TestArenaCompactsOnceHalfIsGarbage(t *testing.T) {
+	a := newArenaEngine()
+	a.set("small", KeyValue{Value: "s"})
+	big := strings.Repeat("v", ArenaSegmentSize)
+	a.set("big", KeyValue{Value: big})
+	a.set("big", KeyValue{Value: big})
+	a.compact()
+	if a.dead == 0 {
+		t.Fatal("compacted while most of the arena was live")
+	}
+	a.delete("big")
+	a.compact()
+	if a.dead != 0 || a.live != 1 || len(a.segs) != 1 {
+		t.Errorf("after compact live %d dead %d segments %d, want 1 0 1", a.live, a.dead, len(a.segs))
+	}
+	if got, ok := a.get("small"); !ok || got.Value != "s" {
+		t.Errorf("get small after compact = %q, %v", got.Value, ok)
+	}
+}
+
+func TestArenaStore(t *testing.T) {
+	kvs, err := NewKeyValueStoreWithEngine(EngineArena)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kvs.Engine() != EngineArena {
+		t.Fatalf("engine %s", kvs.Engine())
+	}
+	kvs.SET("a", "1")
+	kvs.SET("b", "2")
+	kvs.DELETE("a")
+	if _, found := kvs.GET("a"); found {
+		t.Error("deleted key found")
+	}
+	if value, _ := kvs.GET("b"); value != "2" || kvs.Len() != 1 {
+		t.Errorf("GET b = %q, len %d", value, kvs.Len())
+	}
+}
@@ -0,0 +1,611 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// BackupInterval is how often BackupKeyValueStore writes a snapshot unless
+// SetBackup says otherwise
+const BackupInterval = 5 * time.Second
+
+// BackupFileName represents the name of the backup file, unless SetBackup
+// says otherwise
+const BackupFileName = "backup.snap"
+
+// ErrBackupsPaused is returned by WriteBackup while PauseBackups is on
+var ErrBackupsPaused = errors.New("backups are paused")
+
+// BackupSnapshot represents the snapshot of the key-value store's data;
+// a delta, see SetIncremental, also lists the keys Deleted since the
+// snapshot before it
+type BackupSnapshot struct {
+	Data    map[string]KeyValue `json:"data"`
+	Deleted []string            `json:"deleted,omitempty"`
+	WAL     uint64              `json:"wal,omitempty"` // the last WAL record it holds, see OpenWAL
+}
+
+// SetBackup changes where WriteBackup writes kvs and how often
+// BackupKeyValueStore does it; call it before starting BackupKeyValueStore
+func (kvs *KeyValueStore) SetBackup(path string, interval time.Duration) {
+	if path == "" {
+		path = BackupFileName
+	}
+	if interval <= 0 {
+		interval = BackupInterval
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.backupPath, kvs.backupInterval = path, interval
+}
+
+// Backup returns the backup file and interval set by SetBackup
+func (kvs *KeyValueStore) Backup() (path string, interval time.Duration) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.backupPath, kvs.backupInterval
+}
+
+// PauseBackups stops or resumes writing snapshots, e.g. while the disk
+// is nearly full; the last snapshot written stays as it is
+func (kvs *KeyValueStore) PauseBackups(on bool) {
+	kvs.backupsPaused.Store(on)
+}
+
+// BackupsPaused reports whether PauseBackups is on
+func (kvs *KeyValueStore) BackupsPaused() bool {
+	return kvs.backupsPaused.Load()
+}
+
+// BackupKeyValueStore snapshots kvs to its backup file every backup
+// interval, or when the schedule SetBackupSchedule set matches, until ctx
+// is done
+func BackupKeyValueStore(ctx context.Context, kvs *KeyValueStore) {
+	Logf(LogDebug, "BackupKeyValueStore func called")
+	_, interval := kvs.Backup()
+	cron, quiet := kvs.BackupSchedule()
+	next := func(now time.Time) time.Time {
+		if cron == nil {
+			return now.Add(interval)
+		}
+		return cron.Next(now)
+	}
+	defer kvs.nextBackup.Store(0)
+	for at := next(time.Now()); ; at = next(time.Now()) {
+		if at.IsZero() {
+			RecordError("Error scheduling backup:", fmt.Errorf("cron expression %q never matches", cron))
+			return
+		}
+		kvs.nextBackup.Store(at.UnixNano())
+		timer := time.NewTimer(time.Until(at))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if quiet.Contains(time.Now()) {
+			Logf(LogDebug, "Backup skipped, in quiet hours %s", quiet)
+			continue
+		}
+		if kvs.BackupsPaused() {
+			Logf(LogDebug, "Backup skipped, backups are paused")
+			continue
+		}
+		if err := WriteBackup(kvs); err != nil {
+			RecordError("Error writing backup:", err)
+			continue
+		}
+		Logf(LogDebug, "Backup created successfully")
+	}
+}
+
+// Snapshot copies the entries of kvs, with the keys of the values that
+// fail their checksum, which are copied as they are. With SetEncryption
+// on, the values are copied sealed.
+func (kvs *KeyValueStore) Snapshot() (snapshot BackupSnapshot, damaged []string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.snapshot()
+}
+
+// SnapshotOf returns a snapshot of up to limit of the live keys match
+// accepts, its values left unsealed, for another store to MergeSnapshot,
+// and reports whether kvs holds more of them.
+func (kvs *KeyValueStore) SnapshotOf(match func(key string) bool, limit int) (snapshot BackupSnapshot, more bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	now := kvs.now()
+	snapshot.Data = make(map[string]KeyValue)
+	kvs.data.each(func(key string, value KeyValue) bool {
+		if !match(key) || kvs.expired(value, now) {
+			return true
+		}
+		if len(snapshot.Data) == limit {
+			more = true
+			return false
+		}
+		snapshot.Data[key] = value
+		return true
+	})
+	return snapshot, more
+}
+
+// snapshot is Snapshot; caller must hold kvs.mu
+func (kvs *KeyValueStore) snapshot() (snapshot BackupSnapshot, damaged []string) {
+	snapshot.WAL = kvs.walSeq()
+	aead := encryptionOf(kvs.data)
+	snapshot.Data = make(map[string]KeyValue, kvs.data.len())
+	// writers wait for the read lock, so the filter is rebuilt from the
+	// same keys
+	filter := kvs.filter.fresh(kvs.data.len())
+	kvs.data.each(func(key string, value KeyValue) bool {
+		if filter != nil {
+			filter.add(key)
+		}
+		if !value.Intact() {
+			damaged = append(damaged, key)
+		}
+		if aead != nil {
+			value = sealText(aead, key, value)
+		}
+		snapshot.Data[key] = value
+		return true
+	})
+	kvs.filter.swap(filter)
+	return snapshot, damaged
+}
+
+// WriteBackup writes one snapshot of kvs to its backup file, compressed
+// and encrypted if SetSnapshotCompression and SetFileCipher say so, and
+// keeps it if SetRetention says so, or only what changed since the last
+// one if SetIncremental says so, and copies it to the sink SetSnapshotSink
+// set. The snapshot goes to a temp file next to
+// it, which is synced and renamed over the backup file, so a crash or
+// full disk half-way leaves the last snapshot intact. Values that fail
+// their checksum are written as they are, so a restore still sees the
+// damage, and reported in the returned error.
+func WriteBackup(kvs *KeyValueStore) (err error) {
+	if kvs.BackupsPaused() {
+		return ErrBackupsPaused
+	}
+	kvs.backupMu.Lock()
+	defer kvs.backupMu.Unlock()
+	start := time.Now()
+	defer func() {
+		kvs.lastBackup.Store(&BackupResult{Time: time.Now(), Duration: time.Since(start), Err: err})
+	}()
+	path, _ := kvs.Backup()
+	taken := kvs.takeSnapshot(kvs.now())
+	kvs.mu.RLock()
+	compression := kvs.snapshotCompression
+	kvs.mu.RUnlock()
+	data := compressSnapshot(encodeSnapshot(taken.BackupSnapshot, taken.SnapshotInfo), compression)
+	data = kvs.FileCipher().sealFile(data)
+	names := []string{path}
+	if taken.Delta {
+		names[0] = deltaName(path, taken.Seq)
+		err = writeFileAtomic(names[0], data)
+	} else if keep, window := kvs.Retention(); keep == 0 && window == 0 {
+		err = writeFileAtomic(path, data)
+	} else if err = writeRetained(path, taken.Created, data); err == nil {
+		names = append(names, backupName(path, taken.Created))
+		kvs.pruneBackups(taken.Created)
+	}
+	if err != nil {
+		return err
+	}
+	kvs.snapshotWritten(path, taken)
+	if err := kvs.upload(data, names...); err != nil {
+		return err
+	}
+	if damaged := taken.damaged; len(damaged) > 0 {
+		return fmt.Errorf("%s: %d values fail their checksum, e.g. %q", protocol.MsgIntegrity, len(damaged), damaged[0])
+	}
+	return nil
+}
+
+// readSnapshot reads the snapshot in path, decrypting it with c,
+// decompressing it and checking its checksum and count
+func readSnapshot(path string, c *FileCipher) (BackupSnapshot, SnapshotInfo, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return BackupSnapshot{}, SnapshotInfo{}, err
+	}
+	if data, err = c.openFile(data); err != nil {
+		return BackupSnapshot{}, SnapshotInfo{}, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+	data, compression, err := decompressSnapshot(data)
+	if err != nil {
+		return BackupSnapshot{}, SnapshotInfo{}, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+	snapshot, info, err := decodeSnapshot(data)
+	info.Compression = compression
+	if err != nil {
+		return snapshot, info, fmt.Errorf("reading snapshot %s: %w", path, err)
+	}
+	return snapshot, info, nil
+}
+
+// ErrSnapshotChecksum is returned for a snapshot file that doesn't match
+// the checksum or count it was written with
+var ErrSnapshotChecksum = errors.New("snapshot fails its checksum")
+
+// writeFileAtomic is WriteFileAtomic creating files 0644
+func writeFileAtomic(path string, data []byte) error {
+	return WriteFileAtomic(path, data, 0o644)
+}
+
+// WriteFileAtomic replaces the file at path with data via a temp file in
+// the same directory, synced before it is renamed over path, and syncs the
+// directory after so the rename survives a crash. The file keeps the mode
+// of the one it replaces, perm if there is none. Each call has a temp file
+// of its own, so concurrent snapshots don't interleave.
+func WriteFileAtomic(path string, data []byte, perm os.FileMode) error {
+	mode := perm
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+	}
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	err = file.Chmod(mode)
+	if err == nil {
+		_, err = file.Write(data)
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// syncDir flushes the entries of dir, such as a rename into it, to disk;
+// Windows cannot sync a directory and persists renames itself
+func syncDir(dir string) error {
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	err = d.Sync()
+	if cerr := d.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// RestoreStats counts what RestoreBackup did with the entries of a snapshot
+type RestoreStats struct {
+	Loaded  int
+	Expired int // already past their TTL, dropped
+	Damaged int // failing their checksum or decryption, dropped
+	Kept    int // live in the store already, left as they were, see RestoreMissing
+}
+
+// restore modes, see RestoreOptions
+const (
+	RestoreReplace = "replace"
+	RestoreMerge   = "merge"
+	RestoreMissing = "missing"
+)
+
+// RestoreOptions say how RestoreBackupWith loads a snapshot. Mode is
+// RestoreReplace, the default, to replace the whole contents of the
+// store, RestoreMerge to write the snapshot's keys over the store's and
+// keep the rest, or RestoreMissing to add only the keys the store doesn't
+// have. A merge may be limited to the keys matching one of Patterns, in
+// path.Match syntax.
+type RestoreOptions struct {
+	Mode     string
+	Patterns []string
+}
+
+// ParseRestoreMode reads a restore mode, "" being RestoreReplace
+func ParseRestoreMode(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case "":
+		return RestoreReplace, nil
+	case RestoreReplace, RestoreMerge, RestoreMissing:
+		return s, nil
+	}
+	return "", fmt.Errorf("unknown restore mode %q, want replace, merge or missing", s)
+}
+
+// RestoreBackup replaces the contents of kvs with the snapshot in path,
+// as written by WriteBackup with its deltas, decrypting it with the keys
+// SetFileCipher gave. The files are read and checked completely before
+// kvs is touched, so a snapshot that can't be read, or fails its
+// checksum, leaves kvs as it was. Callers with a ServerProxy in front of kvs must Flush it
+// afterwards.
+func RestoreBackup(kvs *KeyValueStore, path string) (RestoreStats, error) {
+	return RestoreBackupWith(kvs, path, RestoreOptions{})
+}
+
+// RestoreBackupWith is RestoreBackup loading the snapshot as opts say;
+// patterns only go with a merge
+func RestoreBackupWith(kvs *KeyValueStore, path string, opts RestoreOptions) (RestoreStats, error) {
+	mode, err := ParseRestoreMode(opts.Mode)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	if err := checkPatterns(opts.Patterns); err != nil {
+		return RestoreStats{}, err
+	}
+	if mode == RestoreReplace && len(opts.Patterns) > 0 {
+		return RestoreStats{}, errors.New("key patterns need a merge, not a replace")
+	}
+	snapshot, _, _, err := readBackup(path, kvs.FileCipher())
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	if mode == RestoreReplace {
+		return kvs.LoadSnapshot(snapshot)
+	}
+	if len(opts.Patterns) > 0 {
+		for key := range snapshot.Data {
+			if !matchAny(opts.Patterns, key) {
+				delete(snapshot.Data, key)
+			}
+		}
+	}
+	return kvs.MergeSnapshot(snapshot, mode == RestoreMerge)
+}
+
+func checkPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("key pattern %q: %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+func matchAny(patterns []string, key string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// RestoreLatest replaces the contents of kvs with the most recent
+// snapshot that restores, for a server starting up: the backup file with
+// its deltas, else the snapshots SetRetention kept, newest first. If
+// there is no backup file but a sink, see SetSnapshotSink, the backup is
+// fetched from the sink first. path is the file restored, "" if there was
+// none, which leaves kvs as it was; so does an error, which says why each
+// snapshot there was failed.
+func RestoreLatest(ctx context.Context, kvs *KeyValueStore) (path string, stats RestoreStats, err error) {
+	backup, _ := kvs.Backup()
+	if sink := kvs.SnapshotSink(); sink != nil && !fileExists(backup) {
+		if err := FetchBackup(ctx, sink, backup); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			RecordError("Error fetching backup:", err)
+		}
+	}
+	candidates := []string{}
+	latest, _ := os.Stat(backup)
+	if latest != nil {
+		candidates = append(candidates, backup)
+	}
+	if kept, err := kvs.Backups(); err == nil {
+		for _, f := range kept {
+			name := filepath.Join(filepath.Dir(backup), f.Name)
+			// the backup file links to the newest when they are kept
+			if info, err := os.Stat(name); err == nil && latest != nil && os.SameFile(info, latest) {
+				continue
+			}
+			candidates = append(candidates, name)
+		}
+	}
+	var errs []error
+	for _, path := range candidates {
+		stats, err := RestoreBackup(kvs, path)
+		if err == nil {
+			return path, stats, nil
+		}
+		RecordError("Error restoring backup:", err)
+		errs = append(errs, err)
+	}
+	return "", RestoreStats{}, errors.Join(errs...)
+}
+
+// BackupReport is what VerifyBackup found in a snapshot file: Loaded is
+// how many entries a restore would load now, and Deltas how many deltas
+// it would apply after the full snapshot
+type BackupReport struct {
+	RestoreStats
+	SnapshotInfo
+	Deltas int
+}
+
+// VerifyBackup checks the snapshot in path the way RestoreBackup reads
+// it, without loading it, and reports what a restore would do with it
+func VerifyBackup(kvs *KeyValueStore, path string) (BackupReport, error) {
+	snapshot, info, deltas, err := readBackup(path, kvs.FileCipher())
+	if err != nil {
+		return BackupReport{}, err
+	}
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	stats, err := kvs.eachRestorable(snapshot, encryptionOf(kvs.data), func(string, KeyValue) {})
+	return BackupReport{RestoreStats: stats, SnapshotInfo: info, Deltas: deltas}, err
+}
+
+// LoadSnapshot replaces the contents of kvs with snapshot, dropping the
+// entries that have expired or fail their checksum. Entries keep their
+// revisions, and those from snapshots that had none get new ones. Sealed
+// values are opened with the key SetEncryption set; a snapshot none of
+// whose values open with it fails with ErrEncryptionKey, leaving kvs as it
+// was, and any other value that doesn't open is dropped as damaged. Open
+// read transactions are closed. Callers with a ServerProxy in front of kvs
+// must Flush it afterwards.
+func (kvs *KeyValueStore) LoadSnapshot(snapshot BackupSnapshot) (RestoreStats, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	threshold := 0
+	if c := compressionOf(kvs.data); c != nil {
+		threshold = c.threshold
+	}
+	aead := encryptionOf(kvs.data)
+	data, err := newStorage(kvs.data, aead, threshold)
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	sep := ""
+	if d, ok := kvs.data.(*dirEngine); ok {
+		sep = d.sep
+	}
+	deltas := kvs.deltas
+	if deltas != nil {
+		// the next snapshot must hold everything loaded
+		deltas = newDeltaLog(deltas.fullEvery)
+		data = &deltaEngine{engine: data, log: deltas}
+	}
+	if kvs.wal != nil {
+		// logged only if the whole snapshot loads
+		kvs.wal.hold()
+		kvs.wal.append(walRecord{Op: walClear})
+		data = &walEngine{engine: data, log: kvs.wal, aead: aead}
+	}
+	if kvs.repl != nil {
+		// replicas load a snapshot again anyway, see below
+		data = &replEngine{engine: data, kvs: kvs, log: kvs.repl}
+	}
+	data = withIndexes(data, sep, orderOf(kvs.data) != nil, searchOf(kvs.data) != nil)
+	defer kvs.keepStamps()()
+	for _, item := range snapshot.Data {
+		kvs.revision = max(kvs.revision, item.Revision)
+	}
+	stats, err := kvs.eachRestorable(snapshot, aead, func(key string, item KeyValue) {
+		if item.Revision == 0 {
+			kvs.revise(&item)
+		}
+		data.set(key, item)
+	})
+	if kvs.wal != nil {
+		kvs.wal.release(err == nil)
+	}
+	if err != nil {
+		releaseStorage(data)
+		return RestoreStats{}, err
+	}
+	releaseStorage(kvs.data)
+	kvs.data = data
+	kvs.deltas = deltas
+	kvs.walMark = snapshot.WAL
+	if kvs.repl != nil {
+		kvs.repl.reset()
+	}
+	kvs.namespaces.recount(data)
+	kvs.stale.reset()
+	kvs.tracking.changedAll()
+	kvs.closeReads()
+	kvs.zsets.reset()
+	return stats, nil
+}
+
+// MergeSnapshot writes the entries of snapshot into kvs, dropping those
+// that have expired or fail their checksum as LoadSnapshot does, and
+// leaves the other keys of kvs as they are. A key kvs has live is
+// overwritten if overwrite is set, else kept. The entries written get new
+// revisions, and open read transactions still see what they replaced.
+func (kvs *KeyValueStore) MergeSnapshot(snapshot BackupSnapshot, overwrite bool) (RestoreStats, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	type entry struct {
+		key  string
+		item KeyValue
+	}
+	var entries []entry
+	stats, err := kvs.eachRestorable(snapshot, encryptionOf(kvs.data), func(key string, item KeyValue) {
+		entries = append(entries, entry{key, item})
+	})
+	if err != nil {
+		return RestoreStats{}, err
+	}
+	now := kvs.now()
+	for _, e := range entries {
+		old, exists := kvs.data.get(e.key)
+		if exists && !overwrite && !kvs.expired(old, now) {
+			stats.Loaded--
+			stats.Kept++
+			continue
+		}
+		if exists {
+			kvs.namespaces.remove(e.key, old)
+		}
+		kvs.revise(&e.item)
+		if exists {
+			kvs.retire(e.key, old, e.item.Revision)
+		}
+		kvs.data.set(e.key, e.item)
+		kvs.namespaces.add(e.key, e.item)
+		kvs.stale.add(e.key, e.item.Revision)
+		kvs.tracking.changed(e.key)
+		kvs.zsets.drop(e.key)
+	}
+	return stats, nil
+}
+
+// eachRestorable calls fn with each entry of snapshot a restore loads,
+// its value opened with aead if it is sealed, and counts every entry in
+// stats; see LoadSnapshot. Caller must hold kvs.mu.
+func (kvs *KeyValueStore) eachRestorable(snapshot BackupSnapshot, aead cipher.AEAD, fn func(key string, item KeyValue)) (stats RestoreStats, err error) {
+	now := kvs.now()
+	opened, sealed := 0, 0
+	for key, item := range snapshot.Data {
+		if item.Encrypted {
+			sealed++
+			if aead == nil {
+				return RestoreStats{}, ErrEncryptionKey
+			}
+			var err error
+			if item, err = openText(aead, key, item); err != nil {
+				RecordError("Error restoring backup:", fmt.Errorf("value of %q doesn't decrypt: %w", key, err))
+				stats.Damaged++
+				continue
+			}
+			opened++
+		}
+		switch {
+		case kvs.expired(item, now):
+			stats.Expired++
+		case !item.Intact():
+			RecordError("Error restoring backup:", fmt.Errorf("value of %q fails its checksum", key))
+			stats.Damaged++
+		default:
+			fn(key, item)
+			stats.Loaded++
+		}
+	}
+	if sealed > 0 && opened == 0 {
+		return RestoreStats{}, ErrEncryptionKey
+	}
+	return stats, nil
+}
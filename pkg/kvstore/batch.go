@@ -0,0 +1,146 @@
+package kvstore
+
+import (
+	"slices"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// BatchOp is one write of a BATCH: a SET of Key to Value, with Checksum as
+// in SETSUM, or if Delete a DELETE of Key
+type BatchOp struct {
+	Key      string
+	Value    string
+	Checksum uint32
+	Delete   bool
+}
+
+// batchUndo is what a BATCH rolls key back to: old, or nothing if the key
+// did not exist
+type batchUndo struct {
+	key     string
+	old     KeyValue
+	existed bool
+}
+
+// BATCH applies ops in order as one write: either every one of them is
+// applied or none is. The sets expire after ttl, or the store's default,
+// and each op is checked as SETSUM would check it, so a value that fails
+// its checksum or goes over its namespace's quota rolls back the ops
+// before it; failed is the index of that op and message why. The store's
+// memory limit is checked once the whole batch is in, if it grew the
+// store, so other keys are evicted only for a batch that otherwise
+// stands; if it is over anyway, failed is the first set. Deleting a
+// missing key is not a failure. No reader sees some of the writes without
+// the rest, and nothing is emitted for a batch that is rolled back, though
+// the revisions it took are not given again. revs has the revision of
+// each op, zero for the delete of a missing key.
+func (kvs *KeyValueStore) BATCH(ops []BatchOp, ttl time.Duration) (revs []uint64, failed int, message string, ok bool) {
+	for i, op := range ops {
+		if !op.Delete && !(KeyValue{Value: op.Value, Checksum: op.Checksum}).Intact() {
+			return nil, i, protocol.MsgIntegrity, false
+		}
+	}
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	start, used := kvs.revision, kvs.usedMemory()
+	undo := make([]batchUndo, 0, len(ops))
+	revs = make([]uint64, len(ops))
+	for i, op := range ops {
+		old, exists := kvs.data.get(op.Key)
+		if op.Delete {
+			if !exists {
+				continue
+			}
+			if old.Type == TypeSequence {
+				kvs.rollback(undo, start)
+				return nil, i, protocol.MsgWrongType, false
+			}
+			undo = append(undo, batchUndo{op.Key, old, true})
+			kvs.revision++
+			revs[i] = kvs.revision
+			kvs.retire(op.Key, old, kvs.revision)
+			kvs.data.delete(op.Key)
+			kvs.namespaces.remove(op.Key, old)
+			continue
+		}
+		item := KeyValue{Value: op.Value, Timestamp: now, TTL: ttl, Checksum: op.Checksum}
+		if item.TTL <= 0 {
+			item.TTL = kvs.namespaces.ttl(op.Key)
+		}
+		if message = kvs.admitQuota(op.Key, old, exists, item); message != "" {
+			kvs.rollback(undo, start)
+			return nil, i, message, false
+		}
+		undo = append(undo, batchUndo{op.Key, old, exists})
+		if exists {
+			kvs.namespaces.remove(op.Key, old)
+		}
+		kvs.revise(&item)
+		if exists {
+			kvs.retire(op.Key, old, item.Revision)
+		}
+		kvs.data.set(op.Key, item)
+		kvs.namespaces.add(op.Key, item)
+		revs[i] = item.Revision
+	}
+	keys := make([]string, len(ops))
+	for i, op := range ops {
+		keys[i] = op.Key
+	}
+	if kvs.usedMemory() > used && !kvs.makeRoom(0, keys) {
+		kvs.memory.rejected++
+		kvs.rollback(undo, start)
+		return nil, slices.IndexFunc(ops, func(op BatchOp) bool { return !op.Delete }), protocol.MsgOutOfMemory, false
+	}
+	for i, op := range ops {
+		switch {
+		case !op.Delete:
+			kvs.emit(EventSet, op.Key, op.Value, now)
+		case revs[i] != 0:
+			kvs.emit(EventDelete, op.Key, "", now)
+		}
+	}
+	// only once the batch stands, the undo entries holding what the
+	// deletes removed
+	for _, u := range undo {
+		if _, exists := kvs.data.get(u.key); u.existed && !exists {
+			kvs.trashKey(u.key, u.old, now)
+		}
+	}
+	return revs, -1, "", true
+}
+
+// rollback undoes the writes of a BATCH, latest first, and forgets the
+// versions it kept from revision start on. The revisions themselves are
+// not taken back, as a key evicted meanwhile may have been given one.
+// Caller must hold kvs.mu.
+func (kvs *KeyValueStore) rollback(undo []batchUndo, start uint64) {
+	for i := len(undo) - 1; i >= 0; i-- {
+		u := undo[i]
+		if current, ok := kvs.data.get(u.key); ok {
+			kvs.namespaces.remove(u.key, current)
+			kvs.data.delete(u.key)
+		}
+		if u.existed {
+			kvs.data.set(u.key, u.old)
+			kvs.namespaces.add(u.key, u.old)
+		}
+		kvs.versions.forget(u.key, start)
+		// forget the entries retire kept for open reads
+		kept := kvs.history[u.key][:0]
+		for _, v := range kvs.history[u.key] {
+			if v.until <= start {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(kvs.history, u.key)
+		} else {
+			kvs.history[u.key] = kept
+		}
+	}
+}
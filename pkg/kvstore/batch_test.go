@@ -0,0 +1,105 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// newFullStore returns a store of ten keys, old/0 to old/9, with a memory
+// limit they just fit under
+func newFullStore(t *testing.T, policy MaxMemoryPolicy) *KeyValueStore {
+	t.Helper()
+	kvs := NewKeyValueStore()
+	for i := 0; i < 10; i++ {
+		kvs.SET(fmt.Sprintf("old/%d", i), strings.Repeat("v", 100))
+	}
+	kvs.SetMaxMemory(kvs.MemoryUsage().Used+50, policy)
+	return kvs
+}
+
+func TestBatchApplies(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SET("gone", "1")
+	revs, failed, message, ok := kvs.BATCH([]BatchOp{
+		{Key: "a", Value: "1"},
+		{Key: "gone", Delete: true},
+		{Key: "missing", Delete: true},
+	}, 0)
+	if !ok || failed != -1 {
+		t.Fatalf("BATCH failed at %d: %s", failed, message)
+	}
+	if revs[0] == 0 || revs[1] <= revs[0] || revs[2] != 0 {
+		t.Errorf("revisions %v", revs)
+	}
+	if _, found := kvs.GET("gone"); found {
+		t.Error("deleted key still there")
+	}
+	if value, _ := kvs.GET("a"); value != "1" {
+		t.Errorf("GET a = %q", value)
+	}
+}
+
+func TestBatchRollsBackWithoutEvicting(t *testing.T) {
+	kvs := newFullStore(t, MaxMemoryLRU)
+	if err := kvs.SetNamespaces([]Namespace{{Prefix: "q/", MaxKeys: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	events := kvs.Events()
+	before := kvs.Revision()
+	_, failed, message, ok := kvs.BATCH([]BatchOp{
+		{Key: "big", Value: strings.Repeat("v", 500)},
+		{Key: "q/a", Value: "1"},
+		{Key: "q/b", Value: "1"},
+	}, 0)
+	if ok || failed != 2 || message != protocol.MsgQuotaExceeded {
+		t.Fatalf("BATCH = %d, %s, %v; want 2, %s", failed, message, ok, protocol.MsgQuotaExceeded)
+	}
+	if evicted := kvs.MemoryUsage().Evicted; evicted != 0 {
+		t.Errorf("a failed BATCH evicted %d keys", evicted)
+	}
+	if kvs.Len() != 10 {
+		t.Errorf("%d keys after a failed BATCH, want the 10 before it", kvs.Len())
+	}
+	select {
+	case e := <-events:
+		t.Errorf("a failed BATCH emitted %+v", e)
+	case <-time.After(10 * time.Millisecond):
+	}
+	// the revisions it took are left as a gap
+	if after := kvs.Revision(); after < before {
+		t.Errorf("revision went back from %d to %d", before, after)
+	}
+	kvs.SET("next", "1")
+	if item, _ := kvs.GETKV("next"); item.Revision <= before+2 {
+		t.Errorf("revision %d given again after a failed BATCH that took up to %d", item.Revision, before+2)
+	}
+}
+
+func TestBatchEvictsOnlyForABatchThatStands(t *testing.T) {
+	kvs := newFullStore(t, MaxMemoryLRU)
+	if _, failed, message, ok := kvs.BATCH([]BatchOp{{Key: "big", Value: strings.Repeat("v", 200)}}, 0); !ok {
+		t.Fatalf("BATCH failed at %d: %s", failed, message)
+	}
+	if kvs.MemoryUsage().Evicted == 0 {
+		t.Error("BATCH over the limit evicted nothing")
+	}
+	if _, found := kvs.GET("big"); !found {
+		t.Error("the batch's own key was evicted")
+	}
+
+	kvs = newFullStore(t, MaxMemoryReject)
+	_, failed, message, ok := kvs.BATCH([]BatchOp{
+		{Key: "old/0", Delete: true},
+		{Key: "big", Value: strings.Repeat("v", 500)},
+	}, 0)
+	if ok || failed != 1 || message != protocol.MsgOutOfMemory {
+		t.Fatalf("BATCH = %d, %s, %v; want 1, %s", failed, message, ok, protocol.MsgOutOfMemory)
+	}
+	if _, found := kvs.GET("old/0"); !found || kvs.Len() != 10 {
+		t.Errorf("BATCH refused for memory left %d keys, old/0 %v", kvs.Len(), found)
+	}
+}
@@ -0,0 +1,56 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+)
+
+// BackupStatus is the state of the snapshots StartBackup runs: whether
+// one is Running, when the running or last one Started, and when the last
+// one Finished, with its Err. All are zero before the first.
+type BackupStatus struct {
+	Running  bool
+	Started  time.Time
+	Finished time.Time
+	Err      error
+}
+
+// bgSave tracks the snapshots StartBackup runs
+type bgSave struct {
+	mu     sync.Mutex
+	status BackupStatus
+}
+
+// StartBackup runs WriteBackup in the background and reports whether it
+// started one; while one it started is running it starts none, so callers
+// can ask for a snapshot as often as they like. BackgroundBackup tells
+// when it is done.
+func StartBackup(kvs *KeyValueStore) bool {
+	b := &kvs.bgsave
+	b.mu.Lock()
+	if b.status.Running {
+		b.mu.Unlock()
+		return false
+	}
+	b.status.Running, b.status.Started = true, time.Now()
+	b.mu.Unlock()
+	go func() {
+		err := WriteBackup(kvs)
+		if err != nil {
+			RecordError("Error writing background backup:", err)
+		} else {
+			Logf(LogInfo, "Background backup written")
+		}
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		b.status.Running, b.status.Finished, b.status.Err = false, time.Now(), err
+	}()
+	return true
+}
+
+// BackgroundBackup returns the state of the snapshots StartBackup runs
+func (kvs *KeyValueStore) BackgroundBackup() BackupStatus {
+	kvs.bgsave.mu.Lock()
+	defer kvs.bgsave.mu.Unlock()
+	return kvs.bgsave.status
+}
@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"math/bits"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Bitmaps are plain string values read bit by bit, the most significant
+// bit of the first byte being offset 0, so a flag per user ID takes one
+// bit of the key's value.
+
+// SETBIT sets the bit at offset in key's value to bit, 0 or 1, and returns
+// what it was. A value too short is padded with zero bytes first, a
+// missing key being empty; see modify for its lifetime and checksum. An
+// offset outside 0 to protocol.MaxBitOffset, or a bit other than 0 or 1,
+// fails with protocol.MsgInvalidArgument.
+func (kvs *KeyValueStore) SETBIT(key string, offset, bit int, ttl time.Duration) (old int, message string, ok bool) {
+	if !validBitOffset(offset) || bit != 0 && bit != 1 {
+		return 0, protocol.MsgInvalidArgument, false
+	}
+	_, message, ok = kvs.modify(key, ttl, func(value string, _ bool) (string, string) {
+		old, _, _ = GetBit(value, offset)
+		if n := offset/8 + 1; len(value) < n {
+			value += string(make([]byte, n-len(value)))
+		}
+		if old == bit {
+			return value, ""
+		}
+		b := []byte(value)
+		b[offset/8] ^= 0x80 >> (offset % 8)
+		return string(b), ""
+	})
+	return old, message, ok
+}
+
+// GetBit returns the bit at offset in value, 0 past its end; an offset
+// outside 0 to protocol.MaxBitOffset fails with
+// protocol.MsgInvalidArgument
+func GetBit(value string, offset int) (bit int, message string, ok bool) {
+	if !validBitOffset(offset) {
+		return 0, protocol.MsgInvalidArgument, false
+	}
+	if offset/8 >= len(value) {
+		return 0, "", true
+	}
+	return int(value[offset/8]>>(7-offset%8)) & 1, "", true
+}
+
+// validBitOffset reports whether SETBIT and GetBit take offset
+func validBitOffset(offset int) bool {
+	return offset >= 0 && offset <= protocol.MaxBitOffset
+}
+
+// BitCount returns how many bits of value are set
+func BitCount(value string) int {
+	n := 0
+	for i := 0; i < len(value); i++ {
+		n += bits.OnesCount8(value[i])
+	}
+	return n
+}
@@ -0,0 +1,44 @@
+package kvstore
+
+import (
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestSetBit(t *testing.T) {
+	kvs := NewKeyValueStore()
+	if old, message, ok := kvs.SETBIT("k", 9, 1, 0); !ok || old != 0 {
+		t.Fatalf("SETBIT = %d, %s, %v", old, message, ok)
+	}
+	if value, _ := kvs.GET("k"); value != "\x00\x40" {
+		t.Fatalf("value %q, want \\x00\\x40", value)
+	}
+	if old, _, _ := kvs.SETBIT("k", 9, 0, 0); old != 1 {
+		t.Fatalf("SETBIT returned old bit %d, want 1", old)
+	}
+	if bit, _, ok := GetBit("\x80", 0); !ok || bit != 1 {
+		t.Fatalf("GetBit = %d, %v", bit, ok)
+	}
+	if bit, _, ok := GetBit("", protocol.MaxBitOffset); !ok || bit != 0 {
+		t.Fatalf("GetBit past the end = %d, %v", bit, ok)
+	}
+}
+
+func TestBitOffsetBounds(t *testing.T) {
+	kvs := NewKeyValueStore()
+	for _, offset := range []int{-1, -8, protocol.MaxBitOffset + 1, 1 << 40} {
+		if _, message, ok := kvs.SETBIT("k", offset, 1, 0); ok || message != protocol.MsgInvalidArgument {
+			t.Errorf("SETBIT(%d) = %s, %v; want %s", offset, message, ok, protocol.MsgInvalidArgument)
+		}
+		if _, message, ok := GetBit("abc", offset); ok || message != protocol.MsgInvalidArgument {
+			t.Errorf("GetBit(%d) = %s, %v; want %s", offset, message, ok, protocol.MsgInvalidArgument)
+		}
+	}
+	if _, message, ok := kvs.SETBIT("k", 0, 2, 0); ok || message != protocol.MsgInvalidArgument {
+		t.Errorf("SETBIT of bit 2 = %s, %v", message, ok)
+	}
+	if _, found := kvs.GET("k"); found {
+		t.Error("a refused SETBIT created the key")
+	}
+}
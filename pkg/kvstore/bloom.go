@@ -0,0 +1,181 @@
+package kvstore
+
+import (
+	"hash/maphash"
+	"sync/atomic"
+)
+
+// bloomBitsPerKey and bloomHashes make a false positive, a missing key the
+// filter lets through to the store, about 1 in 100
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 7
+	bloomMinKeys    = 1024
+)
+
+// bloomFilter is a Bloom filter sized for capacity keys. Keys are added
+// by one writer at a time, under the store's lock, while readers test
+// them without it.
+type bloomFilter struct {
+	bits     []atomic.Uint64
+	seed     maphash.Seed
+	capacity int64
+	added    atomic.Int64
+}
+
+// newBloomFilter returns a filter with room for twice keys, so it fills
+// up only after the store has grown as much again
+func newBloomFilter(keys int) *bloomFilter {
+	capacity := int64(max(2*keys, bloomMinKeys))
+	return &bloomFilter{
+		bits:     make([]atomic.Uint64, (capacity*bloomBitsPerKey+63)/64),
+		seed:     maphash.MakeSeed(),
+		capacity: capacity,
+	}
+}
+
+// each calls fn with the bloomHashes bits of key, by double hashing
+func (f *bloomFilter) each(key string, fn func(word int, mask uint64) bool) {
+	h := maphash.String(f.seed, key)
+	h1, h2 := h, h>>32|1
+	n := uint64(len(f.bits)) * 64
+	for i := uint64(0); i < bloomHashes; i++ {
+		bit := (h1 + i*h2) % n
+		if !fn(int(bit/64), 1<<(bit%64)) {
+			return
+		}
+	}
+}
+
+// add sets the bits of key; caller must hold the store's lock for writing
+// or own the filter
+func (f *bloomFilter) add(key string) {
+	f.each(key, func(word int, mask uint64) bool {
+		if w := f.bits[word].Load(); w&mask == 0 {
+			f.bits[word].Store(w | mask)
+		}
+		return true
+	})
+	f.added.Add(1)
+}
+
+// mayContain reports whether key may have been added; false means it
+// certainly was not
+func (f *bloomFilter) mayContain(key string) bool {
+	found := true
+	f.each(key, func(word int, mask uint64) bool {
+		found = f.bits[word].Load()&mask != 0
+		return found
+	})
+	return found
+}
+
+// full reports whether more keys were added than the filter was sized
+// for, so it lets too many missing keys through
+func (f *bloomFilter) full() bool {
+	return f.added.Load() > f.capacity
+}
+
+// keyFilter is the store's Bloom filter over its keys, see SetBloomFilter.
+// Keys are added as they are written, never taken out; the filter is
+// rebuilt from the live keys by full snapshots, restores and, once it is
+// full, the janitor.
+type keyFilter struct {
+	bloom   atomic.Pointer[bloomFilter] // nil when off
+	skipped atomic.Uint64               // reads it answered
+}
+
+// add adds key, caller must hold the store's lock for writing
+func (k *keyFilter) add(key string) {
+	if f := k.bloom.Load(); f != nil {
+		f.add(key)
+	}
+}
+
+// absent reports whether key is certainly not in the store. A full filter
+// answers nothing, until it is rebuilt.
+func (k *keyFilter) absent(key string) bool {
+	f := k.bloom.Load()
+	if f == nil || f.full() || f.mayContain(key) {
+		return false
+	}
+	k.skipped.Add(1)
+	return true
+}
+
+// fresh returns an empty filter for keys keys if the filter is on, nil
+// if not; swap puts it in place once it holds them
+func (k *keyFilter) fresh(keys int) *bloomFilter {
+	if k.bloom.Load() == nil {
+		return nil
+	}
+	return newBloomFilter(keys)
+}
+
+// swap replaces the filter with f, a filter fresh returned, if it is not
+// nil
+func (k *keyFilter) swap(f *bloomFilter) {
+	if f != nil {
+		k.bloom.Store(f)
+	}
+}
+
+// rebuild builds the filter, if it is on, again from the keys of data;
+// caller must hold the store's lock
+func (k *keyFilter) rebuild(data engine) {
+	if k.bloom.Load() != nil {
+		k.bloom.Store(buildBloomFilter(data))
+	}
+}
+
+// buildBloomFilter returns a filter holding the keys of data
+func buildBloomFilter(data engine) *bloomFilter {
+	f := newBloomFilter(data.len())
+	data.each(func(key string, _ KeyValue) bool {
+		f.add(key)
+		return true
+	})
+	return f
+}
+
+// full reports whether the filter is on and full
+func (k *keyFilter) full() bool {
+	f := k.bloom.Load()
+	return f != nil && f.full()
+}
+
+// BloomFilterStats is the store's Bloom filter: whether it is On, its
+// size in Bytes, the Keys added since it was last built and the reads of
+// missing keys it answered without the store, Skipped
+type BloomFilterStats struct {
+	On      bool
+	Bytes   int64
+	Keys    int64
+	Skipped uint64
+}
+
+// SetBloomFilter keeps a Bloom filter over the store's keys, so a GETKV,
+// and every read built on it, of a key that certainly doesn't exist is
+// answered without taking the store's lock or reading a disk engine. The
+// filter costs about 10 bits per key and lets about 1 missing key in 100
+// through to the store. Deleted keys stay in it until it is rebuilt, by
+// every full snapshot and restore, and by the janitor once more keys were
+// written than it was sized for.
+func (kvs *KeyValueStore) SetBloomFilter(on bool) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if !on {
+		kvs.filter.bloom.Store(nil)
+		return
+	}
+	kvs.filter.bloom.Store(buildBloomFilter(kvs.data))
+}
+
+// BloomFilterStats returns the state of the filter of SetBloomFilter
+func (kvs *KeyValueStore) BloomFilterStats() BloomFilterStats {
+	stats := BloomFilterStats{Skipped: kvs.filter.skipped.Load()}
+	if f := kvs.filter.bloom.Load(); f != nil {
+		stats.On, stats.Bytes, stats.Keys = true, int64(len(f.bits))*8, f.added.Load()
+	}
+	return stats
+}
@@ -0,0 +1,311 @@
+package kvstore
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// cacheEntry is a cached value and when it was cached
+type cacheEntry struct {
+	kv     KeyValue
+	cost   int64
+	cached time.Time
+	used   atomic.Bool // a hit is waiting in the cache's reads
+}
+
+// cacheEntryOverhead is what an entry costs besides its key and value:
+// the entry itself, its map slot and its place in the policy, about a
+// list node and a map slot again
+const cacheEntryOverhead = int64(2*(unsafe.Sizeof("")+unsafe.Sizeof(&cacheEntry{})) +
+	unsafe.Sizeof(cacheEntry{}) + unsafe.Sizeof(listNode{}))
+
+// cacheReadBuffer is how many hits served under the proxy's read lock
+// wait for the policy to hear of them, see proxyCache.hit
+const cacheReadBuffer = 1024
+
+// maxStaleKeys is how many changed keys the store keeps for a ServerProxy
+// before it gives up on them and has the proxy empty its cache instead
+const maxStaleKeys = 65536
+
+// staleKeys are the keys the store changed that a ServerProxy caching it
+// has yet to drop. Every change is recorded, as it is made and under the
+// store's lock, whether it went through the proxy or not: the store's own
+// methods called directly, expiry, eviction and restores. A cache hit
+// checks the key it reads against them and the proxy takes them all in
+// before any other lookup, so it never serves a value older than a change
+// that finished before the read began.
+type staleKeys struct {
+	on      atomic.Bool // a ServerProxy caches the store
+	pending atomic.Bool // keys or all is set
+	mu      sync.RWMutex
+	keys    map[string]uint64 // the latest revision each key changed at
+	all     bool              // every key is stale, e.g. after a restore
+}
+
+// add records that key changed at rev
+func (s *staleKeys) add(key string, rev uint64) {
+	if !s.on.Load() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	switch {
+	case s.all:
+	case len(s.keys) >= maxStaleKeys:
+		s.keys, s.all = nil, true
+	default:
+		if s.keys == nil {
+			s.keys = make(map[string]uint64)
+		}
+		s.keys[key] = max(s.keys[key], rev)
+	}
+	s.pending.Store(true)
+}
+
+// reset records that every key changed
+func (s *staleKeys) reset() {
+	if !s.on.Load() {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.keys, s.all = nil, true
+	s.pending.Store(true)
+}
+
+// has reports whether key may have changed since take was last called. It
+// also reports true once half of maxStaleKeys are waiting, so a proxy that
+// only hits takes them in before they overflow and empty its cache.
+func (s *staleKeys) has(key string) bool {
+	if !s.pending.Load() {
+		return false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, changed := s.keys[key]
+	return changed || s.all || len(s.keys) >= maxStaleKeys/2
+}
+
+// take returns the changes recorded since it was last called
+func (s *staleKeys) take() (keys map[string]uint64, all bool) {
+	if !s.pending.Load() {
+		return nil, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys, all = s.keys, s.all
+	s.keys, s.all = nil, false
+	s.pending.Store(false)
+	return keys, all
+}
+
+// proxyCache is the cache of a ServerProxy: up to maxKeys entries and
+// maxBytes bytes of them, either being no limit if zero, evicting the ones
+// its policy picks when it is full. An entry older than ttl, if above
+// zero, is read from the store again.
+type proxyCache struct {
+	entries    map[string]*cacheEntry
+	policy     EvictionPolicy
+	policyName string
+	bytes      int64
+	maxKeys    int
+	maxBytes   int64
+	ttl        time.Duration
+	reads      chan string // keys hit since the policy last heard of hits
+	hits       atomic.Uint64
+	misses     uint64
+	evictions  uint64
+}
+
+// CacheStats is how the cache of a ServerProxy has fared since it was
+// created: Hits are reads it served, Misses reads that went to the store
+// and Evictions the keys it dropped to fit its limits. Keys and Bytes are
+// what it holds now, against its limits MaxKeys and MaxBytes. Under
+// WriteBack, Pending are the writes buffered now, and Flushed and Failed
+// the ones the store took and refused.
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Keys      int
+	Bytes     int64
+	MaxKeys   int
+	MaxBytes  int64
+	Pending   int
+	Flushed   uint64
+	Failed    uint64
+}
+
+// HitRatio is the share of reads the cache served, 0 before any
+func (s CacheStats) HitRatio() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// FillRatio is how full the cache is, by the limit it is closest to, 0 if
+// it has none
+func (s CacheStats) FillRatio() float64 {
+	var fill float64
+	if s.MaxKeys > 0 {
+		fill = float64(s.Keys) / float64(s.MaxKeys)
+	}
+	if s.MaxBytes > 0 {
+		fill = max(fill, float64(s.Bytes)/float64(s.MaxBytes))
+	}
+	return fill
+}
+
+func newProxyCache() *proxyCache {
+	policy, _ := newEvictionPolicy(EvictLRU)
+	return &proxyCache{
+		entries:    make(map[string]*cacheEntry),
+		policy:     policy,
+		policyName: EvictLRU,
+		reads:      make(chan string, cacheReadBuffer),
+	}
+}
+
+// get returns the value cached for key, telling the policy it was used.
+// An entry older than the cache's TTL at now is dropped, unless keep
+// protects it.
+func (c *proxyCache) get(key string, now time.Time, keep func(key string) bool) (KeyValue, bool) {
+	c.drain()
+	e, ok := c.entries[key]
+	if !ok {
+		return KeyValue{}, false
+	}
+	if c.ttl > 0 && now.Sub(e.cached) >= c.ttl && !keep(key) {
+		c.remove(key)
+		return KeyValue{}, false
+	}
+	c.policy.Access(key)
+	return e.kv, true
+}
+
+// hit is get for a reader holding the proxy's lock only for reading, so
+// hits on any keys run in parallel. The policy can't be told under a read
+// lock, so the key is queued for it, once until the next drain: the policy
+// sees the keys hit in between in the order they were first hit. It
+// leaves an entry past the cache's TTL, and every hit once the queue is
+// full, to get.
+func (c *proxyCache) hit(key string, now time.Time) (KeyValue, bool) {
+	e, ok := c.entries[key]
+	if !ok || c.ttl > 0 && now.Sub(e.cached) >= c.ttl {
+		return KeyValue{}, false
+	}
+	if !e.used.Load() && e.used.CompareAndSwap(false, true) {
+		select {
+		case c.reads <- key:
+		default:
+			e.used.Store(false)
+			return KeyValue{}, false
+		}
+	}
+	return e.kv, true
+}
+
+// drain tells the policy of the keys hit queued; caller must hold the
+// proxy's lock for writing
+func (c *proxyCache) drain() {
+	for {
+		select {
+		case key := <-c.reads:
+			if e, ok := c.entries[key]; ok && e.used.Swap(false) {
+				c.policy.Access(key)
+			}
+		default:
+			return
+		}
+	}
+}
+
+// peek returns the value cached for key without counting it as used
+func (c *proxyCache) peek(key string) (KeyValue, bool) {
+	e, ok := c.entries[key]
+	if !ok {
+		return KeyValue{}, false
+	}
+	return e.kv, true
+}
+
+// put caches kv for key at now, then evicts the keys the policy picks, the ones
+// keep protects excepted, until the cache fits its limits. A value bigger
+// than the whole cache is not cached.
+func (c *proxyCache) put(key string, kv KeyValue, now time.Time, keep func(key string) bool) {
+	cost := int64(len(key)+len(kv.Value)) + cacheEntryOverhead
+	if c.maxBytes > 0 && cost > c.maxBytes {
+		c.remove(key)
+		return
+	}
+	if e, ok := c.entries[key]; ok {
+		c.bytes += cost - e.cost
+		e.kv, e.cost, e.cached = kv, cost, now
+		c.policy.Access(key)
+	} else {
+		c.entries[key] = &cacheEntry{kv: kv, cost: cost, cached: now}
+		c.bytes += cost
+		c.policy.Add(key)
+	}
+	c.evict(keep)
+}
+
+// evict drops the entries the policy offers and keep doesn't protect until
+// the cache fits its limits or only protected ones are left
+func (c *proxyCache) evict(keep func(key string) bool) {
+	if !c.over() {
+		return
+	}
+	c.drain()
+	c.policy.Victims(func(key string) bool {
+		if !keep(key) {
+			c.remove(key)
+			c.evictions++
+		}
+		return c.over()
+	})
+}
+
+// over reports whether the cache holds more than its limits allow
+func (c *proxyCache) over() bool {
+	return c.maxKeys > 0 && len(c.entries) > c.maxKeys || c.maxBytes > 0 && c.bytes > c.maxBytes
+}
+
+// remove forgets key
+func (c *proxyCache) remove(key string) {
+	e, ok := c.entries[key]
+	if !ok {
+		return
+	}
+	delete(c.entries, key)
+	c.policy.Remove(key)
+	c.bytes -= e.cost
+}
+
+func (c *proxyCache) len() int { return len(c.entries) }
+
+// reset forgets every entry, keeping the limits, the TTL and the policy
+func (c *proxyCache) reset() {
+	c.entries = make(map[string]*cacheEntry)
+	c.policy.Reset()
+	c.bytes = 0
+}
+
+// setPolicy makes policy, registered as name, pick the victims from now
+// on. The cached keys are handed to it in the order the old one would
+// have evicted them, so the most valuable are added last.
+func (c *proxyCache) setPolicy(name string, policy EvictionPolicy) {
+	c.drain()
+	keys := make([]string, 0, len(c.entries))
+	c.policy.Victims(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	})
+	for _, key := range keys {
+		policy.Add(key)
+	}
+	c.policy, c.policyName = policy, name
+}
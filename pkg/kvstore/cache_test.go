@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestSharedHitsReachEvictionPolicy(t *testing.T) {
+	kvs := NewKeyValueStore()
+	sp := NewServerProxy(kvs)
+	sp.SetCacheSize(2)
+	for _, key := range []string{"a", "b", "c"} {
+		kvs.SET(key, "v")
+	}
+	sp.GET("a")
+	sp.GET("b")
+	// served under the read lock, the policy only hears of it later
+	sp.GET("a")
+	sp.GET("c")
+	if _, ok := sp.cache.peek("a"); !ok {
+		t.Error("a was evicted though it was the most recently read")
+	}
+	if _, ok := sp.cache.peek("b"); ok {
+		t.Error("b was kept though it was the least recently read")
+	}
+}
+
+func TestHitsOnlyMissKeysThatChanged(t *testing.T) {
+	kvs := NewKeyValueStore()
+	sp := NewServerProxy(kvs)
+	kvs.SET("a", "1")
+	kvs.SET("b", "1")
+	sp.GET("a")
+	sp.GET("b")
+	kvs.SET("b", "2")
+	if _, ok := sp.hit("a"); !ok {
+		t.Error("a missed the cache after a write to b")
+	}
+	if _, ok := sp.hit("b"); ok {
+		t.Error("b hit the cache after it was written")
+	}
+	if value, _ := sp.GET("b"); value != "2" {
+		t.Errorf("GET b = %q, want 2", value)
+	}
+	if _, ok := sp.hit("b"); !ok {
+		t.Error("b missed the cache once it was read again")
+	}
+}
+
+// BenchmarkProxyGETWithWriters reads cached keys from parallel goroutines
+// while others keep updating keys of the same keyspace, reporting how
+// many updates they got in per read
+func BenchmarkProxyGETWithWriters(b *testing.B) {
+	for _, writers := range []int{0, 1, 4} {
+		b.Run(fmt.Sprintf("writers=%d", writers), func(b *testing.B) {
+			sp := newBenchProxy(b)
+			for i := 0; i < benchKeys; i++ {
+				sp.GET(benchKey(i))
+			}
+			stop := make(chan struct{})
+			var wg sync.WaitGroup
+			var updates atomic.Int64
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					for {
+						select {
+						case <-stop:
+							return
+						default:
+						}
+						sp.UPDATE(benchKey(i), "updated")
+						updates.Add(1)
+						i += 31
+					}
+				}(w)
+			}
+			var next atomic.Int64
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				i := int(next.Add(1)) * 7919
+				for pb.Next() {
+					sp.GET(benchKey(i))
+					i++
+				}
+			})
+			b.StopTimer()
+			close(stop)
+			wg.Wait()
+			b.ReportMetric(float64(updates.Load())/float64(b.N), "updates/op")
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// ChangeSink is where change data capture publishes the store's events,
+// see KeyValueStore.Events, for downstream systems to build views from.
+// Publish delivers a batch in order, or fails as a whole and gets the
+// same batch again, so a sink delivers each event at least once.
+type ChangeSink interface {
+	Publish(ctx context.Context, events []Event) error
+	Close() error
+	String() string
+}
+
+// changeEvent is how a sink encodes an Event
+type changeEvent struct {
+	Type  string    `json:"type"`
+	Key   string    `json:"key"`
+	Value string    `json:"value,omitempty"`
+	Time  time.Time `json:"time"`
+}
+
+// MarshalChange encodes e as the JSON object sinks publish: its type,
+// "set", "update", "delete", "expire" or "evict", key, value for sets and
+// updates, and time.
+func MarshalChange(e Event) ([]byte, error) {
+	return json.Marshal(changeEvent{Type: e.Type.String(), Key: e.Key, Value: e.Value, Time: e.Time.UTC()})
+}
+
+// ParseChangeSink reads a change sink URL:
+// kafka://broker1:9092,broker2:9092/topic for a KafkaSink.
+func ParseChangeSink(s string) (ChangeSink, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	switch {
+	case ok && scheme == "kafka":
+		brokers, topic, _ := strings.Cut(rest, "/")
+		if brokers == "" || topic == "" {
+			return nil, fmt.Errorf("change sink %q needs brokers and a topic, as kafka://host:9092/topic", s)
+		}
+		return NewKafkaSink(strings.Split(brokers, ","), topic), nil
+	}
+	return nil, fmt.Errorf("unknown change sink %q, want kafka://", s)
+}
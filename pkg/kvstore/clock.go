@@ -0,0 +1,74 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+)
+
+// Clock tells a KeyValueStore the time: when keys are written, whether
+// they have expired and which window a counter lands in
+type Clock interface {
+	Now() time.Time
+}
+
+// SystemClock is the wall clock, every store's clock unless SetClock says
+// otherwise
+var SystemClock Clock = systemClock{}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManualClock is a Clock that stands still until it is moved, so tests of
+// expiry can step past a TTL instead of sleeping through it. It is safe
+// for concurrent use.
+type ManualClock struct {
+	mu  sync.Mutex
+	now time.Time
+}
+
+// NewManualClock returns a clock that reads start
+func NewManualClock(start time.Time) *ManualClock {
+	return &ManualClock{now: start}
+}
+
+// Now returns the time the clock was last set or moved to
+func (c *ManualClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// Advance moves the clock on by d
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+// Set moves the clock to t
+func (c *ManualClock) Set(t time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = t
+}
+
+// SetClock makes the store, its janitor and its ServerProxy read the time
+// from clock, SystemClock if it is nil; call it before the store is used.
+// Only the data's time is the clock's: the janitor still runs every
+// ClearInterval of real time, so a test moving the clock calls
+// ClearExpiredKeysNow to sweep, and backups, locks and timeouts keep to
+// the wall clock.
+func (kvs *KeyValueStore) SetClock(clock Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.clock = clock
+}
+
+// now is the time by the store's clock
+func (kvs *KeyValueStore) now() time.Time {
+	return kvs.clock.Now()
+}
@@ -0,0 +1,149 @@
+package kvstore
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// compressEngine is an engine that keeps values of at least threshold
+// bytes DEFLATE-compressed, marking them Compressed, whenever that makes
+// them smaller. Everything above it, the indexes, the store and backups,
+// sees the values as written. It sits under the indexes, and over
+// encryption, since sealed values don't compress.
+type compressEngine struct {
+	engine
+	threshold int
+	keys      int   // compressed values
+	raw       int64 // their bytes as written
+	stored    int64 // their bytes as kept
+}
+
+func newCompressEngine(inner engine, threshold int) *compressEngine {
+	return &compressEngine{engine: inner, threshold: threshold}
+}
+
+func (c *compressEngine) get(key string) (KeyValue, bool) {
+	kv, ok := c.engine.get(key)
+	if ok && kv.Compressed {
+		kv = decompressed(key, kv)
+	}
+	return kv, ok
+}
+
+func (c *compressEngine) set(key string, kv KeyValue) {
+	c.forget(key)
+	if len(kv.Value) >= c.threshold && !kv.Compressed {
+		if packed := compress(kv.Value); len(packed) < len(kv.Value) {
+			c.keys++
+			c.raw += int64(len(kv.Value))
+			c.stored += int64(len(packed))
+			kv.Value, kv.Compressed = packed, true
+		}
+	}
+	c.engine.set(key, kv)
+}
+
+func (c *compressEngine) delete(key string) {
+	c.forget(key)
+	c.engine.delete(key)
+}
+
+// forget takes the value key has now out of the counts
+func (c *compressEngine) forget(key string) {
+	old, ok := c.engine.get(key)
+	if !ok || !old.Compressed {
+		return
+	}
+	n, _ := binary.Uvarint([]byte(old.Value))
+	c.keys--
+	c.raw -= int64(n)
+	c.stored -= int64(len(old.Value))
+}
+
+func (c *compressEngine) each(fn func(key string, kv KeyValue) bool) {
+	c.engine.each(func(key string, kv KeyValue) bool {
+		if kv.Compressed {
+			kv = decompressed(key, kv)
+		}
+		return fn(key, kv)
+	})
+}
+
+// compress packs value as its length in bytes, as a uvarint, followed by
+// its DEFLATE stream
+func compress(value string) string {
+	var b bytes.Buffer
+	b.Write(binary.AppendUvarint(nil, uint64(len(value))))
+	w, _ := flate.NewWriter(&b, flate.BestSpeed)
+	io.WriteString(w, value)
+	w.Close()
+	return b.String()
+}
+
+// decompressed is kv with its value as written; a value that doesn't
+// decompress, which only damage to memory could cause, is recorded and
+// kept as it is
+func decompressed(key string, kv KeyValue) KeyValue {
+	n, size := binary.Uvarint([]byte(kv.Value))
+	if size <= 0 {
+		RecordError("Error decompressing value:", fmt.Errorf("key %q: bad length", key))
+		return kv
+	}
+	var b strings.Builder
+	b.Grow(int(n))
+	r := flate.NewReader(strings.NewReader(kv.Value[size:]))
+	if _, err := io.Copy(&b, r); err != nil || uint64(b.Len()) != n {
+		RecordError("Error decompressing value:", fmt.Errorf("key %q: %v", key, err))
+		return kv
+	}
+	kv.Value, kv.Compressed = b.String(), false
+	return kv
+}
+
+// compressionOf returns the compressing layer of e, nil if there is none
+func compressionOf(e engine) *compressEngine {
+	c, _ := storageOf(e).(*compressEngine)
+	return c
+}
+
+// SetCompression makes kvs keep values of at least threshold bytes
+// compressed when that saves space, or none if threshold <= 0. Each key
+// records whether its value is compressed, so values written before and
+// after a change are read alike. Changing the threshold rewrites every
+// value. Compression costs CPU on every write and every read that misses
+// the server's cache, and suits large text and JSON values.
+func (kvs *KeyValueStore) SetCompression(threshold int) error {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	current := 0
+	if c := compressionOf(kvs.data); c != nil {
+		current = c.threshold
+	}
+	if max(threshold, 0) == current {
+		return nil
+	}
+	return kvs.relayer(kvs.data, encryptionOf(kvs.data), threshold)
+}
+
+// CompressionStats is what compression saves, see SetCompression
+type CompressionStats struct {
+	Threshold int
+	Keys      int   // values kept compressed
+	RawBytes  int64 // their size as written
+	Bytes     int64 // their size as kept
+}
+
+// Compression returns what compression saves, all zero if it is off
+func (kvs *KeyValueStore) Compression() CompressionStats {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	c := compressionOf(kvs.data)
+	if c == nil {
+		return CompressionStats{}
+	}
+	return CompressionStats{Threshold: c.threshold, Keys: c.keys, RawBytes: c.raw, Bytes: c.stored}
+}
@@ -0,0 +1,210 @@
+package kvstore
+
+import (
+	"cmp"
+	"time"
+)
+
+// TombstoneTTL is how long a multi-primary store remembers a deleted key,
+// so a write to it older than the delete that arrives late from another
+// node is not taken back
+const TombstoneTTL = time.Hour
+
+// Stamp orders the writes of a multi-primary store: the hybrid logical
+// clock reading of the node that wrote the key, then the node's name to
+// break ties, so every node picks the same last writer.
+type Stamp struct {
+	At     uint64
+	Origin string
+}
+
+// After reports whether s is a later write than t.
+func (s Stamp) After(t Stamp) bool {
+	return s.At > t.At || s.At == t.At && s.Origin > t.Origin
+}
+
+// hlc is a hybrid logical clock: milliseconds of wall time in the high 48
+// bits and a counter in the low 16, which keeps its readings increasing,
+// and ahead of every reading it has seen from another node, whatever the
+// wall clocks of the nodes say
+type hlc struct {
+	last uint64
+}
+
+// now returns a reading later than every one before
+func (c *hlc) now(wall time.Time) uint64 {
+	if pt := uint64(wall.UnixMilli()) << 16; pt > c.last {
+		c.last = pt
+	} else {
+		c.last++
+	}
+	return c.last
+}
+
+// observe moves the clock past a reading from another node
+func (c *hlc) observe(at uint64) {
+	c.last = max(c.last, at)
+}
+
+// tombstone is a deleted key of a multi-primary store
+type tombstone struct {
+	stamp Stamp
+	at    time.Time
+}
+
+// multiPrimary is the state of a store that takes writes alongside others,
+// see SetMultiPrimary
+type multiPrimary struct {
+	node       string
+	clock      hlc
+	tombstones map[string]tombstone
+	pruned     time.Time
+	// merging marks writes that carry their own stamp, from another node
+	// or a snapshot, and deleting is the stamp of a merged delete
+	merging  bool
+	deleting Stamp
+}
+
+// SetMultiPrimary makes kvs one of several nodes that all take writes and
+// exchange them with MergeReplication. Every write is stamped with a
+// hybrid logical clock and node, and a write from another node replaces a
+// key only if it is later, last writer wins, so the nodes converge
+// whatever order the writes arrive in. node names this one and must
+// differ between them. It starts keeping the replication backlog, so call
+// it before the store takes writes.
+func (kvs *KeyValueStore) SetMultiPrimary(node string) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.multi = &multiPrimary{node: node, tombstones: make(map[string]tombstone)}
+	if kvs.repl == nil {
+		kvs.repl = newReplLog(cmp.Or(kvs.replBacklog, DefaultReplicationBacklog))
+		kvs.retrack()
+	}
+}
+
+// stamp returns the stamp a write of key by kvs gets, or the one it
+// carries if it was merged; kv is the entry written, nil for a delete.
+// Caller must hold kvs.mu.
+func (m *multiPrimary) stamp(key string, kv *KeyValue, now time.Time) Stamp {
+	var s Stamp
+	switch {
+	case kv != nil && m.merging && kv.Stamp != 0:
+		s = Stamp{kv.Stamp, kv.Origin}
+		m.clock.observe(s.At)
+	case kv == nil && m.merging:
+		s = m.deleting
+	default:
+		s = Stamp{m.clock.now(now), m.node}
+	}
+	if kv == nil {
+		m.tombstones[key] = tombstone{stamp: s, at: now}
+		return s
+	}
+	kv.Stamp, kv.Origin = s.At, s.Origin
+	delete(m.tombstones, key)
+	return s
+}
+
+// keepStamps makes the writes until done keep the stamps they carry, as
+// they are restored rather than written; caller must hold kvs.mu
+func (kvs *KeyValueStore) keepStamps() (done func()) {
+	m := kvs.multi
+	if m == nil {
+		return func() {}
+	}
+	m.merging = true
+	return func() { m.merging = false }
+}
+
+// prune forgets the tombstones older than TombstoneTTL, at most every
+// tenth of it
+func (m *multiPrimary) prune(now time.Time) {
+	if now.Sub(m.pruned) < TombstoneTTL/10 {
+		return
+	}
+	m.pruned = now
+	for key, t := range m.tombstones {
+		if now.Sub(t.at) > TombstoneTTL {
+			delete(m.tombstones, key)
+		}
+	}
+}
+
+// MergeReplication applies the ops from another node's ReplicationSince
+// that are later than what kvs has of their keys, or remembers of their
+// deletion, and drops the rest. The merged writes get revisions of kvs
+// and go into its own backlog, so they reach the nodes that follow it;
+// a node that gets its own writes back drops them.
+func (kvs *KeyValueStore) MergeReplication(ops []ReplicationOp) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	for _, op := range ops {
+		kvs.merge(op.Key, op.Entry, Stamp{op.Stamp, op.Origin}, now)
+	}
+	kvs.multi.prune(now)
+}
+
+// MergeReplicationSnapshot merges every entry of another node's ReplicationSnapshot
+// into kvs as MergeReplication would, keeping the keys that node lacks.
+// Kept counts the entries kvs had a later write of.
+func (kvs *KeyValueStore) MergeReplicationSnapshot(snapshot BackupSnapshot) (RestoreStats, error) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	kept := 0
+	stats, err := kvs.eachRestorable(snapshot, encryptionOf(kvs.data), func(key string, item KeyValue) {
+		if !kvs.merge(key, &item, Stamp{item.Stamp, item.Origin}, now) {
+			kept++
+		}
+	})
+	stats.Loaded -= kept
+	stats.Kept = kept
+	return stats, err
+}
+
+// merge writes entry to key, or deletes key if entry is nil, if s is later
+// than what kvs has of it, and reports whether it did; caller must hold
+// kvs.mu
+func (kvs *KeyValueStore) merge(key string, entry *KeyValue, s Stamp, now time.Time) bool {
+	m := kvs.multi
+	m.clock.observe(s.At)
+	old, exists := kvs.data.get(key)
+	current := m.tombstones[key].stamp
+	if exists {
+		current = Stamp{old.Stamp, old.Origin}
+	}
+	if !s.After(current) {
+		return false
+	}
+	m.merging = true
+	defer func() { m.merging = false }()
+	if entry == nil {
+		if !exists {
+			m.tombstones[key] = tombstone{stamp: s, at: now}
+			return true
+		}
+		kvs.revision++
+		kvs.retire(key, old, kvs.revision)
+		m.deleting = s
+		kvs.data.delete(key)
+		kvs.namespaces.remove(key, old)
+		kvs.zsets.drop(key)
+		kvs.emit(EventDelete, key, "", now)
+		return true
+	}
+	kv := *entry
+	kv.Stamp, kv.Origin = s.At, s.Origin
+	kvs.revise(&kv)
+	if exists {
+		kvs.namespaces.remove(key, old)
+		kvs.retire(key, old, kv.Revision)
+	}
+	kvs.zsets.drop(key)
+	kvs.data.set(key, kv)
+	kvs.namespaces.add(key, kv)
+	kvs.emit(EventSet, key, kv.Value, now)
+	return true
+}
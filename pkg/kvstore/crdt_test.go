@@ -0,0 +1,126 @@
+package kvstore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// newNode returns a multi-primary store named node on clock
+func newNode(node string, clock *ManualClock) *KeyValueStore {
+	kvs := NewKeyValueStore()
+	kvs.SetClock(clock)
+	kvs.SetMultiPrimary(node)
+	return kvs
+}
+
+// writesOf returns every write in the backlog of kvs
+func writesOf(t *testing.T, kvs *KeyValueStore) []ReplicationOp {
+	t.Helper()
+	_, id, _ := kvs.ReplicationSnapshot()
+	ops, _, err := kvs.ReplicationSince(context.Background(), id, 0, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ops
+}
+
+func TestMultiPrimaryLastWriterWins(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	a, b := newNode("a", clock), newNode("b", clock)
+	// written at the same instant, the node name breaks the tie
+	a.SET("tie", "from a")
+	b.SET("tie", "from b")
+	clock.Advance(time.Millisecond)
+	a.SET("k", "from a")
+	clock.Advance(time.Millisecond)
+	b.SET("k", "from b")
+	a.SET("only a", "1")
+
+	fromA, fromB := writesOf(t, a), writesOf(t, b)
+	// each gets the other's writes, in either order
+	b.MergeReplication(fromA)
+	a.MergeReplication(fromB)
+	for _, node := range []*KeyValueStore{a, b} {
+		for key, want := range map[string]string{"k": "from b", "tie": "from b", "only a": "1"} {
+			if value, _ := node.GET(key); value != want {
+				t.Errorf("%s = %q on %s, want %q", key, value, node.multi.node, want)
+			}
+		}
+	}
+
+	// a node that gets its own writes back, through the other, drops them
+	revision := b.Revision()
+	b.MergeReplication(writesOf(t, a))
+	if b.Revision() != revision {
+		t.Errorf("merging its own writes back moved b from revision %d to %d", revision, b.Revision())
+	}
+}
+
+func TestMultiPrimaryDeleteWins(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	a, b := newNode("a", clock), newNode("b", clock)
+	b.SET("k", "old")
+	clock.Advance(time.Millisecond)
+	a.SET("k", "v")
+	clock.Advance(time.Millisecond)
+	a.DELETE("k")
+
+	// b's older write arrives after the delete and is not taken back
+	a.MergeReplication(writesOf(t, b))
+	if _, found := a.GET("k"); found {
+		t.Error("a write older than the delete brought k back")
+	}
+	b.MergeReplication(writesOf(t, a))
+	if _, found := b.GET("k"); found {
+		t.Error("the delete didn't reach b")
+	}
+	// a later write does
+	clock.Advance(time.Millisecond)
+	b.SET("k", "new")
+	a.MergeReplication(writesOf(t, b))
+	if value, _ := a.GET("k"); value != "new" {
+		t.Errorf("k = %q after a write later than the delete, want new", value)
+	}
+}
+
+// TestMultiPrimaryFollowsCausality checks that a node whose wall clock is
+// behind still stamps a write after the one it had seen as later
+func TestMultiPrimaryFollowsCausality(t *testing.T) {
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	a := newNode("a", NewManualClock(start.Add(time.Hour)))
+	b := newNode("b", NewManualClock(start))
+	a.SET("k", "first")
+	b.MergeReplication(writesOf(t, a))
+	b.SET("k", "second")
+	a.MergeReplication(writesOf(t, b))
+	for _, node := range []*KeyValueStore{a, b} {
+		if value, _ := node.GET("k"); value != "second" {
+			t.Errorf("k = %q on %s, want the write made after seeing the first", value, node.multi.node)
+		}
+	}
+}
+
+func TestMergeReplicationSnapshotKeepsLaterWrites(t *testing.T) {
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	a, b := newNode("a", clock), newNode("b", clock)
+	a.SET("shared", "from a")
+	a.SET("only a", "1")
+	clock.Advance(time.Millisecond)
+	b.SET("shared", "from b")
+	b.SET("only b", "1")
+
+	snapshot, _, _ := a.ReplicationSnapshot()
+	stats, err := b.MergeReplicationSnapshot(snapshot)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Loaded != 1 || stats.Kept != 1 {
+		t.Errorf("merged %d and kept %d, want 1 and 1", stats.Loaded, stats.Kept)
+	}
+	for key, want := range map[string]string{"shared": "from b", "only a": "1", "only b": "1"} {
+		if value, _ := b.GET(key); value != want {
+			t.Errorf("%s = %q, want %q", key, value, want)
+		}
+	}
+}
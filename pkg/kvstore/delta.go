@@ -0,0 +1,248 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"time"
+)
+
+// deltaLog records the keys written or deleted since the last snapshot,
+// for the deltas of SetIncremental; the store's lock guards it
+type deltaLog struct {
+	fullEvery int
+	seq       uint64            // counts the writes
+	dirty     map[string]uint64 // key to the write that last touched it
+	base      time.Time         // when the full snapshot the deltas follow was taken
+	written   int               // deltas since it
+	needFull  bool              // no full snapshot of the current data yet
+}
+
+func newDeltaLog(fullEvery int) *deltaLog {
+	return &deltaLog{fullEvery: fullEvery, dirty: make(map[string]uint64), needFull: true}
+}
+
+func (l *deltaLog) touch(key string) {
+	l.seq++
+	l.dirty[key] = l.seq
+}
+
+// settle forgets the keys touched up to write seq, once a snapshot that
+// holds them is written
+func (l *deltaLog) settle(seq uint64) {
+	for key, s := range l.dirty {
+		if s <= seq {
+			delete(l.dirty, key)
+		}
+	}
+}
+
+// deltaEngine is an engine that records every key written or deleted
+// through it in log. It sits under the indexes and over the other storage
+// layers, so the keys are recorded as written.
+type deltaEngine struct {
+	engine
+	log *deltaLog
+}
+
+func (d *deltaEngine) set(key string, kv KeyValue) {
+	d.log.touch(key)
+	d.engine.set(key, kv)
+}
+
+func (d *deltaEngine) delete(key string) {
+	d.log.touch(key)
+	d.engine.delete(key)
+}
+
+// storageOf returns the storage layers of e, under the indexes, the
+// delta log and the WAL
+func storageOf(e engine) engine {
+	e = unwrap(e)
+	for {
+		switch d := e.(type) {
+		case *deltaEngine:
+			e = d.engine
+		case *walEngine:
+			e = d.engine
+		case *replEngine:
+			e = d.engine
+		default:
+			return e
+		}
+	}
+}
+
+// tracked is storage under the delta log of kvs, if SetIncremental turned
+// it on, its WAL, if OpenWAL did, and its replication backlog, once a
+// replica asked for it; caller must hold kvs.mu
+func (kvs *KeyValueStore) tracked(storage engine) engine {
+	e := storage
+	if kvs.deltas != nil {
+		e = &deltaEngine{engine: e, log: kvs.deltas}
+	}
+	if kvs.wal != nil {
+		e = &walEngine{engine: e, log: kvs.wal, aead: encryptionOf(storage)}
+	}
+	if kvs.repl != nil {
+		e = &replEngine{engine: e, kvs: kvs, log: kvs.repl}
+	}
+	return e
+}
+
+// retrack rebuilds the layers over the storage of kvs after the delta log,
+// the WAL or the replication backlog changed; caller must hold kvs.mu
+func (kvs *KeyValueStore) retrack() {
+	sep := ""
+	if d, ok := kvs.data.(*dirEngine); ok {
+		sep = d.sep
+	}
+	kvs.data = withIndexes(kvs.tracked(storageOf(kvs.data)), sep, orderOf(kvs.data) != nil, searchOf(kvs.data) != nil)
+}
+
+// SetIncremental makes WriteBackup write deltas, holding only the keys
+// written or deleted since the snapshot before, next to the backup file
+// as backup.snap.delta.1, .2 and so on, and a full snapshot every
+// fullEvery snapshots, which starts the chain again. A restore reads the
+// full snapshot and then its deltas in order. With fullEvery <= 0 every
+// snapshot is full, as before.
+func (kvs *KeyValueStore) SetIncremental(fullEvery int) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	fullEvery = max(fullEvery, 0)
+	if kvs.deltas != nil && fullEvery > 0 {
+		kvs.deltas.fullEvery = fullEvery
+		return
+	}
+	if kvs.deltas == nil && fullEvery == 0 {
+		return
+	}
+	kvs.deltas = nil
+	if fullEvery > 0 {
+		kvs.deltas = newDeltaLog(fullEvery)
+	}
+	kvs.retrack()
+}
+
+// Incremental returns what SetIncremental set
+func (kvs *KeyValueStore) Incremental() (fullEvery int) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	if kvs.deltas == nil {
+		return 0
+	}
+	return kvs.deltas.fullEvery
+}
+
+// deltaName is the name of delta n after the full snapshot in path
+func deltaName(path string, n int) string {
+	return fmt.Sprintf("%s.delta.%d", path, n)
+}
+
+// snapshotTaken is a snapshot WriteBackup is about to write: what it
+// holds, and up to which write of the delta log
+type snapshotTaken struct {
+	BackupSnapshot
+	SnapshotInfo
+	damaged []string
+	upTo    uint64
+}
+
+// takeSnapshot is Snapshot, or with SetIncremental on and a delta due, the
+// entries of the keys touched since the last snapshot and the keys of
+// those deleted, taken at now
+func (kvs *KeyValueStore) takeSnapshot(now time.Time) (taken snapshotTaken) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	taken.Created = now
+	l := kvs.deltas
+	if l == nil || l.needFull || l.written+1 >= l.fullEvery {
+		taken.BackupSnapshot, taken.damaged = kvs.snapshot()
+		if l != nil {
+			taken.upTo = l.seq
+		}
+		return taken
+	}
+	taken.Delta, taken.Base, taken.Seq, taken.upTo = true, l.base, l.written+1, l.seq
+	taken.WAL = kvs.walSeq()
+	aead := encryptionOf(kvs.data)
+	taken.Data = make(map[string]KeyValue, len(l.dirty))
+	for key := range l.dirty {
+		value, ok := kvs.data.get(key)
+		if !ok {
+			taken.Deleted = append(taken.Deleted, key)
+			continue
+		}
+		if !value.Intact() {
+			taken.damaged = append(taken.damaged, key)
+		}
+		if aead != nil {
+			value = sealText(aead, key, value)
+		}
+		taken.Data[key] = value
+	}
+	return taken
+}
+
+// snapshotWritten moves the delta log past taken, now it is written to
+// path, deleting the deltas a full snapshot replaces
+func (kvs *KeyValueStore) snapshotWritten(path string, taken snapshotTaken) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	l := kvs.deltas
+	if l == nil {
+		return
+	}
+	l.settle(taken.upTo)
+	if taken.Delta {
+		l.written = taken.Seq
+		return
+	}
+	old := l.written
+	l.base, l.written, l.needFull = taken.Created, 0, false
+	// deltas of an older full snapshot are never read again
+	for n := 1; n <= old || fileExists(deltaName(path, n)); n++ {
+		os.Remove(deltaName(path, n))
+	}
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readBackup reads the full snapshot in path and applies the deltas
+// written after it in order, see SetIncremental, returning the result,
+// what the full snapshot is, and how many deltas were applied. Deltas of
+// another full snapshot, left by a crash, are ignored.
+func readBackup(path string, c *FileCipher) (snapshot BackupSnapshot, info SnapshotInfo, deltas int, err error) {
+	snapshot, info, err = readSnapshot(path, c)
+	if err != nil {
+		return snapshot, info, 0, err
+	}
+	if info.Delta {
+		return snapshot, info, 0, fmt.Errorf("reading snapshot %s: a delta, not a full snapshot", path)
+	}
+	for n := 1; ; n++ {
+		name := deltaName(path, n)
+		if !fileExists(name) {
+			break
+		}
+		delta, dinfo, err := readSnapshot(name, c)
+		if err != nil {
+			return snapshot, info, deltas, err
+		}
+		if !dinfo.Delta || dinfo.Seq != n || !dinfo.Base.Equal(info.Created) {
+			break
+		}
+		for _, key := range delta.Deleted {
+			delete(snapshot.Data, key)
+		}
+		for key, kv := range delta.Data {
+			snapshot.Data[key] = kv
+		}
+		snapshot.WAL = delta.WAL
+		deltas++
+	}
+	info.Keys = len(snapshot.Data)
+	return snapshot, info, deltas, nil
+}
@@ -0,0 +1,168 @@
+package kvstore
+
+import (
+	"sort"
+	"strings"
+)
+
+// DirEntry is one child of a directory listed by LIST: a key, or a
+// sub-directory with the number of keys anywhere beneath it. Name is the
+// full path, ending in the separator for directories.
+type DirEntry struct {
+	Name string
+	Dir  bool
+	Keys int
+}
+
+// dirNode is one directory of the index
+type dirNode struct {
+	subdirs map[string]int  // child directory name → keys beneath it
+	keys    map[string]bool // child key names
+}
+
+// dirEngine is an engine that also indexes its keys as a hierarchy, so a
+// directory's children are listed without scanning every key
+type dirEngine struct {
+	engine
+	sep  string
+	dirs map[string]*dirNode // by path, "" for the root, else ending in sep
+}
+
+func newDirEngine(inner engine, sep string) *dirEngine {
+	d := &dirEngine{engine: inner, sep: sep, dirs: make(map[string]*dirNode)}
+	inner.each(func(key string, _ KeyValue) bool {
+		d.add(key)
+		return true
+	})
+	return d
+}
+
+func (d *dirEngine) set(key string, kv KeyValue) {
+	if _, ok := d.engine.get(key); !ok {
+		d.add(key)
+	}
+	d.engine.set(key, kv)
+}
+
+func (d *dirEngine) delete(key string) {
+	if _, ok := d.engine.get(key); ok {
+		d.remove(key)
+	}
+	d.engine.delete(key)
+}
+
+// add indexes a new key in every directory on its path
+func (d *dirEngine) add(key string) {
+	dir := ""
+	rest := key
+	for {
+		node := d.dirs[dir]
+		if node == nil {
+			node = &dirNode{subdirs: make(map[string]int), keys: make(map[string]bool)}
+			d.dirs[dir] = node
+		}
+		name, tail, found := strings.Cut(rest, d.sep)
+		if !found {
+			node.keys[rest] = true
+			return
+		}
+		node.subdirs[name]++
+		dir += name + d.sep
+		rest = tail
+	}
+}
+
+// remove undoes add, dropping directories left empty
+func (d *dirEngine) remove(key string) {
+	dir := ""
+	rest := key
+	for {
+		node := d.dirs[dir]
+		name, tail, found := strings.Cut(rest, d.sep)
+		if !found {
+			delete(node.keys, rest)
+		} else if node.subdirs[name]--; node.subdirs[name] == 0 {
+			delete(node.subdirs, name)
+		}
+		if len(node.keys) == 0 && len(node.subdirs) == 0 {
+			delete(d.dirs, dir)
+		}
+		if !found {
+			return
+		}
+		dir += name + d.sep
+		rest = tail
+	}
+}
+
+// list returns the children of dir, directories first, each group sorted
+func (d *dirEngine) list(dir string) []DirEntry {
+	node := d.dirs[dir]
+	if node == nil {
+		return nil
+	}
+	entries := make([]DirEntry, 0, len(node.subdirs)+len(node.keys))
+	for name, n := range node.subdirs {
+		entries = append(entries, DirEntry{Name: dir + name + d.sep, Dir: true, Keys: n})
+	}
+	for name := range node.keys {
+		entries = append(entries, DirEntry{Name: dir + name})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Dir != entries[j].Dir {
+			return entries[i].Dir
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// SetSeparator makes kvs index its keys as a hierarchy split at sep, such
+// as "/", for LIST; "" turns the index off. Building it visits every key,
+// and afterwards each new or deleted key costs one step per level.
+func (kvs *KeyValueStore) SetSeparator(sep string) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	inner := kvs.data
+	if d, ok := inner.(*dirEngine); ok {
+		if d.sep == sep {
+			return
+		}
+		inner = d.engine
+	}
+	if sep == "" {
+		kvs.data = inner
+		return
+	}
+	kvs.data = newDirEngine(inner, sep)
+}
+
+// Separator returns the separator set by SetSeparator
+func (kvs *KeyValueStore) Separator() string {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	if d, ok := kvs.data.(*dirEngine); ok {
+		return d.sep
+	}
+	return ""
+}
+
+// LIST returns the keys and sub-directories directly under dir, "" being
+// the root; a dir not ending in the separator gets one. Keys past their
+// TTL show up until the janitor removes them. ok is false if there is no
+// separator set.
+func (kvs *KeyValueStore) LIST(dir string) (entries []DirEntry, ok bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	d, ok := kvs.data.(*dirEngine)
+	if !ok {
+		return nil, false
+	}
+	if dir == d.sep {
+		dir = ""
+	}
+	if dir != "" && !strings.HasSuffix(dir, d.sep) {
+		dir += d.sep
+	}
+	return d.list(dir), true
+}
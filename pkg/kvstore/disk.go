@@ -0,0 +1,237 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"unsafe"
+)
+
+// DiskSegmentSize is the size a disk engine's segment file grows to before
+// the engine starts another
+const DiskSegmentSize = 256 << 20
+
+// diskBufferSize is how much a disk engine buffers before it writes
+const diskBufferSize = 64 << 10
+
+// diskEngine keeps values in segment files in a directory and only the
+// keys, and where their values are, in memory, so a dataset whose values
+// don't fit in RAM can still be served; the server's read cache keeps the
+// hot ones in memory in front of it. Values are appended, as in
+// arenaEngine, and compact copies the live ones out once half of the files
+// is garbage. The files are unlinked as soon as they are created: keeping
+// the data across restarts is still up to snapshots and the WAL, and a
+// crash leaves nothing behind.
+type diskEngine struct {
+	spec    string // the engine name it was made with
+	dir     string
+	index   buckets[arenaRef]
+	segs    []*os.File
+	size    int64  // of the last segment, buffer included
+	flushed int64  // how much of the last segment is in its file
+	buf     []byte // the rest
+	live    int64
+	dead    int64
+}
+
+// newDiskEngine returns an empty disk engine keeping its files in dir, the
+// system's temporary directory if dir is empty
+func newDiskEngine(spec, dir string) (*diskEngine, error) {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+	d := &diskEngine{spec: spec, dir: dir}
+	if err := d.addSegment(); err != nil {
+		return nil, fmt.Errorf("disk engine: %w", err)
+	}
+	return d, nil
+}
+
+// engineDir is the directory an engine name such as "disk:/var/lib/kvs"
+// asks for, and whether it names an engine of that kind at all
+func engineDir(name, kind string) (string, bool) {
+	if name == kind {
+		return "", true
+	}
+	return strings.CutPrefix(name, kind+":")
+}
+
+func (d *diskEngine) name() string { return d.spec }
+
+func (d *diskEngine) get(key string) (KeyValue, bool) {
+	ref, ok := d.index.get(key)
+	if !ok {
+		return KeyValue{}, false
+	}
+	return d.value(key, ref), true
+}
+
+// value reads the entry ref locates back
+func (d *diskEngine) value(key string, ref arenaRef) KeyValue {
+	kv := expiryOf(ref)
+	kv.Checksum, kv.Revision, kv.Type, kv.Compressed, kv.Encrypted = ref.sum, ref.rev, ref.typ, ref.packed, ref.sealed
+	b, err := d.read(ref)
+	if err != nil {
+		// left empty, the value fails its checksum, so it is reported as
+		// damaged rather than returned wrong
+		RecordError("Error reading disk engine:", fmt.Errorf("value of %q: %w", key, err))
+		return kv
+	}
+	kv.Value = string(b)
+	return kv
+}
+
+func (d *diskEngine) set(key string, kv KeyValue) {
+	if old, ok := d.index.get(key); ok {
+		d.release(old)
+	}
+	d.index.set(key, d.store(kv))
+}
+
+func (d *diskEngine) delete(key string) {
+	if old, ok := d.index.get(key); ok {
+		d.release(old)
+		d.index.delete(key)
+	}
+}
+
+func (d *diskEngine) len() int { return d.index.len() }
+
+// a disk entry is the index's string header and arenaRef and the bucket's
+// share, as in the arena; values are on disk
+func (d *diskEngine) overhead() int64 {
+	return int64(unsafe.Sizeof("") + unsafe.Sizeof(arenaRef{}) + 8)
+}
+
+func (d *diskEngine) each(fn func(key string, kv KeyValue) bool) {
+	d.index.each(func(key string, ref arenaRef) bool {
+		return fn(key, d.value(key, ref))
+	})
+}
+
+func (d *diskEngine) eachExpiry(fn func(key string, kv KeyValue) bool) {
+	d.index.each(func(key string, ref arenaRef) bool {
+		return fn(key, expiryOf(ref))
+	})
+}
+
+func (d *diskEngine) eachExpiryIn(bucket int, fn func(key string, kv KeyValue) bool) {
+	d.index.eachIn(bucket, func(key string, ref arenaRef) bool {
+		return fn(key, expiryOf(ref))
+	})
+}
+
+// compact copies the live values into fresh segments once at least half of
+// the files is garbage, and drops the old ones
+func (d *diskEngine) compact() {
+	if d.dead < DiskSegmentSize || d.dead < d.live {
+		return
+	}
+	fresh := &diskEngine{spec: d.spec, dir: d.dir}
+	if err := fresh.addSegment(); err != nil {
+		RecordError("Error compacting disk engine:", err)
+		return
+	}
+	var err error
+	d.index.each(func(key string, ref arenaRef) bool {
+		var b []byte
+		if b, err = d.read(ref); err != nil {
+			return false
+		}
+		fresh.index.set(key, fresh.append(ref, b))
+		return true
+	})
+	if err == nil {
+		err = fresh.flush()
+	}
+	if err != nil {
+		fresh.close()
+		RecordError("Error compacting disk engine:", err)
+		return
+	}
+	d.close()
+	*d = *fresh
+}
+
+// close closes the segment files, which frees them
+func (d *diskEngine) close() error {
+	var err error
+	for _, f := range d.segs {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	d.segs = nil
+	return err
+}
+
+func (d *diskEngine) read(ref arenaRef) ([]byte, error) {
+	last := uint32(len(d.segs) - 1)
+	if ref.seg == last && int64(ref.off) >= d.flushed {
+		start := int64(ref.off) - d.flushed
+		return d.buf[start : start+int64(ref.n)], nil
+	}
+	b := make([]byte, ref.n)
+	_, err := d.segs[ref.seg].ReadAt(b, int64(ref.off))
+	return b, err
+}
+
+func (d *diskEngine) store(kv KeyValue) arenaRef {
+	ref := arenaRef{timestamp: kv.Timestamp.UnixNano(), ttl: kv.TTL, sum: kv.Checksum, rev: kv.Revision, typ: kv.Type, packed: kv.Compressed, sealed: kv.Encrypted}
+	return d.append(ref, []byte(kv.Value))
+}
+
+// append adds b to the end of the last segment, starting a new one when
+// it is full, and returns ref pointing at it
+func (d *diskEngine) append(ref arenaRef, b []byte) arenaRef {
+	if d.size > 0 && d.size+int64(len(b)) > DiskSegmentSize {
+		// a segment that can't be started leaves the values in the last one
+		if err := d.flush(); err == nil {
+			if err := d.addSegment(); err != nil {
+				RecordError("Error writing disk engine:", err)
+			}
+		}
+	}
+	ref.seg, ref.off, ref.n = uint32(len(d.segs)-1), uint32(d.size), uint32(len(b))
+	d.buf = append(d.buf, b...)
+	d.size += int64(len(b))
+	d.live += int64(len(b))
+	if len(d.buf) >= diskBufferSize {
+		// the buffer keeps what didn't make it, for the next try
+		d.flush()
+	}
+	return ref
+}
+
+// flush writes the buffer to the last segment
+func (d *diskEngine) flush() error {
+	if len(d.buf) == 0 {
+		return nil
+	}
+	n, err := d.segs[len(d.segs)-1].WriteAt(d.buf, d.flushed)
+	d.flushed += int64(n)
+	d.buf = d.buf[n:]
+	if err != nil {
+		RecordError("Error writing disk engine:", err)
+		return err
+	}
+	d.buf = d.buf[:0]
+	return nil
+}
+
+func (d *diskEngine) addSegment() error {
+	f, err := os.CreateTemp(d.dir, "kvs-*.seg")
+	if err != nil {
+		return err
+	}
+	// open, the file lives on until it is closed
+	os.Remove(f.Name())
+	d.segs = append(d.segs, f)
+	d.size, d.flushed, d.buf = 0, 0, nil
+	return nil
+}
+
+func (d *diskEngine) release(ref arenaRef) {
+	d.live -= int64(ref.n)
+	d.dead += int64(ref.n)
+}
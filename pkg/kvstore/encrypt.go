@@ -0,0 +1,169 @@
+package kvstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// EncryptionKeyEnv is the environment variable kvs-server reads its
+// encryption key from unless told another
+const EncryptionKeyEnv = "KVS_ENCRYPTION_KEY"
+
+// ErrEncryptionKey is returned when an encrypted snapshot is loaded
+// without the key it was written with
+var ErrEncryptionKey = errors.New("snapshot is encrypted with a key the store does not have")
+
+// encryptEngine is an engine that keeps values sealed with AES-GCM,
+// marking them Encrypted, so neither a dump of the process's memory nor
+// of its engine holds them in the clear. Each value is sealed with a
+// nonce of its own and its key as additional data, so sealed values
+// can't be moved between keys. Everything above it sees the values as
+// written. It sits directly on the storage engine, under compression.
+type encryptEngine struct {
+	engine
+	aead cipher.AEAD
+}
+
+// ParseEncryptionKey reads an AES key of 16, 24 or 32 bytes in base64,
+// as `openssl rand -base64 32` makes one
+func ParseEncryptionKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key is not base64: %w", err)
+	}
+	if _, err := newAEAD(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("encryption key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+func (e *encryptEngine) get(key string) (KeyValue, bool) {
+	kv, ok := e.engine.get(key)
+	if ok && kv.Encrypted {
+		kv = e.opened(key, kv)
+	}
+	return kv, ok
+}
+
+func (e *encryptEngine) set(key string, kv KeyValue) {
+	e.engine.set(key, seal(e.aead, key, kv))
+}
+
+func (e *encryptEngine) each(fn func(key string, kv KeyValue) bool) {
+	e.engine.each(func(key string, kv KeyValue) bool {
+		if kv.Encrypted {
+			kv = e.opened(key, kv)
+		}
+		return fn(key, kv)
+	})
+}
+
+// opened is kv with its value as written; a value that doesn't open,
+// which only damage to memory could cause, is recorded and kept as it is
+func (e *encryptEngine) opened(key string, kv KeyValue) KeyValue {
+	opened, err := open(e.aead, key, kv)
+	if err != nil {
+		RecordError("Error decrypting value:", fmt.Errorf("key %q: %w", key, err))
+		return kv
+	}
+	return opened
+}
+
+// seal is kv with its value sealed for key, as the nonce followed by the
+// ciphertext
+func seal(aead cipher.AEAD, key string, kv KeyValue) KeyValue {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(kv.Value)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	kv.Value = string(aead.Seal(nonce, nonce, []byte(kv.Value), []byte(key)))
+	kv.Encrypted = true
+	return kv
+}
+
+// open undoes seal
+func open(aead cipher.AEAD, key string, kv KeyValue) (KeyValue, error) {
+	n := aead.NonceSize()
+	if len(kv.Value) < n {
+		return kv, errors.New("sealed value too short")
+	}
+	plain, err := aead.Open(nil, []byte(kv.Value[:n]), []byte(kv.Value[n:]), []byte(key))
+	if err != nil {
+		return kv, err
+	}
+	kv.Value, kv.Encrypted = string(plain), false
+	return kv, nil
+}
+
+// sealText is seal with the sealed value in base64, as snapshots keep it,
+// since JSON strings can't hold arbitrary bytes
+func sealText(aead cipher.AEAD, key string, kv KeyValue) KeyValue {
+	kv = seal(aead, key, kv)
+	kv.Value = base64.StdEncoding.EncodeToString([]byte(kv.Value))
+	return kv
+}
+
+// openText undoes sealText
+func openText(aead cipher.AEAD, key string, kv KeyValue) (KeyValue, error) {
+	sealed, err := base64.StdEncoding.DecodeString(kv.Value)
+	if err != nil {
+		return kv, err
+	}
+	kv.Value = string(sealed)
+	return open(aead, key, kv)
+}
+
+// encryptionOf returns the AEAD values of e are sealed with, nil if there
+// is none
+func encryptionOf(e engine) cipher.AEAD {
+	e = storageOf(e)
+	if c, ok := e.(*compressEngine); ok {
+		e = c.engine
+	}
+	if s, ok := e.(*encryptEngine); ok {
+		return s.aead
+	}
+	return nil
+}
+
+// SetEncryption makes kvs keep its values encrypted with AES-GCM under
+// key, of 16, 24 or 32 bytes, both in memory and in the snapshots it
+// writes, or in the clear if key is empty. Changing the key rewrites
+// every value. A snapshot written under a key only loads with it. Keys,
+// TTLs and checksums stay in the clear, and so do the values the server's
+// read cache and journal hold.
+func (kvs *KeyValueStore) SetEncryption(key []byte) error {
+	var aead cipher.AEAD
+	if len(key) > 0 {
+		var err error
+		if aead, err = newAEAD(key); err != nil {
+			return err
+		}
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	threshold := 0
+	if c := compressionOf(kvs.data); c != nil {
+		threshold = c.threshold
+	}
+	return kvs.relayer(kvs.data, aead, threshold)
+}
+
+// Encrypted reports whether SetEncryption is on
+func (kvs *KeyValueStore) Encrypted() bool {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return encryptionOf(kvs.data) != nil
+}
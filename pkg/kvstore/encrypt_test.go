@@ -0,0 +1,72 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testKey = []byte("0123456789abcdef")
+
+func TestEncryptionKeepsValuesSealed(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SET("before", "plain before")
+	if err := kvs.SetEncryption(testKey); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SET("secret", "hunter2")
+	enc, ok := storageOf(kvs.data).(*encryptEngine)
+	if !ok {
+		t.Fatalf("storage engine %T, want encryptEngine", storageOf(kvs.data))
+	}
+	for key, plain := range map[string]string{"before": "plain before", "secret": "hunter2"} {
+		raw, _ := enc.engine.get(key)
+		if !raw.Encrypted || raw.Value == plain {
+			t.Errorf("%s held in the clear: %+v", key, raw)
+		}
+		if value, _ := kvs.GET(key); value != plain {
+			t.Errorf("GET %s = %q, want %q", key, value, plain)
+		}
+	}
+	// a sealed value doesn't open under another key
+	raw, _ := enc.engine.get("secret")
+	if _, err := open(enc.aead, "before", raw); err == nil {
+		t.Error("sealed value opened under another key")
+	}
+}
+
+func TestEncryptedSnapshotNeedsItsKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.snap")
+	kvs := NewKeyValueStore()
+	if err := kvs.SetEncryption(testKey); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SetBackup(path, 0)
+	kvs.SET("secret", "hunter2")
+	if err := WriteBackup(kvs); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(data, []byte("hunter2")) {
+		t.Error("snapshot holds the value in the clear")
+	}
+
+	if _, err := RestoreBackup(NewKeyValueStore(), path); !errors.Is(err, ErrEncryptionKey) {
+		t.Errorf("restore without the key = %v, want ErrEncryptionKey", err)
+	}
+	restored := NewKeyValueStore()
+	if err := restored.SetEncryption(testKey); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := RestoreBackup(restored, path); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := restored.GET("secret"); value != "hunter2" {
+		t.Errorf("GET after restore = %q", value)
+	}
+}
@@ -0,0 +1,169 @@
+package kvstore
+
+import (
+	"crypto/cipher"
+	"fmt"
+	"unsafe"
+)
+
+// storage engines a KeyValueStore can keep its entries in
+const (
+	EngineMap   = "map"
+	EngineArena = "arena"
+	// EngineDisk keeps values on disk, in the system's temporary directory,
+	// or in DIR for "disk:DIR"
+	EngineDisk = "disk"
+	// EngineTiered keeps the most recently used keys in memory and the
+	// rest on disk, as EngineDisk does, see SetHotKeys
+	EngineTiered = "tiered"
+	// EngineMmap is EngineArena with its segments in files mapped into
+	// memory, in the system's temporary directory, or in DIR for
+	// "mmap:DIR"; Linux only
+	EngineMmap = "mmap"
+)
+
+// engine holds the entries of a KeyValueStore; the store's lock guards
+// every call
+type engine interface {
+	name() string
+	get(key string) (KeyValue, bool)
+	set(key string, kv KeyValue)
+	delete(key string)
+	len() int
+	// each calls fn for every entry until it returns false; fn may delete
+	// the entry it was given
+	each(fn func(key string, kv KeyValue) bool)
+	// eachExpiry is each with only the Timestamp and TTL filled in, which
+	// is all the janitor needs and may be much cheaper
+	eachExpiry(fn func(key string, kv KeyValue) bool)
+	// eachExpiryIn is eachExpiry for the keys of one scan bucket, see
+	// bucketOf
+	eachExpiryIn(bucket int, fn func(key string, kv KeyValue) bool)
+	// compact reclaims space freed by overwrites and deletes, if the
+	// engine leaves any behind
+	compact()
+	// overhead is roughly what an entry takes up besides its key and
+	// value bytes
+	overhead() int64
+}
+
+func newEngine(name string) (engine, error) {
+	switch name {
+	case EngineMap, "":
+		return &mapEngine{}, nil
+	case EngineArena:
+		return newArenaEngine(), nil
+	}
+	if dir, ok := engineDir(name, EngineDisk); ok {
+		return newDiskEngine(name, dir)
+	}
+	if dir, ok := engineDir(name, EngineTiered); ok {
+		return newTieredEngine(name, dir)
+	}
+	if dir, ok := engineDir(name, EngineMmap); ok {
+		return newMappedArena(name, dir)
+	}
+	return nil, fmt.Errorf("unknown storage engine %q", name)
+}
+
+// newStorage returns an empty engine like the one under like, with its
+// settings, under the storage layers: values sealed with aead unless it is
+// nil, then compressed from threshold bytes if it is above zero
+func newStorage(like engine, aead cipher.AEAD, threshold int) (engine, error) {
+	e, err := newEngine(like.name())
+	if err != nil {
+		return nil, err
+	}
+	if t := tierOf(like); t != nil {
+		e.(*tieredEngine).limit = t.limit
+	}
+	routed, err := newNamespacedEngine(e, routesOf(like))
+	if err != nil {
+		releaseStorage(e)
+		return nil, err
+	}
+	e = routed
+	if aead != nil {
+		e = &encryptEngine{engine: e, aead: aead}
+	}
+	if threshold > 0 {
+		e = newCompressEngine(e, threshold)
+	}
+	return e, nil
+}
+
+// baseOf is the engine under every layer of e
+func baseOf(e engine) engine {
+	e = storageOf(e)
+	if c, ok := e.(*compressEngine); ok {
+		e = c.engine
+	}
+	if s, ok := e.(*encryptEngine); ok {
+		e = s.engine
+	}
+	return e
+}
+
+// releaseStorage frees what an engine replaced by another holds outside
+// the heap, such as a disk engine's files or an arena's mapped segments
+func releaseStorage(e engine) {
+	switch b := baseOf(e).(type) {
+	case *namespacedEngine:
+		b.close()
+		releaseStorage(b.store)
+	case *arenaEngine:
+		b.close()
+	case *diskEngine:
+		b.close()
+	case *tieredEngine:
+		b.cold.close()
+	}
+}
+
+// relayer moves every entry of kvs into new storage layers over engines
+// like those under like, see newStorage, keeping the indexes and delta
+// log it has; caller must hold kvs.mu
+func (kvs *KeyValueStore) relayer(like engine, aead cipher.AEAD, threshold int) error {
+	data, err := newStorage(like, aead, threshold)
+	if err != nil {
+		return err
+	}
+	kvs.data.each(func(key string, kv KeyValue) bool {
+		data.set(key, kv)
+		return true
+	})
+	releaseStorage(kvs.data)
+	sep := ""
+	if d, ok := kvs.data.(*dirEngine); ok {
+		sep = d.sep
+	}
+	kvs.data = withIndexes(kvs.tracked(data), sep, orderOf(kvs.data) != nil, searchOf(kvs.data) != nil)
+	return nil
+}
+
+// mapEngine is the default engine: one heap object per entry
+type mapEngine struct {
+	entries buckets[KeyValue]
+}
+
+func (m *mapEngine) name() string { return EngineMap }
+
+func (m *mapEngine) get(key string) (KeyValue, bool) { return m.entries.get(key) }
+func (m *mapEngine) set(key string, kv KeyValue)     { m.entries.set(key, kv) }
+func (m *mapEngine) delete(key string)               { m.entries.delete(key) }
+func (m *mapEngine) len() int                        { return m.entries.len() }
+func (m *mapEngine) compact()                        {}
+
+// a map entry is the key's string header, the KeyValue and the bucket's
+// share of a hash byte and overflow pointer
+func (m *mapEngine) overhead() int64 {
+	return int64(unsafe.Sizeof("") + unsafe.Sizeof(KeyValue{}) + 8)
+}
+
+func (m *mapEngine) each(fn func(key string, kv KeyValue) bool) { m.entries.each(fn) }
+
+func (m *mapEngine) eachExpiry(fn func(key string, kv KeyValue) bool) { m.entries.each(fn) }
+
+func (m *mapEngine) eachExpiryIn(bucket int, fn func(key string, kv KeyValue) bool) {
+	m.entries.eachIn(bucket, fn)
+}
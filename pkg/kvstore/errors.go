@@ -0,0 +1,35 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MaxRecentErrors is how many errors RecentErrors remembers
+const MaxRecentErrors = 100
+
+// recentErrors keeps the last MaxRecentErrors errors for DIAGNOSE
+var recentErrors struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+// RecordError prints the error and remembers it for RecentErrors
+func RecordError(msg string, err error) {
+	Logf(LogError, "%s %v", msg, err)
+	line := fmt.Sprintf("%s %s %v", time.Now().Format(time.RFC3339), msg, err)
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+	recentErrors.lines = append(recentErrors.lines, line)
+	if len(recentErrors.lines) > MaxRecentErrors {
+		recentErrors.lines = recentErrors.lines[len(recentErrors.lines)-MaxRecentErrors:]
+	}
+}
+
+// RecentErrors returns the remembered errors, oldest first
+func RecentErrors() []string {
+	recentErrors.mu.Lock()
+	defer recentErrors.mu.Unlock()
+	return append([]string(nil), recentErrors.lines...)
+}
@@ -0,0 +1,250 @@
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// EventType says what happened to a key
+type EventType int
+
+const (
+	EventSet EventType = iota + 1
+	EventUpdate
+	EventDelete
+	// EventExpire is the janitor removing a key that outlived its TTL
+	EventExpire
+	// EventEvict is a key removed to make room; pinned keys never are
+	EventEvict
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventSet:
+		return "set"
+	case EventUpdate:
+		return "update"
+	case EventDelete:
+		return "delete"
+	case EventExpire:
+		return "expire"
+	case EventEvict:
+		return "evict"
+	}
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is one change to a key, see KeyValueStore.Events. Value is the new
+// value for EventSet and EventUpdate and empty otherwise.
+type Event struct {
+	Type  EventType
+	Key   string
+	Value string
+	Time  time.Time
+}
+
+// EventPolicy says what happens to events the consumer of Events has not
+// read yet once the buffer is full
+type EventPolicy int
+
+const (
+	// EventsDropOldest discards the oldest buffered event to make room, so
+	// writers never wait; DroppedEvents counts the losses
+	EventsDropOldest EventPolicy = iota
+	// EventsBlock makes SET, UPDATE and DELETE wait for room, so nothing
+	// is lost but a slow consumer slows every writer. Expiry and eviction
+	// don't wait and may overfill the buffer.
+	EventsBlock
+	// EventsCoalesce keeps only the latest buffered event per key, which is
+	// enough for consumers that only need each key's current state. A full
+	// buffer of distinct keys drops the oldest like EventsDropOldest.
+	EventsCoalesce
+)
+
+func (p EventPolicy) String() string {
+	switch p {
+	case EventsDropOldest:
+		return "drop-oldest"
+	case EventsBlock:
+		return "block"
+	case EventsCoalesce:
+		return "coalesce"
+	}
+	return fmt.Sprintf("EventPolicy(%d)", int(p))
+}
+
+// ParseEventPolicy parses the names printed by EventPolicy.String
+func ParseEventPolicy(name string) (EventPolicy, error) {
+	for _, p := range []EventPolicy{EventsDropOldest, EventsBlock, EventsCoalesce} {
+		if p.String() == name {
+			return p, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown event policy %q, expected drop-oldest, block or coalesce", name)
+}
+
+// DefaultEventBuffer is how many undelivered events Events keeps unless
+// SetEventBuffer says otherwise
+const DefaultEventBuffer = 1024
+
+// eventStream buffers events between the store's writers and the single
+// goroutine feeding the Events channel
+type eventStream struct {
+	on      atomic.Bool
+	dropped atomic.Uint64
+
+	mu     sync.Mutex
+	cond   *sync.Cond // signalled when the queue grows or shrinks
+	size   int
+	policy EventPolicy
+	queue  []Event
+	head   uint64            // sequence number of queue[0]
+	latest map[string]uint64 // EventsCoalesce: sequence number of each key's event
+	out    chan Event
+	// sending is set while deliver holds an event it popped, until the
+	// consumer reads it or deliver next takes es.mu
+	sending bool
+}
+
+func newEventStream() *eventStream {
+	es := &eventStream{size: DefaultEventBuffer, latest: make(map[string]uint64)}
+	es.cond = sync.NewCond(&es.mu)
+	return es
+}
+
+// Events returns the channel of changes to the store's keys, the common
+// source for watches, change data capture, webhooks and cache invalidation.
+// There is one channel per store and every call returns it, so a program
+// with several consumers fans it out itself. Nothing is recorded until the
+// first call. Events follow the order of the writes for each key;
+// RestoreBackup replaces the data without any.
+func (kvs *KeyValueStore) Events() <-chan Event {
+	es := kvs.events
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.out == nil {
+		es.out = make(chan Event)
+		es.on.Store(true)
+		go es.deliver()
+	}
+	return es.out
+}
+
+// SetEventBuffer sets how many undelivered events are kept, and policy for
+// when there are more; size <= 0 means DefaultEventBuffer. Call it before
+// Events.
+func (kvs *KeyValueStore) SetEventBuffer(size int, policy EventPolicy) {
+	if size <= 0 {
+		size = DefaultEventBuffer
+	}
+	es := kvs.events
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	es.size = size
+	es.policy = policy
+	es.cond.Broadcast()
+}
+
+// DroppedEvents is how many events were discarded because the consumer of
+// Events fell behind
+func (kvs *KeyValueStore) DroppedEvents() uint64 {
+	return kvs.events.dropped.Load()
+}
+
+// PendingEvents is how many events are buffered for the Events channel
+// and not read from it yet, for a consumer that is shutting down to read
+// up to the last write. It may count one already read for a moment.
+func (kvs *KeyValueStore) PendingEvents() int {
+	es := kvs.events
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	n := len(es.queue)
+	if es.sending {
+		n++
+	}
+	return n
+}
+
+// wait blocks a writer while the buffer is full under EventsBlock; call it
+// before taking kvs.mu so the consumer can still read the store
+func (es *eventStream) wait() {
+	if !es.on.Load() {
+		return
+	}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	for es.policy == EventsBlock && len(es.queue) >= es.size {
+		es.cond.Wait()
+	}
+}
+
+// emit records a change to key, made at the store's latest revision:
+// an event, a stale key for the read cache, see staleKeys, and an
+// invalidation for the clients caching it, see Track. An expired key is
+// stale whatever revision the cache holds. Caller holds kvs.mu.
+func (kvs *KeyValueStore) emit(typ EventType, key, value string, now time.Time) {
+	rev := kvs.revision
+	if typ == EventExpire {
+		rev = math.MaxUint64
+	}
+	kvs.stale.add(key, rev)
+	kvs.tracking.changed(key)
+	kvs.events.emit(typ, key, value, now)
+}
+
+// emit buffers an event, caller holds kvs.mu so events keep the order of
+// the writes
+func (es *eventStream) emit(typ EventType, key, value string, now time.Time) {
+	if !es.on.Load() {
+		return
+	}
+	e := Event{Type: typ, Key: key, Value: value, Time: now}
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	if es.policy == EventsCoalesce {
+		if seq, ok := es.latest[key]; ok {
+			es.queue[seq-es.head] = e
+			return
+		}
+	}
+	if es.policy != EventsBlock && len(es.queue) >= es.size {
+		es.pop()
+		es.dropped.Add(1)
+	}
+	if es.policy == EventsCoalesce {
+		es.latest[key] = es.head + uint64(len(es.queue))
+	}
+	es.queue = append(es.queue, e)
+	es.cond.Broadcast()
+}
+
+// pop removes the oldest buffered event, caller holds es.mu
+func (es *eventStream) pop() Event {
+	e := es.queue[0]
+	es.queue[0] = Event{}
+	es.queue = es.queue[1:]
+	if seq, ok := es.latest[e.Key]; ok && seq == es.head {
+		delete(es.latest, e.Key)
+	}
+	es.head++
+	return e
+}
+
+// deliver feeds the Events channel for the life of the program
+func (es *eventStream) deliver() {
+	for {
+		es.mu.Lock()
+		es.sending = false
+		for len(es.queue) == 0 {
+			es.cond.Wait()
+		}
+		e := es.pop()
+		es.sending = true
+		es.cond.Broadcast()
+		es.mu.Unlock()
+		es.out <- e
+	}
+}
@@ -0,0 +1,272 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// The eviction policies registered by this package, see
+// ServerProxy.SetEvictionPolicy
+const (
+	EvictLRU  = "lru"  // the least recently read or written
+	EvictLFU  = "lfu"  // the least often read or written, the oldest of those first
+	EvictFIFO = "fifo" // the first cached, however often it was read since
+)
+
+// EvictionPolicy decides which keys a ServerProxy evicts when its cache is
+// full. The cache tells it which keys come and go and which are used, and
+// asks it for victims; its calls are never concurrent.
+type EvictionPolicy interface {
+	// Add is called when key is cached
+	Add(key string)
+	// Access is called when a cached key is read, or its value replaced
+	Access(key string)
+	// Remove is called when key leaves the cache, evicted or not
+	Remove(key string)
+	// Victims calls yield with the keys to evict, the first to go first,
+	// until it returns false or every key was offered. The cache may
+	// Remove the key yield is given, and skips the ones it can't evict,
+	// pinned keys, so a key that was offered is not offered again.
+	Victims(yield func(key string) bool)
+	// Reset forgets every key
+	Reset()
+}
+
+var (
+	evictionMu       sync.RWMutex
+	evictionPolicies = map[string]func() EvictionPolicy{
+		EvictLRU:  func() EvictionPolicy { return newListPolicy(true) },
+		EvictLFU:  func() EvictionPolicy { return newLFUPolicy() },
+		EvictFIFO: func() EvictionPolicy { return newListPolicy(false) },
+	}
+)
+
+// RegisterEvictionPolicy makes the policy newPolicy returns selectable by
+// name, e.g. with kvs-server -cache-policy, so workloads the built-in ones
+// serve badly can bring their own. Call it from an init function. A policy
+// that admits keys selectively, like TinyLFU, offers a key it just added
+// as the first victim to keep it out. Like sql.Register it panics if the
+// name is taken or newPolicy is nil.
+func RegisterEvictionPolicy(name string, newPolicy func() EvictionPolicy) {
+	evictionMu.Lock()
+	defer evictionMu.Unlock()
+	if name == "" || newPolicy == nil {
+		panic("kvstore: eviction policy needs a name and a constructor")
+	}
+	if _, dup := evictionPolicies[name]; dup {
+		panic(fmt.Sprintf("kvstore: eviction policy %q registered twice", name))
+	}
+	evictionPolicies[name] = newPolicy
+}
+
+// EvictionPolicies lists the names of the registered eviction policies
+func EvictionPolicies() []string {
+	evictionMu.RLock()
+	defer evictionMu.RUnlock()
+	names := make([]string, 0, len(evictionPolicies))
+	for name := range evictionPolicies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// newEvictionPolicy returns a new policy of the one registered as name,
+// "" being EvictLRU
+func newEvictionPolicy(name string) (EvictionPolicy, error) {
+	if name == "" {
+		name = EvictLRU
+	}
+	evictionMu.RLock()
+	newPolicy, ok := evictionPolicies[name]
+	evictionMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown eviction policy %q, expected one of %v", name, EvictionPolicies())
+	}
+	return newPolicy(), nil
+}
+
+// listNode is a key in a listPolicy or a bucket of an lfuPolicy
+type listNode struct {
+	key        string
+	bucket     *lfuBucket
+	prev, next *listNode
+}
+
+// keyList is a list of keys from the newest, after the sentinel root, to
+// the oldest, before it
+type keyList struct {
+	root listNode
+	len  int
+}
+
+func (l *keyList) init() {
+	l.root.prev, l.root.next = &l.root, &l.root
+	l.len = 0
+}
+
+func (l *keyList) pushFront(n *listNode) {
+	n.prev, n.next = &l.root, l.root.next
+	l.root.next.prev = n
+	l.root.next = n
+	l.len++
+}
+
+func (l *keyList) unlink(n *listNode) {
+	n.prev.next, n.next.prev = n.next, n.prev
+	n.prev, n.next = nil, nil
+	l.len--
+}
+
+// oldest calls yield with the keys from the oldest until it returns false
+func (l *keyList) oldest(yield func(key string) bool) bool {
+	for n := l.root.prev; n != &l.root; {
+		// yield may remove n
+		prev := n.prev
+		if !yield(n.key) {
+			return false
+		}
+		n = prev
+	}
+	return true
+}
+
+// listPolicy evicts the oldest key in a list: by when it was last used if
+// moveOnAccess, for LRU, or else by when it was cached, for FIFO
+type listPolicy struct {
+	nodes        map[string]*listNode
+	list         keyList
+	moveOnAccess bool
+}
+
+func newListPolicy(moveOnAccess bool) *listPolicy {
+	p := &listPolicy{nodes: make(map[string]*listNode), moveOnAccess: moveOnAccess}
+	p.list.init()
+	return p
+}
+
+func (p *listPolicy) Add(key string) {
+	if _, ok := p.nodes[key]; ok {
+		p.Access(key)
+		return
+	}
+	n := &listNode{key: key}
+	p.nodes[key] = n
+	p.list.pushFront(n)
+}
+
+func (p *listPolicy) Access(key string) {
+	if n, ok := p.nodes[key]; ok && p.moveOnAccess {
+		p.list.unlink(n)
+		p.list.pushFront(n)
+	}
+}
+
+func (p *listPolicy) Remove(key string) {
+	if n, ok := p.nodes[key]; ok {
+		p.list.unlink(n)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *listPolicy) Victims(yield func(key string) bool) {
+	p.list.oldest(yield)
+}
+
+func (p *listPolicy) Reset() {
+	p.nodes = make(map[string]*listNode)
+	p.list.init()
+}
+
+// lfuBucket holds the keys used freq times, by when they were last used
+type lfuBucket struct {
+	freq       uint64
+	keys       keyList
+	prev, next *lfuBucket
+}
+
+// lfuPolicy evicts the key used the fewest times, and of those the one
+// used longest ago. Its buckets are kept by ascending freq, after the
+// sentinel root, and only the ones holding keys are kept, so every call is
+// O(1).
+type lfuPolicy struct {
+	nodes map[string]*listNode
+	root  lfuBucket
+}
+
+func newLFUPolicy() *lfuPolicy {
+	p := &lfuPolicy{nodes: make(map[string]*listNode)}
+	p.root.prev, p.root.next = &p.root, &p.root
+	return p
+}
+
+func (p *lfuPolicy) Add(key string) {
+	if _, ok := p.nodes[key]; ok {
+		p.Access(key)
+		return
+	}
+	n := &listNode{key: key}
+	p.nodes[key] = n
+	p.insert(n, &p.root, 1)
+}
+
+func (p *lfuPolicy) Access(key string) {
+	n, ok := p.nodes[key]
+	if !ok {
+		return
+	}
+	b, after := n.bucket, n.bucket
+	if b.keys.len == 1 {
+		// take drops b
+		after = b.prev
+	}
+	p.take(n)
+	p.insert(n, after, b.freq+1)
+}
+
+// insert puts n in the bucket of freq, which comes right after after
+func (p *lfuPolicy) insert(n *listNode, after *lfuBucket, freq uint64) {
+	b := after.next
+	if b == &p.root || b.freq != freq {
+		b = &lfuBucket{freq: freq, prev: after, next: after.next}
+		b.keys.init()
+		after.next.prev = b
+		after.next = b
+	}
+	n.bucket = b
+	b.keys.pushFront(n)
+}
+
+// take takes n out of its bucket, dropping the bucket if it is left empty
+func (p *lfuPolicy) take(n *listNode) {
+	b := n.bucket
+	b.keys.unlink(n)
+	n.bucket = nil
+	if b.keys.len == 0 {
+		b.prev.next, b.next.prev = b.next, b.prev
+	}
+}
+
+func (p *lfuPolicy) Remove(key string) {
+	if n, ok := p.nodes[key]; ok {
+		p.take(n)
+		delete(p.nodes, key)
+	}
+}
+
+func (p *lfuPolicy) Victims(yield func(key string) bool) {
+	for b := p.root.next; b != &p.root; {
+		// yield may empty b and drop it
+		next := b.next
+		if !b.keys.oldest(yield) {
+			return
+		}
+		b = next
+	}
+}
+
+func (p *lfuPolicy) Reset() {
+	p.nodes = make(map[string]*listNode)
+	p.root.prev, p.root.next = &p.root, &p.root
+}
@@ -0,0 +1,196 @@
+package kvstore
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// export formats, see WriteExport
+const (
+	ExportJSON = "json"
+	ExportCSV  = "csv"
+)
+
+// exportVersion is the version of the JSON export format
+const exportVersion = 1
+
+// exportHeader is the first row of a CSV export
+var exportHeader = []string{"key", "type", "value", "written", "expires", "checksum", "revision"}
+
+// ExportRecord is one key of an export: its value in the encoding of its
+// type, as protocol.EncodeHash and the like describe, when it was written
+// and, if it has a TTL of its own, when it expires; keys without expire
+// after the default TTL of the store they are imported into. Revision is
+// for reading only and is not imported.
+type ExportRecord struct {
+	Key      string     `json:"key"`
+	Type     string     `json:"type"`
+	Value    string     `json:"value"`
+	Written  time.Time  `json:"written"`
+	Expires  *time.Time `json:"expires,omitempty"`
+	Checksum uint32     `json:"checksum,omitempty"`
+	Revision uint64     `json:"revision,omitempty"`
+}
+
+// exportFile is a JSON export
+type exportFile struct {
+	Format   string         `json:"format"`
+	Version  int            `json:"version"`
+	Exported time.Time      `json:"exported"`
+	Keys     []ExportRecord `json:"keys"`
+}
+
+// Export returns every live key of kvs, in key order, for WriteExport, and
+// the keys left out because their values fail their checksum.
+func (kvs *KeyValueStore) Export() (records []ExportRecord, damaged []string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	now := kvs.now()
+	kvs.data.each(func(key string, kv KeyValue) bool {
+		switch {
+		case kvs.expired(kv, now):
+		case !kv.Intact():
+			damaged = append(damaged, key)
+		default:
+			r := ExportRecord{Key: key, Type: kv.Type.String(), Value: kv.Value, Written: kv.Timestamp, Checksum: kv.Checksum, Revision: kv.Revision}
+			if kv.TTL > 0 {
+				expires := kv.Timestamp.Add(kv.TTL)
+				r.Expires = &expires
+			}
+			records = append(records, r)
+		}
+		return true
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].Key < records[j].Key })
+	return records, damaged
+}
+
+// Import writes records over the keys of kvs, leaving its other keys as
+// they are, as MergeSnapshot does: records already expired, or whose value
+// fails its checksum, are dropped. Each key gets a new revision.
+func (kvs *KeyValueStore) Import(records []ExportRecord) (RestoreStats, error) {
+	snapshot := BackupSnapshot{Data: make(map[string]KeyValue, len(records))}
+	for _, r := range records {
+		typ, ok := parseValueType(r.Type)
+		if !ok {
+			return RestoreStats{}, fmt.Errorf("key %q: unknown type %q", r.Key, r.Type)
+		}
+		kv := KeyValue{Value: r.Value, Timestamp: r.Written, Checksum: r.Checksum, Type: typ}
+		if kv.Timestamp.IsZero() {
+			kv.Timestamp = kvs.now()
+		}
+		if r.Expires != nil {
+			// a TTL of zero would be the store's default, so one that ran
+			// out stays above it, and the key is dropped as expired
+			kv.TTL = max(r.Expires.Sub(kv.Timestamp), time.Nanosecond)
+		}
+		snapshot.Data[r.Key] = kv
+	}
+	return kvs.MergeSnapshot(snapshot, true)
+}
+
+// parseValueType parses a ValueType's String
+func parseValueType(name string) (ValueType, bool) {
+	for t := TypeString; t <= TypeSequence; t++ {
+		if t.String() == name {
+			return t, true
+		}
+	}
+	return 0, name == ""
+}
+
+// WriteExport writes records to w as format, ExportJSON or ExportCSV. A
+// JSON export is one document holding the records under "keys"; a CSV
+// export has a row per key under a header row naming the columns, times
+// in RFC 3339 and an empty expires for keys without a TTL of their own.
+func WriteExport(w io.Writer, records []ExportRecord, format string) error {
+	switch format {
+	case ExportJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(exportFile{Format: "kvs-export", Version: exportVersion, Exported: time.Now().UTC(), Keys: records})
+	case ExportCSV:
+		cw := csv.NewWriter(w)
+		cw.Write(exportHeader)
+		for _, r := range records {
+			expires := ""
+			if r.Expires != nil {
+				expires = r.Expires.UTC().Format(time.RFC3339Nano)
+			}
+			cw.Write([]string{r.Key, r.Type, r.Value, r.Written.UTC().Format(time.RFC3339Nano), expires,
+				strconv.FormatUint(uint64(r.Checksum), 10), strconv.FormatUint(r.Revision, 10)})
+		}
+		cw.Flush()
+		return cw.Error()
+	}
+	return fmt.Errorf("unknown export format %q", format)
+}
+
+// ReadExport reads the records of an export WriteExport wrote as format.
+func ReadExport(r io.Reader, format string) ([]ExportRecord, error) {
+	switch format {
+	case ExportJSON:
+		var file exportFile
+		if err := json.NewDecoder(r).Decode(&file); err != nil {
+			return nil, fmt.Errorf("reading export: %w", err)
+		}
+		if file.Version > exportVersion {
+			return nil, fmt.Errorf("export version %d is newer than %d", file.Version, exportVersion)
+		}
+		return file.Keys, nil
+	case ExportCSV:
+		cr := csv.NewReader(r)
+		cr.FieldsPerRecord = len(exportHeader)
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("reading export: %w", err)
+		}
+		if header[0] != exportHeader[0] {
+			return nil, errors.New("reading export: no header row")
+		}
+		var records []ExportRecord
+		for {
+			row, err := cr.Read()
+			if errors.Is(err, io.EOF) {
+				return records, nil
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading export: %w", err)
+			}
+			record, err := parseExportRow(row)
+			if err != nil {
+				return nil, fmt.Errorf("reading export: key %q: %w", row[0], err)
+			}
+			records = append(records, record)
+		}
+	}
+	return nil, fmt.Errorf("unknown export format %q", format)
+}
+
+// parseExportRow parses a CSV export row
+func parseExportRow(row []string) (r ExportRecord, err error) {
+	r.Key, r.Type, r.Value = row[0], row[1], row[2]
+	if r.Written, err = time.Parse(time.RFC3339Nano, row[3]); err != nil {
+		return r, err
+	}
+	if row[4] != "" {
+		expires, err := time.Parse(time.RFC3339Nano, row[4])
+		if err != nil {
+			return r, err
+		}
+		r.Expires = &expires
+	}
+	sum, err := strconv.ParseUint(row[5], 10, 32)
+	if err != nil {
+		return r, err
+	}
+	r.Checksum = uint32(sum)
+	r.Revision, err = strconv.ParseUint(row[6], 10, 64)
+	return r, err
+}
@@ -0,0 +1,188 @@
+package kvstore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// environment variables kvs-server reads its file keys from unless told
+// others
+const (
+	FileKeyEnv     = "KVS_FILE_KEY"
+	FileOldKeysEnv = "KVS_FILE_OLD_KEYS"
+)
+
+// ErrFileKey is returned for a file, or a line of one, encrypted with a
+// key its FileCipher doesn't have
+var ErrFileKey = errors.New("file is encrypted with a key that was not given")
+
+// sealedFileMagic starts an encrypted snapshot, followed by the ID of its
+// key and a newline; a JSON snapshot starts with '{'
+const sealedFileMagic = "KVS-SEALED "
+
+// sealedLinePrefix starts an encrypted line of the journal or pub/sub log,
+// followed by the ID of its key, ':' and the sealed line in base64; a JSON
+// line starts with '{'
+const sealedLinePrefix = '!'
+
+// FileCipher encrypts the files a store and its server write, snapshots,
+// the journal and the pub/sub log, with AES-GCM, independently of
+// SetEncryption, since backups often end up on storage less trusted than
+// the server. Everything is written under its current key. Its old keys
+// only read what was written before a rotation: snapshots are written
+// under the current key from the next one on, and OpenJournalWithCipher
+// and OpenPubSubWithCipher rewrite the files they open under it. Files in
+// the clear are read as they are, so encryption can be turned on for an
+// existing store. A nil *FileCipher writes in the clear.
+type FileCipher struct {
+	current string
+	keys    map[string]cipher.AEAD
+}
+
+// NewFileCipher returns a FileCipher writing under current and reading
+// under it and old, AES keys of 16, 24 or 32 bytes each
+func NewFileCipher(current []byte, old ...[]byte) (*FileCipher, error) {
+	c := &FileCipher{keys: make(map[string]cipher.AEAD)}
+	for i, key := range append([][]byte{current}, old...) {
+		aead, err := newAEAD(key)
+		if err != nil {
+			return nil, err
+		}
+		id := fileKeyID(key)
+		if i == 0 {
+			c.current = id
+		}
+		c.keys[id] = aead
+	}
+	return c, nil
+}
+
+// fileKeyID names key in what it encrypts, without giving it away: the
+// start of its SHA-256 in hex
+func fileKeyID(key []byte) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:4])
+}
+
+// KeyID is the ID of the key c writes under, "" for a nil c
+func (c *FileCipher) KeyID() string {
+	if c == nil {
+		return ""
+	}
+	return c.current
+}
+
+func (c *FileCipher) seal(plain, header []byte) []byte {
+	aead := c.keys[c.current]
+	out := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(out); err != nil {
+		panic(err) // crypto/rand doesn't fail on supported platforms
+	}
+	return aead.Seal(out, out, plain, header)
+}
+
+func (c *FileCipher) open(id string, sealed, header []byte) ([]byte, error) {
+	if c == nil {
+		return nil, ErrFileKey
+	}
+	aead, ok := c.keys[id]
+	if !ok {
+		return nil, ErrFileKey
+	}
+	n := aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed data too short")
+	}
+	return aead.Open(nil, sealed[:n], sealed[n:], header)
+}
+
+// sealFile encrypts a whole snapshot, the header it starts with bound in
+// as additional data
+func (c *FileCipher) sealFile(data []byte) []byte {
+	if c == nil {
+		return data
+	}
+	header := []byte(sealedFileMagic + c.current + "\n")
+	return append(header, c.seal(data, header)...)
+}
+
+// openFile undoes sealFile, and returns data in the clear as it is
+func (c *FileCipher) openFile(data []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, []byte(sealedFileMagic)) {
+		return data, nil
+	}
+	end := bytes.IndexByte(data, '\n')
+	if end < 0 {
+		return nil, errors.New("sealed file header has no end")
+	}
+	id := string(data[len(sealedFileMagic):end])
+	plain, err := c.open(id, data[end+1:], data[:end+1])
+	if err != nil && !errors.Is(err, ErrFileKey) {
+		err = fmt.Errorf("decrypting file: %w", err)
+	}
+	return plain, err
+}
+
+// sealLine encrypts one line of a log, without its newline
+func (c *FileCipher) sealLine(line []byte) []byte {
+	if c == nil {
+		return line
+	}
+	sealed := c.seal(line, nil)
+	out := make([]byte, 0, 2+len(c.current)+base64.StdEncoding.EncodedLen(len(sealed)))
+	out = append(out, sealedLinePrefix)
+	out = append(out, c.current...)
+	out = append(out, ':')
+	return base64.StdEncoding.AppendEncode(out, sealed)
+}
+
+// openLine undoes sealLine, ignoring a trailing newline, and returns a
+// line in the clear as it is
+func (c *FileCipher) openLine(line []byte) ([]byte, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	if len(line) == 0 || line[0] != sealedLinePrefix {
+		return line, nil
+	}
+	id, encoded, ok := bytes.Cut(line[1:], []byte(":"))
+	if !ok {
+		return nil, errors.New("sealed line has no key ID")
+	}
+	sealed, err := base64.StdEncoding.DecodeString(string(encoded))
+	if err != nil {
+		return nil, err
+	}
+	return c.open(string(id), sealed, nil)
+}
+
+// stale reports whether line is not written the way c writes lines: in
+// the clear for a nil c, else under its current key
+func (c *FileCipher) stale(line []byte) bool {
+	sealed := len(line) > 0 && line[0] == sealedLinePrefix
+	if c == nil {
+		return sealed
+	}
+	return !sealed || !bytes.HasPrefix(line[1:], []byte(c.current+":"))
+}
+
+// SetFileCipher makes WriteBackup encrypt snapshots with c, and
+// RestoreBackup decrypt them, or write them in the clear if c is nil. A
+// new current key takes effect from the next snapshot, which is how a
+// rotation re-encrypts the backup file.
+func (kvs *KeyValueStore) SetFileCipher(c *FileCipher) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.fileCipher = c
+}
+
+// FileCipher returns what SetFileCipher set
+func (kvs *KeyValueStore) FileCipher() *FileCipher {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.fileCipher
+}
@@ -0,0 +1,100 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var (
+	oldFileKey = []byte("old-file-key-16b")
+	newFileKey = []byte("new-file-key-16b")
+)
+
+func newFileCipher(t *testing.T, current []byte, old ...[]byte) *FileCipher {
+	t.Helper()
+	c, err := NewFileCipher(current, old...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestSnapshotKeyRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "backup.snap")
+	kvs := NewKeyValueStore()
+	kvs.SetBackup(path, 0)
+	kvs.SetFileCipher(newFileCipher(t, oldFileKey))
+	kvs.SET("k", "secret value")
+	if err := WriteBackup(kvs); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if !bytes.HasPrefix(data, []byte(sealedFileMagic+fileKeyID(oldFileKey))) || bytes.Contains(data, []byte("secret value")) {
+		t.Fatalf("snapshot not sealed under the old key: %.40q", data)
+	}
+
+	restore := func(c *FileCipher) error {
+		t.Helper()
+		into := NewKeyValueStore()
+		into.SetFileCipher(c)
+		_, err := RestoreBackup(into, path)
+		if err == nil {
+			if value, _ := into.GET("k"); value != "secret value" {
+				t.Errorf("GET after restore = %q", value)
+			}
+		}
+		return err
+	}
+	if err := restore(newFileCipher(t, newFileKey)); !errors.Is(err, ErrFileKey) {
+		t.Errorf("restore without the old key = %v, want ErrFileKey", err)
+	}
+	// rotating: the old key still reads, the next snapshot is under the new
+	rotated := newFileCipher(t, newFileKey, oldFileKey)
+	if err := restore(rotated); err != nil {
+		t.Fatalf("restore with the old key kept: %v", err)
+	}
+	kvs.SetFileCipher(rotated)
+	if err := WriteBackup(kvs); err != nil {
+		t.Fatal(err)
+	}
+	if err := restore(newFileCipher(t, newFileKey)); err != nil {
+		t.Errorf("restore of the next snapshot with only the new key: %v", err)
+	}
+}
+
+func TestJournalResealedOnRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "journal")
+	j, err := OpenJournalWithCipher(path, newFileCipher(t, oldFileKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := j.Append("SET", "k", "secret value", "test"); err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+
+	j, err = OpenJournalWithCipher(path, newFileCipher(t, newFileKey, oldFileKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	j.Close()
+	data, _ := os.ReadFile(path)
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		if !strings.HasPrefix(line, string(sealedLinePrefix)+fileKeyID(newFileKey)+":") {
+			t.Errorf("journal line not resealed under the new key: %.40q", line)
+		}
+	}
+	j, err = OpenJournalWithCipher(path, newFileCipher(t, newFileKey))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+	entries, err := j.Since(0, 0)
+	if err != nil || len(entries) != 1 || entries[0].Value != "secret value" {
+		t.Errorf("entries with only the new key = %+v, %v", entries, err)
+	}
+}
@@ -0,0 +1,213 @@
+package kvstore
+
+import (
+	"math"
+	"sort"
+)
+
+// Locations are kept in a sorted set, each member scored with the geohash
+// of its longitude and latitude: GeoStep bits of each, interleaved
+// longitude first into an integer that a float64 holds exactly. Nearby
+// points share a prefix, so a cell of the grid at any step is one range
+// of scores.
+
+// GeoStep is how many bits of longitude and of latitude a geohash keeps,
+// which places a point to within about a metre
+const GeoStep = 26
+
+// Latitudes are bounded as in web maps, where the Mercator projection
+// ends; longitudes span the whole globe.
+const (
+	GeoMinLat = -85.05112878
+	GeoMaxLat = 85.05112878
+	GeoMinLon = -180.0
+	GeoMaxLon = 180.0
+)
+
+// earthRadius is the Earth's mean radius in metres, for distances
+const earthRadius = 6372797.560856
+
+// GeoMember is a member of a geo set with its location and its distance in
+// metres from the centre of a search
+type GeoMember struct {
+	Member   string
+	Lon, Lat float64
+	Distance float64
+}
+
+// GeoArea is the area a GEOSEARCH covers, centred on Lon and Lat: a circle
+// of Radius metres if Radius is above zero, else a box Width metres east to
+// west and Height metres north to south
+type GeoArea struct {
+	Lon, Lat              float64
+	Radius, Width, Height float64
+}
+
+// ValidGeo reports whether lon and lat are within the bounds a geohash
+// covers
+func ValidGeo(lon, lat float64) bool {
+	return lon >= GeoMinLon && lon <= GeoMaxLon && lat >= GeoMinLat && lat <= GeoMaxLat
+}
+
+// GeoScore returns the geohash of lon and lat as a sorted set score; see
+// ValidGeo for their bounds
+func GeoScore(lon, lat float64) float64 {
+	x, y := geoCell(lon, lat, GeoStep)
+	return float64(interleave(x, y))
+}
+
+// geoCell returns the column and row of the cell holding lon and lat in
+// the grid of 2^step by 2^step cells
+func geoCell(lon, lat float64, step uint) (x, y uint32) {
+	cells := float64(uint64(1) << step)
+	x = uint32(min((lon-GeoMinLon)/(GeoMaxLon-GeoMinLon)*cells, cells-1))
+	y = uint32(min((lat-GeoMinLat)/(GeoMaxLat-GeoMinLat)*cells, cells-1))
+	return x, y
+}
+
+// geoDecode returns the centre of the cell a geohash score stands for
+func geoDecode(score float64) (lon, lat float64) {
+	x, y := deinterleave(uint64(score))
+	cells := float64(uint64(1) << GeoStep)
+	lon = GeoMinLon + (float64(x)+0.5)/cells*(GeoMaxLon-GeoMinLon)
+	lat = GeoMinLat + (float64(y)+0.5)/cells*(GeoMaxLat-GeoMinLat)
+	return lon, lat
+}
+
+// interleave spreads the bits of x over the odd bits of the result and
+// those of y over the even ones
+func interleave(x, y uint32) uint64 {
+	return spread(x)<<1 | spread(y)
+}
+
+func deinterleave(h uint64) (x, y uint32) {
+	return squash(h >> 1), squash(h)
+}
+
+// spread moves bit i of v to bit 2i
+func spread(v uint32) uint64 {
+	h := uint64(v)
+	h = (h | h<<16) & 0x0000FFFF0000FFFF
+	h = (h | h<<8) & 0x00FF00FF00FF00FF
+	h = (h | h<<4) & 0x0F0F0F0F0F0F0F0F
+	h = (h | h<<2) & 0x3333333333333333
+	h = (h | h<<1) & 0x5555555555555555
+	return h
+}
+
+// squash undoes spread, dropping the odd bits
+func squash(h uint64) uint32 {
+	h &= 0x5555555555555555
+	h = (h | h>>1) & 0x3333333333333333
+	h = (h | h>>2) & 0x0F0F0F0F0F0F0F0F
+	h = (h | h>>4) & 0x00FF00FF00FF00FF
+	h = (h | h>>8) & 0x0000FFFF0000FFFF
+	h = (h | h>>16) & 0x00000000FFFFFFFF
+	return uint32(h)
+}
+
+// geoDistance returns the distance in metres between two points along the
+// Earth's surface
+func geoDistance(lon1, lat1, lon2, lat2 float64) float64 {
+	lat1, lat2 = lat1*math.Pi/180, lat2*math.Pi/180
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((lon2 - lon1) * math.Pi / 180 / 2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(u*u+math.Cos(lat1)*math.Cos(lat2)*v*v))
+}
+
+// contains reports whether a holds the point at lon and lat, and how far it
+// is from a's centre
+func (a GeoArea) contains(lon, lat float64) (float64, bool) {
+	d := geoDistance(a.Lon, a.Lat, lon, lat)
+	if a.Radius > 0 {
+		return d, d <= a.Radius
+	}
+	// a box: north to south along the centre's meridian, east to west
+	// along the point's parallel
+	if geoDistance(a.Lon, a.Lat, a.Lon, lat) > a.Height/2 || geoDistance(a.Lon, lat, lon, lat) > a.Width/2 {
+		return d, false
+	}
+	return d, true
+}
+
+// reach is the radius of the circle around a's centre that covers it
+func (a GeoArea) reach() float64 {
+	if a.Radius > 0 {
+		return a.Radius
+	}
+	return math.Hypot(a.Width, a.Height) / 2
+}
+
+// geoRanges returns the score ranges, each from its first score up to but
+// not including its second, that hold every point within reach metres of
+// lon and lat: the cell of the centre and its eight neighbours, at the
+// finest step whose cells are at least reach across
+func geoRanges(lon, lat, reach float64) [][2]float64 {
+	// cells are narrowest east to west at the latitude farthest from the
+	// equator that the search reaches
+	farthest := math.Min(math.Abs(lat)+reach/earthRadius*180/math.Pi, 90)
+	cellHeight := (GeoMaxLat - GeoMinLat) * math.Pi / 180 * earthRadius
+	cellWidth := (GeoMaxLon - GeoMinLon) * math.Pi / 180 * earthRadius * math.Cos(farthest*math.Pi/180)
+	step := 0
+	for step < GeoStep && cellHeight/2 >= reach && cellWidth/2 >= reach {
+		cellHeight, cellWidth = cellHeight/2, cellWidth/2
+		step++
+	}
+	if step == 0 {
+		return [][2]float64{{0, math.Ldexp(1, 2*GeoStep)}}
+	}
+	cx, cy := geoCell(lon, lat, uint(step))
+	cells := int64(1) << step
+	shift := 2 * (GeoStep - step)
+	seen := make(map[uint64]bool, 9)
+	var ranges [][2]float64
+	for dy := int64(-1); dy <= 1; dy++ {
+		y := int64(cy) + dy
+		if y < 0 || y >= cells {
+			continue
+		}
+		for dx := int64(-1); dx <= 1; dx++ {
+			// longitudes wrap around at the antimeridian
+			x := (int64(cx) + dx + cells) % cells
+			h := interleave(uint32(x), uint32(y))
+			if seen[h] {
+				continue
+			}
+			seen[h] = true
+			ranges = append(ranges, [2]float64{float64(h << shift), float64((h + 1) << shift)})
+		}
+	}
+	return ranges
+}
+
+// GEOSEARCH returns the members of the geo set at key within area,
+// nearest first, at most limit of them if limit is above zero. found is
+// false if the key is missing, and a key that holds another type fails
+// with protocol.MsgWrongType.
+func (kvs *KeyValueStore) GEOSEARCH(key string, area GeoArea, limit int) (members []GeoMember, found bool, message string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	z, found, message := kvs.readZSet(key)
+	if !found {
+		return nil, false, message
+	}
+	members = []GeoMember{}
+	for _, r := range geoRanges(area.Lon, area.Lat, area.reach()) {
+		for n := z.seek(r[0]); n != nil && n.Score < r[1]; n = n.next[0].node {
+			lon, lat := geoDecode(n.Score)
+			if d, ok := area.contains(lon, lat); ok {
+				members = append(members, GeoMember{n.Member, lon, lat, d})
+			}
+		}
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if members[i].Distance != members[j].Distance {
+			return members[i].Distance < members[j].Distance
+		}
+		return members[i].Member < members[j].Member
+	})
+	if limit > 0 && len(members) > limit {
+		members = members[:limit]
+	}
+	return members, true, ""
+}
@@ -0,0 +1,79 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// HSET sets each field in fields to the value at the same index in values
+// in the hash at key, creating it with ttl, or the store's default, if it
+// is missing or expired; an existing hash keeps its remaining lifetime.
+// added is how many of the fields are new. item is the entry written, its
+// Value the whole hash as protocol.EncodeHash makes it. A key that holds
+// another type fails with protocol.MsgWrongType, and the write is refused
+// over quota, see SETSUM.
+func (kvs *KeyValueStore) HSET(key string, fields, values []string, ttl time.Duration) (added int, item KeyValue, message string, ok bool) {
+	item, _, message, ok = kvs.modifyHash(key, ttl, func(hash map[string]string) bool {
+		for i, field := range fields {
+			if _, exists := hash[field]; !exists {
+				added++
+			}
+			hash[field] = values[i]
+		}
+		return true
+	})
+	return added, item, message, ok
+}
+
+// HDEL removes fields from the hash at key and returns how many of them
+// it had; if none, nothing is written. Removing the last field deletes the
+// key, reported by deleted; otherwise item is the entry written, see HSET.
+func (kvs *KeyValueStore) HDEL(key string, fields []string) (removed int, item KeyValue, deleted bool, message string, ok bool) {
+	item, deleted, message, ok = kvs.modifyHash(key, 0, func(hash map[string]string) bool {
+		for _, field := range fields {
+			if _, exists := hash[field]; exists {
+				removed++
+				delete(hash, field)
+			}
+		}
+		return removed > 0
+	})
+	return removed, item, deleted, message, ok
+}
+
+// HGET returns field of the hash at key; found is false if either is
+// missing. A key that holds another type fails with protocol.MsgWrongType.
+func (kvs *KeyValueStore) HGET(key, field string) (value string, found bool, message string) {
+	hash, found, message := kvs.HGETALL(key)
+	value, found = hash[field]
+	return value, found, message
+}
+
+// HGETALL returns every field of the hash at key, found false if it is
+// missing. A key that holds another type fails with protocol.MsgWrongType.
+func (kvs *KeyValueStore) HGETALL(key string) (hash map[string]string, found bool, message string) {
+	value, found, message := kvs.readTyped(key, TypeHash)
+	if !found {
+		return nil, false, message
+	}
+	hash, err := protocol.DecodeHash(value)
+	if err != nil {
+		return nil, false, protocol.MsgIntegrity
+	}
+	return hash, true, ""
+}
+
+// modifyHash is modifyTyped for the hash at key, decoded for fn
+func (kvs *KeyValueStore) modifyHash(key string, ttl time.Duration, fn func(hash map[string]string) (changed bool)) (item KeyValue, deleted bool, message string, ok bool) {
+	return kvs.modifyTyped(key, TypeHash, ttl, func(value string) (string, bool, string) {
+		hash, err := protocol.DecodeHash(value)
+		if err != nil {
+			return "", false, protocol.MsgIntegrity
+		}
+		if !fn(hash) {
+			return "", false, ""
+		}
+		return protocol.EncodeHash(hash), true, ""
+	})
+}
@@ -0,0 +1,296 @@
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// journal defaults
+const (
+	JournalFile        = "journal.log"
+	JournalTailSize    = 10000
+	MaxJournalPageSize = 1000
+	MaxJournalWait     = 30 * time.Second
+)
+
+// JournalEntry is one committed write. Revisions start at 1 and increase by
+// one per write, across restarts, so a consumer can resume from the last
+// revision it saw.
+type JournalEntry struct {
+	Revision uint64    `json:"rev"`
+	Time     time.Time `json:"time"`
+	Op       string    `json:"op"`
+	Key      string    `json:"key"`
+	Value    string    `json:"value,omitempty"`
+	Identity string    `json:"identity,omitempty"`
+}
+
+// Journal is the ordered, append-only history of writes. Every entry is
+// appended to a JSON-lines file and the latest JournalTailSize entries are
+// also kept in memory for cheap reads.
+type Journal struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	rev     uint64
+	tail    []JournalEntry
+	appends chan struct{}
+	cipher  *FileCipher
+}
+
+// OpenJournal opens the journal at path, continuing its revisions. An empty
+// path keeps the journal in memory only.
+func OpenJournal(path string) (*Journal, error) {
+	return OpenJournalWithCipher(path, nil)
+}
+
+// OpenJournalWithCipher is OpenJournal for a journal whose entries are
+// encrypted with c, or in the clear if c is nil. A journal with entries
+// written otherwise, e.g. under a key c has as an old one, is rewritten
+// first, so afterwards it only needs the current key.
+func OpenJournalWithCipher(path string, c *FileCipher) (*Journal, error) {
+	j := &Journal{path: path, appends: make(chan struct{}), cipher: c}
+	if path == "" {
+		return j, nil
+	}
+	end, stale, err := j.scan(func(e JournalEntry) bool {
+		j.rev = e.Revision
+		j.remember(e)
+		return true
+	})
+	if err == nil && stale {
+		end, err = j.reseal()
+	}
+	if err != nil {
+		return nil, err
+	}
+	j.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	// drop anything after the last good entry so new writes follow it
+	if err := j.file.Truncate(end); err != nil {
+		j.file.Close()
+		return nil, err
+	}
+	if _, err := j.file.Seek(end, io.SeekStart); err != nil {
+		j.file.Close()
+		return nil, err
+	}
+	return j, nil
+}
+
+// Close closes the journal file
+func (j *Journal) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	err := j.file.Close()
+	j.file = nil
+	return err
+}
+
+// Sync flushes the journal file to disk
+func (j *Journal) Sync() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.file == nil {
+		return nil
+	}
+	return j.file.Sync()
+}
+
+// Append records a write and returns its revision
+func (j *Journal) Append(op, key, value, identity string) (uint64, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e := JournalEntry{Revision: j.rev + 1, Time: time.Now(), Op: op, Key: key, Value: value, Identity: identity}
+	if j.file != nil {
+		line, err := json.Marshal(e)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := j.file.Write(append(j.cipher.sealLine(line), '\n')); err != nil {
+			return 0, err
+		}
+	}
+	j.rev = e.Revision
+	j.remember(e)
+	close(j.appends)
+	j.appends = make(chan struct{})
+	return e.Revision, nil
+}
+
+// Revision returns the revision of the latest write
+func (j *Journal) Revision() uint64 {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.rev
+}
+
+// Since returns up to limit entries with a revision greater than after, in
+// order. Entries no longer in memory are read back from the file.
+func (j *Journal) Since(after uint64, limit int) ([]JournalEntry, error) {
+	if limit <= 0 || limit > MaxJournalPageSize {
+		limit = MaxJournalPageSize
+	}
+	j.mu.Lock()
+	if len(j.tail) > 0 && j.tail[0].Revision <= after+1 || j.file == nil {
+		var out []JournalEntry
+		for _, e := range j.tail {
+			if e.Revision > after {
+				out = append(out, e)
+				if len(out) == limit {
+					break
+				}
+			}
+		}
+		j.mu.Unlock()
+		return out, nil
+	}
+	j.mu.Unlock()
+
+	// older than the in-memory tail; the file is append-only so reading it
+	// without the lock is safe
+	var out []JournalEntry
+	_, _, err := j.scan(func(e JournalEntry) bool {
+		if e.Revision > after {
+			out = append(out, e)
+		}
+		return len(out) < limit
+	})
+	return out, err
+}
+
+// Wait returns up to limit entries after revision after like Since, but if
+// there are none yet it waits up to wait for the next write, or until ctx
+// is done.
+func (j *Journal) Wait(ctx context.Context, after uint64, limit int, wait time.Duration) ([]JournalEntry, error) {
+	if wait > MaxJournalWait {
+		wait = MaxJournalWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		j.mu.Lock()
+		appends := j.appends
+		j.mu.Unlock()
+		entries, err := j.Since(after, limit)
+		if err != nil || len(entries) > 0 || wait <= 0 {
+			return entries, err
+		}
+		select {
+		case <-appends:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// remember keeps e in the in-memory tail, caller must hold j.mu
+func (j *Journal) remember(e JournalEntry) {
+	j.tail = append(j.tail, e)
+	if len(j.tail) > JournalTailSize {
+		j.tail = append([]JournalEntry(nil), j.tail[len(j.tail)-JournalTailSize/2:]...)
+	}
+}
+
+// scan calls fn for each entry in the file until fn returns false and
+// returns the offset just past the last complete entry it read, and
+// whether any entry it read is not written the way j.cipher writes them.
+// An entry encrypted with a key j.cipher doesn't have fails with
+// ErrFileKey, rather than ending the journal there.
+func (j *Journal) scan(fn func(JournalEntry) bool) (offset int64, stale bool, err error) {
+	file, err := os.Open(j.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			// a partial line is a write torn by a crash
+			return offset, stale, nil
+		}
+		if err != nil {
+			return offset, stale, err
+		}
+		plain, err := j.cipher.openLine(line)
+		if errors.Is(err, ErrFileKey) {
+			return offset, stale, fmt.Errorf("reading journal %s: %w", j.path, err)
+		}
+		var e JournalEntry
+		if err == nil {
+			err = json.Unmarshal(plain, &e)
+		}
+		if err != nil {
+			RecordError("Error reading journal:", err)
+			return offset, stale, nil
+		}
+		stale = stale || j.cipher.stale(line)
+		offset += int64(len(line))
+		if !fn(e) {
+			return offset, stale, nil
+		}
+	}
+}
+
+// reseal rewrites the journal file with every entry scan reads written
+// the way j.cipher writes them, via a temp file and rename so a crash
+// leaves either file intact, and returns its new size
+func (j *Journal) reseal() (int64, error) {
+	tmp := j.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(file)
+	var size int64
+	var werr error
+	_, _, err = j.scan(func(e JournalEntry) bool {
+		line, err := json.Marshal(e)
+		if err == nil {
+			var n int
+			n, err = w.Write(append(j.cipher.sealLine(line), '\n'))
+			size += int64(n)
+		}
+		werr = err
+		return err == nil
+	})
+	if err == nil {
+		err = werr
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, j.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return size, syncDir(filepath.Dir(j.path))
+}
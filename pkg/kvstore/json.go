@@ -0,0 +1,189 @@
+package kvstore
+
+import (
+	"bytes"
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// jsonStep is one step of a JSON path: a member of an object, or the
+// element of an array at index, negative counting from the end
+type jsonStep struct {
+	name  string
+	index int
+	elem  bool
+}
+
+// parseJSONPath reads a path such as $.users[0].name or $["a.b"]: the
+// root $, then any of .name, ["name"] and [index]. "" is the root.
+func parseJSONPath(path string) ([]jsonStep, bool) {
+	if path == "" || path == "$" {
+		return nil, true
+	}
+	if !strings.HasPrefix(path, "$") {
+		return nil, false
+	}
+	var steps []jsonStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[") + 1
+			if end == 0 {
+				end = len(rest)
+			}
+			if end == 1 {
+				return nil, false
+			}
+			steps = append(steps, jsonStep{name: rest[1:end]})
+			rest = rest[end:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, false
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonStep{name: inner[1 : len(inner)-1]})
+			} else if i, err := strconv.Atoi(inner); err == nil {
+				steps = append(steps, jsonStep{index: i, elem: true})
+			} else {
+				return nil, false
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, false
+		}
+	}
+	return steps, true
+}
+
+// child returns the value step leads to from v, and for an array the
+// index it resolved to
+func (step jsonStep) child(v any) (any, int, bool) {
+	if !step.elem {
+		obj, ok := v.(map[string]any)
+		if !ok {
+			return nil, 0, false
+		}
+		child, ok := obj[step.name]
+		return child, 0, ok
+	}
+	arr, ok := v.([]any)
+	if !ok {
+		return nil, 0, false
+	}
+	i := step.index
+	if i < 0 {
+		i += len(arr)
+	}
+	if i < 0 || i >= len(arr) {
+		return nil, 0, false
+	}
+	return arr[i], i, true
+}
+
+// decodeJSON parses a document, keeping numbers as written
+func decodeJSON(text string) (any, bool) {
+	d := json.NewDecoder(strings.NewReader(text))
+	d.UseNumber()
+	var v any
+	if d.Decode(&v) != nil || d.More() {
+		return nil, false
+	}
+	return v, true
+}
+
+// encodeJSON writes v compactly, leaving <, > and & as they are
+func encodeJSON(v any) string {
+	var b bytes.Buffer
+	e := json.NewEncoder(&b)
+	e.SetEscapeHTML(false)
+	e.Encode(v)
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+// JSONGET returns, as JSON, the part of the document at key that path
+// selects, the whole of it for the root; found is false if the key is
+// missing or the path selects nothing. A path that doesn't parse fails
+// with protocol.MsgInvalidArgument, and a key that holds another type
+// with protocol.MsgWrongType.
+func (kvs *KeyValueStore) JSONGET(key, path string) (value string, found bool, message string) {
+	steps, ok := parseJSONPath(path)
+	if !ok {
+		return "", false, protocol.MsgInvalidArgument
+	}
+	text, found, message := kvs.readTyped(key, TypeJSON)
+	if !found {
+		return "", false, message
+	}
+	v, ok := decodeJSON(text)
+	if !ok {
+		return "", false, protocol.MsgIntegrity
+	}
+	for _, step := range steps {
+		if v, _, ok = step.child(v); !ok {
+			return "", false, ""
+		}
+	}
+	return encodeJSON(v), true, ""
+}
+
+// JSONSET sets the part of the document at key that path selects to the
+// JSON in value, in one step, so concurrent writers of different fields
+// don't lose each other's changes. The root path replaces the whole
+// document, creating the key with ttl, or the store's default, if it is
+// missing; an existing document keeps its remaining lifetime. Otherwise
+// the path's parent must exist: a member of an object is added or
+// replaced, an element of an array replaced. A missing parent fails with
+// protocol.MsgNoPath, a value that is not JSON with
+// protocol.MsgInvalidJSON. item is the entry written, its Value the whole
+// document.
+func (kvs *KeyValueStore) JSONSET(key, path, value string, ttl time.Duration) (item KeyValue, message string, ok bool) {
+	steps, valid := parseJSONPath(path)
+	if !valid {
+		return KeyValue{}, protocol.MsgInvalidArgument, false
+	}
+	v, valid := decodeJSON(value)
+	if !valid {
+		return KeyValue{}, protocol.MsgInvalidJSON, false
+	}
+	item, _, message, ok = kvs.modifyTyped(key, TypeJSON, ttl, func(text string) (string, bool, string) {
+		if len(steps) == 0 {
+			return encodeJSON(v), true, ""
+		}
+		if text == "" {
+			return "", false, protocol.MsgNoPath
+		}
+		doc, ok := decodeJSON(text)
+		if !ok {
+			return "", false, protocol.MsgIntegrity
+		}
+		parent := doc
+		for _, step := range steps[:len(steps)-1] {
+			if parent, _, ok = step.child(parent); !ok {
+				return "", false, protocol.MsgNoPath
+			}
+		}
+		last := steps[len(steps)-1]
+		if !last.elem {
+			obj, ok := parent.(map[string]any)
+			if !ok {
+				return "", false, protocol.MsgNoPath
+			}
+			obj[last.name] = v
+		} else {
+			_, i, ok := last.child(parent)
+			if !ok {
+				return "", false, protocol.MsgNoPath
+			}
+			parent.([]any)[i] = v
+		}
+		return encodeJSON(doc), true, ""
+	})
+	return item, message, ok
+}
@@ -0,0 +1,426 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultKafkaTimeout bounds each request of a KafkaSink unless its
+// Timeout says otherwise
+const DefaultKafkaTimeout = 10 * time.Second
+
+// Kafka API keys and the versions of them a KafkaSink speaks
+const (
+	kafkaProduce        = 0
+	kafkaMetadata       = 3
+	kafkaProduceVersion = 3
+	kafkaMetaVersion    = 1
+)
+
+// kafkaRetriable are the Kafka error codes that a metadata refresh and a
+// resend can get past: unknown topic or partition, leader not available,
+// not leader, request timed out and not enough replicas
+var kafkaRetriable = map[int16]bool{3: true, 5: true, 6: true, 7: true, 19: true, 20: true}
+
+// KafkaSink is a ChangeSink producing each event to Topic, as the JSON
+// MarshalChange returns, keyed by the key it changed: a compacted topic
+// keeps each key's latest change, and a key's changes stay in order in
+// its partition, picked by murmur2 hash of the key as Kafka's Java client
+// picks it. It speaks the Kafka protocol itself, Metadata v1 and Produce
+// v3 with acks from all in-sync replicas, in plaintext without SASL.
+type KafkaSink struct {
+	Brokers  []string
+	Topic    string
+	ClientID string
+	Timeout  time.Duration
+
+	mu          sync.Mutex
+	leaders     []string // broker address of each partition, nil until loaded
+	conns       map[string]net.Conn
+	correlation int32
+}
+
+// NewKafkaSink returns a KafkaSink for topic, finding its partitions from
+// any of brokers.
+func NewKafkaSink(brokers []string, topic string) *KafkaSink {
+	return &KafkaSink{Brokers: brokers, Topic: topic, ClientID: "kvs-server", Timeout: DefaultKafkaTimeout, conns: make(map[string]net.Conn)}
+}
+
+func (k *KafkaSink) String() string {
+	return "kafka://" + strings.Join(k.Brokers, ",") + "/" + k.Topic
+}
+
+// Publish produces events to their partitions, in order per partition. A
+// failure may leave some partitions written, and the retry writes them
+// again.
+func (k *KafkaSink) Publish(ctx context.Context, events []Event) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.leaders == nil {
+		if err := k.loadMetadata(ctx); err != nil {
+			return err
+		}
+	}
+	batches := make(map[int32][]Event)
+	var order []int32
+	for _, e := range events {
+		p := int32(murmur2([]byte(e.Key)) & 0x7fffffff % uint32(len(k.leaders)))
+		if batches[p] == nil {
+			order = append(order, p)
+		}
+		batches[p] = append(batches[p], e)
+	}
+	for _, p := range order {
+		if err := k.produce(ctx, p, batches[p]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close closes the connections to the brokers.
+func (k *KafkaSink) Close() error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	for addr, conn := range k.conns {
+		conn.Close()
+		delete(k.conns, addr)
+	}
+	return nil
+}
+
+// loadMetadata finds the leader of each partition of Topic; caller holds
+// k.mu
+func (k *KafkaSink) loadMetadata(ctx context.Context) error {
+	var w kafkaWriter
+	w.int32(1)
+	w.string(k.Topic)
+	err := errors.New("kafka: no brokers")
+	for _, broker := range k.Brokers {
+		var r *kafkaReader
+		if r, err = k.roundTrip(ctx, broker, kafkaMetadata, kafkaMetaVersion, w.b); err != nil {
+			continue
+		}
+		brokers := make(map[int32]string)
+		for n := r.int32(); n > 0 && r.err == nil; n-- {
+			id, host, port := r.int32(), r.string(), r.int32()
+			r.string() // rack
+			brokers[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+		}
+		r.int32() // controller
+		for n := r.int32(); n > 0 && r.err == nil; n-- {
+			code, name := r.int16(), r.string()
+			r.int8() // internal
+			partitions := r.int32()
+			if name != k.Topic || r.err != nil {
+				return fmt.Errorf("kafka: metadata for %q: %v", k.Topic, r.err)
+			}
+			if code != 0 {
+				return fmt.Errorf("kafka: topic %q: error code %d", k.Topic, code)
+			}
+			leaders := make([]string, max(partitions, 0))
+			for ; partitions > 0 && r.err == nil; partitions-- {
+				code, index, leader := r.int16(), r.int32(), r.int32()
+				r.int32s() // replicas
+				r.int32s() // in-sync replicas
+				if index < 0 || int(index) >= len(leaders) {
+					return fmt.Errorf("kafka: topic %q: bad partition %d", k.Topic, index)
+				}
+				if code != 0 || brokers[leader] == "" {
+					return fmt.Errorf("kafka: partition %d of %q has no leader", index, k.Topic)
+				}
+				leaders[index] = brokers[leader]
+			}
+			if r.err == nil && len(leaders) > 0 {
+				k.leaders = leaders
+				return nil
+			}
+		}
+		if r.err != nil {
+			return fmt.Errorf("kafka: metadata from %s: %w", broker, r.err)
+		}
+		err = fmt.Errorf("kafka: topic %q not found", k.Topic)
+	}
+	return err
+}
+
+// produce writes events to partition as one record batch; caller holds
+// k.mu
+func (k *KafkaSink) produce(ctx context.Context, partition int32, events []Event) error {
+	batch, err := kafkaBatch(events)
+	if err != nil {
+		return err
+	}
+	timeout := k.timeout()
+	var w kafkaWriter
+	w.int16(-1) // no transaction
+	w.int16(-1) // acks from all in-sync replicas
+	w.int32(int32(timeout / time.Millisecond))
+	w.int32(1)
+	w.string(k.Topic)
+	w.int32(1)
+	w.int32(partition)
+	w.bytes(batch)
+	leader := k.leaders[partition]
+	r, err := k.roundTrip(ctx, leader, kafkaProduce, kafkaProduceVersion, w.b)
+	if err != nil {
+		k.leaders = nil
+		return err
+	}
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.string()
+		for m := r.int32(); m > 0 && r.err == nil; m-- {
+			index, code := r.int32(), r.int16()
+			r.int64() // base offset
+			r.int64() // log append time
+			if code != 0 && r.err == nil {
+				if kafkaRetriable[code] {
+					k.leaders = nil
+				}
+				return fmt.Errorf("kafka: producing to partition %d of %q: error code %d", index, k.Topic, code)
+			}
+		}
+	}
+	if r.err != nil {
+		return fmt.Errorf("kafka: produce response from %s: %w", leader, r.err)
+	}
+	return nil
+}
+
+// kafkaBatch encodes events as a v2 record batch
+func kafkaBatch(events []Event) ([]byte, error) {
+	base := events[0].Time.UnixMilli()
+	last := base
+	var records []byte
+	for i, e := range events {
+		value, err := MarshalChange(e)
+		if err != nil {
+			return nil, err
+		}
+		ts := e.Time.UnixMilli()
+		last = max(last, ts)
+		var rec []byte
+		rec = append(rec, 0) // attributes
+		rec = binary.AppendVarint(rec, ts-base)
+		rec = binary.AppendVarint(rec, int64(i))
+		rec = binary.AppendVarint(rec, int64(len(e.Key)))
+		rec = append(rec, e.Key...)
+		rec = binary.AppendVarint(rec, int64(len(value)))
+		rec = append(rec, value...)
+		rec = binary.AppendVarint(rec, 0) // headers
+		records = binary.AppendVarint(records, int64(len(rec)))
+		records = append(records, rec...)
+	}
+	// from attributes on, what the CRC covers
+	var body kafkaWriter
+	body.int16(0) // attributes: no compression, create time
+	body.int32(int32(len(events) - 1))
+	body.int64(base)
+	body.int64(last)
+	body.int64(-1) // producer ID
+	body.int16(-1) // producer epoch
+	body.int32(-1) // base sequence
+	body.int32(int32(len(events)))
+	body.b = append(body.b, records...)
+
+	var w kafkaWriter
+	w.int64(0)                              // base offset
+	w.int32(int32(4 + 1 + 4 + len(body.b))) // length, from the leader epoch on
+	w.int32(-1)                             // partition leader epoch
+	w.int8(2)                               // magic
+	w.int32(int32(crc32.Checksum(body.b, crc32.MakeTable(crc32.Castagnoli))))
+	w.b = append(w.b, body.b...)
+	return w.b, nil
+}
+
+// timeout is k.Timeout or DefaultKafkaTimeout
+func (k *KafkaSink) timeout() time.Duration {
+	if k.Timeout > 0 {
+		return k.Timeout
+	}
+	return DefaultKafkaTimeout
+}
+
+// roundTrip sends a request to the broker at addr and reads its response,
+// dropping the connection if either fails; caller holds k.mu
+func (k *KafkaSink) roundTrip(ctx context.Context, addr string, api, version int16, body []byte) (*kafkaReader, error) {
+	conn := k.conns[addr]
+	if conn == nil {
+		var err error
+		d := net.Dialer{Timeout: k.timeout()}
+		if conn, err = d.DialContext(ctx, "tcp", addr); err != nil {
+			return nil, err
+		}
+		if k.conns == nil {
+			k.conns = make(map[string]net.Conn)
+		}
+		k.conns[addr] = conn
+	}
+	deadline := time.Now().Add(k.timeout())
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+	k.correlation++
+	var w kafkaWriter
+	w.int32(0) // size, filled in below
+	w.int16(api)
+	w.int16(version)
+	w.int32(k.correlation)
+	w.string(k.ClientID)
+	w.b = append(w.b, body...)
+	binary.BigEndian.PutUint32(w.b, uint32(len(w.b)-4))
+	response, err := k.exchange(conn, w.b)
+	if err != nil {
+		conn.Close()
+		delete(k.conns, addr)
+		return nil, fmt.Errorf("kafka: %s: %w", addr, err)
+	}
+	r := &kafkaReader{b: response}
+	if id := r.int32(); id != k.correlation {
+		conn.Close()
+		delete(k.conns, addr)
+		return nil, fmt.Errorf("kafka: %s: response %d to request %d", addr, id, k.correlation)
+	}
+	return r, nil
+}
+
+// exchange writes request to conn and reads the response
+func (k *KafkaSink) exchange(conn net.Conn, request []byte) ([]byte, error) {
+	if _, err := conn.Write(request); err != nil {
+		return nil, err
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > 64<<20 {
+		return nil, fmt.Errorf("response of %d bytes", n)
+	}
+	response := make([]byte, n)
+	_, err := io.ReadFull(conn, response)
+	return response, err
+}
+
+// murmur2 is the hash Kafka's default partitioner picks partitions by
+func murmur2(data []byte) uint32 {
+	const m, r = 0x5bd1e995, 24
+	h := uint32(0x9747b28c) ^ uint32(len(data))
+	n := len(data) / 4 * 4
+	for i := 0; i < n; i += 4 {
+		k := binary.LittleEndian.Uint32(data[i:])
+		k *= m
+		k ^= k >> r
+		k *= m
+		h *= m
+		h ^= k
+	}
+	switch len(data) % 4 {
+	case 3:
+		h ^= uint32(data[n+2]) << 16
+		fallthrough
+	case 2:
+		h ^= uint32(data[n+1]) << 8
+		fallthrough
+	case 1:
+		h ^= uint32(data[n])
+		h *= m
+	}
+	h ^= h >> 13
+	h *= m
+	h ^= h >> 15
+	return h
+}
+
+// kafkaWriter encodes the big-endian fields of a Kafka request
+type kafkaWriter struct {
+	b []byte
+}
+
+func (w *kafkaWriter) int8(v int8)   { w.b = append(w.b, byte(v)) }
+func (w *kafkaWriter) int16(v int16) { w.b = binary.BigEndian.AppendUint16(w.b, uint16(v)) }
+func (w *kafkaWriter) int32(v int32) { w.b = binary.BigEndian.AppendUint32(w.b, uint32(v)) }
+func (w *kafkaWriter) int64(v int64) { w.b = binary.BigEndian.AppendUint64(w.b, uint64(v)) }
+
+func (w *kafkaWriter) string(s string) {
+	w.int16(int16(len(s)))
+	w.b = append(w.b, s...)
+}
+
+func (w *kafkaWriter) bytes(b []byte) {
+	w.int32(int32(len(b)))
+	w.b = append(w.b, b...)
+}
+
+// kafkaReader decodes a Kafka response; the first field past its end sets
+// err and reads as zero, as does every one after
+type kafkaReader struct {
+	b   []byte
+	err error
+}
+
+func (r *kafkaReader) next(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || len(r.b) < n {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	b := r.b[:n]
+	r.b = r.b[n:]
+	return b
+}
+
+func (r *kafkaReader) int8() int8 {
+	if b := r.next(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (r *kafkaReader) int16() int16 {
+	if b := r.next(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int32() int32 {
+	if b := r.next(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (r *kafkaReader) int64() int64 {
+	if b := r.next(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+// string reads a nullable string, null as ""
+func (r *kafkaReader) string() string {
+	n := r.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(r.next(int(n)))
+}
+
+func (r *kafkaReader) int32s() {
+	for n := r.int32(); n > 0 && r.err == nil; n-- {
+		r.int32()
+	}
+}
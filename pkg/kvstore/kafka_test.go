@@ -0,0 +1,284 @@
+package kvstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeKafka is a broker leading every partition of its one topic. It
+// answers Metadata and Produce, keeping the records produced.
+type fakeKafka struct {
+	t          *testing.T
+	ln         net.Listener
+	topic      string
+	partitions int32
+
+	mu       sync.Mutex
+	metadata int           // Metadata requests answered
+	fail     int16         // error code for the next Produce, 0 for none
+	records  []kafkaRecord // produced, in order
+}
+
+type kafkaRecord struct {
+	partition  int32
+	key, value string
+}
+
+func newFakeKafka(t *testing.T, topic string, partitions int32) *fakeKafka {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := &fakeKafka{t: t, ln: ln, topic: topic, partitions: partitions}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go f.serve(conn)
+		}
+	}()
+	return f
+}
+
+func (f *fakeKafka) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		request := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, request); err != nil {
+			return
+		}
+		r := &kafkaReader{b: request}
+		api, _, correlation := r.int16(), r.int16(), r.int32()
+		r.string() // client ID
+		var w kafkaWriter
+		w.int32(0) // size
+		w.int32(correlation)
+		switch api {
+		case kafkaMetadata:
+			f.answerMetadata(&w)
+		case kafkaProduce:
+			f.answerProduce(r, &w)
+		default:
+			f.t.Errorf("fake kafka got API %d", api)
+			return
+		}
+		binary.BigEndian.PutUint32(w.b, uint32(len(w.b)-4))
+		if _, err := conn.Write(w.b); err != nil {
+			return
+		}
+	}
+}
+
+func (f *fakeKafka) answerMetadata(w *kafkaWriter) {
+	f.mu.Lock()
+	f.metadata++
+	f.mu.Unlock()
+	host, port, _ := net.SplitHostPort(f.ln.Addr().String())
+	n, _ := strconv.Atoi(port)
+	w.int32(1) // brokers
+	w.int32(1)
+	w.string(host)
+	w.int32(int32(n))
+	w.int16(-1) // rack
+	w.int32(1)  // controller
+	w.int32(1)  // topics
+	w.int16(0)
+	w.string(f.topic)
+	w.int8(0)
+	w.int32(f.partitions)
+	for p := int32(0); p < f.partitions; p++ {
+		w.int16(0)
+		w.int32(p)
+		w.int32(1) // leader
+		w.int32(1) // replicas
+		w.int32(1)
+		w.int32(1) // in-sync replicas
+		w.int32(1)
+	}
+}
+
+func (f *fakeKafka) answerProduce(r *kafkaReader, w *kafkaWriter) {
+	r.string() // transactional ID
+	if acks := r.int16(); acks != -1 {
+		f.t.Errorf("produced with acks %d, want all", acks)
+	}
+	r.int32() // timeout
+	r.int32() // topics
+	topic := r.string()
+	r.int32() // partitions
+	partition := r.int32()
+	batch := r.next(int(r.int32()))
+	f.mu.Lock()
+	code := f.fail
+	f.fail = 0
+	if code == 0 {
+		f.records = append(f.records, f.decodeBatch(partition, batch)...)
+	}
+	f.mu.Unlock()
+	w.int32(1)
+	w.string(topic)
+	w.int32(1)
+	w.int32(partition)
+	w.int16(code)
+	w.int64(0)  // base offset
+	w.int64(-1) // log append time
+	w.int32(0)  // throttle time
+}
+
+// decodeBatch reads the records of a v2 record batch, checking its CRC
+func (f *fakeKafka) decodeBatch(partition int32, batch []byte) []kafkaRecord {
+	r := &kafkaReader{b: batch}
+	r.int64() // base offset
+	r.int32() // length
+	r.int32() // partition leader epoch
+	if magic := r.int8(); magic != 2 {
+		f.t.Errorf("record batch magic %d, want 2", magic)
+	}
+	crc := uint32(r.int32())
+	if got := crc32.Checksum(r.b, crc32.MakeTable(crc32.Castagnoli)); got != crc {
+		f.t.Errorf("record batch CRC %08x, want %08x", crc, got)
+	}
+	r.next(2 + 4 + 8 + 8 + 8 + 2 + 4) // attributes to base sequence
+	count := r.int32()
+	varint := func() int64 {
+		v, n := binary.Varint(r.b)
+		r.next(n)
+		return v
+	}
+	var records []kafkaRecord
+	for i := int32(0); i < count && r.err == nil; i++ {
+		varint() // length
+		r.int8() // attributes
+		varint() // timestamp delta
+		varint() // offset delta
+		key := string(r.next(int(varint())))
+		value := string(r.next(int(varint())))
+		varint() // headers
+		records = append(records, kafkaRecord{partition, key, value})
+	}
+	if r.err != nil {
+		f.t.Errorf("record batch: %v", r.err)
+	}
+	return records
+}
+
+func TestMurmur2MatchesKafka(t *testing.T) {
+	// from the tests of Kafka's Java client
+	for in, want := range map[string]int32{
+		"21":                         -973932308,
+		"foobar":                     -790332482,
+		"a-little-bit-long-string":   -985981536,
+		"a-little-bit-longer-string": -1486304829,
+		"lkjh234lh9fiuh90y23oiuhsafujhadof229phr9h19h89h8": -58897971,
+		"abc": 479470107,
+	} {
+		if got := int32(murmur2([]byte(in))); got != want {
+			t.Errorf("murmur2(%q) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestKafkaSinkProducesByKey(t *testing.T) {
+	broker := newFakeKafka(t, "changes", 3)
+	sink := NewKafkaSink([]string{broker.ln.Addr().String()}, "changes")
+	defer sink.Close()
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	var events []Event
+	for i := 0; i < 20; i++ {
+		key := "key/" + strconv.Itoa(i%5)
+		events = append(events, Event{Type: EventSet, Key: key, Value: strconv.Itoa(i), Time: now.Add(time.Duration(i) * time.Millisecond)})
+	}
+	events = append(events, Event{Type: EventDelete, Key: "key/0", Time: now.Add(time.Second)})
+	if err := sink.Publish(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if len(broker.records) != len(events) {
+		t.Fatalf("%d records produced, want %d", len(broker.records), len(events))
+	}
+	// each key's changes are in one partition, in the order made
+	last := make(map[string]int)
+	for _, record := range broker.records {
+		if want := int32(murmur2([]byte(record.key)) & 0x7fffffff % 3); record.partition != want {
+			t.Errorf("%s produced to partition %d, want %d", record.key, record.partition, want)
+		}
+		var change changeEvent
+		if err := json.Unmarshal([]byte(record.value), &change); err != nil {
+			t.Fatal(err)
+		}
+		if change.Key != record.key {
+			t.Errorf("record keyed %s carries a change of %s", record.key, change.Key)
+		}
+		at := int(change.Time.Sub(now) / time.Millisecond)
+		if prev, seen := last[record.key]; seen && at <= prev {
+			t.Errorf("changes of %s out of order", record.key)
+		}
+		last[record.key] = at
+	}
+	// the delete is the last of key/0's changes
+	var final string
+	for _, record := range broker.records {
+		if record.key == "key/0" {
+			final = record.value
+		}
+	}
+	if want := `{"type":"delete","key":"key/0","time":"2024-01-01T00:00:01Z"}`; final != want {
+		t.Errorf("last change of key/0 = %s, want %s", final, want)
+	}
+}
+
+func TestKafkaSinkReloadsLeadersAfterARetriableError(t *testing.T) {
+	broker := newFakeKafka(t, "changes", 1)
+	sink := NewKafkaSink([]string{broker.ln.Addr().String()}, "changes")
+	defer sink.Close()
+	events := []Event{{Type: EventSet, Key: "k", Value: "v", Time: time.Now()}}
+	broker.mu.Lock()
+	broker.fail = 6 // not leader for partition
+	broker.mu.Unlock()
+	if err := sink.Publish(context.Background(), events); err == nil {
+		t.Fatal("Publish answered NOT_LEADER_FOR_PARTITION succeeded")
+	}
+	if err := sink.Publish(context.Background(), events); err != nil {
+		t.Fatal(err)
+	}
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if broker.metadata != 2 {
+		t.Errorf("%d metadata requests, want one more after the error", broker.metadata)
+	}
+	if len(broker.records) != 1 {
+		t.Errorf("%d records produced, want the retried one", len(broker.records))
+	}
+}
+
+func TestParseChangeSink(t *testing.T) {
+	sink, err := ParseChangeSink("kafka://a:9092,b:9092/changes")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if k, ok := sink.(*KafkaSink); !ok || len(k.Brokers) != 2 || k.Topic != "changes" {
+		t.Errorf("ParseChangeSink = %#v", sink)
+	}
+	for _, bad := range []string{"kafka://a:9092", "kafka:///changes", "s3://bucket"} {
+		if _, err := ParseChangeSink(bad); err == nil {
+			t.Errorf("ParseChangeSink(%q) succeeded", bad)
+		}
+	}
+}
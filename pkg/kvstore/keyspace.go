@@ -0,0 +1,102 @@
+package kvstore
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// ValueSizeBuckets are the upper bounds, in bytes, of the value-size
+// histogram of KeyspaceStats; values over the last fall in one more
+var ValueSizeBuckets = []int64{16, 64, 256, 1 << 10, 4 << 10, 16 << 10, 64 << 10, 256 << 10, 1 << 20}
+
+// TTLBuckets are the upper bounds of the TTL-remaining histogram of
+// KeyspaceStats; keys living longer fall in one more
+var TTLBuckets = []time.Duration{time.Second, 10 * time.Second, time.Minute, 10 * time.Minute, time.Hour, 24 * time.Hour, 30 * 24 * time.Hour}
+
+// KeyspaceStats describes how the keys of the store are distributed, from
+// a sample of Sampled of its Keys: how many of the sample have a value up
+// to each of ValueSizeBuckets in size, and one more for larger ones, and
+// a TTL left up to each of TTLBuckets, and one more; and how many fall
+// under each prefix, the namespace of a key or else its start up to the
+// first ':' or '/', "" for keys with neither.
+type KeyspaceStats struct {
+	Keys       int
+	Sampled    int
+	ValueSizes []int
+	TTLs       []int
+	Prefixes   []PrefixCount
+}
+
+// Estimate scales n keys of the sample up to the whole store
+func (s KeyspaceStats) Estimate(n int) int {
+	if s.Sampled == 0 {
+		return 0
+	}
+	return int(int64(n) * int64(s.Keys) / int64(s.Sampled))
+}
+
+// PrefixCount is how many keys of a sample start with Prefix
+type PrefixCount struct {
+	Prefix string
+	Keys   int
+}
+
+// KeyspaceStats samples up to samples live keys, or DefaultMemorySamples
+// if samples <= 0, visiting at most four times as many entries, and
+// describes their distribution; the sample is as random as that of
+// EstimateMemory. Prefixes are sorted by keys, most first.
+func (kvs *KeyValueStore) KeyspaceStats(samples int) KeyspaceStats {
+	if samples <= 0 {
+		samples = DefaultMemorySamples
+	}
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	now := kvs.now()
+	stats := KeyspaceStats{
+		Keys:       kvs.data.len(),
+		ValueSizes: make([]int, len(ValueSizeBuckets)+1),
+		TTLs:       make([]int, len(TTLBuckets)+1),
+	}
+	prefixes := make(map[string]int)
+	visited := 0
+	kvs.data.each(func(key string, kv KeyValue) bool {
+		visited++
+		if !kvs.expired(kv, now) {
+			stats.Sampled++
+			size := int64(len(kv.Value))
+			stats.ValueSizes[sort.Search(len(ValueSizeBuckets), func(i int) bool { return size <= ValueSizeBuckets[i] })]++
+			ttl := kv.TTL
+			if ttl <= 0 {
+				ttl = kvs.ttl
+			}
+			left := kv.Timestamp.Add(ttl).Sub(now)
+			stats.TTLs[sort.Search(len(TTLBuckets), func(i int) bool { return left <= TTLBuckets[i] })]++
+			prefixes[kvs.prefixOf(key)]++
+		}
+		return stats.Sampled < samples && visited < 4*samples
+	})
+	for prefix, n := range prefixes {
+		stats.Prefixes = append(stats.Prefixes, PrefixCount{prefix, n})
+	}
+	sort.Slice(stats.Prefixes, func(i, j int) bool {
+		a, b := stats.Prefixes[i], stats.Prefixes[j]
+		if a.Keys != b.Keys {
+			return a.Keys > b.Keys
+		}
+		return a.Prefix < b.Prefix
+	})
+	return stats
+}
+
+// prefixOf returns the namespace of key, or else its start up to the
+// first ':' or '/', "" if it has neither; caller must hold kvs.mu
+func (kvs *KeyValueStore) prefixOf(key string) string {
+	if ns := kvs.namespaces.of(key); ns != nil {
+		return ns.Prefix
+	}
+	if i := strings.IndexAny(key, ":/"); i >= 0 {
+		return key[:i+1]
+	}
+	return ""
+}
@@ -0,0 +1,26 @@
+package kvstore
+
+import (
+	"strconv"
+	"testing"
+)
+
+func TestKeyspaceStatsScalesSample(t *testing.T) {
+	kvs := NewKeyValueStore()
+	for i := 0; i < 100; i++ {
+		kvs.SET("user:"+strconv.Itoa(i), "v")
+	}
+	stats := kvs.KeyspaceStats(10)
+	if stats.Keys != 100 || stats.Sampled != 10 {
+		t.Fatalf("keys %d, sampled %d; want 100 and 10", stats.Keys, stats.Sampled)
+	}
+	if len(stats.Prefixes) != 1 || stats.Prefixes[0].Prefix != "user:" || stats.Prefixes[0].Keys != 10 {
+		t.Fatalf("prefixes %+v, want user: with the 10 sampled", stats.Prefixes)
+	}
+	if got := stats.Estimate(stats.Prefixes[0].Keys); got != 100 {
+		t.Fatalf("estimate %d, want 100", got)
+	}
+	if stats.ValueSizes[0] != 10 {
+		t.Fatalf("value sizes %v, want all 10 in the first bucket", stats.ValueSizes)
+	}
+}
@@ -0,0 +1,63 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Lease locks are keys: LOCK is a SETNX of the key to its owner, and the
+// revision of that write is the fencing token, which grows with every lock
+// granted, so a resource can refuse a holder whose lease lapsed once a
+// later one has written to it. UNLOCK and RENEW act only on the key as
+// that LOCK left it, so a lapsed holder never releases or extends the
+// lock of the one after it.
+
+// UNLOCK deletes key if it is still at revision token, as the LOCK that
+// granted token wrote it; otherwise it fails with protocol.MsgLockNotHeld
+func (kvs *KeyValueStore) UNLOCK(key string, token uint64) (message string, released bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	old, ok := kvs.held(key, token, now)
+	if !ok {
+		return protocol.MsgLockNotHeld, false
+	}
+	kvs.revision++
+	kvs.retire(key, old, kvs.revision)
+	kvs.data.delete(key)
+	kvs.namespaces.remove(key, old)
+	kvs.emit(EventDelete, key, "", now)
+	return protocol.MsgLockReleased, true
+}
+
+// RENEW restarts the lifetime of key, with ttl if it is above zero, if it
+// is still at revision token; otherwise it fails with
+// protocol.MsgLockNotHeld. The key keeps its revision, so the token stays
+// the same.
+func (kvs *KeyValueStore) RENEW(key string, token uint64, ttl time.Duration) (message string, renewed bool) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	item, ok := kvs.held(key, token, now)
+	if !ok {
+		return protocol.MsgLockNotHeld, false
+	}
+	item.Timestamp = now
+	if ttl > 0 {
+		item.TTL = ttl
+	}
+	kvs.data.set(key, item)
+	return protocol.MsgLockRenewed, true
+}
+
+// held returns key if it is live at revision token at now, caller must
+// hold kvs.mu
+func (kvs *KeyValueStore) held(key string, token uint64, now time.Time) (KeyValue, bool) {
+	item, ok := kvs.data.get(key)
+	if !ok || token == 0 || item.Revision != token || item.Type == TypeSequence || kvs.expired(item, now) {
+		return KeyValue{}, false
+	}
+	return item, true
+}
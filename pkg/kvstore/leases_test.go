@@ -0,0 +1,47 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestLeaseFollowsStoreClock(t *testing.T) {
+	kvs, clock := newClockedStore()
+	token, message, ok := kvs.SETNX("lock", "owner", 10*time.Second, 0)
+	if !ok {
+		t.Fatalf("SETNX: %s", message)
+	}
+	clock.Advance(time.Second)
+	if message, renewed := kvs.RENEW("lock", token, 0); !renewed {
+		t.Fatalf("RENEW of a live lease: %s", message)
+	}
+	// renewed at 1s for 10s, so still held at 10.5s
+	clock.Advance(9*time.Second + 500*time.Millisecond)
+	if message, renewed := kvs.RENEW("lock", token, 0); !renewed {
+		t.Fatalf("RENEW within the renewed lease: %s", message)
+	}
+	clock.Advance(11 * time.Second)
+	if message, renewed := kvs.RENEW("lock", token, 0); renewed || message != protocol.MsgLockNotHeld {
+		t.Fatalf("RENEW of a lapsed lease = %s, %v; want %s", message, renewed, protocol.MsgLockNotHeld)
+	}
+	if message, released := kvs.UNLOCK("lock", token); released || message != protocol.MsgLockNotHeld {
+		t.Fatalf("UNLOCK of a lapsed lease = %s, %v; want %s", message, released, protocol.MsgLockNotHeld)
+	}
+}
+
+func TestUnlockReleasesLease(t *testing.T) {
+	kvs, clock := newClockedStore()
+	token, _, _ := kvs.SETNX("lock", "owner", 10*time.Second, 0)
+	clock.Advance(5 * time.Second)
+	if message, released := kvs.UNLOCK("lock", token+1); released {
+		t.Fatalf("UNLOCK with a wrong token released the lock: %s", message)
+	}
+	if message, released := kvs.UNLOCK("lock", token); !released {
+		t.Fatalf("UNLOCK: %s", message)
+	}
+	if _, found := kvs.GET("lock"); found {
+		t.Fatal("lock key left after UNLOCK")
+	}
+}
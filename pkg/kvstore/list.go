@@ -0,0 +1,132 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// LPUSH adds values to the head of the list at key, one after another, so
+// the last ends up first, creating it with ttl, or the store's default, if
+// it is missing or expired; an existing list keeps its remaining lifetime.
+// length is the list's length after. item is the entry written, its Value
+// the whole list as protocol.EncodeList makes it. A key that holds another
+// type fails with protocol.MsgWrongType, and the write is refused over
+// quota, see SETSUM.
+func (kvs *KeyValueStore) LPUSH(key string, values []string, ttl time.Duration) (length int, item KeyValue, message string, ok bool) {
+	return kvs.push(key, values, ttl, true)
+}
+
+// RPUSH adds values to the tail of the list at key, in order, see LPUSH.
+func (kvs *KeyValueStore) RPUSH(key string, values []string, ttl time.Duration) (length int, item KeyValue, message string, ok bool) {
+	return kvs.push(key, values, ttl, false)
+}
+
+// LPOP removes up to count items from the head of the list at key, at
+// least one, and returns them in the order they were removed; none if it
+// is missing. Removing the last item deletes the key, reported by
+// deleted; otherwise item is the entry written, see LPUSH.
+func (kvs *KeyValueStore) LPOP(key string, count int) (items []string, item KeyValue, deleted bool, message string, ok bool) {
+	return kvs.pop(key, count, true)
+}
+
+// RPOP removes up to count items from the tail of the list at key, see
+// LPOP.
+func (kvs *KeyValueStore) RPOP(key string, count int) (items []string, item KeyValue, deleted bool, message string, ok bool) {
+	return kvs.pop(key, count, false)
+}
+
+// LRANGE returns the items of the list at key from index start to stop,
+// both included; negative indexes count from the end, -1 being the last
+// item. found is false if the key is missing. A key that holds another
+// type fails with protocol.MsgWrongType.
+func (kvs *KeyValueStore) LRANGE(key string, start, stop int) (items []string, found bool, message string) {
+	value, found, message := kvs.readTyped(key, TypeList)
+	if !found {
+		return nil, false, message
+	}
+	list, err := protocol.DecodeList(value)
+	if err != nil {
+		return nil, false, protocol.MsgIntegrity
+	}
+	if start < 0 {
+		start = max(len(list)+start, 0)
+	}
+	if stop < 0 {
+		stop += len(list)
+	}
+	stop = min(stop, len(list)-1)
+	if start > stop {
+		return []string{}, true, ""
+	}
+	return list[start : stop+1], true, ""
+}
+
+// Pushed returns a channel closed by the next push to any list, so a
+// reader can wait for items: take it before trying to pop, then wait on it
+// if there was nothing.
+func (kvs *KeyValueStore) Pushed() <-chan struct{} {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if kvs.pushed == nil {
+		kvs.pushed = make(chan struct{})
+	}
+	return kvs.pushed
+}
+
+func (kvs *KeyValueStore) push(key string, values []string, ttl time.Duration, head bool) (length int, item KeyValue, message string, ok bool) {
+	item, _, message, ok = kvs.modifyList(key, ttl, func(list []string) ([]string, bool) {
+		if head {
+			pushed := make([]string, 0, len(values)+len(list))
+			for i := len(values) - 1; i >= 0; i-- {
+				pushed = append(pushed, values[i])
+			}
+			list = append(pushed, list...)
+		} else {
+			list = append(list, values...)
+		}
+		length = len(list)
+		return list, len(values) > 0
+	})
+	if ok && len(values) > 0 {
+		kvs.mu.Lock()
+		if kvs.pushed != nil {
+			close(kvs.pushed)
+			kvs.pushed = nil
+		}
+		kvs.mu.Unlock()
+	}
+	return length, item, message, ok
+}
+
+func (kvs *KeyValueStore) pop(key string, count int, head bool) (items []string, item KeyValue, deleted bool, message string, ok bool) {
+	count = max(count, 1)
+	item, deleted, message, ok = kvs.modifyList(key, 0, func(list []string) ([]string, bool) {
+		n := min(count, len(list))
+		if head {
+			items, list = append([]string(nil), list[:n]...), list[n:]
+		} else {
+			for i := len(list) - 1; i >= len(list)-n; i-- {
+				items = append(items, list[i])
+			}
+			list = list[:len(list)-n]
+		}
+		return list, n > 0
+	})
+	return items, item, deleted, message, ok
+}
+
+// modifyList is modifyTyped for the list at key, decoded for fn
+func (kvs *KeyValueStore) modifyList(key string, ttl time.Duration, fn func(list []string) (updated []string, changed bool)) (item KeyValue, deleted bool, message string, ok bool) {
+	return kvs.modifyTyped(key, TypeList, ttl, func(value string) (string, bool, string) {
+		list, err := protocol.DecodeList(value)
+		if err != nil {
+			return "", false, protocol.MsgIntegrity
+		}
+		list, changed := fn(list)
+		if !changed {
+			return "", false, ""
+		}
+		return protocol.EncodeList(list), true, ""
+	})
+}
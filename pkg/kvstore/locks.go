@@ -0,0 +1,178 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// defaults for the advisory key locks
+const (
+	DefaultLockWait  = 5 * time.Second
+	MaxLockWait      = 30 * time.Second
+	DefaultLockLease = 30 * time.Second
+)
+
+// keyLock is the advisory lock state of one key, owners map to lease expiry
+type keyLock struct {
+	writer       string
+	writerExpiry time.Time
+	readers      map[string]time.Time
+}
+
+// LockManager hands out advisory read/write locks on keys. Locks are leases
+// that expire on their own and waiters give up after a timeout, so a client
+// that dies or two clients locking in opposite order can never hang forever.
+type LockManager struct {
+	mu       sync.Mutex
+	locks    map[string]*keyLock
+	released chan struct{}
+}
+
+func NewLockManager() *LockManager {
+	return &LockManager{
+		locks:    make(map[string]*keyLock),
+		released: make(chan struct{}),
+	}
+}
+
+// Acquire waits up to wait for a read or write lock on key and holds it for
+// lease; it gives up early when ctx is done
+func (lm *LockManager) Acquire(ctx context.Context, key, owner string, write bool, wait, lease time.Duration) bool {
+	if wait <= 0 {
+		wait = DefaultLockWait
+	}
+	if wait > MaxLockWait {
+		wait = MaxLockWait
+	}
+	if lease <= 0 {
+		lease = DefaultLockLease
+	}
+	deadline := time.Now().Add(wait)
+	for {
+		lm.mu.Lock()
+		now := time.Now()
+		kl := lm.expire(key, now)
+		if kl.grantable(owner, write) {
+			if write {
+				delete(kl.readers, owner)
+				kl.writer = owner
+				kl.writerExpiry = now.Add(lease)
+			} else {
+				kl.readers[owner] = now.Add(lease)
+			}
+			lm.mu.Unlock()
+			return true
+		}
+		// wake up on a release, on the next lease expiry or at the deadline
+		next := deadline
+		if kl.writer != "" && kl.writerExpiry.Before(next) {
+			next = kl.writerExpiry
+		}
+		for _, expiry := range kl.readers {
+			if expiry.Before(next) {
+				next = expiry
+			}
+		}
+		released := lm.released
+		lm.mu.Unlock()
+
+		if !now.Before(deadline) {
+			return false
+		}
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-released:
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return false
+		}
+		timer.Stop()
+	}
+}
+
+// Release drops every lock owner holds on key
+func (lm *LockManager) Release(key, owner string) bool {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	kl := lm.expire(key, time.Now())
+	held := false
+	if kl.writer == owner {
+		kl.writer = ""
+		held = true
+	}
+	if _, ok := kl.readers[owner]; ok {
+		delete(kl.readers, owner)
+		held = true
+	}
+	if kl.writer == "" && len(kl.readers) == 0 {
+		delete(lm.locks, key)
+	}
+	if held {
+		close(lm.released)
+		lm.released = make(chan struct{})
+	}
+	return held
+}
+
+// List describes every live lock, one line per holder
+func (lm *LockManager) List() []string {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	now := time.Now()
+	var lines []string
+	for key := range lm.locks {
+		kl := lm.expire(key, now)
+		if kl.writer != "" {
+			lines = append(lines, fmt.Sprintf("%s mode=write owner=%s expires_in=%s", key, kl.writer, kl.writerExpiry.Sub(now).Round(time.Millisecond)))
+		}
+		for owner, expiry := range kl.readers {
+			lines = append(lines, fmt.Sprintf("%s mode=read owner=%s expires_in=%s", key, owner, expiry.Sub(now).Round(time.Millisecond)))
+		}
+		if kl.writer == "" && len(kl.readers) == 0 {
+			delete(lm.locks, key)
+		}
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+// expire drops lapsed leases on key and returns its (possibly new) lock state,
+// caller must hold lm.mu
+func (lm *LockManager) expire(key string, now time.Time) *keyLock {
+	kl, ok := lm.locks[key]
+	if !ok {
+		kl = &keyLock{readers: make(map[string]time.Time)}
+		lm.locks[key] = kl
+		return kl
+	}
+	if kl.writer != "" && !now.Before(kl.writerExpiry) {
+		kl.writer = ""
+	}
+	for owner, expiry := range kl.readers {
+		if !now.Before(expiry) {
+			delete(kl.readers, owner)
+		}
+	}
+	return kl
+}
+
+// grantable reports whether owner may take the lock now; an owner may
+// re-take its own lock or upgrade when it is the only reader
+func (kl *keyLock) grantable(owner string, write bool) bool {
+	if kl.writer != "" && kl.writer != owner {
+		return false
+	}
+	if !write {
+		return true
+	}
+	for reader := range kl.readers {
+		if reader != owner {
+			return false
+		}
+	}
+	return true
+}
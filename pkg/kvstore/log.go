@@ -0,0 +1,60 @@
+package kvstore
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// LogLevel is how much the store and server print
+type LogLevel int32
+
+// log levels, from the most verbose
+const (
+	LogDebug LogLevel = iota
+	LogInfo
+	LogWarn
+	LogError
+)
+
+var logLevelNames = []string{"debug", "info", "warn", "error"}
+
+func (l LogLevel) String() string {
+	if l < LogDebug || l > LogError {
+		return fmt.Sprintf("LogLevel(%d)", int32(l))
+	}
+	return logLevelNames[l]
+}
+
+// ParseLogLevel parses a level name as printed by String
+func ParseLogLevel(name string) (LogLevel, error) {
+	for i, n := range logLevelNames {
+		if n == name {
+			return LogLevel(i), nil
+		}
+	}
+	return LogInfo, fmt.Errorf("unknown log level %q, expected debug, info, warn or error", name)
+}
+
+var logLevel atomic.Int32
+
+func init() {
+	logLevel.Store(int32(LogInfo))
+}
+
+// SetLogLevel changes the level at runtime; messages below it are dropped
+func SetLogLevel(l LogLevel) {
+	logLevel.Store(int32(l))
+}
+
+// CurrentLogLevel returns the level set by SetLogLevel, LogInfo by default
+func CurrentLogLevel() LogLevel {
+	return LogLevel(logLevel.Load())
+}
+
+// Logf prints a message if level is at or above the current log level
+func Logf(level LogLevel, format string, args ...any) {
+	if level < CurrentLogLevel() {
+		return
+	}
+	fmt.Printf(format+"\n", args...)
+}
@@ -0,0 +1,228 @@
+package kvstore
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sync/atomic"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// MaxMemoryPolicy says what the store does about a write that would take
+// it over the limit of SetMaxMemory
+type MaxMemoryPolicy int
+
+const (
+	// MaxMemoryReject refuses the write with protocol.MsgOutOfMemory
+	MaxMemoryReject MaxMemoryPolicy = iota
+	// MaxMemoryLRU evicts the least recently read or written keys
+	MaxMemoryLRU
+	// MaxMemoryTTL evicts the keys closest to expiry
+	MaxMemoryTTL
+)
+
+// ParseMaxMemoryPolicy parses "reject", "lru" or "ttl", the names printed
+// by String
+func ParseMaxMemoryPolicy(name string) (MaxMemoryPolicy, error) {
+	switch name {
+	case "reject":
+		return MaxMemoryReject, nil
+	case "lru":
+		return MaxMemoryLRU, nil
+	case "ttl":
+		return MaxMemoryTTL, nil
+	}
+	return MaxMemoryReject, fmt.Errorf("unknown maxmemory policy %q, expected reject, lru or ttl", name)
+}
+
+func (p MaxMemoryPolicy) String() string {
+	switch p {
+	case MaxMemoryLRU:
+		return "lru"
+	case MaxMemoryTTL:
+		return "ttl"
+	}
+	return "reject"
+}
+
+// memorySamples is how many keys an eviction compares to pick the one to
+// go, as Redis approximates LRU
+const memorySamples = 5
+
+// memoryLimit is the limit of SetMaxMemory; the store's lock guards it,
+// bar lru
+type memoryLimit struct {
+	max      int64
+	policy   MaxMemoryPolicy
+	lru      atomic.Bool // policy is MaxMemoryLRU, for readers without the lock
+	evicted  uint64
+	rejected uint64
+}
+
+// MemoryUsage is the store's memory, as SetMaxMemory counts it, against
+// its limit; Evicted and Rejected count the keys evicted and the writes
+// refused to stay under it
+type MemoryUsage struct {
+	Used     int64
+	Max      int64
+	Policy   MaxMemoryPolicy
+	Evicted  uint64
+	Rejected uint64
+}
+
+// SetMaxMemory keeps the store to about max bytes, 0 for no limit, so the
+// process can't grow until the OOM killer takes it. The bytes counted are
+// those of keys and values, with the engine's overhead per entry, as
+// EstimateMemory counts them; the read cache and other bookkeeping come on
+// top. A write that would take the store over max is refused with
+// protocol.MsgOutOfMemory under MaxMemoryReject; under MaxMemoryLRU or
+// MaxMemoryTTL other keys are evicted first, with EventEvict, and the write
+// is refused only if no key can be. Pinned keys are never evicted. Writes
+// that don't grow the store always go through. A store already over a new
+// max evicts at once.
+func (kvs *KeyValueStore) SetMaxMemory(max int64, policy MaxMemoryPolicy) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.memory.max, kvs.memory.policy = max, policy
+	kvs.memory.lru.Store(policy == MaxMemoryLRU)
+	if policy != MaxMemoryLRU {
+		kvs.namespaces.access = nil
+	} else if kvs.namespaces.access == nil {
+		// keys already stored count as used now
+		kvs.namespaces.access = make(map[string]*atomic.Int64)
+		kvs.namespaces.recount(kvs.data)
+	}
+	kvs.makeRoom(0, nil)
+}
+
+// MemoryUsage returns the store's memory against the limit of
+// SetMaxMemory
+func (kvs *KeyValueStore) MemoryUsage() MemoryUsage {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	m := &kvs.memory
+	return MemoryUsage{Used: kvs.usedMemory(), Max: m.max, Policy: m.policy, Evicted: m.evicted, Rejected: m.rejected}
+}
+
+// touch notes that key was read without the store being asked, from the
+// read cache, for MaxMemoryLRU
+func (kvs *KeyValueStore) touch(key string) {
+	if !kvs.memory.lru.Load() {
+		return
+	}
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	kvs.namespaces.touch(key)
+}
+
+// usedMemory is the memory SetMaxMemory counts, caller must hold kvs.mu
+func (kvs *KeyValueStore) usedMemory() int64 {
+	return kvs.namespaces.bytes + int64(kvs.data.len())*kvs.data.overhead()
+}
+
+// admit returns why replacing old, if there is one, with item at key is
+// refused: protocol.MsgWrongType for a sequence replaced by anything but
+// a sequence, protocol.MsgQuotaExceeded for the limits of its namespace,
+// or protocol.MsgOutOfMemory for the store's, evicting keys other than key
+// and keep to stay under it as its policy allows; "" if it is not.
+// Caller must hold kvs.mu.
+func (kvs *KeyValueStore) admit(key string, old KeyValue, replaced bool, item KeyValue, keep ...string) string {
+	if message := kvs.admitQuota(key, old, replaced, item); message != "" {
+		return message
+	}
+	grow := size(key, item)
+	if replaced {
+		grow -= size(key, old)
+	} else {
+		grow += kvs.data.overhead()
+	}
+	if !kvs.makeRoom(grow, append(keep, key)) {
+		kvs.memory.rejected++
+		return protocol.MsgOutOfMemory
+	}
+	return ""
+}
+
+// admitQuota is admit without the store's memory limit; caller must hold
+// kvs.mu
+func (kvs *KeyValueStore) admitQuota(key string, old KeyValue, replaced bool, item KeyValue) string {
+	if replaced && old.Type == TypeSequence && item.Type != TypeSequence {
+		return protocol.MsgWrongType
+	}
+	if !kvs.namespaces.admit(key, old, replaced, item) {
+		return protocol.MsgQuotaExceeded
+	}
+	return ""
+}
+
+// makeRoom evicts keys other than keep until grow more bytes fit under the
+// limit, and reports whether they do; caller must hold kvs.mu
+func (kvs *KeyValueStore) makeRoom(grow int64, keep []string) bool {
+	m := &kvs.memory
+	if m.max <= 0 || grow < 0 {
+		return true
+	}
+	for kvs.usedMemory()+grow > m.max {
+		if m.policy == MaxMemoryReject {
+			return false
+		}
+		key, ok := kvs.victim(keep)
+		if !ok {
+			return false
+		}
+		kvs.evict(key)
+	}
+	return true
+}
+
+// victim picks the key to evict under the store's policy, comparing up to
+// memorySamples unpinned keys not in keep, and never a sequence, from a
+// random scan bucket on; ok is false if there is none. Caller must hold
+// kvs.mu.
+func (kvs *KeyValueStore) victim(keep []string) (key string, ok bool) {
+	var best int64
+	sampled := 0
+	start := rand.Intn(ScanBuckets)
+	for i := 0; i < ScanBuckets && sampled < memorySamples; i++ {
+		kvs.data.eachExpiryIn((start+i)%ScanBuckets, func(k string, kv KeyValue) bool {
+			if kv.Type == TypeSequence || slices.Contains(keep, k) || kvs.Pinned(k) {
+				return true
+			}
+			// the lower goes first
+			var score int64
+			if kvs.memory.policy == MaxMemoryLRU {
+				if a := kvs.namespaces.access[k]; a != nil {
+					score = a.Load()
+				}
+			} else {
+				ttl := kv.TTL
+				if ttl <= 0 {
+					ttl = kvs.ttl
+				}
+				score = kv.Timestamp.Add(ttl).UnixNano()
+			}
+			if !ok || score < best {
+				key, best, ok = k, score, true
+			}
+			sampled++
+			return sampled < memorySamples
+		})
+	}
+	return key, ok
+}
+
+// evict deletes key to make room, caller must hold kvs.mu
+func (kvs *KeyValueStore) evict(key string) {
+	old, ok := kvs.data.get(key)
+	if !ok {
+		return
+	}
+	kvs.revision++
+	kvs.retire(key, old, kvs.revision)
+	kvs.data.delete(key)
+	kvs.namespaces.remove(key, old)
+	kvs.emit(EventEvict, key, "", kvs.now())
+	kvs.memory.evicted++
+	Logf(LogDebug, "Evicted key '%s' to stay under maxmemory", key)
+}
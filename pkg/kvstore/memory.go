@@ -0,0 +1,105 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+)
+
+// DefaultMemorySamples is how many keys outside any namespace
+// EstimateMemory looks at unless told otherwise
+const DefaultMemorySamples = 1000
+
+// MemoryEstimate is roughly how much memory the keys of one namespace
+// take up. Prefix is "" for the keys in no namespace. Sampled is how many
+// entries the estimate was scaled up from; namespaces are counted exactly
+// and have none.
+type MemoryEstimate struct {
+	Prefix        string
+	Keys          int
+	KeyBytes      int64
+	ValueBytes    int64
+	OverheadBytes int64
+	Sampled       int
+}
+
+// Total is the estimated memory of all the keys
+func (m MemoryEstimate) Total() int64 {
+	return m.KeyBytes + m.ValueBytes + m.OverheadBytes
+}
+
+// String describes m on one line, for ADMIN MEMORY
+func (m MemoryEstimate) String() string {
+	prefix := m.Prefix
+	if prefix == "" {
+		prefix = "(none)"
+	}
+	return fmt.Sprintf("%s keys=%d key_bytes=%d value_bytes=%d overhead_bytes=%d total_bytes=%d sampled=%d",
+		prefix, m.Keys, m.KeyBytes, m.ValueBytes, m.OverheadBytes, m.Total(), m.Sampled)
+}
+
+// EstimateMemory estimates the memory taken by the keys of each namespace,
+// by prefix, followed by the keys in none. Namespaces come from the
+// accounting their quotas use; the rest is scaled up from up to samples
+// of its entries, or DefaultMemorySamples if samples <= 0, visiting at
+// most four times as many entries in all. With the map engine the
+// entries visited are a random choice; the arena engine visits them in
+// no particular order either, but not a random one. The overhead per
+// entry is the engine's, leaving out the LIST index and the read cache.
+func (kvs *KeyValueStore) EstimateMemory(samples int) []MemoryEstimate {
+	if samples <= 0 {
+		samples = DefaultMemorySamples
+	}
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	overhead := kvs.data.overhead()
+	var estimates []MemoryEstimate
+	rest := MemoryEstimate{Keys: kvs.data.len()}
+	for _, ns := range kvs.namespaces.byPrefix {
+		estimates = append(estimates, MemoryEstimate{
+			Prefix:        ns.Prefix,
+			Keys:          ns.keys,
+			KeyBytes:      ns.keyBytes,
+			ValueBytes:    ns.bytes - ns.keyBytes,
+			OverheadBytes: int64(ns.keys) * overhead,
+		})
+		rest.Keys -= ns.keys
+	}
+	sort.Slice(estimates, func(i, j int) bool { return estimates[i].Prefix < estimates[j].Prefix })
+
+	var keyBytes, valueBytes int64
+	visited := 0
+	kvs.data.each(func(key string, kv KeyValue) bool {
+		visited++
+		if kvs.namespaces.of(key) == nil {
+			rest.Sampled++
+			keyBytes += int64(len(key))
+			valueBytes += int64(len(kv.Value))
+		}
+		return rest.Sampled < samples && visited < 4*samples
+	})
+	if rest.Sampled > 0 {
+		rest.KeyBytes = keyBytes * int64(rest.Keys) / int64(rest.Sampled)
+		rest.ValueBytes = valueBytes * int64(rest.Keys) / int64(rest.Sampled)
+	}
+	rest.OverheadBytes = int64(rest.Keys) * overhead
+	return append(estimates, rest)
+}
+
+// MEMORYUSAGE estimates the memory key takes, counted as EstimateMemory
+// and SetMaxMemory count it: Keys is 1, Prefix the namespace key belongs
+// to, "" if none, and the bytes are exact for the key. It returns false if
+// key does not exist or has expired. Unlike a read it does not count as an
+// access of the key.
+func (kvs *KeyValueStore) MEMORYUSAGE(key string) (MemoryEstimate, bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	item, ok := kvs.data.get(key)
+	if !ok || kvs.expired(item, kvs.now()) {
+		return MemoryEstimate{}, false
+	}
+	m := MemoryEstimate{Keys: 1, KeyBytes: int64(len(key)), ValueBytes: int64(len(item.Value)), OverheadBytes: kvs.data.overhead()}
+	if ns := kvs.namespaces.of(key); ns != nil {
+		m.Prefix = ns.Prefix
+	}
+	return m, true
+}
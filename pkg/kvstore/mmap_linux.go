@@ -0,0 +1,27 @@
+//go:build linux
+
+package kvstore
+
+import (
+	"os"
+	"syscall"
+)
+
+// mapSegment maps a new file of size bytes in dir into memory, unlinked so
+// it goes away with the mapping
+func mapSegment(dir string, size int) ([]byte, error) {
+	f, err := os.CreateTemp(dir, "kvs-*.mmap")
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	os.Remove(f.Name())
+	if err := f.Truncate(int64(size)); err != nil {
+		return nil, err
+	}
+	return syscall.Mmap(int(f.Fd()), 0, size, syscall.PROT_READ|syscall.PROT_WRITE, syscall.MAP_SHARED)
+}
+
+func unmapSegment(b []byte) error {
+	return syscall.Munmap(b)
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+
+package kvstore
+
+import "errors"
+
+func mapSegment(dir string, size int) ([]byte, error) {
+	return nil, errors.New("mmap segments are only supported on linux")
+}
+
+func unmapSegment(b []byte) error { return nil }
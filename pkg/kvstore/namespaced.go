@@ -0,0 +1,219 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// namespacedEngine keeps the keys of each namespace that names an engine,
+// see Namespace.Engine, in an engine of its own, and every other key in
+// the store's engine
+type namespacedEngine struct {
+	store  engine
+	routes []engineRoute // longest prefix first
+}
+
+// engineRoute is a namespace and the engine its keys live in. Namespaces
+// without an engine of their own are routed too, to the store's engine,
+// so that their keys are not taken by a namespace with a shorter prefix.
+type engineRoute struct {
+	prefix string
+	name   string // of the engine, "" for the store's
+	engine engine // nil for the store's
+}
+
+// routesFor returns the routes of namespaces list, without their engines,
+// nil if no namespace names an engine
+func routesFor(list []Namespace) []engineRoute {
+	var routes []engineRoute
+	named := false
+	for _, ns := range list {
+		routes = append(routes, engineRoute{prefix: ns.Prefix, name: ns.Engine})
+		named = named || ns.Engine != ""
+	}
+	if !named {
+		return nil
+	}
+	sort.Slice(routes, func(i, j int) bool { return len(routes[i].prefix) > len(routes[j].prefix) })
+	return routes
+}
+
+// sameRoutes reports whether a and b send the same prefixes to the same
+// engines
+func sameRoutes(a, b []engineRoute) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].prefix != b[i].prefix || a[i].name != b[i].name {
+			return false
+		}
+	}
+	return true
+}
+
+// namespacedOf returns the namespaced engine under e, nil if there is none
+func namespacedOf(e engine) *namespacedEngine {
+	n, _ := baseOf(e).(*namespacedEngine)
+	return n
+}
+
+// storeEngineOf returns the store's engine under e, leaving out the
+// namespaces' engines
+func storeEngineOf(e engine) engine {
+	e = baseOf(e)
+	if n, ok := e.(*namespacedEngine); ok {
+		return n.store
+	}
+	return e
+}
+
+// routesOf returns the routes of the namespaced engine under e, nil if
+// there is none
+func routesOf(e engine) []engineRoute {
+	if n := namespacedOf(e); n != nil {
+		return n.routes
+	}
+	return nil
+}
+
+// newNamespacedEngine returns store with an empty engine of its own for
+// each route naming one, or store itself if routes is empty
+func newNamespacedEngine(store engine, routes []engineRoute) (engine, error) {
+	if len(routes) == 0 {
+		return store, nil
+	}
+	n := &namespacedEngine{store: store, routes: make([]engineRoute, len(routes))}
+	for i, r := range routes {
+		n.routes[i] = engineRoute{prefix: r.prefix, name: r.name}
+		if r.name == "" {
+			continue
+		}
+		e, err := newEngine(r.name)
+		if err != nil {
+			n.close()
+			return nil, fmt.Errorf("namespace %q: %v", r.prefix, err)
+		}
+		n.routes[i].engine = e
+	}
+	return n, nil
+}
+
+// reroute moves every entry of kvs into storage sending the keys of
+// namespaces to the engines routes name; caller must hold kvs.mu
+func (kvs *KeyValueStore) reroute(routes []engineRoute) error {
+	threshold := 0
+	if c := compressionOf(kvs.data); c != nil {
+		threshold = c.threshold
+	}
+	like := &namespacedEngine{store: storeEngineOf(kvs.data), routes: routes}
+	return kvs.relayer(like, encryptionOf(kvs.data), threshold)
+}
+
+// of returns the engine key lives in
+func (n *namespacedEngine) of(key string) engine {
+	for _, r := range n.routes {
+		if strings.HasPrefix(key, r.prefix) {
+			if r.engine != nil {
+				return r.engine
+			}
+			break
+		}
+	}
+	return n.store
+}
+
+// engines returns the store's engine and every namespace's
+func (n *namespacedEngine) engines() []engine {
+	engines := []engine{n.store}
+	for _, r := range n.routes {
+		if r.engine != nil {
+			engines = append(engines, r.engine)
+		}
+	}
+	return engines
+}
+
+// close releases the namespaces' engines, but not the store's
+func (n *namespacedEngine) close() {
+	for _, r := range n.routes {
+		if r.engine != nil {
+			releaseStorage(r.engine)
+		}
+	}
+}
+
+// name is the store's engine; the namespaces' engines are named by
+// Namespace.Engine
+func (n *namespacedEngine) name() string { return n.store.name() }
+
+func (n *namespacedEngine) get(key string) (KeyValue, bool) { return n.of(key).get(key) }
+func (n *namespacedEngine) set(key string, kv KeyValue)     { n.of(key).set(key, kv) }
+func (n *namespacedEngine) delete(key string)               { n.of(key).delete(key) }
+
+func (n *namespacedEngine) len() int {
+	total := 0
+	for _, e := range n.engines() {
+		total += e.len()
+	}
+	return total
+}
+
+func (n *namespacedEngine) compact() {
+	for _, e := range n.engines() {
+		e.compact()
+	}
+}
+
+// the overhead of each engine weighted by how many entries it holds
+func (n *namespacedEngine) overhead() int64 {
+	var sum, count int64
+	for _, e := range n.engines() {
+		sum += e.overhead() * int64(e.len())
+		count += int64(e.len())
+	}
+	if count == 0 {
+		return n.store.overhead()
+	}
+	return sum / count
+}
+
+func (n *namespacedEngine) each(fn func(key string, kv KeyValue) bool) {
+	more := true
+	for _, e := range n.engines() {
+		e.each(func(key string, kv KeyValue) bool {
+			more = fn(key, kv)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+func (n *namespacedEngine) eachExpiry(fn func(key string, kv KeyValue) bool) {
+	more := true
+	for _, e := range n.engines() {
+		e.eachExpiry(func(key string, kv KeyValue) bool {
+			more = fn(key, kv)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
+
+func (n *namespacedEngine) eachExpiryIn(bucket int, fn func(key string, kv KeyValue) bool) {
+	more := true
+	for _, e := range n.engines() {
+		e.eachExpiryIn(bucket, func(key string, kv KeyValue) bool {
+			more = fn(key, kv)
+			return more
+		})
+		if !more {
+			return
+		}
+	}
+}
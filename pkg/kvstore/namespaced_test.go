@@ -0,0 +1,86 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNamespaceEngine(t *testing.T) {
+	kvs := NewKeyValueStore()
+	for _, key := range []string{"big/a", "big/hot/b", "other"} {
+		kvs.SET(key, "v:"+key)
+	}
+	list, err := ParseNamespaces("big/=engine=arena,big/hot/=ttl=1h")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.SetNamespaces(list); err != nil {
+		t.Fatal(err)
+	}
+	n := namespacedOf(kvs.data)
+	if n == nil {
+		t.Fatal("no namespaced engine under the store")
+	}
+	if _, ok := n.of("big/a").(*arenaEngine); !ok {
+		t.Errorf("big/a lives in %s, want arena", n.of("big/a").name())
+	}
+	// a key of a nested namespace without an engine stays in the store's
+	for _, key := range []string{"big/hot/b", "other"} {
+		if _, ok := n.of(key).(*mapEngine); !ok {
+			t.Errorf("%s lives in %s, want map", key, n.of(key).name())
+		}
+	}
+	check := func() {
+		t.Helper()
+		for _, key := range []string{"big/a", "big/hot/b", "other"} {
+			if value, found := kvs.GET(key); !found || value != "v:"+key {
+				t.Errorf("GET %s = %q, %v", key, value, found)
+			}
+		}
+		if kvs.Len() != 3 || kvs.Engine() != EngineMap {
+			t.Errorf("len %d engine %s, want 3 map", kvs.Len(), kvs.Engine())
+		}
+	}
+	check()
+	if err := kvs.SetEncryption([]byte("0123456789abcdef")); err != nil {
+		t.Fatal(err)
+	}
+	if namespacedOf(kvs.data) == nil {
+		t.Fatal("SetEncryption dropped the namespaces' engines")
+	}
+	check()
+	if err := kvs.SetNamespaces(nil); err != nil {
+		t.Fatal(err)
+	}
+	if namespacedOf(kvs.data) != nil {
+		t.Fatal("namespaced engine left after its namespaces were removed")
+	}
+	check()
+}
+
+func TestNamespaceEngineExpiry(t *testing.T) {
+	kvs, clock := newClockedStore()
+	if err := kvs.SetNamespaces([]Namespace{{Prefix: "big/", Engine: EngineArena}}); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SETEX("big/a", "v", time.Second)
+	kvs.SETEX("other", "v", time.Minute)
+	clock.Advance(2 * time.Second)
+	if n := ClearExpiredKeysNow(kvs, nil); n != 1 {
+		t.Fatalf("janitor removed %d keys, want 1", n)
+	}
+	if _, found := kvs.GET("big/a"); found || kvs.Len() != 1 {
+		t.Fatalf("expired key still stored, len %d", kvs.Len())
+	}
+}
+
+func TestNamespaceEngineUnknown(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SET("big/a", "v")
+	if err := kvs.SetNamespaces([]Namespace{{Prefix: "big/", Engine: "nope"}}); err == nil {
+		t.Fatal("SetNamespaces took an unknown engine")
+	}
+	if value, found := kvs.GET("big/a"); !found || value != "v" || namespacedOf(kvs.data) != nil {
+		t.Fatalf("store changed by a failed SetNamespaces: %q, %v", value, found)
+	}
+}
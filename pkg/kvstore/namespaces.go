@@ -0,0 +1,261 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Namespace is the set of keys starting with Prefix, e.g. one tenant's
+// "tenant-a/". A key belongs to the namespace with the longest prefix it
+// starts with, if any. Zero limits are unlimited, and a zero TTL leaves
+// keys set without their own TTL to the store's default. Engine, if set,
+// names the storage engine the namespace's keys live in, as
+// NewKeyValueStoreWithEngine does for the store, e.g. EngineArena for a
+// large read-mostly namespace; the rest of the keys stay in the store's.
+type Namespace struct {
+	Prefix   string
+	MaxKeys  int
+	MaxBytes int64 // of keys and values together
+	TTL      time.Duration
+	Engine   string
+}
+
+// NamespaceStats is the usage of one namespace; Rejected counts the
+// writes refused for going over a limit
+type NamespaceStats struct {
+	Namespace
+	Keys     int
+	Bytes    int64
+	Rejected uint64
+}
+
+// String describes s on one line, for ADMIN NAMESPACES
+func (s NamespaceStats) String() string {
+	line := fmt.Sprintf("%s keys=%d/%s bytes=%d/%s ttl=%s rejected=%d", s.Prefix,
+		s.Keys, limit(int64(s.MaxKeys)), s.Bytes, limit(s.MaxBytes), s.TTL, s.Rejected)
+	if s.Engine != "" {
+		line += " engine=" + s.Engine
+	}
+	return line
+}
+
+func limit(n int64) string {
+	if n <= 0 {
+		return "unlimited"
+	}
+	return strconv.FormatInt(n, 10)
+}
+
+// ParseNamespaces parses namespaces separated by commas, each a prefix,
+// "=" and space-separated limits and engine, e.g.
+// "tenant-a/=max-keys=1000 max-bytes=1048576 ttl=1h,tenant-b/=ttl=5m engine=arena"
+func ParseNamespaces(s string) ([]Namespace, error) {
+	var namespaces []Namespace
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		prefix, limits, _ := strings.Cut(item, "=")
+		ns := Namespace{Prefix: prefix}
+		for _, field := range strings.Fields(limits) {
+			name, value, _ := strings.Cut(field, "=")
+			var err error
+			switch name {
+			case "max-keys":
+				ns.MaxKeys, err = strconv.Atoi(value)
+			case "max-bytes":
+				ns.MaxBytes, err = strconv.ParseInt(value, 10, 64)
+			case "ttl":
+				ns.TTL, err = time.ParseDuration(value)
+			case "engine":
+				ns.Engine = value
+			default:
+				err = fmt.Errorf("unknown limit %q, expected max-keys, max-bytes, ttl or engine", name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("namespace %q: %v", prefix, err)
+			}
+		}
+		namespaces = append(namespaces, ns)
+	}
+	return namespaces, nil
+}
+
+// namespaceUsage is a namespace and what its keys take up
+type namespaceUsage struct {
+	Namespace
+	keys     int
+	bytes    int64
+	keyBytes int64 // the part of bytes that is keys
+	rejected uint64
+}
+
+// namespaces accounts the keys of each namespace, and of the whole store
+// for SetMaxMemory; the store's lock guards it
+type namespaces struct {
+	byPrefix map[string]*namespaceUsage
+	prefixes []string // longest first
+	bytes    int64    // of every key, counted as for MaxBytes
+	// access is when each key was last read or written, in Unix
+	// nanoseconds, kept for MaxMemoryLRU only; reads store to the
+	// counters under the store's read lock
+	access map[string]*atomic.Int64
+	filter *keyFilter // the store's, see SetBloomFilter
+}
+
+// size is what an entry counts towards MaxBytes
+func size(key string, kv KeyValue) int64 {
+	return int64(len(key) + len(kv.Value))
+}
+
+// of returns the namespace key belongs to, nil if none
+func (n *namespaces) of(key string) *namespaceUsage {
+	for _, prefix := range n.prefixes {
+		if strings.HasPrefix(key, prefix) {
+			return n.byPrefix[prefix]
+		}
+	}
+	return nil
+}
+
+// admit reports whether replacing old, if there is one, with kv keeps the
+// namespace of key within its limits, counting a refusal
+func (n *namespaces) admit(key string, old KeyValue, replaced bool, kv KeyValue) bool {
+	ns := n.of(key)
+	if ns == nil {
+		return true
+	}
+	keys, bytes := ns.keys, ns.bytes+size(key, kv)
+	if replaced {
+		bytes -= size(key, old)
+	} else {
+		keys++
+	}
+	if (ns.MaxKeys > 0 && keys > ns.MaxKeys) || (ns.MaxBytes > 0 && bytes > ns.MaxBytes) {
+		ns.rejected++
+		return false
+	}
+	return true
+}
+
+// add counts a new entry, remove one that is gone
+func (n *namespaces) add(key string, kv KeyValue) {
+	n.bytes += size(key, kv)
+	n.filter.add(key)
+	if n.access != nil {
+		if a := n.access[key]; a != nil {
+			a.Store(time.Now().UnixNano())
+		} else {
+			a = new(atomic.Int64)
+			a.Store(time.Now().UnixNano())
+			n.access[key] = a
+		}
+	}
+	if ns := n.of(key); ns != nil {
+		ns.keys++
+		ns.bytes += size(key, kv)
+		ns.keyBytes += int64(len(key))
+	}
+}
+
+func (n *namespaces) remove(key string, kv KeyValue) {
+	n.bytes -= size(key, kv)
+	if n.access != nil {
+		delete(n.access, key)
+	}
+	if ns := n.of(key); ns != nil {
+		ns.keys--
+		ns.bytes -= size(key, kv)
+		ns.keyBytes -= int64(len(key))
+	}
+}
+
+// drop is remove for callers that only have the key, such as the janitor
+func (n *namespaces) drop(data engine, key string) {
+	if kv, ok := data.get(key); ok {
+		n.remove(key, kv)
+	}
+}
+
+// touch notes that key was read; caller must hold the store's lock, if
+// only for reading
+func (n *namespaces) touch(key string) {
+	if n.access == nil {
+		return
+	}
+	if a := n.access[key]; a != nil {
+		a.Store(time.Now().UnixNano())
+	}
+}
+
+// recount counts the entries of data from scratch
+func (n *namespaces) recount(data engine) {
+	for _, ns := range n.byPrefix {
+		ns.keys, ns.bytes, ns.keyBytes = 0, 0, 0
+	}
+	n.bytes = 0
+	if n.access != nil {
+		n.access = make(map[string]*atomic.Int64)
+	}
+	data.each(func(key string, kv KeyValue) bool {
+		n.add(key, kv)
+		return true
+	})
+	// drop the keys that are gone
+	n.filter.rebuild(data)
+}
+
+// ttl is the TTL a key set without its own gets, zero for the store's
+func (n *namespaces) ttl(key string) time.Duration {
+	if ns := n.of(key); ns != nil {
+		return ns.TTL
+	}
+	return 0
+}
+
+// SetNamespaces replaces the store's namespaces. Existing keys are counted
+// at once but never removed, even if a namespace is already over its
+// limits; from then on writes that would take one over are refused with
+// protocol.MsgQuotaExceeded. Rejection counts start again from zero.
+// Keys move at once into the engines the namespaces now name.
+func (kvs *KeyValueStore) SetNamespaces(list []Namespace) error {
+	n := namespaces{byPrefix: make(map[string]*namespaceUsage)}
+	for _, ns := range list {
+		if ns.Prefix == "" {
+			return fmt.Errorf("namespace prefix must not be empty")
+		}
+		if n.byPrefix[ns.Prefix] != nil {
+			return fmt.Errorf("namespace %q is defined twice", ns.Prefix)
+		}
+		n.byPrefix[ns.Prefix] = &namespaceUsage{Namespace: ns}
+		n.prefixes = append(n.prefixes, ns.Prefix)
+	}
+	sort.Slice(n.prefixes, func(i, j int) bool { return len(n.prefixes[i]) > len(n.prefixes[j]) })
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if routes := routesFor(list); !sameRoutes(routes, routesOf(kvs.data)) {
+		if err := kvs.reroute(routes); err != nil {
+			return err
+		}
+	}
+	n.access, n.filter = kvs.namespaces.access, kvs.namespaces.filter
+	n.recount(kvs.data)
+	kvs.namespaces = n
+	return nil
+}
+
+// NamespaceStats returns the usage of every namespace, by prefix
+func (kvs *KeyValueStore) NamespaceStats() []NamespaceStats {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	stats := make([]NamespaceStats, 0, len(kvs.namespaces.prefixes))
+	for _, ns := range kvs.namespaces.byPrefix {
+		stats = append(stats, NamespaceStats{Namespace: ns.Namespace, Keys: ns.keys, Bytes: ns.bytes, Rejected: ns.rejected})
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Prefix < stats[j].Prefix })
+	return stats
+}
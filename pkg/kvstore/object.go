@@ -0,0 +1,48 @@
+package kvstore
+
+import "time"
+
+// KeyInfo describes a key without its value, see OBJECT: its type and
+// revision, its size as SetMaxMemory and namespace limits count it, when
+// it was last written and when it expires, with the TTL it had left then,
+// and when it was last read or written, which the store tracks only under
+// MaxMemoryLRU and is zero otherwise.
+type KeyInfo struct {
+	Type       ValueType
+	Revision   uint64
+	Size       int64
+	Written    time.Time
+	Expires    time.Time
+	TTL        time.Duration
+	LastAccess time.Time
+}
+
+// TYPE returns the type of the value at key, and false if key does not
+// exist or has expired
+func (kvs *KeyValueStore) TYPE(key string) (ValueType, bool) {
+	info, found := kvs.OBJECT(key)
+	return info.Type, found
+}
+
+// OBJECT describes key without reading its value out, and returns false if
+// key does not exist or has expired. Unlike a read it does not count as an
+// access of the key.
+func (kvs *KeyValueStore) OBJECT(key string) (info KeyInfo, found bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	now := kvs.now()
+	item, ok := kvs.data.get(key)
+	if !ok || kvs.expired(item, now) {
+		return info, false
+	}
+	ttl := item.TTL
+	if ttl <= 0 {
+		ttl = kvs.ttl
+	}
+	info = KeyInfo{Type: item.Type, Revision: item.Revision, Size: size(key, item), Written: item.Timestamp, Expires: item.Timestamp.Add(ttl)}
+	info.TTL = max(info.Expires.Sub(now), 0)
+	if a := kvs.namespaces.access[key]; a != nil {
+		info.LastAccess = time.Unix(0, a.Load())
+	}
+	return info, true
+}
@@ -0,0 +1,218 @@
+package kvstore
+
+import (
+	"math/rand"
+	"unsafe"
+)
+
+// skipMaxLevel bounds the levels of the skip list, enough for 4^16 keys
+const skipMaxLevel = 16
+
+// skipNode is one key of a skipList, linked to the next key on each of
+// its levels
+type skipNode struct {
+	key  string
+	next []*skipNode
+}
+
+// skipList keeps keys in lexicographic order; each level skips about
+// three in four of the nodes below it
+type skipList struct {
+	head  skipNode
+	level int
+}
+
+func newSkipList() *skipList {
+	return &skipList{head: skipNode{next: make([]*skipNode, skipMaxLevel)}, level: 1}
+}
+
+// path fills prev with the last node before key on every level and
+// returns the first node at or after it
+func (l *skipList) path(key string, prev *[skipMaxLevel]*skipNode) *skipNode {
+	n := &l.head
+	for i := l.level - 1; i >= 0; i-- {
+		for n.next[i] != nil && n.next[i].key < key {
+			n = n.next[i]
+		}
+		if prev != nil {
+			prev[i] = n
+		}
+	}
+	return n.next[0]
+}
+
+func (l *skipList) insert(key string) {
+	var prev [skipMaxLevel]*skipNode
+	if n := l.path(key, &prev); n != nil && n.key == key {
+		return
+	}
+	level := skipLevel()
+	for ; l.level < level; l.level++ {
+		prev[l.level] = &l.head
+	}
+	n := &skipNode{key: key, next: make([]*skipNode, level)}
+	for i := 0; i < level; i++ {
+		n.next[i] = prev[i].next[i]
+		prev[i].next[i] = n
+	}
+}
+
+// skipLevel picks the levels of a new node: one more with odds 1 in 4
+func skipLevel() int {
+	level := 1
+	for level < skipMaxLevel && rand.Intn(4) == 0 {
+		level++
+	}
+	return level
+}
+
+func (l *skipList) remove(key string) {
+	var prev [skipMaxLevel]*skipNode
+	n := l.path(key, &prev)
+	if n == nil || n.key != key {
+		return
+	}
+	for i := range n.next {
+		prev[i].next[i] = n.next[i]
+	}
+	for l.level > 1 && l.head.next[l.level-1] == nil {
+		l.level--
+	}
+}
+
+// seek returns the first node at or after key
+func (l *skipList) seek(key string) *skipNode {
+	return l.path(key, nil)
+}
+
+// orderedEngine is an engine that also keeps its keys sorted, so a range
+// of them is read without scanning every key
+type orderedEngine struct {
+	engine
+	keys *skipList
+}
+
+func newOrderedEngine(inner engine) *orderedEngine {
+	o := &orderedEngine{engine: inner, keys: newSkipList()}
+	inner.each(func(key string, _ KeyValue) bool {
+		o.keys.insert(key)
+		return true
+	})
+	return o
+}
+
+func (o *orderedEngine) set(key string, kv KeyValue) {
+	if _, ok := o.engine.get(key); !ok {
+		o.keys.insert(key)
+	}
+	o.engine.set(key, kv)
+}
+
+func (o *orderedEngine) delete(key string) {
+	if _, ok := o.engine.get(key); ok {
+		o.keys.remove(key)
+	}
+	o.engine.delete(key)
+}
+
+// a node is its key's string header, its slice header and 4/3 next
+// pointers on average
+func (o *orderedEngine) overhead() int64 {
+	return o.engine.overhead() + int64(unsafe.Sizeof(skipNode{})+unsafe.Sizeof(uintptr(0))*4/3)
+}
+
+// withIndexes layers the indexes kvs keeps on base: the ordered index if
+// ordered, the search index if search, and the directory index, always
+// outermost, if sep is set
+func withIndexes(base engine, sep string, ordered, search bool) engine {
+	if ordered {
+		base = newOrderedEngine(base)
+	}
+	if search {
+		base = newSearchEngine(base)
+	}
+	if sep != "" {
+		base = newDirEngine(base, sep)
+	}
+	return base
+}
+
+// unwrap returns the storage engine under the indexes layered on e
+func unwrap(e engine) engine {
+	for {
+		switch w := e.(type) {
+		case *dirEngine:
+			e = w.engine
+		case *orderedEngine:
+			e = w.engine
+		case *searchEngine:
+			e = w.engine
+		default:
+			return e
+		}
+	}
+}
+
+// orderOf returns the ordered index layered on e, nil if there is none
+func orderOf(e engine) *orderedEngine {
+	for {
+		switch w := e.(type) {
+		case *orderedEngine:
+			return w
+		case *dirEngine:
+			e = w.engine
+		case *searchEngine:
+			e = w.engine
+		default:
+			return nil
+		}
+	}
+}
+
+// SetOrdered makes kvs keep its keys sorted for RANGE, or stops it.
+// Building the index visits every key, and afterwards each new or deleted
+// key costs O(log n).
+func (kvs *KeyValueStore) SetOrdered(ordered bool) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if (orderOf(kvs.data) != nil) == ordered {
+		return
+	}
+	sep := ""
+	if d, ok := kvs.data.(*dirEngine); ok {
+		sep = d.sep
+	}
+	kvs.data = withIndexes(unwrap(kvs.data), sep, ordered, searchOf(kvs.data) != nil)
+}
+
+// Ordered reports whether kvs keeps its keys sorted, see SetOrdered
+func (kvs *KeyValueStore) Ordered() bool {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return orderOf(kvs.data) != nil
+}
+
+// RANGE returns, in order, the live keys from start up to but not
+// including end, "" for no end, at most limit of them if limit is above
+// zero. next is the first live key in the range past those, where the
+// next page starts, and "" if there is none. ok is false if kvs doesn't
+// keep its keys sorted.
+func (kvs *KeyValueStore) RANGE(start, end string, limit int) (keys []string, next string, ok bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	o := orderOf(kvs.data)
+	if o == nil {
+		return nil, "", false
+	}
+	now := kvs.now()
+	for n := o.keys.seek(start); n != nil && (end == "" || n.key < end); n = n.next[0] {
+		if item, _ := o.engine.get(n.key); kvs.expired(item, now) {
+			continue
+		}
+		if limit > 0 && len(keys) >= limit {
+			return keys, n.key, true
+		}
+		keys = append(keys, n.key)
+	}
+	return keys, "", true
+}
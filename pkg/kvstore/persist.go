@@ -0,0 +1,206 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// Persister keeps the data of a store across restarts. A server calls
+// Open before it takes writes, runs Run until it stops, and on the way
+// out calls Sync once the writes are done and then Close.
+type Persister interface {
+	// Open loads what was persisted into kvs
+	Open(ctx context.Context, kvs *KeyValueStore) error
+	// Run does the background work, such as periodic snapshots, until
+	// ctx is done
+	Run(ctx context.Context, kvs *KeyValueStore)
+	// Sync flushes what is buffered to disk
+	Sync(kvs *KeyValueStore) error
+	// Close persists what is left and releases the files
+	Close(kvs *KeyValueStore) error
+	// String names the mode, as ParsePersistence takes it
+	String() string
+}
+
+// WALFile is where kvs-server keeps its write-ahead log by default
+const WALFile = "appendonly.wal"
+
+// ErrRestore wraps the error of a SnapshotPersister that could not
+// restore a snapshot
+var ErrRestore = errors.New("restoring backup")
+
+// NoPersistence keeps nothing: a restart starts empty
+type NoPersistence struct{}
+
+func (NoPersistence) Open(ctx context.Context, kvs *KeyValueStore) error { return nil }
+func (NoPersistence) Run(ctx context.Context, kvs *KeyValueStore)        {}
+func (NoPersistence) Sync(kvs *KeyValueStore) error                      { return nil }
+func (NoPersistence) Close(kvs *KeyValueStore) error                     { return nil }
+func (NoPersistence) String() string                                     { return "none" }
+
+// SnapshotPersister restores the latest snapshot on Open, unless
+// SkipRestore, see RestoreLatest, writes one as BackupKeyValueStore
+// schedules them, and a last one on Close. A crash loses the writes since
+// the last snapshot.
+type SnapshotPersister struct {
+	SkipRestore bool
+}
+
+func (p SnapshotPersister) Open(ctx context.Context, kvs *KeyValueStore) error {
+	if p.SkipRestore {
+		return nil
+	}
+	path, stats, err := RestoreLatest(ctx, kvs)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRestore, err)
+	}
+	if path != "" {
+		Logf(LogInfo, "Restored %s: %d keys loaded, %d expired, %d damaged", path, stats.Loaded, stats.Expired, stats.Damaged)
+	}
+	return nil
+}
+
+func (SnapshotPersister) Run(ctx context.Context, kvs *KeyValueStore) {
+	BackupKeyValueStore(ctx, kvs)
+}
+
+func (SnapshotPersister) Sync(kvs *KeyValueStore) error { return nil }
+
+func (SnapshotPersister) Close(kvs *KeyValueStore) error { return WriteBackup(kvs) }
+
+func (SnapshotPersister) String() string { return "snapshot" }
+
+// WALPersister logs every change to the write-ahead log at Path,
+// encrypted with the store's file cipher, and replays it on Open, see
+// OpenWAL. CompactWAL keeps it from growing without bound.
+type WALPersister struct {
+	Path string
+}
+
+func (p WALPersister) Open(ctx context.Context, kvs *KeyValueStore) error {
+	replay, err := kvs.OpenWAL(p.Path, kvs.FileCipher())
+	if err != nil {
+		return err
+	}
+	Logf(LogInfo, "Replayed %d records of WAL %s: %d already in the snapshot, %d damaged, %d bytes dropped", replay.Records, p.Path, replay.Skipped, replay.Damaged, replay.Dropped)
+	return nil
+}
+
+func (WALPersister) Run(ctx context.Context, kvs *KeyValueStore) {
+	CompactWAL(ctx, kvs)
+}
+
+func (WALPersister) Sync(kvs *KeyValueStore) error { return kvs.SyncWAL() }
+
+func (WALPersister) Close(kvs *KeyValueStore) error { return kvs.CloseWAL() }
+
+func (WALPersister) String() string { return "wal" }
+
+// Persisters combines persisters: Open, Sync and Close run them in order,
+// Open stopping at the first that fails, and Run runs them all at once.
+// A snapshot followed by a WAL restores the snapshot and replays the log
+// over it.
+type Persisters []Persister
+
+func (ps Persisters) Open(ctx context.Context, kvs *KeyValueStore) error {
+	for _, p := range ps {
+		if err := p.Open(ctx, kvs); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ps Persisters) Run(ctx context.Context, kvs *KeyValueStore) {
+	var wg sync.WaitGroup
+	for _, p := range ps {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			p.Run(ctx, kvs)
+		}()
+	}
+	wg.Wait()
+}
+
+func (ps Persisters) Sync(kvs *KeyValueStore) error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p.Sync(kvs))
+	}
+	return errors.Join(errs...)
+}
+
+func (ps Persisters) Close(kvs *KeyValueStore) error {
+	var errs []error
+	for _, p := range ps {
+		errs = append(errs, p.Close(kvs))
+	}
+	return errors.Join(errs...)
+}
+
+func (ps Persisters) String() string {
+	names := make([]string, len(ps))
+	for i, p := range ps {
+		names[i] = p.String()
+	}
+	return strings.Join(names, "+")
+}
+
+// MemoryPersister keeps a snapshot in memory instead of on disk, for
+// tests: Close saves the data and Open loads what the last Close saved,
+// so a server restarted over it gets its data back. The zero value holds
+// nothing.
+type MemoryPersister struct {
+	mu       sync.Mutex
+	snapshot *BackupSnapshot
+}
+
+func (p *MemoryPersister) Open(ctx context.Context, kvs *KeyValueStore) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.snapshot == nil {
+		return nil
+	}
+	_, err := kvs.LoadSnapshot(*p.snapshot)
+	return err
+}
+
+func (p *MemoryPersister) Run(ctx context.Context, kvs *KeyValueStore) {}
+
+func (p *MemoryPersister) Sync(kvs *KeyValueStore) error { return nil }
+
+func (p *MemoryPersister) Close(kvs *KeyValueStore) error {
+	snapshot, _ := kvs.Snapshot()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.snapshot = &snapshot
+	return nil
+}
+
+func (p *MemoryPersister) String() string { return "memory" }
+
+// ParsePersistence returns the persister for a mode: "none", "snapshot",
+// "wal" or "snapshot+wal", with the WAL at walPath; restore false skips
+// restoring the snapshot on Open
+func ParsePersistence(mode, walPath string, restore bool) (Persister, error) {
+	snapshot := SnapshotPersister{SkipRestore: !restore}
+	wal := WALPersister{Path: walPath}
+	if strings.HasSuffix(mode, "wal") && walPath == "" {
+		return nil, fmt.Errorf("persistence %q needs a WAL file", mode)
+	}
+	switch mode {
+	case "none":
+		return NoPersistence{}, nil
+	case "snapshot":
+		return snapshot, nil
+	case "wal":
+		return wal, nil
+	case "snapshot+wal":
+		return Persisters{snapshot, wal}, nil
+	}
+	return nil, fmt.Errorf("unknown persistence %q, want none, snapshot, wal or snapshot+wal", mode)
+}
@@ -0,0 +1,90 @@
+package kvstore
+
+import (
+	"path"
+	"sort"
+	"sync"
+)
+
+// pinSet is the keys that must never be evicted, named one by one with PIN
+// or matched by a configured pattern
+type pinSet struct {
+	mu       sync.RWMutex
+	keys     map[string]bool
+	patterns []string
+}
+
+// PIN protects key from eviction by memory limits and cache eviction. TTL
+// expiry still applies. The pin is kept when the key is deleted or expires,
+// so it also covers the key once it is set again. Returns false if key was
+// already pinned.
+func (kvs *KeyValueStore) PIN(key string) bool {
+	kvs.pins.mu.Lock()
+	defer kvs.pins.mu.Unlock()
+	if kvs.pins.keys[key] {
+		return false
+	}
+	kvs.pins.keys[key] = true
+	return true
+}
+
+// UNPIN removes a pin set by PIN, returns false if key was not pinned that
+// way. Keys matching a pin pattern stay pinned.
+func (kvs *KeyValueStore) UNPIN(key string) bool {
+	kvs.pins.mu.Lock()
+	defer kvs.pins.mu.Unlock()
+	if !kvs.pins.keys[key] {
+		return false
+	}
+	delete(kvs.pins.keys, key)
+	return true
+}
+
+// Pinned reports whether key is pinned, by PIN or by a pattern. Anything
+// that evicts keys must skip the ones that are.
+func (kvs *KeyValueStore) Pinned(key string) bool {
+	kvs.pins.mu.RLock()
+	defer kvs.pins.mu.RUnlock()
+	if kvs.pins.keys[key] {
+		return true
+	}
+	for _, pattern := range kvs.pins.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// SetPinPatterns pins every key matching one of patterns, in path.Match
+// syntax such as "config/*". It replaces the previous patterns.
+func (kvs *KeyValueStore) SetPinPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	kvs.pins.mu.Lock()
+	defer kvs.pins.mu.Unlock()
+	kvs.pins.patterns = append([]string(nil), patterns...)
+	return nil
+}
+
+// PinPatterns returns the configured pin patterns
+func (kvs *KeyValueStore) PinPatterns() []string {
+	kvs.pins.mu.RLock()
+	defer kvs.pins.mu.RUnlock()
+	return append([]string(nil), kvs.pins.patterns...)
+}
+
+// PinnedKeys returns the keys pinned with PIN, sorted
+func (kvs *KeyValueStore) PinnedKeys() []string {
+	kvs.pins.mu.RLock()
+	defer kvs.pins.mu.RUnlock()
+	keys := make([]string, 0, len(kvs.pins.keys))
+	for key := range kvs.pins.keys {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
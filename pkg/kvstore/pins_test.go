@@ -0,0 +1,64 @@
+package kvstore
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPinnedKeysSurviveEviction(t *testing.T) {
+	kvs := newFullStore(t, MaxMemoryLRU)
+	kvs.PIN("old/0")
+	if err := kvs.SetPinPatterns([]string{"old/[12]"}); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		kvs.SET("big/"+strings.Repeat("x", i), strings.Repeat("v", 300))
+	}
+	if kvs.MemoryUsage().Evicted == 0 {
+		t.Fatal("nothing was evicted")
+	}
+	for _, key := range []string{"old/0", "old/1", "old/2"} {
+		if _, found := kvs.GET(key); !found {
+			t.Errorf("pinned %s was evicted", key)
+		}
+	}
+	if !kvs.UNPIN("old/0") || kvs.UNPIN("old/1") {
+		t.Error("UNPIN undid a pin it didn't set or missed the one it did")
+	}
+	if !kvs.Pinned("old/1") || kvs.Pinned("old/0") {
+		t.Error("Pinned wrong after UNPIN")
+	}
+}
+
+func TestPinnedKeysStillExpire(t *testing.T) {
+	kvs, clock := newClockedStore()
+	kvs.PIN("k")
+	kvs.SETEX("k", "v", time.Second)
+	clock.Advance(2 * time.Second)
+	if _, found := kvs.OBJECT("k"); found {
+		t.Error("pinned key outlived its TTL")
+	}
+	if n := ClearExpiredKeysNow(kvs, nil); n != 1 {
+		t.Errorf("janitor removed %d keys, want the pinned one", n)
+	}
+}
+
+func TestPinnedKeysStayCached(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SET("pinned", "v")
+	kvs.SET("a", "v")
+	kvs.SET("b", "v")
+	kvs.PIN("pinned")
+	sp := NewServerProxy(kvs)
+	sp.SetCacheSize(1)
+	sp.GET("pinned")
+	sp.GET("a")
+	sp.GET("b")
+	if _, ok := sp.cache.peek("pinned"); !ok {
+		t.Error("pinned key was evicted from the cache")
+	}
+	if _, ok := sp.cache.peek("a"); ok {
+		t.Error("the cache kept a past its size")
+	}
+}
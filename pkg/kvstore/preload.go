@@ -0,0 +1,77 @@
+package kvstore
+
+import (
+	"cmp"
+	"slices"
+	"strings"
+)
+
+// Preload picks the keys ServerProxy.Preload caches: the Recent most
+// recently written keys, and every key starting with one of Prefixes
+type Preload struct {
+	Recent   int
+	Prefixes []string
+}
+
+// preloaded is a key Preload picked and its entry
+type preloaded struct {
+	key  string
+	item KeyValue
+}
+
+// Preload fills the cache with the keys p picks, as the store holds them
+// now, so the first requests after a restart don't all miss; call it once
+// the store is restored. The prefixed keys are cached first and the recent
+// ones from the oldest on, so a cache too small for them all keeps the
+// most recent. The store is read in one pass, under its read lock, and
+// reads through the proxy wait for it. It returns how many keys it cached;
+// those evicted to fit the cache's limits count too.
+func (sp *ServerProxy) Preload(p Preload) int {
+	if p.Recent <= 0 && len(p.Prefixes) == 0 {
+		return 0
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	// take in the restore first, or the next lookup would empty the cache
+	sp.sync()
+	prefixed, recent := sp.kvs.preload(p)
+	for _, pl := range prefixed {
+		sp.store(pl.key, pl.item)
+	}
+	for i := len(recent) - 1; i >= 0; i-- {
+		sp.store(recent[i].key, recent[i].item)
+	}
+	return len(prefixed) + len(recent)
+}
+
+// preload returns the live keys p picks: those with its prefixes, and of
+// the others the p.Recent with the highest revisions, the highest first
+func (kvs *KeyValueStore) preload(p Preload) (prefixed, recent []preloaded) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	newest := func(a, b preloaded) int { return cmp.Compare(b.item.Revision, a.item.Revision) }
+	now := kvs.now()
+	kvs.data.each(func(key string, kv KeyValue) bool {
+		if kvs.expired(kv, now) || !kv.Intact() {
+			return true
+		}
+		if slices.ContainsFunc(p.Prefixes, func(prefix string) bool { return strings.HasPrefix(key, prefix) }) {
+			prefixed = append(prefixed, preloaded{key, kv})
+			return true
+		}
+		if p.Recent > 0 {
+			recent = append(recent, preloaded{key, kv})
+			// keep it to the p.Recent newest now and then, not at every key
+			if len(recent) >= 2*p.Recent {
+				slices.SortFunc(recent, newest)
+				recent = recent[:p.Recent]
+			}
+		}
+		return true
+	})
+	slices.SortFunc(recent, newest)
+	if len(recent) > p.Recent {
+		recent = recent[:p.Recent]
+	}
+	return prefixed, recent
+}
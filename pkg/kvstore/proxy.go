@@ -0,0 +1,686 @@
+package kvstore
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ServerProxy caches values read from a KeyValueStore, evicting the keys
+// its EvictionPolicy picks, the least recently used by default, once the
+// cache is full, see SetCacheSize and SetCacheBytes. What writes do to the
+// cache is up to its CacheStrategy.
+type ServerProxy struct {
+	kvs    *KeyValueStore
+	cache  *proxyCache
+	fills  map[string]*fill
+	gen    uint64
+	mu     sync.RWMutex
+	warmup warmup
+	writes writeBack
+}
+
+// fill is a store read in flight for a cache miss; concurrent misses on the
+// same key wait for it instead of reading the store again
+type fill struct {
+	done chan struct{}
+	item KeyValue
+	ok   bool
+	err  error // the reader gave up; waiters read the store themselves
+}
+
+// create instance of serverproxy
+func NewServerProxy(kvs *KeyValueStore) *ServerProxy {
+	sp := &ServerProxy{
+		kvs:   kvs,
+		cache: newProxyCache(),
+		fills: make(map[string]*fill),
+	}
+	kvs.stale.on.Store(true)
+	return sp
+}
+
+// to get values from cache, reading through to the store on a miss. Only
+// keys that exist are cached, and the store is read without holding the
+// proxy lock so misses don't stall hits on other keys.
+func (sp *ServerProxy) GET(key string) (value string, found bool) {
+	value, _, found = sp.GETSUM(key)
+	return value, found
+}
+
+// GETSUM is GET that also returns the value's checksum, see
+// KeyValueStore.GETSUM. A cached copy that fails its checksum is dropped
+// and the store is read instead.
+func (sp *ServerProxy) GETSUM(key string) (value string, sum uint32, found bool) {
+	item, found, _ := sp.GETKVContext(context.Background(), key)
+	if found && item.Type != TypeString {
+		return protocol.MsgWrongType, 0, false
+	}
+	return item.Value, item.Checksum, found
+}
+
+// GETKVContext is GETSUM returning the whole entry, see
+// KeyValueStore.GETKV, that stops waiting, for the warm-up or another
+// request's read of key, when ctx is done and returns its error
+func (sp *ServerProxy) GETKVContext(ctx context.Context, key string) (item KeyValue, found bool, err error) {
+	if cached, ok := sp.hit(key); ok {
+		sp.kvs.touch(key)
+		Logf(LogDebug, "Value for key '%s' retrieved from cache: %v", key, cached)
+		return cached, true, nil
+	}
+	sp.mu.Lock()
+	sp.sync()
+	if cached, ok := sp.cache.get(key, sp.kvs.now(), sp.keep); ok {
+		if cached.Intact() {
+			sp.cache.hits.Add(1)
+			sp.mu.Unlock()
+			sp.kvs.touch(key)
+			Logf(LogDebug, "Value for key '%s' retrieved from cache: %v", key, cached)
+			return cached, true, nil
+		}
+		RecordError("Error reading cache:", fmt.Errorf("cached value of %q fails its checksum", key))
+		sp.cache.remove(key)
+	}
+	sp.cache.misses++
+	if f, ok := sp.fills[key]; ok {
+		sp.mu.Unlock()
+		select {
+		case <-f.done:
+		case <-ctx.Done():
+			return KeyValue{}, false, ctx.Err()
+		}
+		if f.err != nil {
+			return sp.GETKVContext(ctx, key)
+		}
+		return f.item, f.ok, nil
+	}
+	f := &fill{done: make(chan struct{})}
+	sp.fills[key] = f
+	gen := sp.gen
+	sp.mu.Unlock()
+
+	if err := sp.warmup.wait(ctx); err != nil {
+		sp.mu.Lock()
+		if sp.fills[key] == f {
+			delete(sp.fills, key)
+		}
+		sp.mu.Unlock()
+		f.err = err
+		close(f.done)
+		return KeyValue{}, false, err
+	}
+	f.item, f.ok = sp.kvs.GETKV(key)
+
+	sp.mu.Lock()
+	if sp.fills[key] == f {
+		delete(sp.fills, key)
+	}
+	// a write that landed while we read may have made the value stale
+	sp.sync()
+	if f.ok && sp.gen == gen {
+		sp.store(key, f.item)
+	}
+	sp.mu.Unlock()
+	close(f.done)
+	return f.item, f.ok, nil
+}
+
+// hit serves key from the cache holding sp.mu only for reading, so reads
+// of cached keys don't wait for each other or for writes to other keys.
+// Anything else, a change to key to take in first or a cached value
+// failing its checksum included, is left to the write lock.
+func (sp *ServerProxy) hit(key string) (KeyValue, bool) {
+	sp.mu.RLock()
+	defer sp.mu.RUnlock()
+	if sp.kvs.stale.has(key) {
+		return KeyValue{}, false
+	}
+	item, ok := sp.cache.hit(key, sp.kvs.now())
+	if !ok || !item.Intact() {
+		return KeyValue{}, false
+	}
+	sp.cache.hits.Add(1)
+	return item, true
+}
+
+// store caches key, evicting unpinned keys if the cache is full, but not
+// the ones buffered under WriteBack; caller must hold sp.mu
+func (sp *ServerProxy) store(key string, kv KeyValue) {
+	sp.cache.put(key, kv, sp.kvs.now(), sp.keep)
+}
+
+// sync drops from the cache what the store changed since sync was last
+// called, keeping a cached value written at or after the change, such as
+// the one an UPDATE through the proxy caches. A write buffered under
+// WriteBack that the change overtook is dropped with it. Caller must hold
+// sp.mu.
+func (sp *ServerProxy) sync() {
+	keys, all := sp.kvs.stale.take()
+	if all {
+		sp.writes.pending = nil
+		sp.reset()
+		return
+	}
+	for key, rev := range keys {
+		if kv, ok := sp.cache.peek(key); ok && kv.Revision >= rev {
+			continue
+		}
+		delete(sp.writes.pending, key)
+		sp.invalidate(key)
+	}
+}
+
+// SetCacheSize limits the cache to size keys, 0 for no limit. A full cache
+// evicts the unpinned keys its eviction policy picks; pinned keys can take
+// it past size.
+func (sp *ServerProxy) SetCacheSize(size int) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.cache.maxKeys = size
+	sp.cache.evict(sp.keep)
+}
+
+// SetCacheBytes limits the cache to about n bytes of keys and values, with
+// the bookkeeping of each, 0 for no limit; it evicts as SetCacheSize does.
+// A value bigger than n is never cached.
+func (sp *ServerProxy) SetCacheBytes(n int64) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.cache.maxBytes = n
+	sp.cache.evict(sp.keep)
+}
+
+// SetCacheTTL makes a value cached longer than ttl ago be read from the
+// store again, 0 for never, so the cache refreshes keys that live long or
+// never expire. Keys still change in the cache as soon as they do in the
+// store; the TTL only bounds how long a copy is trusted, e.g. where the
+// store's data can change in ways the cache isn't told of. It doesn't
+// change when keys expire in the store. A write buffered under WriteBack
+// stays cached until it is written back.
+func (sp *ServerProxy) SetCacheTTL(ttl time.Duration) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.cache.ttl = ttl
+}
+
+// CacheTTL returns the TTL of SetCacheTTL
+func (sp *ServerProxy) CacheTTL() time.Duration {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.cache.ttl
+}
+
+// SetEvictionPolicy makes the cache evict by the policy registered as
+// name, see EvictLRU, EvictLFU, EvictFIFO and RegisterEvictionPolicy. The
+// keys already cached are kept.
+func (sp *ServerProxy) SetEvictionPolicy(name string) error {
+	policy, err := newEvictionPolicy(name)
+	if err != nil {
+		return err
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.cache.setPolicy(cmp.Or(name, EvictLRU), policy)
+	sp.cache.evict(sp.keep)
+	return nil
+}
+
+// EvictionPolicy returns the name of the cache's eviction policy
+func (sp *ServerProxy) EvictionPolicy() string {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.cache.policyName
+}
+
+// invalidate forgets key and any read of it in flight, caller must hold sp.mu
+func (sp *ServerProxy) invalidate(key string) {
+	sp.gen++
+	delete(sp.fills, key)
+	sp.cache.remove(key)
+}
+
+func (sp *ServerProxy) SET(key, value string) bool {
+	return sp.SETEX(key, value, 0)
+}
+
+// SETEX sets key with its own TTL, see KeyValueStore.SETEX
+func (sp *ServerProxy) SETEX(key, value string, ttl time.Duration) bool {
+	_, _, ok := sp.SETSUM(key, value, ttl, 0)
+	return ok
+}
+
+// SETSUM sets key with its own TTL and checksum, see KeyValueStore.SETSUM
+func (sp *ServerProxy) SETSUM(key, value string, ttl time.Duration, sum uint32) (rev uint64, message string, ok bool) {
+	sp.kvs.events.wait()
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	switch sp.writes.strategy {
+	case WriteBack:
+		message, ok = sp.buffer(key, value, ttl, sum)
+		return 0, message, ok
+	case WriteThrough:
+		sp.flushWrites()
+		_, item, _, message, ok := sp.kvs.set(key, value, ttl, sum, nil)
+		sp.invalidate(key)
+		if ok {
+			sp.store(key, item)
+		}
+		return item.Revision, message, ok
+	}
+	sp.flushWrites()
+	sp.invalidate(key)
+	return sp.kvs.SETSUM(key, value, ttl, sum)
+}
+
+// SETNX sets key if it does not exist, see KeyValueStore.SETNX
+func (sp *ServerProxy) SETNX(key, value string, ttl time.Duration, sum uint32) (rev uint64, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if rev, message, ok = sp.kvs.SETNX(key, value, ttl, sum); ok {
+		sp.invalidate(key)
+	}
+	return rev, message, ok
+}
+
+// CAS sets key if it holds expect or is at revision expectRev, see
+// KeyValueStore.CAS
+func (sp *ServerProxy) CAS(key, expect string, expectRev uint64, value string, ttl time.Duration, sum uint32) (current KeyValue, found bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if current, found, message, ok = sp.kvs.CAS(key, expect, expectRev, value, ttl, sum); ok {
+		sp.invalidate(key)
+	}
+	return current, found, message, ok
+}
+
+// GETSET sets key and returns its old value, see KeyValueStore.GETSET
+func (sp *ServerProxy) GETSET(key, value string, ttl time.Duration, sum uint32) (old string, found bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if old, found, message, ok = sp.kvs.GETSET(key, value, ttl, sum); ok {
+		sp.invalidate(key)
+	}
+	return old, found, message, ok
+}
+
+// GETDEL deletes key and returns its value, see KeyValueStore.GETDEL
+func (sp *ServerProxy) GETDEL(key string) (value string, found bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if value, found = sp.kvs.GETDEL(key); found {
+		sp.invalidate(key)
+	}
+	return value, found
+}
+
+func (sp *ServerProxy) UPDATE(key, value string) (message string, updated bool) {
+	_, message, updated = sp.UPDATEEX(key, value, TTLDefault, 0, 0)
+	return message, updated
+}
+
+// UPDATEEX is UPDATE with expiry options and a checksum, see
+// KeyValueStore.UPDATEEX
+func (sp *ServerProxy) UPDATEEX(key, value string, mode TTLMode, ttl time.Duration, sum uint32) (rev uint64, message string, updated bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	item, message, updated := sp.kvs.update(key, value, mode, ttl, sum)
+	if !updated {
+		return 0, message, false
+	}
+	sp.invalidate(key)
+	if sp.writes.strategy != CacheAside {
+		sp.store(key, item)
+	}
+	return item.Revision, message, true
+}
+
+func (sp *ServerProxy) DELETE(key string) (message string, deleted bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if _, ok := sp.kvs.GETKV(key); !ok {
+		return protocol.MsgValueNotExist, false
+	}
+	if message, deleted = sp.kvs.DELETE(key); deleted {
+		sp.invalidate(key)
+	}
+	return message, deleted
+}
+
+// RENAME moves src to dst, see KeyValueStore.RENAME
+func (sp *ServerProxy) RENAME(src, dst string) (message string, renamed bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	message, renamed = sp.kvs.RENAME(src, dst)
+	if renamed {
+		sp.invalidate(src)
+		sp.invalidate(dst)
+	}
+	return message, renamed
+}
+
+// INCRBY adjusts the integer in key, see KeyValueStore.INCRBY
+func (sp *ServerProxy) INCRBY(key string, delta int64, ttl time.Duration) (n int64, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	n, message, ok = sp.kvs.INCRBY(key, delta, ttl)
+	if ok {
+		sp.invalidate(key)
+	}
+	return n, message, ok
+}
+
+// NEXTID hands out IDs of the sequence at key, see KeyValueStore.NEXTID
+func (sp *ServerProxy) NEXTID(key string, count int64) (first int64, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	first, message, ok = sp.kvs.NEXTID(key, count)
+	if ok {
+		sp.invalidate(key)
+	}
+	return first, message, ok
+}
+
+// APPEND extends the value of key, see KeyValueStore.APPEND
+func (sp *ServerProxy) APPEND(key, value string, ttl time.Duration) (length int, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	length, message, ok = sp.kvs.APPEND(key, value, ttl)
+	if ok {
+		sp.invalidate(key)
+	}
+	return length, message, ok
+}
+
+// SETBIT sets a bit of the value of key, see KeyValueStore.SETBIT
+func (sp *ServerProxy) SETBIT(key string, offset, bit int, ttl time.Duration) (old int, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	old, message, ok = sp.kvs.SETBIT(key, offset, bit, ttl)
+	if ok {
+		sp.invalidate(key)
+	}
+	return old, message, ok
+}
+
+// COPY copies src to dst, see KeyValueStore.COPY
+func (sp *ServerProxy) COPY(src, dst string, ttl time.Duration) (message string, copied bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	message, copied = sp.kvs.COPY(src, dst, ttl)
+	if copied {
+		sp.invalidate(dst)
+	}
+	return message, copied
+}
+
+// UNLOCK releases the lease lock on key, see KeyValueStore.UNLOCK
+func (sp *ServerProxy) UNLOCK(key string, token uint64) (message string, released bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if message, released = sp.kvs.UNLOCK(key, token); released {
+		sp.invalidate(key)
+	}
+	return message, released
+}
+
+// RENEW extends the lease lock on key, see KeyValueStore.RENEW
+func (sp *ServerProxy) RENEW(key string, token uint64, ttl time.Duration) (message string, renewed bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if message, renewed = sp.kvs.RENEW(key, token, ttl); renewed {
+		sp.invalidate(key)
+	}
+	return message, renewed
+}
+
+// TOUCH restarts the lifetime of key, see KeyValueStore.TOUCH
+func (sp *ServerProxy) TOUCH(key string, ttl time.Duration) (message string, touched bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if message, touched = sp.kvs.TOUCH(key, ttl); touched {
+		sp.invalidate(key)
+	}
+	return message, touched
+}
+
+// HSET sets fields of the hash at key, see KeyValueStore.HSET
+func (sp *ServerProxy) HSET(key string, fields, values []string, ttl time.Duration) (added int, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if added, item, message, ok = sp.kvs.HSET(key, fields, values, ttl); ok {
+		sp.invalidate(key)
+	}
+	return added, item, message, ok
+}
+
+// HDEL removes fields of the hash at key, see KeyValueStore.HDEL
+func (sp *ServerProxy) HDEL(key string, fields []string) (removed int, item KeyValue, deleted bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if removed, item, deleted, message, ok = sp.kvs.HDEL(key, fields); removed > 0 {
+		sp.invalidate(key)
+	}
+	return removed, item, deleted, message, ok
+}
+
+// LPUSH adds values to the head of the list at key, see
+// KeyValueStore.LPUSH
+func (sp *ServerProxy) LPUSH(key string, values []string, ttl time.Duration) (length int, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if length, item, message, ok = sp.kvs.LPUSH(key, values, ttl); ok {
+		sp.invalidate(key)
+	}
+	return length, item, message, ok
+}
+
+// RPUSH adds values to the tail of the list at key, see KeyValueStore.RPUSH
+func (sp *ServerProxy) RPUSH(key string, values []string, ttl time.Duration) (length int, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if length, item, message, ok = sp.kvs.RPUSH(key, values, ttl); ok {
+		sp.invalidate(key)
+	}
+	return length, item, message, ok
+}
+
+// LPOP removes items from the head of the list at key, see
+// KeyValueStore.LPOP
+func (sp *ServerProxy) LPOP(key string, count int) (items []string, item KeyValue, deleted bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if items, item, deleted, message, ok = sp.kvs.LPOP(key, count); len(items) > 0 {
+		sp.invalidate(key)
+	}
+	return items, item, deleted, message, ok
+}
+
+// RPOP removes items from the tail of the list at key, see
+// KeyValueStore.RPOP
+func (sp *ServerProxy) RPOP(key string, count int) (items []string, item KeyValue, deleted bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if items, item, deleted, message, ok = sp.kvs.RPOP(key, count); len(items) > 0 {
+		sp.invalidate(key)
+	}
+	return items, item, deleted, message, ok
+}
+
+// SADD adds members to the set at key, see KeyValueStore.SADD
+func (sp *ServerProxy) SADD(key string, members []string, ttl time.Duration) (added int, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if added, item, message, ok = sp.kvs.SADD(key, members, ttl); added > 0 {
+		sp.invalidate(key)
+	}
+	return added, item, message, ok
+}
+
+// SREM removes members from the set at key, see KeyValueStore.SREM
+func (sp *ServerProxy) SREM(key string, members []string) (removed int, item KeyValue, deleted bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if removed, item, deleted, message, ok = sp.kvs.SREM(key, members); removed > 0 {
+		sp.invalidate(key)
+	}
+	return removed, item, deleted, message, ok
+}
+
+// ZADD sets scores of members of the sorted set at key, see
+// KeyValueStore.ZADD
+func (sp *ServerProxy) ZADD(key string, members []string, scores []float64, ttl time.Duration) (added int, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if added, item, message, ok = sp.kvs.ZADD(key, members, scores, ttl); ok {
+		sp.invalidate(key)
+	}
+	return added, item, message, ok
+}
+
+// ZREM removes members from the sorted set at key, see KeyValueStore.ZREM
+func (sp *ServerProxy) ZREM(key string, members []string) (removed int, item KeyValue, deleted bool, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if removed, item, deleted, message, ok = sp.kvs.ZREM(key, members); removed > 0 {
+		sp.invalidate(key)
+	}
+	return removed, item, deleted, message, ok
+}
+
+// XADD appends an entry to the stream at key, see KeyValueStore.XADD
+func (sp *ServerProxy) XADD(key string, fields []string, maxLen int, ttl time.Duration) (id string, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if id, item, message, ok = sp.kvs.XADD(key, fields, maxLen, ttl); ok {
+		sp.invalidate(key)
+	}
+	return id, item, message, ok
+}
+
+// XGROUP creates a consumer group of the stream at key, see
+// KeyValueStore.XGROUP
+func (sp *ServerProxy) XGROUP(key, group, start string, ttl time.Duration) (item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if item, message, ok = sp.kvs.XGROUP(key, group, start, ttl); ok {
+		sp.invalidate(key)
+	}
+	return item, message, ok
+}
+
+// XREADGROUP delivers entries of the stream at key to a consumer, see
+// KeyValueStore.XREADGROUP
+func (sp *ServerProxy) XREADGROUP(key, group, consumer, after string, count int) (entries []protocol.StreamEntry, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if entries, item, message, ok = sp.kvs.XREADGROUP(key, group, consumer, after, count); item.Revision != 0 {
+		sp.invalidate(key)
+	}
+	return entries, item, message, ok
+}
+
+// XACK acknowledges entries of the stream at key, see KeyValueStore.XACK
+func (sp *ServerProxy) XACK(key, group string, ids []string) (acked int, item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if acked, item, message, ok = sp.kvs.XACK(key, group, ids); acked > 0 {
+		sp.invalidate(key)
+	}
+	return acked, item, message, ok
+}
+
+// JSONSET sets part of the JSON document at key, see KeyValueStore.JSONSET
+func (sp *ServerProxy) JSONSET(key, path, value string, ttl time.Duration) (item KeyValue, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	if item, message, ok = sp.kvs.JSONSET(key, path, value, ttl); ok {
+		sp.invalidate(key)
+	}
+	return item, message, ok
+}
+
+// BATCH applies ops all or none, see KeyValueStore.BATCH
+func (sp *ServerProxy) BATCH(ops []BatchOp, ttl time.Duration) (revs []uint64, failed int, message string, ok bool) {
+	sp.lockWrite()
+	defer sp.mu.Unlock()
+	revs, failed, message, ok = sp.kvs.BATCH(ops, ttl)
+	if ok {
+		for _, op := range ops {
+			sp.invalidate(op.Key)
+		}
+	}
+	return revs, failed, message, ok
+}
+
+// Flush empties the cache, e.g. after the store was restored underneath
+// it, writing back what WriteBack buffered first, and returns how many
+// keys it held
+func (sp *ServerProxy) Flush() int {
+	sp.warmup.restart(time.Now())
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	sp.flushWrites()
+	n := sp.cache.len()
+	sp.reset()
+	return n
+}
+
+// reset forgets every key and every read in flight, caller must hold sp.mu
+func (sp *ServerProxy) reset() {
+	sp.gen++
+	sp.cache.reset()
+	sp.fills = make(map[string]*fill)
+}
+
+// CacheLen returns the number of cached keys
+func (sp *ServerProxy) CacheLen() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.cache.len()
+}
+
+// CacheStats returns the cache's hits, misses and evictions, how full it
+// is and how its writes back fared
+func (sp *ServerProxy) CacheStats() CacheStats {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	c := sp.cache
+	return CacheStats{
+		Hits:      c.hits.Load(),
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Keys:      c.len(),
+		Bytes:     c.bytes,
+		MaxKeys:   c.maxKeys,
+		MaxBytes:  c.maxBytes,
+		Pending:   len(sp.writes.pending),
+		Flushed:   sp.writes.flushed,
+		Failed:    sp.writes.failed,
+	}
+}
+
+// CacheBytes returns about how many bytes the cached keys take, as
+// SetCacheBytes counts them
+func (sp *ServerProxy) CacheBytes() int64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.cache.bytes
+}
+
+// CacheSize returns the limit set by SetCacheSize
+func (sp *ServerProxy) CacheSize() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.cache.maxKeys
+}
+
+// CacheMaxBytes returns the limit set by SetCacheBytes
+func (sp *ServerProxy) CacheMaxBytes() int64 {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.cache.maxBytes
+}
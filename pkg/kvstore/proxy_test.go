@@ -0,0 +1,132 @@
+package kvstore
+
+import (
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchKeys is the keyspace the benchmarks read and write
+const benchKeys = 10000
+
+func benchKey(i int) string {
+	return "key:" + strconv.Itoa(i%benchKeys)
+}
+
+// newBenchProxy returns a proxy over a store holding benchKeys keys
+func newBenchProxy(b *testing.B) *ServerProxy {
+	b.Helper()
+	kvs := NewKeyValueStore()
+	for i := 0; i < benchKeys; i++ {
+		kvs.SET(benchKey(i), "value")
+	}
+	return NewServerProxy(kvs)
+}
+
+func TestProxyCachesOnlyKeysThatExist(t *testing.T) {
+	kvs := NewKeyValueStore()
+	sp := NewServerProxy(kvs)
+	if value, found := sp.GET("k"); found {
+		t.Fatalf("GET of a missing key = %q", value)
+	}
+	if stats := sp.CacheStats(); stats.Keys != 0 {
+		t.Fatalf("cache holds %d keys after a miss, want 0", stats.Keys)
+	}
+	kvs.SET("k", "v")
+	if value, found := sp.GET("k"); !found || value != "v" {
+		t.Fatalf("GET after the key was written = %q, %v", value, found)
+	}
+	if stats := sp.CacheStats(); stats.Keys != 1 {
+		t.Fatalf("cache holds %d keys, want 1", stats.Keys)
+	}
+}
+
+func TestProxyGETSeesWritesAfterCaching(t *testing.T) {
+	kvs := NewKeyValueStore()
+	sp := NewServerProxy(kvs)
+	kvs.SET("k", "v1")
+	sp.GET("k")
+	kvs.SET("k", "v2")
+	if value, _ := sp.GET("k"); value != "v2" {
+		t.Fatalf("GET = %q, want v2", value)
+	}
+}
+
+func TestProxyMissesShareOneFill(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SET("k", "stored")
+	sp := NewServerProxy(kvs)
+	// a read of k in flight, whose result the misses must wait for
+	f := &fill{done: make(chan struct{})}
+	sp.mu.Lock()
+	sp.fills["k"] = f
+	sp.mu.Unlock()
+	values := make(chan string)
+	for i := 0; i < 3; i++ {
+		go func() {
+			value, _ := sp.GET("k")
+			values <- value
+		}()
+	}
+	select {
+	case value := <-values:
+		t.Fatalf("GET returned %q before the read in flight did", value)
+	case <-time.After(20 * time.Millisecond):
+	}
+	f.item, f.ok = KeyValue{Value: "filled", Type: TypeString}, true
+	close(f.done)
+	for i := 0; i < 3; i++ {
+		if value := <-values; value != "filled" {
+			t.Errorf("GET = %q, want the read in flight's", value)
+		}
+	}
+}
+
+// BenchmarkProxyGET reads cached keys from parallel goroutines
+func BenchmarkProxyGET(b *testing.B) {
+	sp := newBenchProxy(b)
+	for i := 0; i < benchKeys; i++ {
+		sp.GET(benchKey(i))
+	}
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1)) * 7919
+		for pb.Next() {
+			sp.GET(benchKey(i))
+			i++
+		}
+	})
+}
+
+// BenchmarkProxyGETMissing reads keys that don't exist, which go to the
+// store every time since they are not cached
+func BenchmarkProxyGETMissing(b *testing.B) {
+	sp := newBenchProxy(b)
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1)) * 7919
+		for pb.Next() {
+			sp.GET("missing:" + strconv.Itoa(i%benchKeys))
+			i++
+		}
+	})
+}
+
+// BenchmarkProxyGETFill reads through a cache a tenth the size of the
+// keyspace, so most reads are misses filling it while others are hits
+func BenchmarkProxyGETFill(b *testing.B) {
+	sp := newBenchProxy(b)
+	sp.SetCacheSize(benchKeys / 10)
+	var next atomic.Int64
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := int(next.Add(1)) * 7919
+		for pb.Next() {
+			sp.GET(benchKey(i))
+			i++
+		}
+	})
+}
@@ -0,0 +1,419 @@
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// pub/sub defaults
+const (
+	PubSubLogFile     = "pubsub.wal"
+	MaxPendingPerSub  = 1000
+	DefaultFetchWait  = 5 * time.Second
+	MaxFetchWait      = 30 * time.Second
+	MaxFetchBatchSize = 100
+)
+
+// ErrNotSubscribed is returned for a subscriber name that has no subscriptions
+var ErrNotSubscribed = errors.New("not subscribed")
+
+// Message is one published message as queued for a durable subscriber
+type Message struct {
+	ID      uint64
+	Channel string
+	Payload string
+}
+
+// subscriber is a named durable subscription: its channels and the messages
+// it has not acknowledged yet, oldest first
+type subscriber struct {
+	channels map[string]bool
+	pending  []Message
+	dropped  uint64
+	notify   chan struct{}
+}
+
+// pubsubRecord is one line of the pub/sub write-ahead log
+type pubsubRecord struct {
+	Op         string `json:"op"`
+	Subscriber string `json:"sub,omitempty"`
+	Channel    string `json:"ch,omitempty"`
+	ID         uint64 `json:"id,omitempty"`
+	Payload    string `json:"payload,omitempty"`
+}
+
+// PubSub delivers published messages to named durable subscribers. Messages
+// stay queued (at most MaxPendingPerSub per subscriber, oldest dropped first)
+// until the subscriber acknowledges them, so a subscriber that disconnects
+// gets everything it missed on its next fetch: at-least-once delivery.
+// Every change is appended to a log and replayed by OpenPubSub.
+type PubSub struct {
+	mu     sync.Mutex
+	subs   map[string]*subscriber
+	nextID uint64
+	path   string
+	log    *os.File
+	cipher *FileCipher
+}
+
+// OpenPubSub replays the log at path, compacts it and keeps appending to it.
+// An empty path keeps subscriptions in memory only.
+func OpenPubSub(path string) (*PubSub, error) {
+	return OpenPubSubWithCipher(path, nil)
+}
+
+// OpenPubSubWithCipher is OpenPubSub for a log whose lines are encrypted
+// with c, or in the clear if c is nil. Compacting rewrites the whole log
+// that way, whatever keys it was written under.
+func OpenPubSubWithCipher(path string, c *FileCipher) (*PubSub, error) {
+	ps := &PubSub{subs: make(map[string]*subscriber), nextID: 1, path: path, cipher: c}
+	if path == "" {
+		return ps, nil
+	}
+	if err := ps.replay(); err != nil {
+		return nil, err
+	}
+	if err := ps.compact(); err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+// Close closes the log file
+func (ps *PubSub) Close() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.log == nil {
+		return nil
+	}
+	err := ps.log.Close()
+	ps.log = nil
+	return err
+}
+
+// Sync flushes the log file to disk
+func (ps *PubSub) Sync() error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if ps.log == nil {
+		return nil
+	}
+	return ps.log.Sync()
+}
+
+// Subscribe adds channel to the durable subscription called name; channel
+// may be a pattern such as "news.*" in path.Match syntax
+func (ps *PubSub) Subscribe(name, channel string) error {
+	if _, err := path.Match(channel, ""); err != nil {
+		return err
+	}
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if err := ps.append(pubsubRecord{Op: "sub", Subscriber: name, Channel: channel}); err != nil {
+		return err
+	}
+	ps.subscribe(name, channel)
+	return nil
+}
+
+// Unsubscribe removes channel from name's subscription; the subscriber and
+// its queue go away with its last channel
+func (ps *PubSub) Unsubscribe(name, channel string) error {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	sub, ok := ps.subs[name]
+	if !ok || !sub.channels[channel] {
+		return ErrNotSubscribed
+	}
+	if err := ps.append(pubsubRecord{Op: "unsub", Subscriber: name, Channel: channel}); err != nil {
+		return err
+	}
+	ps.unsubscribe(name, channel)
+	return nil
+}
+
+// Publish queues payload for every subscriber of channel and returns how
+// many subscribers it was queued for
+func (ps *PubSub) Publish(channel, payload string) (int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	id := ps.nextID
+	if err := ps.append(pubsubRecord{Op: "pub", Channel: channel, ID: id, Payload: payload}); err != nil {
+		return 0, err
+	}
+	return ps.publish(Message{ID: id, Channel: channel, Payload: payload}), nil
+}
+
+// Fetch returns up to MaxFetchBatchSize unacknowledged messages for name,
+// waiting up to wait for one to arrive if none are queued. Fetched messages
+// are redelivered until they are acknowledged. It stops waiting when ctx is
+// done.
+func (ps *PubSub) Fetch(ctx context.Context, name string, wait time.Duration) ([]Message, error) {
+	if wait <= 0 {
+		wait = DefaultFetchWait
+	}
+	if wait > MaxFetchWait {
+		wait = MaxFetchWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	for {
+		ps.mu.Lock()
+		sub, ok := ps.subs[name]
+		if !ok {
+			ps.mu.Unlock()
+			return nil, ErrNotSubscribed
+		}
+		if len(sub.pending) > 0 {
+			n := len(sub.pending)
+			if n > MaxFetchBatchSize {
+				n = MaxFetchBatchSize
+			}
+			batch := append([]Message(nil), sub.pending[:n]...)
+			ps.mu.Unlock()
+			return batch, nil
+		}
+		notify := sub.notify
+		ps.mu.Unlock()
+
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, nil
+		case <-ctx.Done():
+			return nil, nil
+		}
+	}
+}
+
+// Ack acknowledges every message for name up to and including id
+func (ps *PubSub) Ack(name string, id uint64) (int, error) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	if _, ok := ps.subs[name]; !ok {
+		return 0, ErrNotSubscribed
+	}
+	if err := ps.append(pubsubRecord{Op: "ack", Subscriber: name, ID: id}); err != nil {
+		return 0, err
+	}
+	return ps.ack(name, id), nil
+}
+
+// Subscribers describes every durable subscriber, one line each
+func (ps *PubSub) Subscribers() []string {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+	var lines []string
+	for name, sub := range ps.subs {
+		channels := make([]string, 0, len(sub.channels))
+		for ch := range sub.channels {
+			channels = append(channels, ch)
+		}
+		sort.Strings(channels)
+		lines = append(lines, fmt.Sprintf("%s channels=%s pending=%d dropped=%d", name, strings.Join(channels, ","), len(sub.pending), sub.dropped))
+	}
+	sort.Strings(lines)
+	return lines
+}
+
+func (ps *PubSub) subscribe(name, channel string) {
+	sub, ok := ps.subs[name]
+	if !ok {
+		sub = &subscriber{channels: make(map[string]bool), notify: make(chan struct{})}
+		ps.subs[name] = sub
+	}
+	sub.channels[channel] = true
+}
+
+// matches reports whether sub receives messages published to channel, by
+// name or by a pattern such as "news.*" in path.Match syntax
+func (sub *subscriber) matches(channel string) bool {
+	if sub.channels[channel] {
+		return true
+	}
+	for pattern := range sub.channels {
+		if strings.ContainsAny(pattern, "*?[") {
+			if ok, _ := path.Match(pattern, channel); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (ps *PubSub) unsubscribe(name, channel string) {
+	sub, ok := ps.subs[name]
+	if !ok {
+		return
+	}
+	delete(sub.channels, channel)
+	if len(sub.channels) == 0 {
+		delete(ps.subs, name)
+	}
+}
+
+func (ps *PubSub) publish(msg Message) int {
+	if msg.ID >= ps.nextID {
+		ps.nextID = msg.ID + 1
+	}
+	n := 0
+	for _, sub := range ps.subs {
+		if !sub.matches(msg.Channel) {
+			continue
+		}
+		sub.pending = append(sub.pending, msg)
+		if len(sub.pending) > MaxPendingPerSub {
+			drop := len(sub.pending) - MaxPendingPerSub
+			sub.pending = append([]Message(nil), sub.pending[drop:]...)
+			sub.dropped += uint64(drop)
+		}
+		close(sub.notify)
+		sub.notify = make(chan struct{})
+		n++
+	}
+	return n
+}
+
+func (ps *PubSub) ack(name string, id uint64) int {
+	sub, ok := ps.subs[name]
+	if !ok {
+		return 0
+	}
+	n := 0
+	for n < len(sub.pending) && sub.pending[n].ID <= id {
+		n++
+	}
+	sub.pending = sub.pending[n:]
+	return n
+}
+
+// append writes rec to the log, caller must hold ps.mu
+func (ps *PubSub) append(rec pubsubRecord) error {
+	if ps.log == nil {
+		return nil
+	}
+	line, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	_, err = ps.log.Write(append(ps.cipher.sealLine(line), '\n'))
+	return err
+}
+
+func (ps *PubSub) replay() error {
+	file, err := os.Open(ps.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line, err := ps.cipher.openLine(scanner.Bytes())
+		if errors.Is(err, ErrFileKey) {
+			return fmt.Errorf("replaying pub/sub log %s: %w", ps.path, err)
+		}
+		var rec pubsubRecord
+		if err == nil {
+			err = json.Unmarshal(line, &rec)
+		}
+		if err != nil {
+			// a torn last line from a crash, everything before it is good
+			RecordError("Error replaying pub/sub log:", err)
+			break
+		}
+		switch rec.Op {
+		case "sub":
+			ps.subscribe(rec.Subscriber, rec.Channel)
+		case "unsub":
+			ps.unsubscribe(rec.Subscriber, rec.Channel)
+		case "pub":
+			ps.publish(Message{ID: rec.ID, Channel: rec.Channel, Payload: rec.Payload})
+		case "queued":
+			// compacted form: a message already routed to one subscriber
+			if sub, ok := ps.subs[rec.Subscriber]; ok {
+				sub.pending = append(sub.pending, Message{ID: rec.ID, Channel: rec.Channel, Payload: rec.Payload})
+			}
+			if rec.ID >= ps.nextID {
+				ps.nextID = rec.ID + 1
+			}
+		case "ack":
+			ps.ack(rec.Subscriber, rec.ID)
+		case "seq":
+			if rec.ID > ps.nextID {
+				ps.nextID = rec.ID
+			}
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log as just the current subscriptions and queues,
+// via a temp file and rename so a crash leaves either log intact
+func (ps *PubSub) compact() error {
+	tmp := ps.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	write := func(rec pubsubRecord) {
+		var line []byte
+		if err == nil {
+			line, err = json.Marshal(rec)
+		}
+		if err == nil {
+			_, err = w.Write(append(ps.cipher.sealLine(line), '\n'))
+		}
+	}
+	// keep ids increasing across restarts even when every queue is empty
+	write(pubsubRecord{Op: "seq", ID: ps.nextID})
+	names := make([]string, 0, len(ps.subs))
+	for name := range ps.subs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub := ps.subs[name]
+		for ch := range sub.channels {
+			write(pubsubRecord{Op: "sub", Subscriber: name, Channel: ch})
+		}
+		for _, msg := range sub.pending {
+			write(pubsubRecord{Op: "queued", Subscriber: name, Channel: msg.Channel, ID: msg.ID, Payload: msg.Payload})
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, ps.path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	ps.log, err = os.OpenFile(ps.path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err == nil {
+		err = syncDir(filepath.Dir(ps.path))
+	}
+	return err
+}
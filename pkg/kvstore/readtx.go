@@ -0,0 +1,159 @@
+package kvstore
+
+import (
+	"errors"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrReadClosed is returned by reads of a ReadTx after Close, or after
+// LoadSnapshot replaced what it was reading
+var ErrReadClosed = errors.New("read transaction closed")
+
+// ReadTx is a point-in-time view of a KeyValueStore: its reads see every
+// key as it was at Revision, however many writes land after BeginRead, so
+// reads of several keys are never torn by a write between them. Keys that
+// have expired since are missing all the same.
+//
+// While a ReadTx is open the store keeps the entries it overwrites or
+// deletes, so Close it as soon as it is done with.
+type ReadTx struct {
+	kvs    *KeyValueStore
+	rev    uint64
+	closed bool // guarded by kvs.mu
+}
+
+// version is an entry that has been overwritten or deleted, by the write
+// at revision until
+type version struct {
+	KeyValue
+	until uint64
+}
+
+// BeginRead opens a read transaction at the store's latest revision
+func (kvs *KeyValueStore) BeginRead() *ReadTx {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	tx := &ReadTx{kvs: kvs, rev: kvs.revision}
+	if kvs.reads == nil {
+		kvs.reads = make(map[*ReadTx]bool)
+	}
+	kvs.reads[tx] = true
+	return tx
+}
+
+// Revision is the revision the transaction reads at: it sees the writes up
+// to and including it
+func (tx *ReadTx) Revision() uint64 {
+	return tx.rev
+}
+
+// GET reads key as it was at the transaction's revision, see
+// KeyValueStore.GETSUM
+func (tx *ReadTx) GET(key string) (value string, found bool, err error) {
+	item, found, err := tx.GETKV(key)
+	if found && item.Type != TypeString {
+		return protocol.MsgWrongType, false, err
+	}
+	return item.Value, found, err
+}
+
+// GETKV is GET returning the whole entry, hashes included, see
+// KeyValueStore.GETKV
+func (tx *ReadTx) GETKV(key string) (item KeyValue, found bool, err error) {
+	kvs := tx.kvs
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	if tx.closed {
+		return KeyValue{}, false, ErrReadClosed
+	}
+	item, ok := kvs.data.get(key)
+	if !ok || item.Revision > tx.rev {
+		item, ok = kvs.versionAt(key, tx.rev)
+	}
+	if !ok || kvs.expired(item, kvs.now()) {
+		return KeyValue{Value: protocol.MsgNotFound}, false, nil
+	}
+	if !item.Intact() {
+		return KeyValue{Value: protocol.MsgIntegrity}, false, nil
+	}
+	return item, true, nil
+}
+
+// Close ends the transaction and lets the store drop the entries only it
+// could still read. Closing it again does nothing.
+func (tx *ReadTx) Close() {
+	kvs := tx.kvs
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if tx.closed {
+		return
+	}
+	tx.closed = true
+	delete(kvs.reads, tx)
+	kvs.pruneHistory()
+}
+
+// versionAt returns the overwritten or deleted entry of key that was live
+// at rev, caller must hold kvs.mu
+func (kvs *KeyValueStore) versionAt(key string, rev uint64) (KeyValue, bool) {
+	for _, v := range kvs.history[key] {
+		if v.Revision <= rev && rev < v.until {
+			return v.KeyValue, true
+		}
+	}
+	return KeyValue{}, false
+}
+
+// retire keeps old, the entry of key the write at revision until
+// overwrites or deletes, for the open read transactions and the version
+// history; caller must hold kvs.mu
+func (kvs *KeyValueStore) retire(key string, old KeyValue, until uint64) {
+	if kvs.versions.keep > 0 {
+		kvs.versions.add(key, old, until, kvs.now())
+	}
+	if len(kvs.reads) == 0 {
+		return
+	}
+	if kvs.history == nil {
+		kvs.history = make(map[string][]version)
+	}
+	kvs.history[key] = append(kvs.history[key], version{KeyValue: old, until: until})
+}
+
+// pruneHistory drops the retired entries no open read transaction can
+// read, caller must hold kvs.mu
+func (kvs *KeyValueStore) pruneHistory() {
+	if len(kvs.reads) == 0 {
+		kvs.history = nil
+		return
+	}
+	oldest := kvs.revision
+	for tx := range kvs.reads {
+		oldest = min(oldest, tx.rev)
+	}
+	for key, versions := range kvs.history {
+		kept := versions[:0]
+		for _, v := range versions {
+			if v.until > oldest {
+				kept = append(kept, v)
+			}
+		}
+		if len(kept) == 0 {
+			delete(kvs.history, key)
+		} else {
+			kvs.history[key] = kept
+		}
+	}
+}
+
+// closeReads ends every open read transaction, and drops the version
+// history, for writes that replace the whole store; caller must hold
+// kvs.mu
+func (kvs *KeyValueStore) closeReads() {
+	for tx := range kvs.reads {
+		tx.closed = true
+	}
+	kvs.reads, kvs.history = nil, nil
+	kvs.versions.keys = nil
+}
@@ -0,0 +1,253 @@
+package kvstore
+
+import (
+	"cmp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+// DefaultReplicationBacklog is how many writes a primary keeps for its
+// replicas to catch up from unless SetReplicationBacklog says otherwise
+const DefaultReplicationBacklog = 100000
+
+// ErrReplicationGap is returned by ReplicationSince when the writes after
+// the replica's position are no longer kept, or the position is from
+// another history, so the replica has to start again from a snapshot.
+var ErrReplicationGap = errors.New("replication position not in the backlog")
+
+// ReplicationOp is one write for a replica to apply: the entry Key was
+// left with, whole, or its deletion if Entry is nil, at the store's
+// Revision. Seq increases by one per op within the history of one
+// replication ID. Stamp and Origin are the Stamp of the write in a
+// multi-primary store, for a delete as much as a set.
+type ReplicationOp struct {
+	Seq      uint64
+	Key      string
+	Entry    *KeyValue
+	Revision uint64
+	Stamp    uint64
+	Origin   string
+}
+
+// replLog is the backlog of the writes replicas read, kept in a ring of
+// the latest size ops
+type replLog struct {
+	mu    sync.Mutex
+	id    string // changes whenever the history starts again
+	seq   uint64 // of the latest op
+	first uint64 // of the oldest op kept
+	ring  []ReplicationOp
+	wake  chan struct{} // closed at the next append, nil if nobody waits
+}
+
+func newReplLog(size int) *replLog {
+	l := &replLog{ring: make([]ReplicationOp, size)}
+	l.reset()
+	return l
+}
+
+// reset starts a new history, which replicas can't follow from the old
+func (l *replLog) reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	var id [8]byte
+	rand.Read(id[:])
+	l.id = hex.EncodeToString(id[:])
+	l.first = l.seq + 1
+}
+
+func (l *replLog) append(key string, entry *KeyValue, rev uint64, stamp Stamp) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.seq++
+	l.ring[l.seq%uint64(len(l.ring))] = ReplicationOp{Seq: l.seq, Key: key, Entry: entry, Revision: rev, Stamp: stamp.At, Origin: stamp.Origin}
+	if l.seq-l.first >= uint64(len(l.ring)) {
+		l.first = l.seq - uint64(len(l.ring)) + 1
+	}
+	if l.wake != nil {
+		close(l.wake)
+		l.wake = nil
+	}
+}
+
+// position returns the history and the seq of its latest op
+func (l *replLog) position() (id string, seq uint64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.id, l.seq
+}
+
+// since returns up to limit ops after seq after of history id, and a
+// channel closed at the next append if there are none
+func (l *replLog) since(id string, after uint64, limit int) ([]ReplicationOp, uint64, <-chan struct{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if id != l.id || after+1 < l.first || after > l.seq {
+		return nil, l.seq, nil, ErrReplicationGap
+	}
+	if after == l.seq {
+		if l.wake == nil {
+			l.wake = make(chan struct{})
+		}
+		return nil, l.seq, l.wake, nil
+	}
+	n := min(l.seq-after, uint64(limit))
+	ops := make([]ReplicationOp, n)
+	for i := range ops {
+		ops[i] = l.ring[(after+1+uint64(i))%uint64(len(l.ring))]
+	}
+	return ops, l.seq, nil, nil
+}
+
+// replEngine is an engine that appends every write and delete through it
+// to the replication backlog. It sits under the indexes and over the WAL.
+type replEngine struct {
+	engine
+	kvs *KeyValueStore
+	log *replLog
+}
+
+func (e *replEngine) set(key string, kv KeyValue) {
+	var stamp Stamp
+	if m := e.kvs.multi; m != nil {
+		stamp = m.stamp(key, &kv, e.kvs.now())
+	}
+	e.engine.set(key, kv)
+	e.log.append(key, &kv, kv.Revision, stamp)
+}
+
+func (e *replEngine) delete(key string) {
+	var stamp Stamp
+	if m := e.kvs.multi; m != nil {
+		stamp = m.stamp(key, nil, e.kvs.now())
+	}
+	e.engine.delete(key)
+	e.log.append(key, nil, e.kvs.revision, stamp)
+}
+
+// SetReplicationBacklog sets how many writes are kept for replicas to
+// catch up from, DefaultReplicationBacklog if n <= 0; a replica further
+// behind loads a snapshot again. Call it before the first replica
+// connects.
+func (kvs *KeyValueStore) SetReplicationBacklog(n int) {
+	if n <= 0 {
+		n = DefaultReplicationBacklog
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.replBacklog = n
+}
+
+// ReplicationBacklog returns what SetReplicationBacklog set
+func (kvs *KeyValueStore) ReplicationBacklog() int {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.replBacklog
+}
+
+// ReplicationSnapshot returns every entry of kvs, with its values in the
+// clear whatever SetEncryption says, and the replication ID and seq it is
+// at, for a replica to load and then follow with ReplicationSince. The
+// first call starts keeping the backlog.
+func (kvs *KeyValueStore) ReplicationSnapshot() (snapshot BackupSnapshot, id string, seq uint64) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if kvs.repl == nil {
+		kvs.repl = newReplLog(cmp.Or(kvs.replBacklog, DefaultReplicationBacklog))
+		kvs.retrack()
+	}
+	snapshot.Data = make(map[string]KeyValue, kvs.data.len())
+	kvs.data.each(func(key string, value KeyValue) bool {
+		snapshot.Data[key] = value
+		return true
+	})
+	id, seq = kvs.repl.position()
+	return snapshot, id, seq
+}
+
+// ReplicationPosition returns the seq of the latest write replicas read,
+// or false if none has loaded a ReplicationSnapshot yet.
+func (kvs *KeyValueStore) ReplicationPosition() (uint64, bool) {
+	kvs.mu.RLock()
+	l := kvs.repl
+	kvs.mu.RUnlock()
+	if l == nil {
+		return 0, false
+	}
+	_, seq := l.position()
+	return seq, true
+}
+
+// ReplicationSince returns up to limit writes after seq after of the
+// history id, waiting up to wait for one if there are none yet, and the
+// seq of the latest write. It returns ErrReplicationGap if they are not
+// kept, including after LoadSnapshot, which starts a new history.
+func (kvs *KeyValueStore) ReplicationSince(ctx context.Context, id string, after uint64, limit int, wait time.Duration) ([]ReplicationOp, uint64, error) {
+	kvs.mu.RLock()
+	l := kvs.repl
+	kvs.mu.RUnlock()
+	if l == nil {
+		return nil, 0, ErrReplicationGap
+	}
+	if limit <= 0 || limit > MaxJournalPageSize {
+		limit = MaxJournalPageSize
+	}
+	timer := time.NewTimer(min(wait, MaxJournalWait))
+	defer timer.Stop()
+	for {
+		ops, seq, wake, err := l.since(id, after, limit)
+		if err != nil || len(ops) > 0 || wait <= 0 {
+			return ops, seq, err
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return nil, seq, nil
+		case <-ctx.Done():
+			return nil, seq, nil
+		}
+	}
+}
+
+// ApplyReplication writes ops from a primary's ReplicationSince into kvs,
+// as the primary wrote them, with its revisions. Callers with a
+// ServerProxy in front of kvs need do nothing more: the keys are stale to
+// its cache like any others written.
+func (kvs *KeyValueStore) ApplyReplication(ops []ReplicationOp) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	for _, op := range ops {
+		old, exists := kvs.data.get(op.Key)
+		kvs.revision = max(kvs.revision, op.Revision)
+		if exists {
+			kvs.namespaces.remove(op.Key, old)
+			kvs.retire(op.Key, old, op.Revision)
+		}
+		kvs.zsets.drop(op.Key)
+		if op.Entry == nil {
+			if exists {
+				kvs.data.delete(op.Key)
+				kvs.emit(EventDelete, op.Key, "", now)
+			}
+			continue
+		}
+		kvs.data.set(op.Key, *op.Entry)
+		kvs.namespaces.add(op.Key, *op.Entry)
+		kvs.emit(EventSet, op.Key, op.Entry.Value, now)
+	}
+}
+
+// Revision returns the last revision given to a write, on a replica that
+// of the last write it applied, so the replica furthest along has the
+// highest.
+func (kvs *KeyValueStore) Revision() uint64 {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.revision
+}
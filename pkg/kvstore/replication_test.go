@@ -0,0 +1,37 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestReplicationSinceReportsAGap(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SetReplicationBacklog(2)
+	_, id, seq := kvs.ReplicationSnapshot()
+	for _, key := range []string{"a", "b", "c"} {
+		kvs.SET(key, "1")
+	}
+	ctx := context.Background()
+	// a is no longer kept
+	if _, _, err := kvs.ReplicationSince(ctx, id, seq, 0, 0); !errors.Is(err, ErrReplicationGap) {
+		t.Fatalf("ReplicationSince from before the backlog = %v, want ErrReplicationGap", err)
+	}
+	ops, latest, err := kvs.ReplicationSince(ctx, id, seq+1, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0].Key != "b" || ops[1].Key != "c" || latest != seq+3 {
+		t.Errorf("ops %+v up to %d, want b and c up to %d", ops, latest, seq+3)
+	}
+	if _, _, err := kvs.ReplicationSince(ctx, "another", seq+1, 0, 0); !errors.Is(err, ErrReplicationGap) {
+		t.Errorf("ReplicationSince of another history = %v, want ErrReplicationGap", err)
+	}
+
+	// a snapshot loaded starts a new history
+	kvs.LoadSnapshot(BackupSnapshot{Data: map[string]KeyValue{}})
+	if _, _, err := kvs.ReplicationSince(ctx, id, latest, 0, 0); !errors.Is(err, ErrReplicationGap) {
+		t.Errorf("ReplicationSince across LoadSnapshot = %v, want ErrReplicationGap", err)
+	}
+}
@@ -0,0 +1,117 @@
+package kvstore
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// backupTimeLayout stamps the snapshots kept by SetRetention; it sorts
+// by time as text
+const backupTimeLayout = "20060102T150405.000Z"
+
+// BackupFile is one snapshot kept by SetRetention
+type BackupFile struct {
+	Name string // in the backup file's directory
+	Time time.Time
+	Size int64
+}
+
+// SetRetention makes WriteBackup keep every snapshot under a name of its
+// own, the backup file's with the time it was taken, e.g.
+// backup-20240501T120000.000Z.snap, instead of overwriting one file. The
+// backup file itself becomes a link to the latest. Snapshots past the
+// newest keep, if keep is above zero, or older than window, if it is above
+// zero, are deleted after each snapshot; the latest is always kept. With
+// both zero, the backup file is overwritten as before.
+func (kvs *KeyValueStore) SetRetention(keep int, window time.Duration) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.backupKeep, kvs.backupWindow = max(keep, 0), max(window, 0)
+}
+
+// Retention returns what SetRetention set
+func (kvs *KeyValueStore) Retention() (keep int, window time.Duration) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.backupKeep, kvs.backupWindow
+}
+
+// backupName is the name of the snapshot of path taken at t
+func backupName(path string, t time.Time) string {
+	ext := filepath.Ext(path)
+	stem := strings.TrimSuffix(filepath.Base(path), ext)
+	return stem + "-" + t.UTC().Format(backupTimeLayout) + ext
+}
+
+// Backups lists the snapshots SetRetention keeps next to the backup file,
+// newest first
+func (kvs *KeyValueStore) Backups() ([]BackupFile, error) {
+	path, _ := kvs.Backup()
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(filepath.Base(path), ext) + "-"
+	var files []BackupFile
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasPrefix(name, prefix) || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		t, err := time.Parse(backupTimeLayout, strings.TrimSuffix(strings.TrimPrefix(name, prefix), ext))
+		if err != nil {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, BackupFile{Name: name, Time: t, Size: info.Size()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Time.After(files[j].Time) })
+	return files, nil
+}
+
+// writeRetained writes data as the snapshot of path taken at t and links
+// path to it, copying it instead where links aren't supported
+func writeRetained(path string, t time.Time, data []byte) error {
+	stamped := filepath.Join(filepath.Dir(path), backupName(path, t))
+	if err := writeFileAtomic(stamped, data); err != nil {
+		return err
+	}
+	tmp := fmt.Sprintf("%s.%d.link", path, t.UnixNano())
+	if err := os.Link(stamped, tmp); err != nil {
+		return writeFileAtomic(path, data)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// pruneBackups deletes the snapshots SetRetention no longer keeps
+func (kvs *KeyValueStore) pruneBackups(now time.Time) {
+	keep, window := kvs.Retention()
+	files, err := kvs.Backups()
+	if err != nil {
+		RecordError("Error listing backups:", err)
+		return
+	}
+	path, _ := kvs.Backup()
+	for i, f := range files {
+		if i == 0 || (keep == 0 || i < keep) && (window == 0 || now.Sub(f.Time) <= window) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(filepath.Dir(path), f.Name)); err != nil {
+			RecordError("Error pruning backup:", err)
+			continue
+		}
+		Logf(LogDebug, "Pruned backup %s", f.Name)
+	}
+}
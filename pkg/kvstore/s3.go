@@ -0,0 +1,172 @@
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3Sink keeps snapshots in a bucket of S3 or a service that speaks its
+// API, such as MinIO or GCS with HMAC keys, under Prefix. Requests are
+// signed with AWS Signature Version 4.
+type S3Sink struct {
+	Endpoint  string // e.g. https://s3.eu-west-1.amazonaws.com; empty for AWS in Region
+	Region    string
+	Bucket    string
+	Prefix    string
+	AccessKey string
+	SecretKey string
+	Token     string // session token of temporary credentials, if any
+	Client    *http.Client
+}
+
+// NewS3SinkFromEnv returns an S3Sink for bucket and prefix with the
+// credentials, region and endpoint the AWS tools read from the
+// environment: AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY,
+// AWS_SESSION_TOKEN, AWS_REGION or AWS_DEFAULT_REGION, and
+// AWS_ENDPOINT_URL_S3 or AWS_ENDPOINT_URL.
+func NewS3SinkFromEnv(bucket, prefix string) (*S3Sink, error) {
+	s := &S3Sink{
+		Endpoint:  firstEnv("AWS_ENDPOINT_URL_S3", "AWS_ENDPOINT_URL"),
+		Region:    firstEnv("AWS_REGION", "AWS_DEFAULT_REGION"),
+		Bucket:    bucket,
+		Prefix:    prefix,
+		AccessKey: os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		Token:     os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return nil, fmt.Errorf("s3://%s: AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY must be set", bucket)
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	return s, nil
+}
+
+func firstEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+func (s *S3Sink) String() string {
+	return "s3://" + s.Bucket + "/" + s.Prefix
+}
+
+// objectURL is the URL of the object name: path-style on a custom
+// endpoint, virtual-hosted on AWS
+func (s *S3Sink) objectURL(name string) (*url.URL, error) {
+	key := strings.TrimPrefix(strings.TrimSuffix(s.Prefix, "/")+"/"+name, "/")
+	if s.Endpoint == "" {
+		return url.Parse("https://" + s.Bucket + ".s3." + s.Region + ".amazonaws.com/" + s3Escape(key))
+	}
+	return url.Parse(strings.TrimSuffix(s.Endpoint, "/") + "/" + s3Escape(s.Bucket) + "/" + s3Escape(key))
+}
+
+// Put stores data as the object name
+func (s *S3Sink) Put(ctx context.Context, name string, data []byte) error {
+	_, err := s.do(ctx, http.MethodPut, name, data)
+	return err
+}
+
+// Get reads the object name; a missing one fails with fs.ErrNotExist
+func (s *S3Sink) Get(ctx context.Context, name string) ([]byte, error) {
+	return s.do(ctx, http.MethodGet, name, nil)
+}
+
+func (s *S3Sink) do(ctx context.Context, method, name string, body []byte) ([]byte, error) {
+	u, err := s.objectURL(name)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(len(body))
+	s.sign(req, body, time.Now())
+	client := s.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return nil, fmt.Errorf("%s%s: %w", s, name, fs.ErrNotExist)
+	case resp.StatusCode/100 != 2:
+		return nil, fmt.Errorf("%s %s%s: %s: %s", method, s, name, resp.Status, bytes.TrimSpace(data))
+	}
+	return data, nil
+}
+
+// sign adds the AWS Signature Version 4 headers for body at now to req
+func (s *S3Sink) sign(req *http.Request, body []byte, now time.Time) {
+	now = now.UTC()
+	stamp, day := now.Format("20060102T150405Z"), now.Format("20060102")
+	payload := sha256.Sum256(body)
+	payloadHash := hex.EncodeToString(payload[:])
+	req.Header.Set("X-Amz-Date", stamp)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.Token != "" {
+		req.Header.Set("X-Amz-Security-Token", s.Token)
+	}
+	signed := "host;x-amz-content-sha256;x-amz-date"
+	headers := "host:" + req.URL.Host + "\nx-amz-content-sha256:" + payloadHash + "\nx-amz-date:" + stamp + "\n"
+	if s.Token != "" {
+		signed += ";x-amz-security-token"
+		headers += "x-amz-security-token:" + s.Token + "\n"
+	}
+	canonical := strings.Join([]string{req.Method, req.URL.EscapedPath(), "", headers, signed, payloadHash}, "\n")
+	scope := day + "/" + s.Region + "/s3/aws4_request"
+	sum := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + stamp + "\n" + scope + "\n" + hex.EncodeToString(sum[:])
+	key := []byte("AWS4" + s.SecretKey)
+	for _, part := range []string{day, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKey+"/"+scope+", SignedHeaders="+signed+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3Escape escapes an object key the way Signature Version 4 expects: all
+// but unreserved characters and the slashes between segments
+func s3Escape(key string) string {
+	var b strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || strings.IndexByte("-._~/", c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,157 @@
+package kvstore
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ScanBuckets is how many buckets the engines spread keys over by hash. A
+// SCAN cursor points into a bucket, and a key stays in its bucket for as
+// long as it exists, so a key present for a whole scan is returned
+// exactly once however the store changes in between.
+const ScanBuckets = 1024
+
+// DefaultScanCount is how many keys a SCAN examines when not told
+const DefaultScanCount = 100
+
+// bucketOf returns the scan bucket of key: FNV-1a, inlined so it does not
+// allocate
+func bucketOf(key string) int {
+	h := uint32(2166136261)
+	for i := 0; i < len(key); i++ {
+		h ^= uint32(key[i])
+		h *= 16777619
+	}
+	return int(h % ScanBuckets)
+}
+
+// buckets is a map from key split into ScanBuckets maps by bucketOf, made
+// on first use
+type buckets[V any] struct {
+	maps [ScanBuckets]map[string]V
+	n    int
+}
+
+func (b *buckets[V]) get(key string) (V, bool) {
+	v, ok := b.maps[bucketOf(key)][key]
+	return v, ok
+}
+
+func (b *buckets[V]) set(key string, v V) {
+	m := b.maps[bucketOf(key)]
+	if m == nil {
+		m = make(map[string]V)
+		b.maps[bucketOf(key)] = m
+	}
+	if _, ok := m[key]; !ok {
+		b.n++
+	}
+	m[key] = v
+}
+
+func (b *buckets[V]) delete(key string) {
+	m := b.maps[bucketOf(key)]
+	if _, ok := m[key]; ok {
+		delete(m, key)
+		b.n--
+	}
+}
+
+func (b *buckets[V]) len() int { return b.n }
+
+// each calls fn for every key until it returns false
+func (b *buckets[V]) each(fn func(key string, v V) bool) {
+	for i := range b.maps {
+		if !b.eachIn(i, fn) {
+			return
+		}
+	}
+}
+
+// eachIn calls fn for the keys of bucket i until it returns false, and
+// reports whether it got to the end
+func (b *buckets[V]) eachIn(i int, fn func(key string, v V) bool) bool {
+	for key, v := range b.maps[i] {
+		if !fn(key, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// SCAN examines the next count keys from cursor and returns those that
+// are live and match pattern, in path.Match syntax, "" matching all. next
+// is the cursor to pass on to read on, "" once every key was examined.
+// A page may hold fewer keys than count, or none, before the scan is done.
+//
+// Keys are visited bucket by bucket, and in order within a bucket, so a
+// cursor is a bucket and then the last key examined in it, as in "17" or
+// "17:user:42"; "" or "0" starts the scan. The store is locked one bucket
+// at a time, so a scan of any size holds up writers no longer than reading
+// one bucket takes. Keys present throughout the scan are returned once;
+// keys written or deleted meanwhile may or may not be.
+func (kvs *KeyValueStore) SCAN(cursor, pattern string, count int) (keys []string, next string, err error) {
+	if pattern != "" {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, "", err
+		}
+	}
+	bucket, after, resume, err := parseScanCursor(cursor)
+	if err != nil {
+		return nil, "", err
+	}
+	if count <= 0 {
+		count = DefaultScanCount
+	}
+	type entry struct {
+		key  string
+		live bool
+	}
+	examined := 0
+	for ; bucket < ScanBuckets; bucket, resume = bucket+1, false {
+		var entries []entry
+		kvs.mu.RLock()
+		now := kvs.now()
+		kvs.data.eachExpiryIn(bucket, func(key string, item KeyValue) bool {
+			if !resume || key > after {
+				entries = append(entries, entry{key, !kvs.expired(item, now)})
+			}
+			return true
+		})
+		kvs.mu.RUnlock()
+		sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+		for i, e := range entries {
+			if examined == count {
+				if i == 0 {
+					return keys, strconv.Itoa(bucket), nil
+				}
+				return keys, strconv.Itoa(bucket) + ":" + entries[i-1].key, nil
+			}
+			examined++
+			if !e.live {
+				continue
+			}
+			if ok, _ := path.Match(pattern, e.key); ok || pattern == "" {
+				keys = append(keys, e.key)
+			}
+		}
+	}
+	return keys, "", nil
+}
+
+// parseScanCursor splits a SCAN cursor into its bucket and, if resume is
+// set, the last key examined in it
+func parseScanCursor(cursor string) (bucket int, after string, resume bool, err error) {
+	if cursor == "" {
+		return 0, "", false, nil
+	}
+	b, after, resume := strings.Cut(cursor, ":")
+	bucket, err = strconv.Atoi(b)
+	if err != nil || bucket < 0 || bucket >= ScanBuckets {
+		return 0, "", false, fmt.Errorf("invalid scan cursor %q", cursor)
+	}
+	return bucket, after, resume, nil
+}
@@ -0,0 +1,220 @@
+package kvstore
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a schedule in the five fields of crontab(5): minute, hour, day
+// of month, month and day of week, e.g. "*/15 * * * *" or "0 3 * * 1-5",
+// in the server's local time. Each field is *, a number, a range a-b, any
+// of those with a step /n, or a comma-separated list of them; day of week
+// runs from 0, Sunday, to 6, and 7 is Sunday too. As in cron, a day
+// matches if either day field does when both are restricted. @hourly,
+// @daily, @weekly and @monthly stand for their usual schedules.
+type Cron struct {
+	expr                          string
+	minute, hour, dom, month, dow uint64 // bit n set if n matches
+	domAny, dowAny                bool
+}
+
+var cronMacros = map[string]string{
+	"@hourly":   "0 * * * *",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@weekly":   "0 0 * * 0",
+	"@monthly":  "0 0 1 * *",
+}
+
+// ParseCron reads a cron expression, see Cron
+func ParseCron(expr string) (*Cron, error) {
+	spec := strings.TrimSpace(expr)
+	if macro, ok := cronMacros[spec]; ok {
+		spec = macro
+	}
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression %q: want 5 fields, got %d", expr, len(fields))
+	}
+	c := &Cron{expr: expr, domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	bounds := []struct {
+		bits     *uint64
+		min, max int
+	}{{&c.minute, 0, 59}, {&c.hour, 0, 23}, {&c.dom, 1, 31}, {&c.month, 1, 12}, {&c.dow, 0, 7}}
+	for i, b := range bounds {
+		bits, err := parseCronField(fields[i], b.min, b.max)
+		if err != nil {
+			return nil, fmt.Errorf("cron expression %q: %w", expr, err)
+		}
+		*b.bits = bits
+	}
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+func parseCronField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rng, stepText, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			var err error
+			if step, err = strconv.Atoi(stepText); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+		}
+		lo, hi := min, max
+		if rng != "*" {
+			from, to, isRange := strings.Cut(rng, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("bad value in %q", part)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("bad value in %q", part)
+				}
+			} else if hasStep {
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q is outside %d-%d", part, min, max)
+		}
+		for n := lo; n <= hi; n += step {
+			bits |= 1 << n
+		}
+	}
+	return bits, nil
+}
+
+func (c *Cron) String() string {
+	return c.expr
+}
+
+// Next returns the first time after t the schedule matches, zero if there
+// is none in the next five years, e.g. for "0 0 30 2 *"
+func (c *Cron) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		switch {
+		case c.month&(1<<int(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !c.dayMatches(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case c.hour&(1<<t.Hour()) == 0:
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+		case c.minute&(1<<t.Minute()) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	}
+	return dom || dow
+}
+
+// QuietHours is a time of day, in the server's local time, when scheduled
+// snapshots are skipped, e.g. the busiest hours; the zero value is none
+type QuietHours struct {
+	From, To time.Duration // since midnight; To before From spans midnight
+}
+
+// ParseQuietHours reads quiet hours written "22:00-06:00"; "" is none
+func ParseQuietHours(s string) (QuietHours, error) {
+	if s == "" {
+		return QuietHours{}, nil
+	}
+	from, to, ok := strings.Cut(s, "-")
+	if !ok {
+		return QuietHours{}, fmt.Errorf("quiet hours %q: want HH:MM-HH:MM", s)
+	}
+	var q QuietHours
+	for _, f := range []struct {
+		text string
+		d    *time.Duration
+	}{{from, &q.From}, {to, &q.To}} {
+		t, err := time.Parse("15:04", strings.TrimSpace(f.text))
+		if err != nil {
+			return QuietHours{}, fmt.Errorf("quiet hours %q: want HH:MM-HH:MM", s)
+		}
+		*f.d = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+	return q, nil
+}
+
+// Contains reports whether t falls in the quiet hours
+func (q QuietHours) Contains(t time.Time) bool {
+	if q.From == q.To {
+		return false
+	}
+	d := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+	if q.From < q.To {
+		return q.From <= d && d < q.To
+	}
+	return d >= q.From || d < q.To
+}
+
+func (q QuietHours) String() string {
+	if q.From == q.To {
+		return ""
+	}
+	clock := func(d time.Duration) string {
+		return fmt.Sprintf("%02d:%02d", int(d.Hours()), int(d.Minutes())%60)
+	}
+	return clock(q.From) + "-" + clock(q.To)
+}
+
+// SetBackupSchedule makes BackupKeyValueStore write snapshots when cron
+// matches instead of every backup interval, unless cron is nil, and skip
+// those that fall in quiet; call it before starting BackupKeyValueStore
+func (kvs *KeyValueStore) SetBackupSchedule(cron *Cron, quiet QuietHours) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.backupCron, kvs.backupQuiet = cron, quiet
+}
+
+// BackupSchedule returns what SetBackupSchedule set
+func (kvs *KeyValueStore) BackupSchedule() (cron *Cron, quiet QuietHours) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.backupCron, kvs.backupQuiet
+}
+
+// BackupResult is how the last WriteBackup went: when it finished, how
+// long it took, and its error
+type BackupResult struct {
+	Time     time.Time
+	Duration time.Duration
+	Err      error
+}
+
+// LastBackup returns how the last snapshot went, by any caller of
+// WriteBackup, and when BackupKeyValueStore writes the next; either is
+// zero if unknown
+func (kvs *KeyValueStore) LastBackup() (last BackupResult, next time.Time) {
+	if p := kvs.lastBackup.Load(); p != nil {
+		last = *p
+	}
+	if n := kvs.nextBackup.Load(); n != 0 {
+		next = time.Unix(0, n)
+	}
+	return last, next
+}
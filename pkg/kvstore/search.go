@@ -0,0 +1,216 @@
+package kvstore
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// maxTermLen bounds the bytes of a term the search index keeps; longer
+// words, such as encoded blobs, are left out
+const maxTermLen = 64
+
+// terms splits text into lower-case words of letters and digits, each
+// with how many times it occurs, and returns how many words there were
+func terms(text string) (counts map[string]int, total int) {
+	counts = make(map[string]int)
+	for _, word := range strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if len(word) > maxTermLen {
+			continue
+		}
+		counts[strings.ToLower(word)]++
+		total++
+	}
+	return counts, total
+}
+
+// searchEngine is an engine that also keeps an inverted index of its
+// plain values, so the keys whose values hold some words are found
+// without reading every value
+type searchEngine struct {
+	engine
+	postings map[string]map[string]int // term → key → times it occurs
+	lengths  map[string]int            // key → words in its value
+	words    int                       // sum of lengths
+}
+
+func newSearchEngine(inner engine) *searchEngine {
+	s := &searchEngine{engine: inner, postings: make(map[string]map[string]int), lengths: make(map[string]int)}
+	inner.each(func(key string, kv KeyValue) bool {
+		s.add(key, kv)
+		return true
+	})
+	return s
+}
+
+func (s *searchEngine) set(key string, kv KeyValue) {
+	if old, ok := s.engine.get(key); ok {
+		s.remove(key, old)
+	}
+	s.add(key, kv)
+	s.engine.set(key, kv)
+}
+
+func (s *searchEngine) delete(key string) {
+	if old, ok := s.engine.get(key); ok {
+		s.remove(key, old)
+	}
+	s.engine.delete(key)
+}
+
+// add indexes the value of a key with none indexed; only plain values are
+func (s *searchEngine) add(key string, kv KeyValue) {
+	if kv.Type != TypeString {
+		return
+	}
+	counts, total := terms(kv.Value)
+	if total == 0 {
+		return
+	}
+	for term, n := range counts {
+		keys := s.postings[term]
+		if keys == nil {
+			keys = make(map[string]int)
+			s.postings[term] = keys
+		}
+		keys[key] = n
+	}
+	s.lengths[key] = total
+	s.words += total
+}
+
+// remove undoes add for the value kv the key had
+func (s *searchEngine) remove(key string, kv KeyValue) {
+	total, ok := s.lengths[key]
+	if !ok {
+		return
+	}
+	counts, _ := terms(kv.Value)
+	for term := range counts {
+		delete(s.postings[term], key)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+		}
+	}
+	delete(s.lengths, key)
+	s.words -= total
+}
+
+// searchOf returns the search index layered on e, nil if there is none
+func searchOf(e engine) *searchEngine {
+	for {
+		switch w := e.(type) {
+		case *searchEngine:
+			return w
+		case *dirEngine:
+			e = w.engine
+		case *orderedEngine:
+			e = w.engine
+		default:
+			return nil
+		}
+	}
+}
+
+// SetSearch makes kvs keep an inverted index of the words in its plain
+// values for SEARCH, or stops it. Building the index reads every value,
+// and afterwards each write costs a pass over the old and the new value;
+// the index takes memory in proportion to the distinct words of each
+// value.
+func (kvs *KeyValueStore) SetSearch(search bool) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if (searchOf(kvs.data) != nil) == search {
+		return
+	}
+	sep := ""
+	if d, ok := kvs.data.(*dirEngine); ok {
+		sep = d.sep
+	}
+	kvs.data = withIndexes(unwrap(kvs.data), sep, orderOf(kvs.data) != nil, search)
+}
+
+// Searchable reports whether kvs keeps a search index, see SetSearch
+func (kvs *KeyValueStore) Searchable() bool {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return searchOf(kvs.data) != nil
+}
+
+// SearchHit is a key SEARCH found and how well its value matched
+type SearchHit struct {
+	Key   string
+	Score float64
+}
+
+// BM25 parameters: how soon repeats of a term stop adding to a score, and
+// how much a long value is marked down
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// SEARCH returns the live keys whose plain values hold every word of
+// query, any case, best match first, skipping offset of them and
+// returning at most limit if limit is above zero; more reports whether
+// there are matches past those. Values are ranked by BM25: a rarer word
+// counts for more, as does a word repeated in a shorter value. ok is false
+// if kvs keeps no search index.
+func (kvs *KeyValueStore) SEARCH(query string, offset, limit int) (hits []SearchHit, more bool, ok bool) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	s := searchOf(kvs.data)
+	if s == nil {
+		return nil, false, false
+	}
+	counts, _ := terms(query)
+	hits = []SearchHit{}
+	if len(counts) == 0 {
+		return hits, false, true
+	}
+	words := make([]string, 0, len(counts))
+	for term := range counts {
+		words = append(words, term)
+	}
+	// walk the rarest term's keys and look the others up
+	sort.Slice(words, func(i, j int) bool { return len(s.postings[words[i]]) < len(s.postings[words[j]]) })
+	docs := float64(len(s.lengths))
+	avg := float64(s.words) / docs
+	now := kvs.now()
+	for key, tf := range s.postings[words[0]] {
+		score := 0.0
+		for i, term := range words {
+			if i > 0 {
+				if tf = s.postings[term][key]; tf == 0 {
+					score = -1
+					break
+				}
+			}
+			n := float64(len(s.postings[term]))
+			idf := math.Log(1 + (docs-n+0.5)/(n+0.5))
+			f := float64(tf)
+			score += idf * f * (bm25K1 + 1) / (f + bm25K1*(1-bm25B+bm25B*float64(s.lengths[key])/avg))
+		}
+		if score < 0 {
+			continue
+		}
+		if item, _ := s.engine.get(key); kvs.expired(item, now) {
+			continue
+		}
+		hits = append(hits, SearchHit{key, score})
+	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Key < hits[j].Key
+	})
+	hits = hits[min(offset, len(hits)):]
+	if limit > 0 && len(hits) > limit {
+		return hits[:limit], true, true
+	}
+	return hits, false, true
+}
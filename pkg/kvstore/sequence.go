@@ -0,0 +1,51 @@
+package kvstore
+
+import (
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// SequenceTTL is the lifetime the keys NEXTID creates are given, for
+// OBJECT and the like to show; a sequence never expires whatever its TTL
+const SequenceTTL = 100 * 365 * 24 * time.Hour
+
+// MaxIDBlock is the most IDs one NEXTID hands out
+const MaxIDBlock = 1 << 20
+
+// NEXTID hands out the next count IDs of the sequence at key and returns
+// the first: the IDs first to first+count-1 are the caller's alone, and
+// come after every ID the sequence handed out before. The key holds the
+// last ID handed out as a TypeSequence, written and persisted like any
+// other value, so a sequence survives restarts and failovers with the rest
+// of the data; a missing key starts at 1. A sequence never expires and is
+// never evicted, and SET, DELETE, RENAME, COPY and BATCH refuse it with
+// protocol.MsgWrongType, so nothing restarts it to hand out its IDs again;
+// NEXTID likewise refuses a key of any other type. A count outside 1 to
+// MaxIDBlock fails with protocol.MsgInvalidArgument, and a sequence that
+// would overflow an int64 with protocol.MsgNotInteger.
+func (kvs *KeyValueStore) NEXTID(key string, count int64) (first int64, message string, ok bool) {
+	if count < 1 || count > MaxIDBlock {
+		return 0, protocol.MsgInvalidArgument, false
+	}
+	_, _, message, ok = kvs.modifyTyped(key, TypeSequence, SequenceTTL, func(value string) (string, bool, string) {
+		var last int64
+		if value != "" {
+			var err error
+			if last, err = strconv.ParseInt(value, 10, 64); err != nil || last < 0 {
+				return "", false, protocol.MsgNotInteger
+			}
+		}
+		if last > math.MaxInt64-count {
+			return "", false, protocol.MsgNotInteger
+		}
+		first = last + 1
+		return strconv.FormatInt(last+count, 10), true, ""
+	})
+	if !ok {
+		return 0, message, false
+	}
+	return first, protocol.MsgIDsAllocated, true
+}
@@ -0,0 +1,92 @@
+package kvstore
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestNextIDHandsOutBlocks(t *testing.T) {
+	kvs := NewKeyValueStore()
+	for _, c := range []struct {
+		count int64
+		first int64
+	}{{1, 1}, {10, 2}, {1, 12}} {
+		first, message, ok := kvs.NEXTID("seq", c.count)
+		if !ok || first != c.first {
+			t.Fatalf("NEXTID(%d) = %d, %s, %v; want %d", c.count, first, message, ok, c.first)
+		}
+	}
+	if typ, _ := kvs.TYPE("seq"); typ != TypeSequence {
+		t.Fatalf("type %s, want sequence", typ)
+	}
+	for _, count := range []int64{0, MaxIDBlock + 1} {
+		if _, message, ok := kvs.NEXTID("seq", count); ok || message != protocol.MsgInvalidArgument {
+			t.Fatalf("NEXTID(%d) = %s, %v; want %s", count, message, ok, protocol.MsgInvalidArgument)
+		}
+	}
+}
+
+func TestNextIDRefusesOtherTypes(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.SET("plain", "5")
+	if _, message, ok := kvs.NEXTID("plain", 1); ok || message != protocol.MsgWrongType {
+		t.Fatalf("NEXTID of a string = %s, %v; want %s", message, ok, protocol.MsgWrongType)
+	}
+}
+
+func TestSequenceCannotBeRestarted(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.NEXTID("seq", 5)
+	if _, message, _ := kvs.SETSUM("seq", "0", 0, 0); message != protocol.MsgWrongType {
+		t.Errorf("SET: %s, want %s", message, protocol.MsgWrongType)
+	}
+	if message, _ := kvs.DELETE("seq"); message != protocol.MsgWrongType {
+		t.Errorf("DELETE: %s, want %s", message, protocol.MsgWrongType)
+	}
+	if message, _ := kvs.RENAME("seq", "other"); message != protocol.MsgWrongType {
+		t.Errorf("RENAME: %s, want %s", message, protocol.MsgWrongType)
+	}
+	if message, _ := kvs.COPY("seq", "other", 0); message != protocol.MsgWrongType {
+		t.Errorf("COPY: %s, want %s", message, protocol.MsgWrongType)
+	}
+	kvs.SET("plain", "v")
+	if message, _ := kvs.RENAME("plain", "seq"); message != protocol.MsgWrongType {
+		t.Errorf("RENAME over it: %s, want %s", message, protocol.MsgWrongType)
+	}
+	if _, _, message, _ := kvs.BATCH([]BatchOp{{Key: "seq", Delete: true}}, 0); message != protocol.MsgWrongType {
+		t.Errorf("BATCH delete: %s, want %s", message, protocol.MsgWrongType)
+	}
+	if first, _, _ := kvs.NEXTID("seq", 1); first != 6 {
+		t.Fatalf("NEXTID after the refused writes = %d, want 6", first)
+	}
+}
+
+func TestSequenceNeverExpires(t *testing.T) {
+	clock := NewManualClock(time.Unix(1_700_000_000, 0))
+	kvs := NewKeyValueStore()
+	kvs.SetClock(clock)
+	kvs.NEXTID("seq", 1)
+	clock.Advance(200 * 365 * 24 * time.Hour)
+	ClearExpiredKeysNow(kvs, nil)
+	if first, _, _ := kvs.NEXTID("seq", 1); first != 2 {
+		t.Fatalf("NEXTID after 200 years = %d, want 2", first)
+	}
+}
+
+func TestSequenceNotEvicted(t *testing.T) {
+	kvs := NewKeyValueStore()
+	kvs.NEXTID("seq", 1)
+	kvs.SetMaxMemory(kvs.MemoryUsage().Used+4096, MaxMemoryLRU)
+	for i := 0; i < 200; i++ {
+		kvs.SET("k"+strconv.Itoa(i), "some value to fill memory with")
+	}
+	if kvs.MemoryUsage().Evicted == 0 {
+		t.Fatal("nothing was evicted; the limit is too loose for the test")
+	}
+	if first, _, _ := kvs.NEXTID("seq", 1); first != 2 {
+		t.Fatalf("NEXTID after evictions = %d, want 2", first)
+	}
+}
@@ -0,0 +1,173 @@
+package kvstore
+
+import (
+	"sort"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// SADD adds members to the set at key, creating it with ttl, or the
+// store's default, if it is missing or expired; an existing set keeps its
+// remaining lifetime. added is how many of them are new; if none, nothing
+// is written. item is the entry written, its Value the whole set as
+// protocol.EncodeSet makes it. A key that holds another type fails with
+// protocol.MsgWrongType, and the write is refused over quota, see SETSUM.
+func (kvs *KeyValueStore) SADD(key string, members []string, ttl time.Duration) (added int, item KeyValue, message string, ok bool) {
+	item, _, message, ok = kvs.modifySet(key, ttl, func(set map[string]bool) bool {
+		for _, member := range members {
+			if !set[member] {
+				added++
+				set[member] = true
+			}
+		}
+		return added > 0
+	})
+	return added, item, message, ok
+}
+
+// SREM removes members from the set at key and returns how many of them
+// it had; if none, nothing is written. Removing the last member deletes
+// the key, reported by deleted; otherwise item is the entry written, see
+// SADD.
+func (kvs *KeyValueStore) SREM(key string, members []string) (removed int, item KeyValue, deleted bool, message string, ok bool) {
+	item, deleted, message, ok = kvs.modifySet(key, 0, func(set map[string]bool) bool {
+		for _, member := range members {
+			if set[member] {
+				removed++
+				delete(set, member)
+			}
+		}
+		return removed > 0
+	})
+	return removed, item, deleted, message, ok
+}
+
+// SMEMBERS returns the members of the set at key in order, found false if
+// it is missing. A key that holds another type fails with
+// protocol.MsgWrongType.
+func (kvs *KeyValueStore) SMEMBERS(key string) (members []string, found bool, message string) {
+	sets, message := kvs.readSets([]string{key})
+	if message != "" || sets[0] == nil {
+		return nil, false, message
+	}
+	return sorted(sets[0]), true, ""
+}
+
+// SISMEMBER reports whether the set at key has member, see SMEMBERS.
+func (kvs *KeyValueStore) SISMEMBER(key, member string) (found bool, message string) {
+	sets, message := kvs.readSets([]string{key})
+	return sets[0][member], message
+}
+
+// SUNION returns, in order, the members of any of the sets at keys; a
+// missing key is an empty set. A key that holds another type fails with
+// protocol.MsgWrongType. The sets are read at one instant, so no write
+// lands between them.
+func (kvs *KeyValueStore) SUNION(keys []string) (members []string, message string) {
+	sets, message := kvs.readSets(keys)
+	if message != "" {
+		return nil, message
+	}
+	union := make(map[string]bool)
+	for _, set := range sets {
+		for member := range set {
+			union[member] = true
+		}
+	}
+	return sorted(union), ""
+}
+
+// SINTER returns, in order, the members of all of the sets at keys, see
+// SUNION.
+func (kvs *KeyValueStore) SINTER(keys []string) (members []string, message string) {
+	sets, message := kvs.readSets(keys)
+	if message != "" || len(sets) == 0 {
+		return nil, message
+	}
+	inter := make(map[string]bool)
+	for member := range sets[0] {
+		in := true
+		for _, set := range sets[1:] {
+			if !set[member] {
+				in = false
+				break
+			}
+		}
+		if in {
+			inter[member] = true
+		}
+	}
+	return sorted(inter), ""
+}
+
+// SDIFF returns, in order, the members of the set at the first of keys
+// that none of the others has, see SUNION.
+func (kvs *KeyValueStore) SDIFF(keys []string) (members []string, message string) {
+	sets, message := kvs.readSets(keys)
+	if message != "" || len(sets) == 0 {
+		return nil, message
+	}
+	diff := make(map[string]bool)
+	for member := range sets[0] {
+		in := false
+		for _, set := range sets[1:] {
+			if set[member] {
+				in = true
+				break
+			}
+		}
+		if !in {
+			diff[member] = true
+		}
+	}
+	return sorted(diff), ""
+}
+
+// readSets decodes the sets at keys, all under one read lock; a missing
+// key is a nil set
+func (kvs *KeyValueStore) readSets(keys []string) (sets []map[string]bool, message string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	now := kvs.now()
+	sets = make([]map[string]bool, len(keys))
+	for i, key := range keys {
+		value, found, message := kvs.typed(key, TypeSet, now)
+		if message != "" {
+			return sets, message
+		}
+		if !found {
+			continue
+		}
+		set, err := protocol.DecodeSet(value)
+		if err != nil {
+			return sets, protocol.MsgIntegrity
+		}
+		sets[i] = set
+	}
+	return sets, ""
+}
+
+// modifySet is modifyTyped for the set at key, decoded for fn
+func (kvs *KeyValueStore) modifySet(key string, ttl time.Duration, fn func(set map[string]bool) (changed bool)) (item KeyValue, deleted bool, message string, ok bool) {
+	return kvs.modifyTyped(key, TypeSet, ttl, func(value string) (string, bool, string) {
+		set, err := protocol.DecodeSet(value)
+		if err != nil {
+			return "", false, protocol.MsgIntegrity
+		}
+		if !fn(set) {
+			return "", false, ""
+		}
+		return protocol.EncodeSet(set), true, ""
+	})
+}
+
+// sorted returns the members of set in order
+func sorted(set map[string]bool) []string {
+	members := make([]string, 0, len(set))
+	for member := range set {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
@@ -0,0 +1,133 @@
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// SnapshotSink is somewhere besides the backup file's directory that
+// WriteBackup copies each snapshot to, so backups survive the loss of the
+// server's disk. Objects are named like the files they copy, e.g.
+// backup.snap and backup.snap.delta.1.
+type SnapshotSink interface {
+	Put(ctx context.Context, name string, data []byte) error
+	// Get fails with fs.ErrNotExist for a missing name
+	Get(ctx context.Context, name string) ([]byte, error)
+	String() string
+}
+
+// SinkTimeout bounds how long WriteBackup waits for a sink to take a
+// snapshot
+const SinkTimeout = time.Minute
+
+// DirSink is a SnapshotSink keeping snapshots in a directory, such as a
+// network mount
+type DirSink string
+
+func (d DirSink) Put(ctx context.Context, name string, data []byte) error {
+	return writeFileAtomic(filepath.Join(string(d), name), data)
+}
+
+func (d DirSink) Get(ctx context.Context, name string) ([]byte, error) {
+	return os.ReadFile(filepath.Join(string(d), name))
+}
+
+func (d DirSink) String() string {
+	return string(d)
+}
+
+// ParseSink reads a sink URL: s3://bucket/prefix for S3, see
+// NewS3SinkFromEnv, gs://bucket/prefix for GCS through its S3 API with
+// HMAC keys in the same variables, or a directory, optionally as
+// file:///dir
+func ParseSink(s string) (SnapshotSink, error) {
+	scheme, rest, ok := strings.Cut(s, "://")
+	if !ok {
+		return DirSink(s), nil
+	}
+	bucket, prefix, _ := strings.Cut(rest, "/")
+	switch scheme {
+	case "file":
+		return DirSink(rest), nil
+	case "s3":
+		return NewS3SinkFromEnv(bucket, prefix)
+	case "gs":
+		sink, err := NewS3SinkFromEnv(bucket, prefix)
+		if err != nil {
+			return nil, err
+		}
+		if sink.Endpoint == "" {
+			sink.Endpoint, sink.Region = "https://storage.googleapis.com", "auto"
+		}
+		return sink, nil
+	}
+	return nil, fmt.Errorf("unknown sink %q, want s3://, gs:// or a directory", s)
+}
+
+// SetSnapshotSink makes WriteBackup copy each snapshot to sink as well,
+// or to none if sink is nil
+func (kvs *KeyValueStore) SetSnapshotSink(sink SnapshotSink) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.sink = sink
+}
+
+// SnapshotSink returns the sink SetSnapshotSink set, nil if none
+func (kvs *KeyValueStore) SnapshotSink() SnapshotSink {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.sink
+}
+
+// upload copies data, the snapshot written as the files names, to the
+// store's sink if it has one
+func (kvs *KeyValueStore) upload(data []byte, names ...string) error {
+	sink := kvs.SnapshotSink()
+	if sink == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), SinkTimeout)
+	defer cancel()
+	for _, name := range names {
+		if err := sink.Put(ctx, filepath.Base(name), data); err != nil {
+			return fmt.Errorf("copying snapshot to %s: %w", sink, err)
+		}
+	}
+	return nil
+}
+
+// FetchBackup copies the snapshot sink holds for the backup file path,
+// and the deltas after it, to path, for RestoreBackup to read after the
+// server's disk was lost. Local deltas past those fetched are deleted.
+func FetchBackup(ctx context.Context, sink SnapshotSink, path string) error {
+	data, err := sink.Get(ctx, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if err := writeFileAtomic(path, data); err != nil {
+		return err
+	}
+	n := 1
+	for ; ; n++ {
+		data, err := sink.Get(ctx, filepath.Base(deltaName(path, n)))
+		if errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := writeFileAtomic(deltaName(path, n), data); err != nil {
+			return err
+		}
+	}
+	for ; fileExists(deltaName(path, n)); n++ {
+		os.Remove(deltaName(path, n))
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package kvstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an S3 endpoint keeping objects in memory. It checks that each
+// request carries a signature for its body, without verifying it.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	sum := sha256.Sum256(body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hex.EncodeToString(sum[:]) ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AK/") ||
+		!strings.Contains(r.Header.Get("Authorization"), "/eu-west-1/s3/aws4_request") {
+		http.Error(w, "bad signature", http.StatusForbidden)
+		return
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.Method {
+	case http.MethodPut:
+		f.objects[r.URL.Path] = body
+	case http.MethodGet:
+		data, ok := f.objects[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(data)
+	}
+}
+
+func newFakeS3Sink(t *testing.T) (*S3Sink, *fakeS3) {
+	t.Helper()
+	f := &fakeS3{objects: make(map[string][]byte)}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return &S3Sink{Endpoint: srv.URL, Region: "eu-west-1", Bucket: "bucket", Prefix: "kvs/", AccessKey: "AK", SecretKey: "SK"}, f
+}
+
+func TestS3SinkPutGet(t *testing.T) {
+	sink, f := newFakeS3Sink(t)
+	ctx := context.Background()
+	if err := sink.Put(ctx, "backup.snap", []byte("snapshot")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := f.objects["/bucket/kvs/backup.snap"]; !ok {
+		t.Errorf("objects %v, want /bucket/kvs/backup.snap", f.objects)
+	}
+	if data, err := sink.Get(ctx, "backup.snap"); err != nil || string(data) != "snapshot" {
+		t.Errorf("Get = %q, %v", data, err)
+	}
+	if _, err := sink.Get(ctx, "missing"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Get of a missing object = %v, want fs.ErrNotExist", err)
+	}
+}
+
+// TestSinkSurvivesLostDisk writes snapshots through each kind of sink,
+// loses the backup file and restores from the sink
+func TestSinkSurvivesLostDisk(t *testing.T) {
+	s3, _ := newFakeS3Sink(t)
+	for _, sink := range []SnapshotSink{DirSink(t.TempDir()), s3} {
+		path := filepath.Join(t.TempDir(), "backup.snap")
+		kvs := NewKeyValueStore()
+		kvs.SetBackup(path, 0)
+		kvs.SetSnapshotSink(sink)
+		kvs.SET("k", "v")
+		if err := WriteBackup(kvs); err != nil {
+			t.Fatalf("%s: %v", sink, err)
+		}
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+
+		restored := NewKeyValueStore()
+		restored.SetBackup(path, 0)
+		restored.SetSnapshotSink(sink)
+		if _, _, err := RestoreLatest(context.Background(), restored); err != nil {
+			t.Fatalf("%s: %v", sink, err)
+		}
+		if value, _ := restored.GET("k"); value != "v" {
+			t.Errorf("%s: GET after restoring from the sink = %q", sink, value)
+		}
+	}
+}
+
+func TestParseSink(t *testing.T) {
+	t.Setenv("AWS_ACCESS_KEY_ID", "AK")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SK")
+	t.Setenv("AWS_ENDPOINT_URL_S3", "")
+	t.Setenv("AWS_ENDPOINT_URL", "")
+	sink, err := ParseSink("gs://bucket/some/prefix")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s3, ok := sink.(*S3Sink)
+	if !ok || s3.Endpoint != "https://storage.googleapis.com" || s3.Bucket != "bucket" || s3.Prefix != "some/prefix" {
+		t.Errorf("gs sink %+v", sink)
+	}
+	if sink, _ := ParseSink("file:///backups"); sink != DirSink("/backups") {
+		t.Errorf("file sink %v", sink)
+	}
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	if _, err := ParseSink("s3://bucket"); err == nil {
+		t.Error("s3 sink without credentials")
+	}
+}
@@ -0,0 +1,333 @@
+package kvstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SnapshotVersion is the version of the snapshot format WriteBackup
+// writes. Each version is read by every later release, so a restore never
+// depends on the release that wrote the file.
+//
+// A snapshot file is, with every integer a varint as encoding/binary
+// makes them, signed ones zig-zag encoded:
+//
+//	magic    "KVSSNAP\n"
+//	version  uvarint
+//	created  varint, Unix nanoseconds
+//	kind     uvarint, 0 for a full snapshot, 1 for a delta (version 2)
+//	base     varint, Unix nanoseconds, when the full snapshot a delta
+//	         follows was created, 0 for a full one (version 2)
+//	seq      uvarint, a delta's place after it, from 1 (version 2)
+//	wal      uvarint, the last WAL record the snapshot holds, 0 for none
+//	         (version 3)
+//	count    uvarint, entries
+//	count entries, in key order, each:
+//	  key        uvarint length, bytes
+//	  value      uvarint length, bytes
+//	  timestamp  varint, Unix nanoseconds, 0 for none
+//	  ttl        varint, nanoseconds
+//	  checksum   uvarint
+//	  revision   uvarint
+//	  type       uvarint, a ValueType
+//	  flags      uvarint, 1 if the value is sealed, see SetEncryption
+//	deleted  uvarint, keys a delta deletes, then each as uvarint length,
+//	         bytes (version 2)
+//	sha256   32 bytes, of everything before it
+//
+// Snapshots from before the format, JSON objects with a "data" map, are
+// still read, and the next snapshot replaces them.
+const SnapshotVersion = 3
+
+const snapshotMagic = "KVSSNAP\n"
+
+// ErrSnapshotVersion is returned for a snapshot written in a format
+// version newer than SnapshotVersion
+var ErrSnapshotVersion = errors.New("snapshot format is newer than this release reads")
+
+// snapshot compressions, see SetSnapshotCompression
+const (
+	SnapshotUncompressed = "none"
+	SnapshotGzip         = "gzip"
+)
+
+// SnapshotInfo describes a snapshot file: its Format, "binary" with its
+// Version or "json", its Compression, when it was Created if known, how
+// many Keys it holds, and whether it was Checked against a checksum and
+// count. A Delta holds only what changed after the full snapshot created
+// at Base, being the Seq'th delta after it; see SetIncremental.
+type SnapshotInfo struct {
+	Format      string
+	Version     int
+	Compression string
+	Created     time.Time
+	Keys        int
+	Checked     bool
+	Delta       bool
+	Base        time.Time
+	Seq         int
+}
+
+// SetSnapshotCompression makes WriteBackup compress snapshots with name,
+// SnapshotGzip or SnapshotUncompressed, before any encryption. Snapshots
+// shrink to a fraction of their size at some CPU per snapshot; a restore
+// reads either, whatever the setting.
+func (kvs *KeyValueStore) SetSnapshotCompression(name string) error {
+	switch name {
+	case SnapshotUncompressed, SnapshotGzip:
+	case "":
+		name = SnapshotUncompressed
+	default:
+		return fmt.Errorf("unknown snapshot compression %q, want gzip or none", name)
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.snapshotCompression = name
+	return nil
+}
+
+// compressSnapshot is data compressed with name, see
+// SetSnapshotCompression
+func compressSnapshot(data []byte, name string) []byte {
+	if name != SnapshotGzip {
+		return data
+	}
+	var b bytes.Buffer
+	w, _ := gzip.NewWriterLevel(&b, gzip.BestSpeed)
+	w.Write(data)
+	w.Close()
+	return b.Bytes()
+}
+
+// decompressSnapshot undoes compressSnapshot, telling the compression
+// from the data
+func decompressSnapshot(data []byte) ([]byte, string, error) {
+	if !bytes.HasPrefix(data, []byte{0x1f, 0x8b}) {
+		return data, SnapshotUncompressed, nil
+	}
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, SnapshotGzip, err
+	}
+	data, err = io.ReadAll(r)
+	if err != nil {
+		return nil, SnapshotGzip, fmt.Errorf("%w: %v", ErrSnapshotChecksum, err)
+	}
+	return data, SnapshotGzip, nil
+}
+
+// encodeSnapshot is snapshot as WriteBackup writes it, before encryption,
+// created when info says, and a delta if info says so
+func encodeSnapshot(snapshot BackupSnapshot, info SnapshotInfo) []byte {
+	keys := make([]string, 0, len(snapshot.Data))
+	for key := range snapshot.Data {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	b := []byte(snapshotMagic)
+	b = binary.AppendUvarint(b, SnapshotVersion)
+	b = binary.AppendVarint(b, info.Created.UnixNano())
+	var kind uint64
+	var base int64
+	if info.Delta {
+		kind, base = 1, info.Base.UnixNano()
+	}
+	b = binary.AppendUvarint(b, kind)
+	b = binary.AppendVarint(b, base)
+	b = binary.AppendUvarint(b, uint64(info.Seq))
+	b = binary.AppendUvarint(b, snapshot.WAL)
+	b = binary.AppendUvarint(b, uint64(len(keys)))
+	for _, key := range keys {
+		kv := snapshot.Data[key]
+		b = appendBytes(b, key)
+		b = appendBytes(b, kv.Value)
+		var ts int64
+		if !kv.Timestamp.IsZero() {
+			ts = kv.Timestamp.UnixNano()
+		}
+		b = binary.AppendVarint(b, ts)
+		b = binary.AppendVarint(b, int64(kv.TTL))
+		b = binary.AppendUvarint(b, uint64(kv.Checksum))
+		b = binary.AppendUvarint(b, kv.Revision)
+		b = binary.AppendUvarint(b, uint64(kv.Type))
+		var flags uint64
+		if kv.Encrypted {
+			flags |= 1
+		}
+		b = binary.AppendUvarint(b, flags)
+	}
+	b = binary.AppendUvarint(b, uint64(len(snapshot.Deleted)))
+	for _, key := range snapshot.Deleted {
+		b = appendBytes(b, key)
+	}
+	sum := sha256.Sum256(b)
+	return append(b, sum[:]...)
+}
+
+func appendBytes(b []byte, s string) []byte {
+	return append(binary.AppendUvarint(b, uint64(len(s))), s...)
+}
+
+// decodeSnapshot reads a snapshot file, after decryption, in any format
+// and version this release knows
+func decodeSnapshot(data []byte) (BackupSnapshot, SnapshotInfo, error) {
+	if bytes.HasPrefix(data, []byte(snapshotMagic)) {
+		return decodeBinarySnapshot(data)
+	}
+	return decodeJSONSnapshot(data)
+}
+
+// snapshotReader reads the fields of a binary snapshot, remembering the
+// first that is cut short
+type snapshotReader struct {
+	b   []byte
+	err error
+}
+
+var errSnapshotShort = fmt.Errorf("%w: cut short", ErrSnapshotChecksum)
+
+func (r *snapshotReader) uvarint() uint64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(r.b)
+	if n <= 0 {
+		r.err = errSnapshotShort
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *snapshotReader) varint() int64 {
+	if r.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(r.b)
+	if n <= 0 {
+		r.err = errSnapshotShort
+		return 0
+	}
+	r.b = r.b[n:]
+	return v
+}
+
+func (r *snapshotReader) bytes() string {
+	n := r.uvarint()
+	if r.err != nil {
+		return ""
+	}
+	if n > uint64(len(r.b)) {
+		r.err = errSnapshotShort
+		return ""
+	}
+	s := string(r.b[:n])
+	r.b = r.b[n:]
+	return s
+}
+
+func decodeBinarySnapshot(data []byte) (snapshot BackupSnapshot, info SnapshotInfo, err error) {
+	info.Format = "binary"
+	if len(data) < len(snapshotMagic)+sha256.Size {
+		return snapshot, info, errSnapshotShort
+	}
+	body, sum := data[:len(data)-sha256.Size], data[len(data)-sha256.Size:]
+	r := &snapshotReader{b: body[len(snapshotMagic):]}
+	info.Version = int(r.uvarint())
+	if r.err == nil && info.Version > SnapshotVersion {
+		return snapshot, info, fmt.Errorf("%w: version %d, this release reads up to %d", ErrSnapshotVersion, info.Version, SnapshotVersion)
+	}
+	if want := sha256.Sum256(body); !bytes.Equal(sum, want[:]) {
+		return snapshot, info, ErrSnapshotChecksum
+	}
+	info.Checked = true
+	info.Created = time.Unix(0, r.varint())
+	if info.Version >= 2 {
+		info.Delta = r.uvarint() == 1
+		if base := r.varint(); info.Delta {
+			info.Base = time.Unix(0, base)
+		}
+		info.Seq = int(r.uvarint())
+	}
+	if info.Version >= 3 {
+		snapshot.WAL = r.uvarint()
+	}
+	count := r.uvarint()
+	if r.err == nil && count > uint64(len(r.b)) {
+		// every entry takes a few bytes; a count past that is damage
+		r.err = errSnapshotShort
+	}
+	snapshot.Data = make(map[string]KeyValue, int(count))
+	for i := uint64(0); i < count && r.err == nil; i++ {
+		key := r.bytes()
+		var kv KeyValue
+		kv.Value = r.bytes()
+		if ts := r.varint(); ts != 0 {
+			kv.Timestamp = time.Unix(0, ts)
+		}
+		kv.TTL = time.Duration(r.varint())
+		kv.Checksum = uint32(r.uvarint())
+		kv.Revision = r.uvarint()
+		kv.Type = ValueType(r.uvarint())
+		kv.Encrypted = r.uvarint()&1 != 0
+		snapshot.Data[key] = kv
+	}
+	if info.Version >= 2 {
+		deleted := r.uvarint()
+		if r.err == nil && deleted > uint64(len(r.b)) {
+			r.err = errSnapshotShort
+		}
+		for i := uint64(0); i < deleted && r.err == nil; i++ {
+			snapshot.Deleted = append(snapshot.Deleted, r.bytes())
+		}
+	}
+	if r.err != nil {
+		return snapshot, info, r.err
+	}
+	if len(r.b) > 0 || len(snapshot.Data) != int(count) {
+		return snapshot, info, fmt.Errorf("%w: %d entries, %d written", ErrSnapshotChecksum, len(snapshot.Data), count)
+	}
+	info.Keys = len(snapshot.Data)
+	return snapshot, info, nil
+}
+
+// jsonSnapshotFile is a snapshot as files held it before the binary
+// format: the data kept raw, so SHA256, its checksum in hex, covers the
+// exact bytes, and Count, how many entries it has. The oldest files have
+// neither.
+type jsonSnapshotFile struct {
+	Data   json.RawMessage `json:"data"`
+	Count  int             `json:"count"`
+	SHA256 string          `json:"sha256,omitempty"`
+}
+
+func decodeJSONSnapshot(data []byte) (snapshot BackupSnapshot, info SnapshotInfo, err error) {
+	info.Format = "json"
+	var file jsonSnapshotFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return snapshot, info, err
+	}
+	if file.SHA256 != "" {
+		if sum := sha256.Sum256(file.Data); hex.EncodeToString(sum[:]) != file.SHA256 {
+			return snapshot, info, ErrSnapshotChecksum
+		}
+	}
+	if err := json.Unmarshal(file.Data, &snapshot.Data); err != nil {
+		return snapshot, info, err
+	}
+	if file.SHA256 != "" && len(snapshot.Data) != file.Count {
+		return snapshot, info, fmt.Errorf("%w: %d entries, %d written", ErrSnapshotChecksum, len(snapshot.Data), file.Count)
+	}
+	info.Keys = len(snapshot.Data)
+	info.Checked = file.SHA256 != ""
+	return snapshot, info, nil
+}
@@ -0,0 +1,723 @@
+// Package kvstore is the embeddable key-value store: the TTL-aware
+// KeyValueStore, the caching ServerProxy in front of it, the expiry janitor,
+// JSON backups, advisory key locks, expiring counter windows, pinned keys
+// and key lifecycle events. It has no networking of its own, so it can be used inside any Go
+// program; pkg/server puts it behind TCP.
+package kvstore
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+const (
+	DefaultTTL = 15 * time.Second // TTL set to 5 minutes for all keys
+)
+
+// struct for keyvalue, a zero TTL means the store's default and a zero
+// Checksum that the writer sent none. Revision numbers the writes of the
+// store: every write of a key gives it a revision above any the store has
+// given before, so a key's revision changes exactly when it is written.
+// Type says what Value holds; a hash is kept as protocol.EncodeHash makes
+// it.
+type KeyValue struct {
+	Value     string
+	Timestamp time.Time
+	TTL       time.Duration `json:",omitempty"`
+	Checksum  uint32        `json:",omitempty"`
+	Revision  uint64        `json:",omitempty"`
+	Type      ValueType     `json:",omitempty"`
+	// Compressed marks a value kept compressed by the storage engine,
+	// which hands it out decompressed, see SetCompression
+	Compressed bool `json:",omitempty"`
+	// Encrypted marks a value sealed by the storage engine or in a
+	// snapshot, see SetEncryption
+	Encrypted bool `json:",omitempty"`
+	// Stamp and Origin are the Stamp of the write in a multi-primary
+	// store, see SetMultiPrimary
+	Stamp  uint64 `json:",omitempty"`
+	Origin string `json:",omitempty"`
+}
+
+// ValueType is the kind of value a key holds
+type ValueType uint8
+
+const (
+	// TypeString is a plain value, written by SET
+	TypeString ValueType = iota
+	// TypeHash is a map of fields to values, written by HSET
+	TypeHash
+	// TypeList is a sequence of values, written by LPUSH and RPUSH
+	TypeList
+	// TypeSet is a collection of distinct members, written by SADD
+	TypeSet
+	// TypeZSet is a set of members ordered by score, written by ZADD
+	TypeZSet
+	// TypeStream is a log of entries read by consumer groups, written by
+	// XADD
+	TypeStream
+	// TypeJSON is a JSON document, written by JSON.SET
+	TypeJSON
+	// TypeSequence is the last ID NEXTID handed out, a base-10 integer;
+	// it never expires or is evicted, and nothing but NEXTID writes it
+	TypeSequence
+)
+
+func (t ValueType) String() string {
+	switch t {
+	case TypeHash:
+		return "hash"
+	case TypeList:
+		return "list"
+	case TypeSet:
+		return "set"
+	case TypeZSet:
+		return "zset"
+	case TypeStream:
+		return "stream"
+	case TypeJSON:
+		return "json"
+	case TypeSequence:
+		return "sequence"
+	}
+	return "string"
+}
+
+// Intact reports whether the value still matches the checksum its writer
+// sent; values without one always do
+func (kv KeyValue) Intact() bool {
+	return kv.Checksum == 0 || protocol.Checksum(kv.Value) == kv.Checksum
+}
+
+// TTLMode says what an UPDATE does to the key's expiry
+type TTLMode int
+
+const (
+	// TTLDefault uses the store's UpdateTTLMode
+	TTLDefault TTLMode = iota
+	// TTLKeep leaves the key's remaining lifetime as it was
+	TTLKeep
+	// TTLReset restarts the key's TTL from the time of the update
+	TTLReset
+)
+
+// ParseTTLMode parses "keep" or "reset", the names printed by String
+func ParseTTLMode(name string) (TTLMode, error) {
+	switch name {
+	case "keep":
+		return TTLKeep, nil
+	case "reset":
+		return TTLReset, nil
+	}
+	return TTLDefault, fmt.Errorf("unknown TTL mode %q, expected keep or reset", name)
+}
+
+func (m TTLMode) String() string {
+	switch m {
+	case TTLKeep:
+		return "keep"
+	case TTLReset:
+		return "reset"
+	}
+	return "default"
+}
+
+// struct for keyvaluestore
+type KeyValueStore struct {
+	data       engine
+	clock      Clock // see SetClock
+	ttl        time.Duration
+	updateTTL  TTLMode
+	mu         sync.RWMutex
+	windows    counterWindows
+	pins       pinSet
+	events     *eventStream
+	stale      staleKeys // see ServerProxy
+	tracking   tracking  // see Track
+	namespaces namespaces
+	memory     memoryLimit // see SetMaxMemory
+	filter     keyFilter   // see SetBloomFilter
+	revision   uint64      // the last revision given to a write
+	reads      map[*ReadTx]bool
+	history    map[string][]version // entries open reads may still see
+	versions   versionLog           // see SetVersionHistory
+	trash      trash                // see SetSoftDelete
+	pushed     chan struct{}        // closed by the next list push, see Pushed
+	zsets      zsetCache
+
+	backupPath          string
+	backupInterval      time.Duration
+	backupsPaused       atomic.Bool
+	fileCipher          *FileCipher
+	backupKeep          int
+	backupWindow        time.Duration
+	snapshotCompression string
+	deltas              *deltaLog // see SetIncremental
+	wal                 *WAL      // see OpenWAL
+	walSync             WALSync   // see SetWALSync
+	walGrowth           int       // see SetWALRewrite
+	walMinSize          int64
+	walMark             uint64 // the last WAL record of the snapshot loaded
+	walRecovery         WALRecovery
+	repl                *replLog      // see ReplicationSnapshot
+	replBacklog         int           // see SetReplicationBacklog
+	multi               *multiPrimary // see SetMultiPrimary
+	backupMu            sync.Mutex    // one WriteBackup at a time
+	bgsave              bgSave
+	backupCron          *Cron
+	backupQuiet         QuietHours
+	lastBackup          atomic.Pointer[BackupResult]
+	nextBackup          atomic.Int64 // Unix nanoseconds, 0 if none is scheduled
+	sink                SnapshotSink
+}
+
+// to create  instance of class
+func NewKeyValueStore() *KeyValueStore {
+	kvs, _ := NewKeyValueStoreWithEngine(EngineMap)
+	return kvs
+}
+
+// NewKeyValueStoreWithEngine creates a store whose entries live in the named
+// engine: EngineMap, EngineArena for large read-mostly datasets, EngineMmap
+// for large values, or EngineDisk or EngineTiered for datasets larger than
+// memory
+func NewKeyValueStoreWithEngine(name string) (*KeyValueStore, error) {
+	data, err := newEngine(name)
+	if err != nil {
+		return nil, err
+	}
+	kvs := &KeyValueStore{
+		data:      data,
+		clock:     SystemClock,
+		ttl:       DefaultTTL,
+		updateTTL: TTLReset,
+		windows:   counterWindows{windows: make(map[string]*counterWindow)},
+		pins:      pinSet{keys: make(map[string]bool)},
+		events:    newEventStream(),
+
+		backupPath:     BackupFileName,
+		backupInterval: BackupInterval,
+
+		snapshotCompression: SnapshotUncompressed,
+	}
+	kvs.namespaces.filter = &kvs.filter
+	return kvs, nil
+}
+
+// CRUD
+
+// to get values from kvs
+func (kvs *KeyValueStore) GET(key string) (value string, found bool) {
+	value, _, found = kvs.GETSUM(key)
+	return value, found
+}
+
+// GETSUM is GET that also returns the value's checksum. A value that no
+// longer matches its checksum is not returned: value is then
+// protocol.MsgIntegrity and found is false. A key that holds a hash is
+// not returned either, value is then protocol.MsgWrongType.
+func (kvs *KeyValueStore) GETSUM(key string) (value string, sum uint32, found bool) {
+	item, found := kvs.GETKV(key)
+	if found && item.Type != TypeString {
+		return protocol.MsgWrongType, 0, false
+	}
+	return item.Value, item.Checksum, found
+}
+
+// GETKV is GETSUM returning the whole entry, with its revision and Type,
+// so a hash is returned as it is stored. If found is false only its Value
+// is set, to protocol.MsgNotFound or protocol.MsgIntegrity.
+func (kvs *KeyValueStore) GETKV(key string) (item KeyValue, found bool) {
+	if kvs.filter.absent(key) {
+		return KeyValue{Value: protocol.MsgNotFound}, false
+	}
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	item, ok := kvs.data.get(key)
+	if !ok {
+		return KeyValue{Value: protocol.MsgNotFound}, false
+	}
+	kvs.namespaces.touch(key)
+	if !item.Intact() {
+		return KeyValue{Value: protocol.MsgIntegrity}, false
+	}
+	return item, true
+}
+
+func (kvs *KeyValueStore) SET(key, value string) bool {
+	return kvs.SETEX(key, value, 0)
+}
+
+// SETEX sets key to expire after ttl instead of the store's default TTL
+func (kvs *KeyValueStore) SETEX(key, value string, ttl time.Duration) bool {
+	_, _, ok := kvs.SETSUM(key, value, ttl, 0)
+	return ok
+}
+
+// SETSUM is SETEX keeping sum, the value's protocol.Checksum, with it. A
+// value that does not match sum was damaged on its way here and is refused
+// with protocol.MsgIntegrity; a zero sum skips the check. A value that
+// would take the key's namespace over its limits is refused with
+// protocol.MsgQuotaExceeded. rev is the revision of the write.
+func (kvs *KeyValueStore) SETSUM(key, value string, ttl time.Duration, sum uint32) (rev uint64, message string, ok bool) {
+	_, item, _, message, ok := kvs.set(key, value, ttl, sum, nil)
+	return item.Revision, message, ok
+}
+
+// SETNX is SETSUM only if key does not exist, or has expired; otherwise
+// nothing is written and it returns protocol.MsgValueExists with the
+// existing key's revision
+func (kvs *KeyValueStore) SETNX(key, value string, ttl time.Duration, sum uint32) (rev uint64, message string, ok bool) {
+	old, item, _, message, ok := kvs.set(key, value, ttl, sum, func(_ KeyValue, live bool) string {
+		if live {
+			return protocol.MsgValueExists
+		}
+		return ""
+	})
+	if !ok {
+		return old.Revision, message, false
+	}
+	return item.Revision, message, true
+}
+
+// CAS is SETSUM only if key holds expect, or, if expectRev is above zero,
+// is at revision expectRev, compared and written in one step. It returns
+// the entry written, or if nothing is written protocol.MsgConflict with
+// the key's current entry, as GETKV would have returned it, in current. A
+// missing or expired key, or one whose value fails its checksum, holds
+// nothing that can match.
+func (kvs *KeyValueStore) CAS(key, expect string, expectRev uint64, value string, ttl time.Duration, sum uint32) (current KeyValue, found bool, message string, ok bool) {
+	_, item, _, message, ok := kvs.set(key, value, ttl, sum, func(old KeyValue, live bool) string {
+		if live && old.Type != TypeString {
+			return protocol.MsgWrongType
+		}
+		if live && old.Intact() {
+			if expectRev > 0 && old.Revision == expectRev || expectRev == 0 && old.Value == expect {
+				return ""
+			}
+			current, found = old, true
+		}
+		return protocol.MsgConflict
+	})
+	if !ok {
+		return current, found, message, false
+	}
+	return item, true, message, true
+}
+
+// GETSET is SETSUM that also returns the value it replaced, as GET would
+// have returned it; a replaced value that fails its checksum counts as
+// not found. A hash is not replaced: it fails with protocol.MsgWrongType.
+func (kvs *KeyValueStore) GETSET(key, value string, ttl time.Duration, sum uint32) (old string, found bool, message string, ok bool) {
+	item, _, replaced, message, ok := kvs.set(key, value, ttl, sum, func(old KeyValue, live bool) string {
+		if live && old.Type != TypeString {
+			return protocol.MsgWrongType
+		}
+		return ""
+	})
+	if !ok || !replaced || !item.Intact() {
+		return "", false, message, ok
+	}
+	return item.Value, true, message, ok
+}
+
+// set writes key, returning the entry it replaced and the one it wrote. If
+// cond is set it sees the entry first, live false if there is none or it
+// has expired, and refuses the write by returning a message.
+func (kvs *KeyValueStore) set(key, value string, ttl time.Duration, sum uint32, cond func(old KeyValue, live bool) string) (old, item KeyValue, replaced bool, message string, ok bool) {
+	item = KeyValue{Value: value, Timestamp: kvs.now(), TTL: ttl, Checksum: sum}
+	if !item.Intact() {
+		return old, item, false, protocol.MsgIntegrity, false
+	}
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if item.TTL <= 0 {
+		item.TTL = kvs.namespaces.ttl(key)
+	}
+	old, replaced = kvs.data.get(key)
+	if cond != nil {
+		if message = cond(old, replaced && !kvs.expired(old, item.Timestamp)); message != "" {
+			return old, item, replaced, message, false
+		}
+	}
+	if message = kvs.admit(key, old, replaced, item); message != "" {
+		return old, item, replaced, message, false
+	}
+	if replaced {
+		kvs.namespaces.remove(key, old)
+	}
+	kvs.revise(&item)
+	if replaced {
+		kvs.retire(key, old, item.Revision)
+	}
+	kvs.data.set(key, item)
+	kvs.namespaces.add(key, item)
+	kvs.emit(EventSet, key, value, item.Timestamp)
+	return old, item, replaced, protocol.MsgValueSet, true
+}
+
+// revise gives item the next revision, caller must hold kvs.mu
+func (kvs *KeyValueStore) revise(item *KeyValue) {
+	kvs.revision++
+	item.Revision = kvs.revision
+}
+
+// UPDATE replaces the value of an existing key; its expiry follows the
+// store's UpdateTTLMode
+func (kvs *KeyValueStore) UPDATE(key, value string) (message string, updated bool) {
+	_, message, updated = kvs.UPDATEEX(key, value, TTLDefault, 0, 0)
+	return message, updated
+}
+
+// UPDATEEX is UPDATE choosing what happens to the key's expiry: a ttl above
+// zero replaces the key's TTL from now, otherwise mode applies. sum is the
+// value's checksum and the value is refused over quota, see SETSUM. rev
+// is the revision of the update.
+func (kvs *KeyValueStore) UPDATEEX(key, value string, mode TTLMode, ttl time.Duration, sum uint32) (rev uint64, message string, updated bool) {
+	item, message, updated := kvs.update(key, value, mode, ttl, sum)
+	return item.Revision, message, updated
+}
+
+// update is UPDATEEX returning the entry written
+func (kvs *KeyValueStore) update(key, value string, mode TTLMode, ttl time.Duration, sum uint32) (item KeyValue, message string, updated bool) {
+	item = KeyValue{Value: value, Timestamp: kvs.now(), TTL: ttl, Checksum: sum}
+	if !item.Intact() {
+		return item, protocol.MsgIntegrity, false
+	}
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	old, ok := kvs.data.get(key)
+	if !ok {
+		return item, protocol.MsgValueNotExist, false
+	}
+	if old.Type != TypeString {
+		return item, protocol.MsgWrongType, false
+	}
+	if ttl <= 0 {
+		if mode == TTLDefault {
+			mode = kvs.updateTTL
+		}
+		item.TTL = old.TTL
+		if mode == TTLKeep {
+			item.Timestamp = old.Timestamp
+		}
+	}
+	if message = kvs.admit(key, old, true, item); message != "" {
+		return item, message, false
+	}
+	kvs.namespaces.remove(key, old)
+	kvs.revise(&item)
+	kvs.retire(key, old, item.Revision)
+	kvs.data.set(key, item)
+	kvs.namespaces.add(key, item)
+	kvs.emit(EventUpdate, key, value, kvs.now())
+	return item, protocol.MsgValueUpdated, true
+}
+
+func (kvs *KeyValueStore) DELETE(key string) (message string, deleted bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	old, ok := kvs.data.get(key)
+	if !ok {
+		return protocol.MsgValueNotExist, false
+	}
+	if old.Type == TypeSequence {
+		return protocol.MsgWrongType, false
+	}
+	kvs.revision++
+	kvs.retire(key, old, kvs.revision)
+	kvs.data.delete(key)
+	kvs.namespaces.remove(key, old)
+	kvs.trashKey(key, old, kvs.now())
+	kvs.emit(EventDelete, key, "", kvs.now())
+	return protocol.MsgValueDeleted, true
+}
+
+// GETDEL deletes key and returns the value it had, as GET would have
+// returned it, in one step. found reports whether key existed; its value
+// is protocol.MsgIntegrity if it failed its checksum, and is deleted all
+// the same. A hash is not deleted: value is then protocol.MsgWrongType and
+// found false.
+func (kvs *KeyValueStore) GETDEL(key string) (value string, found bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	old, ok := kvs.data.get(key)
+	if !ok {
+		return protocol.MsgNotFound, false
+	}
+	if old.Type != TypeString {
+		return protocol.MsgWrongType, false
+	}
+	kvs.revision++
+	kvs.retire(key, old, kvs.revision)
+	kvs.data.delete(key)
+	kvs.namespaces.remove(key, old)
+	kvs.trashKey(key, old, kvs.now())
+	kvs.emit(EventDelete, key, "", kvs.now())
+	if !old.Intact() {
+		return protocol.MsgIntegrity, true
+	}
+	return old.Value, true
+}
+
+// RENAME moves the value of src, with its checksum and remaining lifetime,
+// to dst, replacing dst if it exists. Both happen under one lock, so no
+// reader sees both keys or neither. The value is refused if it would take
+// dst's namespace over its limits, see SETSUM.
+func (kvs *KeyValueStore) RENAME(src, dst string) (message string, renamed bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	item, ok := kvs.data.get(src)
+	if !ok {
+		return protocol.MsgValueNotExist, false
+	}
+	if item.Type == TypeSequence {
+		return protocol.MsgWrongType, false
+	}
+	if src == dst {
+		return protocol.MsgValueRenamed, true
+	}
+	// src's bytes are freed by the move, which matters if both are in the
+	// same namespace
+	kvs.namespaces.remove(src, item)
+	old, replaced := kvs.data.get(dst)
+	if message = kvs.admit(dst, old, replaced, item, src); message != "" {
+		kvs.namespaces.add(src, item)
+		return message, false
+	}
+	if replaced {
+		kvs.namespaces.remove(dst, old)
+	}
+	kvs.data.delete(src)
+	moved := item
+	kvs.revise(&item)
+	kvs.retire(src, moved, item.Revision)
+	if replaced {
+		kvs.retire(dst, old, item.Revision)
+	}
+	kvs.data.set(dst, item)
+	kvs.namespaces.add(dst, item)
+	now := kvs.now()
+	kvs.emit(EventDelete, src, "", now)
+	kvs.emit(EventSet, dst, item.Value, now)
+	return protocol.MsgValueRenamed, true
+}
+
+// INCRBY adds delta to the base-10 integer in key's value, taking a
+// missing key as 0, and returns the result; see modify for its lifetime
+// and checksum. A value that is not an int64, or a result that overflows
+// one, fails with protocol.MsgNotInteger.
+func (kvs *KeyValueStore) INCRBY(key string, delta int64, ttl time.Duration) (n int64, message string, ok bool) {
+	_, message, ok = kvs.modify(key, ttl, func(value string, live bool) (string, string) {
+		if live {
+			var err error
+			if n, err = strconv.ParseInt(value, 10, 64); err != nil {
+				return "", protocol.MsgNotInteger
+			}
+		}
+		if delta > 0 && n > math.MaxInt64-delta || delta < 0 && n < math.MinInt64-delta {
+			return "", protocol.MsgNotInteger
+		}
+		n += delta
+		return strconv.FormatInt(n, 10), ""
+	})
+	if !ok {
+		return 0, message, false
+	}
+	return n, protocol.MsgIncremented, true
+}
+
+// APPEND adds value to the end of key's value, taking a missing key as
+// empty, and returns the new length in bytes; see modify for its lifetime
+// and checksum
+func (kvs *KeyValueStore) APPEND(key, value string, ttl time.Duration) (length int, message string, ok bool) {
+	value, message, ok = kvs.modify(key, ttl, func(old string, _ bool) (string, string) {
+		return old + value, ""
+	})
+	if !ok {
+		return 0, message, false
+	}
+	return len(value), protocol.MsgAppended, true
+}
+
+// modify sets key to what fn makes of its value, "" and live false if the
+// key is missing or expired, all under the store's lock; fn refuses by
+// returning a message, which modify returns. The key keeps its remaining
+// lifetime and, if it had a checksum, gets one of the new value; a new key
+// gets ttl as in SETSUM. The write is refused over quota.
+func (kvs *KeyValueStore) modify(key string, ttl time.Duration, fn func(value string, live bool) (string, string)) (value, message string, ok bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	item := KeyValue{Timestamp: now, TTL: ttl}
+	old, exists := kvs.data.get(key)
+	live := exists && !kvs.expired(old, now)
+	current := ""
+	if live {
+		if old.Type != TypeString {
+			return "", protocol.MsgWrongType, false
+		}
+		if !old.Intact() {
+			return "", protocol.MsgIntegrity, false
+		}
+		current = old.Value
+		item.Timestamp, item.TTL = old.Timestamp, old.TTL
+	} else if item.TTL <= 0 {
+		item.TTL = kvs.namespaces.ttl(key)
+	}
+	if item.Value, message = fn(current, live); message != "" {
+		return "", message, false
+	}
+	if live && old.Checksum != 0 {
+		item.Checksum = protocol.Checksum(item.Value)
+	}
+	if message = kvs.admit(key, old, exists, item); message != "" {
+		return "", message, false
+	}
+	if exists {
+		kvs.namespaces.remove(key, old)
+	}
+	kvs.revise(&item)
+	if exists {
+		kvs.retire(key, old, item.Revision)
+	}
+	kvs.data.set(key, item)
+	kvs.namespaces.add(key, item)
+	event := EventSet
+	if live {
+		event = EventUpdate
+	}
+	kvs.emit(event, key, item.Value, now)
+	return item.Value, "", true
+}
+
+// COPY sets dst to the value of src, with its checksum, replacing dst if
+// it exists. A ttl above zero gives the copy that TTL from now, otherwise
+// it expires with src. The copy is refused over quota, see SETSUM.
+func (kvs *KeyValueStore) COPY(src, dst string, ttl time.Duration) (message string, copied bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	item, ok := kvs.data.get(src)
+	if !ok {
+		return protocol.MsgValueNotExist, false
+	}
+	if item.Type == TypeSequence {
+		// two sequences from one would hand out the same IDs
+		return protocol.MsgWrongType, false
+	}
+	if src == dst {
+		return protocol.MsgValueCopied, true
+	}
+	if ttl > 0 {
+		item.Timestamp, item.TTL = kvs.now(), ttl
+	}
+	old, replaced := kvs.data.get(dst)
+	if message = kvs.admit(dst, old, replaced, item, src); message != "" {
+		return message, false
+	}
+	if replaced {
+		kvs.namespaces.remove(dst, old)
+	}
+	kvs.revise(&item)
+	if replaced {
+		kvs.retire(dst, old, item.Revision)
+	}
+	kvs.data.set(dst, item)
+	kvs.namespaces.add(dst, item)
+	kvs.emit(EventSet, dst, item.Value, kvs.now())
+	return protocol.MsgValueCopied, true
+}
+
+// Len returns the number of keys in kvs
+func (kvs *KeyValueStore) Len() int {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.data.len()
+}
+
+// DBSIZE counts the live keys, leaving out expired ones the janitor has
+// not removed yet, in all and by namespace prefix
+func (kvs *KeyValueStore) DBSIZE() (total int, byNamespace map[string]int) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	byNamespace = make(map[string]int, len(kvs.namespaces.prefixes))
+	for _, prefix := range kvs.namespaces.prefixes {
+		byNamespace[prefix] = 0
+	}
+	now := kvs.now()
+	kvs.data.eachExpiry(func(key string, item KeyValue) bool {
+		if kvs.expired(item, now) {
+			return true
+		}
+		total++
+		if ns := kvs.namespaces.of(key); ns != nil {
+			byNamespace[ns.Prefix]++
+		}
+		return true
+	})
+	return total, byNamespace
+}
+
+// expired reports whether item has outlived its TTL, caller must hold kvs.mu
+func (kvs *KeyValueStore) expired(item KeyValue, now time.Time) bool {
+	if item.Type == TypeSequence {
+		// restarting it would hand out its IDs again
+		return false
+	}
+	ttl := item.TTL
+	if ttl <= 0 {
+		ttl = kvs.ttl
+	}
+	return now.Sub(item.Timestamp) > ttl
+}
+
+// Engine returns the name of the storage engine
+func (kvs *KeyValueStore) Engine() string {
+	return kvs.data.name()
+}
+
+// TTL returns how long keys live before the janitor removes them
+func (kvs *KeyValueStore) TTL() time.Duration {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.ttl
+}
+
+// SetTTL changes how long keys live before the janitor removes them
+func (kvs *KeyValueStore) SetTTL(ttl time.Duration) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.ttl = ttl
+}
+
+// UpdateTTLMode returns what an UPDATE that doesn't say otherwise does to
+// the key's expiry
+func (kvs *KeyValueStore) UpdateTTLMode() TTLMode {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.updateTTL
+}
+
+// SetUpdateTTLMode changes what an UPDATE that doesn't say otherwise does
+// to the key's expiry: TTLReset, the initial mode, or TTLKeep
+func (kvs *KeyValueStore) SetUpdateTTLMode(mode TTLMode) {
+	if mode == TTLDefault {
+		mode = TTLReset
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.updateTTL = mode
+}
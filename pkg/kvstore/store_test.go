@@ -0,0 +1,102 @@
+package kvstore
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestSetGetDelete(t *testing.T) {
+	kvs := NewKeyValueStore()
+	if !kvs.SET("k", "v") {
+		t.Fatal("SET failed")
+	}
+	if value, found := kvs.GET("k"); !found || value != "v" {
+		t.Fatalf("GET = %q, %v; want v", value, found)
+	}
+	if message, updated := kvs.UPDATE("k", "w"); !updated {
+		t.Fatalf("UPDATE: %s", message)
+	}
+	if message, deleted := kvs.DELETE("k"); !deleted {
+		t.Fatalf("DELETE: %s", message)
+	}
+	if value, found := kvs.GET("k"); found || value != protocol.MsgNotFound {
+		t.Fatalf("GET after DELETE = %q, %v", value, found)
+	}
+	if message, updated := kvs.UPDATE("k", "x"); updated || message != protocol.MsgValueNotExist {
+		t.Fatalf("UPDATE of a deleted key = %s, %v", message, updated)
+	}
+}
+
+func BenchmarkStoreGET(b *testing.B) {
+	kvs := newBenchProxy(b).kvs
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kvs.GET(benchKey(i))
+	}
+}
+
+func BenchmarkStoreSET(b *testing.B) {
+	for _, size := range []int{16, 1024, 64 << 10} {
+		b.Run(fmt.Sprintf("size=%d", size), func(b *testing.B) {
+			kvs := NewKeyValueStore()
+			value := strings.Repeat("v", size)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				kvs.SET(benchKey(i), value)
+			}
+		})
+	}
+}
+
+func BenchmarkStoreUPDATE(b *testing.B) {
+	kvs := newBenchProxy(b).kvs
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kvs.UPDATE(benchKey(i), "updated")
+	}
+}
+
+func BenchmarkStoreINCRBY(b *testing.B) {
+	kvs := NewKeyValueStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		kvs.INCRBY(benchKey(i), 1, 0)
+	}
+}
+
+func BenchmarkStoreSETDELETE(b *testing.B) {
+	kvs := NewKeyValueStore()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := benchKey(i)
+		kvs.SET(key, "value")
+		kvs.DELETE(key)
+	}
+}
+
+// BenchmarkStoreMixed is nine GETs to every SET from parallel goroutines,
+// the mix kvs-bench runs by default
+func BenchmarkStoreMixed(b *testing.B) {
+	kvs := newBenchProxy(b).kvs
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for i := 0; pb.Next(); i++ {
+			if i%10 == 0 {
+				kvs.SET(benchKey(i), "value")
+			} else {
+				kvs.GET(benchKey(i))
+			}
+		}
+	})
+}
+
+func BenchmarkProxySET(b *testing.B) {
+	sp := newBenchProxy(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		sp.SET(benchKey(i), "value")
+	}
+}
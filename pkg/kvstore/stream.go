@@ -0,0 +1,282 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// streamID is the ID of a stream entry: the time it was added in
+// milliseconds, and a sequence number among the entries added in that
+// millisecond
+type streamID struct {
+	ms, seq uint64
+}
+
+// parseStreamID reads an ID as "ms-seq" or "ms"; "" is the ID before every
+// entry
+func parseStreamID(s string) (streamID, bool) {
+	if s == "" {
+		return streamID{}, true
+	}
+	ms, seq, hasSeq := strings.Cut(s, "-")
+	var id streamID
+	var err error
+	if id.ms, err = strconv.ParseUint(ms, 10, 64); err != nil {
+		return streamID{}, false
+	}
+	if hasSeq {
+		if id.seq, err = strconv.ParseUint(seq, 10, 64); err != nil {
+			return streamID{}, false
+		}
+	}
+	return id, true
+}
+
+func (id streamID) String() string {
+	return fmt.Sprintf("%d-%d", id.ms, id.seq)
+}
+
+func (id streamID) before(other streamID) bool {
+	return id.ms < other.ms || id.ms == other.ms && id.seq < other.seq
+}
+
+// entryID is the ID of e; IDs are checked when the stream is decoded
+func entryID(e protocol.StreamEntry) streamID {
+	id, _ := parseStreamID(e.ID)
+	return id
+}
+
+// entriesAfter returns up to count of entries, all if count is zero, with
+// an ID after after; entries are in ID order
+func entriesAfter(entries []protocol.StreamEntry, after streamID, count int) []protocol.StreamEntry {
+	i := sort.Search(len(entries), func(i int) bool { return after.before(entryID(entries[i])) })
+	entries = entries[i:]
+	if count > 0 && len(entries) > count {
+		entries = entries[:count]
+	}
+	return entries
+}
+
+// entryAt returns the entry of entries with ID id; entries are in ID order
+func entryAt(entries []protocol.StreamEntry, id streamID) (protocol.StreamEntry, bool) {
+	i := sort.Search(len(entries), func(i int) bool { return !entryID(entries[i]).before(id) })
+	if i == len(entries) || entryID(entries[i]) != id {
+		return protocol.StreamEntry{}, false
+	}
+	return entries[i], true
+}
+
+// XADD appends an entry with fields, each field followed by its value, to
+// the stream at key, creating it with ttl, or the store's default, if it
+// is missing or expired; an existing stream keeps its remaining lifetime.
+// id is the ID it was given, after every ID before. A maxLen above zero
+// caps the stream at that many entries from now on, dropping the oldest,
+// along with their pending deliveries. item is the entry written, its
+// Value the whole stream as protocol.EncodeStream makes it. A key that
+// holds another type fails with protocol.MsgWrongType, and the write is
+// refused over quota, see SETSUM.
+func (kvs *KeyValueStore) XADD(key string, fields []string, maxLen int, ttl time.Duration) (id string, item KeyValue, message string, ok bool) {
+	item, message, ok = kvs.modifyStream(key, ttl, func(s *protocol.Stream) (bool, string) {
+		last, _ := parseStreamID(s.LastID)
+		next := streamID{ms: uint64(kvs.now().UnixMilli())}
+		if !last.before(next) {
+			next = streamID{last.ms, last.seq + 1}
+		}
+		id = next.String()
+		s.LastID = id
+		s.Entries = append(s.Entries, protocol.StreamEntry{ID: id, Fields: fields})
+		if maxLen > 0 {
+			s.MaxLen = maxLen
+		}
+		trimStream(s)
+		return true, ""
+	})
+	return id, item, message, ok
+}
+
+// XREAD returns up to count entries, all if count is zero, of the stream
+// at key with an ID after after; found is false if it is missing. An
+// after that is no ID fails with protocol.MsgInvalidID, and a key that
+// holds another type with protocol.MsgWrongType.
+func (kvs *KeyValueStore) XREAD(key, after string, count int) (entries []protocol.StreamEntry, found bool, message string) {
+	from, ok := parseStreamID(after)
+	if !ok {
+		return nil, false, protocol.MsgInvalidID
+	}
+	value, found, message := kvs.readTyped(key, TypeStream)
+	if !found {
+		return nil, false, message
+	}
+	s, err := decodeStream(value)
+	if err != nil {
+		return nil, false, protocol.MsgIntegrity
+	}
+	return entriesAfter(s.Entries, from, count), true, ""
+}
+
+// XGROUP creates the consumer group group on the stream at key, creating
+// the stream, see XADD, if it is missing. The group delivers the entries
+// with an ID after start, or those added from now on if start is "". A
+// group that exists fails with protocol.MsgGroupExists.
+func (kvs *KeyValueStore) XGROUP(key, group, start string, ttl time.Duration) (item KeyValue, message string, ok bool) {
+	from, valid := parseStreamID(start)
+	if !valid {
+		return KeyValue{}, protocol.MsgInvalidID, false
+	}
+	return kvs.modifyStream(key, ttl, func(s *protocol.Stream) (bool, string) {
+		if streamGroup(s, group) != nil {
+			return false, protocol.MsgGroupExists
+		}
+		last := s.LastID
+		if start != "" {
+			last = from.String()
+		}
+		s.Groups = append(s.Groups, protocol.StreamGroup{Name: group, LastID: last})
+		return true, ""
+	})
+}
+
+// XREADGROUP delivers up to count entries, all if count is zero, of the
+// stream at key to consumer of group. With after "" they are the next
+// entries the group has delivered to no one, which stay pending for
+// consumer until XACK; item is then the entry written, empty if there
+// were none. Otherwise they are the entries pending for consumer with an
+// ID after after, which changes nothing. A missing group fails with
+// protocol.MsgNoGroup.
+func (kvs *KeyValueStore) XREADGROUP(key, group, consumer, after string, count int) (entries []protocol.StreamEntry, item KeyValue, message string, ok bool) {
+	from, valid := parseStreamID(after)
+	if !valid {
+		return nil, KeyValue{}, protocol.MsgInvalidID, false
+	}
+	item, message, ok = kvs.modifyStream(key, 0, func(s *protocol.Stream) (bool, string) {
+		g := streamGroup(s, group)
+		if g == nil {
+			return false, protocol.MsgNoGroup
+		}
+		if after == "" {
+			last, _ := parseStreamID(g.LastID)
+			entries = entriesAfter(s.Entries, last, count)
+			for _, e := range entries {
+				g.Pending = append(g.Pending, protocol.PendingEntry{ID: e.ID, Consumer: consumer})
+			}
+			if len(entries) > 0 {
+				g.LastID = entries[len(entries)-1].ID
+			}
+			return len(entries) > 0, ""
+		}
+		for _, p := range g.Pending {
+			if count > 0 && len(entries) >= count {
+				break
+			}
+			id, _ := parseStreamID(p.ID)
+			if p.Consumer != consumer || !from.before(id) {
+				continue
+			}
+			if e, ok := entryAt(s.Entries, id); ok {
+				entries = append(entries, e)
+			}
+		}
+		return false, ""
+	})
+	return entries, item, message, ok
+}
+
+// XACK acknowledges the entries with ids for group of the stream at key,
+// so they are no longer pending, and returns how many of them were; if
+// none, nothing is written. A missing group fails with protocol.MsgNoGroup.
+func (kvs *KeyValueStore) XACK(key, group string, ids []string) (acked int, item KeyValue, message string, ok bool) {
+	item, message, ok = kvs.modifyStream(key, 0, func(s *protocol.Stream) (bool, string) {
+		g := streamGroup(s, group)
+		if g == nil {
+			return false, protocol.MsgNoGroup
+		}
+		done := make(map[streamID]bool, len(ids))
+		for _, id := range ids {
+			if id, ok := parseStreamID(id); ok {
+				done[id] = true
+			}
+		}
+		kept := g.Pending[:0]
+		for _, p := range g.Pending {
+			if id, _ := parseStreamID(p.ID); done[id] {
+				acked++
+				continue
+			}
+			kept = append(kept, p)
+		}
+		g.Pending = kept
+		return acked > 0, ""
+	})
+	return acked, item, message, ok
+}
+
+// streamGroup returns the group of s named name, nil if there is none
+func streamGroup(s *protocol.Stream, name string) *protocol.StreamGroup {
+	for i := range s.Groups {
+		if s.Groups[i].Name == name {
+			return &s.Groups[i]
+		}
+	}
+	return nil
+}
+
+// trimStream drops the oldest entries of s past its MaxLen, and their
+// pending deliveries
+func trimStream(s *protocol.Stream) {
+	if s.MaxLen <= 0 || len(s.Entries) <= s.MaxLen {
+		return
+	}
+	drop := len(s.Entries) - s.MaxLen
+	last := entryID(s.Entries[drop-1])
+	s.Entries = s.Entries[drop:]
+	for i := range s.Groups {
+		g := &s.Groups[i]
+		kept := g.Pending[:0]
+		for _, p := range g.Pending {
+			if id, _ := parseStreamID(p.ID); last.before(id) {
+				kept = append(kept, p)
+			}
+		}
+		g.Pending = kept
+	}
+}
+
+// decodeStream is protocol.DecodeStream that also checks every ID, ""
+// being an empty stream
+func decodeStream(value string) (protocol.Stream, error) {
+	if value == "" {
+		return protocol.Stream{}, nil
+	}
+	s, err := protocol.DecodeStream(value)
+	if err != nil {
+		return s, err
+	}
+	for _, e := range s.Entries {
+		if _, ok := parseStreamID(e.ID); !ok {
+			return s, fmt.Errorf("stream entry ID %q", e.ID)
+		}
+	}
+	return s, nil
+}
+
+// modifyStream is modifyTyped for the stream at key, decoded for fn. A
+// stream is never empty, as it keeps its last ID, so it is not deleted.
+func (kvs *KeyValueStore) modifyStream(key string, ttl time.Duration, fn func(s *protocol.Stream) (changed bool, message string)) (item KeyValue, message string, ok bool) {
+	item, _, message, ok = kvs.modifyTyped(key, TypeStream, ttl, func(value string) (string, bool, string) {
+		s, err := decodeStream(value)
+		if err != nil {
+			return "", false, protocol.MsgIntegrity
+		}
+		changed, message := fn(&s)
+		if message != "" || !changed {
+			return "", false, message
+		}
+		return protocol.EncodeStream(s), true, ""
+	})
+	return item, message, ok
+}
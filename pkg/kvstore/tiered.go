@@ -0,0 +1,251 @@
+package kvstore
+
+import (
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// DefaultHotKeys is how many keys a tiered engine keeps in memory unless
+// SetHotKeys says otherwise
+const DefaultHotKeys = 100000
+
+// tierSample is how many hot keys a demotion compares to pick the least
+// recently used, as Redis approximates LRU
+const tierSample = 5
+
+// hotEntry is a key of a tiered engine kept in memory, with when it was
+// last read or written
+type hotEntry struct {
+	kv     KeyValue
+	access atomic.Int64 // Unix nanoseconds
+}
+
+func newHotEntry(kv KeyValue) *hotEntry {
+	e := &hotEntry{kv: kv}
+	e.access.Store(time.Now().UnixNano())
+	return e
+}
+
+// tieredEngine keeps the most recently used keys, up to its limit, in
+// memory as mapEngine does and demotes the rest to a diskEngine. A read of
+// a cold key is served from disk and promotes the key back, once the
+// store next takes its write lock: reads run under the read lock, so they
+// only note the keys to promote, and the next write, or the janitor within
+// ClearInterval, moves them.
+type tieredEngine struct {
+	spec       string
+	limit      int
+	hot        buckets[*hotEntry]
+	cold       *diskEngine
+	mu         sync.Mutex // guards pending, which reads add to
+	pending    map[string]bool
+	coldReads  atomic.Int64
+	promotions int64
+	demotions  int64
+}
+
+func newTieredEngine(spec, dir string) (*tieredEngine, error) {
+	cold, err := newDiskEngine(spec, dir)
+	if err != nil {
+		return nil, err
+	}
+	return &tieredEngine{spec: spec, limit: DefaultHotKeys, cold: cold}, nil
+}
+
+func (t *tieredEngine) name() string { return t.spec }
+
+func (t *tieredEngine) get(key string) (KeyValue, bool) {
+	if e, ok := t.hot.get(key); ok {
+		e.access.Store(time.Now().UnixNano())
+		return e.kv, true
+	}
+	kv, ok := t.cold.get(key)
+	if !ok {
+		return kv, false
+	}
+	t.coldReads.Add(1)
+	t.mu.Lock()
+	// a scan of the cold keys doesn't queue more than fit
+	if len(t.pending) < t.limit {
+		if t.pending == nil {
+			t.pending = make(map[string]bool)
+		}
+		t.pending[key] = true
+	}
+	t.mu.Unlock()
+	return kv, true
+}
+
+func (t *tieredEngine) set(key string, kv KeyValue) {
+	t.settle()
+	if e, ok := t.hot.get(key); ok {
+		e.kv = kv
+		e.access.Store(time.Now().UnixNano())
+		return
+	}
+	t.cold.delete(key)
+	t.hot.set(key, newHotEntry(kv))
+	t.demote()
+}
+
+func (t *tieredEngine) delete(key string) {
+	t.settle()
+	if _, ok := t.hot.get(key); ok {
+		t.hot.delete(key)
+		return
+	}
+	t.cold.delete(key)
+}
+
+func (t *tieredEngine) len() int { return t.hot.len() + t.cold.len() }
+
+// a hot entry is a map entry holding a pointer to the KeyValue and its
+// access time; cold entries cost what a disk engine's do, and the mix is
+// weighted by how many of each there are
+func (t *tieredEngine) overhead() int64 {
+	hot := int64(unsafe.Sizeof("") + unsafe.Sizeof(&hotEntry{}) + unsafe.Sizeof(hotEntry{}) + 8)
+	n := int64(t.len())
+	if n == 0 {
+		return hot
+	}
+	return (hot*int64(t.hot.len()) + t.cold.overhead()*int64(t.cold.len())) / n
+}
+
+func (t *tieredEngine) each(fn func(key string, kv KeyValue) bool) {
+	more := true
+	t.hot.each(func(key string, e *hotEntry) bool {
+		more = fn(key, e.kv)
+		return more
+	})
+	if more {
+		t.cold.each(fn)
+	}
+}
+
+func (t *tieredEngine) eachExpiry(fn func(key string, kv KeyValue) bool) {
+	more := true
+	t.hot.each(func(key string, e *hotEntry) bool {
+		more = fn(key, KeyValue{Timestamp: e.kv.Timestamp, TTL: e.kv.TTL})
+		return more
+	})
+	if more {
+		t.cold.eachExpiry(fn)
+	}
+}
+
+func (t *tieredEngine) eachExpiryIn(bucket int, fn func(key string, kv KeyValue) bool) {
+	more := true
+	t.hot.eachIn(bucket, func(key string, e *hotEntry) bool {
+		more = fn(key, KeyValue{Timestamp: e.kv.Timestamp, TTL: e.kv.TTL})
+		return more
+	})
+	if more {
+		t.cold.eachExpiryIn(bucket, fn)
+	}
+}
+
+// compact promotes the keys read since the last write, demotes what is
+// over the limit and compacts the cold tier
+func (t *tieredEngine) compact() {
+	t.settle()
+	t.cold.compact()
+}
+
+// settle promotes the cold keys read since it last ran, then demotes keys
+// until the hot tier fits its limit
+func (t *tieredEngine) settle() {
+	t.mu.Lock()
+	pending := t.pending
+	t.pending = nil
+	t.mu.Unlock()
+	for key := range pending {
+		kv, ok := t.cold.get(key)
+		if !ok {
+			continue // deleted since
+		}
+		t.cold.delete(key)
+		t.hot.set(key, newHotEntry(kv))
+		t.promotions++
+	}
+	t.demote()
+}
+
+// demote moves the least recently used of a sample of hot keys to the
+// cold tier until the hot tier fits its limit
+func (t *tieredEngine) demote() {
+	for t.hot.len() > t.limit {
+		var victim string
+		var oldest int64
+		n := 0
+		start := rand.Intn(ScanBuckets)
+		for i := 0; i < ScanBuckets && n < tierSample; i++ {
+			t.hot.eachIn((start+i)%ScanBuckets, func(key string, e *hotEntry) bool {
+				if access := e.access.Load(); n == 0 || access < oldest {
+					victim, oldest = key, access
+				}
+				n++
+				return n < tierSample
+			})
+		}
+		e, _ := t.hot.get(victim)
+		t.hot.delete(victim)
+		t.cold.set(victim, e.kv)
+		t.demotions++
+	}
+}
+
+// tierOf returns the tiered engine under e, nil if there is none
+func tierOf(e engine) *tieredEngine {
+	t, _ := storeEngineOf(e).(*tieredEngine)
+	return t
+}
+
+// SetHotKeys makes a tiered engine keep at most n keys in memory, demoting
+// the least recently used at once if it has more; n <= 0 restores
+// DefaultHotKeys. Other engines ignore it.
+func (kvs *KeyValueStore) SetHotKeys(n int) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	t := tierOf(kvs.data)
+	if t == nil {
+		return
+	}
+	if n <= 0 {
+		n = DefaultHotKeys
+	}
+	t.limit = n
+	t.settle()
+}
+
+// TieringStats describes a tiered engine: the most keys it keeps in
+// memory, how many are Hot, in memory, and Cold, on disk, how many reads
+// went to disk, and how many keys moved each way
+type TieringStats struct {
+	HotLimit   int
+	Hot        int
+	Cold       int
+	ColdReads  int64
+	Promotions int64
+	Demotions  int64
+}
+
+// Tiering returns the state of a tiered engine, all zero for the others
+func (kvs *KeyValueStore) Tiering() TieringStats {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	t := tierOf(kvs.data)
+	if t == nil {
+		return TieringStats{}
+	}
+	return TieringStats{
+		HotLimit:   t.limit,
+		Hot:        t.hot.len(),
+		Cold:       t.cold.len(),
+		ColdReads:  t.coldReads.Load(),
+		Promotions: t.promotions,
+		Demotions:  t.demotions,
+	}
+}
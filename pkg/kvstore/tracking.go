@@ -0,0 +1,238 @@
+package kvstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Limits of the client tracking of Track and Invalidations
+const (
+	// MaxTrackedKeys is how many keys one client may cache, and how many
+	// invalidations may wait for it, before it is told to drop its whole
+	// cache instead
+	MaxTrackedKeys = 100000
+	// DefaultInvalidationWait and MaxInvalidationWait bound how long
+	// Invalidations waits for a change
+	DefaultInvalidationWait = 25 * time.Second
+	MaxInvalidationWait     = time.Minute
+	// TrackingIdle is how long a client that stopped calling Invalidations
+	// stays tracked
+	TrackingIdle = 2 * MaxInvalidationWait
+)
+
+// tracking remembers which keys each client caches, for Track
+type tracking struct {
+	on      atomic.Bool // a client is tracked
+	mu      sync.Mutex
+	clients map[string]*trackedClient
+	byKey   map[string]map[*trackedClient]bool
+}
+
+// trackedClient is a client's cached keys and the invalidations it has yet
+// to be told of
+type trackedClient struct {
+	keys    map[string]bool
+	pending []string
+	all     bool          // drop every key
+	notify  chan struct{} // closed once pending or all is set
+	seen    time.Time
+}
+
+// TrackingStats is how many clients are tracked and how many keys they
+// cache between them
+type TrackingStats struct {
+	Clients int
+	Keys    int
+}
+
+// client returns the tracked client id, adding it if it is new; caller
+// must hold t.mu
+func (t *tracking) client(id string, now time.Time) (c *trackedClient, added bool) {
+	if c = t.clients[id]; c != nil {
+		c.seen = now
+		return c, false
+	}
+	if t.clients == nil {
+		t.clients = make(map[string]*trackedClient)
+		t.byKey = make(map[string]map[*trackedClient]bool)
+	}
+	c = &trackedClient{keys: make(map[string]bool), notify: make(chan struct{}), seen: now}
+	t.clients[id] = c
+	t.on.Store(true)
+	return c, true
+}
+
+// invalidate tells c that key changed; caller must hold t.mu
+func (t *tracking) invalidate(c *trackedClient, key string) {
+	delete(c.keys, key)
+	if c.all {
+		return
+	}
+	if len(c.pending) >= MaxTrackedKeys {
+		t.flush(c)
+		return
+	}
+	if len(c.pending) == 0 {
+		close(c.notify)
+	}
+	c.pending = append(c.pending, key)
+}
+
+// flush tells c to drop every key; caller must hold t.mu
+func (t *tracking) flush(c *trackedClient) {
+	for key := range c.keys {
+		t.untrack(c, key)
+	}
+	if !c.all && len(c.pending) == 0 {
+		close(c.notify)
+	}
+	c.keys, c.pending, c.all = make(map[string]bool), nil, true
+}
+
+// untrack forgets that c caches key; caller must hold t.mu
+func (t *tracking) untrack(c *trackedClient, key string) {
+	if cs := t.byKey[key]; cs != nil {
+		delete(cs, c)
+		if len(cs) == 0 {
+			delete(t.byKey, key)
+		}
+	}
+}
+
+// changed tells the clients caching key that it changed; the store calls
+// it for every change, under its lock
+func (t *tracking) changed(key string) {
+	if !t.on.Load() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for c := range t.byKey[key] {
+		t.invalidate(c, key)
+	}
+	delete(t.byKey, key)
+}
+
+// changedAll tells every client to drop its cache, e.g. after a restore
+func (t *tracking) changedAll() {
+	if !t.on.Load() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range t.clients {
+		t.flush(c)
+	}
+}
+
+// sweep forgets the clients that haven't been seen since before now less
+// TrackingIdle
+func (t *tracking) sweep(now time.Time) {
+	if !t.on.Load() {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, c := range t.clients {
+		if now.Sub(c.seen) > TrackingIdle {
+			t.remove(id, c)
+		}
+	}
+}
+
+// remove forgets client id; caller must hold t.mu
+func (t *tracking) remove(id string, c *trackedClient) {
+	for key := range c.keys {
+		t.untrack(c, key)
+	}
+	delete(t.clients, id)
+	t.on.Store(len(t.clients) > 0)
+}
+
+// Track records that client, an ID the client picked, caches key, so the
+// next change to key, of any kind and made by anyone, is reported to it
+// by Invalidations, once; a client that reads key again after that calls
+// Track again. Call it before reading key, so a change that lands in
+// between is not missed. A client caching more than MaxTrackedKeys keys
+// is told to drop them all.
+func (kvs *KeyValueStore) Track(client, key string) {
+	t := &kvs.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	c, _ := t.client(client, kvs.now())
+	if c.keys[key] {
+		return
+	}
+	if len(c.keys) >= MaxTrackedKeys {
+		t.flush(c)
+	}
+	c.keys[key] = true
+	cs := t.byKey[key]
+	if cs == nil {
+		cs = make(map[*trackedClient]bool)
+		t.byKey[key] = cs
+	}
+	cs[c] = true
+}
+
+// Invalidations returns the keys client cached that changed since it was
+// last called, waiting up to wait, or DefaultInvalidationWait if it is
+// zero, for one if there are none. all means client must drop every key
+// it caches: the store was restored, client cached or fell behind by too
+// many keys, or the store doesn't know client, e.g. because it restarted
+// or client didn't call Invalidations for TrackingIdle. It returns ctx's
+// error if ctx is done first.
+func (kvs *KeyValueStore) Invalidations(ctx context.Context, client string, wait time.Duration) (keys []string, all bool, err error) {
+	if wait <= 0 {
+		wait = DefaultInvalidationWait
+	}
+	timer := time.NewTimer(min(wait, MaxInvalidationWait))
+	defer timer.Stop()
+	t := &kvs.tracking
+	for {
+		t.mu.Lock()
+		c, added := t.client(client, kvs.now())
+		if added || c.all || len(c.pending) > 0 {
+			keys, all = c.pending, c.all || added
+			c.pending, c.all = nil, false
+			if !added {
+				c.notify = make(chan struct{})
+			}
+			t.mu.Unlock()
+			return keys, all, nil
+		}
+		notify := c.notify
+		t.mu.Unlock()
+		select {
+		case <-notify:
+		case <-timer.C:
+			return nil, false, nil
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		}
+	}
+}
+
+// Untrack forgets client and the keys it caches
+func (kvs *KeyValueStore) Untrack(client string) {
+	t := &kvs.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if c := t.clients[client]; c != nil {
+		t.remove(client, c)
+	}
+}
+
+// TrackingStats returns how many clients Track tracks
+func (kvs *KeyValueStore) TrackingStats() TrackingStats {
+	t := &kvs.tracking
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	stats := TrackingStats{Clients: len(t.clients)}
+	for _, c := range t.clients {
+		stats.Keys += len(c.keys)
+	}
+	return stats
+}
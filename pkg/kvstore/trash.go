@@ -0,0 +1,148 @@
+package kvstore
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// trash holds the keys DELETE soft deleted, see SetSoftDelete; guarded by
+// kvs.mu
+type trash struct {
+	window time.Duration
+	keys   map[string]TrashedKey
+}
+
+// TrashedKey is a key soft deleted, with the entry it had, when it was
+// deleted and when it is purged for good
+type TrashedKey struct {
+	Key     string
+	Entry   KeyValue
+	Deleted time.Time
+	Purge   time.Time
+}
+
+func (t TrashedKey) String() string {
+	return fmt.Sprintf("%s type=%s bytes=%d deleted=%s purge=%s", t.Key, t.Entry.Type, len(t.Entry.Value),
+		t.Deleted.UTC().Format(time.RFC3339), t.Purge.UTC().Format(time.RFC3339))
+}
+
+// SetSoftDelete makes DELETE, GETDEL and the deletes of BATCH keep the
+// entry they remove for window, so UNDELETE can bring it back until it is
+// purged; 0, the default, deletes for good. The key is gone meanwhile, to
+// reads and writes alike, and setting it again leaves the deleted entry
+// to be purged. Deleted entries live in memory only, outside maxmemory
+// and namespace limits; turning soft delete off purges them.
+func (kvs *KeyValueStore) SetSoftDelete(window time.Duration) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.trash.window = max(window, 0)
+	if kvs.trash.window == 0 {
+		kvs.trash.keys = nil
+	}
+}
+
+// SoftDelete returns the window SetSoftDelete set
+func (kvs *KeyValueStore) SoftDelete() time.Duration {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.trash.window
+}
+
+// trashKey keeps old, the entry of key a delete removed at now; caller
+// must hold kvs.mu
+func (kvs *KeyValueStore) trashKey(key string, old KeyValue, now time.Time) {
+	t := &kvs.trash
+	if t.window == 0 || kvs.expired(old, now) {
+		return
+	}
+	if t.keys == nil {
+		t.keys = make(map[string]TrashedKey)
+	}
+	t.keys[key] = TrashedKey{Key: key, Entry: old, Deleted: now, Purge: now.Add(t.window)}
+}
+
+// Trash returns the soft-deleted keys that can still be brought back, in
+// key order
+func (kvs *KeyValueStore) Trash() []TrashedKey {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	now := kvs.now()
+	keys := make([]TrashedKey, 0, len(kvs.trash.keys))
+	for _, t := range kvs.trash.keys {
+		if now.Before(t.Purge) && !kvs.expired(t.Entry, now) {
+			keys = append(keys, t)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Key < keys[j].Key })
+	return keys
+}
+
+// UNDELETE brings back key, soft deleted, with the value, type and
+// remaining lifetime it had, at a new revision. It fails with
+// protocol.MsgValueNotExist if key was not soft deleted, or has been
+// purged or would have expired since, and with protocol.MsgValueExists,
+// leaving the deleted entry as it is, if key has been set again. Like SET
+// it may be refused over quota or maxmemory.
+func (kvs *KeyValueStore) UNDELETE(key string) (item KeyValue, message string, ok bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	t, found := kvs.trash.keys[key]
+	if !found || !now.Before(t.Purge) || kvs.expired(t.Entry, now) {
+		delete(kvs.trash.keys, key)
+		return item, protocol.MsgValueNotExist, false
+	}
+	old, exists := kvs.data.get(key)
+	if exists && !kvs.expired(old, now) {
+		return old, protocol.MsgValueExists, false
+	}
+	item = t.Entry
+	if message = kvs.admit(key, old, exists, item); message != "" {
+		return item, message, false
+	}
+	if exists {
+		kvs.namespaces.remove(key, old)
+	}
+	kvs.revise(&item)
+	if exists {
+		kvs.retire(key, old, item.Revision)
+	}
+	kvs.data.set(key, item)
+	kvs.namespaces.add(key, item)
+	delete(kvs.trash.keys, key)
+	kvs.emit(EventSet, key, item.Value, now)
+	return item, protocol.MsgValueRestored, true
+}
+
+// Purge drops the soft-deleted entry of key for good, or every one if key
+// is empty, and returns how many it dropped
+func (kvs *KeyValueStore) Purge(key string) int {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if key == "" {
+		n := len(kvs.trash.keys)
+		kvs.trash.keys = nil
+		return n
+	}
+	if _, ok := kvs.trash.keys[key]; !ok {
+		return 0
+	}
+	delete(kvs.trash.keys, key)
+	return 1
+}
+
+// purgeTrash drops the soft-deleted entries due for purging, or that
+// would have expired, by now
+func (kvs *KeyValueStore) purgeTrash(now time.Time) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	for key, t := range kvs.trash.keys {
+		if !now.Before(t.Purge) || kvs.expired(t.Entry, now) {
+			delete(kvs.trash.keys, key)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package kvstore
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ClearInterval is how often the janitor looks for expired keys
+const ClearInterval = 2 * time.Second
+
+// ClearExpiredKeys removes expired keys from cache and kvs every
+// ClearInterval until ctx is done, sp may be nil when the store is used
+// without a proxy
+func ClearExpiredKeys(ctx context.Context, kvs *KeyValueStore, sp *ServerProxy) {
+	Logf(LogDebug, "ClearExpiredKeys func called")
+	ticker := time.NewTicker(ClearInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		ClearExpiredKeysNow(kvs, sp)
+	}
+}
+
+// ClearExpiredKeysNow is one round of ClearExpiredKeys: it removes the
+// keys expired by the store's clock, see SetClock, and returns how many
+func ClearExpiredKeysNow(kvs *KeyValueStore, sp *ServerProxy) int {
+	var expired []string
+	now := kvs.now()
+	kvs.mu.Lock()
+	kvs.data.eachExpiry(func(key string, value KeyValue) bool {
+		if kvs.expired(value, now) {
+			kvs.namespaces.drop(kvs.data, key)
+			kvs.data.delete(key)
+			kvs.emit(EventExpire, key, "", now)
+			expired = append(expired, key)
+			Logf(LogDebug, "Expired key '%s' deleted from cache and kvs", key)
+		}
+		return true
+	})
+	kvs.data.compact()
+	if kvs.filter.full() {
+		kvs.filter.rebuild(kvs.data)
+	}
+	kvs.mu.Unlock()
+	// the proxy takes its lock before the store's, so never nest them here
+	if sp != nil && len(expired) > 0 {
+		sp.mu.Lock()
+		for _, key := range expired {
+			sp.invalidate(key)
+		}
+		sp.mu.Unlock()
+	}
+	kvs.pruneWindows(now)
+	kvs.tracking.sweep(now)
+	kvs.purgeTrash(now)
+	return len(expired)
+}
+
+// TOUCH restarts the lifetime of key from now, for ttl if it is above zero
+// or else for the TTL it has, without rewriting its value, whatever its
+// type. Like RENEW it is a change of lifetime only: the key keeps its
+// revision. It fails with protocol.MsgValueNotExist if key does not exist
+// or has expired.
+func (kvs *KeyValueStore) TOUCH(key string, ttl time.Duration) (message string, touched bool) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	item, ok := kvs.data.get(key)
+	if !ok || kvs.expired(item, now) {
+		return protocol.MsgValueNotExist, false
+	}
+	item.Timestamp = now
+	if ttl > 0 {
+		item.TTL = ttl
+	}
+	kvs.data.set(key, item)
+	return protocol.MsgValueTouched, true
+}
@@ -0,0 +1,127 @@
+package kvstore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// newClockedStore returns a store whose clock only moves when the test
+// advances it
+func newClockedStore() (*KeyValueStore, *ManualClock) {
+	kvs := NewKeyValueStore()
+	clock := NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	kvs.SetClock(clock)
+	return kvs, clock
+}
+
+func TestKeyExpiresAfterItsTTL(t *testing.T) {
+	kvs, clock := newClockedStore()
+	kvs.SETEX("k", "v", 10*time.Second)
+	clock.Advance(4 * time.Second)
+	if info, found := kvs.OBJECT("k"); !found || info.TTL != 6*time.Second {
+		t.Fatalf("OBJECT after 4s = %v, %v; want ttl 6s", info.TTL, found)
+	}
+	if n := ClearExpiredKeysNow(kvs, nil); n != 0 {
+		t.Fatalf("janitor removed %d live keys", n)
+	}
+	// a key lives out its whole TTL and expires just after
+	clock.Advance(6 * time.Second)
+	if info, found := kvs.OBJECT("k"); !found || info.TTL != 0 {
+		t.Fatalf("OBJECT at its expiry = %v, %v; want ttl 0", info.TTL, found)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, found := kvs.OBJECT("k"); found {
+		t.Fatal("key found once its TTL ran out")
+	}
+	if n := ClearExpiredKeysNow(kvs, nil); n != 1 {
+		t.Fatalf("janitor removed %d keys, want 1", n)
+	}
+	if _, found := kvs.GET("k"); found || kvs.Len() != 0 {
+		t.Fatalf("key still stored after the janitor ran, len %d", kvs.Len())
+	}
+}
+
+func TestKeyFollowsStoreTTL(t *testing.T) {
+	kvs, clock := newClockedStore()
+	kvs.SetTTL(time.Minute)
+	kvs.SET("k", "v")
+	clock.Advance(59 * time.Second)
+	if n := ClearExpiredKeysNow(kvs, nil); n != 0 {
+		t.Fatalf("janitor removed %d keys before the store TTL", n)
+	}
+	// a key without a TTL of its own follows the store's as it changes
+	kvs.SetTTL(2 * time.Minute)
+	clock.Advance(time.Minute)
+	if info, found := kvs.OBJECT("k"); !found || info.TTL != time.Second {
+		t.Fatalf("OBJECT = %v, %v; want ttl 1s", info.TTL, found)
+	}
+	clock.Advance(2 * time.Second)
+	if n := ClearExpiredKeysNow(kvs, nil); n != 1 {
+		t.Fatalf("janitor removed %d keys, want 1", n)
+	}
+}
+
+func TestTouchSetsExpiry(t *testing.T) {
+	kvs, clock := newClockedStore()
+	kvs.SETEX("k", "v", 10*time.Second)
+	clock.Advance(8 * time.Second)
+	if _, touched := kvs.TOUCH("k", 0); !touched {
+		t.Fatal("TOUCH of a live key failed")
+	}
+	clock.Advance(8 * time.Second)
+	if info, found := kvs.OBJECT("k"); !found || info.TTL != 2*time.Second {
+		t.Fatalf("OBJECT after TOUCH = %v, %v; want ttl 2s", info.TTL, found)
+	}
+	// a TOUCH with a TTL is an EXPIRE: a longer one keeps the key
+	// around as though it had no expiry, a shorter one brings it forward
+	kvs.TOUCH("k", 24*time.Hour)
+	clock.Advance(time.Hour)
+	if n := ClearExpiredKeysNow(kvs, nil); n != 0 {
+		t.Fatalf("janitor removed %d keys given a day to live", n)
+	}
+	kvs.TOUCH("k", time.Second)
+	clock.Advance(2 * time.Second)
+	if message, touched := kvs.TOUCH("k", 0); touched || message != protocol.MsgValueNotExist {
+		t.Fatalf("TOUCH of an expired key = %s, %v; want %s", message, touched, protocol.MsgValueNotExist)
+	}
+	if n := ClearExpiredKeysNow(kvs, nil); n != 1 {
+		t.Fatalf("janitor removed %d keys, want 1", n)
+	}
+}
+
+func TestUpdateTTLModes(t *testing.T) {
+	kvs, clock := newClockedStore()
+	kvs.SETEX("keep", "v", 10*time.Second)
+	kvs.SETEX("reset", "v", 10*time.Second)
+	clock.Advance(6 * time.Second)
+	kvs.UPDATEEX("keep", "w", TTLKeep, 0, 0)
+	kvs.UPDATEEX("reset", "w", TTLReset, 0, 0)
+	clock.Advance(6 * time.Second)
+	if _, found := kvs.OBJECT("keep"); found {
+		t.Error("TTLKeep update extended the key's lifetime")
+	}
+	if info, found := kvs.OBJECT("reset"); !found || info.TTL != 4*time.Second {
+		t.Errorf("TTLReset update = %v, %v; want ttl 4s", info.TTL, found)
+	}
+	if n := ClearExpiredKeysNow(kvs, nil); n != 1 {
+		t.Errorf("janitor removed %d keys, want 1", n)
+	}
+}
+
+func TestJanitorInvalidatesProxyCache(t *testing.T) {
+	kvs, clock := newClockedStore()
+	sp := NewServerProxy(kvs)
+	kvs.SETEX("k", "v", time.Second)
+	if value, found := sp.GET("k"); !found || value != "v" {
+		t.Fatalf("proxy GET = %q, %v", value, found)
+	}
+	clock.Advance(2 * time.Second)
+	if n := ClearExpiredKeysNow(kvs, sp); n != 1 {
+		t.Fatalf("janitor removed %d keys, want 1", n)
+	}
+	if value, found := sp.GET("k"); found {
+		t.Fatalf("proxy served %q from its cache after the key expired", value)
+	}
+}
@@ -0,0 +1,95 @@
+package kvstore
+
+import (
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Typed values, such as hashes and lists, are kept encoded in Value, so
+// every engine, backup and journal handles them as it does plain ones. An
+// empty one is no value at all: emptying it deletes the key.
+
+// readTyped returns the value of key if it holds typ, "" and found false
+// if it is missing or expired; a key of another type fails with
+// protocol.MsgWrongType
+func (kvs *KeyValueStore) readTyped(key string, typ ValueType) (value string, found bool, message string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.typed(key, typ, kvs.now())
+}
+
+// typed is readTyped as of now, caller must hold kvs.mu
+func (kvs *KeyValueStore) typed(key string, typ ValueType, now time.Time) (value string, found bool, message string) {
+	item, ok := kvs.data.get(key)
+	if !ok || kvs.expired(item, now) {
+		return "", false, ""
+	}
+	if item.Type != typ {
+		return "", false, protocol.MsgWrongType
+	}
+	kvs.namespaces.touch(key)
+	return item.Value, true, ""
+}
+
+// modifyTyped sets key, of type typ, to what fn makes of its value, "" if
+// the key is missing or expired, all under the store's lock; fn refuses
+// by returning a message, which modifyTyped returns, and reports whether
+// it changed anything. Nothing is written without a change, and item is
+// then empty; an empty value deletes the key, reported by deleted;
+// otherwise item is the entry written. The key keeps its remaining
+// lifetime, and a new one gets ttl as in SETSUM. A key of another type
+// fails with protocol.MsgWrongType, and the write is refused over quota.
+func (kvs *KeyValueStore) modifyTyped(key string, typ ValueType, ttl time.Duration, fn func(value string) (updated string, changed bool, message string)) (item KeyValue, deleted bool, message string, ok bool) {
+	kvs.events.wait()
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	now := kvs.now()
+	item = KeyValue{Timestamp: now, TTL: ttl, Type: typ}
+	old, exists := kvs.data.get(key)
+	live := exists && !kvs.expired(old, now)
+	current := ""
+	if live {
+		if old.Type != typ {
+			return KeyValue{}, false, protocol.MsgWrongType, false
+		}
+		current = old.Value
+		item.Timestamp, item.TTL = old.Timestamp, old.TTL
+	} else if item.TTL <= 0 {
+		item.TTL = kvs.namespaces.ttl(key)
+	}
+	updated, changed, message := fn(current)
+	if message != "" {
+		return KeyValue{}, false, message, false
+	}
+	if !changed {
+		return KeyValue{}, false, "", true
+	}
+	if updated == "" {
+		kvs.revision++
+		kvs.retire(key, old, kvs.revision)
+		kvs.data.delete(key)
+		kvs.namespaces.remove(key, old)
+		kvs.emit(EventDelete, key, "", now)
+		return KeyValue{}, true, "", true
+	}
+	item.Value = updated
+	if message = kvs.admit(key, old, exists, item); message != "" {
+		return KeyValue{}, false, message, false
+	}
+	if exists {
+		kvs.namespaces.remove(key, old)
+	}
+	kvs.revise(&item)
+	if exists {
+		kvs.retire(key, old, item.Revision)
+	}
+	kvs.data.set(key, item)
+	kvs.namespaces.add(key, item)
+	event := EventSet
+	if live {
+		event = EventUpdate
+	}
+	kvs.emit(event, key, item.Value, now)
+	return item, false, "", true
+}
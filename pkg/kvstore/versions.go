@@ -0,0 +1,194 @@
+package kvstore
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ErrVersionGone is returned by GETAT for a revision the store no longer
+// keeps the key's value at
+var ErrVersionGone = errors.New("version no longer kept")
+
+// versionLog keeps the last values of keys, see SetVersionHistory; guarded
+// by kvs.mu
+type versionLog struct {
+	keep     int
+	patterns []string
+	keys     map[string][]keptVersion // oldest first
+}
+
+// keptVersion is a value of the history, overwritten or removed at when
+type keptVersion struct {
+	version
+	when time.Time
+}
+
+// KeyVersion is a value a key had, see Versions: Revision is the write
+// that gave it and Until the one that overwrote or removed it, zero for
+// the value the key has now, and Replaced when that write was. Removed
+// reports that the key did not exist after Until, deleted, expired or
+// evicted, until it was set again.
+type KeyVersion struct {
+	KeyValue
+	Until    uint64
+	Replaced time.Time
+	Removed  bool
+}
+
+// SetVersionHistory keeps the last keep values each key had before it was
+// overwritten or removed, for GETAT and Versions, so what changed and when
+// can be looked up later; 0, the default, keeps none. With patterns, in
+// path.Match syntax such as "config/*", only matching keys keep theirs.
+// History lives in memory only and a restore drops it, as does lowering
+// keep for the values past it.
+func (kvs *KeyValueStore) SetVersionHistory(keep int, patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return err
+		}
+	}
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	v := &kvs.versions
+	v.keep = max(keep, 0)
+	v.patterns = append([]string(nil), patterns...)
+	for key, kept := range v.keys {
+		if !v.keeps(key) {
+			delete(v.keys, key)
+		} else if len(kept) > v.keep {
+			v.keys[key] = kept[len(kept)-v.keep:]
+		}
+	}
+	return nil
+}
+
+// VersionHistory returns what SetVersionHistory set
+func (kvs *KeyValueStore) VersionHistory() (keep int, patterns []string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.versions.keep, append([]string(nil), kvs.versions.patterns...)
+}
+
+// keeps reports whether key keeps its history
+func (v *versionLog) keeps(key string) bool {
+	if v.keep == 0 {
+		return false
+	}
+	if len(v.patterns) == 0 {
+		return true
+	}
+	for _, pattern := range v.patterns {
+		if ok, _ := path.Match(pattern, key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// add keeps old, the value of key the write at revision until overwrites
+// or removes at now; caller must hold kvs.mu
+func (v *versionLog) add(key string, old KeyValue, until uint64, now time.Time) {
+	if !v.keeps(key) {
+		return
+	}
+	if v.keys == nil {
+		v.keys = make(map[string][]keptVersion)
+	}
+	kept := append(v.keys[key], keptVersion{version{KeyValue: old, until: until}, now})
+	if len(kept) > v.keep {
+		// a fresh slice, so the dropped values can be freed
+		kept = append([]keptVersion(nil), kept[len(kept)-v.keep:]...)
+	}
+	v.keys[key] = kept
+}
+
+// forget drops the values of key kept for writes after revision start,
+// which a rolled back BATCH took back; caller must hold kvs.mu
+func (v *versionLog) forget(key string, start uint64) {
+	kept := v.keys[key]
+	n := len(kept)
+	for n > 0 && kept[n-1].until > start {
+		n--
+	}
+	switch {
+	case n == 0:
+		delete(v.keys, key)
+	case n < len(kept):
+		v.keys[key] = kept[:n]
+	}
+}
+
+// Versions returns the values key has had, newest first: the one it has
+// now, if it exists, then those SetVersionHistory kept. Values that fail
+// their checksum are returned as they are, for the caller to check with
+// Intact.
+func (kvs *KeyValueStore) Versions(key string) []KeyVersion {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	var versions []KeyVersion
+	next := uint64(0) // the revision of the value after, 0 if none
+	if item, ok := kvs.data.get(key); ok && !kvs.expired(item, kvs.now()) {
+		versions = append(versions, KeyVersion{KeyValue: item})
+		next = item.Revision
+	}
+	kept := kvs.versions.keys[key]
+	for i := len(kept) - 1; i >= 0; i-- {
+		v := kept[i]
+		versions = append(versions, KeyVersion{KeyValue: v.KeyValue, Until: v.until, Replaced: v.when, Removed: v.until != next})
+		next = v.Revision
+	}
+	return versions
+}
+
+// GETAT reads key as it was right after the write at revision rev, from
+// the values SetVersionHistory keeps, or that an open ReadTx keeps. found
+// is false if the key did not exist then. If the key has been written
+// since and its value then is no longer kept, or was never, it fails with
+// ErrVersionGone. A value that fails its checksum is returned as
+// protocol.MsgIntegrity, not found, as for GETKV.
+func (kvs *KeyValueStore) GETAT(key string, rev uint64) (item KeyValue, found bool, err error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	if rev > kvs.revision {
+		rev = kvs.revision
+	}
+	current, exists := kvs.data.get(key)
+	item, ok := current, exists && current.Revision <= rev
+	if ok && kvs.expired(item, kvs.now()) {
+		ok = false
+	} else if !ok {
+		if item, ok = kvs.versionAt(key, rev); !ok {
+			item, ok, err = kvs.versions.at(key, rev, exists)
+			if err != nil {
+				return KeyValue{}, false, err
+			}
+		}
+	}
+	if !ok {
+		return KeyValue{Value: protocol.MsgNotFound}, false, nil
+	}
+	if !item.Intact() {
+		return KeyValue{Value: protocol.MsgIntegrity}, false, nil
+	}
+	return item, true, nil
+}
+
+// at is the kept value of key at rev; exists says whether key exists now,
+// written after rev. Caller holds kvs.mu.
+func (v *versionLog) at(key string, rev uint64, exists bool) (KeyValue, bool, error) {
+	kept := v.keys[key]
+	// before the oldest value kept, or while none was, the store can't
+	// tell what the key was
+	if !v.keeps(key) || len(kept) == 0 && exists || len(kept) > 0 && kept[0].Revision > rev {
+		return KeyValue{}, false, ErrVersionGone
+	}
+	for _, kv := range kept {
+		if kv.Revision <= rev && rev < kv.until {
+			return kv.KeyValue, true, nil
+		}
+	}
+	return KeyValue{}, false, nil
+}
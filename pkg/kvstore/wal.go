@@ -0,0 +1,537 @@
+package kvstore
+
+import (
+	"crypto/cipher"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// WAL record ops
+const (
+	walSet    = "set"
+	walDelete = "del"
+	walClear  = "clear" // a restore replaced everything
+)
+
+// walRecord is one line of the write-ahead log: the entry a key was left
+// with, whole, or its deletion. Replaying records in order rebuilds the
+// store, however often a key was written, and a record replayed twice
+// does no harm.
+type walRecord struct {
+	Seq   uint64    `json:"seq"`
+	Op    string    `json:"op"`
+	Key   string    `json:"key,omitempty"`
+	Entry *KeyValue `json:"kv,omitempty"`
+}
+
+// WALSync is when the WAL is fsynced, trading durability for throughput
+// as Redis's appendfsync does: after every append, every so long, or
+// whenever the OS flushes its buffers. Appends reach the file before the
+// write returns in every mode, so only a crash of the machine, not of the
+// server, loses any.
+type WALSync time.Duration
+
+const (
+	// WALSyncOS leaves flushing to the OS, the fastest; the zero value
+	WALSyncOS WALSync = 0
+	// WALSyncAlways has every write wait for an fsync of the log before it
+	// is acknowledged, losing nothing acknowledged. Writers waiting at the
+	// same time share one fsync, see CommitWAL.
+	WALSyncAlways WALSync = -1
+)
+
+// ParseWALSync parses "always", "os", "everysec" for once a second, or a
+// duration such as "100ms", the names printed by String
+func ParseWALSync(s string) (WALSync, error) {
+	switch s {
+	case "always":
+		return WALSyncAlways, nil
+	case "os", "no":
+		return WALSyncOS, nil
+	case "everysec":
+		return WALSync(time.Second), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("WAL fsync %q: want always, os or an interval such as 1s", s)
+	}
+	return WALSync(d), nil
+}
+
+func (s WALSync) String() string {
+	switch s {
+	case WALSyncAlways:
+		return "always"
+	case WALSyncOS:
+		return "os"
+	}
+	return time.Duration(s).String()
+}
+
+// WAL is the write-ahead log of a KeyValueStore, see OpenWAL: every
+// change to an entry is appended to it, as a JSON line with a checksum,
+// before the write returns, and fsynced as its WALSync says.
+type WAL struct {
+	mu      sync.Mutex
+	path    string
+	file    *os.File
+	cipher  *FileCipher
+	seq     uint64
+	held    [][]byte // lines kept back by hold
+	holding bool
+	policy  WALSync
+	synced  uint64        // the last record fsynced
+	syncing bool          // a CommitWAL is fsyncing for the others
+	done    *sync.Cond    // signalled when syncing ends
+	stop    chan struct{} // stops the fsyncs of an interval policy
+	size    int64
+	base    int64 // size after the last rewrite, or on open
+	rewrite walRewrite
+	fsyncs  int64
+	commits int64
+	errors  atomic.Int64
+	failed  error // of the append that stopped the log, see WALError
+}
+
+// append writes r, or keeps it back while held. A failure stops the log,
+// as a record after a torn one would not be replayed, and CommitWAL
+// returns it, so the write that made r and those after it are not
+// acknowledged.
+func (w *WAL) append(r walRecord) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil || w.failed != nil {
+		return
+	}
+	w.seq++
+	r.Seq = w.seq
+	line, err := encodeWALRecord(r)
+	if err == nil {
+		line = append(w.cipher.sealLine(line), '\n')
+		if w.holding {
+			w.held = append(w.held, line)
+			return
+		}
+		err = w.write(line)
+	}
+	if err != nil {
+		w.fail(err)
+	}
+}
+
+// fail stops the log for err; caller must hold w.mu
+func (w *WAL) fail(err error) {
+	w.errors.Add(1)
+	w.failed = fmt.Errorf("WAL stopped: %w", err)
+	RecordError("Error writing WAL:", err)
+}
+
+// write appends b to the file; caller must hold w.mu
+func (w *WAL) write(b []byte) error {
+	n, err := w.file.Write(b)
+	w.size += int64(n)
+	return err
+}
+
+// sync fsyncs what was appended since the last time; caller must hold
+// w.mu
+func (w *WAL) sync() error {
+	if w.synced >= w.seq || w.file == nil {
+		return nil
+	}
+	upTo := w.seq
+	w.fsyncs++
+	if err := w.file.Sync(); err != nil {
+		return err
+	}
+	w.synced = upTo
+	return nil
+}
+
+// commit waits until the records appended so far are fsynced. The first
+// writer to wait fsyncs, without holding w.mu so appends go on, and those
+// that come meanwhile wait for the next fsync, which covers all of them:
+// a group commit, one fsync for however many writers queued behind one.
+func (w *WAL) commit() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.commits++
+	if w.failed != nil {
+		return w.failed
+	}
+	target := w.seq
+	for w.synced < target {
+		if w.syncing {
+			w.done.Wait()
+			continue
+		}
+		if w.file == nil {
+			return ErrNoWAL
+		}
+		w.syncing = true
+		file, upTo := w.file, w.seq
+		w.fsyncs++
+		w.mu.Unlock()
+		err := file.Sync()
+		w.mu.Lock()
+		w.syncing = false
+		w.done.Broadcast()
+		// a rewrite that swapped the file in meanwhile synced it already
+		if err != nil && file == w.file {
+			w.errors.Add(1)
+			return err
+		}
+		w.synced = max(w.synced, upTo)
+	}
+	return nil
+}
+
+// setPolicy changes when w is fsynced, starting or stopping the fsyncs of
+// an interval policy; caller must hold w.mu
+func (w *WAL) setPolicy(policy WALSync) {
+	if w.stop != nil {
+		close(w.stop)
+		w.stop = nil
+	}
+	w.policy = policy
+	if policy <= 0 {
+		return
+	}
+	w.stop = make(chan struct{})
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(time.Duration(policy))
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				w.mu.Lock()
+				if err := w.sync(); err != nil {
+					w.errors.Add(1)
+					RecordError("Error syncing WAL:", err)
+				}
+				w.mu.Unlock()
+			}
+		}
+	}(w.stop)
+}
+
+// hold keeps the records appended from now on back until release, for
+// changes that may yet fail as a whole
+func (w *WAL) hold() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.holding = true
+}
+
+// release ends hold, writing the records kept back if write is set and
+// dropping them otherwise
+func (w *WAL) release(write bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	held := w.held
+	w.holding, w.held = false, nil
+	if !write || w.file == nil || w.failed != nil {
+		w.seq -= uint64(len(held))
+		return
+	}
+	var b []byte
+	for _, line := range held {
+		b = append(b, line...)
+	}
+	if err := w.write(b); err != nil {
+		w.fail(err)
+	}
+}
+
+// walEngine is an engine that appends every write and delete through it
+// to a WAL, sealing values as snapshots do if aead is set. It sits under
+// the indexes and over the other storage layers, like deltaEngine.
+type walEngine struct {
+	engine
+	log  *WAL
+	aead cipher.AEAD
+}
+
+func (e *walEngine) set(key string, kv KeyValue) {
+	e.engine.set(key, kv)
+	logged := kv
+	if e.aead != nil {
+		logged = sealText(e.aead, key, kv)
+	}
+	e.log.append(walRecord{Op: walSet, Key: key, Entry: &logged})
+}
+
+func (e *walEngine) delete(key string) {
+	e.engine.delete(key)
+	e.log.append(walRecord{Op: walDelete, Key: key})
+}
+
+// OpenWAL replays the write-ahead log at path into kvs, on top of what
+// kvs holds, e.g. the snapshot it was restored from, skipping the records
+// that snapshot holds already, and then appends every change to it,
+// fsyncing it as SetWALSync says. Lines are read and written encrypted
+// with c, if not nil. Every record carries a checksum. A damaged last
+// record, torn by a crash, is dropped; damage before valid records is
+// left to SetWALRecovery.
+func (kvs *KeyValueStore) OpenWAL(path string, c *FileCipher) (WALReplay, error) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	if kvs.wal != nil {
+		return WALReplay{}, errors.New("WAL already open")
+	}
+	w := &WAL{path: path, cipher: c}
+	w.done = sync.NewCond(&w.mu)
+	end, replay, err := kvs.replayWAL(w)
+	if err != nil {
+		return replay, err
+	}
+	w.file, err = os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return replay, err
+	}
+	info, err := w.file.Stat()
+	if err != nil {
+		w.file.Close()
+		return replay, err
+	}
+	// only cut a log whose end was dropped
+	if info.Size() > end {
+		if err := w.file.Truncate(end); err != nil {
+			w.file.Close()
+			return replay, err
+		}
+	}
+	if _, err := w.file.Seek(end, io.SeekStart); err != nil {
+		w.file.Close()
+		return replay, err
+	}
+	w.size, w.base, w.synced = end, end, w.seq
+	w.setPolicy(kvs.walSync)
+	kvs.wal = w
+	kvs.retrack()
+	return replay, nil
+}
+
+// replayWAL applies the records of w's file to kvs, after the last one
+// of the snapshot loaded before, and returns where the records end;
+// caller must hold kvs.mu
+func (kvs *KeyValueStore) replayWAL(w *WAL) (end int64, replay WALReplay, err error) {
+	scan, err := scanWAL(w.path, w.cipher, kvs.walRecovery)
+	if err != nil {
+		return 0, replay, err
+	}
+	replay.Dropped = scan.dropped
+	// a log that lost its end, or was deleted, since the snapshot goes on
+	// after the snapshot's records rather than number new ones like them
+	mark := kvs.walMark
+	w.seq = max(scan.last, mark)
+	aead := encryptionOf(kvs.data)
+	now := kvs.now()
+	defer kvs.keepStamps()()
+	defer func() {
+		kvs.namespaces.recount(kvs.data)
+		kvs.stale.reset()
+		kvs.tracking.changedAll()
+		kvs.zsets.reset()
+	}()
+	err = readWAL(w.path, w.cipher, func(l walLine) bool {
+		if l.offset >= scan.end {
+			return false
+		}
+		rec := l.rec
+		if rec.Seq <= mark {
+			replay.Skipped++
+			return true
+		}
+		replay.Records++
+		switch rec.Op {
+		case walClear:
+			kvs.data.each(func(key string, _ KeyValue) bool {
+				kvs.data.delete(key)
+				return true
+			})
+		case walDelete:
+			kvs.data.delete(rec.Key)
+		case walSet:
+			if rec.Entry == nil {
+				return true
+			}
+			item := *rec.Entry
+			if item.Encrypted {
+				if aead == nil {
+					err = fmt.Errorf("reading WAL %s: %w", w.path, ErrEncryptionKey)
+					return false
+				}
+				var oerr error
+				if item, oerr = openText(aead, rec.Key, item); oerr != nil {
+					replay.Damaged++
+					RecordError("Error reading WAL:", fmt.Errorf("%s line %d: value of %q doesn't decrypt: %w", w.path, l.n, rec.Key, oerr))
+					return true
+				}
+			}
+			if !item.Intact() {
+				replay.Damaged++
+				RecordError("Error reading WAL:", fmt.Errorf("%s line %d: value of %q fails its checksum", w.path, l.n, rec.Key))
+				return true
+			}
+			kvs.revision = max(kvs.revision, item.Revision)
+			if kvs.expired(item, now) {
+				kvs.data.delete(rec.Key)
+				return true
+			}
+			kvs.data.set(rec.Key, item)
+		}
+		return true
+	})
+	return scan.end, replay, err
+}
+
+// SetWALSync changes when the write-ahead log is fsynced, now and for the
+// next OpenWAL; it starts as WALSyncOS
+func (kvs *KeyValueStore) SetWALSync(policy WALSync) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.walSync = policy
+	if w := kvs.wal; w != nil {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.setPolicy(policy)
+	}
+}
+
+// WALSync returns what SetWALSync set
+func (kvs *KeyValueStore) WALSync() WALSync {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.walSync
+}
+
+// CommitWAL waits until the changes logged so far are on disk, if the
+// write-ahead log is fsynced WALSyncAlways; call it after a write and
+// before acknowledging it. Writers calling it at once share an fsync. It
+// fails, whatever the WALSync, once an append has failed, see WALError,
+// and under WALSyncAlways if the fsync does.
+func (kvs *KeyValueStore) CommitWAL() error {
+	w := kvs.walLog()
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	always, failed := w.policy == WALSyncAlways, w.failed
+	w.mu.Unlock()
+	if failed != nil || !always {
+		return failed
+	}
+	return w.commit()
+}
+
+// WALError returns the failed append that stopped the write-ahead log,
+// nil while it works. Changes are not logged from then on, so they should
+// be refused; a RewriteWAL that succeeds starts the log again.
+func (kvs *KeyValueStore) WALError() error {
+	w := kvs.walLog()
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.failed
+}
+
+// SyncWAL flushes the write-ahead log to disk, if there is one
+func (kvs *KeyValueStore) SyncWAL() error {
+	w := kvs.walLog()
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.sync()
+}
+
+// CloseWAL syncs and closes the write-ahead log, if there is one; changes
+// after it are no longer logged
+func (kvs *KeyValueStore) CloseWAL() error {
+	kvs.mu.Lock()
+	w := kvs.wal
+	kvs.wal = nil
+	if w != nil {
+		kvs.retrack()
+	}
+	kvs.mu.Unlock()
+	if w == nil {
+		return nil
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.setPolicy(WALSyncOS)
+	err := w.file.Sync()
+	if cerr := w.file.Close(); err == nil {
+		err = cerr
+	}
+	w.file = nil
+	return err
+}
+
+// WALStats describes the write-ahead log: its Path, "" if there is none,
+// the sequence number of its last Record, its size in Bytes and after the
+// last rewrite, when it is fsynced, how many Fsyncs and CommitWALs there
+// were, how many appends and fsyncs failed, and its rewrites
+type WALStats struct {
+	Path      string
+	Records   uint64
+	Bytes     int64
+	BaseBytes int64
+	Sync      WALSync
+	Fsyncs    int64
+	Commits   int64
+	Errors    int64
+	Rewriting bool
+	Rewrite   BackupResult // the last rewrite
+}
+
+// WALStats returns the state of the write-ahead log
+func (kvs *KeyValueStore) WALStats() WALStats {
+	w := kvs.walLog()
+	if w == nil {
+		return WALStats{Sync: kvs.WALSync()}
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return WALStats{
+		Path:      w.path,
+		Records:   w.seq,
+		Bytes:     w.size,
+		BaseBytes: w.base,
+		Sync:      w.policy,
+		Fsyncs:    w.fsyncs,
+		Commits:   w.commits,
+		Errors:    w.errors.Load(),
+		Rewriting: w.rewrite.running,
+		Rewrite:   w.rewrite.last,
+	}
+}
+
+// walSeq is the last record of the WAL, 0 if there is none; caller must
+// hold kvs.mu
+func (kvs *KeyValueStore) walSeq() uint64 {
+	if kvs.wal == nil {
+		return 0
+	}
+	kvs.wal.mu.Lock()
+	defer kvs.wal.mu.Unlock()
+	return kvs.wal.seq
+}
+
+func (kvs *KeyValueStore) walLog() *WAL {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.wal
+}
@@ -0,0 +1,68 @@
+package kvstore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWALAppendFailureStopsLog(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs.wal")
+	kvs := NewKeyValueStore()
+	if _, err := kvs.OpenWAL(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SET("a", "1")
+	if err := kvs.CommitWAL(); err != nil {
+		t.Fatal(err)
+	}
+	// a file that refuses writes, as a full or failing disk would
+	readOnly, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := kvs.walLog()
+	w.mu.Lock()
+	writable := w.file
+	w.file = readOnly
+	w.mu.Unlock()
+	writable.Close()
+
+	kvs.SET("b", "1")
+	if err := kvs.CommitWAL(); err == nil {
+		t.Fatal("CommitWAL after a failed append returned nil")
+	}
+	if kvs.WALError() == nil {
+		t.Fatal("WALError nil after a failed append")
+	}
+	records := kvs.WALStats().Records
+	kvs.SET("c", "1")
+	if got := kvs.WALStats().Records; got != records {
+		t.Fatalf("stopped log took %d more records", got-records)
+	}
+
+	if err := kvs.RewriteWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvs.WALError(); err != nil {
+		t.Fatalf("WALError after a rewrite: %v", err)
+	}
+	kvs.SET("d", "1")
+	if err := kvs.CommitWAL(); err != nil {
+		t.Fatalf("CommitWAL after a rewrite: %v", err)
+	}
+	if err := kvs.CloseWAL(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := NewKeyValueStore()
+	if _, err := replayed.OpenWAL(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.CloseWAL()
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if _, found := replayed.GET(key); !found {
+			t.Errorf("%s lost across the failed append and the rewrite", key)
+		}
+	}
+}
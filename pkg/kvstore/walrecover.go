@@ -0,0 +1,214 @@
+package kvstore
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strconv"
+)
+
+// ErrWALCorrupt is returned by OpenWAL for a WAL with a damaged record
+// that valid ones follow, so it is not a write torn by a crash, and
+// dropping the rest of the log would lose them
+var ErrWALCorrupt = errors.New("WAL is corrupt")
+
+// WALRecovery is what OpenWAL does with a corrupt WAL, see ErrWALCorrupt
+type WALRecovery int
+
+const (
+	// WALRefuse fails OpenWAL, leaving the file alone; the zero value
+	WALRefuse WALRecovery = iota
+	// WALTruncate replays the records before the damage and drops the
+	// rest, after copying the file to path.corrupt
+	WALTruncate
+)
+
+// ParseWALRecovery parses "refuse" or "truncate", the names printed by
+// String
+func ParseWALRecovery(name string) (WALRecovery, error) {
+	switch name {
+	case "refuse":
+		return WALRefuse, nil
+	case "truncate":
+		return WALTruncate, nil
+	}
+	return 0, fmt.Errorf("unknown WAL recovery %q, want refuse or truncate", name)
+}
+
+func (r WALRecovery) String() string {
+	if r == WALTruncate {
+		return "truncate"
+	}
+	return "refuse"
+}
+
+// SetWALRecovery changes what OpenWAL does with a corrupt WAL; it starts
+// as WALRefuse
+func (kvs *KeyValueStore) SetWALRecovery(r WALRecovery) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.walRecovery = r
+}
+
+// WALReplay is what OpenWAL replayed: the Records applied, those Skipped
+// for being in the snapshot loaded before, the entries Damaged, failing
+// their checksum or decryption, which were dropped, and how many bytes of
+// a torn or corrupt tail were Dropped
+type WALReplay struct {
+	Records int
+	Skipped int
+	Damaged int
+	Dropped int64
+}
+
+// encodeWALRecord is r as a line of the log, without its newline: JSON
+// with a last field "crc", the CRC-32 of the object before it was added
+func encodeWALRecord(r walRecord) ([]byte, error) {
+	line, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	sum := crc32.ChecksumIEEE(line)
+	line = append(line[:len(line)-1], `,"crc":`...)
+	line = strconv.AppendUint(line, uint64(sum), 10)
+	return append(line, '}'), nil
+}
+
+// decodeWALRecord undoes encodeWALRecord; lines from before the checksum
+// are read unchecked
+func decodeWALRecord(line []byte) (walRecord, error) {
+	var r walRecord
+	body := line
+	var sum uint64
+	if i := bytes.LastIndex(line, []byte(`,"crc":`)); i >= 0 && bytes.HasSuffix(line, []byte("}")) {
+		var err error
+		if sum, err = strconv.ParseUint(string(line[i+len(`,"crc":`):len(line)-1]), 10, 32); err != nil {
+			return r, fmt.Errorf("bad checksum: %w", err)
+		}
+		body = append(line[:i:i], '}')
+		if crc32.ChecksumIEEE(body) != uint32(sum) {
+			return r, errors.New("checksum mismatch")
+		}
+	}
+	if err := json.Unmarshal(body, &r); err != nil {
+		return r, err
+	}
+	switch r.Op {
+	case walSet, walDelete, walClear:
+	default:
+		return r, fmt.Errorf("unknown op %q", r.Op)
+	}
+	return r, nil
+}
+
+// walLine is a complete line of a WAL file as read back: where it
+// starts, its number from 1, and its record, or why it has none
+type walLine struct {
+	offset int64
+	size   int64
+	n      int
+	rec    walRecord
+	err    error
+}
+
+// readWAL calls fn with each complete line of the WAL file path, that
+// ends in a newline, until fn returns false; a file that doesn't exist
+// has none. It fails for a file encrypted with a key it wasn't given.
+func readWAL(path string, c *FileCipher, fn func(walLine) bool) error {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	r := bufio.NewReader(file)
+	var offset int64
+	for n := 1; ; n++ {
+		line, err := r.ReadBytes('\n')
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		l := walLine{offset: offset, size: int64(len(line)), n: n}
+		offset += l.size
+		plain, err := c.openLine(line)
+		if errors.Is(err, ErrFileKey) {
+			return fmt.Errorf("reading WAL %s: %w", path, err)
+		}
+		if err == nil {
+			l.rec, l.err = decodeWALRecord(bytes.TrimSuffix(plain, []byte("\n")))
+		} else {
+			l.err = err
+		}
+		if !fn(l) {
+			return nil
+		}
+	}
+}
+
+// walScan is what a first pass over a WAL file found: the offset past
+// the last record to replay, its sequence number, and the bytes after it
+type walScan struct {
+	end     int64
+	last    uint64
+	dropped int64
+}
+
+// scanWAL checks the WAL file path before a replay. Damage with no valid
+// record after it is a torn tail, dropped; damage before valid records
+// fails with ErrWALCorrupt, saying where, unless recovery is WALTruncate,
+// which copies the file to path.corrupt and drops the rest from there.
+func scanWAL(path string, c *FileCipher, recovery WALRecovery) (scan walScan, err error) {
+	var bad *walLine
+	valid := 0 // records after bad
+	var size int64
+	err = readWAL(path, c, func(l walLine) bool {
+		size = l.offset + l.size
+		switch {
+		case l.err != nil:
+			if bad == nil {
+				bad = &l
+			}
+		case bad != nil:
+			valid++
+		default:
+			scan.end, scan.last = l.offset+l.size, l.rec.Seq
+		}
+		return true
+	})
+	if err != nil {
+		return scan, err
+	}
+	if info, err := os.Stat(path); err == nil {
+		size = info.Size()
+	}
+	scan.dropped = size - scan.end
+	if bad == nil || valid == 0 {
+		if scan.dropped > 0 {
+			RecordError("Error reading WAL:", fmt.Errorf("%s: dropping %d bytes of a torn last record", path, scan.dropped))
+		}
+		return scan, nil
+	}
+	damage := fmt.Errorf("%w: %s line %d, offset %d: %v; %d valid records follow", ErrWALCorrupt, path, bad.n, bad.offset, bad.err, valid)
+	if recovery != WALTruncate {
+		return scan, damage
+	}
+	data, err := os.ReadFile(path)
+	if err == nil {
+		err = writeFileAtomic(path+".corrupt", data)
+	}
+	if err != nil {
+		return scan, fmt.Errorf("%w; keeping a copy failed: %v", damage, err)
+	}
+	RecordError("Error reading WAL:", fmt.Errorf("%w; dropping %d bytes from there, the whole file is kept as %s.corrupt", damage, scan.dropped, path))
+	return scan, nil
+}
@@ -0,0 +1,124 @@
+package kvstore
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeWAL writes keys a, b and c to a new WAL in dir and returns its path
+func writeWAL(t *testing.T, dir string) string {
+	t.Helper()
+	path := filepath.Join(dir, "kvs.wal")
+	kvs := NewKeyValueStore()
+	if _, err := kvs.OpenWAL(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"a", "b", "c"} {
+		kvs.SET(key, "v")
+	}
+	if err := kvs.CloseWAL(); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestWALReplaysAfterTheSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	backup, wal := filepath.Join(dir, "backup.snap"), filepath.Join(dir, "kvs.wal")
+	kvs := NewKeyValueStore()
+	kvs.SetBackup(backup, 0)
+	if _, err := kvs.OpenWAL(wal, nil); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SET("a", "1")
+	kvs.SET("b", "1")
+	if err := WriteBackup(kvs); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SET("a", "2")
+	kvs.DELETE("b")
+	kvs.SET("c", "1")
+	// a crash: the log is left as it is, not rewritten
+	if err := kvs.CommitWAL(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { kvs.CloseWAL() })
+
+	recovered := NewKeyValueStore()
+	if _, err := RestoreBackup(recovered, backup); err != nil {
+		t.Fatal(err)
+	}
+	replay, err := recovered.OpenWAL(wal, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.CloseWAL()
+	if replay.Skipped != 2 || replay.Records != 3 || replay.Damaged != 0 {
+		t.Errorf("replay %+v, want 2 skipped and 3 records", replay)
+	}
+	if value, _ := recovered.GET("a"); value != "2" {
+		t.Errorf("a = %q, want 2", value)
+	}
+	if _, found := recovered.GET("b"); found {
+		t.Error("b came back after its delete")
+	}
+	if _, found := recovered.GET("c"); !found {
+		t.Error("c lost")
+	}
+}
+
+func TestWALDropsATornTail(t *testing.T) {
+	path := writeWAL(t, t.TempDir())
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"seq":4,"op":"SET","key":"d"`)
+	f.Close()
+	kvs := NewKeyValueStore()
+	replay, err := kvs.OpenWAL(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvs.CloseWAL()
+	if replay.Records != 3 || replay.Dropped == 0 {
+		t.Errorf("replay %+v, want 3 records and the torn one dropped", replay)
+	}
+}
+
+func TestWALRefusesCorruptionBeforeValidRecords(t *testing.T) {
+	dir := t.TempDir()
+	path := writeWAL(t, dir)
+	data, _ := os.ReadFile(path)
+	// damage the second record, so the third one follows it
+	second := bytes.IndexByte(data, '\n') + 1
+	damaged := bytes.Clone(data)
+	damaged[second+bytes.Index(data[second:], []byte(`"b"`))+1] = 'x'
+	if err := os.WriteFile(path, damaged, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewKeyValueStore().OpenWAL(path, nil); !errors.Is(err, ErrWALCorrupt) {
+		t.Fatalf("OpenWAL of a corrupt log = %v, want ErrWALCorrupt", err)
+	}
+	if after, _ := os.ReadFile(path); !bytes.Equal(after, damaged) {
+		t.Error("a refused WAL was changed")
+	}
+
+	kvs := NewKeyValueStore()
+	kvs.SetWALRecovery(WALTruncate)
+	replay, err := kvs.OpenWAL(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kvs.CloseWAL()
+	if replay.Records != 1 || kvs.Len() != 1 {
+		t.Errorf("replay %+v, %d keys; want only the record before the damage", replay, kvs.Len())
+	}
+	if kept, _ := os.ReadFile(path + ".corrupt"); !bytes.Equal(kept, damaged) {
+		t.Error("the corrupt log wasn't kept")
+	}
+}
@@ -0,0 +1,207 @@
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// ErrNoWAL is returned for a rewrite of a store without a WAL
+var ErrNoWAL = errors.New("no WAL is open")
+
+// ErrWALRewriteRunning is returned for a rewrite while one is running
+var ErrWALRewriteRunning = errors.New("WAL rewrite already running")
+
+// walRewrite is the state of the rewrites of a WAL; the WAL's lock
+// guards it
+type walRewrite struct {
+	running bool
+	last    BackupResult
+}
+
+// walCut is a rewrite begun: the entries live at the cut, and where the
+// log stood then
+type walCut struct {
+	w       *WAL
+	entries []walRecord
+	offset  int64
+	start   time.Time
+	failed  error // the WAL's at the cut
+}
+
+// beginRewrite claims the rewrite of the WAL of kvs and copies the live
+// entries, sealed as the log holds them
+func (kvs *KeyValueStore) beginRewrite() (*walCut, error) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	w := kvs.wal
+	if w == nil {
+		return nil, ErrNoWAL
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.rewrite.running {
+		return nil, ErrWALRewriteRunning
+	}
+	w.rewrite.running = true
+	c := &walCut{w: w, offset: w.size, start: time.Now(), failed: w.failed}
+	aead := encryptionOf(kvs.data)
+	kvs.data.each(func(key string, item KeyValue) bool {
+		if kvs.expired(item, c.start) {
+			return true
+		}
+		if aead != nil {
+			item = sealText(aead, key, item)
+		}
+		c.entries = append(c.entries, walRecord{Seq: w.seq, Op: walSet, Key: key, Entry: &item})
+		return true
+	})
+	return c, nil
+}
+
+// finish writes the entries of c to a new log, then appends what the old
+// one got since the cut and puts the new one in its place
+func (c *walCut) finish() (err error) {
+	w := c.w
+	defer func() {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		w.rewrite.running = false
+		w.rewrite.last = BackupResult{Time: time.Now(), Duration: time.Since(c.start), Err: err}
+	}()
+	file, err := os.CreateTemp(filepath.Dir(w.path), filepath.Base(w.path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	placed := false
+	defer func() {
+		if err != nil && !placed {
+			file.Close()
+			os.Remove(file.Name())
+		}
+	}()
+	out := bufio.NewWriter(file)
+	for _, r := range c.entries {
+		line, err := encodeWALRecord(r)
+		if err != nil {
+			return err
+		}
+		out.Write(append(w.cipher.sealLine(line), '\n'))
+	}
+	if err := out.Flush(); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+
+	// appends wait from here, for as long as copying the writes since the
+	// cut takes
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.file == nil {
+		return ErrNoWAL
+	}
+	old, err := os.Open(w.path)
+	if err != nil {
+		return err
+	}
+	defer old.Close()
+	if _, err := old.Seek(c.offset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := io.Copy(file, old); err != nil {
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		return err
+	}
+	size, err := file.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(file.Name(), w.path); err != nil {
+		return err
+	}
+	w.file.Close()
+	w.file, w.size, w.base, w.synced = file, size, size, w.seq
+	if w.failed == c.failed {
+		// stopped before the cut, so the entries hold every change since
+		w.failed = nil
+	}
+	placed = true
+	// until the directory is synced a crash may bring the old log back
+	return syncDir(filepath.Dir(w.path))
+}
+
+// RewriteWAL replaces the write-ahead log with a compact one: a record
+// of each live key as it is now, followed by what was logged while the
+// rewrite ran. Writes carry on meanwhile and go to the old log until the
+// new one takes its place, so none is lost, and a crash during the
+// rewrite leaves the old log as it was. It also starts a log that a
+// failed append stopped again, see WALError.
+func (kvs *KeyValueStore) RewriteWAL() error {
+	c, err := kvs.beginRewrite()
+	if err != nil {
+		return err
+	}
+	return c.finish()
+}
+
+// StartWALRewrite runs RewriteWAL in the background and reports whether
+// it started one; it starts none while one is running or if there is no
+// WAL. WALStats tells when it is done.
+func StartWALRewrite(kvs *KeyValueStore) bool {
+	c, err := kvs.beginRewrite()
+	if err != nil {
+		return false
+	}
+	go func() {
+		if err := c.finish(); err != nil {
+			RecordError("Error rewriting WAL:", err)
+			return
+		}
+		Logf(LogInfo, "WAL rewritten")
+	}()
+	return true
+}
+
+// SetWALRewrite makes CompactWAL rewrite the WAL once it has grown by
+// percent since the last rewrite, or since it was opened, and is at least
+// minSize bytes; percent <= 0 turns that off
+func (kvs *KeyValueStore) SetWALRewrite(percent int, minSize int64) {
+	kvs.mu.Lock()
+	defer kvs.mu.Unlock()
+	kvs.walGrowth, kvs.walMinSize = max(percent, 0), minSize
+}
+
+// WALRewrite returns what SetWALRewrite set
+func (kvs *KeyValueStore) WALRewrite() (percent int, minSize int64) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	return kvs.walGrowth, kvs.walMinSize
+}
+
+// CompactWAL starts a rewrite of the WAL of kvs whenever it has grown as
+// SetWALRewrite says, checking every ClearInterval, until ctx is done
+func CompactWAL(ctx context.Context, kvs *KeyValueStore) {
+	ticker := time.NewTicker(ClearInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			percent, minSize := kvs.WALRewrite()
+			stats := kvs.WALStats()
+			if percent > 0 && stats.Path != "" && !stats.Rewriting && stats.Bytes > stats.BaseBytes &&
+				stats.Bytes >= minSize && stats.Bytes >= stats.BaseBytes+stats.BaseBytes*int64(percent)/100 {
+				StartWALRewrite(kvs)
+			}
+		}
+	}
+}
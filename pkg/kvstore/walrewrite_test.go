@@ -0,0 +1,40 @@
+package kvstore
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestRewriteWALKeepsEveryKey(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kvs.wal")
+	kvs := NewKeyValueStore()
+	if _, err := kvs.OpenWAL(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	kvs.SET("a", "1")
+	kvs.SET("a", "2")
+	kvs.SET("b", "1")
+	kvs.DELETE("b")
+	if err := kvs.RewriteWAL(); err != nil {
+		t.Fatal(err)
+	}
+	// written to the new log after it took the old one's place
+	kvs.SET("c", "1")
+	if err := kvs.CloseWAL(); err != nil {
+		t.Fatal(err)
+	}
+
+	replayed := NewKeyValueStore()
+	if _, err := replayed.OpenWAL(path, nil); err != nil {
+		t.Fatal(err)
+	}
+	defer replayed.CloseWAL()
+	for key, want := range map[string]string{"a": "2", "c": "1"} {
+		if value, found := replayed.GET(key); !found || value != want {
+			t.Errorf("GET %s = %q, %v; want %q", key, value, found, want)
+		}
+	}
+	if _, found := replayed.GET("b"); found || replayed.Len() != 2 {
+		t.Errorf("replayed %d keys, want a and c", replayed.Len())
+	}
+}
@@ -0,0 +1,90 @@
+package kvstore
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// warmup paces cache misses while the cache is cold: their rate ramps
+// linearly from `from` to `to` per second over window, then is unlimited
+type warmup struct {
+	mu        sync.Mutex
+	window    time.Duration
+	from, to  float64
+	start     time.Time
+	next      time.Time // when the next miss may go through
+	throttled atomic.Uint64
+}
+
+// restart begins the window again, e.g. once the cache was emptied
+func (w *warmup) restart(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.start = now
+	w.next = now
+}
+
+// delay reserves a slot for one miss and returns how long it must wait
+func (w *warmup) delay(now time.Time) time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	end := w.start.Add(w.window)
+	if w.window <= 0 || !now.Before(end) {
+		return 0
+	}
+	at := w.next
+	if at.Before(now) {
+		at = now
+	}
+	if !at.Before(end) {
+		return end.Sub(now)
+	}
+	rate := w.from + (w.to-w.from)*float64(at.Sub(w.start))/float64(w.window)
+	w.next = at.Add(time.Duration(float64(time.Second) / rate))
+	return at.Sub(now)
+}
+
+// wait holds a miss back until the warm-up lets it reach the store, or
+// returns ctx's error if ctx is done first. A miss that gives up keeps its
+// turn, so the ones behind it still wait theirs.
+func (w *warmup) wait(ctx context.Context) error {
+	d := w.delay(time.Now())
+	if d <= 0 {
+		return nil
+	}
+	w.throttled.Add(1)
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// SetWarmup protects the store behind a cold cache from a stampede of
+// misses, e.g. right after a restart: for the next window, misses reach
+// the store at a rate ramping linearly from `from` to `to` per second and
+// wait their turn beyond it. Concurrent misses on one key still share a
+// single read. Flush starts the window again. A zero window turns the
+// throttle off.
+func (sp *ServerProxy) SetWarmup(window time.Duration, from, to float64) {
+	if from <= 0 {
+		from = 1
+	}
+	if to < from {
+		to = from
+	}
+	sp.warmup.mu.Lock()
+	sp.warmup.window, sp.warmup.from, sp.warmup.to = window, from, to
+	sp.warmup.mu.Unlock()
+	sp.warmup.restart(time.Now())
+}
+
+// ThrottledMisses is how many cache misses waited for the warm-up
+func (sp *ServerProxy) ThrottledMisses() uint64 {
+	return sp.warmup.throttled.Load()
+}
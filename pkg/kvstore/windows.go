@@ -0,0 +1,96 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultWindowBuckets is how many buckets a counter window keeps when the
+// caller does not ask for a retention
+const DefaultWindowBuckets = 60
+
+// counterWindow is a set of per-bucket counters for one key. Buckets are
+// indexed by their start time divided by the bucket width.
+type counterWindow struct {
+	bucket    time.Duration
+	retention time.Duration
+	counts    map[int64]int64
+}
+
+// counterWindows holds every counter window, apart from the string keys
+type counterWindows struct {
+	mu      sync.Mutex
+	windows map[string]*counterWindow
+}
+
+// WINDOWINCR adds one to the current bucket of key's counter window and
+// returns the bucket's new count. Buckets older than retention (default
+// DefaultWindowBuckets buckets, kept until a new retention is given) are
+// dropped. A window keeps the bucket width it was created with; ok is false
+// if a different width is asked for.
+func (kvs *KeyValueStore) WINDOWINCR(key string, bucket, retention time.Duration) (count int64, ok bool) {
+	if bucket <= 0 {
+		return 0, false
+	}
+	cw := &kvs.windows
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	w, exists := cw.windows[key]
+	if !exists {
+		w = &counterWindow{bucket: bucket, retention: bucket * DefaultWindowBuckets, counts: make(map[int64]int64)}
+		cw.windows[key] = w
+	}
+	if w.bucket != bucket {
+		return 0, false
+	}
+	if retention >= bucket {
+		w.retention = retention
+	}
+	now := kvs.now()
+	w.prune(now)
+	idx := now.UnixNano() / int64(w.bucket)
+	w.counts[idx]++
+	return w.counts[idx], true
+}
+
+// WINDOWSUM returns the total of key's buckets that overlap the last span
+func (kvs *KeyValueStore) WINDOWSUM(key string, span time.Duration) (sum int64, found bool) {
+	cw := &kvs.windows
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	w, ok := cw.windows[key]
+	if !ok {
+		return 0, false
+	}
+	now := kvs.now()
+	w.prune(now)
+	from := now.Add(-span).UnixNano() / int64(w.bucket)
+	for idx, count := range w.counts {
+		if idx >= from {
+			sum += count
+		}
+	}
+	return sum, true
+}
+
+// pruneWindows drops expired buckets and windows with no buckets left
+func (kvs *KeyValueStore) pruneWindows(now time.Time) {
+	cw := &kvs.windows
+	cw.mu.Lock()
+	defer cw.mu.Unlock()
+	for key, w := range cw.windows {
+		w.prune(now)
+		if len(w.counts) == 0 {
+			delete(cw.windows, key)
+		}
+	}
+}
+
+func (w *counterWindow) prune(now time.Time) {
+	oldest := now.Add(-w.retention).UnixNano() / int64(w.bucket)
+	for idx := range w.counts {
+		if idx < oldest {
+			delete(w.counts, idx)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultWriteBackDelay and DefaultWriteBackPending bound the writes a
+// ServerProxy buffers under WriteBack unless WriteBackLimits says otherwise
+const (
+	DefaultWriteBackDelay   = time.Second
+	DefaultWriteBackPending = 10000
+)
+
+// CacheStrategy is what a ServerProxy does with its cache when a key is
+// SET or UPDATEd through it. Every other write drops the key from the
+// cache whatever the strategy.
+type CacheStrategy int
+
+const (
+	// CacheAside writes to the store and drops the key from the cache, so
+	// the next read loads it again
+	CacheAside CacheStrategy = iota
+	// WriteThrough writes to the store and caches the value written, so
+	// the next read is a hit
+	WriteThrough
+	// WriteBack caches the value and buffers the write, which reaches the
+	// store within WriteBackLimits, see ServerProxy.WriteBack
+	WriteBack
+)
+
+// ParseCacheStrategy parses "cache-aside", "write-through" or
+// "write-back", the names printed by String
+func ParseCacheStrategy(name string) (CacheStrategy, error) {
+	switch name {
+	case "cache-aside":
+		return CacheAside, nil
+	case "write-through":
+		return WriteThrough, nil
+	case "write-back":
+		return WriteBack, nil
+	}
+	return CacheAside, fmt.Errorf("unknown cache strategy %q, expected cache-aside, write-through or write-back", name)
+}
+
+func (s CacheStrategy) String() string {
+	switch s {
+	case WriteThrough:
+		return "write-through"
+	case WriteBack:
+		return "write-back"
+	}
+	return "cache-aside"
+}
+
+// WriteBackLimits bound what WriteBack can lose in a crash: a buffered
+// write reaches the store within Delay, and no more than MaxPending keys
+// wait at once, the write that would make more flushing them all first.
+// Zero means DefaultWriteBackDelay and DefaultWriteBackPending.
+type WriteBackLimits struct {
+	Delay      time.Duration
+	MaxPending int
+}
+
+// writeBack is the write buffer of a ServerProxy, guarded by sp.mu
+type writeBack struct {
+	strategy CacheStrategy
+	limits   WriteBackLimits
+	pending  map[string]KeyValue
+	flushed  uint64
+	failed   uint64
+}
+
+// SetCacheStrategy sets what SET and UPDATE do with the cache, CacheAside
+// by default. Under WriteBack a SET is answered once it is cached, before
+// the store has it; it fails later, if the store refuses it, logged and
+// counted in CacheStats. Reads through the proxy see it at once, but
+// anything reading the store directly, or its write-ahead log, only once
+// it is flushed: every other write through the proxy flushes the buffer
+// first, and so does Flush. Run WriteBack while the strategy is WriteBack.
+// Leaving it flushes what is buffered.
+func (sp *ServerProxy) SetCacheStrategy(strategy CacheStrategy, limits WriteBackLimits) {
+	if limits.Delay <= 0 {
+		limits.Delay = DefaultWriteBackDelay
+	}
+	if limits.MaxPending <= 0 {
+		limits.MaxPending = DefaultWriteBackPending
+	}
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	if strategy != WriteBack {
+		sp.flushWrites()
+	}
+	sp.writes.strategy, sp.writes.limits = strategy, limits
+}
+
+// CacheStrategy returns the strategy of SetCacheStrategy and its limits
+func (sp *ServerProxy) CacheStrategy() (CacheStrategy, WriteBackLimits) {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.writes.strategy, sp.writes.limits
+}
+
+// WriteBack flushes the writes buffered under WriteBack every Delay of its
+// limits until ctx is done, and then once more
+func (sp *ServerProxy) WriteBack(ctx context.Context) {
+	_, limits := sp.CacheStrategy()
+	ticker := time.NewTicker(limits.Delay)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			sp.FlushWrites()
+			return
+		case <-ticker.C:
+			sp.FlushWrites()
+		}
+	}
+}
+
+// FlushWrites writes what WriteBack buffered to the store now and returns
+// how many keys it wrote
+func (sp *ServerProxy) FlushWrites() int {
+	sp.mu.Lock()
+	defer sp.mu.Unlock()
+	return sp.flushWrites()
+}
+
+// lockWrite takes sp.mu for a write other than a buffered SET, flushing
+// the buffer first so the write sees the keys in it
+func (sp *ServerProxy) lockWrite() {
+	// wait for room for the event without holding sp.mu, which readers of
+	// Events may need
+	sp.kvs.events.wait()
+	sp.mu.Lock()
+	sp.flushWrites()
+}
+
+// buffer caches a SET of key under WriteBack and buffers it for the
+// store, caller must hold sp.mu
+func (sp *ServerProxy) buffer(key, value string, ttl time.Duration, sum uint32) (message string, ok bool) {
+	item := KeyValue{Value: value, Timestamp: sp.kvs.now(), TTL: ttl, Checksum: sum}
+	if !item.Intact() {
+		return protocol.MsgIntegrity, false
+	}
+	if t, found := sp.kvs.TYPE(key); found && t == TypeSequence {
+		// refused now, as the store would refuse it when flushed
+		return protocol.MsgWrongType, false
+	}
+	if len(sp.writes.pending) >= sp.writes.limits.MaxPending {
+		sp.flushWrites()
+	}
+	if sp.writes.pending == nil {
+		sp.writes.pending = make(map[string]KeyValue)
+	}
+	sp.writes.pending[key] = item
+	sp.invalidate(key)
+	sp.store(key, item)
+	return protocol.MsgValueSet, true
+}
+
+// flushWrites writes the buffered SETs to the store, caching each at the
+// revision it was written at, and returns how many it wrote; caller must
+// hold sp.mu
+func (sp *ServerProxy) flushWrites() int {
+	if len(sp.writes.pending) == 0 {
+		return 0
+	}
+	// changes made underneath since drop the writes they overtook
+	sp.sync()
+	pending := sp.writes.pending
+	sp.writes.pending = nil
+	n := 0
+	for key, kv := range pending {
+		_, item, _, message, ok := sp.kvs.set(key, kv.Value, kv.TTL, kv.Checksum, nil)
+		if !ok {
+			sp.writes.failed++
+			sp.invalidate(key)
+			RecordError("Error writing back cache:", fmt.Errorf("SET of %q refused: %s", key, message))
+			continue
+		}
+		sp.writes.flushed++
+		n++
+		if _, cached := sp.cache.peek(key); cached {
+			sp.store(key, item)
+		}
+	}
+	return n
+}
+
+// keep reports whether the cache must not evict key: it is pinned, or a
+// buffered write the store doesn't have yet
+func (sp *ServerProxy) keep(key string) bool {
+	_, pending := sp.writes.pending[key]
+	return pending || sp.kvs.Pinned(key)
+}
@@ -0,0 +1,390 @@
+package kvstore
+
+import (
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// ScoredMember is a member of a sorted set with its score
+type ScoredMember struct {
+	Member string
+	Score  float64
+}
+
+// zsetLink is a link of a zsetNode on one level: the next node, and how
+// many nodes on level 0 it skips over, itself included
+type zsetLink struct {
+	node *zsetNode
+	span int
+}
+
+type zsetNode struct {
+	ScoredMember
+	next []zsetLink
+}
+
+// zset is a sorted set as a skip list ordered by score, then by member.
+// The spans on its links make the rank of a member, and the member at a
+// rank, O(log n) to find.
+type zset struct {
+	head   zsetNode
+	level  int
+	length int
+	scores map[string]float64
+}
+
+func newZSet() *zset {
+	return &zset{head: zsetNode{next: make([]zsetLink, skipMaxLevel)}, level: 1, scores: make(map[string]float64)}
+}
+
+// before reports whether n sorts before score and member
+func (n *zsetNode) before(score float64, member string) bool {
+	return n.Score < score || n.Score == score && n.Member < member
+}
+
+// add sets the score of member and reports whether it is new
+func (z *zset) add(member string, score float64) (added bool) {
+	old, exists := z.scores[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.unlink(member, old)
+	}
+	z.scores[member] = score
+	z.link(member, score)
+	return !exists
+}
+
+// remove takes member out and reports whether it was in
+func (z *zset) remove(member string) bool {
+	score, ok := z.scores[member]
+	if ok {
+		delete(z.scores, member)
+		z.unlink(member, score)
+	}
+	return ok
+}
+
+func (z *zset) link(member string, score float64) {
+	var prev [skipMaxLevel]*zsetNode
+	var rank [skipMaxLevel]int
+	n := &z.head
+	for i := z.level - 1; i >= 0; i-- {
+		if i < z.level-1 {
+			rank[i] = rank[i+1]
+		}
+		for n.next[i].node != nil && n.next[i].node.before(score, member) {
+			rank[i] += n.next[i].span
+			n = n.next[i].node
+		}
+		prev[i] = n
+	}
+	level := skipLevel()
+	for ; z.level < level; z.level++ {
+		prev[z.level] = &z.head
+		z.head.next[z.level].span = z.length
+	}
+	n = &zsetNode{ScoredMember: ScoredMember{member, score}, next: make([]zsetLink, level)}
+	for i := 0; i < level; i++ {
+		n.next[i].node = prev[i].next[i].node
+		prev[i].next[i].node = n
+		n.next[i].span = prev[i].next[i].span - (rank[0] - rank[i])
+		prev[i].next[i].span = rank[0] - rank[i] + 1
+	}
+	for i := level; i < z.level; i++ {
+		prev[i].next[i].span++
+	}
+	z.length++
+}
+
+func (z *zset) unlink(member string, score float64) {
+	var prev [skipMaxLevel]*zsetNode
+	n := &z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for n.next[i].node != nil && n.next[i].node.before(score, member) {
+			n = n.next[i].node
+		}
+		prev[i] = n
+	}
+	n = n.next[0].node
+	for i := 0; i < z.level; i++ {
+		if prev[i].next[i].node == n {
+			prev[i].next[i].span += n.next[i].span - 1
+			prev[i].next[i].node = n.next[i].node
+		} else {
+			prev[i].next[i].span--
+		}
+	}
+	for z.level > 1 && z.head.next[z.level-1].node == nil {
+		z.level--
+	}
+	z.length--
+}
+
+// rank returns the index of member in order, from 0
+func (z *zset) rank(member string) (int, bool) {
+	score, ok := z.scores[member]
+	if !ok {
+		return 0, false
+	}
+	rank := 0
+	n := &z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for n.next[i].node != nil && (n.next[i].node.before(score, member) || n.next[i].node.Member == member) {
+			rank += n.next[i].span
+			n = n.next[i].node
+		}
+		if n != &z.head && n.Member == member {
+			return rank - 1, true
+		}
+	}
+	return 0, false
+}
+
+// at returns the node at index rank, nil past the end
+func (z *zset) at(rank int) *zsetNode {
+	traversed := 0
+	n := &z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for n.next[i].node != nil && traversed+n.next[i].span <= rank+1 {
+			traversed += n.next[i].span
+			n = n.next[i].node
+		}
+		if traversed == rank+1 {
+			return n
+		}
+	}
+	return nil
+}
+
+// seek returns the first node scored min or more
+func (z *zset) seek(min float64) *zsetNode {
+	n := &z.head
+	for i := z.level - 1; i >= 0; i-- {
+		for n.next[i].node != nil && n.next[i].node.Score < min {
+			n = n.next[i].node
+		}
+	}
+	return n.next[0].node
+}
+
+// zsetCacheSize bounds how many sorted sets a store keeps decoded
+const zsetCacheSize = 64
+
+// zsetCache keeps the sorted sets used lately decoded into skip lists, by
+// key, with the revision of the entry each was decoded from, so reads
+// don't decode the whole set. Any write to the key gives its entry a new
+// revision, which misses the cache.
+type zsetCache struct {
+	mu   sync.Mutex
+	sets map[string]cachedZSet
+}
+
+type cachedZSet struct {
+	rev uint64
+	z   *zset
+}
+
+func (c *zsetCache) get(key string, rev uint64) *zset {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if cached, ok := c.sets[key]; ok && cached.rev == rev {
+		return cached.z
+	}
+	return nil
+}
+
+func (c *zsetCache) put(key string, rev uint64, z *zset) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sets == nil {
+		c.sets = make(map[string]cachedZSet)
+	}
+	if _, ok := c.sets[key]; !ok && len(c.sets) >= zsetCacheSize {
+		// any one will do
+		for other := range c.sets {
+			delete(c.sets, other)
+			break
+		}
+	}
+	c.sets[key] = cachedZSet{rev, z}
+}
+
+func (c *zsetCache) drop(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.sets, key)
+}
+
+// reset empties the cache, for loads that may reuse revisions
+func (c *zsetCache) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.sets = nil
+}
+
+// ZADD sets the score of each member in members to the score at the same
+// index in scores in the sorted set at key, creating it with ttl, or the
+// store's default, if it is missing or expired; an existing set keeps its
+// remaining lifetime. added is how many of the members are new; if no
+// score changed, nothing is written and item is empty. Otherwise item is
+// the entry written, its Value the whole set as protocol.EncodeZSet makes
+// it. A key that holds another
+// type fails with protocol.MsgWrongType, and the write is refused over
+// quota, see SETSUM.
+func (kvs *KeyValueStore) ZADD(key string, members []string, scores []float64, ttl time.Duration) (added int, item KeyValue, message string, ok bool) {
+	item, _, message, ok = kvs.modifyZSet(key, ttl, func(z *zset) (changed bool) {
+		for i, member := range members {
+			if old, exists := z.scores[member]; exists && old == scores[i] {
+				continue
+			}
+			if z.add(member, scores[i]) {
+				added++
+			}
+			changed = true
+		}
+		return changed
+	})
+	return added, item, message, ok
+}
+
+// ZREM removes members from the sorted set at key and returns how many of
+// them it had; if none, nothing is written. Removing the last member
+// deletes the key, reported by deleted; otherwise item is the entry
+// written, see ZADD.
+func (kvs *KeyValueStore) ZREM(key string, members []string) (removed int, item KeyValue, deleted bool, message string, ok bool) {
+	item, deleted, message, ok = kvs.modifyZSet(key, 0, func(z *zset) bool {
+		for _, member := range members {
+			if z.remove(member) {
+				removed++
+			}
+		}
+		return removed > 0
+	})
+	return removed, item, deleted, message, ok
+}
+
+// ZRANGE returns the members of the sorted set at key from index start to
+// stop in order, both included; negative indexes count from the end, -1
+// being the last member. found is false if the key is missing. A key that
+// holds another type fails with protocol.MsgWrongType.
+func (kvs *KeyValueStore) ZRANGE(key string, start, stop int) (members []ScoredMember, found bool, message string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	z, found, message := kvs.readZSet(key)
+	if !found {
+		return nil, false, message
+	}
+	if start < 0 {
+		start = max(z.length+start, 0)
+	}
+	if stop < 0 {
+		stop += z.length
+	}
+	stop = min(stop, z.length-1)
+	members = []ScoredMember{}
+	if start > stop {
+		return members, true, ""
+	}
+	for n := z.at(start); n != nil && len(members) <= stop-start; n = n.next[0].node {
+		members = append(members, n.ScoredMember)
+	}
+	return members, true, ""
+}
+
+// ZRANGEBYSCORE returns, in order, the members of the sorted set at key
+// scored from min to max, both included, at most limit of them if limit
+// is above zero; see ZRANGE.
+func (kvs *KeyValueStore) ZRANGEBYSCORE(key string, min, max float64, limit int) (members []ScoredMember, found bool, message string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	z, found, message := kvs.readZSet(key)
+	if !found {
+		return nil, false, message
+	}
+	members = []ScoredMember{}
+	for n := z.seek(min); n != nil && n.Score <= max; n = n.next[0].node {
+		if limit > 0 && len(members) >= limit {
+			break
+		}
+		members = append(members, n.ScoredMember)
+	}
+	return members, true, ""
+}
+
+// ZRANK returns the index of member in the sorted set at key, from 0 for
+// the lowest score; found is false if either is missing. A key that holds
+// another type fails with protocol.MsgWrongType.
+func (kvs *KeyValueStore) ZRANK(key, member string) (rank int, found bool, message string) {
+	kvs.mu.RLock()
+	defer kvs.mu.RUnlock()
+	z, found, message := kvs.readZSet(key)
+	if !found {
+		return 0, false, message
+	}
+	rank, found = z.rank(member)
+	return rank, found, ""
+}
+
+// readZSet returns the sorted set at key from the cache, or decodes it
+// into the cache; caller must hold kvs.mu and must not change it
+func (kvs *KeyValueStore) readZSet(key string) (z *zset, found bool, message string) {
+	item, ok := kvs.data.get(key)
+	if !ok || kvs.expired(item, kvs.now()) {
+		return nil, false, ""
+	}
+	if item.Type != TypeZSet {
+		return nil, false, protocol.MsgWrongType
+	}
+	if z = kvs.zsets.get(key, item.Revision); z != nil {
+		return z, true, ""
+	}
+	z, err := decodeZSet(item.Value)
+	if err != nil {
+		return nil, false, protocol.MsgIntegrity
+	}
+	kvs.zsets.put(key, item.Revision, z)
+	return z, true, ""
+}
+
+func decodeZSet(value string) (*zset, error) {
+	scores, err := protocol.DecodeZSet(value)
+	if err != nil {
+		return nil, err
+	}
+	z := newZSet()
+	for member, score := range scores {
+		z.add(member, score)
+	}
+	return z, nil
+}
+
+// modifyZSet is modifyTyped for the sorted set at key, decoded for fn. The
+// set fn changes is taken out of the cache meanwhile, and goes back with
+// the new revision once written.
+func (kvs *KeyValueStore) modifyZSet(key string, ttl time.Duration, fn func(z *zset) (changed bool)) (item KeyValue, deleted bool, message string, ok bool) {
+	var z *zset
+	item, deleted, message, ok = kvs.modifyTyped(key, TypeZSet, ttl, func(value string) (string, bool, string) {
+		if old, exists := kvs.data.get(key); exists && value != "" {
+			z = kvs.zsets.get(key, old.Revision)
+		}
+		kvs.zsets.drop(key)
+		if z == nil {
+			var err error
+			if z, err = decodeZSet(value); err != nil {
+				return "", false, protocol.MsgIntegrity
+			}
+		}
+		if !fn(z) {
+			return "", false, ""
+		}
+		return protocol.EncodeZSet(z.scores), true, ""
+	})
+	if ok && !deleted && z != nil && item.Revision != 0 {
+		kvs.zsets.put(key, item.Revision, z)
+	}
+	return item, deleted, message, ok
+}
@@ -0,0 +1,16 @@
+package protocol
+
+import "hash/crc32"
+
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// Checksum is the CRC-32C of value that a client may send with SET or
+// UPDATE and gets back with GET. It is never zero, so a zero Checksum
+// means none was sent.
+func Checksum(value string) uint32 {
+	sum := crc32.Checksum([]byte(value), castagnoli)
+	if sum == 0 {
+		sum = 1
+	}
+	return sum
+}
@@ -0,0 +1,43 @@
+package protocol
+
+import (
+	"fmt"
+	"hash/crc32"
+)
+
+// NumSlots is how many hash slots the keyspace of a cluster is split into.
+// Every key belongs to one slot and every slot to one server; a server
+// answers a request for a key in a slot it does not own with MOVED and the
+// owner's address in Value. While a slot moves between servers, its owner
+// answers ASK with the other server's address for the keys it no longer
+// holds, and the client sends that one request there with Asking set;
+// requests on several keys of a moving slot are answered TRYAGAIN.
+const NumSlots = 1024
+
+// Slot returns the hash slot of key.
+func Slot(key string) int {
+	return int(crc32.ChecksumIEEE([]byte(key)) % NumSlots)
+}
+
+// SlotRange is a run of slots owned by one server.
+type SlotRange struct {
+	First, Last int
+	Addr        string
+}
+
+// String formats r as a CLUSTER INFO line.
+func (r SlotRange) String() string {
+	return fmt.Sprintf("%d-%d %s", r.First, r.Last, r.Addr)
+}
+
+// ParseSlotRange parses a CLUSTER INFO line.
+func ParseSlotRange(line string) (SlotRange, error) {
+	var r SlotRange
+	if _, err := fmt.Sscanf(line, "%d-%d %s", &r.First, &r.Last, &r.Addr); err != nil {
+		return r, fmt.Errorf("bad slot range %q: %w", line, err)
+	}
+	if r.First < 0 || r.Last >= NumSlots || r.First > r.Last {
+		return r, fmt.Errorf("bad slot range %q", line)
+	}
+	return r, nil
+}
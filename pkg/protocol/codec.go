@@ -0,0 +1,71 @@
+package protocol
+
+import (
+	"encoding/gob"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Encoder writes protocol messages to a stream.
+type Encoder interface {
+	Encode(v any) error
+}
+
+// Decoder reads protocol messages from a stream.
+type Decoder interface {
+	Decode(v any) error
+}
+
+// Codec is a wire encoding for Requests and Responses. Both ends of a
+// connection must use the same codec.
+type Codec interface {
+	Name() string
+	NewEncoder(w io.Writer) Encoder
+	NewDecoder(r io.Reader) Decoder
+}
+
+// Gob is the default codec.
+var Gob Codec = gobCodec{}
+
+type gobCodec struct{}
+
+func (gobCodec) Name() string                   { return "gob" }
+func (gobCodec) NewEncoder(w io.Writer) Encoder { return gob.NewEncoder(w) }
+func (gobCodec) NewDecoder(r io.Reader) Decoder { return gob.NewDecoder(r) }
+
+// JSON is a codec of one JSON object per message, for clients in
+// languages without gob. Strings travel as UTF-8, so values that are not
+// valid UTF-8 arrive mangled; binary values need gob.
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string                   { return "json" }
+func (jsonCodec) NewEncoder(w io.Writer) Encoder { return json.NewEncoder(w) }
+func (jsonCodec) NewDecoder(r io.Reader) Decoder { return json.NewDecoder(r) }
+
+// Codecs are the codecs this package provides.
+var Codecs = []Codec{Gob, JSON}
+
+// CodecByName returns the codec named name, one of Codecs.
+func CodecByName(name string) (Codec, error) {
+	for _, c := range Codecs {
+		if c.Name() == name {
+			return c, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown codec %q, want gob or json", name)
+}
+
+// SplitAddr splits an address such as "json://:8082" into the codec it
+// names, gob if it names none, and the address.
+func SplitAddr(addr string) (Codec, string, error) {
+	name, rest, ok := strings.Cut(addr, "://")
+	if !ok {
+		return Gob, addr, nil
+	}
+	c, err := CodecByName(name)
+	return c, rest, err
+}
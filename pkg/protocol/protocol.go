@@ -0,0 +1,1070 @@
+// Package protocol holds the request and response types shared by the kvs
+// client and server, so both sides encode exactly the same structs.
+package protocol
+
+import "time"
+
+// Version is the protocol version announced in HELLO.
+const Version = 1
+
+// Capabilities a server announces in its HELLO response Values.
+const (
+	// CapPersistent means the server answers many requests per connection;
+	// without it a client must use a new connection for every request.
+	CapPersistent = "persistent"
+	CapLocks      = "locks"
+	CapWindows    = "windows"
+	CapPubSub     = "pubsub"
+	CapJournal    = "journal"
+	CapDiagnose   = "diagnose"
+	CapPins       = "pins"
+	CapCluster    = "cluster"
+	CapChecksums  = "checksums"
+	CapUpdateTTL  = "update-ttl"
+	CapAdmin      = "admin"
+	CapList       = "list"
+	CapCommands   = "commands"
+	CapDBSize     = "dbsize"
+	CapRename     = "rename"
+	CapScan       = "scan"
+	CapRange      = "range"
+	CapBatch      = "batch"
+	CapCounters   = "counters"
+	CapAppend     = "append"
+	CapSetNX      = "setnx"
+	CapGetSet     = "getset"
+	CapCAS        = "cas"
+	CapRevisions  = "revisions"
+	CapReadTx     = "readtx"
+	CapMulti      = "multi"
+	CapWatch      = "watch"
+	CapWriteBatch = "writebatch"
+	CapLeases     = "leases"
+	CapHashes     = "hashes"
+	CapLists      = "lists"
+	CapSets       = "sets"
+	CapZSets      = "zsets"
+	CapStreams    = "streams"
+	CapBitmaps    = "bitmaps"
+	CapGeo        = "geo"
+	CapJSON       = "json"
+	CapSearch     = "search"
+	CapScripting  = "scripting"
+	CapPing       = "ping"
+	CapTracking   = "tracking"
+	CapReplicas   = "replicas"
+	CapFailover   = "failover"
+	CapStaleness  = "max-staleness"
+	CapHistory    = "history"
+)
+
+// CodecCapability is the capability announcing that a codec is accepted.
+func CodecCapability(name string) string {
+	return "codec:" + name
+}
+
+// CommandCapability is the capability announcing a custom action added to
+// the server, see server.RegisterCommand.
+func CommandCapability(action string) string {
+	return "command:" + action
+}
+
+// Actions understood by the server.
+const (
+	// HELLO carries the client's protocol version in Value; the server
+	// answers with its own version in Value and its capabilities in Values.
+	ActionHello = "HELLO"
+	// PING checks the server is up and serving: it returns Value, or
+	// "PONG" if it is empty, in Value.
+	ActionPing     = "PING"
+	ActionGet      = "GET"
+	ActionSet      = "SET"
+	ActionUpdate   = "UPDATE"
+	ActionDelete   = "DELETE"
+	ActionDiagnose = "DIAGNOSE"
+	ActionRLock    = "RLOCK"
+	ActionWLock    = "WLOCK"
+	ActionUnlock   = "UNLOCK"
+	ActionLocks    = "LOCKS"
+
+	// LOCK takes a lease lock, a key that names its holder: it sets Key to
+	// Owner for TTL, or the server's lock lease if zero, only if Key does
+	// not exist, and returns the fencing token in Revision. Tokens grow
+	// with every lock the server grants, so a resource that remembers the
+	// highest token it has seen can refuse a holder whose lease lapsed. A
+	// held lock fails with LOCKED, its holder in Value and token in
+	// Revision. UNLOCK with the token in Revision releases the lock, and
+	// RENEW restarts its lease, with TTL if set; either fails with
+	// LOCK_NOT_HELD once the lease has lapsed or the key was written
+	// since. Without a Revision, UNLOCK releases advisory locks as before.
+	ActionLock  = "LOCK"
+	ActionRenew = "RENEW"
+
+	// WINDOWINCR takes the bucket width in Value and an optional retention
+	// in TTL, WINDOWSUM takes the span to add up in Value; both as Go
+	// durations like "1m" or "1h".
+	ActionWindowIncr = "WINDOWINCR"
+	ActionWindowSum  = "WINDOWSUM"
+
+	// Durable pub/sub: Key is the channel, Owner the subscriber name,
+	// PUBLISH carries the payload in Value and ACK the message id. SUBSCRIBE
+	// also takes channel patterns like "news.*".
+	ActionPublish     = "PUBLISH"
+	ActionSubscribe   = "SUBSCRIBE"
+	ActionUnsubscribe = "UNSUBSCRIBE"
+	ActionFetch       = "FETCH"
+	ActionAck         = "ACK"
+
+	// JOURNAL returns the write journal after the revision in Value, at
+	// most Limit entries, plus the latest revision in Response.Value. With a
+	// Timeout it waits that long for a write if there are no entries yet.
+	ActionJournal = "JOURNAL"
+
+	// Replication: SYNC returns every key as a JSON snapshot in Value, the
+	// replication ID in Continue and the position it is at in Revision.
+	// With a Limit it streams the snapshot instead, Limit bytes of it at a
+	// time with their Checksum: while More is set, Continue asks for the
+	// next chunk, and may be sent again to resume after a dropped
+	// connection, until the stream expires and SYNC fails with RESYNC.
+	// PSYNC returns in Replication up to Limit writes after position
+	// Revision of the replication ID in Value, and the latest position in
+	// Revision, waiting up to Timeout for one if there are none yet; it
+	// fails with RESYNC if they are no longer kept, or the ID is from
+	// before the primary restarted or restored, and the replica calls SYNC
+	// again.
+	ActionSync  = "SYNC"
+	ActionPSync = "PSYNC"
+
+	// WAIT waits up to Timeout for Limit replicas to have every write the
+	// primary took before it, the caller's last included, and returns in
+	// Value how many have them, which may be fewer.
+	ActionWait = "WAIT"
+
+	// Failover groups: ROLE returns in Value the address of the primary as
+	// far as the server knows, empty if it doesn't, Found if that is this
+	// server and it takes writes, the failover epoch in Epoch and in Values
+	// the replication status, one "name: value" line each. VOTE asks for
+	// the server's vote for the candidate in Value, whose store is at
+	// Revision, to become primary in Epoch; Success is the vote, and
+	// Epoch the server's own. A VOTE with Key PRE only asks whether the
+	// server would vote, recording nothing, so a candidate that would lose
+	// does not start an epoch.
+	ActionRole = "ROLE"
+	ActionVote = "VOTE"
+	VotePre    = "PRE"
+
+	// Client-side caching: a GET with a client ID in Owner has the server
+	// track Key for that client, and INVALIDATIONS returns in Values the
+	// keys tracked for Owner that changed since, waiting up to Timeout for
+	// one if there are none. Each change is reported once; Message
+	// INVALIDATE_ALL tells the client to drop every key it caches.
+	ActionInvalidations = "INVALIDATIONS"
+
+	// PIN and UNPIN mark and unmark Key as protected from eviction.
+	ActionPin   = "PIN"
+	ActionUnpin = "UNPIN"
+
+	// CLUSTER with Value "INFO" returns the slot map in Values, one
+	// "first-last addr" line per range; none means a standalone server.
+	// With "MIGRATIONS" it returns the slots moving to or from the server,
+	// one "first-last migrating addr" or "first-last importing addr" line
+	// per range.
+	ActionCluster = "CLUSTER"
+
+	// LIST returns the keys and sub-directories directly under the
+	// directory in Key, "" for the root, in Entries, at most Limit of them
+	// if it is set, and the separator in Value. In a cluster it lists only
+	// the keys of the server it is sent to.
+	ActionList = "LIST"
+
+	// COMMANDS describes every action the server understands in
+	// Response.Commands, or only the one named in Key, and lists the
+	// messages any action may return in Values.
+	ActionCommands = "COMMANDS"
+
+	// DBSIZE returns the number of live keys in Value, leaving out expired
+	// ones not removed yet, and a "prefix: count" line per namespace in
+	// Values. In a cluster it counts only the keys of the server it is
+	// sent to.
+	ActionDBSize = "DBSIZE"
+
+	// SCAN returns a page of the keys matching the path.Match pattern in
+	// Key, all if empty, in Values, examining at most Limit keys, the
+	// server's default if zero. It pages with Continue, see Request; Value
+	// is accepted as the cursor too and Response.Value is the next one, "0"
+	// when the scan is done. In a cluster it scans only the keys of the
+	// server it is sent to.
+	ActionScan = "SCAN"
+
+	// RANGE returns in Values, in lexicographic order, the live keys from
+	// Key up to but not including Value, or to the last key if Value is
+	// empty; at most Limit of them if it is above zero, paging with
+	// Continue, see Request. It fails with UNORDERED on a server not
+	// keeping its keys sorted. In a cluster it returns only the keys of the
+	// server it is sent to.
+	ActionRange = "RANGE"
+
+	// SEARCH returns the live keys whose plain values hold every word in
+	// Value, in any case, best match first, each followed by its score in
+	// Values. A page skips the first Start matches and holds at most
+	// Limit, the server's default if zero; More reports whether there are
+	// more. Pages are by position, so a write between two of them may
+	// shift a match from one to the other. It fails with NO_INDEX on a
+	// server not keeping a search index. In a cluster it searches only the
+	// keys of the server it is sent to.
+	ActionSearch = "SEARCH"
+
+	// RENAME moves the value of Key, with its TTL, to the key in Value,
+	// replacing it if it exists, atomically. COPY sets the key in Value to
+	// the value of Key, with Key's remaining lifetime, or TTL from now if it
+	// is set. In a cluster both keys must be owned by the same server, or
+	// the request is refused with CROSSSLOT.
+	ActionRename = "RENAME"
+	ActionCopy   = "COPY"
+
+	// MGET reads every key in Keys and MSET sets each of them to the value
+	// at the same index in Values, with TTL, in one round trip; at most
+	// MaxBatchKeys keys. Response.Results has a KeyResult per key, in
+	// order. Each key succeeds or fails on its own, and readers may see
+	// some of an MSET's writes before the rest. In a cluster a key another
+	// server owns gets MOVED with that server in its Value.
+	ActionMGet = "MGET"
+	ActionMSet = "MSET"
+
+	// BATCH writes every key in Keys in one step, all or none: it deletes
+	// the keys Deletes marks and sets the rest to the value at the same
+	// index in Values, with TTL; at most MaxBatchKeys keys, in order. If
+	// any write is refused, such as for INTEGRITY or QUOTA_EXCEEDED, the
+	// writes before it are rolled back and BATCH fails with its message,
+	// which its KeyResult in Results also carries. Otherwise Results has
+	// the Revision of each write. No reader sees some of the writes
+	// without the rest. In a cluster every key must be owned by the server
+	// it is sent to, or the batch is refused with CROSSSLOT.
+	ActionBatch = "BATCH"
+
+	// SETNX is SET only if Key does not exist, checked and written in one
+	// step: Success reports whether it wrote, and Found whether the key
+	// was already there, with the message VALUE_EXISTS.
+	ActionSetNX = "SETNX"
+
+	// GETSET sets Key like SET and returns the value it replaced in Value,
+	// with Found reporting whether there was one. GETDEL deletes Key and
+	// returns its value like GET. Each reads and writes in one step, so no
+	// other write lands in between.
+	ActionGetSet = "GETSET"
+	ActionGetDel = "GETDEL"
+
+	// CAS sets Key like SET only if its value is Expect, or its revision
+	// is Revision if that is set, compared and written in one step. Otherwise nothing is written and it fails with
+	// CONFLICT, with the current value in Value and Found reporting
+	// whether the key exists, so the writer can recompute and try again.
+	ActionCAS = "CAS"
+
+	// BEGINREAD opens a read transaction, a point-in-time view of the
+	// server's keys, for TTL or the server's default, and returns its id in
+	// Value and the revision it reads at in Revision. A GET or MGET whose
+	// ReadTx is the id sees every key as it was then, however many writes
+	// land meanwhile, so reads of several keys, in one request or many, are
+	// never torn; keys that have expired since are missing all the same.
+	// ENDREAD closes the transaction named in ReadTx. Either fails with
+	// NO_READ_TX once it has ended or a restore replaced the keys. In a
+	// cluster a transaction covers the keys of the server it is opened on.
+	ActionBeginRead = "BEGINREAD"
+	ActionEndRead   = "ENDREAD"
+
+	// HISTORY returns the values Key has had, newest first, in Journal:
+	// the one it has now, then those the server keeps of a key it keeps
+	// the history of, at most Limit entries if it is above zero. Each is
+	// the write that gave the value, as JOURNAL returns it without the
+	// Identity; a key deleted, expired or evicted before it was set again
+	// has a DELETE entry at the revision that removed it. Found reports
+	// whether there are any. DIFF compares, line by line, the value Key
+	// had right after the write at Revision with the one it had after the
+	// revision in Value, or has now if Value is empty, and returns in
+	// Values the lines only the first has prefixed "-", those only the
+	// second has "+" and those both share " ", in order; a missing key is
+	// empty. A GET or MGET with a Revision reads at it the same way. A
+	// revision older than the values kept, or one on a key whose history
+	// is not kept, fails with VERSION_GONE.
+	ActionHistory = "HISTORY"
+	ActionDiff    = "DIFF"
+
+	// UNDELETE brings back Key, deleted on a server that soft deletes,
+	// with the value, type and remaining lifetime it had, and returns its
+	// new Revision. It fails with VALUE_NOT_EXIST if the key was not soft
+	// deleted, or has been purged or would have expired since, and with
+	// VALUE_EXISTS if it has been set again; Found is false for the first.
+	ActionUndelete = "UNDELETE"
+
+	// TOUCH restarts the lifetime of Key from now, for TTL if it is above
+	// zero or else for the TTL the key has, without resending its value, so
+	// a session kept alive costs a few bytes a request. The key keeps its
+	// value, type and Revision. It fails with VALUE_NOT_EXIST, Found false,
+	// if the key does not exist or has expired.
+	ActionTouch = "TOUCH"
+
+	// TYPE returns the type of the value at Key in Value: string, hash,
+	// list, set, zset, stream, json or sequence, or none with Found false
+	// if it does not exist. OBJECT returns what the server knows of Key besides its
+	// value, one "name: value" line each in Values: type, revision, bytes
+	// (of key and value), written and expires (RFC 3339 times), ttl_ms
+	// left and, on a server that tracks it for its maxmemory policy,
+	// last_access; Found is false if Key does not exist. Neither reads the
+	// value out or counts as an access of the key.
+	ActionType   = "TYPE"
+	ActionObject = "OBJECT"
+
+	// MEMORYUSAGE estimates the memory Key takes, as maxmemory and ADMIN
+	// MEMORY count it, returning the total bytes in Value and one "name:
+	// value" line each in Values: key_bytes, value_bytes, overhead_bytes
+	// (the storage engine's per entry), total_bytes and, if Key is in one,
+	// namespace; Found is false if Key does not exist. ADMIN MEMORY
+	// estimates the whole store.
+	ActionMemoryUsage = "MEMORYUSAGE"
+
+	// MULTI starts a transaction on the connection: the requests after it
+	// are queued, each answered QUEUED, until EXEC runs them one after
+	// another with no other client's request in between and returns their
+	// responses in Replies, in order. DISCARD drops the queue instead.
+	// Only requests on keys, such as GET, SET, INCR or MSET, can be
+	// queued; any other is refused with NOT_QUEUEABLE, as is one past
+	// MaxMultiRequests, and then EXEC runs nothing and fails with
+	// EXEC_ABORTED. A request that fails when EXEC runs it does not stop
+	// the rest, and writes are not rolled back.
+	ActionMulti   = "MULTI"
+	ActionExec    = "EXEC"
+	ActionDiscard = "DISCARD"
+
+	// EVAL runs the script in Value, written in a small subset of Lua, on
+	// the server with no other client's request on keys in between, as EXEC
+	// does, so a read, a decision and a write take one round trip. The
+	// script sees Keys as KEYS[1], KEYS[2]... and Values as ARGV[1]..., and
+	// runs requests with call(action, key, value, more...), which takes the
+	// actions MULTI can queue and returns the Value, nil for a key not
+	// found. What the script returns is in Value, or in Values if it
+	// returns several, and Found is false if it returned nil or false. A
+	// script that does not parse, fails, calls error, runs over
+	// MaxScriptSteps or builds over MaxScriptBytes of text fails with
+	// SCRIPT_ERROR and the reason in Value;
+	// writes it made before stay.
+	ActionEval = "EVAL"
+
+	// WATCH, before MULTI, watches Key and the keys in Keys: if any of them
+	// is written before the EXEC that follows, or deleted, EXEC runs
+	// nothing and fails with CONFLICT, so a client can read keys, decide
+	// and write without another client's write slipping in between. EXEC
+	// and DISCARD end the watch, as does UNWATCH. Like MULTI it belongs to
+	// the connection.
+	ActionWatch   = "WATCH"
+	ActionUnwatch = "UNWATCH"
+
+	// INCR, DECR and INCRBY add 1, -1 or the base-10 integer in Value to
+	// the integer stored in Key, 0 if Key doesn't exist, and return the
+	// result in Value. The key keeps its remaining lifetime; a new one
+	// gets TTL, or the server's default if zero. A value that is not a
+	// 64-bit integer, or a result that would overflow one, fails with
+	// NOT_INTEGER and leaves the key alone.
+	ActionIncr   = "INCR"
+	ActionDecr   = "DECR"
+	ActionIncrBy = "INCRBY"
+
+	// NEXTID hands out the next Limit IDs, or one if Limit is zero, of the
+	// sequence at Key and returns the first in Value: the IDs from it to
+	// it plus Limit minus one are the caller's alone, and greater than any
+	// the sequence handed out before. The key, of type sequence, holds
+	// the last ID handed out and is persisted and replicated like any
+	// other; a missing one starts at 1. A sequence never expires and is
+	// never evicted, and SET, DELETE, RENAME, COPY and BATCH refuse it with
+	// WRONGTYPE, so it is never restarted; NEXTID on a key of another type
+	// fails with WRONGTYPE too. A Limit over 1048576 fails with
+	// INVALID_ARGUMENT, and a sequence that would overflow a 64-bit integer
+	// with NOT_INTEGER.
+	ActionNextID = "NEXTID"
+
+	// APPEND adds Value to the end of the value of Key, which is created
+	// empty with TTL, or the server's default if zero, if it doesn't exist;
+	// an existing key keeps its remaining lifetime. STRLEN returns the
+	// length in bytes of the value of Key, 0 if it doesn't exist. Both
+	// return the length in Value.
+	ActionAppend = "APPEND"
+	ActionStrlen = "STRLEN"
+
+	// SETBIT, GETBIT and BITCOUNT treat the value of Key as a bitmap, the
+	// most significant bit of its first byte being offset 0. SETBIT sets
+	// the bit at offset Start to Value, "0" or "1", padding the value with
+	// zero bytes as needed, and returns what it was in Value; a missing key
+	// is created empty as for APPEND. GETBIT returns the bit at offset
+	// Start in Value, 0 past the end or for a missing key, and BITCOUNT how
+	// many bits are set. Offsets run from 0 to MaxBitOffset.
+	ActionSetBit   = "SETBIT"
+	ActionGetBit   = "GETBIT"
+	ActionBitCount = "BITCOUNT"
+
+	// HSET sets fields of the hash at Key, each field in Keys to the value
+	// at the same index in Values, and returns how many are new in Value.
+	// A missing key is created with TTL, or the server's default if zero;
+	// an existing hash keeps its remaining lifetime. HGET returns the field
+	// named in Value, with Found reporting whether the hash has it, and
+	// HGETALL every field and its value, alternating in Values in field
+	// order. HDEL removes the fields in Keys and returns how many there
+	// were in Value; removing the last deletes the key. A hash action on a
+	// key that holds a plain value, and a plain action such as GET, APPEND
+	// or INCR on a hash, fails with WRONGTYPE; SET, DELETE, RENAME and
+	// COPY work on either.
+	ActionHSet    = "HSET"
+	ActionHGet    = "HGET"
+	ActionHGetAll = "HGETALL"
+	ActionHDel    = "HDEL"
+
+	// LPUSH adds the items in Values to the head of the list at Key, one
+	// after another so the last ends up first, and RPUSH to its tail, in
+	// order; both return the list's length in Value. A missing key is
+	// created with TTL, or the server's default if zero; an existing list
+	// keeps its remaining lifetime, as the TTL is the whole list's. LPOP
+	// and RPOP remove an item from the head or tail and return it in Value,
+	// with Found reporting whether there was one, or with a Limit above
+	// zero up to that many in Values; removing the last item deletes the
+	// key. BLPOP is LPOP that, if the list is empty, waits up to Timeout,
+	// or the server's default if zero, for an item. LRANGE returns the
+	// items from index Start to Stop, both included, in Values; negative
+	// indexes count from the end, -1 being the last. As with hashes, list
+	// and plain actions on a key of the other type fail with WRONGTYPE.
+	ActionLPush  = "LPUSH"
+	ActionRPush  = "RPUSH"
+	ActionLPop   = "LPOP"
+	ActionRPop   = "RPOP"
+	ActionBLPop  = "BLPOP"
+	ActionLRange = "LRANGE"
+
+	// SADD adds the members in Values to the set at Key and returns how
+	// many are new in Value. A missing key is created with TTL, or the
+	// server's default if zero; an existing set keeps its remaining
+	// lifetime. SREM removes the members in Values and returns how many
+	// there were in Value; removing the last deletes the key. SMEMBERS
+	// returns the members of the set at Key in Values, in order, with Found
+	// reporting whether it exists, and SISMEMBER reports in Found whether
+	// it has the member in Value. SUNION, SINTER and SDIFF combine the sets
+	// at Keys, a missing key being an empty set, and return the members in
+	// Values, in order: those in any of them, those in all of them, and
+	// those of the first that none of the others has. As with hashes, set
+	// and plain actions on a key of the other type fail with WRONGTYPE.
+	ActionSAdd      = "SADD"
+	ActionSRem      = "SREM"
+	ActionSMembers  = "SMEMBERS"
+	ActionSIsMember = "SISMEMBER"
+	ActionSUnion    = "SUNION"
+	ActionSInter    = "SINTER"
+	ActionSDiff     = "SDIFF"
+
+	// ZADD sets the score of each member of the sorted set at Key in Keys
+	// to the score at the same index in Values, as FormatScore writes it,
+	// and returns how many members are new in Value. A missing key is
+	// created with TTL, or the server's default if zero; an existing set
+	// keeps its remaining lifetime. ZREM removes the members in Keys and
+	// returns how many there were in Value; removing the last deletes the
+	// key. Members are ordered by score, then by member. ZRANGE returns
+	// the members from index Start to Stop, both included, and
+	// ZRANGEBYSCORE those scored from Values[0] to Values[1], both
+	// included, -inf and +inf meaning no bound, at most Limit of them if it
+	// is above zero; both with Found reporting whether the set exists, and
+	// each member followed by its score in Values, in order. Negative
+	// indexes count from the end, -1 being the last. ZRANK returns the
+	// index of the member named in Value, from 0 for the lowest score, in
+	// Value, with Found reporting whether the set has it. As with hashes,
+	// sorted set and plain actions on a key of the other type fail with
+	// WRONGTYPE.
+	ActionZAdd          = "ZADD"
+	ActionZRem          = "ZREM"
+	ActionZRange        = "ZRANGE"
+	ActionZRangeByScore = "ZRANGEBYSCORE"
+	ActionZRank         = "ZRANK"
+
+	// GEOADD sets the location of each member in Keys in the geo set at
+	// Key, a sorted set scored with the geohash of each location, and
+	// returns how many members are new in Value. Values holds a longitude
+	// and a latitude for each member, in degrees, in turn; see
+	// kvstore.ValidGeo for their bounds. A missing key is created as for
+	// ZADD. GEOSEARCH returns the members within an area around the
+	// longitude and latitude in Values[0] and Values[1]: a circle with the
+	// radius in Values[2], or a box as wide as Values[2] and as high as
+	// Values[3], all in metres. They come nearest first, at most Limit of
+	// them if it is above zero, each followed by its distance from the
+	// centre in metres, its longitude and its latitude in Values, with
+	// Found reporting whether the set exists. Numbers are written as by
+	// FormatScore. Sorted set actions work on geo sets too.
+	ActionGeoAdd    = "GEOADD"
+	ActionGeoSearch = "GEOSEARCH"
+
+	// XADD appends an entry to the stream at Key, its fields in Keys and
+	// their values in Values, and returns the ID the server gave it in
+	// Value. IDs are "ms-seq", the time in milliseconds and a sequence
+	// within it, and grow with every entry. A missing key is created with
+	// TTL, or the server's default if zero. A Limit above zero caps the
+	// stream at that many entries, from then on, dropping the oldest.
+	// XREAD returns up to Limit entries, all if zero, after the ID in
+	// Value, from the start if empty, in Stream, with Found reporting
+	// whether the stream exists.
+	//
+	// XGROUP creates the consumer group Group on the stream at Key,
+	// creating the stream if it is missing; it delivers the entries after
+	// the ID in Value, only those added from now on if empty. XREADGROUP
+	// delivers up to Limit entries to the consumer Owner: with Value empty
+	// the next ones the group has not delivered to anyone, else those
+	// after the ID in Value that Owner was given and has not acknowledged,
+	// to pick up after a crash. Entries stay pending until XACK
+	// acknowledges the IDs in Keys for Group, returning how many were
+	// pending in Value. As with hashes, stream and plain actions on a key
+	// of the other type fail with WRONGTYPE.
+	ActionXAdd       = "XADD"
+	ActionXRead      = "XREAD"
+	ActionXGroup     = "XGROUP"
+	ActionXReadGroup = "XREADGROUP"
+	ActionXAck       = "XACK"
+
+	// JSON.SET sets the part of the JSON document at Key that Path selects
+	// to the JSON in Value. The root path, "$" or empty, replaces the whole
+	// document, creating the key with TTL, or the server's default if zero,
+	// if it is missing; an existing document keeps its remaining lifetime.
+	// Any other path must have a parent that exists: its last step adds or
+	// replaces a member of an object, or replaces an element of an array,
+	// and fails with NO_PATH otherwise. Value that is not JSON fails with
+	// INVALID_JSON. JSON.GET returns the part of the document Path selects
+	// as JSON in Value, with Found reporting whether there is one. A path
+	// is $ followed by any of .name, ["name"] and [index], an index below
+	// zero counting from the end of an array, as in $.users[0].name. As
+	// with hashes, JSON and plain actions on a key of the other type fail
+	// with WRONGTYPE.
+	ActionJSONSet = "JSON.SET"
+	ActionJSONGet = "JSON.GET"
+
+	// ADMIN carries an operational subcommand in Value and its argument, if
+	// any, in Key; see the Admin constants.
+	ActionAdmin = "ADMIN"
+)
+
+// MaxBatchKeys is the most keys an MGET, MSET or BATCH may carry.
+const MaxBatchKeys = 10000
+
+// MaxBitOffset is the highest offset SETBIT and GETBIT take, which keeps a
+// bitmap within 16 MiB.
+const MaxBitOffset = 1<<27 - 1
+
+// MaxMultiRequests is the most requests a MULTI transaction may queue.
+const MaxMultiRequests = 10000
+
+// MaxScriptSteps is the most statements an EVAL script may run.
+const MaxScriptSteps = 100000
+
+// MaxScriptBytes is the most bytes of text an EVAL script may build with
+// .., in all; a string of it is as long as the largest request.
+const MaxScriptBytes = 64 << 20
+
+// ADMIN subcommands.
+const (
+	// SNAPSHOT writes the backup file now.
+	AdminSnapshot = "SNAPSHOT"
+	// BGSAVE starts writing the backup file in the background and returns
+	// at once, with SNAPSHOT_STARTED, or SNAPSHOT_RUNNING if one it started
+	// is still running; with Key "status" it starts none. Either way it
+	// returns the backup file in Value and "name: value" lines in Values:
+	// the state of the last snapshot it started, "none", "running", "ok"
+	// or "failed", when it started and finished, and its error.
+	AdminBGSave = "BGSAVE"
+	// REWRITEWAL starts compacting the write-ahead log in the background,
+	// like BGREWRITEAOF, with WAL_REWRITE_STARTED, or WAL_REWRITE_RUNNING
+	// if one is still running, or NO_WAL if the server keeps none; with
+	// Key "status" it starts none. It returns the WAL file in Value and
+	// "name: value" lines in Values: the state of the last rewrite,
+	// "none", "running", "ok" or "failed", when it finished, how long it
+	// took, and its error.
+	AdminRewriteWAL = "REWRITEWAL"
+	// RESTORE replaces the data with the backup file named in Key, which
+	// must be in the backup file's directory, or with the backup file
+	// itself, and reports what was loaded in Values. It is not journaled.
+	// Values[0], if given, is the mode: "replace", the default, "merge" to
+	// write the backup's keys over the data and keep the rest, or
+	// "missing" to add only the keys the data lacks; a merge may be
+	// limited to the keys matching a pattern in Keys.
+	AdminRestore = "RESTORE"
+	// VERIFYBACKUP checks the backup file named in Key, as RESTORE takes
+	// it, without loading it: that it decrypts and parses, and matches the
+	// checksum and count it was written with. It returns the file in Value
+	// and "name: value" lines in Values: its format and version, its
+	// compression, when it was created, how many keys it holds, checksum, "ok" or "none" for a
+	// file written without one, and how many entries a restore would
+	// load, drop as expired and drop as damaged. A file that fails is
+	// reported in Message.
+	AdminVerifyBackup = "VERIFYBACKUP"
+	// BACKUPS lists the snapshots kept next to the backup file, newest
+	// first, one per line in Values: its name, which RESTORE takes, size
+	// in bytes and time taken.
+	AdminBackups = "BACKUPS"
+	// STATS returns "name: value" lines in Values.
+	AdminStats = "STATS"
+	// FLUSHCACHE empties the read cache and returns how many keys it held.
+	AdminFlushCache = "FLUSHCACHE"
+	// CLIENTS lists the open connections in Values.
+	AdminClients = "CLIENTS"
+	// SLOWLOG lists the latest requests that took at least the server's
+	// slow log threshold, newest first, one per line in Values: an id
+	// counting from the server's start, when it started, how long it
+	// took, its action, key and client. With Key "reset" it empties the
+	// log instead and returns how many it held in Value.
+	AdminSlowLog = "SLOWLOG"
+	// LOGLEVEL returns the log level, after setting it to Key if given.
+	AdminLogLevel = "LOGLEVEL"
+	// READONLY returns "on" or "off" for read-only mode, after setting it
+	// to Key if given.
+	AdminReadOnly = "READONLY"
+	// NAMESPACES describes each namespace, its limits and usage, one per
+	// line in Values.
+	AdminNamespaces = "NAMESPACES"
+	// MEMORY estimates the memory taken by the keys of each namespace, one
+	// per line in Values, sampling up to Key keys outside namespaces if
+	// it is a number, and last the whole store's, named (total).
+	AdminMemory = "MEMORY"
+	// KEYSPACE samples up to Key keys, if it is a number, and describes
+	// their distribution one line each in Values: the keys in all and in
+	// the sample, histograms of value size and TTL left, the keys under
+	// each of the commonest prefixes and the keys requested most. Each
+	// count is given for the sample and scaled up to all the keys.
+	AdminKeyspace = "KEYSPACE"
+	// DUMP returns the server's keys as a JSON snapshot, in the format of
+	// its backup file, in Value, with "revision: N" and "keys: N" lines
+	// in Values; revision is the journal revision the snapshot matches.
+	AdminDump = "DUMP"
+	// LOAD replaces the server's keys with the JSON snapshot in Key, like
+	// RESTORE, and reports what was loaded in Values. It is not journaled.
+	AdminLoad = "LOAD"
+	// FREEZE refuses writes with READONLY for the duration in Key, e.g.
+	// "30s", or lifts the freeze early with "off"; with no Key it only
+	// reports it. Value is how long the freeze has left, "off" if none.
+	// Coordinators freeze every server of a cluster to back it up at one
+	// point, and the lease thaws it should they die.
+	AdminFreeze = "FREEZE"
+	// REPLICAOF makes the server a read-only replica of the primary at the
+	// address in Key, or a primary again with "no one"; with no Key it
+	// only reports. Values describes the replication, one "name: value"
+	// line each.
+	AdminReplicaOf = "REPLICAOF"
+	// SETSLOT changes the slot map for the slot range "first-last" in Key:
+	// Values[0] is "MIGRATING" with the address its keys move to in
+	// Values[1], "IMPORTING" with the address they come from, "NODE" with
+	// the address that owns them from now, ending their move, or "STABLE"
+	// to call the move off.
+	AdminSetSlot = "SETSLOT"
+	// MIGRATE moves up to Limit of the keys the server holds in the
+	// migrating slot range in Key to the server they move to, IMPORTing
+	// them there and deleting them here, and returns how many it moved in
+	// Value, with More set while it holds more.
+	AdminMigrate = "MIGRATE"
+	// IMPORT writes the entries of the JSON snapshot in Key over the
+	// server's keys, keeping the rest, and reports what was loaded in
+	// Values. Unlike LOAD it is journaled. With "json" or "csv" in
+	// Values[0], Key is an EXPORT in that format instead.
+	AdminImport = "IMPORT"
+	// EXPORT returns every live key with its value, type, TTL and
+	// checksum in Value, as a portable file in the format named in Key,
+	// "json", the default, or "csv", that IMPORT reads back; see
+	// kvstore.WriteExport. Values has "keys: N" and "damaged: N" lines,
+	// the keys left out failing their checksum.
+	AdminExport = "EXPORT"
+	// TRASH lists the keys soft deleted that UNDELETE can still bring
+	// back, in key order, one per line in Values: the key, its type and
+	// size, when it was deleted and when it is purged.
+	AdminTrash = "TRASH"
+	// PURGE drops the soft-deleted key in Key for good, or every one if
+	// Key is empty, and returns how many in Value.
+	AdminPurge = "PURGE"
+)
+
+// Messages returned in Response.Message.
+const (
+	MsgNotFound      = "NOT_FOUND"
+	MsgValueNotExist = "VALUE_NOT_EXIST"
+	MsgValueSet      = "VALUE_SET"
+	MsgValueUpdated  = "VALUE_UPDATED"
+	MsgValueDeleted  = "VALUE_DELETED"
+	MsgValueRestored = "VALUE_RESTORED"
+	MsgValueTouched  = "VALUE_TOUCHED"
+	MsgIDsAllocated  = "IDS_ALLOCATED"
+	MsgInvalidAction = "INVALID_ACTION"
+	MsgLockAcquired  = "LOCK_ACQUIRED"
+	MsgLockTimeout   = "LOCK_TIMEOUT"
+	MsgLockReleased  = "LOCK_RELEASED"
+	MsgLockNotHeld   = "LOCK_NOT_HELD"
+	MsgLockRenewed   = "LOCK_RENEWED"
+	MsgLocked        = "LOCKED"
+	MsgOwnerRequired = "OWNER_REQUIRED"
+	MsgInvalidWindow = "INVALID_WINDOW"
+	MsgSubscribed    = "SUBSCRIBED"
+	MsgUnsubscribed  = "UNSUBSCRIBED"
+	MsgNotSubscribed = "NOT_SUBSCRIBED"
+	MsgAcked         = "ACKED"
+	MsgInvalidated   = "INVALIDATED"
+	MsgInvalidateAll = "INVALIDATE_ALL"
+	MsgInvalidID     = "INVALID_ID"
+	MsgServerError   = "SERVER_ERROR"
+	MsgPinned        = "KEY_PINNED"
+	MsgUnpinned      = "KEY_UNPINNED"
+	MsgNotPinned     = "KEY_NOT_PINNED"
+	MsgMoved         = "MOVED"
+	MsgAsk           = "ASK"
+	MsgTryAgain      = "TRYAGAIN"
+	MsgBadPattern    = "INVALID_PATTERN"
+	MsgIntegrity     = "INTEGRITY"
+	MsgInvalidTTL    = "INVALID_TTL_MODE"
+	MsgNoHierarchy   = "NO_HIERARCHY"
+	MsgReadOnly      = "READONLY"
+	MsgDiskFull      = "DISK_FULL"
+	MsgQuotaExceeded = "QUOTA_EXCEEDED"
+	MsgOutOfMemory   = "OUT_OF_MEMORY"
+	MsgAdminOnly     = "ADMIN_ONLY"
+	MsgUnauthorized  = "UNAUTHORIZED"
+	MsgValueRenamed  = "VALUE_RENAMED"
+	MsgValueCopied   = "VALUE_COPIED"
+	MsgCrossSlot     = "CROSSSLOT"
+	MsgDegraded      = "DEGRADED"
+	MsgRateLimited   = "RATE_LIMITED"
+	MsgUnordered     = "UNORDERED"
+	MsgNoIndex       = "NO_INDEX"
+	MsgIncremented   = "VALUE_INCREMENTED"
+	MsgNotInteger    = "NOT_INTEGER"
+	MsgAppended      = "VALUE_APPENDED"
+	MsgValueExists   = "VALUE_EXISTS"
+	MsgConflict      = "CONFLICT"
+	MsgNoReadTx      = "NO_READ_TX"
+	MsgVersionGone   = "VERSION_GONE"
+	MsgReadEnded     = "READ_ENDED"
+	MsgQueued        = "QUEUED"
+	MsgNotQueueable  = "NOT_QUEUEABLE"
+	MsgInMulti       = "IN_MULTI"
+	MsgNoMulti       = "NO_MULTI"
+	MsgExecAborted   = "EXEC_ABORTED"
+	MsgScriptError   = "SCRIPT_ERROR"
+	MsgCanceled      = "CANCELED"
+	MsgWrongType     = "WRONGTYPE"
+	MsgNoGroup       = "NO_GROUP"
+	MsgGroupExists   = "GROUP_EXISTS"
+	MsgNoPath        = "NO_PATH"
+	MsgInvalidJSON   = "INVALID_JSON"
+
+	MsgSnapshotWritten = "SNAPSHOT_WRITTEN"
+	MsgSnapshotStarted = "SNAPSHOT_STARTED"
+	MsgSnapshotRunning = "SNAPSHOT_RUNNING"
+	MsgRewriteStarted  = "WAL_REWRITE_STARTED"
+	MsgRewriteRunning  = "WAL_REWRITE_RUNNING"
+	MsgNoWAL           = "NO_WAL"
+	MsgRestored        = "RESTORED"
+	MsgBackupVerified  = "BACKUP_VERIFIED"
+	MsgCacheFlushed    = "CACHE_FLUSHED"
+	MsgInvalidPath     = "INVALID_PATH"
+	MsgInvalidLogLevel = "INVALID_LOG_LEVEL"
+	MsgInvalidArgument = "INVALID_ARGUMENT"
+	// MsgBadRequest answers a request the server could not decode, with
+	// the reason in Value, and MsgTooLarge one over the server's size
+	// limit; the server closes the connection after either, unless the
+	// rest of the stream is still intact. MsgBadRequest also answers,
+	// leaving the connection open, a request naming an empty key, one over
+	// the server's key length limit or one with an ASCII control character.
+	MsgBadRequest = "BAD_REQUEST"
+	MsgTooLarge   = "REQUEST_TOO_LARGE"
+	MsgResync     = "RESYNC"
+	// MsgStale answers a read with MaxStaleness on a replica further
+	// behind its primary than that, with the primary in Value
+	MsgStale = "STALE"
+	// MsgNoQuorum answers a write with Consistency QUORUM or ALL that too
+	// few replicas had in time; the primary keeps it, and replicas still
+	// get it as they catch up
+	MsgNoQuorum = "NO_QUORUM"
+	// MsgNotEnoughReplicas answers a write with Consistency QUORUM or ALL
+	// when fewer replicas are up than it needs, or none are known; the
+	// primary keeps it
+	MsgNotEnoughReplicas = "NOT_ENOUGH_REPLICAS"
+)
+
+// Consistency levels of a Request, see Request.Consistency.
+const (
+	ConsistencyOne    = "ONE"
+	ConsistencyQuorum = "QUORUM"
+	ConsistencyAll    = "ALL"
+)
+
+// TTL modes for an UPDATE, see Request.
+const (
+	TTLKeep  = "KEEPTTL"
+	TTLReset = "RESET"
+)
+
+// Request is what the client sends for every action.
+//
+// Owner identifies the caller for lock actions, Timeout bounds how long the
+// server may wait to acquire a lock and TTL is how long a granted lock, or
+// the key written by SET, lives. Zero durations mean the server defaults.
+//
+// For UPDATE a TTL above zero replaces the key's TTL from now; otherwise
+// TTLMode says whether the key keeps its remaining lifetime (KEEPTTL) or
+// restarts its TTL (RESET), and empty means the server's default.
+//
+// Checksum, if not zero, is Checksum(Value) for SET and UPDATE. The server
+// refuses a value that does not match it with INTEGRITY, keeps it with the
+// value and checks it again on every GET and snapshot.
+//
+// Token authenticates admin actions, such as ADMIN and DIAGNOSE, to a
+// server started with an admin token; other actions ignore it.
+//
+// LowPriority marks work that can wait, such as batch jobs and cache
+// warmers, which a server missing its latency objectives may refuse with
+// DEGRADED.
+//
+// Budget, if above zero, is how long the client will wait for the
+// response. Once it runs out the server stops waiting: a lock or a cache
+// miss held back by the warm-up is answered CANCELED, and a wait for
+// journal entries, messages or list items with what has arrived.
+// TraceID identifies the caller's trace, e.g. a W3C traceparent; custom
+// commands find it in their context, and it is logged with their panics.
+//
+// Expect is the value CAS requires Key to have, and Revision, if above
+// zero, the revision it requires instead; see Response.Revision. For
+// UNLOCK and RENEW, Revision is the fencing token LOCK returned.
+//
+// ReadTx makes a GET or MGET read in the read transaction BEGINREAD
+// returned, and names the one ENDREAD closes. Without one, a Revision
+// above zero makes them read the keys as they were right after the write
+// at that revision, see HISTORY.
+//
+// Keys and Values are the keys of MGET, MSET, BATCH, SUNION, SINTER and
+// SDIFF and the values of MSET and BATCH, and Checksums, if set, the
+// Checksum of each of those values. Deletes marks the keys BATCH deletes,
+// whose Values are ignored.
+//
+// Start and Stop are the first and last index LRANGE and ZRANGE return,
+// Start also the bit offset of SETBIT and GETBIT and the matches SEARCH
+// skips,
+// and Group is the stream consumer group XGROUP, XREADGROUP and XACK name.
+// Path selects part of the document JSON.SET and JSON.GET work on.
+//
+// Continue asks SCAN and RANGE for the page after the one whose
+// Response.Continue it is, and is empty for the first page. Tokens are
+// opaque, and stay valid however the store changes in between.
+//
+// Epoch is the failover epoch a server of a failover group is in, sent
+// with SYNC, PSYNC and VOTE; a primary that sees a later one steps down.
+//
+// MaxStaleness, if above zero, is how far behind its primary a replica may
+// be to serve a read; one further behind answers STALE. A primary serves
+// every read.
+//
+// Consistency is how many servers of a primary and its replicas a request
+// needs: ONE, the default, QUORUM, a majority of them, or ALL. A primary
+// answers a write with QUORUM or ALL once that many have it, itself
+// included, waiting up to Timeout, or NO_QUORUM. A client reads with
+// QUORUM or ALL from that many servers, keeping the answer of the one
+// whose Response.Position is furthest along.
+//
+// Asking marks a request sent on after an ASK, which the server a slot is
+// moving to serves before it owns the slot.
+//
+// RequestID, if set, makes a request safe to send again, such as after a
+// timeout: the server remembers the response for a while, two minutes by
+// default, and answers a request with the same RequestID with it instead
+// of running it again, waiting for the first if it is still running. A
+// failure that invites a resend, such as READONLY or TRY_AGAIN, is not
+// remembered. IDs should be random, e.g. 16 random bytes in hex; one sent
+// again with another Action or Key is refused with BAD_REQUEST. Requests
+// inside MULTI ignore it.
+type Request struct {
+	Action      string
+	Key         string
+	Value       string
+	Owner       string
+	Timeout     time.Duration
+	TTL         time.Duration
+	Limit       int
+	Checksum    uint32
+	TTLMode     string
+	Token       string
+	LowPriority bool
+	Budget      time.Duration
+	TraceID     string
+	Continue    string
+	Keys        []string
+	Values      []string
+	Checksums   []uint32
+	Deletes     []bool
+	Expect      string
+	Revision    uint64
+	ReadTx      string
+	Start       int
+	Stop        int
+	Group       string
+	Path        string
+
+	Epoch uint64
+
+	MaxStaleness time.Duration
+	Consistency  string
+
+	Asking bool
+
+	RequestID string
+}
+
+// Response is what the server sends back for every request.
+//
+// Found reports whether the key existed when the action ran, and Success
+// reports whether the action was carried out. A GET of a missing key is
+// therefore Found=false, Success=true, while an UPDATE of a missing key is
+// Found=false, Success=false.
+//
+// Values carries multi-line results such as LOCKS LIST, Messages the
+// pub/sub messages returned by FETCH, Entries the children LIST finds and
+// Stream the stream entries XREAD and XREADGROUP return.
+// Checksum is the checksum stored with the value a GET returns, zero if
+// its writer sent none, or that of the snapshot chunk a SYNC returns.
+//
+// Error details a request that was not carried out, for programs that
+// handle failures without parsing Message; older servers leave it nil.
+//
+// More reports for SCAN and RANGE that the page is not the last, and
+// Continue is then the token that asks for the next one. Results has the
+// outcome for each key of an MGET, MSET or BATCH.
+//
+// Replies has the response of each request an EXEC ran, in order.
+//
+// Revision is the revision of the key a GET read or a SET, SETNX, CAS or
+// UPDATE wrote; a SETNX or CAS that did not write returns the existing
+// key's. The server gives every write of a key a revision above any it
+// has given before, so a key's revision changes exactly when it is
+// written, and revisions are kept in backups.
+//
+// Replication has the writes PSYNC returns, and Epoch the failover epoch
+// of a server of a failover group.
+//
+// Position is, for a read sent with a Consistency, the revision of the
+// last write the server's store has, so the one furthest along is known.
+type Response struct {
+	Value    string
+	Values   []string
+	Messages []Message
+	Journal  []JournalEntry
+	Entries  []DirEntry
+	Commands []CommandSpec
+	Message  string
+	Found    bool
+	Success  bool
+	Checksum uint32
+	Error    *ErrorInfo
+	More     bool
+	Continue string
+	Results  []KeyResult
+	Revision uint64
+	Replies  []Response
+	Stream   []StreamEntry
+
+	Replication []ReplicationOp
+	Epoch       uint64
+	Position    uint64
+}
+
+// KeyResult is the outcome for one key of an MGET, MSET or BATCH, with the
+// fields a GET or SET of it alone would have set in a Response.
+type KeyResult struct {
+	Key      string
+	Value    string
+	Message  string
+	Found    bool
+	Success  bool
+	Checksum uint32
+	Revision uint64
+}
+
+// ErrorInfo describes a failed request. Code is the Response.Message.
+// Retryable says whether sending the same request again may succeed, after
+// waiting Backoff if it is set; Leader, for MOVED, is the server to send it
+// to instead, and for READONLY and STALE from a replica, its primary.
+type ErrorInfo struct {
+	Code      string
+	Retryable bool
+	Backoff   time.Duration
+	Leader    string
+}
+
+// JournalEntry is one committed write from the server's journal. Revision
+// increases by one per write and never repeats, and Identity is the Owner
+// the writer sent or else its network address. Op is SET or DELETE, or
+// HSET, RPUSH, SADD, ZADD or XADD, which replace the key with the whole
+// hash, list, set, sorted set or stream in Value, as EncodeHash,
+// EncodeList, EncodeSet, EncodeZSet or EncodeStream make it, or JSON.SET,
+// which replaces it with the whole JSON document in Value. This is a
+// stable format for consumers such as compliance archives and replicas.
+type JournalEntry struct {
+	Revision uint64
+	Time     time.Time
+	Op       string
+	Key      string
+	Value    string
+	Identity string
+}
+
+// ReplicationOp is one write PSYNC returns: the entry Key was left with,
+// whole, as the primary stores it, or its deletion if Deleted, at the
+// primary's Revision. Seq is its position in the replication history.
+// Stamp and Origin order the writes of multi-primary servers, see
+// kvstore.Stamp.
+type ReplicationOp struct {
+	Seq       uint64
+	Key       string
+	Deleted   bool
+	Value     string
+	Timestamp time.Time
+	TTL       time.Duration
+	Checksum  uint32
+	Revision  uint64
+	Type      uint8
+	Stamp     uint64
+	Origin    string
+}
+
+// DirEntry is a child of the directory listed by LIST: a key, or a
+// sub-directory with the number of keys anywhere beneath it. Name is the
+// full path, ending in the separator for directories.
+type DirEntry struct {
+	Name string
+	Dir  bool
+	Keys int
+}
+
+// StreamEntry is an entry of a stream: its ID, and each field followed by
+// its value in Fields, in the order XADD gave them.
+type StreamEntry struct {
+	ID     string
+	Fields []string
+}
+
+// CommandSpec describes an action for COMMANDS, so clients and tools can
+// find out at run time what a server accepts and what it may answer.
+type CommandSpec struct {
+	Action   string
+	Summary  string
+	Args     []ArgSpec
+	Messages []string // Response.Message values particular to the action
+	Keyed    bool     // routed by Key in a cluster, so it may be MOVED
+	Write    bool     // changes keys, so it is refused with READONLY in read-only mode
+	Admin    bool     // operational, so only served on the admin listener if there is one
+	Custom   bool     // added with server.RegisterCommand
+}
+
+// ArgSpec is a Request field an action reads.
+type ArgSpec struct {
+	Field    string // e.g. "Key" or "TTL"
+	Summary  string
+	Required bool
+}
+
+// Message is a published message delivered to a durable subscriber.
+type Message struct {
+	ID      uint64
+	Channel string
+	Payload string
+}
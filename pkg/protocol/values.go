@@ -0,0 +1,222 @@
+package protocol
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// EncodeList is the form a list takes in backups and in the journal: each
+// item in order, preceded by its length in bytes as a uvarint. Items may
+// hold any bytes.
+func EncodeList(items []string) string {
+	var b strings.Builder
+	var n [binary.MaxVarintLen64]byte
+	for _, item := range items {
+		b.Write(n[:binary.PutUvarint(n[:], uint64(len(item)))])
+		b.WriteString(item)
+	}
+	return b.String()
+}
+
+// DecodeList reads a list written by EncodeList.
+func DecodeList(s string) ([]string, error) {
+	var items []string
+	for len(s) > 0 {
+		n, size := binary.Uvarint([]byte(s[:min(len(s), binary.MaxVarintLen64)]))
+		if size <= 0 || n > uint64(len(s)-size) {
+			return nil, errors.New("protocol: malformed list")
+		}
+		items = append(items, s[size:size+int(n)])
+		s = s[size+int(n):]
+	}
+	return items, nil
+}
+
+// EncodeHash is the form a hash takes in backups and in the journal: the
+// EncodeList of its fields in order, each followed by its value.
+func EncodeHash(fields map[string]string) string {
+	names := make([]string, 0, len(fields))
+	for name := range fields {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	items := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		items = append(items, name, fields[name])
+	}
+	return EncodeList(items)
+}
+
+// DecodeHash reads a hash written by EncodeHash.
+func DecodeHash(s string) (map[string]string, error) {
+	items, err := DecodeList(s)
+	if err != nil || len(items)%2 != 0 {
+		return nil, errors.New("protocol: malformed hash")
+	}
+	fields := make(map[string]string, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		fields[items[i]] = items[i+1]
+	}
+	return fields, nil
+}
+
+// EncodeSet is the form a set takes in backups and in the journal: the
+// EncodeList of its members in order.
+func EncodeSet(members map[string]bool) string {
+	names := make([]string, 0, len(members))
+	for name := range members {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return EncodeList(names)
+}
+
+// DecodeSet reads a set written by EncodeSet.
+func DecodeSet(s string) (map[string]bool, error) {
+	items, err := DecodeList(s)
+	if err != nil {
+		return nil, errors.New("protocol: malformed set")
+	}
+	members := make(map[string]bool, len(items))
+	for _, item := range items {
+		members[item] = true
+	}
+	return members, nil
+}
+
+// EncodeZSet is the form a sorted set takes in backups and in the journal:
+// the EncodeList of its members by score, then by member, each followed
+// by its score in decimal.
+func EncodeZSet(scores map[string]float64) string {
+	names := make([]string, 0, len(scores))
+	for name := range scores {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := scores[names[i]], scores[names[j]]
+		return a < b || a == b && names[i] < names[j]
+	})
+	items := make([]string, 0, 2*len(names))
+	for _, name := range names {
+		items = append(items, name, FormatScore(scores[name]))
+	}
+	return EncodeList(items)
+}
+
+// DecodeZSet reads a sorted set written by EncodeZSet.
+func DecodeZSet(s string) (map[string]float64, error) {
+	items, err := DecodeList(s)
+	if err != nil || len(items)%2 != 0 {
+		return nil, errors.New("protocol: malformed sorted set")
+	}
+	scores := make(map[string]float64, len(items)/2)
+	for i := 0; i < len(items); i += 2 {
+		score, err := ParseScore(items[i+1])
+		if err != nil {
+			return nil, errors.New("protocol: malformed sorted set")
+		}
+		scores[items[i]] = score
+	}
+	return scores, nil
+}
+
+// FormatScore is the decimal form of a sorted set score, in requests and
+// responses as in EncodeZSet.
+func FormatScore(score float64) string {
+	return strconv.FormatFloat(score, 'g', -1, 64)
+}
+
+// ParseScore reads a score written by FormatScore, or an unbounded one
+// written as -inf or +inf. NaN is no score.
+func ParseScore(s string) (float64, error) {
+	score, err := strconv.ParseFloat(s, 64)
+	if err == nil && math.IsNaN(score) {
+		err = errors.New("protocol: score is not a number")
+	}
+	return score, err
+}
+
+// Stream is a stream as EncodeStream stores it. LastID is the ID of the
+// latest entry ever added, which trimming keeps, and MaxLen the most
+// entries it keeps, zero for no limit.
+type Stream struct {
+	LastID  string
+	MaxLen  int
+	Entries []StreamEntry
+	Groups  []StreamGroup
+}
+
+// StreamGroup is a consumer group of a Stream: LastID is the latest entry
+// it delivered, and Pending the entries delivered but not acknowledged,
+// in order.
+type StreamGroup struct {
+	Name    string
+	LastID  string
+	Pending []PendingEntry
+}
+
+// PendingEntry is an entry delivered to Consumer and not yet acknowledged.
+type PendingEntry struct {
+	ID       string
+	Consumer string
+}
+
+// EncodeStream is the form a stream takes in backups and in the journal:
+// the EncodeList of its LastID, its MaxLen in decimal and its number of
+// entries; each entry's ID and the EncodeList of its Fields; then each
+// group's name, LastID and the EncodeList of its pending entries, each ID
+// followed by its consumer.
+func EncodeStream(s Stream) string {
+	items := []string{s.LastID, strconv.Itoa(s.MaxLen), strconv.Itoa(len(s.Entries))}
+	for _, e := range s.Entries {
+		items = append(items, e.ID, EncodeList(e.Fields))
+	}
+	for _, g := range s.Groups {
+		pending := make([]string, 0, 2*len(g.Pending))
+		for _, p := range g.Pending {
+			pending = append(pending, p.ID, p.Consumer)
+		}
+		items = append(items, g.Name, g.LastID, EncodeList(pending))
+	}
+	return EncodeList(items)
+}
+
+// DecodeStream reads a stream written by EncodeStream.
+func DecodeStream(s string) (Stream, error) {
+	malformed := errors.New("protocol: malformed stream")
+	items, err := DecodeList(s)
+	if err != nil || len(items) < 3 {
+		return Stream{}, malformed
+	}
+	var stream Stream
+	stream.LastID = items[0]
+	stream.MaxLen, err = strconv.Atoi(items[1])
+	n, err2 := strconv.Atoi(items[2])
+	items = items[3:]
+	if err != nil || err2 != nil || n < 0 || 2*n > len(items) || (len(items)-2*n)%3 != 0 {
+		return Stream{}, malformed
+	}
+	for i := 0; i < n; i++ {
+		fields, err := DecodeList(items[2*i+1])
+		if err != nil || len(fields)%2 != 0 {
+			return Stream{}, malformed
+		}
+		stream.Entries = append(stream.Entries, StreamEntry{ID: items[2*i], Fields: fields})
+	}
+	for items = items[2*n:]; len(items) > 0; items = items[3:] {
+		pending, err := DecodeList(items[2])
+		if err != nil || len(pending)%2 != 0 {
+			return Stream{}, malformed
+		}
+		g := StreamGroup{Name: items[0], LastID: items[1]}
+		for i := 0; i < len(pending); i += 2 {
+			g.Pending = append(g.Pending, PendingEntry{ID: pending[i], Consumer: pending[i+1]})
+		}
+		stream.Groups = append(stream.Groups, g)
+	}
+	return stream, nil
+}
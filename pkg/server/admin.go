@@ -0,0 +1,539 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// clientConn is one client connection as listed by ADMIN CLIENTS
+type clientConn struct {
+	conn  net.Conn
+	since time.Time
+	admin bool // on an admin listener
+
+	mu         sync.Mutex
+	requests   uint64
+	lastAction string
+	lastActive time.Time
+}
+
+func newClientConn(conn net.Conn, admin bool) *clientConn {
+	now := time.Now()
+	return &clientConn{conn: conn, since: now, admin: admin, lastActive: now}
+}
+
+// touch records a request on the connection
+func (cc *clientConn) touch(action string) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	cc.requests++
+	cc.lastAction = action
+	cc.lastActive = time.Now()
+}
+
+func (cc *clientConn) String() string {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+	now := time.Now()
+	last := cc.lastAction
+	if last == "" {
+		last = "-"
+	}
+	plane := "data"
+	if cc.admin {
+		plane = "admin"
+	}
+	return fmt.Sprintf("addr=%s plane=%s age=%s idle=%s requests=%d last=%s",
+		cc.conn.RemoteAddr(), plane, now.Sub(cc.since).Round(time.Second), now.Sub(cc.lastActive).Round(time.Second), cc.requests, last)
+}
+
+// clients lists the open connections, oldest first
+func (s *Server) clients() []string {
+	s.mu.Lock()
+	conns := make([]*clientConn, 0, len(s.conns))
+	for _, cc := range s.conns {
+		conns = append(conns, cc)
+	}
+	s.mu.Unlock()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].since.Before(conns[j].since) })
+	lines := make([]string, len(conns))
+	for i, cc := range conns {
+		lines[i] = cc.String()
+	}
+	return lines
+}
+
+// stats describes the running server, one "name: value" line each, for
+// ADMIN STATS and DIAGNOSE
+func (s *Server) stats() []string {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+	s.mu.Lock()
+	clients := len(s.conns)
+	journal := s.journal
+	s.mu.Unlock()
+	var revision uint64
+	if journal != nil {
+		revision = journal.Revision()
+	}
+	compression := s.kvs.Compression()
+	bloom := s.kvs.BloomFilterStats()
+	tracking := s.kvs.TrackingStats()
+	tiering := s.kvs.Tiering()
+	memory := s.kvs.MemoryUsage()
+	remembered, replays := s.dedup.stats()
+	cache := s.proxy.CacheStats()
+	lines := []string{
+		fmt.Sprintf("uptime: %s", time.Since(s.started).Round(time.Second)),
+		fmt.Sprintf("go_version: %s", runtime.Version()),
+		fmt.Sprintf("goroutines: %d", runtime.NumGoroutine()),
+		fmt.Sprintf("heap_alloc_bytes: %d", mem.HeapAlloc),
+		fmt.Sprintf("keys: %d", s.kvs.Len()),
+		fmt.Sprintf("used_memory_bytes: %d", memory.Used),
+		fmt.Sprintf("maxmemory_bytes: %d", memory.Max),
+		fmt.Sprintf("evicted_keys: %d", memory.Evicted),
+		fmt.Sprintf("oom_rejected_writes: %d", memory.Rejected),
+		fmt.Sprintf("cached_keys: %d", cache.Keys),
+		fmt.Sprintf("cached_bytes: %d", cache.Bytes),
+		fmt.Sprintf("cache_hits: %d", cache.Hits),
+		fmt.Sprintf("cache_misses: %d", cache.Misses),
+		fmt.Sprintf("cache_hit_ratio: %.3f", cache.HitRatio()),
+		fmt.Sprintf("cache_evictions: %d", cache.Evictions),
+		fmt.Sprintf("cache_fill_ratio: %.3f", cache.FillRatio()),
+		fmt.Sprintf("write_back_pending: %d", cache.Pending),
+		fmt.Sprintf("write_back_flushed: %d", cache.Flushed),
+		fmt.Sprintf("write_back_failed: %d", cache.Failed),
+		fmt.Sprintf("throttled_misses: %d", s.proxy.ThrottledMisses()),
+		fmt.Sprintf("tracking_clients: %d", tracking.Clients),
+		fmt.Sprintf("tracked_keys: %d", tracking.Keys),
+		fmt.Sprintf("clients: %d", clients),
+		fmt.Sprintf("journal_revision: %d", revision),
+		fmt.Sprintf("coalesced_sets: %d", s.coalesced.Load()),
+		fmt.Sprintf("soft_deleted_keys: %d", len(s.kvs.Trash())),
+		fmt.Sprintf("dedup_remembered: %d", remembered),
+		fmt.Sprintf("dedup_replays: %d", replays),
+		fmt.Sprintf("log_level: %s", kvstore.CurrentLogLevel()),
+		fmt.Sprintf("read_only: %s", onOff(s.ReadOnly())),
+		fmt.Sprintf("frozen: %s", onOff(s.frozen())),
+		fmt.Sprintf("role: %s", s.role()),
+		fmt.Sprintf("connected_replicas: %d", len(s.connectedReplicas())),
+		fmt.Sprintf("disk_free_bytes: %d", s.diskFree.Load()),
+		fmt.Sprintf("disk_low: %s", onOff(s.diskLow.Load())),
+		fmt.Sprintf("backups_paused: %s", onOff(s.kvs.BackupsPaused())),
+		fmt.Sprintf("encryption: %s", onOff(s.kvs.Encrypted())),
+		fmt.Sprintf("file_key: %s", cmp.Or(s.kvs.FileCipher().KeyID(), "none")),
+		fmt.Sprintf("compressed_keys: %d", compression.Keys),
+		fmt.Sprintf("compressed_raw_bytes: %d", compression.RawBytes),
+		fmt.Sprintf("compressed_bytes: %d", compression.Bytes),
+		fmt.Sprintf("bloom_filter: %s", onOff(bloom.On)),
+		fmt.Sprintf("bloom_filter_bytes: %d", bloom.Bytes),
+		fmt.Sprintf("bloom_filter_skipped_reads: %d", bloom.Skipped),
+		fmt.Sprintf("tier_hot_keys: %d", tiering.Hot),
+		fmt.Sprintf("tier_cold_keys: %d", tiering.Cold),
+		fmt.Sprintf("tier_cold_reads: %d", tiering.ColdReads),
+		fmt.Sprintf("tier_promotions: %d", tiering.Promotions),
+		fmt.Sprintf("tier_demotions: %d", tiering.Demotions),
+	}
+	lines = append(lines, s.replicationStats()...)
+	lines = append(lines, s.backupStats()...)
+	lines = append(lines, s.slowStats()...)
+	lines = append(lines, s.changeStats()...)
+	return append(lines, s.sloStats()...)
+}
+
+// backupStats describes the WAL, the last snapshot and when the next is
+// due
+func (s *Server) backupStats() []string {
+	last, next := s.kvs.LastBackup()
+	when, status := "never", "none"
+	if !last.Time.IsZero() {
+		when, status = last.Time.UTC().Format(time.RFC3339), "ok"
+		if last.Err != nil {
+			status = "failed: " + last.Err.Error()
+		}
+	}
+	due := "none"
+	if !next.IsZero() {
+		due = next.UTC().Format(time.RFC3339)
+	}
+	sink := "none"
+	if s := s.kvs.SnapshotSink(); s != nil {
+		sink = s.String()
+	}
+	wal := s.kvs.WALStats()
+	walPath := wal.Path
+	if walPath == "" {
+		walPath = "none"
+	}
+	return []string{
+		fmt.Sprintf("wal: %s", walPath),
+		fmt.Sprintf("wal_records: %d", wal.Records),
+		fmt.Sprintf("wal_bytes: %d", wal.Bytes),
+		fmt.Sprintf("wal_fsync: %s", wal.Sync),
+		fmt.Sprintf("wal_fsyncs: %d", wal.Fsyncs),
+		fmt.Sprintf("wal_commits: %d", wal.Commits),
+		fmt.Sprintf("wal_rewrite: %s", rewriteState(wal)),
+		fmt.Sprintf("wal_errors: %d", wal.Errors),
+		fmt.Sprintf("backup_sink: %s", sink),
+		fmt.Sprintf("last_backup: %s", when),
+		fmt.Sprintf("last_backup_status: %s", status),
+		fmt.Sprintf("last_backup_duration: %s", last.Duration.Round(time.Millisecond)),
+		fmt.Sprintf("next_backup: %s", due),
+	}
+}
+
+// admin runs an ADMIN request: request.Value is the subcommand and
+// request.Key its argument, if any
+func (s *Server) admin(ctx context.Context, request protocol.Request) protocol.Response {
+	var response protocol.Response
+	switch strings.ToUpper(request.Value) {
+	case protocol.AdminSnapshot:
+		if err := kvstore.WriteBackup(s.kvs); err != nil {
+			kvstore.RecordError("Error writing backup:", err)
+			response.Message = protocol.MsgServerError
+			break
+		}
+		response.Value, _ = s.kvs.Backup()
+		response.Message = protocol.MsgSnapshotWritten
+		response.Success = true
+	case protocol.AdminBGSave:
+		return s.bgsave(request.Key)
+	case protocol.AdminRewriteWAL:
+		return s.rewriteWAL(request.Key)
+	case protocol.AdminRestore:
+		path, ok := s.backupFile(request.Key)
+		if !ok {
+			response.Message = protocol.MsgInvalidPath
+			break
+		}
+		var opts kvstore.RestoreOptions
+		if len(request.Values) > 0 {
+			opts.Mode = request.Values[0]
+		}
+		opts.Patterns = request.Keys
+		return s.restore(path, func() (kvstore.RestoreStats, error) {
+			return kvstore.RestoreBackupWith(s.kvs, path, opts)
+		})
+	case protocol.AdminVerifyBackup:
+		path, ok := s.backupFile(request.Key)
+		if !ok {
+			response.Message = protocol.MsgInvalidPath
+			break
+		}
+		report, err := kvstore.VerifyBackup(s.kvs, path)
+		if err != nil {
+			kvstore.RecordError("Error verifying backup:", err)
+			response.Message = err.Error()
+			break
+		}
+		checksum := "ok"
+		if !report.Checked {
+			checksum = "none"
+		}
+		format := report.Format
+		if report.Version > 0 {
+			format += " v" + strconv.Itoa(report.Version)
+		}
+		created := "unknown"
+		if !report.Created.IsZero() {
+			created = report.Created.UTC().Format(time.RFC3339)
+		}
+		response.Value = path
+		response.Values = []string{
+			fmt.Sprintf("format: %s", format),
+			fmt.Sprintf("compression: %s", report.Compression),
+			fmt.Sprintf("created: %s", created),
+			fmt.Sprintf("keys: %d", report.Keys),
+			fmt.Sprintf("deltas: %d", report.Deltas),
+			fmt.Sprintf("checksum: %s", checksum),
+			fmt.Sprintf("loadable: %d", report.Loaded),
+			fmt.Sprintf("expired: %d", report.Expired),
+			fmt.Sprintf("damaged: %d", report.Damaged),
+		}
+		response.Message = protocol.MsgBackupVerified
+		response.Success = true
+	case protocol.AdminBackups:
+		files, err := s.kvs.Backups()
+		if err != nil {
+			kvstore.RecordError("Error listing backups:", err)
+			response.Message = protocol.MsgServerError
+			break
+		}
+		response.Values = []string{}
+		for _, f := range files {
+			response.Values = append(response.Values, fmt.Sprintf("%s %d %s", f.Name, f.Size, f.Time.Format(time.RFC3339)))
+		}
+		response.Success = true
+	case protocol.AdminDump:
+		return s.dump()
+	case protocol.AdminLoad:
+		var snapshot kvstore.BackupSnapshot
+		if err := json.Unmarshal([]byte(request.Key), &snapshot); err != nil {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		return s.restore("a loaded snapshot", func() (kvstore.RestoreStats, error) {
+			return s.kvs.LoadSnapshot(snapshot)
+		})
+	case protocol.AdminFreeze:
+		return s.freeze(request.Key)
+	case protocol.AdminReplicaOf:
+		return s.replicaOfCommand(request.Key)
+	case protocol.AdminSetSlot:
+		return s.setSlot(request)
+	case protocol.AdminMigrate:
+		return s.migrate(ctx, request)
+	case protocol.AdminImport:
+		return s.importKeys(request)
+	case protocol.AdminExport:
+		return s.export(request.Key)
+	case protocol.AdminStats:
+		response.Values = s.stats()
+		response.Success = true
+	case protocol.AdminFlushCache:
+		response.Value = strconv.Itoa(s.proxy.Flush())
+		response.Message = protocol.MsgCacheFlushed
+		response.Success = true
+	case protocol.AdminClients:
+		response.Values = s.clients()
+		response.Success = true
+	case protocol.AdminSlowLog:
+		switch strings.ToLower(request.Key) {
+		case "":
+			response.Values = s.slowLog.entries()
+		case "reset":
+			response.Value = strconv.Itoa(s.slowLog.reset())
+		default:
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+		response.Success = true
+	case protocol.AdminLogLevel:
+		if request.Key != "" {
+			level, err := kvstore.ParseLogLevel(strings.ToLower(request.Key))
+			if err != nil {
+				response.Message = protocol.MsgInvalidLogLevel
+				break
+			}
+			kvstore.SetLogLevel(level)
+		}
+		response.Value = kvstore.CurrentLogLevel().String()
+		response.Success = true
+	case protocol.AdminReadOnly:
+		switch strings.ToLower(request.Key) {
+		case "on":
+			s.SetReadOnly(true)
+			kvstore.Logf(kvstore.LogInfo, "Read-only mode on")
+		case "off":
+			s.SetReadOnly(false)
+			kvstore.Logf(kvstore.LogInfo, "Read-only mode off")
+		case "":
+		default:
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+		response.Value = onOff(s.ReadOnly())
+		response.Success = true
+	case protocol.AdminMemory:
+		samples := 0
+		if request.Key != "" {
+			n, err := strconv.Atoi(request.Key)
+			if err != nil || n <= 0 {
+				response.Message = protocol.MsgInvalidArgument
+				return response
+			}
+			samples = n
+		}
+		total := kvstore.MemoryEstimate{Prefix: "(total)"}
+		for _, m := range s.kvs.EstimateMemory(samples) {
+			response.Values = append(response.Values, m.String())
+			total.Keys += m.Keys
+			total.KeyBytes += m.KeyBytes
+			total.ValueBytes += m.ValueBytes
+			total.OverheadBytes += m.OverheadBytes
+			total.Sampled += m.Sampled
+		}
+		response.Values = append(response.Values, total.String())
+		response.Success = true
+	case protocol.AdminKeyspace:
+		samples := 0
+		if request.Key != "" {
+			n, err := strconv.Atoi(request.Key)
+			if err != nil || n <= 0 {
+				response.Message = protocol.MsgInvalidArgument
+				return response
+			}
+			samples = n
+		}
+		response.Values = s.keyspace(samples)
+		response.Success = true
+	case protocol.AdminTrash:
+		for _, t := range s.kvs.Trash() {
+			response.Values = append(response.Values, t.String())
+		}
+		response.Success = true
+	case protocol.AdminPurge:
+		response.Value = strconv.Itoa(s.kvs.Purge(request.Key))
+		response.Success = true
+	case protocol.AdminNamespaces:
+		for _, ns := range s.kvs.NamespaceStats() {
+			response.Values = append(response.Values, ns.String())
+		}
+		response.Success = true
+	default:
+		response.Message = protocol.MsgInvalidAction
+	}
+	return response
+}
+
+// backupFile is the file a RESTORE or VERIFYBACKUP with Key name reads:
+// the backup file if name is empty, else name in its directory; ok is
+// false for a name outside it
+func (s *Server) backupFile(name string) (path string, ok bool) {
+	path, _ = s.kvs.Backup()
+	if name == "" {
+		return path, true
+	}
+	// only files next to the backup file
+	if !filepath.IsLocal(name) {
+		return "", false
+	}
+	return filepath.Join(filepath.Dir(path), name), true
+}
+
+// restore replaces the contents of the store by running load, named from
+// for the log
+func (s *Server) restore(from string, load func() (kvstore.RestoreStats, error)) protocol.Response {
+	var response protocol.Response
+	// hold off journaled writes so none lands between the restore and
+	// the cache flush
+	s.writeMu.Lock()
+	stats, err := load()
+	if err == nil {
+		s.proxy.Flush()
+	}
+	s.writeMu.Unlock()
+	if err != nil {
+		kvstore.RecordError("Error restoring backup:", err)
+		response.Message = err.Error()
+		return response
+	}
+	kvstore.Logf(kvstore.LogInfo, "Restored %s: %d keys loaded, %d expired, %d damaged, %d kept", from, stats.Loaded, stats.Expired, stats.Damaged, stats.Kept)
+	response.Values = []string{
+		fmt.Sprintf("loaded: %d", stats.Loaded),
+		fmt.Sprintf("expired: %d", stats.Expired),
+		fmt.Sprintf("damaged: %d", stats.Damaged),
+		fmt.Sprintf("kept: %d", stats.Kept),
+	}
+	response.Message = protocol.MsgRestored
+	response.Success = true
+	return response
+}
+
+// bgsave runs ADMIN BGSAVE: it starts a snapshot in the background unless
+// arg is "status", and reports on the snapshots it started
+func (s *Server) bgsave(arg string) protocol.Response {
+	var response protocol.Response
+	switch strings.ToLower(arg) {
+	case "status":
+	case "":
+		response.Message = protocol.MsgSnapshotRunning
+		if kvstore.StartBackup(s.kvs) {
+			response.Message = protocol.MsgSnapshotStarted
+		}
+	default:
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	status := s.kvs.BackgroundBackup()
+	state := "none"
+	switch {
+	case status.Running:
+		state = "running"
+	case status.Err != nil:
+		state = "failed"
+	case !status.Finished.IsZero():
+		state = "ok"
+	}
+	response.Value, _ = s.kvs.Backup()
+	response.Values = []string{fmt.Sprintf("state: %s", state)}
+	if !status.Started.IsZero() {
+		response.Values = append(response.Values, fmt.Sprintf("started: %s", status.Started.UTC().Format(time.RFC3339)))
+	}
+	if !status.Finished.IsZero() {
+		response.Values = append(response.Values, fmt.Sprintf("finished: %s", status.Finished.UTC().Format(time.RFC3339)))
+	}
+	if status.Err != nil {
+		response.Values = append(response.Values, fmt.Sprintf("error: %v", status.Err))
+	}
+	response.Success = true
+	return response
+}
+
+// rewriteWAL runs ADMIN REWRITEWAL: it starts a rewrite of the WAL in the
+// background unless arg is "status", and reports on the last one
+func (s *Server) rewriteWAL(arg string) protocol.Response {
+	var response protocol.Response
+	if s.kvs.WALStats().Path == "" {
+		response.Message = protocol.MsgNoWAL
+		return response
+	}
+	switch strings.ToLower(arg) {
+	case "status":
+	case "":
+		response.Message = protocol.MsgRewriteRunning
+		if kvstore.StartWALRewrite(s.kvs) {
+			response.Message = protocol.MsgRewriteStarted
+		}
+	default:
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	wal := s.kvs.WALStats()
+	response.Value = wal.Path
+	response.Values = []string{fmt.Sprintf("state: %s", rewriteState(wal))}
+	if last := wal.Rewrite; !last.Time.IsZero() {
+		response.Values = append(response.Values,
+			fmt.Sprintf("finished: %s", last.Time.UTC().Format(time.RFC3339)),
+			fmt.Sprintf("duration: %s", last.Duration.Round(time.Millisecond)))
+		if last.Err != nil {
+			response.Values = append(response.Values, fmt.Sprintf("error: %v", last.Err))
+		}
+	}
+	response.Success = true
+	return response
+}
+
+// rewriteState is "running" during a rewrite of the WAL, else how the
+// last one went: "none", "ok" or "failed"
+func rewriteState(wal kvstore.WALStats) string {
+	switch {
+	case wal.Rewriting:
+		return "running"
+	case wal.Rewrite.Time.IsZero():
+		return "none"
+	case wal.Rewrite.Err != nil:
+		return "failed"
+	}
+	return "ok"
+}
+
+func onOff(on bool) string {
+	if on {
+		return "on"
+	}
+	return "off"
+}
@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// AdminPlane separates operational actions, ADMIN, DIAGNOSE and custom
+// commands marked Admin, from the data plane, so the data listeners can be
+// exposed more widely than the ones that can restore snapshots or read
+// diagnostics.
+//
+// With Addrs set those actions are only served on these listeners, which
+// also serve everything else, and are refused with protocol.MsgAdminOnly
+// on the data listeners. Allow, if not empty, limits which clients may
+// connect to the admin listeners. Token, if set, must be sent in
+// Request.Token with every admin action, on any listener.
+type AdminPlane struct {
+	Addrs []string
+	Allow []*net.IPNet
+	Token string
+}
+
+// ParseAllow parses comma-separated CIDRs or single addresses, e.g.
+// "10.0.0.0/8,127.0.0.1", for AdminPlane.Allow
+func ParseAllow(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", item)
+			}
+			bits := 8 * len(ip.To4())
+			if bits == 0 {
+				bits = 8 * net.IPv6len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// SetAdminPlane sets the admin listeners and their access rules; call it
+// before Start
+func (s *Server) SetAdminPlane(p AdminPlane) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.adminPlane = p
+}
+
+// isAdmin reports whether action belongs to the admin plane
+func (s *Server) isAdmin(action string) bool {
+	if adminActions[action] {
+		return true
+	}
+	cmd, ok := command(action)
+	return ok && cmd.Admin
+}
+
+// admitted reports whether conn may use an admin listener
+func (s *Server) admitted(conn net.Conn) bool {
+	if len(s.adminPlane.Allow) == 0 {
+		return true
+	}
+	addr, ok := conn.RemoteAddr().(*net.TCPAddr)
+	if !ok {
+		return false
+	}
+	for _, n := range s.adminPlane.Allow {
+		if n.Contains(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// adminRefusal is the message refusing request, which arrived on an admin
+// listener or not, or empty if it may run
+func (s *Server) adminRefusal(request protocol.Request, onAdmin bool) string {
+	if !s.isAdmin(request.Action) {
+		return ""
+	}
+	if len(s.adminPlane.Addrs) > 0 && !onAdmin {
+		return protocol.MsgAdminOnly
+	}
+	if token := s.adminPlane.Token; token != "" && subtle.ConstantTimeCompare([]byte(request.Token), []byte(token)) != 1 {
+		return protocol.MsgUnauthorized
+	}
+	return ""
+}
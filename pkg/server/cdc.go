@@ -0,0 +1,143 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+const (
+	// cdcBatch is the most events published at once
+	cdcBatch = 500
+	// cdcBackoff and cdcMaxBackoff bound the wait between failed publishes
+	cdcBackoff    = 100 * time.Millisecond
+	cdcMaxBackoff = 10 * time.Second
+	// cdcDrainTimeout bounds the wait for the buffered events on shutdown,
+	// and the last publish, and cdcDrainPoll each wait for the next one
+	cdcDrainTimeout = 5 * time.Second
+	cdcDrainPoll    = 10 * time.Millisecond
+)
+
+// changeFeed is the state of change data capture, see SetChangeSink
+type changeFeed struct {
+	sink      kvstore.ChangeSink
+	published atomic.Uint64
+	failures  atomic.Uint64
+
+	mu      sync.Mutex
+	lastErr error // of the last publish, nil once one succeeds
+}
+
+// SetChangeSink publishes every change to the store's keys, sets,
+// updates, deletes, expiries and evictions, to sink, in the order of the
+// writes for each key; call it before Start. Publishing reads
+// KeyValueStore.Events, whose buffer and policy say what happens while the
+// sink falls behind: with kvstore.EventsBlock writes wait for it and none
+// are lost, otherwise DroppedEvents counts those that are. A failed
+// publish is retried, with backoff, until it succeeds, so a change may
+// reach the sink more than once.
+func (s *Server) SetChangeSink(sink kvstore.ChangeSink) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.changes = &changeFeed{sink: sink}
+}
+
+// publishChanges publishes the store's events to f.sink until ctx is
+// done, then what is already buffered, and closes the sink
+func (s *Server) publishChanges(ctx context.Context, f *changeFeed, events <-chan kvstore.Event) {
+	defer f.sink.Close()
+	for {
+		var batch []kvstore.Event
+		select {
+		case <-ctx.Done():
+			s.drainChanges(f, events)
+			return
+		case e := <-events:
+			batch = append(batch, e)
+		}
+		// whatever else is waiting goes along
+	fill:
+		for len(batch) < cdcBatch {
+			select {
+			case e := <-events:
+				batch = append(batch, e)
+			default:
+				break fill
+			}
+		}
+		for backoff := cdcBackoff; !f.publish(ctx, batch); backoff = min(backoff*2, cdcMaxBackoff) {
+			select {
+			case <-ctx.Done():
+				s.drainChanges(f, events, batch...)
+				return
+			case <-time.After(backoff):
+			}
+		}
+	}
+}
+
+// drainChanges makes one last attempt, on shutdown, to publish pending and
+// the events buffered behind it, up to the last write
+func (s *Server) drainChanges(f *changeFeed, events <-chan kvstore.Event, pending ...kvstore.Event) {
+	for deadline := time.Now().Add(cdcDrainTimeout); s.kvs.PendingEvents() > 0 && time.Now().Before(deadline); {
+		select {
+		case e := <-events:
+			pending = append(pending, e)
+		case <-time.After(cdcDrainPoll):
+		}
+	}
+	if len(pending) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cdcDrainTimeout)
+	defer cancel()
+	if !f.publish(ctx, pending) {
+		kvstore.Logf(kvstore.LogWarn, "Dropped %d changes not published to %s on shutdown", len(pending), f.sink)
+	}
+}
+
+// publish publishes batch, reporting the first of a run of failures
+func (f *changeFeed) publish(ctx context.Context, batch []kvstore.Event) bool {
+	err := f.sink.Publish(ctx, batch)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err != nil {
+		f.failures.Add(1)
+		if f.lastErr == nil {
+			kvstore.RecordError("Error publishing changes:", err)
+		}
+		f.lastErr = err
+		return false
+	}
+	if f.lastErr != nil {
+		kvstore.Logf(kvstore.LogInfo, "Publishing changes to %s again", f.sink)
+	}
+	f.lastErr = nil
+	f.published.Add(uint64(len(batch)))
+	return true
+}
+
+// changeStats describes change data capture, if it is on
+func (s *Server) changeStats() []string {
+	f := s.changes
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	status := "ok"
+	if f.lastErr != nil {
+		status = "failing: " + f.lastErr.Error()
+	}
+	f.mu.Unlock()
+	return []string{
+		fmt.Sprintf("cdc_sink: %s", f.sink),
+		fmt.Sprintf("cdc_status: %s", status),
+		fmt.Sprintf("cdc_published: %d", f.published.Load()),
+		fmt.Sprintf("cdc_failures: %d", f.failures.Load()),
+		fmt.Sprintf("events_dropped: %d", s.kvs.DroppedEvents()),
+	}
+}
@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// memorySink is a ChangeSink keeping what it is sent, failing the first
+// fail publishes
+type memorySink struct {
+	mu     sync.Mutex
+	fail   int
+	events []kvstore.Event
+	closed bool
+}
+
+func (m *memorySink) Publish(ctx context.Context, events []kvstore.Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.fail > 0 {
+		m.fail--
+		return errors.New("sink down")
+	}
+	m.events = append(m.events, events...)
+	return nil
+}
+
+func (m *memorySink) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.closed = true
+	return nil
+}
+
+func (m *memorySink) String() string { return "memory" }
+
+func (m *memorySink) published() []kvstore.Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]kvstore.Event(nil), m.events...)
+}
+
+func TestChangesReachTheSink(t *testing.T) {
+	kvs := kvstore.NewKeyValueStore()
+	clock := kvstore.NewManualClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+	kvs.SetClock(clock)
+	sink := &memorySink{fail: 1}
+	s := NewServerWithStore(kvs, "127.0.0.1:0")
+	s.SetPersister(kvstore.NoPersistence{})
+	s.SetFiles(Files{})
+	s.SetChangeSink(sink)
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	kvs.SET("a", "1")
+	kvs.SETEX("b", "2", time.Second)
+	kvs.DELETE("a")
+	clock.Advance(2 * time.Second)
+	kvstore.ClearExpiredKeysNow(kvs, nil)
+
+	want := []kvstore.Event{
+		{Type: kvstore.EventSet, Key: "a", Value: "1"},
+		{Type: kvstore.EventSet, Key: "b", Value: "2"},
+		{Type: kvstore.EventDelete, Key: "a"},
+		{Type: kvstore.EventExpire, Key: "b"},
+	}
+	// the first publish fails and is retried
+	deadline := time.Now().Add(5 * time.Second)
+	for len(sink.published()) < len(want) && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	got := sink.published()
+	if len(got) != len(want) {
+		t.Fatalf("sink got %d changes, want %d", len(got), len(want))
+	}
+	for i, e := range got {
+		if e.Type != want[i].Type || e.Key != want[i].Key || e.Value != want[i].Value {
+			t.Errorf("change %d = %s %s %q, want %s %s %q", i, e.Type, e.Key, e.Value, want[i].Type, want[i].Key, want[i].Value)
+		}
+	}
+	if f := s.changes; f.failures.Load() != 1 || f.published.Load() != uint64(len(want)) {
+		t.Errorf("%d failures and %d published, want 1 and %d", f.failures.Load(), f.published.Load(), len(want))
+	}
+
+	// what is written up to shutdown is published on the way down
+	kvs.SET("last", "1")
+	s.Stop()
+	got = sink.published()
+	if e := got[len(got)-1]; e.Key != "last" {
+		t.Errorf("last change published %s %s, want the SET before Stop", e.Type, e.Key)
+	}
+	sink.mu.Lock()
+	defer sink.mu.Unlock()
+	if !sink.closed {
+		t.Error("Stop left the sink open")
+	}
+}
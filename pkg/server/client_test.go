@@ -0,0 +1,178 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestClientRoundTrip(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore(), "127.0.0.1:0", "json://127.0.0.1:0")
+	ctx := context.Background()
+	for i, codec := range []protocol.Codec{protocol.Gob, protocol.JSON} {
+		c := kvsclient.NewClient(listenAddr(s, i), kvsclient.WithCodec(codec), kvsclient.WithTimeout(time.Second))
+		defer c.Close()
+		key := "k/" + codec.Name()
+		if _, err := c.Get(ctx, key); !errors.Is(err, kvsclient.ErrNotFound) {
+			t.Errorf("%s: Get of a missing key = %v, want ErrNotFound", codec.Name(), err)
+		}
+		if err := c.Set(ctx, key, "v"); err != nil {
+			t.Fatalf("%s: Set: %v", codec.Name(), err)
+		}
+		if value, err := c.Get(ctx, key); err != nil || value != "v" {
+			t.Errorf("%s: Get = %q, %v", codec.Name(), value, err)
+		}
+		if err := c.Delete(ctx, key); err != nil {
+			t.Errorf("%s: Delete: %v", codec.Name(), err)
+		}
+		if err := c.Delete(ctx, key); !errors.Is(err, kvsclient.ErrNotFound) {
+			t.Errorf("%s: Delete of a missing key = %v, want ErrNotFound", codec.Name(), err)
+		}
+	}
+}
+
+// openConns returns how many client connections s has open
+func openConns(s *Server) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.conns)
+}
+
+func TestClientPoolReusesConnections(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore())
+	c := newTestClient(t, s)
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		if err := c.Set(ctx, "k", "v"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the HELLO probe's connection may still be closing
+	deadline := time.Now().Add(time.Second)
+	for openConns(s) != 1 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if n := openConns(s); n != 1 {
+		t.Errorf("%d connections open after serial requests, want 1", n)
+	}
+
+	// a pooled connection the server dropped is found out and replaced
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	time.Sleep(1100 * time.Millisecond)
+	if _, err := c.Get(ctx, "k"); err != nil {
+		t.Errorf("Get after the server dropped the idle connection: %v", err)
+	}
+}
+
+func TestClientPoolCapsActiveConnections(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore())
+	c := newTestClient(t, s, kvsclient.WithMaxActive(1), kvsclient.WithDialTimeout(50*time.Millisecond))
+	ctx := context.Background()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatal(err)
+	}
+	busy := make(chan error)
+	go func() {
+		_, err := c.BLPop(ctx, "queue", 500*time.Millisecond)
+		busy <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	if err := c.Ping(ctx); err == nil {
+		t.Error("Ping got a second connection past WithMaxActive(1)")
+	}
+	<-busy
+	if err := c.Ping(ctx); err != nil {
+		t.Errorf("Ping once the connection was free: %v", err)
+	}
+}
+
+func TestClientAsyncCalls(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore())
+	c := newTestClient(t, s)
+	ctx := context.Background()
+	var sets, gets []*kvsclient.Future
+	for i := 0; i < 50; i++ {
+		sets = append(sets, c.SetAsync(ctx, fmt.Sprintf("k%d", i), fmt.Sprint(i), 0))
+	}
+	for i := 0; i < 50; i++ {
+		gets = append(gets, c.GetAsync(ctx, fmt.Sprintf("k%d", i)))
+	}
+	missing := c.DeleteAsync(ctx, "missing")
+	for i, f := range sets {
+		if _, err := f.Wait(ctx); err != nil {
+			t.Fatalf("SetAsync %d: %v", i, err)
+		}
+	}
+	for i, f := range gets {
+		if value, err := f.Wait(ctx); err != nil || value != fmt.Sprint(i) {
+			t.Errorf("GetAsync k%d = %q, %v", i, value, err)
+		}
+	}
+	if _, err := missing.Wait(ctx); !errors.Is(err, kvsclient.ErrNotFound) {
+		t.Errorf("DeleteAsync of a missing key = %v, want ErrNotFound", err)
+	}
+}
+
+// nextEvent returns the next event of events, failing the test if none
+// comes within a second
+func nextEvent(t *testing.T, events <-chan kvsclient.Event) kvsclient.Event {
+	t.Helper()
+	select {
+	case e, ok := <-events:
+		if !ok {
+			t.Fatal("watch channel closed")
+		}
+		return e
+	case <-time.After(time.Second):
+		t.Fatal("no event within a second")
+	}
+	return kvsclient.Event{}
+}
+
+func TestClientWatchResumesAfterReconnect(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore())
+	c := newTestClient(t, s)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	c.Set(ctx, "w/before", "1")
+	events, err := c.Watch(ctx, "w/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Set(ctx, "w/1", "1")
+	c.Set(ctx, "x/1", "1")
+	c.Set(ctx, "w/2", "1")
+	for _, key := range []string{"w/1", "w/2"} {
+		if e := nextEvent(t, events); e.Key != key {
+			t.Fatalf("event for %s, want %s", e.Key, key)
+		}
+	}
+
+	// the watch's connection fails and the writes go on meanwhile
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+	other := newTestClient(t, s)
+	other.Set(ctx, "w/3", "1")
+	other.Set(ctx, "w/4", "1")
+	for _, key := range []string{"w/3", "w/4"} {
+		if e := nextEvent(t, events); e.Key != key {
+			t.Fatalf("event for %s after the reconnect, want %s", e.Key, key)
+		}
+	}
+	cancel()
+	for range events {
+	}
+}
@@ -0,0 +1,434 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// resharding defaults
+const (
+	// ClusterStateFile is where a server keeps the slot map once slots
+	// have moved, see SetClusterState
+	ClusterStateFile = "cluster.json"
+	// DefaultMigrateBatch is how many keys ADMIN MIGRATE moves unless its
+	// Limit says otherwise
+	DefaultMigrateBatch = 100
+)
+
+// cluster is this server's view of a cluster: which server owns each slot,
+// and which slots are moving to or from this one
+type cluster struct {
+	self string
+
+	mu        sync.RWMutex
+	owner     [protocol.NumSlots]string
+	migrating map[int]string // slots of this server, to the server they move to
+	importing map[int]string // slots of others, to the server they come from
+	stateFile string
+}
+
+// clusterState is what the cluster state file holds
+type clusterState struct {
+	Slots []string `json:"slots"`
+}
+
+// SetCluster makes the server one of nodes, which share the slots in equal
+// contiguous ranges in the order given; self is this server's address as it
+// appears in nodes. A server not in nodes joins owning no slots, for
+// resharding to move some to. Call it before Start.
+func (s *Server) SetCluster(self string, nodes []string) error {
+	if len(nodes) == 0 {
+		return errors.New("a cluster needs at least one node")
+	}
+	c := &cluster{self: self, migrating: make(map[int]string), importing: make(map[int]string)}
+	for i, node := range nodes {
+		first, last := i*protocol.NumSlots/len(nodes), (i+1)*protocol.NumSlots/len(nodes)-1
+		for slot := first; slot <= last; slot++ {
+			c.owner[slot] = node
+		}
+	}
+	s.cluster = c
+	return nil
+}
+
+// SetClusterState keeps the slot map in path, so slots moved by SETSLOT
+// stay moved across restarts: the map it holds, if it exists, replaces
+// the one SetCluster made. Call it after SetCluster.
+func (s *Server) SetClusterState(path string) error {
+	c := s.cluster
+	if c == nil {
+		return errors.New("not a cluster")
+	}
+	if path == "" {
+		path = ClusterStateFile
+	}
+	c.stateFile = path
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var state clusterState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	var owner [protocol.NumSlots]string
+	for _, line := range state.Slots {
+		r, err := protocol.ParseSlotRange(line)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for slot := r.First; slot <= r.Last; slot++ {
+			owner[slot] = r.Addr
+		}
+	}
+	c.owner = owner
+	return nil
+}
+
+// save writes the slot map to the state file; caller holds c.mu
+func (c *cluster) save() error {
+	if c.stateFile == "" {
+		return nil
+	}
+	data, err := json.Marshal(clusterState{Slots: c.ranges()})
+	if err != nil {
+		return err
+	}
+	return kvstore.WriteFileAtomic(c.stateFile, data, 0o600)
+}
+
+// ranges lists the slot map as CLUSTER INFO lines; caller holds c.mu
+func (c *cluster) ranges() []string {
+	return runs(func(slot int) string { return c.owner[slot] }, "")
+}
+
+// runs formats each run of slots that value gives the same non-empty
+// result as a "first-last result" line, with prefix before the result
+func runs(value func(slot int) string, prefix string) []string {
+	var lines []string
+	for first := 0; first < protocol.NumSlots; {
+		v := value(first)
+		last := first
+		for last+1 < protocol.NumSlots && value(last+1) == v {
+			last++
+		}
+		if v != "" {
+			lines = append(lines, protocol.SlotRange{First: first, Last: last, Addr: prefix + v}.String())
+		}
+		first = last + 1
+	}
+	return lines
+}
+
+// ownerOf returns the server owning slot
+func (c *cluster) ownerOf(slot int) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.owner[slot]
+}
+
+// moved returns where request's key is served if not here: MOVED and the
+// owner, or ASK and the server its slot is moving to
+func (s *Server) moved(request protocol.Request) (message, addr string) {
+	if s.cluster == nil {
+		return "", ""
+	}
+	if !keyActions[request.Action] {
+		if cmd, ok := command(request.Action); !ok || !cmd.Keyed {
+			return "", ""
+		}
+	}
+	return s.redirect(request.Key, request.Asking)
+}
+
+// redirect returns where key is served if not here. A slot's owner serves
+// the keys it holds while the slot moves and sends the rest on with ASK,
+// and the server it moves to serves them when asked. Requests on keys
+// hold multiMu from here until they are done, see holdsOffExec, so a key
+// isn't moved between this check and the request.
+func (s *Server) redirect(key string, asking bool) (message, addr string) {
+	c := s.cluster
+	if c == nil {
+		return "", ""
+	}
+	slot := protocol.Slot(key)
+	c.mu.RLock()
+	owner, to, from := c.owner[slot], c.migrating[slot], c.importing[slot]
+	c.mu.RUnlock()
+	switch {
+	case owner != c.self && from != "" && asking:
+		return "", ""
+	case owner != c.self:
+		return protocol.MsgMoved, owner
+	case to != "" && s.revisionOf(key) == 0:
+		return protocol.MsgAsk, to
+	}
+	return "", ""
+}
+
+// elsewhere returns the server owning key if it is not this one
+func (s *Server) elsewhere(key string) (string, bool) {
+	if s.cluster == nil {
+		return "", false
+	}
+	owner := s.cluster.ownerOf(protocol.Slot(key))
+	return owner, owner != s.cluster.self
+}
+
+// multiKeys returns the keys besides Key a request names: the second key
+// in Value of RENAME and COPY, or Keys of a BATCH or set operation
+func multiKeys(request protocol.Request) []string {
+	switch request.Action {
+	case protocol.ActionRename, protocol.ActionCopy:
+		return []string{request.Value}
+	case protocol.ActionBatch, protocol.ActionSUnion, protocol.ActionSInter, protocol.ActionSDiff:
+		return request.Keys
+	}
+	return nil
+}
+
+// crossSlot reports whether request names a second key in Value, as RENAME
+// and COPY do, or a key in Keys of a BATCH or set operation, that another
+// server owns
+func (s *Server) crossSlot(request protocol.Request) bool {
+	if s.cluster == nil {
+		return false
+	}
+	for _, key := range multiKeys(request) {
+		if _, ok := s.elsewhere(key); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// unsettled reports whether request names several keys and one of them is
+// in a slot moving away, which may have left some of them here and some
+// there; the client tries again once the slot has moved
+func (s *Server) unsettled(request protocol.Request) bool {
+	c := s.cluster
+	keys := multiKeys(request)
+	if c == nil || len(keys) == 0 {
+		return false
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.migrating) == 0 {
+		return false
+	}
+	for _, key := range keys {
+		if c.migrating[protocol.Slot(key)] != "" {
+			return true
+		}
+	}
+	return c.migrating[protocol.Slot(request.Key)] != ""
+}
+
+// clusterInfo lists the slot map for CLUSTER INFO
+func (s *Server) clusterInfo() []string {
+	if s.cluster == nil {
+		return nil
+	}
+	s.cluster.mu.RLock()
+	defer s.cluster.mu.RUnlock()
+	return s.cluster.ranges()
+}
+
+// clusterMigrations lists the slots moving to or from this server for
+// CLUSTER MIGRATIONS
+func (s *Server) clusterMigrations() []string {
+	c := s.cluster
+	if c == nil {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	lines := runs(func(slot int) string { return c.migrating[slot] }, "migrating ")
+	return append(lines, runs(func(slot int) string { return c.importing[slot] }, "importing ")...)
+}
+
+// parseSlots parses a "first-last" slot range, or a single slot
+func parseSlots(s string) (first, last int, ok bool) {
+	a, b, found := strings.Cut(s, "-")
+	if !found {
+		b = a
+	}
+	first, err1 := strconv.Atoi(a)
+	last, err2 := strconv.Atoi(b)
+	if err1 != nil || err2 != nil || first < 0 || last >= protocol.NumSlots || first > last {
+		return 0, 0, false
+	}
+	return first, last, true
+}
+
+// setSlot runs ADMIN SETSLOT
+func (s *Server) setSlot(request protocol.Request) protocol.Response {
+	var response protocol.Response
+	c := s.cluster
+	if c == nil {
+		response.Message = protocol.MsgInvalidAction
+		return response
+	}
+	first, last, ok := parseSlots(request.Key)
+	if !ok || len(request.Values) == 0 {
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	state, addr := strings.ToUpper(request.Values[0]), ""
+	if len(request.Values) > 1 {
+		addr = request.Values[1]
+	}
+	if state != "STABLE" && addr == "" {
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	// keys of this server's slots that another is to own must have moved
+	// first, or they would be lost to it
+	if state == "NODE" && addr != c.self {
+		if _, left := s.kvs.SnapshotOf(func(key string) bool {
+			slot := protocol.Slot(key)
+			return slot >= first && slot <= last && c.ownerOf(slot) == c.self
+		}, 0); left {
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for slot := first; slot <= last; slot++ {
+		mine := c.owner[slot] == c.self
+		switch state {
+		case "MIGRATING", "IMPORTING":
+			if mine != (state == "MIGRATING") || addr == c.self {
+				response.Message = protocol.MsgInvalidArgument
+				return response
+			}
+		case "NODE", "STABLE":
+		default:
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+	}
+	for slot := first; slot <= last; slot++ {
+		delete(c.migrating, slot)
+		delete(c.importing, slot)
+		switch state {
+		case "MIGRATING":
+			c.migrating[slot] = addr
+		case "IMPORTING":
+			c.importing[slot] = addr
+		case "NODE":
+			c.owner[slot] = addr
+		}
+	}
+	if state == "NODE" {
+		if err := c.save(); err != nil {
+			kvstore.RecordError("Error saving the slot map:", err)
+		}
+	}
+	kvstore.Logf(kvstore.LogInfo, "Slots %d-%d set %s %s", first, last, state, addr)
+	response.Values = c.ranges()
+	response.Success = true
+	return response
+}
+
+// migrate runs ADMIN MIGRATE: it moves a batch of the keys of the slots in
+// request.Key that are migrating to the server they move to. Requests on
+// keys are held off meanwhile, as EXEC holds them off, so none lands on a
+// key between its copy and its deletion.
+func (s *Server) migrate(ctx context.Context, request protocol.Request) protocol.Response {
+	var response protocol.Response
+	c := s.cluster
+	if c == nil {
+		response.Message = protocol.MsgInvalidAction
+		return response
+	}
+	first, last, ok := parseSlots(request.Key)
+	if !ok {
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	c.mu.RLock()
+	target := c.migrating[first]
+	for slot := first; slot <= last; slot++ {
+		if c.migrating[slot] != target {
+			target = ""
+		}
+	}
+	c.mu.RUnlock()
+	if target == "" {
+		// not migrating, or to more than one server
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	limit := request.Limit
+	if limit <= 0 {
+		limit = DefaultMigrateBatch
+	}
+
+	s.multiMu.Lock()
+	defer s.multiMu.Unlock()
+	s.proxy.FlushWrites()
+	snapshot, more := s.kvs.SnapshotOf(func(key string) bool {
+		slot := protocol.Slot(key)
+		return slot >= first && slot <= last
+	}, limit)
+	if len(snapshot.Data) > 0 {
+		if err := s.importTo(ctx, target, snapshot); err != nil {
+			kvstore.RecordError("Error migrating keys to "+target+":", err)
+			response.Message = err.Error()
+			return response
+		}
+		deleted := s.writeOps(request.Owner, func() []journalOp {
+			var ops []journalOp
+			for key := range snapshot.Data {
+				if _, ok := s.proxy.DELETE(key); ok {
+					ops = append(ops, journalOp{protocol.ActionDelete, key, ""})
+				}
+			}
+			return ops
+		})
+		if !deleted {
+			// turned read-only; the keys stay here, and the copies there
+			// are written over by the next try
+			response.Message = protocol.MsgReadOnly
+			return response
+		}
+	}
+	response.Value = strconv.Itoa(len(snapshot.Data))
+	response.More = more
+	response.Success = true
+	return response
+}
+
+// importTo writes snapshot to the server at addr with ADMIN IMPORT
+func (s *Server) importTo(ctx context.Context, addr string, snapshot kvstore.BackupSnapshot) error {
+	body, err := json.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	var opts []kvsclient.Option
+	if token := s.adminPlane.Token; token != "" {
+		opts = append(opts, kvsclient.WithToken(token))
+	}
+	client := kvsclient.NewClient(addr, opts...)
+	defer client.Close()
+	response, err := client.Do(ctx, protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminImport, Key: string(body)})
+	if err == nil && !response.Success {
+		err = fmt.Errorf("%s answered %s", addr, response.Message)
+	}
+	return err
+}
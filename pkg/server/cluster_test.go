@@ -0,0 +1,99 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// freeAddr returns a local address no one was listening on a moment ago,
+// for servers that must know each other's addresses before they start
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	return ln.Addr().String()
+}
+
+// newTestCluster starts n servers sharing the slots and returns them
+func newTestCluster(t *testing.T, n int) []*Server {
+	t.Helper()
+	var addrs []string
+	for i := 0; i < n; i++ {
+		addrs = append(addrs, freeAddr(t))
+	}
+	var servers []*Server
+	for _, addr := range addrs {
+		s := NewServerWithStore(kvstore.NewKeyValueStore(), addr)
+		s.SetPersister(kvstore.NoPersistence{})
+		s.SetFiles(Files{})
+		if err := s.SetCluster(addr, addrs); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Start(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(s.Stop)
+		servers = append(servers, s)
+	}
+	return servers
+}
+
+// keyOwnedBy returns a key whose slot s owns
+func keyOwnedBy(t *testing.T, s *Server) string {
+	t.Helper()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if s.cluster.ownerOf(protocol.Slot(key)) == s.cluster.self {
+			return key
+		}
+	}
+	t.Fatal("no key found for the server")
+	return ""
+}
+
+func TestClusterClientRoutesByTheSlotMap(t *testing.T) {
+	servers := newTestCluster(t, 2)
+	a, b := servers[0], servers[1]
+	cc := kvsclient.NewClusterClient([]string{a.cluster.self})
+	defer cc.Close()
+	ctx := context.Background()
+	keyA, keyB := keyOwnedBy(t, a), keyOwnedBy(t, b)
+	for _, key := range []string{keyA, keyB} {
+		if err := cc.Set(ctx, key, "v"); err != nil {
+			t.Fatalf("Set %s: %v", key, err)
+		}
+	}
+	if _, found := a.kvs.GET(keyA); !found {
+		t.Errorf("%s not on its owner", keyA)
+	}
+	if _, found := b.kvs.GET(keyB); !found {
+		t.Errorf("%s not on its owner", keyB)
+	}
+
+	// keyB's slot moves to a behind the client's back
+	slot := protocol.Slot(keyB)
+	for _, s := range servers {
+		s.cluster.mu.Lock()
+		s.cluster.owner[slot] = a.cluster.self
+		s.cluster.mu.Unlock()
+	}
+	a.kvs.SET(keyB, "moved")
+	if value, err := cc.Get(ctx, keyB); err != nil || value != "moved" {
+		t.Fatalf("Get after the slot moved = %q, %v", value, err)
+	}
+	if err := cc.Set(ctx, keyB, "again"); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := a.kvs.GET(keyB); value != "again" {
+		t.Errorf("Set after the slot moved left %q on its new owner", value)
+	}
+}
@@ -0,0 +1,133 @@
+package server
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// CoalesceRule merges the journal entries of SETs to keys matching Pattern,
+// in path.Match syntax, that come within Window of the first one.
+type CoalesceRule struct {
+	Pattern string
+	Window  time.Duration
+}
+
+// ParseCoalesceRules parses "pattern=window" pairs separated by commas,
+// e.g. "metrics/*=100ms,telemetry/*=50ms"
+func ParseCoalesceRules(s string) ([]CoalesceRule, error) {
+	var rules []CoalesceRule
+	for _, pair := range strings.Split(s, ",") {
+		if pair == "" {
+			continue
+		}
+		pattern, window, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("coalesce rule %q is not pattern=window", pair)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil {
+			return nil, fmt.Errorf("coalesce rule %q: %v", pair, err)
+		}
+		rules = append(rules, CoalesceRule{Pattern: pattern, Window: d})
+	}
+	return rules, nil
+}
+
+// pendingSet is the journal entry of a coalesced SET, waiting for its
+// window to end
+type pendingSet struct {
+	value    string
+	identity string
+}
+
+// SetCoalescing makes SETs to keys matching one of rules, the first that
+// matches, reach the journal, and so its followers, at most once per
+// window with the last value written: high-frequency keys such as
+// telemetry then cost one journal write per window instead of one per
+// SET. The store itself always has the latest value at once. Any other
+// write to such a key journals the pending SET first, so each key's
+// entries keep their order, though they may land after later writes to
+// other keys. A crash loses at most the last window of pending entries;
+// Stop journals them.
+func (s *Server) SetCoalescing(rules []CoalesceRule) error {
+	for _, rule := range rules {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return err
+		}
+		if rule.Window <= 0 {
+			return fmt.Errorf("coalesce window for %q must be positive", rule.Pattern)
+		}
+	}
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	s.coalesce = append([]CoalesceRule(nil), rules...)
+	return nil
+}
+
+// coalesceWindow is the window for SETs to key, zero if they aren't
+// coalesced; caller holds writeMu
+func (s *Server) coalesceWindow(key string) time.Duration {
+	for _, rule := range s.coalesce {
+		if ok, _ := path.Match(rule.Pattern, key); ok {
+			return rule.Window
+		}
+	}
+	return 0
+}
+
+// journalWrite journals a write that was applied, or holds a SET back to
+// coalesce it; caller holds writeMu
+func (s *Server) journalWrite(op, key, value, identity string) {
+	if p := s.pending[key]; p != nil {
+		if op == protocol.ActionSet {
+			p.value, p.identity = value, identity
+			s.coalesced.Add(1)
+			return
+		}
+		s.flushPending(key)
+	}
+	if op == protocol.ActionSet {
+		if window := s.coalesceWindow(key); window > 0 {
+			p := &pendingSet{value: value, identity: identity}
+			s.pending[key] = p
+			time.AfterFunc(window, func() {
+				s.writeMu.Lock()
+				defer s.writeMu.Unlock()
+				// a later write may have flushed it already
+				if s.pending[key] == p {
+					s.flushPending(key)
+				}
+			})
+			return
+		}
+	}
+	s.appendJournal(op, key, value, identity)
+}
+
+// flushPending journals the coalesced SET of key; caller holds writeMu
+func (s *Server) flushPending(key string) {
+	p := s.pending[key]
+	delete(s.pending, key)
+	s.appendJournal(protocol.ActionSet, key, p.value, p.identity)
+}
+
+// flushAllPending journals every coalesced SET, before the journal is
+// synced at shutdown
+func (s *Server) flushAllPending() {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	for key := range s.pending {
+		s.flushPending(key)
+	}
+}
+
+func (s *Server) appendJournal(op, key, value, identity string) {
+	if _, err := s.journal.Append(op, key, value, identity); err != nil {
+		kvstore.RecordError("Error appending to journal:", err)
+	}
+}
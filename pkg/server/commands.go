@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Command is a custom action added with RegisterCommand.
+type Command struct {
+	// Run handles one request for the action. It is called from many
+	// connections at once and should return when ctx is done.
+	Run func(ctx context.Context, store *Store, request protocol.Request) protocol.Response
+	// Keyed commands act on request.Key, so in a cluster they are
+	// redirected to the server owning the key like GET and SET.
+	Keyed bool
+	// Write commands are refused in read-only mode without running. In
+	// that mode the Store's writes fail in any command.
+	Write bool
+	// Admin commands are operational: with an admin listener they are
+	// refused with protocol.MsgAdminOnly elsewhere, and with an admin
+	// token they need it, like ADMIN and DIAGNOSE.
+	Admin bool
+	// Summary, Args and Messages describe the command to COMMANDS: what
+	// it does, the Request fields it reads and the Response.Message
+	// values it may return.
+	Summary  string
+	Args     []protocol.ArgSpec
+	Messages []string
+}
+
+var (
+	commandsMu sync.RWMutex
+	commands   = make(map[string]Command)
+)
+
+// RegisterCommand makes every Server answer action with cmd, so
+// domain-specific operations can live in their own packages. Call it from
+// an init function: a package imported for its side effects, e.g. by a
+// file with a build tag in a copy of cmd/kvs-server, or a Go plugin loaded
+// with kvs-server -plugin. Like sql.Register it panics if the action is
+// taken or cmd has no Run.
+func RegisterCommand(action string, cmd Command) {
+	commandsMu.Lock()
+	defer commandsMu.Unlock()
+	if action == "" || action != strings.ToUpper(action) {
+		panic(fmt.Sprintf("server: command %q must be a non-empty upper case action", action))
+	}
+	if cmd.Run == nil {
+		panic(fmt.Sprintf("server: command %q has no Run", action))
+	}
+	if builtinActions[action] {
+		panic(fmt.Sprintf("server: %s is a built-in action", action))
+	}
+	if _, dup := commands[action]; dup {
+		panic(fmt.Sprintf("server: command %s registered twice", action))
+	}
+	commands[action] = cmd
+}
+
+// Commands lists the registered custom actions
+func Commands() []string {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// command returns the custom command registered for action
+func command(action string) (Command, bool) {
+	commandsMu.RLock()
+	defer commandsMu.RUnlock()
+	cmd, ok := commands[action]
+	return cmd, ok
+}
+
+// Store is a custom command's handle on the server's data. Its writes go
+// through the cache and are journaled as the built-in SET, UPDATE and DELETE
+// they amount to, attributed to the client that sent the request. A Get
+// followed by a Set is not atomic against other clients.
+type Store struct {
+	s        *Server
+	identity string
+}
+
+// Get returns the value of key, see kvstore.ServerProxy.GET
+func (st *Store) Get(key string) (value string, found bool) {
+	return st.s.proxy.GET(key)
+}
+
+// Set sets key, expiring after ttl or the store's default TTL if ttl is
+// zero. Like Update and Delete it returns false in read-only mode.
+func (st *Store) Set(key, value string, ttl time.Duration) bool {
+	return st.s.write(protocol.ActionSet, key, value, st.identity, func() bool {
+		return st.s.proxy.SETEX(key, value, ttl)
+	})
+}
+
+// Update replaces the value of an existing key
+func (st *Store) Update(key, value string) bool {
+	return st.s.write(protocol.ActionUpdate, key, value, st.identity, func() bool {
+		_, ok := st.s.proxy.UPDATE(key, value)
+		return ok
+	})
+}
+
+// Delete removes key
+func (st *Store) Delete(key string) bool {
+	return st.s.write(protocol.ActionDelete, key, "", st.identity, func() bool {
+		_, ok := st.s.proxy.DELETE(key)
+		return ok
+	})
+}
+
+// KeyValueStore is the store itself, for what the methods above don't
+// cover. Writes made here bypass the cache and the journal.
+func (st *Store) KeyValueStore() *kvstore.KeyValueStore {
+	return st.s.kvs
+}
+
+// runCommand answers request with a registered custom command; a panic in
+// the command fails the request instead of the server
+func (s *Server) runCommand(ctx context.Context, cmd Command, identity string, request protocol.Request) (response protocol.Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("panic: %v", r)
+			if info, ok := RequestFromContext(ctx); ok && info.TraceID != "" {
+				err = fmt.Errorf("%w (trace %s)", err, info.TraceID)
+			}
+			kvstore.RecordError("Error in command "+request.Action+":", err)
+			response = protocol.Response{Message: protocol.MsgServerError}
+		}
+	}()
+	return cmd.Run(ctx, &Store{s: s, identity: identity}, request)
+}
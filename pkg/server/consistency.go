@@ -0,0 +1,133 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultQuorumTimeout is how long a write with Consistency QUORUM or ALL
+// waits for the replicas unless its Timeout says otherwise
+const DefaultQuorumTimeout = time.Second
+
+// consistencyLevels are the levels a Request may ask for
+var consistencyLevels = map[string]bool{
+	"":                         true,
+	protocol.ConsistencyOne:    true,
+	protocol.ConsistencyQuorum: true,
+	protocol.ConsistencyAll:    true,
+}
+
+// SetReplicas sets how many replicas the writes with Consistency QUORUM or
+// ALL count: QUORUM needs a majority of them and the primary, ALL every
+// one. With 0, the default, they are the rest of the failover group, or
+// else the replicas that have connected.
+func (s *Server) SetReplicas(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.replicaCount = max(n, 0)
+}
+
+// Replicas returns what SetReplicas set
+func (s *Server) Replicas() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.replicaCount
+}
+
+// awaitConsistency holds back the response to a write with Consistency
+// QUORUM or ALL until enough replicas have it, answering NOT_ENOUGH_REPLICAS
+// if there are too few replicas up to have it and NO_QUORUM if they don't
+// in time
+func (s *Server) awaitConsistency(ctx context.Context, request protocol.Request, response protocol.Response) protocol.Response {
+	if request.Consistency == "" || request.Consistency == protocol.ConsistencyOne {
+		return response
+	}
+	seq, _ := s.kvs.ReplicationPosition()
+	want := func(replicas int) int {
+		if request.Consistency == protocol.ConsistencyQuorum {
+			// a majority of the replicas and the primary, less the primary
+			return (replicas + 1) / 2
+		}
+		return replicas
+	}
+	if _, replicas, _, _ := s.acks(seq); replicas == 0 {
+		// no replica to have it, which neither level can do without
+		response.Message, response.Success = protocol.MsgNotEnoughReplicas, false
+		return response
+	}
+	if _, ok := s.awaitReplicas(ctx, seq, want, request.Timeout); !ok {
+		response.Message, response.Success = protocol.MsgNoQuorum, false
+		if _, replicas, up, _ := s.acks(seq); up < want(replicas) {
+			response.Message = protocol.MsgNotEnoughReplicas
+		}
+	}
+	return response
+}
+
+// waitReplicas runs WAIT: it waits for Limit replicas to have every write
+// so far, the caller's last included, and returns how many do
+func (s *Server) waitReplicas(ctx context.Context, request protocol.Request) protocol.Response {
+	seq, _ := s.kvs.ReplicationPosition()
+	have, _ := s.awaitReplicas(ctx, seq, func(int) int { return request.Limit }, request.Timeout)
+	return protocol.Response{Value: strconv.Itoa(have), Success: true}
+}
+
+// awaitReplicas waits up to timeout, DefaultQuorumTimeout if it is zero,
+// for want of the replicas to have the writes up to seq, and returns how
+// many have them and whether that is enough
+func (s *Server) awaitReplicas(ctx context.Context, seq uint64, want func(replicas int) int, timeout time.Duration) (int, bool) {
+	if timeout <= 0 {
+		timeout = DefaultQuorumTimeout
+	}
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		have, replicas, _, wake := s.acks(seq)
+		if have >= want(replicas) {
+			return have, true
+		}
+		select {
+		case <-wake:
+		case <-timer.C:
+			return have, false
+		case <-ctx.Done():
+			return have, false
+		}
+	}
+}
+
+// acks counts the replicas that have the writes up to seq, all the
+// replicas and those up, and returns a channel closed at the next PSYNC.
+// The replicas are those of SetReplicas, or else the rest of the failover
+// group, or else those seen within ReplicaKnownFor, up or not.
+func (s *Server) acks(seq uint64) (have, replicas, up int, wake <-chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, seen := range s.replicas {
+		since := time.Since(seen.at)
+		if since > ReplicaKnownFor {
+			continue
+		}
+		replicas++
+		if since > ReplicaSeenFor {
+			continue
+		}
+		up++
+		if seen.seq >= seq {
+			have++
+		}
+	}
+	if f := s.failover; f != nil {
+		replicas = len(f.Members) - 1
+	}
+	if s.replicaCount > 0 {
+		replicas = s.replicaCount
+	}
+	if s.acked == nil {
+		s.acked = make(chan struct{})
+	}
+	return have, replicas, up, s.acked
+}
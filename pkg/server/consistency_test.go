@@ -0,0 +1,68 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// awaitLevel runs awaitConsistency for a successful write at level and
+// returns its message, "" if it still succeeded
+func awaitLevel(s *Server, level string) string {
+	request := protocol.Request{Action: protocol.ActionSet, Key: "k", Value: "v", Consistency: level, Timeout: 10 * time.Millisecond}
+	response := s.awaitConsistency(context.Background(), request, protocol.Response{Success: true})
+	if response.Success {
+		return ""
+	}
+	return response.Message
+}
+
+func TestConsistencyWithoutReplicas(t *testing.T) {
+	s := NewServer()
+	if message := awaitLevel(s, protocol.ConsistencyOne); message != "" {
+		t.Errorf("ONE = %s, want success", message)
+	}
+	for _, level := range []string{protocol.ConsistencyQuorum, protocol.ConsistencyAll} {
+		if message := awaitLevel(s, level); message != protocol.MsgNotEnoughReplicas {
+			t.Errorf("%s with no replica = %q, want %s", level, message, protocol.MsgNotEnoughReplicas)
+		}
+	}
+}
+
+func TestConsistencyCountsReplicasThatAreDown(t *testing.T) {
+	s := NewServer()
+	s.replicas["a"] = seenReplica{at: time.Now()}
+	if message := awaitLevel(s, protocol.ConsistencyAll); message != "" {
+		t.Fatalf("ALL with its one replica up = %s, want success", message)
+	}
+	// b is down but known, so ALL can't be met and QUORUM, 1 of 2, can
+	s.replicas["b"] = seenReplica{at: time.Now().Add(-2 * ReplicaSeenFor)}
+	if message := awaitLevel(s, protocol.ConsistencyAll); message != protocol.MsgNotEnoughReplicas {
+		t.Errorf("ALL with a replica down = %q, want %s", message, protocol.MsgNotEnoughReplicas)
+	}
+	if message := awaitLevel(s, protocol.ConsistencyQuorum); message != "" {
+		t.Errorf("QUORUM with one of two replicas up = %s, want success", message)
+	}
+	// a replica gone that long is forgotten
+	s.replicas["b"] = seenReplica{at: time.Now().Add(-2 * ReplicaKnownFor)}
+	if message := awaitLevel(s, protocol.ConsistencyAll); message != "" {
+		t.Errorf("ALL after the replica down was forgotten = %s, want success", message)
+	}
+}
+
+func TestConsistencyCountsSetReplicas(t *testing.T) {
+	s := NewServer()
+	s.SetReplicas(3)
+	s.replicas["a"] = seenReplica{at: time.Now()}
+	for _, level := range []string{protocol.ConsistencyQuorum, protocol.ConsistencyAll} {
+		if message := awaitLevel(s, level); message != protocol.MsgNotEnoughReplicas {
+			t.Errorf("%s with one of 3 replicas up = %q, want %s", level, message, protocol.MsgNotEnoughReplicas)
+		}
+	}
+	s.replicas["b"] = seenReplica{at: time.Now()}
+	if message := awaitLevel(s, protocol.ConsistencyQuorum); message != "" {
+		t.Errorf("QUORUM with two of 3 replicas up = %s, want success", message)
+	}
+}
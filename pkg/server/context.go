@@ -0,0 +1,43 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// RequestInfo describes the request a context was made for. Every request
+// runs with such a context, which is done when the server shuts down, the
+// client hangs up or the request's Budget runs out.
+type RequestInfo struct {
+	Action  string
+	Client  string // the client's network address
+	Admin   bool   // arrived on an admin listener
+	TraceID string // protocol.Request.TraceID
+	Start   time.Time
+}
+
+type requestKey struct{}
+
+// RequestFromContext returns the request ctx was made for; ok is false
+// outside of one, e.g. in a background job
+func RequestFromContext(ctx context.Context) (info RequestInfo, ok bool) {
+	info, ok = ctx.Value(requestKey{}).(RequestInfo)
+	return info, ok
+}
+
+// requestContext derives the context of request from its connection's
+func requestContext(conn context.Context, client string, request protocol.Request, admin bool) (context.Context, context.CancelFunc) {
+	ctx := context.WithValue(conn, requestKey{}, RequestInfo{
+		Action:  request.Action,
+		Client:  client,
+		Admin:   admin,
+		TraceID: request.TraceID,
+		Start:   time.Now(),
+	})
+	if request.Budget > 0 {
+		return context.WithTimeout(ctx, request.Budget)
+	}
+	return context.WithCancel(ctx)
+}
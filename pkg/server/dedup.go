@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultDedupWindow is how long the server remembers the response to a
+// request with a RequestID, see SetDedupWindow
+const DefaultDedupWindow = 2 * time.Minute
+
+// maxDedupEntries bounds the responses remembered; past it the oldest are
+// forgotten before their window ends
+const maxDedupEntries = 100000
+
+// dedup remembers the responses to requests with a RequestID, so a resend
+// gets the response the first one had instead of running again
+type dedup struct {
+	mu      sync.Mutex
+	window  time.Duration
+	entries map[string]*dedupEntry
+	order   []string // the ids in entries, oldest first
+	replays atomic.Uint64
+}
+
+// dedupEntry is a request with a RequestID, running until done is closed
+type dedupEntry struct {
+	action, key string // what the id was first sent with
+	started     time.Time
+	done        chan struct{}
+	response    protocol.Response // set before done is closed
+	forgotten   bool              // not remembered, a resend runs again
+}
+
+// SetDedupWindow sets how long the response to a request with a RequestID
+// is remembered, DefaultDedupWindow to begin with; 0 turns remembering off,
+// and requests run however often they are sent.
+func (s *Server) SetDedupWindow(d time.Duration) {
+	s.dedup.mu.Lock()
+	defer s.dedup.mu.Unlock()
+	s.dedup.window = max(d, 0)
+	if d <= 0 {
+		s.dedup.entries, s.dedup.order = nil, nil
+	}
+}
+
+// do runs request with run, unless one with its RequestID was run in the
+// window: then it waits for that one, if it is still running, and returns
+// its response. A failure that invites a resend, such as READONLY or
+// TRY_AGAIN, is not remembered, so the resend runs.
+func (d *dedup) do(ctx context.Context, request protocol.Request, run func() protocol.Response) protocol.Response {
+	id := request.RequestID
+	for {
+		d.mu.Lock()
+		if d.window == 0 {
+			d.mu.Unlock()
+			return run()
+		}
+		now := time.Now()
+		d.prune(now)
+		e := d.entries[id]
+		if e == nil {
+			e = &dedupEntry{action: request.Action, key: request.Key, started: now, done: make(chan struct{})}
+			if d.entries == nil {
+				d.entries = make(map[string]*dedupEntry)
+			}
+			d.entries[id] = e
+			d.order = append(d.order, id)
+			d.mu.Unlock()
+			return d.run(id, e, run)
+		}
+		d.mu.Unlock()
+		if e.action != request.Action || e.key != request.Key {
+			return protocol.Response{Message: protocol.MsgBadRequest, Value: (&RequestError{"RequestID", "already used for another request"}).Error()}
+		}
+		select {
+		case <-e.done:
+		case <-ctx.Done():
+			return protocol.Response{Message: protocol.MsgCanceled}
+		}
+		if !e.forgotten {
+			d.replays.Add(1)
+			return e.response
+		}
+	}
+}
+
+// run runs the request with e's id and keeps its response as the one to
+// the id, or forgets the id if the request may be sent again. The entry is
+// released however run ends, a panic included, so no resend waits forever.
+func (d *dedup) run(id string, e *dedupEntry, run func() protocol.Response) (response protocol.Response) {
+	remember := false
+	defer func() {
+		e.response = response
+		if !remember {
+			d.mu.Lock()
+			e.forgotten = true
+			if d.entries[id] == e {
+				delete(d.entries, id)
+			}
+			d.mu.Unlock()
+		}
+		close(e.done)
+	}()
+	response = run()
+	_, retry := retryHints[response.Message]
+	remember = !retry && response.Message != protocol.MsgBadRequest && response.Message != protocol.MsgCanceled
+	return response
+}
+
+// prune forgets the requests that started longer than the window ago, and
+// the oldest past maxDedupEntries; caller must hold d.mu
+func (d *dedup) prune(now time.Time) {
+	n := 0
+	for ; n < len(d.order); n++ {
+		e := d.entries[d.order[n]]
+		if e == nil {
+			continue
+		}
+		if now.Sub(e.started) < d.window && len(d.order)-n <= maxDedupEntries {
+			break
+		}
+		delete(d.entries, d.order[n])
+	}
+	if n > 0 {
+		// a fresh slice now and then, so the ids dropped can be freed
+		if n > len(d.order)/2 {
+			d.order = append([]string(nil), d.order[n:]...)
+		} else {
+			d.order = d.order[n:]
+		}
+	}
+}
+
+// stats returns how many responses are remembered and how many were
+// replayed
+func (d *dedup) stats() (remembered int, replays uint64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.entries), d.replays.Load()
+}
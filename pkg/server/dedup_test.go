@@ -0,0 +1,49 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestDedupReplaysResponse(t *testing.T) {
+	d := dedup{window: time.Minute}
+	request := protocol.Request{Action: protocol.ActionIncr, Key: "n", RequestID: "id"}
+	runs := 0
+	run := func() protocol.Response {
+		runs++
+		return protocol.Response{Success: true, Value: "1"}
+	}
+	for i := 0; i < 2; i++ {
+		if response := d.do(context.Background(), request, run); response.Value != "1" {
+			t.Fatalf("send %d: got %+v", i, response)
+		}
+	}
+	if runs != 1 {
+		t.Fatalf("ran %d times, want 1", runs)
+	}
+}
+
+func TestDedupReleasesEntryOnPanic(t *testing.T) {
+	d := dedup{window: time.Minute}
+	request := protocol.Request{Action: protocol.ActionIncr, Key: "n", RequestID: "id"}
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("panic did not propagate")
+			}
+		}()
+		d.do(context.Background(), request, func() protocol.Response { panic("boom") })
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	response := d.do(ctx, request, func() protocol.Response {
+		return protocol.Response{Success: true, Value: "1"}
+	})
+	if !response.Success || response.Value != "1" {
+		t.Fatalf("resend after panic: got %+v, want it to run again", response)
+	}
+}
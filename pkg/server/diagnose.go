@@ -0,0 +1,180 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"cmp"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"runtime/pprof"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// DiagnoseArchiveFmt is the time layout of the file name suggested for a bundle
+const DiagnoseArchiveFmt = "diagnose-20060102-150405.tar.gz"
+
+// diagnoseHotKeys is how many of the hottest keys a bundle lists
+const diagnoseHotKeys = 100
+
+// SetFlags records the flags the server was started with, as set on fs,
+// for Diagnose; a flag named like "admin-token" holds a secret, so only
+// whether it is set is recorded
+func (s *Server) SetFlags(fs *flag.FlagSet) {
+	var lines []string
+	fs.VisitAll(func(f *flag.Flag) {
+		value := f.Value.String()
+		if strings.HasSuffix(f.Name, "token") {
+			value = onOff(value != "")
+		}
+		lines = append(lines, f.Name+": "+value)
+	})
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flags = lines
+}
+
+// Flags returns the flags of SetFlags as "name: value" lines, sorted by name
+func (s *Server) Flags() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.flags
+}
+
+// Diagnose bundles info, config, the flags of SetFlags, recent errors, the
+// slow log, the hottest keys and a goroutine dump into one tar.gz
+func (s *Server) Diagnose() ([]byte, error) {
+	var info bytes.Buffer
+	for _, line := range s.stats() {
+		fmt.Fprintln(&info, line)
+	}
+	for _, ns := range s.kvs.NamespaceStats() {
+		fmt.Fprintln(&info, "namespace:", ns)
+	}
+	for _, m := range s.kvs.EstimateMemory(0) {
+		fmt.Fprintln(&info, "memory:", m)
+	}
+
+	var config bytes.Buffer
+	fmt.Fprintf(&config, "listen_addrs: %s\n", strings.Join(s.addrs, ","))
+	fmt.Fprintf(&config, "admin_addrs: %s\n", strings.Join(s.adminPlane.Addrs, ","))
+	fmt.Fprintf(&config, "admin_token: %s\n", onOff(s.adminPlane.Token != ""))
+	fmt.Fprintf(&config, "engine: %s\n", s.kvs.Engine())
+	fmt.Fprintf(&config, "default_ttl: %s\n", s.kvs.TTL())
+	fmt.Fprintf(&config, "update_ttl: %s\n", s.kvs.UpdateTTLMode())
+	fmt.Fprintf(&config, "clear_interval: %s\n", kvstore.ClearInterval)
+	fmt.Fprintf(&config, "max_request_size: %d\n", s.maxRequestSize())
+	fmt.Fprintf(&config, "max_key_len: %d\n", s.MaxKeyLen())
+	fmt.Fprintf(&config, "slowlog_threshold: %s\n", time.Duration(s.slowLog.threshold.Load()))
+	fmt.Fprintf(&config, "key_hits: %d\n", s.keyHits.size.Load())
+	fmt.Fprintf(&config, "replica_of: %s\n", cmp.Or(s.primary(), "none"))
+	backupFile, backupInterval := s.kvs.Backup()
+	fmt.Fprintf(&config, "backup_interval: %s\n", backupInterval)
+	fmt.Fprintf(&config, "backup_file: %s\n", backupFile)
+	fmt.Fprintf(&config, "journal_file: %s\n", s.files.Journal)
+	fmt.Fprintf(&config, "pubsub_file: %s\n", s.files.PubSub)
+	fmt.Fprintf(&config, "persistence: %s\n", s.Persister())
+	wal := s.kvs.WALStats()
+	fmt.Fprintf(&config, "wal_file: %s\n", cmp.Or(wal.Path, "none"))
+	fmt.Fprintf(&config, "wal_fsync: %s\n", wal.Sync)
+	walPercent, walMin := s.kvs.WALRewrite()
+	fmt.Fprintf(&config, "wal_rewrite_percent: %d\n", walPercent)
+	fmt.Fprintf(&config, "wal_rewrite_min: %d\n", walMin)
+	fmt.Fprintf(&config, "encryption: %s\n", onOff(s.kvs.Encrypted()))
+	fmt.Fprintf(&config, "file_key: %s\n", cmp.Or(s.kvs.FileCipher().KeyID(), "none"))
+	fmt.Fprintf(&config, "compression_threshold: %d\n", s.kvs.Compression().Threshold)
+	fmt.Fprintf(&config, "replication_backlog: %d\n", s.kvs.ReplicationBacklog())
+	fmt.Fprintf(&config, "replica_poll: %s\n", s.replicaPoll())
+	fmt.Fprintf(&config, "replicas: %d\n", s.Replicas())
+	if f := s.failover; f != nil {
+		fmt.Fprintf(&config, "failover_group: %s\n", strings.Join(f.Members, ","))
+		fmt.Fprintf(&config, "failover_self: %s\n", f.Self)
+		fmt.Fprintf(&config, "failover_timeout: %s\n", f.Timeout)
+		fmt.Fprintf(&config, "failover_state: %s\n", f.StateFile)
+	}
+	if m := s.multi; m != nil {
+		fmt.Fprintf(&config, "multi_primary: %s\n", strings.Join(m.Peers, ","))
+		fmt.Fprintf(&config, "multi_primary_self: %s\n", m.Self)
+	}
+	memory := s.kvs.MemoryUsage()
+	fmt.Fprintf(&config, "maxmemory: %d\n", memory.Max)
+	fmt.Fprintf(&config, "maxmemory_policy: %s\n", memory.Policy)
+	fmt.Fprintf(&config, "cache_size: %d\n", s.proxy.CacheSize())
+	fmt.Fprintf(&config, "cache_bytes: %d\n", s.proxy.CacheMaxBytes())
+	fmt.Fprintf(&config, "cache_ttl: %s\n", s.proxy.CacheTTL())
+	fmt.Fprintf(&config, "cache_preload: %d\n", s.preload.Recent)
+	fmt.Fprintf(&config, "cache_preload_prefixes: %s\n", strings.Join(s.preload.Prefixes, ","))
+	fmt.Fprintf(&config, "cache_policy: %s\n", s.proxy.EvictionPolicy())
+	strategy, writeBack := s.proxy.CacheStrategy()
+	fmt.Fprintf(&config, "cache_strategy: %s\n", strategy)
+	fmt.Fprintf(&config, "cache_write_back_delay: %s\n", writeBack.Delay)
+	fmt.Fprintf(&config, "cache_write_back_max: %d\n", writeBack.MaxPending)
+	fmt.Fprintf(&config, "min_free_disk: %d\n", s.disk.MinFree)
+	fmt.Fprintf(&config, "disk_policy: %s\n", s.disk.Policy)
+	fmt.Fprintf(&config, "log_level: %s\n", kvstore.CurrentLogLevel())
+	fmt.Fprintf(&config, "pin_patterns: %s\n", strings.Join(s.kvs.PinPatterns(), ","))
+	fmt.Fprintf(&config, "pinned_keys: %d\n", len(s.kvs.PinnedKeys()))
+	fmt.Fprintf(&config, "cluster: %s\n", strings.Join(s.clusterInfo(), ", "))
+
+	var flags bytes.Buffer
+	for _, line := range s.Flags() {
+		fmt.Fprintln(&flags, line)
+	}
+
+	var errs bytes.Buffer
+	for _, line := range kvstore.RecentErrors() {
+		fmt.Fprintln(&errs, line)
+	}
+
+	var slow bytes.Buffer
+	for _, line := range s.slowLog.entries() {
+		fmt.Fprintln(&slow, line)
+	}
+
+	var hot bytes.Buffer
+	for _, h := range s.keyHits.top(diagnoseHotKeys) {
+		fmt.Fprintln(&hot, h)
+	}
+
+	var goroutines bytes.Buffer
+	if err := pprof.Lookup("goroutine").WriteTo(&goroutines, 2); err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		body []byte
+	}{
+		{"info.txt", info.Bytes()},
+		{"config.txt", config.Bytes()},
+		{"flags.txt", flags.Bytes()},
+		{"errors.txt", errs.Bytes()},
+		{"slowlog.txt", slow.Bytes()},
+		{"hotkeys.txt", hot.Bytes()},
+		{"goroutines.txt", goroutines.Bytes()},
+	}
+
+	var out bytes.Buffer
+	gz := gzip.NewWriter(&out)
+	tw := tar.NewWriter(gz)
+	now := time.Now()
+	for _, f := range files {
+		hdr := &tar.Header{Name: f.name, Mode: 0644, Size: int64(len(f.body)), ModTime: now}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(f.body); err != nil {
+			return nil, err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
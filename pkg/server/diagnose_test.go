@@ -0,0 +1,60 @@
+package server
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"flag"
+	"io"
+	"strings"
+	"testing"
+)
+
+// untar returns the files of a Diagnose bundle by name
+func untar(t *testing.T, archive []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(body)
+	}
+}
+
+func TestDiagnoseConfig(t *testing.T) {
+	s := NewServer()
+	fs := flag.NewFlagSet("kvs-server", flag.ContinueOnError)
+	fs.String("wal-fsync", "1s", "")
+	fs.String("admin-token", "", "")
+	if err := fs.Parse([]string{"-wal-fsync", "always", "-admin-token", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	s.SetFlags(fs)
+	archive, err := s.Diagnose()
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := untar(t, archive)
+	for _, want := range []string{"persistence: ", "wal_file: none\n", "wal_fsync: ", "encryption: off\n", "file_key: none\n", "replication_backlog: ", "replica_of: none\n"} {
+		if !strings.Contains(files["config.txt"], want) {
+			t.Errorf("config.txt lacks %q:\n%s", want, files["config.txt"])
+		}
+	}
+	if flags := files["flags.txt"]; flags != "admin-token: on\nwal-fsync: always\n" {
+		t.Errorf("flags.txt = %q", flags)
+	}
+}
@@ -0,0 +1,145 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// DiskPolicy is what the server does, besides pausing snapshots, while
+// free disk space is below DiskGuard.MinFree
+type DiskPolicy int
+
+const (
+	// DiskPauseSnapshots only stops writing snapshots
+	DiskPauseSnapshots DiskPolicy = iota
+	// DiskReadOnly also turns read-only mode on; it stays on once space
+	// is back, until an operator lifts it
+	DiskReadOnly
+	// DiskRejectWrites also refuses writes with protocol.MsgDiskFull until
+	// space is back
+	DiskRejectWrites
+)
+
+var diskPolicyNames = []string{"snapshots", "read-only", "reject"}
+
+func (p DiskPolicy) String() string {
+	if p < DiskPauseSnapshots || p > DiskRejectWrites {
+		return fmt.Sprintf("DiskPolicy(%d)", int(p))
+	}
+	return diskPolicyNames[p]
+}
+
+// ParseDiskPolicy parses a policy name as printed by String
+func ParseDiskPolicy(name string) (DiskPolicy, error) {
+	for i, n := range diskPolicyNames {
+		if n == name {
+			return DiskPolicy(i), nil
+		}
+	}
+	return DiskPauseSnapshots, fmt.Errorf("unknown disk policy %q, expected snapshots, read-only or reject", name)
+}
+
+// DefaultDiskCheckInterval is how often free disk space is checked unless
+// DiskGuard.Interval says otherwise
+const DefaultDiskCheckInterval = 5 * time.Second
+
+// DiskGuard watches the free space of the volumes holding the backup
+// file, the journal and the pub/sub log. Below MinFree bytes on any of
+// them it applies Policy and records an error, so it shows up in the log,
+// in DIAGNOSE and in ADMIN STATS; a zero MinFree turns it off.
+type DiskGuard struct {
+	MinFree  uint64
+	Policy   DiskPolicy
+	Interval time.Duration
+}
+
+// SetDiskGuard sets the free disk space guard; call it before Start
+func (s *Server) SetDiskGuard(g DiskGuard) {
+	if g.Interval <= 0 {
+		g.Interval = DefaultDiskCheckInterval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.disk = g
+}
+
+// watchDisk checks free disk space every interval until ctx is done
+func (s *Server) watchDisk(ctx context.Context, g DiskGuard) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	for {
+		s.checkDisk(g)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// diskDirs are the directories the server writes files to
+func (s *Server) diskDirs() []string {
+	backup, _ := s.kvs.Backup()
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, file := range []string{backup, s.files.Journal, s.files.PubSub} {
+		if file == "" {
+			continue
+		}
+		if dir := filepath.Dir(file); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// checkDisk measures free space and applies g.Policy when it crosses
+// g.MinFree, either way
+func (s *Server) checkDisk(g DiskGuard) {
+	low, lowDir := false, ""
+	var least uint64
+	for i, dir := range s.diskDirs() {
+		free, err := freeSpace(dir)
+		if err != nil {
+			if s.diskErr.CompareAndSwap(false, true) {
+				kvstore.RecordError("Error checking free disk space:", err)
+			}
+			return
+		}
+		if i == 0 || free < least {
+			least = free
+		}
+		if free < g.MinFree && !low {
+			low, lowDir = true, dir
+		}
+	}
+	s.diskErr.Store(false)
+	s.diskFree.Store(least)
+	if s.diskLow.Swap(low) == low {
+		return
+	}
+	if low {
+		s.kvs.PauseBackups(true)
+		if g.Policy == DiskReadOnly {
+			s.SetReadOnly(true)
+		}
+		kvstore.RecordError("Low disk space:", fmt.Errorf("%d bytes free in %s, below %d; snapshots paused, policy %s", least, lowDir, g.MinFree, g.Policy))
+		return
+	}
+	s.kvs.PauseBackups(false)
+	note := ""
+	if g.Policy == DiskReadOnly && s.ReadOnly() {
+		note = ", read-only mode stays on until lifted"
+	}
+	kvstore.Logf(kvstore.LogWarn, "Disk space back to %d bytes free, snapshots resumed%s", least, note)
+}
+
+// rejectWrites reports whether writes are refused for lack of disk space
+func (s *Server) rejectWrites() bool {
+	return s.diskLow.Load() && s.disk.Policy == DiskRejectWrites
+}
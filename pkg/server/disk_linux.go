@@ -0,0 +1,15 @@
+//go:build linux
+
+package server
+
+import "syscall"
+
+// freeSpace is how many bytes unprivileged users can still write to the
+// volume holding dir
+func freeSpace(dir string) (uint64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return st.Bavail * uint64(st.Bsize), nil
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package server
+
+import "errors"
+
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free disk space is only checked on linux")
+}
@@ -0,0 +1,43 @@
+package server
+
+import (
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// retryHints are the failures that may succeed if the request is sent
+// again, with how long to wait first; any other failure is final
+var retryHints = map[string]time.Duration{
+	protocol.MsgMoved:       0,
+	protocol.MsgAsk:         0,
+	protocol.MsgTryAgain:    100 * time.Millisecond, // once the slot has moved
+	protocol.MsgLockTimeout: 0,
+	protocol.MsgServerError: 100 * time.Millisecond,
+	protocol.MsgIntegrity:   0, // damaged on the way, a resend may arrive intact
+	protocol.MsgReadOnly:    time.Second,
+	protocol.MsgDiskFull:    DefaultDiskCheckInterval,
+	protocol.MsgOutOfMemory: kvstore.ClearInterval, // expiring keys free memory
+	protocol.MsgDegraded:    DefaultSLOCheckInterval,
+	protocol.MsgStale:       0, // at the primary, or once the replica catches up
+	protocol.MsgNoQuorum:    DefaultQuorumTimeout,
+	// once a replica connects again
+	protocol.MsgNotEnoughReplicas: ReplicaPoll,
+}
+
+// withErrorInfo fills in response.Error if the request failed with a
+// message, so clients need not interpret the message themselves
+func withErrorInfo(response protocol.Response) protocol.Response {
+	if response.Success || response.Message == "" || response.Error != nil {
+		return response
+	}
+	backoff, retryable := retryHints[response.Message]
+	info := &protocol.ErrorInfo{Code: response.Message, Retryable: retryable, Backoff: backoff}
+	switch response.Message {
+	case protocol.MsgMoved, protocol.MsgAsk, protocol.MsgReadOnly, protocol.MsgStale:
+		info.Leader = response.Value
+	}
+	response.Error = info
+	return response
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// eval runs the script of an EVAL, see protocol.ActionEval, holding off
+// every other request on keys as EXEC does
+func (s *Server) eval(ctx context.Context, client string, request protocol.Request, admin bool) protocol.Response {
+	var response protocol.Response
+	script, err := parseScript(request.Value)
+	if err != nil {
+		response.Message, response.Value = protocol.MsgScriptError, err.Error()
+		return response
+	}
+	run := &scriptRun{
+		ctx:   ctx,
+		vars:  map[string]scriptValue{"KEYS": scriptList(request.Keys), "ARGV": scriptList(request.Values)},
+		steps: protocol.MaxScriptSteps,
+		bytes: protocol.MaxScriptBytes,
+		call: func(args []scriptValue) (scriptValue, error) {
+			return s.scriptCall(ctx, client, request, admin, args)
+		},
+	}
+	ret, err := func() ([]scriptValue, error) {
+		s.multiMu.Lock()
+		defer s.multiMu.Unlock()
+		return run.run(script)
+	}()
+	walErr := s.commitWAL()
+	switch {
+	case walErr != nil:
+		response.Message = protocol.MsgServerError
+		return response
+	case ctx.Err() != nil:
+		response.Message = protocol.MsgCanceled
+		return response
+	case errors.Is(err, errScriptSteps):
+		response.Message, response.Value = protocol.MsgScriptError, fmt.Sprintf("%v: over %d steps", err, protocol.MaxScriptSteps)
+		return response
+	case errors.Is(err, errScriptBytes):
+		response.Message, response.Value = protocol.MsgScriptError, fmt.Sprintf("%v: over %d bytes", err, protocol.MaxScriptBytes)
+		return response
+	case err != nil:
+		response.Message, response.Value = protocol.MsgScriptError, err.Error()
+		return response
+	}
+	switch len(ret) {
+	case 0:
+	case 1:
+		response.Found = truthy(ret[0])
+		if response.Found {
+			response.Value = scriptString(ret[0])
+		}
+	default:
+		response.Found = true
+		for _, v := range ret {
+			response.Values = append(response.Values, scriptString(v))
+		}
+	}
+	response.Success = true
+	return response
+}
+
+// scriptCall runs call(action, key, value, more...) for a script that
+// EVAL request runs: the more go in Values. It returns the response's
+// Value, nil for a key not found, and fails the script if the request
+// fails. Caller must hold multiMu.
+func (s *Server) scriptCall(ctx context.Context, client string, eval protocol.Request, admin bool, args []scriptValue) (scriptValue, error) {
+	if len(args) < 2 {
+		return nil, scriptErrorf(0, "call wants an action and a key")
+	}
+	action, ok := args[0].(string)
+	if !ok || !queueable[action] {
+		return nil, scriptErrorf(0, "call cannot run %s", scriptString(args[0]))
+	}
+	request := protocol.Request{Action: action, Key: scriptString(args[1]), Owner: eval.Owner, Token: eval.Token, LowPriority: eval.LowPriority}
+	if len(args) > 2 {
+		request.Value = scriptString(args[2])
+	}
+	for _, v := range args[min(len(args), 3):] {
+		request.Values = append(request.Values, scriptString(v))
+	}
+	response := s.dispatch(ctx, client, request, admin)
+	switch {
+	case !response.Success:
+		return nil, scriptErrorf(0, "%s %s: %s", action, request.Key, response.Message)
+	case response.Message == protocol.MsgNotFound:
+		return nil, nil
+	case response.Found || response.Value != "":
+		return response.Value, nil
+	case len(response.Values) > 0:
+		return scriptList(response.Values), nil
+	case response.Message != "":
+		return response.Message, nil
+	}
+	return true, nil
+}
+
+func scriptList(values []string) []scriptValue {
+	list := make([]scriptValue, len(values))
+	for i, v := range values {
+		list[i] = v
+	}
+	return list
+}
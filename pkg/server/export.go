@@ -0,0 +1,110 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// export runs ADMIN EXPORT
+func (s *Server) export(format string) protocol.Response {
+	var response protocol.Response
+	format = strings.ToLower(format)
+	if format == "" {
+		format = kvstore.ExportJSON
+	}
+	if format != kvstore.ExportJSON && format != kvstore.ExportCSV {
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	// write-back and coalesced writes are in the store first
+	s.proxy.FlushWrites()
+	records, damaged := s.kvs.Export()
+	if len(damaged) > 0 {
+		kvstore.RecordError("Error exporting keys:", fmt.Errorf("%s: %d values fail their checksum, e.g. %q", protocol.MsgIntegrity, len(damaged), damaged[0]))
+	}
+	var buf bytes.Buffer
+	if err := kvstore.WriteExport(&buf, records, format); err != nil {
+		kvstore.RecordError("Error exporting keys:", err)
+		response.Message = protocol.MsgServerError
+		return response
+	}
+	response.Value = buf.String()
+	response.Values = []string{
+		fmt.Sprintf("keys: %d", len(records)),
+		fmt.Sprintf("damaged: %d", len(damaged)),
+	}
+	response.Success = true
+	return response
+}
+
+// importKeys runs ADMIN IMPORT, of a snapshot or an export, journaling
+// each key it writes
+func (s *Server) importKeys(request protocol.Request) protocol.Response {
+	var response protocol.Response
+	var keys []string
+	var load func() (kvstore.RestoreStats, error)
+	if len(request.Values) == 0 || request.Values[0] == "" {
+		var snapshot kvstore.BackupSnapshot
+		if err := json.Unmarshal([]byte(request.Key), &snapshot); err != nil {
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+		for key := range snapshot.Data {
+			keys = append(keys, key)
+		}
+		load = func() (kvstore.RestoreStats, error) { return s.kvs.MergeSnapshot(snapshot, true) }
+	} else {
+		records, err := kvstore.ReadExport(strings.NewReader(request.Key), strings.ToLower(request.Values[0]))
+		if err != nil {
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+		for _, r := range records {
+			keys = append(keys, r.Key)
+		}
+		load = func() (kvstore.RestoreStats, error) { return s.kvs.Import(records) }
+	}
+	var stats kvstore.RestoreStats
+	var err error
+	applied := false
+	s.writeOps(request.Owner, func() []journalOp {
+		applied = true
+		stats, err = load()
+		if err != nil {
+			return nil
+		}
+		// the read cache may hold what the keys were before
+		s.proxy.Flush()
+		var ops []journalOp
+		for _, key := range keys {
+			// expired and damaged entries were dropped
+			if item, ok := s.kvs.GETKV(key); ok {
+				ops = append(ops, journalOp{setOp(item), key, item.Value})
+			}
+		}
+		return ops
+	})
+	switch {
+	case !applied:
+		response.Message = protocol.MsgReadOnly
+		return response
+	case err != nil:
+		kvstore.RecordError("Error importing keys:", err)
+		response.Message = err.Error()
+		return response
+	}
+	kvstore.Logf(kvstore.LogInfo, "Imported %d keys, %d expired, %d damaged", stats.Loaded, stats.Expired, stats.Damaged)
+	response.Values = []string{
+		fmt.Sprintf("loaded: %d", stats.Loaded),
+		fmt.Sprintf("expired: %d", stats.Expired),
+		fmt.Sprintf("damaged: %d", stats.Damaged),
+	}
+	response.Message = protocol.MsgRestored
+	response.Success = true
+	return response
+}
@@ -0,0 +1,498 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// failover defaults
+const (
+	DefaultFailoverTimeout = 5 * time.Second
+	FailoverStateFile      = "failover.json"
+)
+
+// FailoverGroup makes a server one of Members, a primary and its replicas
+// that elect the primary among themselves. A replica that has not heard
+// from the primary for Timeout stands for election in the next epoch, and
+// becomes primary with the votes of a majority of Members; a replica
+// votes once per epoch, for a candidate whose store is at least as far
+// along as its own, and only while it can't reach a primary either. A
+// primary that has not heard from a majority for half of Timeout refuses
+// writes, so one cut off from the rest stops taking them before they can
+// have elected another, and steps down once it learns of a later epoch.
+// Replicas answer writes with READONLY naming the primary, which
+// kvsclient.FailoverClient follows.
+//
+// Self is this server's address as listed in Members, which are the
+// addresses the servers reach each other and clients reach them on. The
+// epoch and this server's vote in it are kept in StateFile, so a restart
+// can't vote twice.
+type FailoverGroup struct {
+	Self      string
+	Members   []string
+	Timeout   time.Duration
+	StateFile string
+}
+
+// failover is this server's view of its failover group
+type failover struct {
+	FailoverGroup
+
+	mu       sync.Mutex
+	epoch    uint64
+	votedFor string    // in epoch
+	primary  string    // of epoch as far as known, Self if this server
+	fenced   bool      // this server is primary but its lease ran out, as last noticed
+	heard    time.Time // from the primary, or on the primary the lease's start
+	deadline time.Time // of the next election
+}
+
+// failoverState is what StateFile holds
+type failoverState struct {
+	Epoch    uint64
+	VotedFor string
+}
+
+// SetFailoverGroup makes the server one of g.Members, loading the epoch
+// from g.StateFile. Call it before Start, instead of ReplicaOf; the
+// server takes writes once it is elected or finds the primary.
+func (s *Server) SetFailoverGroup(g FailoverGroup) error {
+	found := false
+	for _, member := range g.Members {
+		found = found || member == g.Self
+	}
+	if !found {
+		return errors.New("failover group members do not include this server")
+	}
+	if len(g.Members) < 2 {
+		return errors.New("a failover group needs at least two members")
+	}
+	if g.Timeout <= 0 {
+		g.Timeout = DefaultFailoverTimeout
+	}
+	if g.StateFile == "" {
+		g.StateFile = FailoverStateFile
+	}
+	f := &failover{FailoverGroup: g}
+	data, err := os.ReadFile(g.StateFile)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+	case err != nil:
+		return err
+	default:
+		var state failoverState
+		if err := json.Unmarshal(data, &state); err != nil {
+			return fmt.Errorf("%s: %w", g.StateFile, err)
+		}
+		f.epoch, f.votedFor = state.Epoch, state.VotedFor
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failover = f
+	return nil
+}
+
+// save writes the epoch and vote to StateFile; caller holds f.mu
+func (f *failover) save() error {
+	data, err := json.Marshal(failoverState{Epoch: f.epoch, VotedFor: f.votedFor})
+	if err != nil {
+		return err
+	}
+	return kvstore.WriteFileAtomic(f.StateFile, data, 0o600)
+}
+
+// majority is how many members, this one included, make a majority
+func (f *failover) majority() int {
+	return len(f.Members)/2 + 1
+}
+
+// leading reports whether this server is the primary and takes writes,
+// a majority having followed it within half of Timeout; caller holds f.mu
+func (f *failover) leading() bool {
+	return f.primary == f.Self && time.Since(f.heard) <= f.Timeout/2
+}
+
+// postpone moves the next election between one and two Timeouts away, at
+// random so candidates rarely stand at once; caller holds f.mu
+func (f *failover) postpone() {
+	f.deadline = time.Now().Add(f.Timeout + time.Duration(rand.Int63n(int64(f.Timeout)+1)))
+}
+
+// adopt moves to the later epoch, with no vote and no primary known yet;
+// caller holds f.mu
+func (f *failover) adopt(epoch uint64) {
+	if f.primary == f.Self {
+		kvstore.Logf(kvstore.LogWarn, "Failover epoch %d started, stepping down as primary", epoch)
+	}
+	f.epoch, f.votedFor, f.primary = epoch, "", ""
+	if err := f.save(); err != nil {
+		kvstore.RecordError("Error saving failover state:", err)
+	}
+}
+
+// electing reports whether the server is in a failover group and can't
+// take writes, being a replica, a candidate or a primary cut off
+func (s *Server) electing() bool {
+	f := s.failover
+	if f == nil {
+		return false
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return !f.leading()
+}
+
+// failoverPrimary is the primary of the group as far as this server
+// knows, empty if it doesn't or is the primary itself
+func (s *Server) failoverPrimary() string {
+	f := s.failover
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.primary == f.Self {
+		return ""
+	}
+	return f.primary
+}
+
+// withEpoch adds this server's address and epoch to a request to the
+// primary, if it is in a failover group, or its address to one to a peer
+func (s *Server) withEpoch(request protocol.Request) protocol.Request {
+	if m := s.multi; m != nil {
+		request.Owner = m.Self
+	}
+	if f := s.failover; f != nil {
+		f.mu.Lock()
+		request.Owner, request.Epoch = f.Self, f.epoch
+		f.mu.Unlock()
+	}
+	return request
+}
+
+// heardPrimary records an answer from the primary in epoch, and reports
+// whether it is still the primary: false if a later epoch has begun
+func (s *Server) heardPrimary(epoch uint64) bool {
+	f := s.failover
+	if f == nil {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if epoch < f.epoch {
+		return false
+	}
+	if epoch > f.epoch {
+		f.adopt(epoch)
+	}
+	f.heard = time.Now()
+	f.postpone()
+	return true
+}
+
+// servesReplicas checks the epoch of a replica's SYNC or PSYNC, stepping
+// down if it is later, and reports whether this server is the primary to
+// answer it, which it is outside a failover group
+func (s *Server) servesReplicas(request protocol.Request) (epoch uint64, ok bool) {
+	f := s.failover
+	if f == nil {
+		return 0, true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if request.Epoch > f.epoch {
+		f.adopt(request.Epoch)
+	}
+	return f.epoch, f.primary == f.Self
+}
+
+// vote runs VOTE
+func (s *Server) vote(request protocol.Request) (response protocol.Response) {
+	f := s.failover
+	if f == nil {
+		response.Message = protocol.MsgInvalidAction
+		return response
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	defer func() { response.Epoch = f.epoch }()
+	switch {
+	case request.Epoch < f.epoch:
+		return response
+	case f.leading(),
+		f.primary != "" && f.primary != f.Self && time.Since(f.heard) < f.Timeout:
+		// the primary is fine as far as this server can tell
+		return response
+	}
+	if request.Revision < s.kvs.Revision() {
+		return response
+	}
+	if request.Key == protocol.VotePre {
+		response.Success = request.Epoch > f.epoch || f.votedFor == "" || f.votedFor == request.Value
+		return response
+	}
+	if request.Epoch > f.epoch {
+		f.adopt(request.Epoch)
+	}
+	if f.votedFor != "" && f.votedFor != request.Value {
+		return response
+	}
+	f.votedFor = request.Value
+	if err := f.save(); err != nil {
+		kvstore.RecordError("Error saving failover state:", err)
+		f.votedFor = ""
+		return response
+	}
+	f.postpone()
+	kvstore.Logf(kvstore.LogInfo, "Voted for %s in failover epoch %d", request.Value, f.epoch)
+	response.Success = true
+	return response
+}
+
+// roleOf runs ROLE
+func (s *Server) roleOf() protocol.Response {
+	response := protocol.Response{Values: s.replicationInfo(), Success: true}
+	if f := s.failover; f != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		response.Value, response.Found, response.Epoch = f.primary, f.leading(), f.epoch
+		return response
+	}
+	response.Value = s.primary()
+	response.Found = !s.replicating()
+	return response
+}
+
+// watchFailover keeps the server following the group's primary, or
+// elected, until ctx is done
+func (s *Server) watchFailover(ctx context.Context, f *failover) {
+	s.mu.Lock()
+	token := s.adminPlane.Token
+	s.mu.Unlock()
+	peers := make(map[string]*kvsclient.Client)
+	for _, member := range f.Members {
+		if member == f.Self {
+			continue
+		}
+		opts := []kvsclient.Option{kvsclient.WithTimeout(f.Timeout / 2), kvsclient.WithoutProbe()}
+		if token != "" {
+			opts = append(opts, kvsclient.WithToken(token))
+		}
+		peers[member] = kvsclient.NewClient(member, opts...)
+	}
+	defer func() {
+		for _, c := range peers {
+			c.Close()
+		}
+	}()
+	ticker := time.NewTicker(f.Timeout / 8)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		s.checkFailover(ctx, f, peers)
+	}
+}
+
+// checkFailover renews or gives up the primary's lease, or on a server
+// that can't reach the primary looks for another and stands for election
+// once its deadline has passed
+func (s *Server) checkFailover(ctx context.Context, f *failover, peers map[string]*kvsclient.Client) {
+	lease, ok := s.lease(f)
+	f.mu.Lock()
+	primary, epoch := f.primary, f.epoch
+	if primary == f.Self {
+		if ok && lease.After(f.heard) {
+			f.heard = lease
+		}
+		switch {
+		case f.fenced && f.leading():
+			kvstore.Logf(kvstore.LogInfo, "Failover group reachable again, taking writes")
+			f.fenced = false
+		case !f.fenced && !f.leading():
+			kvstore.Logf(kvstore.LogWarn, "Lost contact with a majority of the failover group, refusing writes")
+			f.fenced = true
+		}
+	}
+	healthy := f.leading() || primary != "" && primary != f.Self && time.Since(f.heard) < f.Timeout
+	due := time.Now().After(f.deadline)
+	f.mu.Unlock()
+	if healthy {
+		return
+	}
+	if leader, e, ok := findPrimary(ctx, peers); ok && e >= epoch {
+		s.followPrimary(f, leader, e)
+		return
+	}
+	if due && primary != f.Self {
+		s.standForElection(ctx, f, peers)
+	}
+}
+
+// lease returns the latest time a majority of the group, this server
+// included, is known to have followed it. That is when it answered the
+// PSYNC before each replica's latest, which the replica sent after, so a
+// PSYNC that sat in a queue while this server was cut off or paused does
+// not count as recent.
+func (s *Server) lease(f *failover) (time.Time, bool) {
+	s.mu.Lock()
+	var following []time.Time
+	for _, member := range f.Members {
+		if seen, ok := s.replicas[member]; ok && member != f.Self && !seen.following.IsZero() {
+			following = append(following, seen.following)
+		}
+	}
+	s.mu.Unlock()
+	need := f.majority() - 1
+	if len(following) < need {
+		return time.Time{}, false
+	}
+	sort.Slice(following, func(i, j int) bool { return following[i].After(following[j]) })
+	return following[need-1], true
+}
+
+// findPrimary asks the peers which of them is primary, returning the one
+// that says so in the latest epoch
+func findPrimary(ctx context.Context, peers map[string]*kvsclient.Client) (leader string, epoch uint64, ok bool) {
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for addr, c := range peers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			response, err := c.Do(ctx, protocol.Request{Action: protocol.ActionRole})
+			if err != nil || !response.Found || response.Value != addr {
+				return
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if !ok || response.Epoch > epoch {
+				leader, epoch, ok = addr, response.Epoch, true
+			}
+		}()
+	}
+	wg.Wait()
+	return leader, epoch, ok
+}
+
+// followPrimary makes the server a replica of leader, primary in epoch
+func (s *Server) followPrimary(f *failover, leader string, epoch uint64) {
+	f.mu.Lock()
+	if epoch > f.epoch {
+		f.adopt(epoch)
+	}
+	changed := f.primary != leader
+	f.primary, f.heard = leader, time.Now()
+	f.postpone()
+	f.mu.Unlock()
+	if changed {
+		kvstore.Logf(kvstore.LogInfo, "Following %s, primary in failover epoch %d", leader, epoch)
+		s.link(leader, false)
+	}
+}
+
+// standForElection asks the peers to make this server primary in the
+// next epoch, first asking whether they would, so a server that is cut
+// off from a primary the others still follow doesn't start epochs that
+// unseat it once it is back
+func (s *Server) standForElection(ctx context.Context, f *failover, peers map[string]*kvsclient.Client) {
+	f.mu.Lock()
+	epoch := f.epoch + 1
+	f.postpone()
+	f.mu.Unlock()
+	request := protocol.Request{Action: protocol.ActionVote, Key: protocol.VotePre, Value: f.Self, Revision: s.kvs.Revision(), Epoch: epoch}
+	if votes, _ := poll(ctx, peers, request, f.majority()); votes < f.majority() {
+		return
+	}
+
+	f.mu.Lock()
+	if f.epoch >= epoch || f.primary != "" && f.primary != f.Self && time.Since(f.heard) < f.Timeout {
+		// found a primary meanwhile
+		f.mu.Unlock()
+		return
+	}
+	f.epoch = epoch
+	f.votedFor, f.primary = f.Self, ""
+	err := f.save()
+	f.mu.Unlock()
+	if err != nil {
+		kvstore.RecordError("Error saving failover state:", err)
+		return
+	}
+	kvstore.Logf(kvstore.LogInfo, "Standing for election in failover epoch %d", epoch)
+	request.Key = ""
+	votes, later := poll(ctx, peers, request, f.majority())
+
+	f.mu.Lock()
+	if later > f.epoch {
+		f.adopt(later)
+	}
+	won := f.epoch == epoch && f.primary == "" && votes >= f.majority()
+	if won {
+		f.primary, f.fenced, f.heard = f.Self, false, time.Now()
+	}
+	f.mu.Unlock()
+	if !won {
+		kvstore.Logf(kvstore.LogInfo, "Lost the election in failover epoch %d with %d of %d votes", epoch, votes, len(f.Members))
+		return
+	}
+	kvstore.Logf(kvstore.LogInfo, "Elected primary in failover epoch %d with %d of %d votes", epoch, votes, len(f.Members))
+	s.link("", false)
+}
+
+// poll sends a VOTE to every peer, returning the votes with this
+// server's own, as soon as they make a majority of the group, and the
+// latest epoch any peer that answered is in
+func poll(ctx context.Context, peers map[string]*kvsclient.Client, request protocol.Request, majority int) (votes int, later uint64) {
+	answers := make(chan protocol.Response, len(peers))
+	for _, c := range peers {
+		go func() {
+			response, err := c.Do(ctx, request)
+			if err != nil {
+				response = protocol.Response{}
+			}
+			answers <- response
+		}()
+	}
+	votes = 1
+	for range peers {
+		response := <-answers
+		if response.Success {
+			votes++
+		}
+		later = max(later, response.Epoch)
+		if votes >= majority {
+			break
+		}
+	}
+	return votes, later
+}
+
+// failoverInfo describes the failover group, one "name: value" line each,
+// none outside a group
+func (s *Server) failoverInfo() []string {
+	f := s.failover
+	if f == nil {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []string{
+		fmt.Sprintf("failover_epoch: %d", f.epoch),
+		fmt.Sprintf("failover_primary: %s", f.primary),
+		fmt.Sprintf("failover_voted_for: %s", f.votedFor),
+		fmt.Sprintf("fenced: %s", onOff(f.primary == f.Self && !f.leading())),
+	}
+}
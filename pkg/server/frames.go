@@ -0,0 +1,157 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"runtime/debug"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultMaxRequestSize is the most bytes a request may take on the wire
+// unless SetMaxRequestSize says otherwise
+const DefaultMaxRequestSize = 64 << 20
+
+// errRequestTooLarge is what a frame reader returns for a request over
+// the size limit
+var errRequestTooLarge = errors.New("request too large")
+
+// SetMaxRequestSize bounds the bytes one request may take on the wire,
+// DefaultMaxRequestSize if n is zero; call it before Start. A larger
+// request is answered with REQUEST_TOO_LARGE, before the server reads or
+// allocates room for it, and its connection closed.
+func (s *Server) SetMaxRequestSize(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxRequest = n
+}
+
+// maxRequestSize returns the limit of SetMaxRequestSize
+func (s *Server) maxRequestSize() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.maxRequest <= 0 {
+		return DefaultMaxRequestSize
+	}
+	return s.maxRequest
+}
+
+// frameReader is what a connection's decoder reads from: it fails a
+// request over the size limit
+type frameReader interface {
+	io.Reader
+	// next is called before each request is decoded
+	next()
+}
+
+// newFrameReader returns the frame reader of conn for codec
+func newFrameReader(conn io.Reader, codec protocol.Codec, limit int64) frameReader {
+	if codec.Name() == protocol.Gob.Name() {
+		return &gobFrames{r: bufio.NewReader(conn), limit: limit}
+	}
+	return &countedFrames{r: conn, limit: limit}
+}
+
+// gobFrames passes a gob stream through a message at a time, refusing a
+// message longer than limit from its length prefix, before the decoder
+// allocates room for it
+type gobFrames struct {
+	r     *bufio.Reader
+	limit int64
+	left  int64 // bytes of the message being read still to pass
+}
+
+func (g *gobFrames) next() {}
+
+func (g *gobFrames) Read(p []byte) (int, error) {
+	if g.left == 0 {
+		// a gob message starts with its length as an unsigned integer: one
+		// byte below 0x80, or the negated count of the big-endian bytes
+		// that follow
+		b, err := g.r.Peek(1)
+		if err != nil {
+			return 0, err
+		}
+		head, n := 1, uint64(b[0])
+		if b[0] >= 0x80 {
+			size := int(-int8(b[0]))
+			if size > 8 {
+				return 0, errors.New("gob: malformed message length")
+			}
+			if b, err = g.r.Peek(1 + size); err != nil {
+				return 0, err
+			}
+			head, n = 1+size, 0
+			for _, c := range b[1:] {
+				n = n<<8 | uint64(c)
+			}
+		}
+		if n > uint64(g.limit) {
+			return 0, errRequestTooLarge
+		}
+		g.left = int64(head) + int64(n)
+	}
+	if int64(len(p)) > g.left {
+		p = p[:g.left]
+	}
+	n, err := g.r.Read(p)
+	g.left -= int64(n)
+	return n, err
+}
+
+// countedFrames fails once more than limit bytes were read for one
+// request. The decoder may have read a little of the next request with
+// it, so the limit is approximate.
+type countedFrames struct {
+	r     io.Reader
+	limit int64
+	read  int64
+}
+
+func (c *countedFrames) next() { c.read = 0 }
+
+func (c *countedFrames) Read(p []byte) (int, error) {
+	if c.read >= c.limit {
+		return 0, errRequestTooLarge
+	}
+	if int64(len(p)) > c.limit-c.read {
+		p = p[:c.limit-c.read]
+	}
+	n, err := c.r.Read(p)
+	c.read += int64(n)
+	return n, err
+}
+
+// badFrame is the response to a request the decoder failed on with err,
+// and whether the connection can go on: a JSON value of the wrong type
+// is read whole, so the next request starts where it ends, but after any
+// other failure the stream can't be trusted to be in step
+func badFrame(err error) (response protocol.Response, more bool) {
+	if errors.Is(err, errRequestTooLarge) {
+		return protocol.Response{Message: protocol.MsgTooLarge}, false
+	}
+	var typeErr *json.UnmarshalTypeError
+	return protocol.Response{Message: protocol.MsgBadRequest, Value: err.Error()}, errors.As(err, &typeErr)
+}
+
+// serveSafely runs handler on request; a panic fails the request with
+// SERVER_ERROR instead of taking the server down
+func serveSafely(handler Handler, ctx context.Context, request protocol.Request) (response protocol.Response) {
+	defer func() {
+		if r := recover(); r != nil {
+			err := fmt.Errorf("panic: %v", r)
+			if info, ok := RequestFromContext(ctx); ok && info.TraceID != "" {
+				err = fmt.Errorf("%w (trace %s)", err, info.TraceID)
+			}
+			kvstore.RecordError("Error handling "+request.Action+":", err)
+			kvstore.Logf(kvstore.LogError, "%s", debug.Stack())
+			response = protocol.Response{Message: protocol.MsgServerError}
+		}
+	}()
+	return handler(ctx, request)
+}
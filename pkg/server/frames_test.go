@@ -0,0 +1,114 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// encode returns requests as codec puts them on the wire
+func encode(t testing.TB, codec protocol.Codec, requests ...protocol.Request) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	encoder := codec.NewEncoder(&buf)
+	for _, request := range requests {
+		if err := encoder.Encode(request); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeFrames decodes the requests of data as a connection of codec
+// would, with requests limited to limit bytes, and returns them and the
+// response to the first that failed to decode, if any
+func decodeFrames(s *Server, data []byte, codec protocol.Codec, limit int64) (requests []protocol.Request, bad *protocol.Response) {
+	frames := newFrameReader(bytes.NewReader(data), codec, limit)
+	decoder := codec.NewDecoder(frames)
+	for i := 0; i < 16; i++ {
+		var request protocol.Request
+		frames.next()
+		err := decoder.Decode(&request)
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return requests, bad
+		}
+		if err != nil {
+			response, more := badFrame(err)
+			if bad == nil {
+				bad = &response
+			}
+			if !more {
+				return requests, bad
+			}
+			continue
+		}
+		s.validate(request)
+		requests = append(requests, request)
+	}
+	return requests, bad
+}
+
+func TestDecodeRequests(t *testing.T) {
+	for _, codec := range []protocol.Codec{protocol.Gob, protocol.JSON} {
+		data := encode(t, codec,
+			protocol.Request{Action: protocol.ActionSet, Key: "k", Value: "v"},
+			protocol.Request{Action: protocol.ActionGet, Key: "k"})
+		requests, bad := decodeFrames(&Server{}, data, codec, DefaultMaxRequestSize)
+		if bad != nil || len(requests) != 2 || requests[1].Action != protocol.ActionGet {
+			t.Errorf("%s: decoded %+v, bad %+v", codec.Name(), requests, bad)
+		}
+	}
+}
+
+func TestDecodeRefusesOversizedRequest(t *testing.T) {
+	for _, codec := range []protocol.Codec{protocol.Gob, protocol.JSON} {
+		data := encode(t, codec, protocol.Request{Action: protocol.ActionSet, Key: "k", Value: strings.Repeat("v", 4096)})
+		_, bad := decodeFrames(&Server{}, data, codec, 1024)
+		if bad == nil || bad.Message != protocol.MsgTooLarge {
+			t.Errorf("%s: got %+v, want %s", codec.Name(), bad, protocol.MsgTooLarge)
+		}
+	}
+}
+
+func TestDecodeAnswersMalformedRequest(t *testing.T) {
+	for _, codec := range []protocol.Codec{protocol.Gob, protocol.JSON} {
+		_, bad := decodeFrames(&Server{}, []byte("\x05{garbage"), codec, DefaultMaxRequestSize)
+		if bad == nil || bad.Message != protocol.MsgBadRequest {
+			t.Errorf("%s: got %+v, want %s", codec.Name(), bad, protocol.MsgBadRequest)
+		}
+	}
+	// a JSON value of the wrong type leaves the stream in step
+	requests, bad := decodeFrames(&Server{}, []byte(`{"Action":5}{"Action":"GET","Key":"k"}`), protocol.JSON, DefaultMaxRequestSize)
+	if bad == nil || len(requests) != 1 {
+		t.Errorf("got %+v, bad %+v; want the second request after BAD_REQUEST", requests, bad)
+	}
+}
+
+// FuzzDecodeRequest feeds arbitrary bytes to both codecs' request
+// decoding: a malformed or oversized request must be answered with
+// BAD_REQUEST or REQUEST_TOO_LARGE, never panic
+func FuzzDecodeRequest(f *testing.F) {
+	f.Add(encode(f, protocol.Gob, protocol.Request{Action: protocol.ActionGet, Key: "k"}))
+	f.Add(encode(f, protocol.Gob,
+		protocol.Request{Action: protocol.ActionMSet, Keys: []string{"a", "b"}, Values: []string{"1", "2"}, Checksums: []uint32{0, 0}},
+		protocol.Request{Action: protocol.ActionRename, Key: "a", Value: ""}))
+	f.Add(encode(f, protocol.JSON, protocol.Request{Action: protocol.ActionSet, Key: "k", Value: "v", TTL: 1}))
+	f.Add([]byte(`{"Action":5}{"Action":"GET","Key":"\u0000"}`))
+	f.Add([]byte{0xf8, 0x7f, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{0xfe, 0xff, 0xff, 0x00})
+	f.Add([]byte("\x05{garbage"))
+	f.Add([]byte{})
+	s := &Server{}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		for _, codec := range []protocol.Codec{protocol.Gob, protocol.JSON} {
+			_, bad := decodeFrames(s, data, codec, 1<<16)
+			if bad != nil && bad.Message != protocol.MsgBadRequest && bad.Message != protocol.MsgTooLarge {
+				t.Fatalf("%s: answered %s", codec.Name(), bad.Message)
+			}
+		}
+	})
+}
@@ -0,0 +1,72 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// frozen reports whether writes are refused by ADMIN FREEZE
+func (s *Server) frozen() bool {
+	return time.Now().UnixNano() < s.frozenUntil.Load()
+}
+
+// freeze runs ADMIN FREEZE with arg, a duration, "off" or nothing
+func (s *Server) freeze(arg string) protocol.Response {
+	var response protocol.Response
+	switch arg = strings.ToLower(arg); arg {
+	case "":
+	case "off":
+		s.frozenUntil.Store(0)
+		kvstore.Logf(kvstore.LogInfo, "Writes thawed")
+	default:
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+		s.frozenUntil.Store(time.Now().Add(d).UnixNano())
+		kvstore.Logf(kvstore.LogInfo, "Writes frozen for %s", d)
+	}
+	response.Value = "off"
+	if left := time.Until(time.Unix(0, s.frozenUntil.Load())); left > 0 {
+		response.Value = left.Round(time.Millisecond).String()
+	}
+	response.Success = true
+	return response
+}
+
+// dump runs ADMIN DUMP. Writes are held off while the keys are copied, so
+// the snapshot matches the journal revision it is reported with.
+func (s *Server) dump() protocol.Response {
+	var response protocol.Response
+	s.writeMu.Lock()
+	// coalesced SETs are in the store already; journal them so the
+	// revision covers them
+	for key := range s.pending {
+		s.flushPending(key)
+	}
+	snapshot, damaged := s.kvs.Snapshot()
+	revision := s.journal.Revision()
+	s.writeMu.Unlock()
+	if len(damaged) > 0 {
+		kvstore.RecordError("Error dumping keys:", fmt.Errorf("%s: %d values fail their checksum, e.g. %q", protocol.MsgIntegrity, len(damaged), damaged[0]))
+	}
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		kvstore.RecordError("Error dumping keys:", err)
+		response.Message = protocol.MsgServerError
+		return response
+	}
+	response.Value = string(data)
+	response.Values = []string{
+		fmt.Sprintf("revision: %d", revision),
+		fmt.Sprintf("keys: %d", len(snapshot.Data)),
+	}
+	response.Success = true
+	return response
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// SetHealthAddr makes the server answer HTTP health checks on addr, e.g.
+// ":8090", for load balancers and orchestrators; call it before Start.
+//
+// GET /healthz, the liveness check, answers 200 as long as the process
+// serves HTTP at all. GET /readyz, the readiness check, answers 200 only
+// once Start has restored the data and the listeners take requests, and
+// 503 before that and from the moment the server starts shutting down.
+func (s *Server) SetHealthAddr(addr string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.healthAddr = addr
+}
+
+// startHealth starts answering health checks, not yet ready; the caller
+// holds s.mu
+func (s *Server) startHealth() error {
+	if s.healthAddr == "" {
+		return nil
+	}
+	ln, err := net.Listen("tcp", s.healthAddr)
+	if err != nil {
+		return fmt.Errorf("health checks: %w", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+	mux.HandleFunc("GET /readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.ready.Load() {
+			http.Error(w, "not ready", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprintln(w, "ready")
+	})
+	s.health = &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	health := s.health
+	go func() {
+		if err := health.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			kvstore.RecordError("Error serving health checks:", err)
+		}
+	}()
+	kvstore.Logf(kvstore.LogInfo, "Health checks on http://%s/healthz and /readyz", ln.Addr())
+	return nil
+}
+
+// stopHealth stops answering health checks; the caller holds s.mu
+func (s *Server) stopHealth() {
+	if s.health != nil {
+		s.health.Close()
+	}
+}
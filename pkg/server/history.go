@@ -0,0 +1,150 @@
+package server
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// maxDiffCells bounds the work of a DIFF, the product of the line counts
+// of the two values; past it the values are shown as replaced whole
+const maxDiffCells = 4 << 20
+
+// readAt reads key for GET and MGET as it was at rev, see
+// kvstore.KeyValueStore.GETAT; a revision no longer kept reads as not
+// found, with the Value VERSION_GONE
+func (s *Server) readAt(key string, rev uint64) (kvstore.KeyValue, bool) {
+	// the writes the read cache buffers are in the history too
+	s.proxy.FlushWrites()
+	item, found, err := s.kvs.GETAT(key, rev)
+	if err != nil {
+		return kvstore.KeyValue{Value: protocol.MsgVersionGone}, false
+	}
+	return item, found
+}
+
+// history answers HISTORY
+func (s *Server) history(request protocol.Request) protocol.Response {
+	s.proxy.FlushWrites()
+	var response protocol.Response
+	for _, v := range s.kvs.Versions(request.Key) {
+		if request.Limit > 0 && len(response.Journal) >= request.Limit {
+			break
+		}
+		if v.Removed {
+			response.Journal = append(response.Journal, protocol.JournalEntry{Revision: v.Until, Time: v.Replaced, Op: protocol.ActionDelete, Key: request.Key})
+			if request.Limit > 0 && len(response.Journal) >= request.Limit {
+				break
+			}
+		}
+		value := v.Value
+		if !v.Intact() {
+			value = protocol.MsgIntegrity
+		}
+		response.Journal = append(response.Journal, protocol.JournalEntry{Revision: v.Revision, Time: v.Timestamp, Op: setOp(v.KeyValue), Key: request.Key, Value: value})
+	}
+	response.Found = len(response.Journal) > 0
+	response.Success = true
+	return response
+}
+
+// diff answers DIFF
+func (s *Server) diff(request protocol.Request) protocol.Response {
+	var response protocol.Response
+	to := uint64(0)
+	if request.Value != "" {
+		var err error
+		if to, err = strconv.ParseUint(request.Value, 10, 64); err != nil || to == 0 {
+			response.Message = protocol.MsgInvalidArgument
+			return response
+		}
+	}
+	if request.Revision == 0 {
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	var values [2]string
+	for i, rev := range []uint64{request.Revision, to} {
+		var item kvstore.KeyValue
+		var found bool
+		if rev == 0 {
+			s.proxy.FlushWrites()
+			item, found = s.kvs.GETKV(request.Key)
+		} else {
+			item, found = s.readAt(request.Key, rev)
+		}
+		switch {
+		case found:
+			values[i] = item.Value
+		case item.Value == protocol.MsgVersionGone, item.Value == protocol.MsgIntegrity:
+			response.Message = item.Value
+			return response
+		}
+	}
+	response.Values = diffLines(lines(values[0]), lines(values[1]))
+	response.Found = values[0] != values[1]
+	response.Success = true
+	return response
+}
+
+// lines splits a value into lines, none for an empty one
+func lines(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(value, "\n"), "\n")
+}
+
+// diffLines compares a and b by their longest common subsequence of lines:
+// the lines only a has prefixed "-", those only b has "+" and the rest " "
+func diffLines(a, b []string) []string {
+	var out []string
+	if len(a)*len(b) > maxDiffCells {
+		for _, line := range a {
+			out = append(out, "-"+line)
+		}
+		for _, line := range b {
+			out = append(out, "+"+line)
+		}
+		return out
+	}
+	// common[i][j] is the length of the longest common subsequence of
+	// a[i:] and b[j:]
+	common := make([][]int, len(a)+1)
+	for i := range common {
+		common[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				common[i][j] = common[i+1][j+1] + 1
+			} else {
+				common[i][j] = max(common[i+1][j], common[i][j+1])
+			}
+		}
+	}
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case common[i+1][j] >= common[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
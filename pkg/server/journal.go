@@ -0,0 +1,62 @@
+package server
+
+import (
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// write runs a mutation and, if it changed anything, journals it. Holding
+// writeMu across both keeps the journal in the exact order writes were
+// applied, which is what makes it replayable. In read-only mode, while
+// frozen or while writes are refused for lack of disk space, nothing runs.
+func (s *Server) write(op, key, value, identity string, apply func() bool) bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.readOnly.Load() || s.frozen() || s.replicating() || s.rejectWrites() || !apply() {
+		return false
+	}
+	s.journalWrite(op, key, value, identity)
+	return true
+}
+
+// setOp is the journal op that writes item whole: HSET for a hash, RPUSH
+// for a list, SADD for a set, ZADD for a sorted set, XADD for a stream,
+// JSON.SET for a JSON document, else SET
+func setOp(item kvstore.KeyValue) string {
+	switch item.Type {
+	case kvstore.TypeHash:
+		return protocol.ActionHSet
+	case kvstore.TypeList:
+		return protocol.ActionRPush
+	case kvstore.TypeSet:
+		return protocol.ActionSAdd
+	case kvstore.TypeZSet:
+		return protocol.ActionZAdd
+	case kvstore.TypeStream:
+		return protocol.ActionXAdd
+	case kvstore.TypeJSON:
+		return protocol.ActionJSONSet
+	}
+	return protocol.ActionSet
+}
+
+// journalOp is one journal entry of a write
+type journalOp struct {
+	op, key, value string
+}
+
+// writeOps is write for mutations journaled as several entries, such as
+// RENAME as a DELETE and a SET, which apply returns; none means nothing
+// changed
+func (s *Server) writeOps(identity string, apply func() []journalOp) bool {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	if s.readOnly.Load() || s.frozen() || s.replicating() || s.rejectWrites() {
+		return false
+	}
+	ops := apply()
+	for _, o := range ops {
+		s.journalWrite(o.op, o.key, o.value, identity)
+	}
+	return len(ops) > 0
+}
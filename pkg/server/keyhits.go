@@ -0,0 +1,101 @@
+package server
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultKeyHits is how many keys the server counts the requests of, as
+// kvs-server sets it up; a Server counts none until SetKeyHits
+const DefaultKeyHits = 1024
+
+// hotKey is a key and how often requests named it, see keyHits
+type hotKey struct {
+	key  string
+	hits uint64
+}
+
+func (h hotKey) String() string {
+	return fmt.Sprintf("hot key=%q hits=%d", h.key, h.hits)
+}
+
+// keyHits counts the requests naming each key, for as many keys as its
+// size. Once it counts more, every count is halved, and the keys left at
+// zero dropped, until it counts half as many: the keys requested often
+// stay, ahead of the rest, and old hits weigh less than new ones.
+type keyHits struct {
+	size   atomic.Int64 // 0 is off
+	mu     sync.Mutex   // guards counts
+	counts map[string]uint64
+}
+
+// SetKeyHits makes the server count the requests naming each key, for up
+// to n keys at a time, so ADMIN KEYSPACE can list the hottest; n <= 0
+// turns counting off and forgets the counts.
+func (s *Server) SetKeyHits(n int) {
+	h := &s.keyHits
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.size.Store(int64(max(n, 0)))
+	if n <= 0 {
+		h.counts = nil
+	}
+}
+
+// count notes the keys request names, if it is an action on keys
+func (h *keyHits) count(request protocol.Request) {
+	size := int(h.size.Load())
+	if size == 0 {
+		return
+	}
+	keys := request.Keys
+	switch request.Action {
+	case protocol.ActionMGet, protocol.ActionMSet:
+	default:
+		if !keyActions[request.Action] {
+			return
+		}
+		keys = []string{request.Key}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.counts == nil {
+		h.counts = make(map[string]uint64)
+	}
+	for _, key := range keys {
+		h.counts[key]++
+	}
+	if len(h.counts) <= size {
+		return
+	}
+	for len(h.counts) > size/2 {
+		for key, n := range h.counts {
+			if n /= 2; n == 0 {
+				delete(h.counts, key)
+			} else {
+				h.counts[key] = n
+			}
+		}
+	}
+}
+
+// top returns up to n of the keys with the most hits, most first
+func (h *keyHits) top(n int) []hotKey {
+	h.mu.Lock()
+	hot := make([]hotKey, 0, len(h.counts))
+	for key, hits := range h.counts {
+		hot = append(hot, hotKey{key, hits})
+	}
+	h.mu.Unlock()
+	sort.Slice(hot, func(i, j int) bool {
+		if hot[i].hits != hot[j].hits {
+			return hot[i].hits > hot[j].hits
+		}
+		return hot[i].key < hot[j].key
+	})
+	return hot[:min(n, len(hot))]
+}
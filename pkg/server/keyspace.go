@@ -0,0 +1,47 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// keyspaceTop is how many prefixes and hot keys ADMIN KEYSPACE lists
+const keyspaceTop = 10
+
+// keyspace answers ADMIN KEYSPACE from a sample of up to samples keys;
+// each count is given for the sample and, as keys, scaled up to the store
+func (s *Server) keyspace(samples int) []string {
+	stats := s.kvs.KeyspaceStats(samples)
+	lines := []string{fmt.Sprintf("keys=%d sampled=%d", stats.Keys, stats.Sampled)}
+	for i, n := range stats.ValueSizes {
+		le := "+inf"
+		if i < len(kvstore.ValueSizeBuckets) {
+			le = strconv.FormatInt(kvstore.ValueSizeBuckets[i], 10)
+		}
+		lines = append(lines, fmt.Sprintf("value_size le=%s sampled=%d keys=%d", le, n, stats.Estimate(n)))
+	}
+	for i, n := range stats.TTLs {
+		le := "+inf"
+		if i < len(kvstore.TTLBuckets) {
+			le = kvstore.TTLBuckets[i].String()
+		}
+		lines = append(lines, fmt.Sprintf("ttl le=%s sampled=%d keys=%d", le, n, stats.Estimate(n)))
+	}
+	other := 0
+	for i, p := range stats.Prefixes {
+		if i >= keyspaceTop {
+			other += p.Keys
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("prefix %q sampled=%d keys=%d", p.Prefix, p.Keys, stats.Estimate(p.Keys)))
+	}
+	if other > 0 {
+		lines = append(lines, fmt.Sprintf("prefix (other) sampled=%d keys=%d", other, stats.Estimate(other)))
+	}
+	for _, h := range s.keyHits.top(keyspaceTop) {
+		lines = append(lines, h.String())
+	}
+	return lines
+}
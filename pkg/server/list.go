@@ -0,0 +1,78 @@
+package server
+
+import (
+	"context"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultPopWait is how long BLPOP waits for an item unless it asks
+// otherwise
+const DefaultPopWait = 5 * time.Second
+
+// MaxPopWait bounds how long BLPOP waits, since it holds its connection
+// meanwhile
+const MaxPopWait = 30 * time.Second
+
+// pop runs LPOP, or RPOP unless head, into response: one item in Value, or
+// with a Limit up to that many in Values. It is journaled as an RPUSH of
+// the rest of the list, which replays the same, or a DELETE of its last
+// item.
+func (s *Server) pop(request protocol.Request, identity string, head bool, response *protocol.Response) {
+	pop := s.proxy.RPOP
+	if head {
+		pop = s.proxy.LPOP
+	}
+	s.writeOps(identity, func() []journalOp {
+		items, item, deleted, msg, ok := pop(request.Key, request.Limit)
+		response.Message, response.Success = msg, ok
+		if request.Limit > 0 {
+			response.Values = items
+		} else if len(items) > 0 {
+			response.Value = items[0]
+		}
+		response.Found = len(items) > 0
+		switch {
+		case !ok || len(items) == 0:
+			return nil
+		case deleted:
+			return []journalOp{{protocol.ActionDelete, request.Key, ""}}
+		}
+		return []journalOp{{setOp(item), request.Key, item.Value}}
+	})
+	if !response.Success && response.Message == "" {
+		// turned read-only since the check in handle
+		response.Message = protocol.MsgReadOnly
+	}
+}
+
+// blockingPop is pop from the head that, while the list is empty, waits
+// for a push for up to request.Timeout, DefaultPopWait if zero, or until
+// ctx is done; then it answers with nothing found. Each try holds off
+// transactions as queueable requests do, but not the wait.
+func (s *Server) blockingPop(ctx context.Context, request protocol.Request, identity string, response *protocol.Response) {
+	wait := request.Timeout
+	if wait <= 0 {
+		wait = DefaultPopWait
+	}
+	timer := time.NewTimer(min(wait, MaxPopWait))
+	defer timer.Stop()
+	for {
+		// taken first, so a push after the try is never missed
+		pushed := s.kvs.Pushed()
+		s.multiMu.RLock()
+		s.pop(request, identity, true, response)
+		s.multiMu.RUnlock()
+		if !response.Success || response.Found {
+			return
+		}
+		select {
+		case <-pushed:
+		case <-timer.C:
+			return
+		case <-ctx.Done():
+			return
+		}
+	}
+}
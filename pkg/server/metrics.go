@@ -0,0 +1,173 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// DefaultMetricsInterval is how often metrics are pushed unless
+// MetricsPush.Interval says otherwise
+const DefaultMetricsInterval = 10 * time.Second
+
+// statsdPacket is the most a StatsD datagram carries, to stay within the
+// MTU of most networks
+const statsdPacket = 1432
+
+// MetricsPush sends the numbers ADMIN STATS reports to a StatsD daemon or
+// a Graphite server every Interval, for monitoring that is pushed to
+// rather than scraping. Addr is "statsd://host:port", or just
+// "host:port", for StatsD gauges over UDP, or "graphite://host:port" for
+// Graphite's plaintext protocol over TCP; an empty Addr turns it off.
+// Every name starts with Prefix, e.g. "kvs.". Durations are sent in
+// milliseconds, under their name with "_ms" added, and on and off as 1
+// and 0; stats that are none of these are left out.
+type MetricsPush struct {
+	Addr     string
+	Prefix   string
+	Interval time.Duration
+}
+
+// SetMetricsPush sets where metrics are pushed; call it before Start
+func (s *Server) SetMetricsPush(p MetricsPush) error {
+	if p.Addr != "" {
+		if _, _, err := metricsTarget(p.Addr); err != nil {
+			return err
+		}
+	}
+	if p.Interval <= 0 {
+		p.Interval = DefaultMetricsInterval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.metricsPush = p
+	return nil
+}
+
+// metricsTarget is the network and address to push to, "udp" for StatsD
+// and "tcp" for Graphite
+func metricsTarget(addr string) (network, hostPort string, err error) {
+	scheme, rest, ok := strings.Cut(addr, "://")
+	if !ok {
+		scheme, rest = "statsd", addr
+	}
+	switch scheme {
+	case "statsd":
+		network = "udp"
+	case "graphite":
+		network = "tcp"
+	default:
+		return "", "", fmt.Errorf("unknown metrics scheme %q, expected statsd or graphite", scheme)
+	}
+	if _, _, err := net.SplitHostPort(rest); err != nil {
+		return "", "", fmt.Errorf("metrics address %q: %w", addr, err)
+	}
+	return network, rest, nil
+}
+
+// metric is a stat as a number
+type metric struct {
+	name  string
+	value float64
+}
+
+// metrics are the stats that are numbers, or durations or on and off
+func (s *Server) metrics() []metric {
+	var ms []metric
+	for _, line := range s.stats() {
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok || strings.ContainsAny(name, " :|") {
+			continue
+		}
+		if v, err := strconv.ParseFloat(value, 64); err == nil {
+			ms = append(ms, metric{name, v})
+		} else if d, err := time.ParseDuration(value); err == nil {
+			ms = append(ms, metric{name + "_ms", float64(d) / float64(time.Millisecond)})
+		} else if value == "on" || value == "off" {
+			var v float64
+			if value == "on" {
+				v = 1
+			}
+			ms = append(ms, metric{name, v})
+		}
+	}
+	return ms
+}
+
+// pushMetrics pushes the metrics every interval until ctx is done
+func (s *Server) pushMetrics(ctx context.Context, p MetricsPush) {
+	network, addr, _ := metricsTarget(p.Addr)
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	var conn net.Conn
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var err error
+		if conn == nil {
+			conn, err = net.DialTimeout(network, addr, p.Interval)
+		}
+		if err == nil {
+			conn.SetWriteDeadline(time.Now().Add(p.Interval))
+			err = writeMetrics(conn, network == "tcp", p.Prefix, s.metrics())
+		}
+		if err != nil {
+			// reported once per outage, and the next push dials again
+			if !failing {
+				kvstore.RecordError("Error pushing metrics:", err)
+			}
+			failing = true
+			if conn != nil {
+				conn.Close()
+				conn = nil
+			}
+			continue
+		}
+		if failing {
+			kvstore.Logf(kvstore.LogInfo, "Pushing metrics to %s again", p.Addr)
+		}
+		failing = false
+	}
+}
+
+// writeMetrics writes ms to conn as Graphite plaintext lines, if graphite,
+// or else as StatsD gauges, as many to a datagram as fit
+func writeMetrics(conn net.Conn, graphite bool, prefix string, ms []metric) error {
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	var buf []byte
+	for _, m := range ms {
+		value := strconv.FormatFloat(m.value, 'f', -1, 64)
+		var line string
+		if graphite {
+			line = prefix + m.name + " " + value + " " + now + "\n"
+		} else {
+			line = prefix + m.name + ":" + value + "|g\n"
+		}
+		if !graphite && len(buf) > 0 && len(buf)+len(line) > statsdPacket {
+			if _, err := conn.Write(buf); err != nil {
+				return err
+			}
+			buf = buf[:0]
+		}
+		buf = append(buf, line...)
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := conn.Write(buf)
+	return err
+}
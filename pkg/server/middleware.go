@@ -0,0 +1,156 @@
+package server
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// Handler runs one request and returns its response; ctx carries the
+// request's RequestInfo.
+type Handler func(ctx context.Context, request protocol.Request) protocol.Response
+
+// Middleware wraps a Handler to add behaviour around requests, e.g. rate
+// limiting, validation or logging. It may change the request or the
+// response, or answer without calling next at all.
+//
+// A request passes through the stages in this order: the admin plane's
+// checks, see AdminPlane, then the middleware of Use and then of UseOn,
+// then the timing the SLOs and the slow log are kept from, and last the
+// handler, which runs the request or queues it inside MULTI. The requests
+// EXEC and EVAL run on their behalf don't pass through them again.
+type Middleware func(next Handler) Handler
+
+// Use runs every request on every listener through middleware, the first
+// one outermost; call it before Start.
+func (s *Server) Use(middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.middleware = append(s.middleware, middleware...)
+}
+
+// UseOn is Use for the requests on the listener on addr alone, written as
+// it was given to NewServer or in AdminPlane.Addrs; they pass through it
+// after the middleware of Use.
+func (s *Server) UseOn(addr string, middleware ...Middleware) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.addrMiddleware == nil {
+		s.addrMiddleware = make(map[string][]Middleware)
+	}
+	s.addrMiddleware[addr] = append(s.addrMiddleware[addr], middleware...)
+}
+
+// pipeline wraps h in the stages of a listener, with middleware being its
+// own, see Middleware
+func (s *Server) pipeline(h Handler, middleware []Middleware) Handler {
+	h = s.measure(h)
+	for i := len(middleware) - 1; i >= 0; i-- {
+		h = middleware[i](h)
+	}
+	return s.authorize(h)
+}
+
+// authorize refuses the actions of the admin plane that may not run, see
+// adminRefusal
+func (s *Server) authorize(next Handler) Handler {
+	return func(ctx context.Context, request protocol.Request) protocol.Response {
+		info, _ := RequestFromContext(ctx)
+		if msg := s.adminRefusal(request, info.Admin); msg != "" {
+			return protocol.Response{Message: msg}
+		}
+		return next(ctx, request)
+	}
+}
+
+// measure records how long requests take, for the SLOs and the slow log
+func (s *Server) measure(next Handler) Handler {
+	return func(ctx context.Context, request protocol.Request) protocol.Response {
+		start := time.Now()
+		response := next(ctx, request)
+		took := time.Since(start)
+		s.observe(request.Action, took)
+		info, _ := RequestFromContext(ctx)
+		s.slowLog.record(info.Client, request, took)
+		return response
+	}
+}
+
+// maxRateClients is how many clients a RateLimit tracks before it forgets
+// the idle ones
+const maxRateClients = 10000
+
+// RateLimit returns middleware that lets each client host send perSecond
+// requests a second, in bursts of up to burst, and refuses the rest with
+// protocol.MsgRateLimited and a Backoff of when the next one may be sent.
+// It lets every request through if perSecond is not above zero.
+func RateLimit(perSecond float64, burst int) Middleware {
+	l := &rateLimiter{rate: perSecond, burst: float64(max(burst, 1)), clients: make(map[string]*tokenBucket)}
+	return func(next Handler) Handler {
+		if perSecond <= 0 {
+			return next
+		}
+		return func(ctx context.Context, request protocol.Request) protocol.Response {
+			info, _ := RequestFromContext(ctx)
+			host, _, err := net.SplitHostPort(info.Client)
+			if err != nil {
+				host = info.Client
+			}
+			if wait := l.take(host); wait > 0 {
+				return protocol.Response{
+					Message: protocol.MsgRateLimited,
+					Error:   &protocol.ErrorInfo{Code: protocol.MsgRateLimited, Retryable: true, Backoff: wait},
+				}
+			}
+			return next(ctx, request)
+		}
+	}
+}
+
+// rateLimiter keeps a token bucket per client
+type rateLimiter struct {
+	rate, burst float64
+	mu          sync.Mutex
+	clients     map[string]*tokenBucket
+}
+
+// tokenBucket is a client's allowance: tokens as of at
+type tokenBucket struct {
+	tokens float64
+	at     time.Time
+}
+
+// take spends one of client's tokens, or returns how long until it has one
+func (l *rateLimiter) take(client string) time.Duration {
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b := l.clients[client]
+	if b == nil {
+		if len(l.clients) >= maxRateClients {
+			l.forgetIdle(now)
+		}
+		b = &tokenBucket{tokens: l.burst, at: now}
+		l.clients[client] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.at).Seconds()*l.rate)
+	b.at = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return 0
+}
+
+// forgetIdle drops the clients whose bucket is full again, which a new
+// one would be as well
+func (l *rateLimiter) forgetIdle(now time.Time) {
+	for client, b := range l.clients {
+		if b.tokens+now.Sub(b.at).Seconds()*l.rate >= l.burst {
+			delete(l.clients, client)
+		}
+	}
+}
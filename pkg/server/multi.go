@@ -0,0 +1,220 @@
+package server
+
+import (
+	"context"
+	"strings"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// queueable are the actions a MULTI transaction may queue. They are the
+// requests on keys that neither wait nor take long, so EXEC can hold them
+// all off while it runs a transaction.
+var queueable = map[string]bool{
+	protocol.ActionGet:           true,
+	protocol.ActionSet:           true,
+	protocol.ActionSetNX:         true,
+	protocol.ActionCAS:           true,
+	protocol.ActionGetSet:        true,
+	protocol.ActionGetDel:        true,
+	protocol.ActionUpdate:        true,
+	protocol.ActionDelete:        true,
+	protocol.ActionRename:        true,
+	protocol.ActionCopy:          true,
+	protocol.ActionIncr:          true,
+	protocol.ActionDecr:          true,
+	protocol.ActionIncrBy:        true,
+	protocol.ActionAppend:        true,
+	protocol.ActionStrlen:        true,
+	protocol.ActionSetBit:        true,
+	protocol.ActionGetBit:        true,
+	protocol.ActionBitCount:      true,
+	protocol.ActionMGet:          true,
+	protocol.ActionMSet:          true,
+	protocol.ActionBatch:         true,
+	protocol.ActionHSet:          true,
+	protocol.ActionHGet:          true,
+	protocol.ActionHGetAll:       true,
+	protocol.ActionHDel:          true,
+	protocol.ActionLPush:         true,
+	protocol.ActionRPush:         true,
+	protocol.ActionLPop:          true,
+	protocol.ActionRPop:          true,
+	protocol.ActionLRange:        true,
+	protocol.ActionSAdd:          true,
+	protocol.ActionSRem:          true,
+	protocol.ActionSMembers:      true,
+	protocol.ActionSIsMember:     true,
+	protocol.ActionSUnion:        true,
+	protocol.ActionSInter:        true,
+	protocol.ActionSDiff:         true,
+	protocol.ActionZAdd:          true,
+	protocol.ActionZRem:          true,
+	protocol.ActionZRange:        true,
+	protocol.ActionZRangeByScore: true,
+	protocol.ActionZRank:         true,
+	protocol.ActionGeoAdd:        true,
+	protocol.ActionGeoSearch:     true,
+	protocol.ActionXAdd:          true,
+	protocol.ActionXRead:         true,
+	protocol.ActionXGroup:        true,
+	protocol.ActionXReadGroup:    true,
+	protocol.ActionXAck:          true,
+	protocol.ActionJSONSet:       true,
+	protocol.ActionJSONGet:       true,
+}
+
+// listings are the actions that read the whole keyspace rather than keys
+// named in the request
+var listings = map[string]bool{
+	protocol.ActionScan:   true,
+	protocol.ActionRange:  true,
+	protocol.ActionList:   true,
+	protocol.ActionSearch: true,
+	protocol.ActionDBSize: true,
+}
+
+// keyspaceAdmin are the ADMIN subcommands that replace the keys
+var keyspaceAdmin = map[string]bool{
+	protocol.AdminRestore: true,
+	protocol.AdminLoad:    true,
+	protocol.AdminImport:  true,
+}
+
+// holdsOffExec reports whether request holds multiMu shared while it runs,
+// so that EXEC runs between such requests rather than during one: every
+// request on keys, write and listing of the keyspace. Those that wait for
+// a key, BLPOP and the lock manager's, don't hold it while they wait.
+func holdsOffExec(request protocol.Request) bool {
+	switch request.Action {
+	case protocol.ActionBLPop, protocol.ActionRLock, protocol.ActionWLock:
+		return false
+	case protocol.ActionAdmin:
+		return keyspaceAdmin[strings.ToUpper(request.Value)]
+	}
+	if queueable[request.Action] || keyActions[request.Action] || writeActions[request.Action] || listings[request.Action] {
+		return true
+	}
+	cmd, ok := command(request.Action)
+	return ok && (cmd.Keyed || cmd.Write)
+}
+
+// multi is the transaction a connection started with MULTI
+type multi struct {
+	queued  []protocol.Request
+	aborted bool // a request could not be queued
+}
+
+// session is the transaction state of a connection
+type session struct {
+	multi   *multi            // nil outside MULTI
+	watched map[string]uint64 // the WATCHed keys and their revisions then
+}
+
+// transact runs request from a connection in state sess: MULTI, EXEC and
+// DISCARD start and end its transaction, while it is open other requests
+// are queued rather than run, and WATCH and UNWATCH pick the keys whose
+// change makes EXEC fail
+func (s *Server) transact(ctx context.Context, client string, request protocol.Request, admin bool, sess *session) protocol.Response {
+	var response protocol.Response
+	switch request.Action {
+	case protocol.ActionWatch:
+		if sess.multi != nil {
+			response.Message = protocol.MsgInMulti
+			return response
+		}
+		keys := append([]string{request.Key}, request.Keys...)
+		if request.Key == "" {
+			keys = keys[1:]
+		}
+		for _, key := range keys {
+			if owner, ok := s.elsewhere(key); ok {
+				response.Message, response.Value = protocol.MsgMoved, owner
+				return response
+			}
+		}
+		if sess.watched == nil {
+			sess.watched = make(map[string]uint64)
+		}
+		for _, key := range keys {
+			if _, ok := sess.watched[key]; !ok {
+				sess.watched[key] = s.revisionOf(key)
+			}
+		}
+	case protocol.ActionUnwatch:
+		sess.watched = nil
+	case protocol.ActionMulti:
+		if sess.multi != nil {
+			response.Message = protocol.MsgInMulti
+			return response
+		}
+		sess.multi = &multi{}
+	case protocol.ActionDiscard:
+		if sess.multi == nil {
+			response.Message = protocol.MsgNoMulti
+			return response
+		}
+		sess.multi, sess.watched = nil, nil
+	case protocol.ActionExec:
+		t, watched := sess.multi, sess.watched
+		if t == nil {
+			response.Message = protocol.MsgNoMulti
+			return response
+		}
+		sess.multi, sess.watched = nil, nil
+		if t.aborted {
+			response.Message = protocol.MsgExecAborted
+			return response
+		}
+		replies, ok := s.exec(ctx, client, t.queued, watched, admin)
+		if err := s.commitWAL(); err != nil {
+			response.Message = protocol.MsgServerError
+			return response
+		}
+		if !ok {
+			response.Message = protocol.MsgConflict
+			return response
+		}
+		response.Replies = replies
+	default:
+		t := sess.multi
+		if t == nil {
+			return s.handle(ctx, client, request, admin)
+		}
+		if !queueable[request.Action] || len(t.queued) >= protocol.MaxMultiRequests {
+			t.aborted = true
+			response.Message = protocol.MsgNotQueueable
+			return response
+		}
+		t.queued = append(t.queued, request)
+		response.Message = protocol.MsgQueued
+	}
+	response.Success = true
+	return response
+}
+
+// exec runs the requests of a transaction with every other request on keys
+// held off, unless a watched key's revision has changed since
+func (s *Server) exec(ctx context.Context, client string, requests []protocol.Request, watched map[string]uint64, admin bool) ([]protocol.Response, bool) {
+	s.multiMu.Lock()
+	defer s.multiMu.Unlock()
+	for key, rev := range watched {
+		if s.revisionOf(key) != rev {
+			return nil, false
+		}
+	}
+	replies := make([]protocol.Response, len(requests))
+	for i, request := range requests {
+		replies[i] = withErrorInfo(s.dispatch(ctx, client, request, admin))
+	}
+	return replies, true
+}
+
+// revisionOf returns key's revision, zero if it does not exist. Writes the
+// read cache buffers are written back first, so a SET made before a WATCH
+// doesn't count as made after it.
+func (s *Server) revisionOf(key string) uint64 {
+	s.proxy.FlushWrites()
+	item, _ := s.kvs.GETKV(key)
+	return item.Revision
+}
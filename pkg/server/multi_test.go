@@ -0,0 +1,142 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestExecRunsTheQueue(t *testing.T) {
+	kvs := kvstore.NewKeyValueStore()
+	c := newTestClient(t, newTestServer(t, kvs))
+	ctx := context.Background()
+	replies, err := c.Multi().Set("a", "1").IncrBy("n", 5).Get("a").Delete("missing").Exec(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(replies) != 4 || !replies[0].Success || replies[1].Value != "5" || replies[2].Value != "1" || replies[3].Success {
+		t.Errorf("replies %+v", replies)
+	}
+
+	// a request that can't be queued fails the whole transaction
+	_, err = c.Multi().Set("b", "1").Queue(protocol.Request{Action: protocol.ActionPublish, Key: "ch", Value: "x"}).Exec(ctx)
+	if err == nil {
+		t.Fatal("Exec queued PUBLISH")
+	}
+	if _, found := kvs.GET("b"); found {
+		t.Error("a failed transaction ran its SET")
+	}
+}
+
+// TestExecHoldsOffRequests runs requests while multiMu is held as EXEC
+// holds it: every request on keys, write and listing must wait for it
+func TestExecHoldsOffRequests(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore())
+	for _, request := range []protocol.Request{
+		{Action: protocol.ActionGet, Key: "k"},
+		{Action: protocol.ActionSet, Key: "k", Value: "v"},
+		{Action: protocol.ActionTouch, Key: "k", TTL: time.Minute},
+		{Action: protocol.ActionUndelete, Key: "k"},
+		{Action: protocol.ActionNextID, Key: "id"},
+		{Action: protocol.ActionLock, Key: "lease", Owner: "o", TTL: time.Minute},
+		{Action: protocol.ActionRenew, Key: "lease", TTL: time.Minute},
+		{Action: protocol.ActionUnlock, Key: "lease"},
+		{Action: protocol.ActionWindowIncr, Key: "w", Value: "1s"},
+		{Action: protocol.ActionScan},
+		{Action: protocol.ActionRange},
+		{Action: protocol.ActionList, Key: "/"},
+		{Action: protocol.ActionSearch, Key: "*"},
+		{Action: protocol.ActionDBSize},
+		{Action: protocol.ActionAdmin, Value: "restore", Key: "nothing"},
+	} {
+		s.multiMu.Lock()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			s.handle(context.Background(), "test", request, true)
+		}()
+		select {
+		case <-done:
+			t.Errorf("%s %s ran during a transaction", request.Action, request.Value)
+		case <-time.After(20 * time.Millisecond):
+		}
+		s.multiMu.Unlock()
+		<-done
+	}
+}
+
+// TestExecDoesNotHoldOffWaits checks that the requests that wait for a key
+// don't hold multiMu while they wait, which would hold every transaction up
+func TestExecDoesNotHoldOffWaits(t *testing.T) {
+	s := newTestServer(t, kvstore.NewKeyValueStore())
+	for _, request := range []protocol.Request{
+		{Action: protocol.ActionPing},
+		{Action: protocol.ActionAdmin, Value: protocol.AdminStats},
+		{Action: protocol.ActionRLock, Key: "k", Owner: "o", Timeout: time.Millisecond},
+	} {
+		if holdsOffExec(request) {
+			t.Errorf("%s %s holds off transactions", request.Action, request.Value)
+		}
+	}
+	if !holdsOffExec(protocol.Request{Action: protocol.ActionAdmin, Value: "import"}) {
+		t.Error("ADMIN IMPORT doesn't hold off transactions")
+	}
+	s.multiMu.Lock()
+	defer s.multiMu.Unlock()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.handle(context.Background(), "test", protocol.Request{Action: protocol.ActionPing}, false)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("PING waited for a transaction")
+	}
+}
+
+func TestExecAbortsWhenAWatchedKeyChanges(t *testing.T) {
+	kvs := kvstore.NewKeyValueStore()
+	s := newTestServer(t, kvs)
+	c, other := newTestClient(t, s), newTestClient(t, s)
+	ctx := context.Background()
+	c.Set(ctx, "balance", "10")
+
+	transfer := func(write func()) error {
+		sess, err := c.Session(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sess.Close()
+		if err := sess.Watch(ctx, "balance"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sess.Get(ctx, "balance"); err != nil {
+			t.Fatal(err)
+		}
+		write()
+		_, err = sess.Multi().Set("balance", "0").Set("paid", "10").Exec(ctx)
+		return err
+	}
+	if err := transfer(func() { other.Set(ctx, "balance", "20") }); !errors.Is(err, kvsclient.ErrConflict) {
+		t.Fatalf("Exec after the watched key changed = %v, want ErrConflict", err)
+	}
+	if value, _ := kvs.GET("balance"); value != "20" {
+		t.Errorf("balance %q after an aborted transaction, want the other write's", value)
+	}
+	if _, found := kvs.GET("paid"); found {
+		t.Error("aborted transaction wrote paid")
+	}
+	// a write to another key doesn't abort it
+	if err := transfer(func() { other.Set(ctx, "unrelated", "1") }); err != nil {
+		t.Fatalf("Exec with the watched key unchanged: %v", err)
+	}
+	if value, _ := kvs.GET("balance"); value != "0" {
+		t.Errorf("balance %q, want 0", value)
+	}
+}
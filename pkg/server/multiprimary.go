@@ -0,0 +1,79 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// MultiPrimary makes a server one of Peers, servers that all take writes
+// and follow each other's with PSYNC, so each can be near its own clients.
+// A write from a peer replaces a key only if it is later by hybrid logical
+// clock, the last writer winning, see kvstore.SetMultiPrimary; the peers
+// converge once they have each other's writes, but two that take writes to
+// one key at once keep only one of them, whole: the increments of an INCR,
+// or the members of an SADD, the other made are lost. Self is this
+// server's address as listed in Peers.
+type MultiPrimary struct {
+	Self  string
+	Peers []string
+}
+
+// multiPrimary is this server's view of its peers
+type multiPrimary struct {
+	MultiPrimary
+	links []*replica
+}
+
+// SetMultiPrimary makes the server one of g.Peers. Call it before Start,
+// instead of ReplicaOf and SetFailoverGroup.
+func (s *Server) SetMultiPrimary(g MultiPrimary) error {
+	found := false
+	for _, peer := range g.Peers {
+		found = found || peer == g.Self
+	}
+	if !found {
+		return errors.New("multi-primary peers do not include this server")
+	}
+	if len(g.Peers) < 2 {
+		return errors.New("multi-primary mode needs at least two peers")
+	}
+	s.kvs.SetMultiPrimary(g.Self)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.multi = &multiPrimary{MultiPrimary: g}
+	return nil
+}
+
+// startPeers starts the links to the other peers; caller is Start, holding
+// s.mu
+func (s *Server) startPeers(ctx context.Context, m *multiPrimary) {
+	token := s.adminPlane.Token
+	for _, peer := range m.Peers {
+		if peer == m.Self {
+			continue
+		}
+		ctx, cancel := context.WithCancel(ctx)
+		r := &replica{primary: peer, merge: true, cancel: cancel, done: make(chan struct{}), state: "connecting"}
+		m.links = append(m.links, r)
+		kvstore.Logf(kvstore.LogInfo, "Merging writes from peer %s", peer)
+		s.goWorker(func() { s.replicate(ctx, r, token) })
+	}
+}
+
+// peersInfo describes the links to the peers for REPLICAOF and ROLE
+func (s *Server) peersInfo() []string {
+	m := s.multi
+	if m == nil {
+		return nil
+	}
+	lines := []string{fmt.Sprintf("peers: %d", len(m.links))}
+	for _, r := range m.links {
+		r.mu.Lock()
+		lines = append(lines, fmt.Sprintf("peer %s: %s, position %d, behind %d, last_error %s", r.primary, r.state, r.seq, r.behind, r.lastErr))
+		r.mu.Unlock()
+	}
+	return lines
+}
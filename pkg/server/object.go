@@ -0,0 +1,63 @@
+package server
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// object answers TYPE and OBJECT
+func (s *Server) object(request protocol.Request) protocol.Response {
+	// a SET the read cache buffers is not in the store yet
+	s.proxy.FlushWrites()
+	var response protocol.Response
+	info, found := s.kvs.OBJECT(request.Key)
+	response.Found = found
+	response.Success = true
+	if request.Action == protocol.ActionType {
+		response.Value = "none"
+		if found {
+			response.Value = info.Type.String()
+		}
+		return response
+	}
+	if !found {
+		return response
+	}
+	response.Values = []string{
+		"type: " + info.Type.String(),
+		"revision: " + strconv.FormatUint(info.Revision, 10),
+		"bytes: " + strconv.FormatInt(info.Size, 10),
+		"written: " + info.Written.UTC().Format(time.RFC3339Nano),
+		"expires: " + info.Expires.UTC().Format(time.RFC3339Nano),
+		"ttl_ms: " + strconv.FormatInt(info.TTL.Milliseconds(), 10),
+	}
+	if !info.LastAccess.IsZero() {
+		response.Values = append(response.Values, "last_access: "+info.LastAccess.UTC().Format(time.RFC3339Nano))
+	}
+	return response
+}
+
+// memoryUsage answers MEMORYUSAGE
+func (s *Server) memoryUsage(request protocol.Request) protocol.Response {
+	s.proxy.FlushWrites()
+	var response protocol.Response
+	m, found := s.kvs.MEMORYUSAGE(request.Key)
+	response.Found = found
+	response.Success = true
+	if !found {
+		return response
+	}
+	response.Value = strconv.FormatInt(m.Total(), 10)
+	response.Values = []string{
+		"key_bytes: " + strconv.FormatInt(m.KeyBytes, 10),
+		"value_bytes: " + strconv.FormatInt(m.ValueBytes, 10),
+		"overhead_bytes: " + strconv.FormatInt(m.OverheadBytes, 10),
+		"total_bytes: " + response.Value,
+	}
+	if m.Prefix != "" {
+		response.Values = append(response.Values, "namespace: "+m.Prefix)
+	}
+	return response
+}
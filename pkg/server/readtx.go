@@ -0,0 +1,106 @@
+package server
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultReadTTL is how long a read transaction stays open unless
+// BEGINREAD asks otherwise
+const DefaultReadTTL = 30 * time.Second
+
+// MaxReadTTL bounds how long a read transaction stays open, since the
+// store keeps every entry overwritten meanwhile for it
+const MaxReadTTL = 5 * time.Minute
+
+// readTxs are the open read transactions by id. They belong to the server
+// rather than a connection, as clients spread requests over a pool.
+type readTxs struct {
+	mu   sync.Mutex
+	open map[string]*kvstore.ReadTx
+	seq  uint64
+}
+
+// beginRead opens a read transaction that ends after ttl unless endRead
+// ends it first
+func (s *Server) beginRead(ttl time.Duration) (id string, tx *kvstore.ReadTx) {
+	if ttl <= 0 {
+		ttl = DefaultReadTTL
+	}
+	// the transaction sees the writes the read cache buffers
+	s.proxy.FlushWrites()
+	tx = s.kvs.BeginRead()
+	s.reads.mu.Lock()
+	defer s.reads.mu.Unlock()
+	if s.reads.open == nil {
+		s.reads.open = make(map[string]*kvstore.ReadTx)
+	}
+	s.reads.seq++
+	id = strconv.FormatUint(tx.Revision(), 10) + "-" + strconv.FormatUint(s.reads.seq, 10)
+	s.reads.open[id] = tx
+	time.AfterFunc(min(ttl, MaxReadTTL), func() { s.endRead(id) })
+	return id, tx
+}
+
+// readTx returns the open read transaction id
+func (s *Server) readTx(id string) (*kvstore.ReadTx, bool) {
+	s.reads.mu.Lock()
+	defer s.reads.mu.Unlock()
+	tx, ok := s.reads.open[id]
+	return tx, ok
+}
+
+// endRead closes the read transaction id and reports whether it was open
+func (s *Server) endRead(id string) bool {
+	s.reads.mu.Lock()
+	tx, ok := s.reads.open[id]
+	delete(s.reads.open, id)
+	s.reads.mu.Unlock()
+	if ok {
+		tx.Close()
+	}
+	return ok
+}
+
+// getKV reads key for GET and MGET: in the read transaction request
+// names, if any, else at the Revision it names, see readAt, else through
+// the proxy. A value of any type but a
+// string is not found, with the Value WRONGTYPE. msg is set if it can't be read: CANCELED if ctx is done
+// first, NO_READ_TX if the transaction is not open.
+func (s *Server) getKV(ctx context.Context, request protocol.Request, key string) (item kvstore.KeyValue, found bool, msg string) {
+	item, found, msg = s.readKV(ctx, request, key)
+	if found && item.Type != kvstore.TypeString {
+		return kvstore.KeyValue{Value: protocol.MsgWrongType}, false, ""
+	}
+	return item, found, msg
+}
+
+// readKV is getKV returning any type of value
+func (s *Server) readKV(ctx context.Context, request protocol.Request, key string) (item kvstore.KeyValue, found bool, msg string) {
+	if request.ReadTx == "" && request.Revision > 0 {
+		item, found = s.readAt(key, request.Revision)
+		return item, found, ""
+	}
+	if request.ReadTx == "" {
+		item, found, err := s.proxy.GETKVContext(ctx, key)
+		if err != nil {
+			return item, false, protocol.MsgCanceled
+		}
+		return item, found, ""
+	}
+	tx, ok := s.readTx(request.ReadTx)
+	if !ok {
+		return item, false, protocol.MsgNoReadTx
+	}
+	item, found, err := tx.GETKV(key)
+	if err != nil {
+		// closed by a restore
+		return item, false, protocol.MsgNoReadTx
+	}
+	return item, found, ""
+}
@@ -0,0 +1,553 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// replication defaults
+const (
+	// ReplicaPoll is how long a replica's PSYNC waits for the primary's
+	// next write
+	ReplicaPoll = 10 * time.Second
+	// ReplicaSeenFor is how long after its last PSYNC a replica still
+	// counts as connected
+	ReplicaSeenFor = 3 * ReplicaPoll
+	// ReplicaKnownFor is how long after its last PSYNC a replica that is
+	// no longer connected still counts for writes with Consistency QUORUM
+	// or ALL, unless Server.SetReplicas says how many there are
+	ReplicaKnownFor      = 10 * ReplicaSeenFor
+	replicaBackoff       = 100 * time.Millisecond
+	replicaMaxBackoff    = 5 * time.Second
+	replicaRequestMargin = 5 * time.Second // on top of ReplicaPoll for the round trip
+)
+
+// replica is a server's link to its primary, or with merge to a peer of
+// a multi-primary group
+type replica struct {
+	primary string
+	merge   bool
+	cancel  context.CancelFunc
+	done    chan struct{}
+
+	mu      sync.Mutex
+	state   string // connecting, syncing or streaming
+	id      string // the primary's replication ID, empty until synced
+	seq     uint64 // position of the last write applied
+	behind  uint64 // writes the primary had not sent yet at the last PSYNC
+	synced  time.Time
+	lastErr string
+	current time.Time // the replica last had every write the primary had
+	polling time.Time // a PSYNC sent with the replica current, zero if none
+
+	syncToken string // SYNC Continue of the next snapshot chunk, empty if none
+	syncData  []byte // the snapshot chunks received before it
+}
+
+// seenReplica is what a primary knows of a replica from its PSYNCs
+type seenReplica struct {
+	seq, behind uint64
+	at          time.Time // its latest SYNC or PSYNC arrived
+	answered    time.Time // the last one was answered
+	following   time.Time // the one before was answered, so it followed this server since
+}
+
+// ReplicaOf makes the server a replica of the primary at addr: it loads
+// the primary's keys in place of its own, then applies the primary's
+// writes as they happen and refuses writes of its own with READONLY.
+// addr is an address of the primary serving SYNC and PSYNC, its admin
+// listener if it has one, which is sent the server's own admin token. An
+// empty addr makes the server a primary again, keeping the keys it has.
+// Called before Start, replication begins once the data is restored.
+func (s *Server) ReplicaOf(addr string) {
+	s.link(addr, true)
+}
+
+// link replaces the link to the primary with one to addr, or drops it if
+// addr is empty, remembering addr for Start if config
+func (s *Server) link(addr string, config bool) {
+	s.replicaMu.Lock()
+	defer s.replicaMu.Unlock()
+	// the old link keeps writes refused until the new one takes over
+	if old := s.replica.Load(); old != nil {
+		old.cancel()
+		<-old.done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if config {
+		s.replicaOf = addr
+	}
+	if !s.running {
+		return
+	}
+	s.startReplica(addr)
+}
+
+// startReplica starts the link to addr, or drops the link if it is
+// empty; caller holds s.replicaMu, or is Start, and s.mu
+func (s *Server) startReplica(addr string) {
+	if addr == "" {
+		if s.replica.Swap(nil) != nil {
+			kvstore.Logf(kvstore.LogInfo, "Replication stopped, serving as primary")
+		}
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	r := &replica{primary: addr, cancel: cancel, done: make(chan struct{}), state: "connecting"}
+	s.replica.Store(r)
+	token := s.adminPlane.Token
+	kvstore.Logf(kvstore.LogInfo, "Replicating from %s", r.primary)
+	s.goWorker(func() { s.replicate(ctx, r, token) })
+}
+
+// primary is the address of the server's primary, empty on a primary
+func (s *Server) primary() string {
+	if r := s.replica.Load(); r != nil {
+		return r.primary
+	}
+	return ""
+}
+
+// replicating reports whether the server is a replica, or a member of a
+// failover group that is not the primary, so refuses writes
+func (s *Server) replicating() bool {
+	return s.replica.Load() != nil || s.electing()
+}
+
+// replicate follows the primary of r until ctx is done, loading its keys
+// again whenever it can't resume where it left off
+func (s *Server) replicate(ctx context.Context, r *replica, token string) {
+	defer close(r.done)
+	opts := []kvsclient.Option{kvsclient.WithTimeout(ReplicaPoll + replicaRequestMargin)}
+	if token != "" {
+		opts = append(opts, kvsclient.WithToken(token))
+	}
+	client := kvsclient.NewClient(r.primary, opts...)
+	defer client.Close()
+	backoff := replicaBackoff
+	for ctx.Err() == nil {
+		err := s.follow(ctx, client, r)
+		if ctx.Err() != nil {
+			return
+		}
+		r.mu.Lock()
+		progress := r.state == "streaming"
+		r.state, r.lastErr, r.polling = "connecting", err.Error(), time.Time{}
+		r.mu.Unlock()
+		if progress {
+			backoff = replicaBackoff
+		}
+		kvstore.Logf(kvstore.LogWarn, "Replication from %s failed, retrying in %s: %v", r.primary, backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, replicaMaxBackoff)
+	}
+}
+
+// follow applies the primary's writes until it fails, syncing first if
+// there is nothing to resume from
+func (s *Server) follow(ctx context.Context, client *kvsclient.Client, r *replica) error {
+	for ctx.Err() == nil {
+		r.mu.Lock()
+		id, seq := r.id, r.seq
+		r.polling = time.Time{}
+		if r.behind == 0 && !r.current.IsZero() {
+			r.polling = time.Now()
+		}
+		r.mu.Unlock()
+		if id == "" {
+			if err := s.fullSync(ctx, client, r); err != nil {
+				return err
+			}
+			continue
+		}
+		response, err := client.Do(ctx, s.withEpoch(protocol.Request{Action: protocol.ActionPSync, Value: id, Revision: seq, Timeout: s.replicaPoll()}))
+		if err != nil {
+			return err
+		}
+		if !s.heardPrimary(response.Epoch) {
+			return fmt.Errorf("%s is primary of an earlier failover epoch", r.primary)
+		}
+		if response.Message == protocol.MsgResync {
+			kvstore.Logf(kvstore.LogInfo, "Replication from %s can't resume at %d, syncing again", r.primary, seq)
+			r.mu.Lock()
+			r.id = ""
+			r.mu.Unlock()
+			continue
+		}
+		if !response.Success {
+			return fmt.Errorf("PSYNC: %s", response.Message)
+		}
+		ops := make([]kvstore.ReplicationOp, len(response.Replication))
+		for i, op := range response.Replication {
+			ops[i] = kvstore.ReplicationOp{Seq: op.Seq, Key: op.Key, Revision: op.Revision, Stamp: op.Stamp, Origin: op.Origin}
+			if !op.Deleted {
+				ops[i].Entry = &kvstore.KeyValue{Value: op.Value, Timestamp: op.Timestamp, TTL: op.TTL,
+					Checksum: op.Checksum, Revision: op.Revision, Type: kvstore.ValueType(op.Type)}
+			}
+		}
+		if r.merge {
+			s.kvs.MergeReplication(ops)
+		} else {
+			s.kvs.ApplyReplication(ops)
+		}
+		r.mu.Lock()
+		if len(ops) > 0 {
+			r.seq = ops[len(ops)-1].Seq
+		}
+		r.behind = response.Revision - r.seq
+		r.state, r.lastErr = "streaming", ""
+		if r.behind == 0 {
+			r.current = time.Now()
+		}
+		r.mu.Unlock()
+	}
+	return ctx.Err()
+}
+
+// fullSync replaces the keys with the primary's
+func (s *Server) fullSync(ctx context.Context, client *kvsclient.Client, r *replica) error {
+	r.mu.Lock()
+	r.state = "syncing"
+	token, data := r.syncToken, r.syncData
+	r.mu.Unlock()
+	var response protocol.Response
+	for {
+		var err error
+		response, err = client.Do(ctx, s.withEpoch(protocol.Request{Action: protocol.ActionSync, Limit: SyncChunkSize, Continue: token}))
+		if err != nil {
+			// the next attempt resumes after the chunks received
+			return err
+		}
+		if !s.heardPrimary(response.Epoch) {
+			return fmt.Errorf("%s is primary of an earlier failover epoch", r.primary)
+		}
+		if response.Message == protocol.MsgResync && token != "" {
+			kvstore.Logf(kvstore.LogInfo, "Snapshot from %s can't resume after %d bytes, starting again", r.primary, len(data))
+			token, data = "", nil
+			r.keepSync(token, data)
+			continue
+		}
+		if !response.Success {
+			// BAD_REQUEST from primaries that check actions, INVALID_ACTION
+			// from older ones
+			if response.Message == protocol.MsgBadRequest || response.Message == protocol.MsgInvalidAction {
+				return errors.New("the primary does not support replication")
+			}
+			return fmt.Errorf("SYNC: %s", response.Message)
+		}
+		// older primaries send the whole snapshot at once, unchecked
+		if response.Checksum != 0 && protocol.Checksum(response.Value) != response.Checksum {
+			return fmt.Errorf("SYNC: chunk after %d bytes fails its checksum", len(data))
+		}
+		data = append(data, response.Value...)
+		if !response.More {
+			break
+		}
+		token = response.Continue
+		r.keepSync(token, data)
+	}
+	r.keepSync("", nil)
+	var snapshot kvstore.BackupSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("SYNC: %w", err)
+	}
+	load, from := s.kvs.LoadSnapshot, "the snapshot of primary "+r.primary
+	if r.merge {
+		load, from = s.kvs.MergeReplicationSnapshot, "the snapshot of peer "+r.primary
+	}
+	loaded := s.restore(from, func() (kvstore.RestoreStats, error) {
+		return load(snapshot)
+	})
+	if !loaded.Success {
+		return fmt.Errorf("loading the primary's snapshot: %s", loaded.Message)
+	}
+	r.mu.Lock()
+	r.id, r.seq, r.behind, r.synced = response.Continue, response.Revision, 0, time.Now()
+	r.current = r.synced
+	r.mu.Unlock()
+	return nil
+}
+
+// keepSync records the snapshot chunks received so far and the token for
+// the next, for fullSync to resume from
+func (r *replica) keepSync(token string, data []byte) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.syncToken, r.syncData = token, data
+}
+
+// staleness is how long ago the replica last had every write the primary
+// had. A PSYNC waiting on the primary counts as having them until poll
+// runs out, as the primary answers it at its first write.
+func (r *replica) staleness(poll time.Duration) time.Duration {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.current.IsZero() {
+		return time.Duration(math.MaxInt64)
+	}
+	since := r.current
+	if vouched := r.polling.Add(poll); !r.polling.IsZero() && vouched.After(since) {
+		since = vouched
+	}
+	return max(time.Since(since), 0)
+}
+
+// tooStale reports whether the server can't serve a read for its
+// MaxStaleness, being a replica too far behind or a member of a failover
+// group that follows no primary
+func (s *Server) tooStale(request protocol.Request) bool {
+	if request.MaxStaleness <= 0 || s.isWrite(request.Action) {
+		return false
+	}
+	if r := s.replica.Load(); r != nil {
+		return r.staleness(s.replicaPoll()) > request.MaxStaleness
+	}
+	return s.electing()
+}
+
+// sync runs SYNC for the replica at client: every key, and where the
+// replication history is at
+func (s *Server) sync(client string, request protocol.Request) protocol.Response {
+	var response protocol.Response
+	epoch, ok := s.servesReplicas(request)
+	response.Epoch = epoch
+	if !ok {
+		response.Message = protocol.MsgReadOnly
+		response.Value = s.failoverPrimary()
+		return response
+	}
+	replica := cmp.Or(request.Owner, client)
+	s.sawReplica(replica, 0)
+	defer s.answeredReplica(replica, 0)
+	if request.Limit > 0 || request.Continue != "" {
+		return s.syncChunk(request, response)
+	}
+	snapshot, id, seq := s.kvs.ReplicationSnapshot()
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		kvstore.RecordError("Error syncing a replica:", err)
+		response.Message = protocol.MsgServerError
+		return response
+	}
+	response.Value = string(data)
+	response.Continue = id
+	response.Revision = seq
+	response.Success = true
+	return response
+}
+
+// psync runs PSYNC for the replica at client
+func (s *Server) psync(ctx context.Context, client string, request protocol.Request) protocol.Response {
+	var response protocol.Response
+	epoch, ok := s.servesReplicas(request)
+	response.Epoch = epoch
+	if !ok {
+		response.Message = protocol.MsgReadOnly
+		response.Value = s.failoverPrimary()
+		return response
+	}
+	replica := cmp.Or(request.Owner, client)
+	s.sawReplica(replica, request.Revision)
+	ops, seq, err := s.kvs.ReplicationSince(ctx, request.Value, request.Revision, request.Limit, request.Timeout)
+	if err != nil {
+		response.Message = protocol.MsgResync
+		return response
+	}
+	response.Replication = make([]protocol.ReplicationOp, len(ops))
+	for i, op := range ops {
+		out := &response.Replication[i]
+		out.Seq, out.Key, out.Revision, out.Deleted = op.Seq, op.Key, op.Revision, op.Entry == nil
+		out.Stamp, out.Origin = op.Stamp, op.Origin
+		if e := op.Entry; e != nil {
+			out.Value, out.Timestamp, out.TTL, out.Checksum, out.Type = e.Value, e.Timestamp, e.TTL, e.Checksum, uint8(e.Type)
+		}
+	}
+	response.Revision = seq
+	response.Success = true
+	s.answeredReplica(replica, seq-request.Revision)
+	return response
+}
+
+// sawReplica records a SYNC or PSYNC from the replica at addr, which had
+// applied the writes up to seq
+func (s *Server) sawReplica(addr string, seq uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := s.replicas[addr]
+	seen.seq, seen.at, seen.following = seq, time.Now(), seen.answered
+	s.replicas[addr] = seen
+	if s.acked != nil {
+		close(s.acked)
+		s.acked = nil
+	}
+}
+
+// answeredReplica records the answer to the replica at addr, which left
+// it behind writes
+func (s *Server) answeredReplica(addr string, behind uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	seen := s.replicas[addr]
+	seen.behind, seen.answered = behind, time.Now()
+	s.replicas[addr] = seen
+}
+
+// replicaPoll is how long a PSYNC waits for a write: short enough in a
+// failover group for the primary to hear from its replicas well within
+// the timeout
+func (s *Server) replicaPoll() time.Duration {
+	if f := s.failover; f != nil {
+		return min(ReplicaPoll, f.Timeout/4)
+	}
+	return ReplicaPoll
+}
+
+// replicaOfCommand runs ADMIN REPLICAOF with arg, an address, "no one" or
+// nothing
+func (s *Server) replicaOfCommand(arg string) protocol.Response {
+	if arg != "" && (s.failover != nil || s.multi != nil) {
+		// the group elects its primary, or every peer is one
+		return protocol.Response{Message: protocol.MsgInvalidArgument}
+	}
+	switch strings.ToLower(arg) {
+	case "":
+	case "no one", "no-one":
+		s.ReplicaOf("")
+	default:
+		s.ReplicaOf(arg)
+	}
+	return protocol.Response{Values: s.replicationInfo(), Success: true}
+}
+
+// replicationInfo describes the replication, one "name: value" line each
+func (s *Server) replicationInfo() []string {
+	if r := s.replica.Load(); r != nil {
+		staleness := "unknown"
+		if d := r.staleness(s.replicaPoll()); d < time.Duration(math.MaxInt64) {
+			staleness = d.Round(time.Millisecond).String()
+		}
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		synced := "never"
+		if !r.synced.IsZero() {
+			synced = r.synced.Format(time.RFC3339)
+		}
+		lines := []string{
+			"role: replica",
+			fmt.Sprintf("primary: %s", r.primary),
+			fmt.Sprintf("link: %s", r.state),
+			fmt.Sprintf("replication_id: %s", r.id),
+			fmt.Sprintf("position: %d", r.seq),
+			fmt.Sprintf("behind: %d", r.behind),
+			fmt.Sprintf("last_sync: %s", synced),
+			fmt.Sprintf("sync_received: %s", r.syncReceived()),
+			fmt.Sprintf("staleness: %s", staleness),
+			fmt.Sprintf("last_error: %s", r.lastErr),
+		}
+		return append(lines, s.failoverInfo()...)
+	}
+	lines := []string{"role: " + s.role()}
+	replicas := s.connectedReplicas()
+	lines = append(lines, fmt.Sprintf("replicas: %d", len(replicas)))
+	lines = append(lines, replicas...)
+	lines = append(lines, s.peersInfo()...)
+	return append(lines, s.failoverInfo()...)
+}
+
+// replicationStats describes for STATS where replication is at: on a
+// replica how far behind its primary it is, on a primary the position of
+// each replica seen within ReplicaSeenFor and how many writes it lags
+func (s *Server) replicationStats() []string {
+	if r := s.replica.Load(); r != nil {
+		staleness := r.staleness(s.replicaPoll())
+		r.mu.Lock()
+		defer r.mu.Unlock()
+		lines := []string{
+			fmt.Sprintf("replica_offset: %d", r.seq),
+			fmt.Sprintf("replica_lag: %d", r.behind),
+		}
+		if staleness < time.Duration(math.MaxInt64) {
+			lines = append(lines, fmt.Sprintf("replica_staleness: %s", staleness.Round(time.Millisecond)))
+		}
+		return lines
+	}
+	seq, _ := s.kvs.ReplicationPosition()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	addrs := make([]string, 0, len(s.replicas))
+	for addr, seen := range s.replicas {
+		if time.Since(seen.at) <= ReplicaSeenFor {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+	lines := []string{fmt.Sprintf("replication_offset: %d", seq)}
+	var maxLag uint64
+	for i, addr := range addrs {
+		seen := s.replicas[addr]
+		lag := seq - min(seen.seq, seq)
+		maxLag = max(maxLag, lag)
+		lines = append(lines,
+			fmt.Sprintf("replica_%d_addr: %s", i, addr),
+			fmt.Sprintf("replica_%d_offset: %d", i, seen.seq),
+			fmt.Sprintf("replica_%d_lag: %d", i, lag),
+			fmt.Sprintf("replica_%d_last_ack: %s", i, time.Since(seen.at).Round(time.Millisecond)))
+	}
+	return append(lines, fmt.Sprintf("replication_max_lag: %d", maxLag))
+}
+
+// role is replica, primary, multi-primary, or candidate for a member of a
+// failover group that knows of no primary
+func (s *Server) role() string {
+	if s.replica.Load() != nil {
+		return "replica"
+	}
+	if s.multi != nil {
+		return "multi-primary"
+	}
+	if f := s.failover; f != nil {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.primary != f.Self {
+			return "candidate"
+		}
+	}
+	return "primary"
+}
+
+// connectedReplicas describes the replicas seen within ReplicaSeenFor,
+// forgetting those not seen within ReplicaKnownFor
+func (s *Server) connectedReplicas() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var lines []string
+	for addr, seen := range s.replicas {
+		if time.Since(seen.at) > ReplicaKnownFor {
+			delete(s.replicas, addr)
+			continue
+		}
+		if time.Since(seen.at) > ReplicaSeenFor {
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("replica %s: position %d, behind %d", addr, seen.seq, seen.behind))
+	}
+	sort.Strings(lines)
+	return lines
+}
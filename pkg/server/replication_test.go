@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// awaitKey waits for key to have value in kvs, as a replica applies it
+func awaitKey(t *testing.T, kvs *kvstore.KeyValueStore, key, value string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if got, found := kvs.GET(key); found && got == value {
+			return
+		}
+		if time.Now().After(deadline) {
+			got, found := kvs.GET(key)
+			t.Fatalf("replica has %s = %q (found %v), want %q", key, got, found, value)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReplicaFollowsItsPrimary(t *testing.T) {
+	primaryKVS := kvstore.NewKeyValueStore()
+	primary := newTestServer(t, primaryKVS)
+	ctx := context.Background()
+	pc := newTestClient(t, primary)
+	if err := pc.Set(ctx, "before", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	replicaKVS := kvstore.NewKeyValueStore()
+	replicaKVS.SET("own", "1")
+	replica := newTestServer(t, replicaKVS)
+	replica.ReplicaOf(listenAddr(primary, 0))
+	// the snapshot replaces the replica's own keys
+	awaitKey(t, replicaKVS, "before", "1")
+	if _, found := replicaKVS.GET("own"); found {
+		t.Error("the replica kept a key the primary doesn't have")
+	}
+
+	// then the writes stream
+	if err := pc.Set(ctx, "after", "2"); err != nil {
+		t.Fatal(err)
+	}
+	if err := pc.Delete(ctx, "before"); err != nil {
+		t.Fatal(err)
+	}
+	awaitKey(t, replicaKVS, "after", "2")
+	deadline := time.Now().Add(5 * time.Second)
+	for _, found := replicaKVS.GET("before"); found; _, found = replicaKVS.GET("before") {
+		if time.Now().After(deadline) {
+			t.Fatal("the primary's DELETE never reached the replica")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	set := protocol.Request{Action: protocol.ActionSet, Key: "k", Value: "v"}
+	response := replica.handle(ctx, "test", set, false)
+	if response.Success || response.Message != protocol.MsgReadOnly || response.Value != listenAddr(primary, 0) {
+		t.Fatalf("SET on the replica = %+v, want %s naming the primary", response, protocol.MsgReadOnly)
+	}
+	get := replica.handle(ctx, "test", protocol.Request{Action: protocol.ActionGet, Key: "after"}, false)
+	if !get.Success || get.Value != "2" {
+		t.Errorf("GET on the replica = %+v", get)
+	}
+}
+
+func TestReplicaOfAtRuntime(t *testing.T) {
+	primaryKVS := kvstore.NewKeyValueStore()
+	primaryKVS.SET("k", "primary")
+	primary := newTestServer(t, primaryKVS)
+	replicaKVS := kvstore.NewKeyValueStore()
+	replica := newTestServer(t, replicaKVS)
+	ctx := context.Background()
+	admin := func(arg string) protocol.Response {
+		t.Helper()
+		response := replica.handle(ctx, "test", protocol.Request{Action: protocol.ActionAdmin, Value: protocol.AdminReplicaOf, Key: arg}, true)
+		if !response.Success {
+			t.Fatalf("REPLICAOF %q: %s", arg, response.Message)
+		}
+		return response
+	}
+
+	admin(listenAddr(primary, 0))
+	awaitKey(t, replicaKVS, "k", "primary")
+	if !replica.replicating() {
+		t.Fatal("REPLICAOF left the server a primary")
+	}
+
+	// NO ONE makes it a primary again, keeping the keys it has
+	admin("no one")
+	if replica.replicating() {
+		t.Fatal("REPLICAOF NO ONE left the server a replica")
+	}
+	set := replica.handle(ctx, "test", protocol.Request{Action: protocol.ActionSet, Key: "mine", Value: "1"}, false)
+	if !set.Success {
+		t.Fatalf("SET after REPLICAOF NO ONE: %s", set.Message)
+	}
+	if value, _ := replicaKVS.GET("k"); value != "primary" {
+		t.Errorf("k = %q after REPLICAOF NO ONE, want the replicated value kept", value)
+	}
+	if _, found := primaryKVS.GET("mine"); found {
+		t.Error("a write to the former replica reached its primary")
+	}
+}
@@ -0,0 +1,512 @@
+package server
+
+import (
+	"sort"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// argument specs shared by several actions
+var (
+	argKey      = protocol.ArgSpec{Field: "Key", Summary: "the key", Required: true}
+	argOwner    = protocol.ArgSpec{Field: "Owner", Summary: "lock owner or subscriber name", Required: true}
+	argChecksum = protocol.ArgSpec{Field: "Checksum", Summary: "protocol.Checksum of Value, checked before it is stored"}
+	argChannel  = protocol.ArgSpec{Field: "Key", Summary: "the channel", Required: true}
+	argContinue = protocol.ArgSpec{Field: "Continue", Summary: "Continue token of the previous page, empty for the first"}
+	argReadTx   = protocol.ArgSpec{Field: "ReadTx", Summary: "id of a read transaction to read in, see BEGINREAD"}
+
+	argAtRevision = protocol.ArgSpec{Field: "Revision", Summary: "without a ReadTx, read as of this revision from the history the server keeps, see HISTORY"}
+
+	argCounterTTL   = protocol.ArgSpec{Field: "TTL", Summary: "TTL of the key if it is created, the server's default if zero"}
+	counterMessages = []string{protocol.MsgIncremented, protocol.MsgNotInteger, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}
+
+	listPushArgs = []protocol.ArgSpec{argKey,
+		{Field: "Values", Summary: "the items", Required: true},
+		{Field: "TTL", Summary: "TTL of the list if it is created, the server's default if zero"}}
+	argPopLimit        = protocol.ArgSpec{Field: "Limit", Summary: "how many items to remove, returned in Values; one in Value if zero"}
+	typedWriteMessages = []string{protocol.MsgInvalidArgument, protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}
+	listPopMessages    = []string{protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgReadOnly, protocol.MsgDiskFull}
+
+	argSetKeys    = protocol.ArgSpec{Field: "Keys", Summary: "the sets, a missing one being empty, at most protocol.MaxBatchKeys", Required: true}
+	setOpMessages = []string{protocol.MsgInvalidArgument, protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgCrossSlot}
+
+	argBitOffset = protocol.ArgSpec{Field: "Start", Summary: "the bit offset, 0 being the most significant bit of the first byte, at most protocol.MaxBitOffset"}
+
+	argJSONPath = protocol.ArgSpec{Field: "Path", Summary: "the part of the document, such as $.users[0].name, the whole of it if empty"}
+
+	argGroup            = protocol.ArgSpec{Field: "Group", Summary: "the consumer group", Required: true}
+	streamGroupMessages = []string{protocol.MsgInvalidArgument, protocol.MsgInvalidID, protocol.MsgNoGroup, protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}
+)
+
+// builtins describes every action the server handles itself; COMMANDS
+// serves it, and builtinActions, keyActions, writeActions and adminActions
+// are derived from it
+var builtins = []protocol.CommandSpec{
+	{Action: protocol.ActionHello, Summary: "negotiate the protocol: returns the server's version in Value and its capabilities in Values",
+		Args: []protocol.ArgSpec{{Field: "Value", Summary: "the client's protocol version"}}},
+	{Action: protocol.ActionPing, Summary: "check the server is serving: returns Value, or PONG if it is empty, in Value",
+		Args: []protocol.ArgSpec{{Field: "Value", Summary: "text to send back"}}},
+	{Action: protocol.ActionGet, Summary: "read a key: Found and the value in Value, with its Checksum and Revision", Keyed: true,
+		Args: []protocol.ArgSpec{argKey, argReadTx, argAtRevision,
+			{Field: "Owner", Summary: "client ID to track the key for, see INVALIDATIONS"}},
+		Messages: []string{protocol.MsgNotFound, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgCanceled, protocol.MsgNoReadTx, protocol.MsgVersionGone}},
+	{Action: protocol.ActionSet, Summary: "set a key, with its namespace's TTL if it has one and the request none", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the value"},
+			{Field: "TTL", Summary: "how long the key lives, the server's default if zero"},
+			argChecksum},
+		Messages: []string{protocol.MsgValueSet, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionUpdate, Summary: "replace the value of an existing key", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the new value"},
+			{Field: "TTL", Summary: "a new TTL from now, if above zero"},
+			{Field: "TTLMode", Summary: protocol.TTLKeep + " to keep the remaining lifetime, " + protocol.TTLReset + " to restart the TTL, empty for the server's default"},
+			argChecksum},
+		Messages: []string{protocol.MsgValueUpdated, protocol.MsgValueNotExist, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgInvalidTTL, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionDelete, Summary: "delete a key", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgValueDeleted, protocol.MsgValueNotExist, protocol.MsgWrongType, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionUndelete, Summary: "bring back a key the server soft deleted, with its value and remaining TTL, at a new Revision; Found unless it was not soft deleted or has been purged", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgValueRestored, protocol.MsgValueNotExist, protocol.MsgValueExists, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionType, Summary: "return the type of a key's value in Value, none and not Found if it does not exist", Keyed: true,
+		Args: []protocol.ArgSpec{argKey}},
+	{Action: protocol.ActionObject, Summary: "describe a key without its value, \"name: value\" lines in Values: type, revision, bytes, written, expires, ttl_ms and last_access if tracked; Found if it exists", Keyed: true,
+		Args: []protocol.ArgSpec{argKey}},
+	{Action: protocol.ActionMemoryUsage, Summary: "estimate the memory a key takes, the total bytes in Value and \"name: value\" lines in Values: key_bytes, value_bytes, overhead_bytes, total_bytes and namespace if it has one; Found if it exists", Keyed: true,
+		Args: []protocol.ArgSpec{argKey}},
+	{Action: protocol.ActionTouch, Summary: "restart the lifetime of a key from now without rewriting its value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "TTL", Summary: "the key's new TTL, the one it has if zero"}},
+		Messages: []string{protocol.MsgValueTouched, protocol.MsgValueNotExist, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionRename, Summary: "move the value of a key, with its TTL, to another key, replacing it if it exists", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{{Field: "Key", Summary: "the key to move", Required: true},
+			{Field: "Value", Summary: "the new key", Required: true}},
+		Messages: []string{protocol.MsgValueRenamed, protocol.MsgValueNotExist, protocol.MsgWrongType, protocol.MsgCrossSlot, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionCopy, Summary: "set another key to the value of a key, replacing it if it exists", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{{Field: "Key", Summary: "the key to copy", Required: true},
+			{Field: "Value", Summary: "the new key", Required: true},
+			{Field: "TTL", Summary: "the copy's TTL from now, the rest of the key's lifetime if zero"}},
+		Messages: []string{protocol.MsgValueCopied, protocol.MsgValueNotExist, protocol.MsgWrongType, protocol.MsgCrossSlot, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionSetNX, Summary: "set a key only if it does not exist: Success if it was written, Found if it already existed", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the value"},
+			{Field: "TTL", Summary: "how long the key lives, the server's default if zero"},
+			argChecksum},
+		Messages: []string{protocol.MsgValueSet, protocol.MsgValueExists, protocol.MsgIntegrity, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionCAS, Summary: "set a key only if its value is Expect or its revision Revision; on CONFLICT the current value and revision are in Value and Revision, Found if the key exists", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Expect", Summary: "the value the key must have"},
+			{Field: "Revision", Summary: "the revision the key must have, instead of Expect if above zero"},
+			{Field: "Value", Summary: "the new value"},
+			{Field: "TTL", Summary: "how long the key lives, the server's default if zero"},
+			argChecksum},
+		Messages: []string{protocol.MsgValueSet, protocol.MsgConflict, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionBeginRead, Summary: "open a read transaction: its id in Value and the Revision it reads at",
+		Args: []protocol.ArgSpec{{Field: "TTL", Summary: "how long it stays open, the server's default if zero, at most five minutes"}}},
+	{Action: protocol.ActionEndRead, Summary: "close a read transaction",
+		Args:     []protocol.ArgSpec{{Field: "ReadTx", Summary: "the transaction's id", Required: true}},
+		Messages: []string{protocol.MsgReadEnded, protocol.MsgNoReadTx}},
+	{Action: protocol.ActionHistory, Summary: "return the values a key has had, newest first, in Journal: the current one, then those the server keeps, Found if there are any", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Limit", Summary: "most entries returned, all if zero"}}},
+	{Action: protocol.ActionDiff, Summary: "compare the values a key had at two revisions line by line: -, + and space prefixed lines in Values, Found if they differ", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Revision", Summary: "the revision to compare from", Required: true},
+			{Field: "Value", Summary: "the revision to compare to, the current value if empty"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgIntegrity, protocol.MsgVersionGone}},
+	{Action: protocol.ActionMulti, Summary: "start a transaction on the connection: the requests on keys after it are queued until EXEC",
+		Messages: []string{protocol.MsgInMulti}},
+	{Action: protocol.ActionExec, Summary: "run the queued requests with no other client's in between, their responses in Replies",
+		Messages: []string{protocol.MsgNoMulti, protocol.MsgExecAborted, protocol.MsgConflict}},
+	{Action: protocol.ActionEval, Summary: "run a script on the server with no other client's request on keys in between, what it returns in Value or Values",
+		Args: []protocol.ArgSpec{{Field: "Value", Summary: "the script, in a subset of Lua", Required: true},
+			{Field: "Keys", Summary: "the keys it works on, KEYS in the script"},
+			{Field: "Values", Summary: "its arguments, ARGV in the script"}},
+		Messages: []string{protocol.MsgScriptError, protocol.MsgCanceled}},
+	{Action: protocol.ActionDiscard, Summary: "drop the queued requests and end the transaction",
+		Messages: []string{protocol.MsgNoMulti}},
+	{Action: protocol.ActionWatch, Summary: "make the next EXEC fail with CONFLICT if any of the keys is written first",
+		Args: []protocol.ArgSpec{{Field: "Key", Summary: "a key to watch"},
+			{Field: "Keys", Summary: "more keys to watch"}},
+		Messages: []string{protocol.MsgInMulti}},
+	{Action: protocol.ActionUnwatch, Summary: "stop watching every key"},
+	{Action: protocol.ActionGetSet, Summary: "set a key and return the value it replaced in Value, Found if there was one", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the new value"},
+			{Field: "TTL", Summary: "how long the key lives, the server's default if zero"},
+			argChecksum},
+		Messages: []string{protocol.MsgValueSet, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionGetDel, Summary: "delete a key and return its value: Found and the value in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgValueDeleted, protocol.MsgNotFound, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionIncr, Summary: "add one to the integer in a key, 0 if it is missing, and return the result in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, argCounterTTL},
+		Messages: counterMessages},
+	{Action: protocol.ActionDecr, Summary: "subtract one from the integer in a key, 0 if it is missing, and return the result in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, argCounterTTL},
+		Messages: counterMessages},
+	{Action: protocol.ActionIncrBy, Summary: "add to the integer in a key, 0 if it is missing, and return the result in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the amount to add as a base-10 integer, negative to subtract", Required: true},
+			argCounterTTL},
+		Messages: counterMessages},
+	{Action: protocol.ActionNextID, Summary: "hand out the next IDs of a sequence, starting it at 1 if the key is missing, and return the first in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Limit", Summary: "how many IDs to hand out, at most 1048576; one if zero"}},
+		Messages: []string{protocol.MsgIDsAllocated, protocol.MsgInvalidArgument, protocol.MsgNotInteger, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionAppend, Summary: "add to the end of a key's value, creating the key if it is missing, and return the new length in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the text to append"},
+			{Field: "TTL", Summary: "TTL of the key if it is created, the server's default if zero"}},
+		Messages: []string{protocol.MsgAppended, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionStrlen, Summary: "return the length in bytes of a key's value in Value, 0 if it is missing", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgCanceled}},
+	{Action: protocol.ActionSetBit, Summary: "set a bit of a key's value, creating the key if it is missing, and return what it was in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey, argBitOffset,
+			{Field: "Value", Summary: "the bit, 0 or 1", Required: true},
+			{Field: "TTL", Summary: "TTL of the key if it is created, the server's default if zero"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionGetBit, Summary: "return a bit of a key's value in Value, 0 past its end or if it is missing", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey, argBitOffset},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgCanceled}},
+	{Action: protocol.ActionBitCount, Summary: "return how many bits of a key's value are set in Value, 0 if it is missing", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgCanceled}},
+	{Action: protocol.ActionHSet, Summary: "set fields of a hash, creating it if it is missing, and return how many are new in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Keys", Summary: "the fields", Required: true},
+			{Field: "Values", Summary: "a value for each field", Required: true},
+			{Field: "TTL", Summary: "TTL of the key if it is created, the server's default if zero"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionHGet, Summary: "read a field of a hash: Found and the value in Value", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey, {Field: "Value", Summary: "the field", Required: true}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionHGetAll, Summary: "read a hash: Found and each field and its value, alternating in Values in field order", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionHDel, Summary: "remove fields of a hash, deleting it with its last field, and return how many it had in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, {Field: "Keys", Summary: "the fields", Required: true}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionLPush, Summary: "add items to the head of a list, the last first, creating it if it is missing, and return its length in Value", Keyed: true, Write: true,
+		Args:     listPushArgs,
+		Messages: typedWriteMessages},
+	{Action: protocol.ActionRPush, Summary: "add items to the tail of a list, creating it if it is missing, and return its length in Value", Keyed: true, Write: true,
+		Args:     listPushArgs,
+		Messages: typedWriteMessages},
+	{Action: protocol.ActionLPop, Summary: "remove an item from the head of a list, deleting it with its last item: Found and the item in Value, or up to Limit items in Values", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, argPopLimit},
+		Messages: listPopMessages},
+	{Action: protocol.ActionRPop, Summary: "remove an item from the tail of a list, deleting it with its last item: Found and the item in Value, or up to Limit items in Values", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, argPopLimit},
+		Messages: listPopMessages},
+	{Action: protocol.ActionBLPop, Summary: "LPOP that waits for an item while the list is empty, answering Found false if none comes", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey, argPopLimit,
+			{Field: "Timeout", Summary: "how long to wait for an item, DefaultPopWait if zero, at most MaxPopWait"}},
+		Messages: listPopMessages},
+	{Action: protocol.ActionLRange, Summary: "read items of a list: Found and the items from Start to Stop, both included, in Values", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Start", Summary: "index of the first item, negative to count from the end"},
+			{Field: "Stop", Summary: "index of the last item, negative to count from the end, -1 being the last"}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionSAdd, Summary: "add members to a set, creating it if it is missing, and return how many are new in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Values", Summary: "the members", Required: true},
+			{Field: "TTL", Summary: "TTL of the set if it is created, the server's default if zero"}},
+		Messages: typedWriteMessages},
+	{Action: protocol.ActionSRem, Summary: "remove members of a set, deleting it with its last member, and return how many it had in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, {Field: "Values", Summary: "the members", Required: true}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionSMembers, Summary: "read a set: Found and its members in Values, in order", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionSIsMember, Summary: "report in Found whether a set has a member", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey, {Field: "Value", Summary: "the member", Required: true}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionSUnion, Summary: "return the members of any of the sets in Values, in order",
+		Args:     []protocol.ArgSpec{argSetKeys},
+		Messages: setOpMessages},
+	{Action: protocol.ActionSInter, Summary: "return the members of all of the sets in Values, in order",
+		Args:     []protocol.ArgSpec{argSetKeys},
+		Messages: setOpMessages},
+	{Action: protocol.ActionSDiff, Summary: "return the members of the first set that none of the others has in Values, in order",
+		Args:     []protocol.ArgSpec{argSetKeys},
+		Messages: setOpMessages},
+	{Action: protocol.ActionZAdd, Summary: "set scores of members of a sorted set, creating it if it is missing, and return how many are new in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Keys", Summary: "the members", Required: true},
+			{Field: "Values", Summary: "a score for each member, as protocol.FormatScore writes it", Required: true},
+			{Field: "TTL", Summary: "TTL of the set if it is created, the server's default if zero"}},
+		Messages: typedWriteMessages},
+	{Action: protocol.ActionZRem, Summary: "remove members of a sorted set, deleting it with its last member, and return how many it had in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, {Field: "Keys", Summary: "the members", Required: true}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionZRange, Summary: "read members of a sorted set by index: Found and the members from Start to Stop, each followed by its score, in Values", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Start", Summary: "index of the first member, negative to count from the end"},
+			{Field: "Stop", Summary: "index of the last member, negative to count from the end, -1 being the last"}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionZRangeByScore, Summary: "read members of a sorted set by score: Found and the members scored from min to max, each followed by its score, in Values", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Values", Summary: "min and max, both included, -inf and +inf for no bound", Required: true},
+			{Field: "Limit", Summary: "the most members to return, all if zero"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionZRank, Summary: "return the index of a member of a sorted set, from 0 for the lowest score, in Value, and Found", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey, {Field: "Value", Summary: "the member", Required: true}},
+		Messages: []string{protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionGeoAdd, Summary: "set locations of members of a geo set, creating it if it is missing, and return how many are new in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Keys", Summary: "the members", Required: true},
+			{Field: "Values", Summary: "a longitude and a latitude in degrees for each member, in turn", Required: true},
+			{Field: "TTL", Summary: "TTL of the set if it is created, the server's default if zero"}},
+		Messages: typedWriteMessages},
+	{Action: protocol.ActionGeoSearch, Summary: "find members of a geo set within a circle or box: Found and the members nearest first, each followed by its distance in metres, longitude and latitude, in Values", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Values", Summary: "longitude and latitude of the centre, then a radius, or a width and height, in metres", Required: true},
+			{Field: "Limit", Summary: "the most members to return, all if zero"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionXAdd, Summary: "append an entry to a stream, creating it if it is missing, and return its ID in Value", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Keys", Summary: "the fields of the entry", Required: true},
+			{Field: "Values", Summary: "a value for each field", Required: true},
+			{Field: "Limit", Summary: "the most entries the stream keeps from now on, dropping the oldest; unchanged if zero"},
+			{Field: "TTL", Summary: "TTL of the stream if it is created, the server's default if zero"}},
+		Messages: typedWriteMessages},
+	{Action: protocol.ActionXRead, Summary: "read entries of a stream: Found and the entries after an ID in Stream", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the ID to read after, the start if empty"},
+			{Field: "Limit", Summary: "the most entries to return, all if zero"}},
+		Messages: []string{protocol.MsgInvalidID, protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionXGroup, Summary: "create a consumer group of a stream, creating the stream if it is missing", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey, argGroup,
+			{Field: "Value", Summary: "the ID the group delivers entries after, the stream's last if empty"},
+			{Field: "TTL", Summary: "TTL of the stream if it is created, the server's default if zero"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgInvalidID, protocol.MsgGroupExists, protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionXReadGroup, Summary: "deliver entries of a stream to a consumer of a group, which stay pending until XACK, in Stream", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey, argGroup,
+			{Field: "Owner", Summary: "the consumer", Required: true},
+			{Field: "Value", Summary: "empty for entries not delivered yet, else an ID to re-read the consumer's pending entries after"},
+			{Field: "Limit", Summary: "the most entries to return, all if zero"}},
+		Messages: streamGroupMessages},
+	{Action: protocol.ActionXAck, Summary: "acknowledge entries a group delivered and return how many were pending in Value", Keyed: true, Write: true,
+		Args:     []protocol.ArgSpec{argKey, argGroup, {Field: "Keys", Summary: "the entry IDs", Required: true}},
+		Messages: streamGroupMessages},
+	{Action: protocol.ActionJSONSet, Summary: "set part of a JSON document, or the whole of it, creating it if it is missing", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey, argJSONPath,
+			{Field: "Value", Summary: "the JSON to set", Required: true},
+			{Field: "TTL", Summary: "TTL of the document if it is created, the server's default if zero"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgInvalidJSON, protocol.MsgNoPath, protocol.MsgWrongType, protocol.MsgIntegrity, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionJSONGet, Summary: "read part of a JSON document: Found and the JSON in Value", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey, argJSONPath},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgWrongType, protocol.MsgIntegrity}},
+	{Action: protocol.ActionMGet, Summary: "read many keys, each key's value and whether it was found in Results",
+		Args:     []protocol.ArgSpec{{Field: "Keys", Summary: "the keys, at most protocol.MaxBatchKeys", Required: true}, argReadTx, argAtRevision},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgCanceled, protocol.MsgNoReadTx}},
+	{Action: protocol.ActionMSet, Summary: "set many keys, each key's outcome in Results", Write: true,
+		Args: []protocol.ArgSpec{{Field: "Keys", Summary: "the keys, at most protocol.MaxBatchKeys", Required: true},
+			{Field: "Values", Summary: "a value for each key", Required: true},
+			{Field: "TTL", Summary: "how long the keys live, the server's default if zero"},
+			{Field: "Checksums", Summary: "protocol.Checksum of each value, checked before it is stored"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionBatch, Summary: "set or delete many keys, all or none, each key's revision in Results", Write: true,
+		Args: []protocol.ArgSpec{{Field: "Keys", Summary: "the keys, at most protocol.MaxBatchKeys", Required: true},
+			{Field: "Values", Summary: "a value for each key, ignored for the keys deleted", Required: true},
+			{Field: "Deletes", Summary: "true for each key to delete rather than set"},
+			{Field: "TTL", Summary: "how long the keys set live, the server's default if zero"},
+			{Field: "Checksums", Summary: "protocol.Checksum of each value, checked before it is stored"}},
+		Messages: []string{protocol.MsgInvalidArgument, protocol.MsgIntegrity, protocol.MsgWrongType, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgCrossSlot, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionList, Summary: "list the keys and sub-directories directly under a directory in Entries, and the separator in Value",
+		Args: []protocol.ArgSpec{
+			{Field: "Key", Summary: "the directory, empty for the root"},
+			{Field: "Limit", Summary: "most entries returned, all if zero"}},
+		Messages: []string{protocol.MsgNoHierarchy}},
+	{Action: protocol.ActionScan, Summary: "return a page of the keys matching a pattern in Values, with More and the Continue token of the next page",
+		Args: []protocol.ArgSpec{
+			{Field: "Key", Summary: "glob pattern in path.Match syntax such as user:*, empty for all keys"},
+			argContinue,
+			{Field: "Value", Summary: "cursor returned by the previous page in Value, for clients not sending Continue"},
+			{Field: "Limit", Summary: "most keys to examine, the server's default if zero"}},
+		Messages: []string{protocol.MsgBadPattern, protocol.MsgInvalidArgument}},
+	{Action: protocol.ActionRange, Summary: "return the keys from one key up to another in order in Values, with More and the Continue token of the next page",
+		Args: []protocol.ArgSpec{
+			{Field: "Key", Summary: "the first key, empty for the start"},
+			{Field: "Value", Summary: "the key to stop before, empty for the end"},
+			{Field: "Limit", Summary: "most keys returned, all if zero"},
+			argContinue},
+		Messages: []string{protocol.MsgUnordered}},
+	{Action: protocol.ActionSearch, Summary: "return the keys whose values hold every word of a query, best first, each followed by its score, in Values, with More",
+		Args: []protocol.ArgSpec{
+			{Field: "Value", Summary: "the words", Required: true},
+			{Field: "Start", Summary: "how many matches to skip"},
+			{Field: "Limit", Summary: "most keys returned, the server's default if zero"}},
+		Messages: []string{protocol.MsgNoIndex, protocol.MsgInvalidArgument}},
+	{Action: protocol.ActionDBSize, Summary: "count the live keys in Value, and those of each namespace as \"prefix: count\" lines in Values"},
+	{Action: protocol.ActionRLock, Summary: "take a shared advisory lock on a key", Keyed: true,
+		Args: []protocol.ArgSpec{argKey, argOwner,
+			{Field: "Timeout", Summary: "how long to wait for the lock"},
+			{Field: "TTL", Summary: "lease after which the lock is released"}},
+		Messages: []string{protocol.MsgLockAcquired, protocol.MsgLockTimeout, protocol.MsgOwnerRequired, protocol.MsgCanceled}},
+	{Action: protocol.ActionWLock, Summary: "take an exclusive advisory lock on a key", Keyed: true,
+		Args: []protocol.ArgSpec{argKey, argOwner,
+			{Field: "Timeout", Summary: "how long to wait for the lock"},
+			{Field: "TTL", Summary: "lease after which the lock is released"}},
+		Messages: []string{protocol.MsgLockAcquired, protocol.MsgLockTimeout, protocol.MsgOwnerRequired, protocol.MsgCanceled}},
+	{Action: protocol.ActionUnlock, Summary: "release a lock", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Owner", Summary: "owner of the advisory locks to release, unless Revision is set"},
+			{Field: "Revision", Summary: "fencing token of the lease lock to release"}},
+		Messages: []string{protocol.MsgLockReleased, protocol.MsgLockNotHeld, protocol.MsgOwnerRequired, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionLock, Summary: "take a lease lock, a key naming its owner, and return its fencing token in Revision", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey, argOwner,
+			{Field: "TTL", Summary: "lease after which the lock is released, the server's lock lease if zero"}},
+		Messages: []string{protocol.MsgLockAcquired, protocol.MsgLocked, protocol.MsgOwnerRequired, protocol.MsgQuotaExceeded, protocol.MsgOutOfMemory, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionRenew, Summary: "restart the lease of a lease lock", Keyed: true, Write: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Revision", Summary: "fencing token of the lock", Required: true},
+			{Field: "TTL", Summary: "new lease, the lock's own if zero"}},
+		Messages: []string{protocol.MsgLockRenewed, protocol.MsgLockNotHeld, protocol.MsgReadOnly, protocol.MsgDiskFull}},
+	{Action: protocol.ActionLocks, Summary: "list the locks held in Values",
+		Args:     []protocol.ArgSpec{{Field: "Value", Summary: "LIST or empty"}},
+		Messages: []string{protocol.MsgInvalidAction}},
+	{Action: protocol.ActionWindowIncr, Summary: "add one to the current bucket of an expiring counter and return the bucket's count in Value", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "bucket width as a Go duration, e.g. 1m", Required: true},
+			{Field: "TTL", Summary: "how long buckets are kept"}},
+		Messages: []string{protocol.MsgInvalidWindow}},
+	{Action: protocol.ActionWindowSum, Summary: "add up the buckets of an expiring counter over a span, returned in Value", Keyed: true,
+		Args: []protocol.ArgSpec{argKey,
+			{Field: "Value", Summary: "the span as a Go duration, e.g. 1h", Required: true}},
+		Messages: []string{protocol.MsgInvalidWindow}},
+	{Action: protocol.ActionPublish, Summary: "queue a message for every durable subscriber of a channel; the count is returned in Value",
+		Args: []protocol.ArgSpec{argChannel,
+			{Field: "Value", Summary: "the payload"}}},
+	{Action: protocol.ActionSubscribe, Summary: "subscribe a durable subscriber to a channel or channel pattern",
+		Args:     []protocol.ArgSpec{{Field: "Key", Summary: "channel or pattern such as news.*", Required: true}, argOwner},
+		Messages: []string{protocol.MsgSubscribed, protocol.MsgOwnerRequired, protocol.MsgBadPattern}},
+	{Action: protocol.ActionUnsubscribe, Summary: "remove a subscription",
+		Args:     []protocol.ArgSpec{{Field: "Key", Summary: "channel or pattern", Required: true}, argOwner},
+		Messages: []string{protocol.MsgUnsubscribed, protocol.MsgNotSubscribed, protocol.MsgOwnerRequired}},
+	{Action: protocol.ActionFetch, Summary: "return a subscriber's unacknowledged messages in Messages",
+		Args: []protocol.ArgSpec{argOwner,
+			{Field: "Timeout", Summary: "how long to wait for a message if there is none"}},
+		Messages: []string{protocol.MsgNotSubscribed}},
+	{Action: protocol.ActionInvalidations, Summary: "return in Values the keys a client's tracked GETs read that changed since; INVALIDATE_ALL means drop them all",
+		Args: []protocol.ArgSpec{{Field: "Owner", Summary: "the client ID its GETs sent", Required: true},
+			{Field: "Timeout", Summary: "how long to wait for a change if there is none"}},
+		Messages: []string{protocol.MsgInvalidated, protocol.MsgInvalidateAll, protocol.MsgOwnerRequired, protocol.MsgCanceled}},
+	{Action: protocol.ActionAck, Summary: "acknowledge messages up to an id",
+		Args: []protocol.ArgSpec{argOwner,
+			{Field: "Value", Summary: "the message id", Required: true}},
+		Messages: []string{protocol.MsgAcked, protocol.MsgInvalidID, protocol.MsgNotSubscribed}},
+	{Action: protocol.ActionJournal, Summary: "return committed writes in Journal and the latest revision in Value",
+		Args: []protocol.ArgSpec{
+			{Field: "Value", Summary: "the revision to start after"},
+			{Field: "Limit", Summary: "most entries returned"},
+			{Field: "Timeout", Summary: "how long to wait for a write if there is none"}},
+		Messages: []string{protocol.MsgInvalidID}},
+	{Action: protocol.ActionSync, Summary: "return every key as a JSON snapshot in Value, the replication ID in Continue and its position in Revision, for a replica to load", Admin: true,
+		Args: []protocol.ArgSpec{
+			{Field: "Limit", Summary: "stream the snapshot in chunks of this many bytes, each with its Checksum"},
+			{Field: "Continue", Summary: "the token of the chunk before, while More is set"}},
+		Messages: []string{protocol.MsgReadOnly, protocol.MsgResync}},
+	{Action: protocol.ActionPSync, Summary: "return in Replication the writes after a replica's position and the latest position in Revision; RESYNC means load a snapshot again", Admin: true,
+		Args: []protocol.ArgSpec{
+			{Field: "Value", Summary: "the replication ID SYNC returned", Required: true},
+			{Field: "Revision", Summary: "the position to start after"},
+			{Field: "Limit", Summary: "most writes returned"},
+			{Field: "Timeout", Summary: "how long to wait for a write if there is none"}},
+		Messages: []string{protocol.MsgResync, protocol.MsgReadOnly}},
+	{Action: protocol.ActionWait, Summary: "wait for replicas to have every write so far; returns in Value how many have them",
+		Args: []protocol.ArgSpec{
+			{Field: "Limit", Summary: "how many replicas to wait for"},
+			{Field: "Timeout", Summary: "how long to wait, one second by default"}}},
+	{Action: protocol.ActionRole, Summary: "return the primary's address in Value, Found if it is this server and it takes writes, the failover epoch in Epoch and the replication status in Values"},
+	{Action: protocol.ActionVote, Summary: "vote for a candidate to become primary of the failover group; Success is the vote, Epoch the server's epoch", Admin: true,
+		Args: []protocol.ArgSpec{
+			{Field: "Value", Summary: "the candidate's address", Required: true},
+			{Field: "Epoch", Summary: "the epoch it stands in", Required: true},
+			{Field: "Revision", Summary: "the revision its store is at"},
+			{Field: "Key", Summary: "PRE to ask whether the server would vote, recording nothing"}},
+		Messages: []string{protocol.MsgInvalidAction}},
+	{Action: protocol.ActionPin, Summary: "protect a key from eviction; Found if it was pinned already", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgPinned}},
+	{Action: protocol.ActionUnpin, Summary: "remove a pin", Keyed: true,
+		Args:     []protocol.ArgSpec{argKey},
+		Messages: []string{protocol.MsgUnpinned, protocol.MsgNotPinned}},
+	{Action: protocol.ActionCluster, Summary: "return the cluster slot map, or the slots moving to or from the server, in Values",
+		Args:     []protocol.ArgSpec{{Field: "Value", Summary: "INFO or MIGRATIONS", Required: true}},
+		Messages: []string{protocol.MsgInvalidAction}},
+	{Action: protocol.ActionCommands, Summary: "describe the actions the server understands in Commands",
+		Args: []protocol.ArgSpec{{Field: "Key", Summary: "one action to describe, all if empty"}}},
+	{Action: protocol.ActionAdmin, Summary: "run an operational subcommand", Admin: true,
+		Args: []protocol.ArgSpec{
+			{Field: "Value", Summary: "SNAPSHOT, BGSAVE, REWRITEWAL, RESTORE, VERIFYBACKUP, BACKUPS, STATS, FLUSHCACHE, CLIENTS, SLOWLOG, LOGLEVEL, READONLY, NAMESPACES, MEMORY, KEYSPACE, DUMP, LOAD, FREEZE, REPLICAOF, SETSLOT, MIGRATE, IMPORT, EXPORT, TRASH or PURGE", Required: true},
+			{Field: "Key", Summary: "status for BGSAVE or REWRITEWAL, the file for RESTORE or VERIFYBACKUP, the level for LOGLEVEL, on or off for READONLY, samples for MEMORY, a JSON snapshot for LOAD, a duration or off for FREEZE, reset for SLOWLOG, a primary's address or no one for REPLICAOF, a slot range for SETSLOT or MIGRATE, a JSON snapshot or an export for IMPORT, json or csv for EXPORT, the key for PURGE, every one if empty"},
+			{Field: "Values", Summary: "replace, merge or missing for RESTORE; MIGRATING, IMPORTING, NODE or STABLE and an address for SETSLOT; json or csv for IMPORT of an export"},
+			{Field: "Limit", Summary: "how many keys MIGRATE moves"},
+			{Field: "Keys", Summary: "key patterns a RESTORE merge is limited to"}},
+		Messages: []string{protocol.MsgSnapshotWritten, protocol.MsgSnapshotStarted, protocol.MsgSnapshotRunning, protocol.MsgRewriteStarted, protocol.MsgRewriteRunning, protocol.MsgNoWAL, protocol.MsgRestored, protocol.MsgBackupVerified, protocol.MsgCacheFlushed, protocol.MsgInvalidPath, protocol.MsgInvalidLogLevel, protocol.MsgInvalidArgument, protocol.MsgInvalidAction}},
+	{Action: protocol.ActionDiagnose, Summary: "return a gzipped tar of diagnostics in Value, named after the time in Message", Admin: true},
+}
+
+// commonMessages can be the answer to any action, the last two only
+// inside MULTI
+var commonMessages = []string{protocol.MsgInvalidAction, protocol.MsgBadRequest, protocol.MsgMoved, protocol.MsgAsk, protocol.MsgTryAgain, protocol.MsgServerError, protocol.MsgAdminOnly, protocol.MsgUnauthorized, protocol.MsgDegraded, protocol.MsgRateLimited, protocol.MsgQueued, protocol.MsgNotQueueable}
+
+var (
+	// builtinActions are handled by the server itself and cannot be
+	// registered
+	builtinActions = make(map[string]bool)
+	// keyActions are the built-in actions routed by their Key in a cluster
+	keyActions = make(map[string]bool)
+	// writeActions are the built-in actions refused in read-only mode
+	writeActions = make(map[string]bool)
+	// adminActions are the built-in actions of the admin plane
+	adminActions = make(map[string]bool)
+)
+
+func init() {
+	for _, spec := range builtins {
+		builtinActions[spec.Action] = true
+		if spec.Keyed {
+			keyActions[spec.Action] = true
+		}
+		if spec.Write {
+			writeActions[spec.Action] = true
+		}
+		if spec.Admin {
+			adminActions[spec.Action] = true
+		}
+	}
+}
+
+// commandSpecs describes the built-in and custom actions, sorted by name;
+// with action set, only that one
+func commandSpecs(action string) []protocol.CommandSpec {
+	var specs []protocol.CommandSpec
+	for _, spec := range builtins {
+		if action == "" || spec.Action == action {
+			specs = append(specs, spec)
+		}
+	}
+	for _, name := range Commands() {
+		if action != "" && name != action {
+			continue
+		}
+		cmd, _ := command(name)
+		specs = append(specs, protocol.CommandSpec{
+			Action:   name,
+			Summary:  cmd.Summary,
+			Args:     cmd.Args,
+			Messages: cmd.Messages,
+			Keyed:    cmd.Keyed,
+			Write:    cmd.Write,
+			Admin:    cmd.Admin,
+			Custom:   true,
+		})
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Action < specs[j].Action })
+	return specs
+}
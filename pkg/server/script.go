@@ -0,0 +1,922 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// This file is the language of EVAL: a small subset of Lua, enough for
+// the conditional, multi-step logic scripts run next to the data. It has
+// nil, booleans, numbers, strings and the read-only lists KEYS and ARGV;
+// local and global assignment, which are the same, every variable being
+// the script's; if/elseif/else, while, numeric for and return; and the
+// functions call, tonumber, tostring and error. There are no tables,
+// closures or libraries, so a script can reach nothing but call, and a
+// step budget stops one that would run away.
+
+// scriptValue is nil, bool, float64, string or []scriptValue
+type scriptValue any
+
+// scriptError is a script that failed to parse or run
+type scriptError struct {
+	line int
+	msg  string
+}
+
+func (e *scriptError) Error() string {
+	if e.line == 0 {
+		return e.msg
+	}
+	return fmt.Sprintf("line %d: %s", e.line, e.msg)
+}
+
+func scriptErrorf(line int, format string, args ...any) error {
+	return &scriptError{line: line, msg: fmt.Sprintf(format, args...)}
+}
+
+// tokens
+
+type tokenKind int
+
+const (
+	tokEOF tokenKind = iota
+	tokName
+	tokNumber
+	tokString
+	tokOp // operators, punctuation and keywords
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	num  float64
+	line int
+}
+
+var scriptKeywords = map[string]bool{
+	"and": true, "do": true, "else": true, "elseif": true, "end": true, "false": true, "for": true,
+	"if": true, "local": true, "nil": true, "not": true, "or": true, "return": true, "then": true,
+	"true": true, "while": true,
+}
+
+// lexScript splits src into tokens
+func lexScript(src string) ([]token, error) {
+	var toks []token
+	line := 1
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '\n':
+			line++
+			i++
+		case c == ' ' || c == '\t' || c == '\r' || c == ';':
+			i++
+		case strings.HasPrefix(src[i:], "--"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			kind := tokName
+			if scriptKeywords[src[i:j]] {
+				kind = tokOp
+			}
+			toks = append(toks, token{kind: kind, text: src[i:j], line: line})
+			i = j
+		case c >= '0' && c <= '9' || c == '.' && i+1 < len(src) && src[i+1] >= '0' && src[i+1] <= '9':
+			j := i
+			for j < len(src) && (src[j] >= '0' && src[j] <= '9' || src[j] == '.' || src[j] == 'e' || src[j] == 'E' ||
+				(src[j] == '-' || src[j] == '+') && (src[j-1] == 'e' || src[j-1] == 'E')) {
+				j++
+			}
+			n, err := strconv.ParseFloat(src[i:j], 64)
+			if err != nil {
+				return nil, scriptErrorf(line, "malformed number %q", src[i:j])
+			}
+			toks = append(toks, token{kind: tokNumber, num: n, line: line})
+			i = j
+		case c == '"' || c == '\'':
+			var b strings.Builder
+			j := i + 1
+			for ; j < len(src) && src[j] != c; j++ {
+				if src[j] == '\n' {
+					return nil, scriptErrorf(line, "unfinished string")
+				}
+				if src[j] != '\\' {
+					b.WriteByte(src[j])
+					continue
+				}
+				j++
+				if j == len(src) {
+					break
+				}
+				switch src[j] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				case 'r':
+					b.WriteByte('\r')
+				case '\\', '"', '\'':
+					b.WriteByte(src[j])
+				default:
+					return nil, scriptErrorf(line, "invalid escape \\%c", src[j])
+				}
+			}
+			if j >= len(src) {
+				return nil, scriptErrorf(line, "unfinished string")
+			}
+			toks = append(toks, token{kind: tokString, text: b.String(), line: line})
+			i = j + 1
+		default:
+			op := ""
+			for _, o := range []string{"==", "~=", "<=", ">=", "..", "+", "-", "*", "/", "%", "<", ">", "=", "(", ")", "[", "]", ",", "#"} {
+				if strings.HasPrefix(src[i:], o) {
+					op = o
+					break
+				}
+			}
+			if op == "" {
+				return nil, scriptErrorf(line, "unexpected %q", c)
+			}
+			toks = append(toks, token{kind: tokOp, text: op, line: line})
+			i += len(op)
+		}
+	}
+	return append(toks, token{kind: tokEOF, line: line}), nil
+}
+
+// syntax tree
+
+type (
+	scriptExpr any
+	scriptStmt any
+
+	litExpr   struct{ v scriptValue }
+	nameExpr  struct{ name string }
+	indexExpr struct {
+		x, i scriptExpr
+		line int
+	}
+	callExpr struct {
+		fn   string
+		args []scriptExpr
+		line int
+	}
+	unaryExpr struct {
+		op   string
+		x    scriptExpr
+		line int
+	}
+	binaryExpr struct {
+		op   string
+		x, y scriptExpr
+		line int
+	}
+
+	assignStmt struct {
+		name string
+		x    scriptExpr
+	}
+	callStmt struct{ call *callExpr }
+	ifStmt   struct {
+		conds  []scriptExpr
+		blocks [][]scriptStmt
+		els    []scriptStmt
+	}
+	whileStmt struct {
+		cond scriptExpr
+		body []scriptStmt
+	}
+	forStmt struct {
+		name           string
+		from, to, step scriptExpr
+		body           []scriptStmt
+		line           int
+	}
+	returnStmt struct{ xs []scriptExpr }
+)
+
+// scriptParser is a recursive descent parser over the tokens of a script
+type scriptParser struct {
+	toks []token
+	pos  int
+}
+
+// parseScript parses the source of a script
+func parseScript(src string) ([]scriptStmt, error) {
+	toks, err := lexScript(src)
+	if err != nil {
+		return nil, err
+	}
+	p := &scriptParser{toks: toks}
+	block, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.kind != tokEOF {
+		return nil, scriptErrorf(t.line, "unexpected %s", t)
+	}
+	return block, nil
+}
+
+func (t token) String() string {
+	switch t.kind {
+	case tokEOF:
+		return "end of script"
+	case tokNumber:
+		return formatScriptNumber(t.num)
+	case tokString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+func (p *scriptParser) peek() token { return p.toks[p.pos] }
+
+func (p *scriptParser) next() token {
+	t := p.toks[p.pos]
+	if t.kind != tokEOF {
+		p.pos++
+	}
+	return t
+}
+
+// is reports whether the next token is the operator or keyword op
+func (p *scriptParser) is(op string) bool {
+	t := p.peek()
+	return t.kind == tokOp && t.text == op
+}
+
+func (p *scriptParser) expect(op string) error {
+	if t := p.next(); t.kind != tokOp || t.text != op {
+		return scriptErrorf(t.line, "want '%s', got %s", op, t)
+	}
+	return nil
+}
+
+func (p *scriptParser) name() (string, error) {
+	t := p.next()
+	if t.kind != tokName {
+		return "", scriptErrorf(t.line, "want a name, got %s", t)
+	}
+	return t.text, nil
+}
+
+// block parses statements up to end, else, elseif or the end of the script
+func (p *scriptParser) block() ([]scriptStmt, error) {
+	var stmts []scriptStmt
+	for {
+		if t := p.peek(); t.kind == tokEOF || p.is("end") || p.is("else") || p.is("elseif") {
+			return stmts, nil
+		}
+		s, err := p.statement()
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+		if _, ok := s.(returnStmt); ok {
+			return stmts, nil
+		}
+	}
+}
+
+func (p *scriptParser) statement() (scriptStmt, error) {
+	t := p.peek()
+	switch {
+	case p.is("local"):
+		p.next()
+		name, err := p.name()
+		if err != nil {
+			return nil, err
+		}
+		if !p.is("=") {
+			return assignStmt{name: name, x: litExpr{}}, nil
+		}
+		p.next()
+		x, err := p.expr(0)
+		return assignStmt{name: name, x: x}, err
+	case p.is("if"):
+		return p.ifStatement()
+	case p.is("while"):
+		p.next()
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		body, err := p.doBlock()
+		return whileStmt{cond: cond, body: body}, err
+	case p.is("for"):
+		return p.forStatement()
+	case p.is("return"):
+		p.next()
+		var xs []scriptExpr
+		if t := p.peek(); t.kind == tokEOF || p.is("end") || p.is("else") || p.is("elseif") {
+			return returnStmt{}, nil
+		}
+		for {
+			x, err := p.expr(0)
+			if err != nil {
+				return nil, err
+			}
+			xs = append(xs, x)
+			if !p.is(",") {
+				return returnStmt{xs: xs}, nil
+			}
+			p.next()
+		}
+	case t.kind == tokName:
+		p.next()
+		if p.is("=") {
+			p.next()
+			x, err := p.expr(0)
+			return assignStmt{name: t.text, x: x}, err
+		}
+		if p.is("(") {
+			call, err := p.call(t)
+			return callStmt{call: call}, err
+		}
+	}
+	return nil, scriptErrorf(t.line, "unexpected %s", t)
+}
+
+func (p *scriptParser) ifStatement() (scriptStmt, error) {
+	var s ifStmt
+	for p.is("if") || p.is("elseif") {
+		p.next()
+		cond, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("then"); err != nil {
+			return nil, err
+		}
+		block, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.conds, s.blocks = append(s.conds, cond), append(s.blocks, block)
+	}
+	if p.is("else") {
+		p.next()
+		els, err := p.block()
+		if err != nil {
+			return nil, err
+		}
+		s.els = els
+	}
+	return s, p.expect("end")
+}
+
+func (p *scriptParser) forStatement() (scriptStmt, error) {
+	line := p.next().line
+	name, err := p.name()
+	if err != nil {
+		return nil, err
+	}
+	s := forStmt{name: name, step: litExpr{float64(1)}, line: line}
+	if err := p.expect("="); err != nil {
+		return nil, err
+	}
+	if s.from, err = p.expr(0); err != nil {
+		return nil, err
+	}
+	if err := p.expect(","); err != nil {
+		return nil, err
+	}
+	if s.to, err = p.expr(0); err != nil {
+		return nil, err
+	}
+	if p.is(",") {
+		p.next()
+		if s.step, err = p.expr(0); err != nil {
+			return nil, err
+		}
+	}
+	s.body, err = p.doBlock()
+	return s, err
+}
+
+// doBlock parses do block end
+func (p *scriptParser) doBlock() ([]scriptStmt, error) {
+	if err := p.expect("do"); err != nil {
+		return nil, err
+	}
+	body, err := p.block()
+	if err != nil {
+		return nil, err
+	}
+	return body, p.expect("end")
+}
+
+// call parses the arguments of a call to the function named by t
+func (p *scriptParser) call(t token) (*callExpr, error) {
+	c := &callExpr{fn: t.text, line: t.line}
+	p.next() // (
+	for !p.is(")") {
+		x, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		c.args = append(c.args, x)
+		if !p.is(",") {
+			break
+		}
+		p.next()
+	}
+	return c, p.expect(")")
+}
+
+// binary operators by precedence, as in Lua; .. is right associative
+var scriptPrecedence = map[string]int{
+	"or": 1, "and": 2,
+	"<": 3, ">": 3, "<=": 3, ">=": 3, "~=": 3, "==": 3,
+	"..": 4,
+	"+":  5, "-": 5,
+	"*": 6, "/": 6, "%": 6,
+}
+
+// unaryPrecedence binds tighter than every binary operator
+const unaryPrecedence = 7
+
+// expr parses an expression of binary operators binding tighter than min
+func (p *scriptParser) expr(min int) (scriptExpr, error) {
+	x, err := p.unary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec, ok := scriptPrecedence[t.text]
+		if t.kind != tokOp || !ok || prec <= min {
+			return x, nil
+		}
+		p.next()
+		next := prec
+		if t.text == ".." {
+			next--
+		}
+		y, err := p.expr(next)
+		if err != nil {
+			return nil, err
+		}
+		x = binaryExpr{op: t.text, x: x, y: y, line: t.line}
+	}
+}
+
+func (p *scriptParser) unary() (scriptExpr, error) {
+	if t := p.peek(); p.is("not") || p.is("-") || p.is("#") {
+		p.next()
+		x, err := p.expr(unaryPrecedence)
+		return unaryExpr{op: t.text, x: x, line: t.line}, err
+	}
+	return p.postfix()
+}
+
+func (p *scriptParser) postfix() (scriptExpr, error) {
+	x, err := p.primary()
+	if err != nil {
+		return nil, err
+	}
+	for p.is("[") {
+		line := p.next().line
+		i, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		x = indexExpr{x: x, i: i, line: line}
+	}
+	return x, nil
+}
+
+func (p *scriptParser) primary() (scriptExpr, error) {
+	t := p.next()
+	switch t.kind {
+	case tokNumber:
+		return litExpr{t.num}, nil
+	case tokString:
+		return litExpr{t.text}, nil
+	case tokName:
+		if p.is("(") {
+			return p.call(t)
+		}
+		return nameExpr{t.text}, nil
+	}
+	switch t.text {
+	case "nil":
+		return litExpr{}, nil
+	case "true":
+		return litExpr{true}, nil
+	case "false":
+		return litExpr{false}, nil
+	case "(":
+		x, err := p.expr(0)
+		if err != nil {
+			return nil, err
+		}
+		return x, p.expect(")")
+	}
+	return nil, scriptErrorf(t.line, "unexpected %s", t)
+}
+
+// running
+
+// errScriptSteps is a script that ran over its step budget
+var errScriptSteps = errors.New("script ran too long")
+
+// errScriptBytes is a script that built more strings than its byte budget
+var errScriptBytes = errors.New("script built too much text")
+
+// scriptRun is the state of a running script
+type scriptRun struct {
+	ctx   context.Context
+	vars  map[string]scriptValue
+	steps int // left
+	bytes int // left for the strings it builds
+	// call runs a request for the script's call function
+	call func(args []scriptValue) (scriptValue, error)
+}
+
+// run runs the statements of a parsed script and returns what it returned
+func (r *scriptRun) run(stmts []scriptStmt) ([]scriptValue, error) {
+	ret, _, err := r.block(stmts)
+	return ret, err
+}
+
+// block runs stmts and reports whether one of them returned
+func (r *scriptRun) block(stmts []scriptStmt) ([]scriptValue, bool, error) {
+	for _, s := range stmts {
+		if err := r.step(); err != nil {
+			return nil, false, err
+		}
+		switch s := s.(type) {
+		case assignStmt:
+			if s.name == "KEYS" || s.name == "ARGV" {
+				return nil, false, scriptErrorf(0, "%s is read-only", s.name)
+			}
+			v, err := r.eval(s.x)
+			if err != nil {
+				return nil, false, err
+			}
+			r.vars[s.name] = v
+		case callStmt:
+			if _, err := r.eval(s.call); err != nil {
+				return nil, false, err
+			}
+		case ifStmt:
+			ran := false
+			for i, cond := range s.conds {
+				v, err := r.eval(cond)
+				if err != nil {
+					return nil, false, err
+				}
+				if truthy(v) {
+					ran = true
+					if ret, done, err := r.block(s.blocks[i]); done || err != nil {
+						return ret, done, err
+					}
+					break
+				}
+			}
+			if !ran {
+				if ret, done, err := r.block(s.els); done || err != nil {
+					return ret, done, err
+				}
+			}
+		case whileStmt:
+			for {
+				if err := r.step(); err != nil {
+					return nil, false, err
+				}
+				v, err := r.eval(s.cond)
+				if err != nil {
+					return nil, false, err
+				}
+				if !truthy(v) {
+					break
+				}
+				if ret, done, err := r.block(s.body); done || err != nil {
+					return ret, done, err
+				}
+			}
+		case forStmt:
+			var bounds [3]float64
+			for i, x := range []scriptExpr{s.from, s.to, s.step} {
+				v, err := r.eval(x)
+				if err != nil {
+					return nil, false, err
+				}
+				n, ok := toNumber(v)
+				if !ok {
+					return nil, false, scriptErrorf(s.line, "'for' bound is not a number")
+				}
+				bounds[i] = n
+			}
+			from, to, step := bounds[0], bounds[1], bounds[2]
+			if step == 0 {
+				return nil, false, scriptErrorf(s.line, "'for' step is zero")
+			}
+			for i := from; step > 0 && i <= to || step < 0 && i >= to; i += step {
+				if err := r.step(); err != nil {
+					return nil, false, err
+				}
+				r.vars[s.name] = i
+				if ret, done, err := r.block(s.body); done || err != nil {
+					return ret, done, err
+				}
+			}
+		case returnStmt:
+			ret := make([]scriptValue, len(s.xs))
+			for i, x := range s.xs {
+				v, err := r.eval(x)
+				if err != nil {
+					return nil, false, err
+				}
+				ret[i] = v
+			}
+			return ret, true, nil
+		}
+	}
+	return nil, false, nil
+}
+
+// step takes one step of the budget, each statement and loop iteration
+// costing one, and stops the script when it is spent or ctx is done
+func (r *scriptRun) step() error {
+	if r.steps--; r.steps < 0 {
+		return errScriptSteps
+	}
+	return r.ctx.Err()
+}
+
+// alloc takes n bytes of the budget for a string the script builds, and
+// stops the script when it is spent, so it cannot double a string until
+// the server runs out of memory in a few steps
+func (r *scriptRun) alloc(n int) error {
+	if r.bytes -= n; r.bytes < 0 {
+		return errScriptBytes
+	}
+	return nil
+}
+
+func (r *scriptRun) eval(x scriptExpr) (scriptValue, error) {
+	switch x := x.(type) {
+	case litExpr:
+		return x.v, nil
+	case nameExpr:
+		return r.vars[x.name], nil
+	case indexExpr:
+		v, err := r.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		i, err := r.eval(x.i)
+		if err != nil {
+			return nil, err
+		}
+		list, ok := v.([]scriptValue)
+		if !ok {
+			return nil, scriptErrorf(x.line, "cannot index a %s", scriptType(v))
+		}
+		n, ok := toNumber(i)
+		if !ok || n != math.Trunc(n) || n < 1 || n > float64(len(list)) {
+			return nil, nil
+		}
+		return list[int(n)-1], nil
+	case *callExpr:
+		args := make([]scriptValue, len(x.args))
+		for i, a := range x.args {
+			v, err := r.eval(a)
+			if err != nil {
+				return nil, err
+			}
+			args[i] = v
+		}
+		v, err := r.builtin(x.fn, args)
+		var serr *scriptError
+		if err != nil && errors.As(err, &serr) && serr.line == 0 {
+			serr.line = x.line
+		}
+		return v, err
+	case unaryExpr:
+		v, err := r.eval(x.x)
+		if err != nil {
+			return nil, err
+		}
+		switch x.op {
+		case "not":
+			return !truthy(v), nil
+		case "#":
+			switch v := v.(type) {
+			case string:
+				return float64(len(v)), nil
+			case []scriptValue:
+				return float64(len(v)), nil
+			}
+			return nil, scriptErrorf(x.line, "cannot take the length of a %s", scriptType(v))
+		}
+		n, ok := toNumber(v)
+		if !ok {
+			return nil, scriptErrorf(x.line, "cannot negate a %s", scriptType(v))
+		}
+		return -n, nil
+	case binaryExpr:
+		return r.binary(x)
+	}
+	return nil, fmt.Errorf("unknown expression %T", x)
+}
+
+func (r *scriptRun) binary(x binaryExpr) (scriptValue, error) {
+	a, err := r.eval(x.x)
+	if err != nil {
+		return nil, err
+	}
+	// and and or short-circuit, returning an operand as in Lua
+	switch x.op {
+	case "and":
+		if !truthy(a) {
+			return a, nil
+		}
+		return r.eval(x.y)
+	case "or":
+		if truthy(a) {
+			return a, nil
+		}
+		return r.eval(x.y)
+	}
+	b, err := r.eval(x.y)
+	if err != nil {
+		return nil, err
+	}
+	switch x.op {
+	case "==":
+		return scriptEqual(a, b), nil
+	case "~=":
+		return !scriptEqual(a, b), nil
+	case "..":
+		sa, oka := concatString(a)
+		sb, okb := concatString(b)
+		if !oka || !okb {
+			return nil, scriptErrorf(x.line, "cannot concatenate a %s and a %s", scriptType(a), scriptType(b))
+		}
+		if err := r.alloc(len(sa) + len(sb)); err != nil {
+			return nil, err
+		}
+		return sa + sb, nil
+	case "<", ">", "<=", ">=":
+		var c int
+		na, oka := a.(float64)
+		nb, okb := b.(float64)
+		sa, osa := a.(string)
+		sb, osb := b.(string)
+		switch {
+		case oka && okb:
+			c = cmpFloat(na, nb)
+		case osa && osb:
+			c = strings.Compare(sa, sb)
+		default:
+			return nil, scriptErrorf(x.line, "cannot compare a %s with a %s", scriptType(a), scriptType(b))
+		}
+		switch x.op {
+		case "<":
+			return c < 0, nil
+		case ">":
+			return c > 0, nil
+		case "<=":
+			return c <= 0, nil
+		}
+		return c >= 0, nil
+	}
+	na, oka := toNumber(a)
+	nb, okb := toNumber(b)
+	if !oka || !okb {
+		return nil, scriptErrorf(x.line, "cannot do arithmetic on a %s and a %s", scriptType(a), scriptType(b))
+	}
+	switch x.op {
+	case "+":
+		return na + nb, nil
+	case "-":
+		return na - nb, nil
+	case "*":
+		return na * nb, nil
+	case "/":
+		return na / nb, nil
+	}
+	return na - math.Floor(na/nb)*nb, nil // %, floored as in Lua
+}
+
+// builtin calls the function fn of the script language
+func (r *scriptRun) builtin(fn string, args []scriptValue) (scriptValue, error) {
+	arg := func(i int) scriptValue {
+		if i < len(args) {
+			return args[i]
+		}
+		return nil
+	}
+	switch fn {
+	case "call":
+		return r.call(args)
+	case "tonumber":
+		if n, ok := toNumber(arg(0)); ok {
+			return n, nil
+		}
+		return nil, nil
+	case "tostring":
+		return scriptString(arg(0)), nil
+	case "error":
+		return nil, &scriptError{msg: scriptString(arg(0))}
+	}
+	return nil, scriptErrorf(0, "unknown function %s", fn)
+}
+
+func truthy(v scriptValue) bool {
+	return v != nil && v != false
+}
+
+func scriptEqual(a, b scriptValue) bool {
+	switch a.(type) {
+	case []scriptValue:
+		return false // lists are only ever KEYS and ARGV
+	}
+	return a == b
+}
+
+func cmpFloat(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// toNumber is v as a number, converting strings as Lua does in arithmetic
+func toNumber(v scriptValue) (float64, bool) {
+	switch v := v.(type) {
+	case float64:
+		return v, true
+	case string:
+		n, err := strconv.ParseFloat(strings.TrimSpace(v), 64)
+		return n, err == nil
+	}
+	return 0, false
+}
+
+func concatString(v scriptValue) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return formatScriptNumber(v), true
+	}
+	return "", false
+}
+
+// scriptString is v as tostring gives it
+func scriptString(v scriptValue) string {
+	switch v := v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return strconv.FormatBool(v)
+	case float64:
+		return formatScriptNumber(v)
+	case string:
+		return v
+	}
+	return scriptType(v)
+}
+
+// formatScriptNumber prints whole numbers without a fraction, so INCR
+// arguments and the like come out as integers
+func formatScriptNumber(n float64) string {
+	if n == math.Trunc(n) && math.Abs(n) < 1e15 {
+		return strconv.FormatInt(int64(n), 10)
+	}
+	return strconv.FormatFloat(n, 'g', -1, 64)
+}
+
+func scriptType(v scriptValue) string {
+	switch v.(type) {
+	case nil:
+		return "nil"
+	case bool:
+		return "boolean"
+	case float64:
+		return "number"
+	case string:
+		return "string"
+	}
+	return "list"
+}
@@ -0,0 +1,34 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// runScript runs src with the budgets of EVAL and no call function
+func runScript(t *testing.T, src string) ([]scriptValue, error) {
+	t.Helper()
+	script, err := parseScript(src)
+	if err != nil {
+		t.Fatalf("parse %q: %v", src, err)
+	}
+	r := &scriptRun{ctx: context.Background(), vars: map[string]scriptValue{}, steps: protocol.MaxScriptSteps, bytes: protocol.MaxScriptBytes}
+	return r.run(script)
+}
+
+func TestScriptConcat(t *testing.T) {
+	ret, err := runScript(t, `local s = "a" for i = 1, 3 do s = s .. i end return s`)
+	if err != nil || len(ret) != 1 || ret[0] != "a123" {
+		t.Fatalf("got %v, %v; want a123", ret, err)
+	}
+}
+
+func TestScriptConcatByteBudget(t *testing.T) {
+	_, err := runScript(t, `local s = "x" for i = 1, 40 do s = s .. s end return #s`)
+	if !errors.Is(err, errScriptBytes) {
+		t.Fatalf("doubling a string 40 times: got %v, want %v", err, errScriptBytes)
+	}
+}
@@ -0,0 +1,1858 @@
+// Package server serves a kvstore over TCP using the types in pkg/protocol,
+// gob-encoded, or in JSON on listeners whose address starts with json://.
+package server
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultAddrs are the addresses the server accepts clients on; :8080 is
+// kept for clients built against the older single-file server
+var DefaultAddrs = []string{":8081", ":8080"}
+
+// IdleTimeout is how long a client connection may wait between requests
+const IdleTimeout = 5 * time.Minute
+
+// DefaultSearchLimit is how many keys a page of SEARCH holds when not told
+const DefaultSearchLimit = 10
+
+// ShutdownTimeouts bounds the phases of Stop; zero means no bound.
+type ShutdownTimeouts struct {
+	Drain    time.Duration // for in-flight requests, then connections are cut
+	Sync     time.Duration // for the final fsync of the journal, pub/sub log and persister
+	Snapshot time.Duration // for closing the persister, e.g. the final backup
+}
+
+// DefaultShutdownTimeouts are the phase bounds of a new Server
+var DefaultShutdownTimeouts = ShutdownTimeouts{Drain: 10 * time.Second, Sync: 5 * time.Second, Snapshot: 10 * time.Second}
+
+// Files names where a Server keeps its journal and pub/sub log; empty
+// keeps that one in memory only. Cipher, if not nil, encrypts both, see
+// kvstore.FileCipher; the persister takes its own from the store's
+// SetFileCipher.
+type Files struct {
+	Journal string
+	PubSub  string
+	Cipher  *kvstore.FileCipher
+}
+
+// DefaultFiles are the files of a new Server, in its working directory
+var DefaultFiles = Files{Journal: kvstore.JournalFile, PubSub: kvstore.PubSubLogFile}
+
+// Server owns the store, the proxy, the background janitor and backup
+// worker and the listeners, and starts and stops them together.
+type Server struct {
+	kvs         *kvstore.KeyValueStore
+	proxy       *kvstore.ServerProxy
+	locks       *kvstore.LockManager
+	pubsub      *kvstore.PubSub
+	journal     *kvstore.Journal
+	cluster     *cluster
+	writeMu     sync.Mutex // guards the journal order and the fields below
+	coalesce    []CoalesceRule
+	pending     map[string]*pendingSet
+	addrs       []string
+	started     time.Time
+	shutdown    ShutdownTimeouts
+	files       Files
+	persist     kvstore.Persister
+	preload     kvstore.Preload
+	flags       []string // see SetFlags
+	adminPlane  AdminPlane
+	readOnly    atomic.Bool
+	frozenUntil atomic.Int64  // unix nanoseconds, see ADMIN FREEZE
+	coalesced   atomic.Uint64 // SETs merged into a later journal entry
+	disk        DiskGuard
+	diskFree    atomic.Uint64 // least free bytes at the last check
+	diskLow     atomic.Bool
+	diskErr     atomic.Bool // the last check failed
+	slo         SLOGuard
+	latencies   latencies
+	degraded    atomic.Bool // SLOs missed, SLOGuard.Degrade in force
+	reads       readTxs
+	multiMu     sync.RWMutex // held exclusively by EXEC, see holdsOffExec
+	slowLog     slowLog
+	metricsPush MetricsPush
+	changes     *changeFeed // see SetChangeSink
+	healthAddr  string
+	health      *http.Server
+	ready       atomic.Bool  // the data is restored and the listeners open
+	maxRequest  int64        // see SetMaxRequestSize
+	maxKeyLen   atomic.Int64 // see SetMaxKeyLen
+	dedup       dedup        // see SetDedupWindow
+	keyHits     keyHits      // see SetKeyHits
+
+	replica   atomic.Pointer[replica] // the link to the primary, nil on a primary
+	replicaMu sync.Mutex              // serializes link
+	failover  *failover               // see SetFailoverGroup
+	multi     *multiPrimary           // see SetMultiPrimary
+
+	middleware     []Middleware            // see Use
+	addrMiddleware map[string][]Middleware // see UseOn
+
+	mu           sync.Mutex
+	running      bool
+	ctx          context.Context // Start's, for workers started later
+	cancel       context.CancelFunc
+	replicaOf    string                 // see ReplicaOf
+	replicas     map[string]seenReplica // by client address, see PSYNC
+	acked        chan struct{}          // closed at the next PSYNC, nil if nobody waits
+	replicaCount int                    // see SetReplicas
+	syncs        map[string]*syncStream // snapshots being streamed, see SYNC
+	listeners    []codecListener
+	conns        map[net.Conn]*clientConn
+	closing      bool
+	wg           sync.WaitGroup // background workers
+	acceptWg     sync.WaitGroup
+	connWg       sync.WaitGroup
+}
+
+// create instance of server listening on addrs
+func NewServer(addrs ...string) *Server {
+	return NewServerWithStore(kvstore.NewKeyValueStore(), addrs...)
+}
+
+// NewServerWithStore serves an existing store, e.g. one that is also used in-process.
+// An address may name the codec its connections speak, as in "json://:8082",
+// see protocol.SplitAddr; the default is gob.
+func NewServerWithStore(kvs *kvstore.KeyValueStore, addrs ...string) *Server {
+	return &Server{
+		kvs:      kvs,
+		proxy:    kvstore.NewServerProxy(kvs),
+		locks:    kvstore.NewLockManager(),
+		addrs:    addrs,
+		started:  time.Now(),
+		shutdown: DefaultShutdownTimeouts,
+		files:    DefaultFiles,
+		persist:  kvstore.SnapshotPersister{},
+		pending:  make(map[string]*pendingSet),
+		conns:    make(map[net.Conn]*clientConn),
+		replicas: make(map[string]seenReplica),
+		syncs:    make(map[string]*syncStream),
+		dedup:    dedup{window: DefaultDedupWindow},
+	}
+}
+
+// SetShutdownTimeouts changes the phase bounds used by Stop
+func (s *Server) SetShutdownTimeouts(t ShutdownTimeouts) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.shutdown = t
+}
+
+// SetFiles changes where the journal and pub/sub log are kept; call it
+// before Start
+func (s *Server) SetFiles(files Files) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.files = files
+}
+
+// SetPersister changes how the data is kept across restarts, see
+// kvstore.Persister; call it before Start. A new Server restores the
+// latest snapshot on Start and writes snapshots, kvstore.SnapshotPersister.
+func (s *Server) SetPersister(p kvstore.Persister) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.persist = p
+}
+
+// Persister returns what SetPersister set
+func (s *Server) Persister() kvstore.Persister {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.persist
+}
+
+// SetReadOnly turns read-only mode on or off: while it is on, SET,
+// UPDATE, DELETE and custom commands marked Write are refused with
+// protocol.MsgReadOnly and reads go on as usual, e.g. during a migration
+// or on a replica. ADMIN READONLY toggles it at run time.
+func (s *Server) SetReadOnly(on bool) {
+	s.readOnly.Store(on)
+}
+
+// ReadOnly reports whether read-only mode is on
+func (s *Server) ReadOnly() bool {
+	return s.readOnly.Load()
+}
+
+// isWrite reports whether action changes keys
+func (s *Server) isWrite(action string) bool {
+	if writeActions[action] {
+		return true
+	}
+	cmd, ok := command(action)
+	return ok && cmd.Write
+}
+
+// SetCacheSize limits how many keys the read cache holds, see
+// kvstore.ServerProxy.SetCacheSize
+func (s *Server) SetCacheSize(size int) {
+	s.proxy.SetCacheSize(size)
+}
+
+// SetCacheBytes limits how many bytes the read cache holds, see
+// kvstore.ServerProxy.SetCacheBytes
+func (s *Server) SetCacheBytes(n int64) {
+	s.proxy.SetCacheBytes(n)
+}
+
+// SetPreload has Start fill the read cache with the keys p picks once the
+// data is restored, see kvstore.ServerProxy.Preload; call it before Start
+func (s *Server) SetPreload(p kvstore.Preload) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.preload = p
+}
+
+// SetCacheTTL bounds how long the read cache keeps a copy of a value, see
+// kvstore.ServerProxy.SetCacheTTL
+func (s *Server) SetCacheTTL(ttl time.Duration) {
+	s.proxy.SetCacheTTL(ttl)
+}
+
+// SetEvictionPolicy picks the keys the full read cache evicts, see
+// kvstore.ServerProxy.SetEvictionPolicy
+func (s *Server) SetEvictionPolicy(name string) error {
+	return s.proxy.SetEvictionPolicy(name)
+}
+
+// SetCacheStrategy sets what SET and UPDATE do with the read cache, see
+// kvstore.ServerProxy.SetCacheStrategy; under kvstore.WriteBack, Start
+// writes back the buffered writes and Stop flushes them before the final
+// backup
+func (s *Server) SetCacheStrategy(strategy kvstore.CacheStrategy, limits kvstore.WriteBackLimits) {
+	s.proxy.SetCacheStrategy(strategy, limits)
+}
+
+// SetWarmup throttles cache misses for window from now, see
+// kvstore.ServerProxy.SetWarmup; call it right before Start
+func (s *Server) SetWarmup(window time.Duration, from, to float64) {
+	s.proxy.SetWarmup(window, from, to)
+}
+
+// Start opens every listener, opens the persister, loading the data, and
+// launches the janitor, persister and accept loops exactly once. They all
+// stop when ctx is done or Stop is called.
+func (s *Server) Start(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running {
+		return errors.New("server already started")
+	}
+
+	// alive, though not ready, while the data loads
+	if err := s.startHealth(); err != nil {
+		return err
+	}
+	pubsub, err := kvstore.OpenPubSubWithCipher(s.files.PubSub, s.files.Cipher)
+	if err != nil {
+		s.stopHealth()
+		return err
+	}
+	journal, err := kvstore.OpenJournalWithCipher(s.files.Journal, s.files.Cipher)
+	if err != nil {
+		s.stopHealth()
+		pubsub.Close()
+		return err
+	}
+	// the data listeners come first, then the admin ones
+	for _, spec := range append(append([]string(nil), s.addrs...), s.adminPlane.Addrs...) {
+		codec, addr, err := protocol.SplitAddr(spec)
+		var ln net.Listener
+		if err == nil {
+			ln, err = net.Listen("tcp", addr)
+		}
+		if err == nil {
+			middleware := append(append([]Middleware(nil), s.middleware...), s.addrMiddleware[spec]...)
+			s.listeners = append(s.listeners, codecListener{Listener: ln, codec: codec, middleware: middleware})
+			continue
+		}
+		s.stopHealth()
+		s.closeListeners()
+		pubsub.Close()
+		journal.Close()
+		return err
+	}
+	// the data is in place before the accept loops take a write
+	if err := s.persist.Open(ctx, s.kvs); err != nil {
+		s.stopHealth()
+		s.closeListeners()
+		pubsub.Close()
+		journal.Close()
+		return err
+	}
+	s.pubsub = pubsub
+	s.journal = journal
+	if n := s.proxy.Preload(s.preload); n > 0 {
+		kvstore.Logf(kvstore.LogInfo, "Preloaded %d keys into the read cache", n)
+	}
+
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.ctx = ctx
+	s.running = true
+
+	s.goWorker(func() { kvstore.ClearExpiredKeys(ctx, s.kvs, s.proxy) })
+	persist := s.persist
+	s.goWorker(func() { persist.Run(ctx, s.kvs) })
+	if strategy, _ := s.proxy.CacheStrategy(); strategy == kvstore.WriteBack {
+		s.goWorker(func() { s.proxy.WriteBack(ctx) })
+	}
+	if disk := s.disk; disk.MinFree > 0 {
+		s.goWorker(func() { s.watchDisk(ctx, disk) })
+	}
+	if slo := s.slo; len(slo.SLOs) > 0 {
+		s.goWorker(func() { s.watchSLOs(ctx, slo) })
+	}
+	if metrics := s.metricsPush; metrics.Addr != "" {
+		s.goWorker(func() { s.pushMetrics(ctx, metrics) })
+	}
+	if f := s.changes; f != nil {
+		// recording starts now, not when the worker first runs, so no
+		// write after Start is missed
+		events := s.kvs.Events()
+		s.goWorker(func() { s.publishChanges(ctx, f, events) })
+	}
+	for i, ln := range s.listeners {
+		s.acceptWg.Add(1)
+		go func() {
+			defer s.acceptWg.Done()
+			s.acceptLoop(ctx, ln, i >= len(s.addrs))
+		}()
+	}
+	// when ctx is cancelled from outside, stop taking work right away
+	// rather than when Stop gets called
+	s.goWorker(func() {
+		<-ctx.Done()
+		s.stopAccepting()
+	})
+	if s.replicaOf != "" {
+		s.startReplica(s.replicaOf)
+	}
+	if m := s.multi; m != nil {
+		s.startPeers(ctx, m)
+	}
+	if f := s.failover; f != nil {
+		f.mu.Lock()
+		f.postpone()
+		f.mu.Unlock()
+		s.goWorker(func() { s.watchFailover(ctx, f) })
+	}
+	s.ready.Store(true)
+	return nil
+}
+
+// codecListener is a listener, the codec its connections speak and the
+// middleware its requests pass through
+type codecListener struct {
+	net.Listener
+	codec      protocol.Codec
+	middleware []Middleware
+}
+
+// closeListeners closes the listeners of a Start that failed
+func (s *Server) closeListeners() {
+	for _, l := range s.listeners {
+		l.Close()
+	}
+	s.listeners = nil
+}
+
+// Stop shuts the server down in phases, logging each: stop accepting
+// connections, drain in-flight requests (cutting connections after the
+// drain timeout), stop the background jobs, fsync the journal, pub/sub
+// log and persister, and close the persister, writing a final backup by
+// default.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	cancel, t := s.cancel, s.shutdown
+	s.mu.Unlock()
+	if cancel == nil {
+		return
+	}
+	cancel()
+	phase("stop accepting", 0, func() error {
+		s.stopAccepting()
+		s.acceptWg.Wait()
+		return nil
+	})
+	if !phase("drain", t.Drain, func() error { s.connWg.Wait(); return nil }) {
+		s.closeConns()
+		s.connWg.Wait()
+	}
+	phase("stop background jobs", 0, func() error { s.wg.Wait(); return nil })
+	phase("sync logs", t.Sync, func() error {
+		s.flushAllPending()
+		s.proxy.FlushWrites()
+		return errors.Join(s.journal.Sync(), s.pubsub.Sync(), s.persist.Sync(s.kvs))
+	})
+	phase("persist", t.Snapshot, func() error { return s.persist.Close(s.kvs) })
+	if err := s.pubsub.Close(); err != nil {
+		kvstore.RecordError("Error closing pub/sub log:", err)
+	}
+	if err := s.journal.Close(); err != nil {
+		kvstore.RecordError("Error closing journal:", err)
+	}
+	s.mu.Lock()
+	s.stopHealth()
+	s.mu.Unlock()
+}
+
+// phase runs one step of Stop and logs how long it took. A step still
+// running after timeout is left behind so shutdown can go on; phase then
+// returns false.
+func phase(name string, timeout time.Duration, fn func() error) bool {
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- fn() }()
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	select {
+	case err := <-done:
+		if err != nil {
+			kvstore.RecordError("Error during shutdown phase "+name+":", err)
+		}
+		kvstore.Logf(kvstore.LogInfo, "Shutdown: %s took %s", name, time.Since(start).Round(time.Millisecond))
+		return true
+	case <-expired:
+		kvstore.Logf(kvstore.LogWarn, "Shutdown: %s timed out after %s", name, timeout)
+		return false
+	}
+}
+
+func (s *Server) goWorker(fn func()) {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		fn()
+	}()
+}
+
+// acceptLoop serves the connections of ln, an admin listener if admin
+func (s *Server) acceptLoop(ctx context.Context, ln codecListener, admin bool) {
+	if admin {
+		kvstore.Logf(kvstore.LogInfo, "Listening for admin on %s (%s)", ln.Addr(), ln.codec.Name())
+	} else {
+		kvstore.Logf(kvstore.LogInfo, "Listening on %s (%s)", ln.Addr(), ln.codec.Name())
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			kvstore.RecordError("Error accepting connection:", err)
+			continue
+		}
+		if admin && !s.admitted(conn) {
+			kvstore.Logf(kvstore.LogWarn, "Refused admin connection from %s", conn.RemoteAddr())
+			conn.Close()
+			continue
+		}
+		s.connWg.Add(1)
+		go func() {
+			defer s.connWg.Done()
+			s.handleConnection(ctx, conn, ln, admin)
+		}()
+	}
+}
+
+// handleConnection serves requests on conn, from ln, until the client hangs up, the
+// connection sits idle for IdleTimeout or the server shuts down.
+//
+// Requests are read one ahead of the one running, so a client hanging up
+// cancels the context of its request in flight; the idle timeout only runs
+// while none is.
+func (s *Server) handleConnection(ctx context.Context, conn net.Conn, ln codecListener, admin bool) {
+	defer conn.Close()
+	cc := newClientConn(conn, admin)
+	if !s.trackConn(cc, true) {
+		return
+	}
+	defer s.trackConn(cc, false)
+
+	connCtx, hangUp := context.WithCancel(ctx)
+	defer hangUp()
+	// a request, or the response to one that failed to decode
+	type frame struct {
+		request protocol.Request
+		bad     *protocol.Response
+		more    bool // the stream is intact after bad
+	}
+	requests := make(chan frame)
+	go func() {
+		defer close(requests)
+		frames := newFrameReader(conn, ln.codec, s.maxRequestSize())
+		decoder := ln.codec.NewDecoder(frames)
+		for {
+			var f frame
+			frames.next()
+			if err := decoder.Decode(&f.request); err != nil {
+				// a deadline is the idle timeout or stopAccepting, and
+				// the request in flight, if any, still gets its response
+				var netErr *net.OpError
+				if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, os.ErrDeadlineExceeded) || errors.As(err, &netErr) {
+					if !errors.Is(err, os.ErrDeadlineExceeded) {
+						hangUp()
+					}
+					if !errors.Is(err, io.EOF) && !errors.Is(err, os.ErrDeadlineExceeded) && !errors.Is(err, net.ErrClosed) {
+						kvstore.RecordError("Error decoding request:", err)
+					}
+					return
+				}
+				// a malformed request is answered, after the ones before it
+				kvstore.RecordError("Error decoding request:", err)
+				response, more := badFrame(err)
+				f = frame{bad: &response, more: more}
+			}
+			select {
+			case requests <- f:
+			case <-connCtx.Done():
+				return
+			}
+			if f.bad != nil && !f.more {
+				return
+			}
+		}
+	}()
+
+	encoder := ln.codec.NewEncoder(conn)
+	client := conn.RemoteAddr().String()
+	var sess session
+	handler := s.pipeline(func(ctx context.Context, request protocol.Request) protocol.Response {
+		return s.transact(ctx, client, request, admin, &sess)
+	}, ln.middleware)
+	for {
+		conn.SetReadDeadline(time.Now().Add(IdleTimeout))
+		// checked after the deadline so stopAccepting can't be overridden
+		if ctx.Err() != nil {
+			return
+		}
+		f, ok := <-requests
+		if !ok {
+			return
+		}
+		conn.SetReadDeadline(time.Time{})
+		if ctx.Err() != nil {
+			conn.SetReadDeadline(time.Now())
+		}
+		var response protocol.Response
+		if f.bad != nil {
+			response = withErrorInfo(*f.bad)
+		} else {
+			request := f.request
+			cc.touch(request.Action)
+			reqCtx, cancel := requestContext(connCtx, client, request, admin)
+			response = withErrorInfo(serveSafely(handler, reqCtx, request))
+			cancel()
+		}
+		if err := encoder.Encode(response); err != nil {
+			if connCtx.Err() == nil {
+				kvstore.RecordError("Error encoding response:", err)
+			}
+			return
+		}
+	}
+}
+
+// handle runs one request from client, which came on an admin listener if
+// admin, and returns its response; requests that wait give up when ctx is
+// done
+func (s *Server) handle(ctx context.Context, client string, request protocol.Request, admin bool) protocol.Response {
+	if request.RequestID != "" {
+		return s.dedup.do(ctx, request, func() protocol.Response {
+			return s.handleOnce(ctx, client, request, admin)
+		})
+	}
+	return s.handleOnce(ctx, client, request, admin)
+}
+
+// handleOnce is handle without looking for an earlier send of the request
+func (s *Server) handleOnce(ctx context.Context, client string, request protocol.Request, admin bool) protocol.Response {
+	if holdsOffExec(request) {
+		// EXEC holds it exclusively while it runs a transaction
+		s.multiMu.RLock()
+		defer s.multiMu.RUnlock()
+	}
+	response := s.dispatch(ctx, client, request, admin)
+	if s.isWrite(request.Action) {
+		if err := s.commitWAL(); err != nil {
+			return protocol.Response{Message: protocol.MsgServerError}
+		}
+		if response.Success {
+			response = s.awaitConsistency(ctx, request, response)
+		}
+	} else if request.Consistency != "" {
+		response.Position = s.kvs.Revision()
+	}
+	return response
+}
+
+// commitWAL waits, with the WAL fsynced always, until the writes so far
+// are on disk, so a write is before its reply; writes that came meanwhile
+// share the fsync. It returns why they may not be on disk, after which
+// they must not be acknowledged.
+func (s *Server) commitWAL() error {
+	err := s.kvs.CommitWAL()
+	if err != nil {
+		kvstore.RecordError("Error syncing WAL:", err)
+	}
+	return err
+}
+
+// dispatch is handle without waiting for a running transaction
+func (s *Server) dispatch(ctx context.Context, client string, request protocol.Request, admin bool) protocol.Response {
+	proxy, locks := s.proxy, s.locks
+	var response protocol.Response
+	identity := request.Owner
+	if identity == "" {
+		identity = client
+	}
+	if err := s.validate(request); err != nil {
+		response.Message = protocol.MsgBadRequest
+		response.Value = err.Error()
+		return response
+	}
+	if message, addr := s.moved(request); message != "" {
+		response.Message = message
+		response.Value = addr
+		return response
+	}
+	if s.degradedRefusal(request) {
+		response.Message = protocol.MsgDegraded
+		return response
+	}
+	if !consistencyLevels[request.Consistency] {
+		response.Message = protocol.MsgInvalidArgument
+		return response
+	}
+	if s.crossSlot(request) {
+		response.Message = protocol.MsgCrossSlot
+		return response
+	}
+	if s.unsettled(request) {
+		response.Message = protocol.MsgTryAgain
+		return response
+	}
+	if (s.readOnly.Load() || s.frozen() || s.replicating()) && s.isWrite(request.Action) {
+		response.Message = protocol.MsgReadOnly
+		response.Value = s.primary()
+		return response
+	}
+	if s.tooStale(request) {
+		response.Message = protocol.MsgStale
+		response.Value = s.primary()
+		return response
+	}
+	if s.rejectWrites() && s.isWrite(request.Action) {
+		response.Message = protocol.MsgDiskFull
+		return response
+	}
+	if s.isWrite(request.Action) && s.kvs.WALError() != nil {
+		// it would not be logged; kvs-admin rewrite-wal starts the WAL again
+		response.Message = protocol.MsgServerError
+		return response
+	}
+	s.keyHits.count(request)
+
+	switch request.Action {
+	case protocol.ActionHello:
+		response.Value = strconv.Itoa(protocol.Version)
+		response.Values = capabilities()
+		response.Success = true
+	case protocol.ActionPing:
+		response.Value = cmp.Or(request.Value, "PONG")
+		response.Success = true
+	case protocol.ActionGet:
+		if request.Owner != "" && request.ReadTx == "" {
+			// tracked before the read, so a write in between is reported
+			s.kvs.Track(request.Owner, request.Key)
+		}
+		item, ok, msg := s.getKV(ctx, request, request.Key)
+		if msg != "" {
+			response.Message = msg
+			break
+		}
+		if item.Value == protocol.MsgIntegrity {
+			kvstore.RecordError("Error reading value:", fmt.Errorf("value of %q fails its checksum", request.Key))
+			response.Message = item.Value
+			break
+		}
+		if !ok && (item.Value == protocol.MsgWrongType || item.Value == protocol.MsgVersionGone) {
+			response.Message = item.Value
+			break
+		}
+		if ok {
+			response.Value = item.Value
+			response.Checksum = item.Checksum
+			response.Revision = item.Revision
+		} else {
+			response.Message = item.Value
+		}
+		response.Found = ok
+		response.Success = true
+	case protocol.ActionSet:
+		response.Success = s.write(request.Action, request.Key, request.Value, identity, func() bool {
+			response.Revision, response.Message, response.Success = proxy.SETSUM(request.Key, request.Value, request.TTL, request.Checksum)
+			return response.Success
+		})
+	case protocol.ActionSetNX:
+		response.Success = s.write(protocol.ActionSet, request.Key, request.Value, identity, func() bool {
+			response.Revision, response.Message, response.Success = proxy.SETNX(request.Key, request.Value, request.TTL, request.Checksum)
+			return response.Success
+		})
+		response.Found = response.Message == protocol.MsgValueExists
+	case protocol.ActionCAS:
+		var current kvstore.KeyValue
+		s.write(protocol.ActionSet, request.Key, request.Value, identity, func() bool {
+			current, response.Found, response.Message, response.Success = proxy.CAS(request.Key, request.Expect, request.Revision, request.Value, request.TTL, request.Checksum)
+			return response.Success
+		})
+		response.Revision = current.Revision
+		if response.Message == protocol.MsgConflict {
+			response.Value = current.Value
+		}
+	case protocol.ActionBeginRead:
+		var tx *kvstore.ReadTx
+		response.Value, tx = s.beginRead(request.TTL)
+		response.Revision = tx.Revision()
+		response.Success = true
+	case protocol.ActionEndRead:
+		if !s.endRead(request.ReadTx) {
+			response.Message = protocol.MsgNoReadTx
+			break
+		}
+		response.Message = protocol.MsgReadEnded
+		response.Success = true
+	case protocol.ActionGetSet:
+		s.write(protocol.ActionSet, request.Key, request.Value, identity, func() bool {
+			response.Value, response.Found, response.Message, response.Success = proxy.GETSET(request.Key, request.Value, request.TTL, request.Checksum)
+			return response.Success
+		})
+	case protocol.ActionGetDel:
+		s.write(protocol.ActionDelete, request.Key, "", identity, func() bool {
+			response.Value, response.Found = proxy.GETDEL(request.Key)
+			return response.Found
+		})
+		switch {
+		case response.Value == protocol.MsgIntegrity:
+			kvstore.RecordError("Error reading value:", fmt.Errorf("value of %q fails its checksum", request.Key))
+			response.Message, response.Value = protocol.MsgIntegrity, ""
+		case !response.Found && response.Value == protocol.MsgWrongType:
+			response.Message, response.Value = protocol.MsgWrongType, ""
+		case response.Found:
+			response.Message = protocol.MsgValueDeleted
+			response.Success = true
+		default:
+			response.Message, response.Value = protocol.MsgNotFound, ""
+			response.Success = true
+		}
+	case protocol.ActionDelete:
+		ok := s.write(request.Action, request.Key, "", identity, func() bool {
+			response.Message, response.Found = proxy.DELETE(request.Key)
+			return response.Found
+		})
+		response.Success = ok
+	case protocol.ActionUpdate:
+		mode, valid := ttlMode(request.TTLMode)
+		if !valid {
+			response.Message = protocol.MsgInvalidTTL
+			break
+		}
+		ok := s.write(request.Action, request.Key, request.Value, identity, func() bool {
+			response.Revision, response.Message, response.Found = proxy.UPDATEEX(request.Key, request.Value, mode, request.TTL, request.Checksum)
+			return response.Found
+		})
+		response.Success = ok
+	case protocol.ActionRename, protocol.ActionCopy:
+		// journaled as the writes a follower replays: a SET of the new key,
+		// after a DELETE of the old one for RENAME
+		src, dst := request.Key, request.Value
+		s.writeOps(identity, func() []journalOp {
+			if request.Action == protocol.ActionRename {
+				response.Message, response.Found = proxy.RENAME(src, dst)
+			} else {
+				response.Message, response.Found = proxy.COPY(src, dst, request.TTL)
+			}
+			if !response.Found || src == dst {
+				return nil
+			}
+			item, _ := s.kvs.GETKV(dst)
+			ops := []journalOp{{setOp(item), dst, item.Value}}
+			if request.Action == protocol.ActionRename {
+				ops = append([]journalOp{{protocol.ActionDelete, src, ""}}, ops...)
+			}
+			return ops
+		})
+		response.Success = response.Found
+	case protocol.ActionIncr, protocol.ActionDecr, protocol.ActionIncrBy:
+		delta := int64(1)
+		switch request.Action {
+		case protocol.ActionDecr:
+			delta = -1
+		case protocol.ActionIncrBy:
+			n, err := strconv.ParseInt(request.Value, 10, 64)
+			if err != nil {
+				response.Message = protocol.MsgNotInteger
+				return response
+			}
+			delta = n
+		}
+		// journaled as a SET of the result, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			var n int64
+			if n, response.Message, response.Success = proxy.INCRBY(request.Key, delta, request.TTL); !response.Success {
+				return nil
+			}
+			response.Value = strconv.FormatInt(n, 10)
+			return []journalOp{{protocol.ActionSet, request.Key, response.Value}}
+		})
+	case protocol.ActionNextID:
+		count := int64(max(request.Limit, 1))
+		// journaled as a SET of the last ID, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			var first int64
+			if first, response.Message, response.Success = proxy.NEXTID(request.Key, count); !response.Success {
+				return nil
+			}
+			response.Value = strconv.FormatInt(first, 10)
+			return []journalOp{{protocol.ActionSet, request.Key, strconv.FormatInt(first+count-1, 10)}}
+		})
+	case protocol.ActionAppend:
+		// journaled as a SET of the whole value, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			var length int
+			if length, response.Message, response.Success = proxy.APPEND(request.Key, request.Value, request.TTL); !response.Success {
+				return nil
+			}
+			response.Value = strconv.Itoa(length)
+			value, _ := s.kvs.GET(request.Key)
+			return []journalOp{{protocol.ActionSet, request.Key, value}}
+		})
+	case protocol.ActionStrlen:
+		item, ok, err := proxy.GETKVContext(ctx, request.Key)
+		if err != nil {
+			response.Message = protocol.MsgCanceled
+			break
+		}
+		value := item.Value
+		if value == protocol.MsgIntegrity {
+			response.Message = value
+			break
+		}
+		if ok && item.Type != kvstore.TypeString {
+			response.Message = protocol.MsgWrongType
+			break
+		}
+		if !ok {
+			value = ""
+		}
+		response.Value = strconv.Itoa(len(value))
+		response.Found = ok
+		response.Success = true
+	case protocol.ActionSetBit:
+		bit, err := strconv.Atoi(request.Value)
+		if request.Start < 0 || request.Start > protocol.MaxBitOffset || err != nil || bit != 0 && bit != 1 {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		// journaled as a SET of the whole value, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			var old int
+			if old, response.Message, response.Success = proxy.SETBIT(request.Key, request.Start, bit, request.TTL); !response.Success {
+				return nil
+			}
+			response.Value = strconv.Itoa(old)
+			value, _ := s.kvs.GET(request.Key)
+			return []journalOp{{protocol.ActionSet, request.Key, value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionGetBit, protocol.ActionBitCount:
+		if request.Action == protocol.ActionGetBit && (request.Start < 0 || request.Start > protocol.MaxBitOffset) {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		item, ok, err := proxy.GETKVContext(ctx, request.Key)
+		if err != nil {
+			response.Message = protocol.MsgCanceled
+			break
+		}
+		if !ok && item.Value == protocol.MsgIntegrity {
+			response.Message = item.Value
+			break
+		}
+		if ok && item.Type != kvstore.TypeString {
+			response.Message = protocol.MsgWrongType
+			break
+		}
+		value := ""
+		if ok {
+			value = item.Value
+		}
+		if request.Action == protocol.ActionGetBit {
+			bit, _, _ := kvstore.GetBit(value, request.Start) // checked above
+			response.Value = strconv.Itoa(bit)
+		} else {
+			response.Value = strconv.Itoa(kvstore.BitCount(value))
+		}
+		response.Found = ok
+		response.Success = true
+	case protocol.ActionJournal:
+		after, err := strconv.ParseUint(request.Value, 10, 64)
+		if request.Value != "" && err != nil {
+			response.Message = protocol.MsgInvalidID
+			break
+		}
+		entries, err := s.journal.Wait(ctx, after, request.Limit, request.Timeout)
+		if err != nil {
+			kvstore.RecordError("Error reading journal:", err)
+			response.Message = protocol.MsgServerError
+			break
+		}
+		for _, e := range entries {
+			response.Journal = append(response.Journal, protocol.JournalEntry(e))
+		}
+		response.Value = strconv.FormatUint(s.journal.Revision(), 10)
+		response.Success = true
+	case protocol.ActionSync:
+		response = s.sync(client, request)
+	case protocol.ActionPSync:
+		response = s.psync(ctx, client, request)
+	case protocol.ActionWait:
+		response = s.waitReplicas(ctx, request)
+	case protocol.ActionRole:
+		response = s.roleOf()
+	case protocol.ActionVote:
+		response = s.vote(request)
+	case protocol.ActionEval:
+		response = s.eval(ctx, client, request, admin)
+	case protocol.ActionUndelete:
+		// a SET the read cache buffers goes first, and UNDELETE finds
+		// the key set again
+		s.proxy.FlushWrites()
+		var item kvstore.KeyValue
+		s.writeOps(identity, func() []journalOp {
+			item, response.Message, response.Success = s.kvs.UNDELETE(request.Key)
+			if !response.Success {
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		response.Found = response.Message != protocol.MsgValueNotExist
+		if response.Success {
+			response.Revision = item.Revision
+		}
+	case protocol.ActionHistory:
+		response = s.history(request)
+	case protocol.ActionDiff:
+		response = s.diff(request)
+	case protocol.ActionMGet:
+		if len(request.Keys) > protocol.MaxBatchKeys {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		response.Results = make([]protocol.KeyResult, len(request.Keys))
+		for i, key := range request.Keys {
+			r := &response.Results[i]
+			r.Key = key
+			if message, addr := s.redirect(key, request.Asking); message != "" {
+				r.Message, r.Value = message, addr
+				continue
+			}
+			item, ok, msg := s.getKV(ctx, request, key)
+			if msg != "" {
+				response.Results = nil
+				response.Message = msg
+				break
+			}
+			switch {
+			case item.Value == protocol.MsgIntegrity:
+				kvstore.RecordError("Error reading value:", fmt.Errorf("value of %q fails its checksum", key))
+				r.Message = item.Value
+			case ok:
+				r.Value, r.Checksum, r.Revision = item.Value, item.Checksum, item.Revision
+			default:
+				r.Message = item.Value
+			}
+			r.Found = ok
+			r.Success = r.Message != protocol.MsgIntegrity && r.Message != protocol.MsgWrongType && r.Message != protocol.MsgVersionGone
+		}
+		response.Success = response.Message == ""
+	case protocol.ActionMSet:
+		if len(request.Keys) != len(request.Values) || len(request.Keys) > protocol.MaxBatchKeys {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		response.Results = make([]protocol.KeyResult, len(request.Keys))
+		applied := false
+		s.writeOps(identity, func() []journalOp {
+			applied = true
+			var ops []journalOp
+			for i, key := range request.Keys {
+				r := &response.Results[i]
+				r.Key = key
+				if message, addr := s.redirect(key, request.Asking); message != "" {
+					r.Message, r.Value = message, addr
+					continue
+				}
+				var sum uint32
+				if i < len(request.Checksums) {
+					sum = request.Checksums[i]
+				}
+				r.Revision, r.Message, r.Success = proxy.SETSUM(key, request.Values[i], request.TTL, sum)
+				if r.Success {
+					ops = append(ops, journalOp{protocol.ActionSet, key, request.Values[i]})
+				}
+			}
+			return ops
+		})
+		if !applied {
+			// turned read-only since the check in handle
+			response.Results = nil
+			response.Message = protocol.MsgReadOnly
+			break
+		}
+		response.Success = true
+	case protocol.ActionBatch:
+		if len(request.Keys) != len(request.Values) || len(request.Keys) > protocol.MaxBatchKeys ||
+			len(request.Deletes) > len(request.Keys) || len(request.Checksums) > len(request.Keys) {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		ops := make([]kvstore.BatchOp, len(request.Keys))
+		for i, key := range request.Keys {
+			ops[i] = kvstore.BatchOp{Key: key, Value: request.Values[i]}
+			if i < len(request.Checksums) {
+				ops[i].Checksum = request.Checksums[i]
+			}
+			ops[i].Delete = i < len(request.Deletes) && request.Deletes[i]
+		}
+		response.Results = make([]protocol.KeyResult, len(ops))
+		for i, op := range ops {
+			response.Results[i].Key = op.Key
+		}
+		// journaled as its SETs and DELETEs, which replay the same
+		applied := false
+		s.writeOps(identity, func() []journalOp {
+			applied = true
+			revs, failed, msg, ok := proxy.BATCH(ops, request.TTL)
+			if !ok {
+				response.Message = msg
+				response.Results[failed].Message = msg
+				return nil
+			}
+			var journal []journalOp
+			for i, op := range ops {
+				r := &response.Results[i]
+				r.Revision, r.Success = revs[i], true
+				switch {
+				case !op.Delete:
+					r.Message = protocol.MsgValueSet
+					journal = append(journal, journalOp{protocol.ActionSet, op.Key, op.Value})
+				case revs[i] != 0:
+					r.Message, r.Found = protocol.MsgValueDeleted, true
+					journal = append(journal, journalOp{protocol.ActionDelete, op.Key, ""})
+				default:
+					r.Message = protocol.MsgValueNotExist
+				}
+			}
+			response.Success = true
+			return journal
+		})
+		if !applied {
+			// turned read-only since the check in handle
+			response.Results = nil
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionHSet:
+		if len(request.Keys) == 0 || len(request.Keys) != len(request.Values) {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		// journaled as an HSET of the whole hash, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			added, item, msg, ok := proxy.HSET(request.Key, request.Keys, request.Values, request.TTL)
+			response.Message, response.Success = msg, ok
+			if !ok {
+				return nil
+			}
+			response.Value = strconv.Itoa(added)
+			return []journalOp{{protocol.ActionHSet, request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionJSONSet:
+		// journaled as a JSON.SET of the whole document, which replays the
+		// same
+		s.writeOps(identity, func() []journalOp {
+			item, msg, ok := proxy.JSONSET(request.Key, request.Path, request.Value, request.TTL)
+			response.Message, response.Success = msg, ok
+			if !ok {
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionJSONGet:
+		response.Value, response.Found, response.Message = s.kvs.JSONGET(request.Key, request.Path)
+		response.Success = response.Message == ""
+	case protocol.ActionHDel:
+		s.writeOps(identity, func() []journalOp {
+			removed, item, deleted, msg, ok := proxy.HDEL(request.Key, request.Keys)
+			response.Message, response.Success = msg, ok
+			response.Value = strconv.Itoa(removed)
+			switch {
+			case !ok || removed == 0:
+				return nil
+			case deleted:
+				return []journalOp{{protocol.ActionDelete, request.Key, ""}}
+			}
+			return []journalOp{{protocol.ActionHSet, request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionLPush, protocol.ActionRPush:
+		if len(request.Values) == 0 {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		push := proxy.RPUSH
+		if request.Action == protocol.ActionLPush {
+			push = proxy.LPUSH
+		}
+		// journaled as an RPUSH of the whole list, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			length, item, msg, ok := push(request.Key, request.Values, request.TTL)
+			response.Message, response.Success = msg, ok
+			if !ok {
+				return nil
+			}
+			response.Value = strconv.Itoa(length)
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionLPop, protocol.ActionRPop:
+		s.pop(request, identity, request.Action == protocol.ActionLPop, &response)
+	case protocol.ActionBLPop:
+		s.blockingPop(ctx, request, identity, &response)
+	case protocol.ActionLRange:
+		items, found, msg := s.kvs.LRANGE(request.Key, request.Start, request.Stop)
+		response.Values, response.Found, response.Message, response.Success = items, found, msg, msg == ""
+	case protocol.ActionSAdd:
+		if len(request.Values) == 0 {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		// journaled as an SADD of the whole set, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			added, item, msg, ok := proxy.SADD(request.Key, request.Values, request.TTL)
+			response.Message, response.Success = msg, ok
+			response.Value = strconv.Itoa(added)
+			if !ok || added == 0 {
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionSRem:
+		s.writeOps(identity, func() []journalOp {
+			removed, item, deleted, msg, ok := proxy.SREM(request.Key, request.Values)
+			response.Message, response.Success = msg, ok
+			response.Value = strconv.Itoa(removed)
+			switch {
+			case !ok || removed == 0:
+				return nil
+			case deleted:
+				return []journalOp{{protocol.ActionDelete, request.Key, ""}}
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionSMembers:
+		members, found, msg := s.kvs.SMEMBERS(request.Key)
+		response.Values, response.Found, response.Message, response.Success = members, found, msg, msg == ""
+	case protocol.ActionSIsMember:
+		response.Found, response.Message = s.kvs.SISMEMBER(request.Key, request.Value)
+		response.Success = response.Message == ""
+	case protocol.ActionSUnion, protocol.ActionSInter, protocol.ActionSDiff:
+		if len(request.Keys) == 0 || len(request.Keys) > protocol.MaxBatchKeys {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		combine := s.kvs.SUNION
+		switch request.Action {
+		case protocol.ActionSInter:
+			combine = s.kvs.SINTER
+		case protocol.ActionSDiff:
+			combine = s.kvs.SDIFF
+		}
+		response.Values, response.Message = combine(request.Keys)
+		response.Success = response.Message == ""
+	case protocol.ActionZAdd:
+		scores, ok := parseScores(request.Values)
+		if !ok || len(request.Keys) == 0 || len(request.Keys) != len(scores) {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		// journaled as a ZADD of the whole set, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			added, item, msg, ok := proxy.ZADD(request.Key, request.Keys, scores, request.TTL)
+			response.Message, response.Success = msg, ok
+			response.Value = strconv.Itoa(added)
+			if !ok || item.Revision == 0 {
+				// no score changed
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionZRem:
+		s.writeOps(identity, func() []journalOp {
+			removed, item, deleted, msg, ok := proxy.ZREM(request.Key, request.Keys)
+			response.Message, response.Success = msg, ok
+			response.Value = strconv.Itoa(removed)
+			switch {
+			case !ok || removed == 0:
+				return nil
+			case deleted:
+				return []journalOp{{protocol.ActionDelete, request.Key, ""}}
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionZRange:
+		members, found, msg := s.kvs.ZRANGE(request.Key, request.Start, request.Stop)
+		response.Values, response.Found, response.Message, response.Success = scoredValues(members), found, msg, msg == ""
+	case protocol.ActionZRangeByScore:
+		bounds, ok := parseScores(request.Values)
+		if !ok || len(bounds) != 2 {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		members, found, msg := s.kvs.ZRANGEBYSCORE(request.Key, bounds[0], bounds[1], request.Limit)
+		response.Values, response.Found, response.Message, response.Success = scoredValues(members), found, msg, msg == ""
+	case protocol.ActionZRank:
+		rank, found, msg := s.kvs.ZRANK(request.Key, request.Value)
+		if found {
+			response.Value = strconv.Itoa(rank)
+		}
+		response.Found, response.Message, response.Success = found, msg, msg == ""
+	case protocol.ActionGeoAdd:
+		coords, ok := parseScores(request.Values)
+		if !ok || len(request.Keys) == 0 || len(coords) != 2*len(request.Keys) {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		scores := make([]float64, len(request.Keys))
+		for i := range scores {
+			lon, lat := coords[2*i], coords[2*i+1]
+			if !kvstore.ValidGeo(lon, lat) {
+				ok = false
+				break
+			}
+			scores[i] = kvstore.GeoScore(lon, lat)
+		}
+		if !ok {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		// journaled as a ZADD of the whole set, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			added, item, msg, ok := proxy.ZADD(request.Key, request.Keys, scores, request.TTL)
+			response.Message, response.Success = msg, ok
+			response.Value = strconv.Itoa(added)
+			if !ok || item.Revision == 0 {
+				// no location changed
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionGeoSearch:
+		area, ok := parseGeoArea(request.Values)
+		if !ok {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		members, found, msg := s.kvs.GEOSEARCH(request.Key, area, request.Limit)
+		values := make([]string, 0, 4*len(members))
+		for _, m := range members {
+			values = append(values, m.Member, protocol.FormatScore(m.Distance), protocol.FormatScore(m.Lon), protocol.FormatScore(m.Lat))
+		}
+		response.Values, response.Found, response.Message, response.Success = values, found, msg, msg == ""
+	case protocol.ActionXAdd:
+		if len(request.Keys) == 0 || len(request.Keys) != len(request.Values) || request.Limit < 0 {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		fields := make([]string, 0, 2*len(request.Keys))
+		for i, field := range request.Keys {
+			fields = append(fields, field, request.Values[i])
+		}
+		// journaled as an XADD of the whole stream, which replays the same
+		s.writeOps(identity, func() []journalOp {
+			id, item, msg, ok := proxy.XADD(request.Key, fields, request.Limit, request.TTL)
+			response.Value, response.Message, response.Success = id, msg, ok
+			if !ok {
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionXRead:
+		entries, found, msg := s.kvs.XREAD(request.Key, request.Value, request.Limit)
+		response.Stream, response.Found, response.Message, response.Success = entries, found, msg, msg == ""
+	case protocol.ActionXGroup, protocol.ActionXReadGroup, protocol.ActionXAck:
+		if request.Group == "" || request.Action == protocol.ActionXReadGroup && request.Owner == "" {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		s.writeOps(identity, func() []journalOp {
+			var item kvstore.KeyValue
+			var msg string
+			var ok bool
+			switch request.Action {
+			case protocol.ActionXGroup:
+				item, msg, ok = proxy.XGROUP(request.Key, request.Group, request.Value, request.TTL)
+			case protocol.ActionXReadGroup:
+				response.Stream, item, msg, ok = proxy.XREADGROUP(request.Key, request.Group, request.Owner, request.Value, request.Limit)
+			default:
+				var acked int
+				acked, item, msg, ok = proxy.XACK(request.Key, request.Group, request.Keys)
+				response.Value = strconv.Itoa(acked)
+			}
+			response.Message, response.Success = msg, ok
+			if !ok || item.Revision == 0 {
+				// nothing changed
+				return nil
+			}
+			return []journalOp{{setOp(item), request.Key, item.Value}}
+		})
+		if !response.Success && response.Message == "" {
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionHGet:
+		response.Value, response.Found, response.Message = s.kvs.HGET(request.Key, request.Value)
+		response.Success = response.Message == ""
+	case protocol.ActionHGetAll:
+		hash, found, msg := s.kvs.HGETALL(request.Key)
+		fields := make([]string, 0, len(hash))
+		for field := range hash {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+		for _, field := range fields {
+			response.Values = append(response.Values, field, hash[field])
+		}
+		response.Found, response.Message, response.Success = found, msg, msg == ""
+	case protocol.ActionList:
+		entries, ok := s.kvs.LIST(request.Key)
+		if !ok {
+			response.Message = protocol.MsgNoHierarchy
+			break
+		}
+		if request.Limit > 0 && len(entries) > request.Limit {
+			entries = entries[:request.Limit]
+		}
+		for _, e := range entries {
+			response.Entries = append(response.Entries, protocol.DirEntry(e))
+		}
+		response.Value = s.kvs.Separator()
+		response.Success = true
+	case protocol.ActionScan:
+		cursor := request.Continue
+		if cursor == "" {
+			cursor = request.Value
+		}
+		if _, err := path.Match(request.Key, ""); err != nil {
+			response.Message = protocol.MsgBadPattern
+			break
+		}
+		count := request.Limit
+		if s.degradedBy(DegradeScan) && (count <= 0 || count > DegradedScanCount) {
+			count = DegradedScanCount
+		}
+		keys, next, err := s.kvs.SCAN(cursor, request.Key, count)
+		if err != nil {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		response.Values = keys
+		response.Continue, response.More = next, next != ""
+		response.Value = next
+		if next == "" {
+			response.Value = "0"
+		}
+		response.Success = true
+	case protocol.ActionRange:
+		start := request.Key
+		if request.Continue != "" {
+			start = request.Continue
+		}
+		keys, next, ok := s.kvs.RANGE(start, request.Value, request.Limit)
+		if !ok {
+			response.Message = protocol.MsgUnordered
+			break
+		}
+		response.Values = keys
+		response.Continue, response.More = next, next != ""
+		response.Success = true
+	case protocol.ActionSearch:
+		if request.Start < 0 || request.Limit < 0 {
+			response.Message = protocol.MsgInvalidArgument
+			break
+		}
+		limit := request.Limit
+		if limit == 0 {
+			limit = DefaultSearchLimit
+		}
+		hits, more, ok := s.kvs.SEARCH(request.Value, request.Start, limit)
+		if !ok {
+			response.Message = protocol.MsgNoIndex
+			break
+		}
+		for _, hit := range hits {
+			response.Values = append(response.Values, hit.Key, protocol.FormatScore(hit.Score))
+		}
+		response.More = more
+		response.Success = true
+	case protocol.ActionDBSize:
+		total, byNamespace := s.kvs.DBSIZE()
+		response.Value = strconv.Itoa(total)
+		for prefix, n := range byNamespace {
+			response.Values = append(response.Values, fmt.Sprintf("%s: %d", prefix, n))
+		}
+		sort.Strings(response.Values)
+		response.Success = true
+	case protocol.ActionRLock, protocol.ActionWLock:
+		if request.Owner == "" {
+			response.Message = protocol.MsgOwnerRequired
+			break
+		}
+		write := request.Action == protocol.ActionWLock
+		if locks.Acquire(ctx, request.Key, request.Owner, write, request.Timeout, request.TTL) {
+			response.Message = protocol.MsgLockAcquired
+			response.Success = true
+		} else if ctx.Err() != nil {
+			response.Message = protocol.MsgCanceled
+		} else {
+			response.Message = protocol.MsgLockTimeout
+		}
+	case protocol.ActionUnlock:
+		if request.Revision > 0 {
+			if !s.write(protocol.ActionDelete, request.Key, "", identity, func() bool {
+				response.Message, response.Success = proxy.UNLOCK(request.Key, request.Revision)
+				return response.Success
+			}) && response.Message == "" {
+				response.Message = protocol.MsgReadOnly
+			}
+			response.Found = response.Success
+			break
+		}
+		if request.Owner == "" {
+			response.Message = protocol.MsgOwnerRequired
+			break
+		}
+		if locks.Release(request.Key, request.Owner) {
+			response.Message = protocol.MsgLockReleased
+			response.Found = true
+			response.Success = true
+		} else {
+			response.Message = protocol.MsgLockNotHeld
+		}
+	case protocol.ActionLock:
+		if request.Owner == "" {
+			response.Message = protocol.MsgOwnerRequired
+			break
+		}
+		ttl := request.TTL
+		if ttl <= 0 {
+			ttl = kvstore.DefaultLockLease
+		}
+		s.write(protocol.ActionSet, request.Key, request.Owner, identity, func() bool {
+			response.Revision, response.Message, response.Success = proxy.SETNX(request.Key, request.Owner, ttl, 0)
+			return response.Success
+		})
+		switch {
+		case response.Success:
+			response.Message = protocol.MsgLockAcquired
+		case response.Message == protocol.MsgValueExists:
+			response.Message, response.Found = protocol.MsgLocked, true
+			response.Value, _ = s.kvs.GET(request.Key)
+		case response.Message == "":
+			// turned read-only since the check in handle
+			response.Message = protocol.MsgReadOnly
+		}
+	case protocol.ActionType, protocol.ActionObject:
+		response = s.object(request)
+	case protocol.ActionMemoryUsage:
+		response = s.memoryUsage(request)
+	case protocol.ActionTouch:
+		// a change of lifetime, like RENEW
+		response.Message, response.Success = proxy.TOUCH(request.Key, request.TTL)
+		response.Found = response.Success
+	case protocol.ActionRenew:
+		// a change of lifetime, which is not journaled for any key
+		response.Message, response.Success = proxy.RENEW(request.Key, request.Revision, request.TTL)
+		response.Found = response.Success
+	case protocol.ActionLocks:
+		if request.Value != "" && request.Value != "LIST" {
+			response.Message = protocol.MsgInvalidAction
+			break
+		}
+		response.Values = locks.List()
+		response.Success = true
+	case protocol.ActionWindowIncr:
+		bucket, err := time.ParseDuration(request.Value)
+		if err != nil {
+			response.Message = protocol.MsgInvalidWindow
+			break
+		}
+		count, ok := s.kvs.WINDOWINCR(request.Key, bucket, request.TTL)
+		if !ok {
+			response.Message = protocol.MsgInvalidWindow
+			break
+		}
+		response.Value = strconv.FormatInt(count, 10)
+		response.Found = true
+		response.Success = true
+	case protocol.ActionWindowSum:
+		span, err := time.ParseDuration(request.Value)
+		if err != nil || span <= 0 {
+			response.Message = protocol.MsgInvalidWindow
+			break
+		}
+		sum, ok := s.kvs.WINDOWSUM(request.Key, span)
+		response.Value = strconv.FormatInt(sum, 10)
+		response.Found = ok
+		response.Success = true
+	case protocol.ActionPublish:
+		n, err := s.pubsub.Publish(request.Key, request.Value)
+		if err != nil {
+			kvstore.RecordError("Error publishing:", err)
+			response.Message = protocol.MsgServerError
+			break
+		}
+		response.Value = strconv.Itoa(n)
+		response.Success = true
+	case protocol.ActionSubscribe, protocol.ActionUnsubscribe:
+		if request.Owner == "" {
+			response.Message = protocol.MsgOwnerRequired
+			break
+		}
+		if request.Action == protocol.ActionSubscribe {
+			err := s.pubsub.Subscribe(request.Owner, request.Key)
+			response.Message, response.Success = pubsubResult(err, protocol.MsgSubscribed)
+		} else {
+			err := s.pubsub.Unsubscribe(request.Owner, request.Key)
+			response.Message, response.Success = pubsubResult(err, protocol.MsgUnsubscribed)
+		}
+	case protocol.ActionFetch:
+		msgs, err := s.pubsub.Fetch(ctx, request.Owner, request.Timeout)
+		if response.Message, response.Success = pubsubResult(err, ""); !response.Success {
+			break
+		}
+		for _, msg := range msgs {
+			response.Messages = append(response.Messages, protocol.Message{ID: msg.ID, Channel: msg.Channel, Payload: msg.Payload})
+		}
+		response.Found = len(msgs) > 0
+	case protocol.ActionInvalidations:
+		if request.Owner == "" {
+			response.Message = protocol.MsgOwnerRequired
+			break
+		}
+		keys, all, err := s.kvs.Invalidations(ctx, request.Owner, request.Timeout)
+		if err != nil {
+			response.Message = protocol.MsgCanceled
+			break
+		}
+		response.Values = keys
+		response.Message = protocol.MsgInvalidated
+		if all {
+			response.Message = protocol.MsgInvalidateAll
+		}
+		response.Found = all || len(keys) > 0
+		response.Success = true
+	case protocol.ActionAck:
+		id, err := strconv.ParseUint(request.Value, 10, 64)
+		if err != nil {
+			response.Message = protocol.MsgInvalidID
+			break
+		}
+		n, err := s.pubsub.Ack(request.Owner, id)
+		response.Message, response.Success = pubsubResult(err, protocol.MsgAcked)
+		response.Value = strconv.Itoa(n)
+	case protocol.ActionPin:
+		// Found tells whether the key was pinned already
+		response.Found = !s.kvs.PIN(request.Key)
+		response.Message = protocol.MsgPinned
+		response.Success = true
+	case protocol.ActionUnpin:
+		if s.kvs.UNPIN(request.Key) {
+			response.Message = protocol.MsgUnpinned
+			response.Found = true
+			response.Success = true
+		} else {
+			response.Message = protocol.MsgNotPinned
+		}
+	case protocol.ActionCluster:
+		switch request.Value {
+		case "INFO":
+			response.Values = s.clusterInfo()
+		case "MIGRATIONS":
+			response.Values = s.clusterMigrations()
+		default:
+			response.Message = protocol.MsgInvalidAction
+			return response
+		}
+		response.Success = true
+	case protocol.ActionCommands:
+		response.Commands = commandSpecs(request.Key)
+		response.Values = commonMessages
+		response.Found = len(response.Commands) > 0
+		response.Success = true
+	case protocol.ActionAdmin:
+		response = s.admin(ctx, request)
+	case protocol.ActionDiagnose:
+		archive, err := s.Diagnose()
+		if err != nil {
+			kvstore.RecordError("Error building diagnose bundle:", err)
+			response.Message = err.Error()
+			break
+		}
+		response.Value = string(archive)
+		response.Message = time.Now().Format(DiagnoseArchiveFmt)
+		response.Success = true
+	default:
+		if cmd, ok := command(request.Action); ok {
+			return s.runCommand(ctx, cmd, identity, request)
+		}
+		// unregistered since validate
+		response.Message = protocol.MsgBadRequest
+		response.Value = (&RequestError{"Action", fmt.Sprintf("unknown action %q", request.Action)}).Error()
+	}
+
+	return response
+}
+
+// trackConn adds or removes conn from the open connections; adding fails
+// once shutdown has begun
+func (s *Server) trackConn(cc *clientConn, add bool) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !add {
+		delete(s.conns, cc.conn)
+		return true
+	}
+	if s.closing {
+		return false
+	}
+	s.conns[cc.conn] = cc
+	return true
+}
+
+// stopAccepting closes the listeners, which unblocks Accept, and makes
+// every open connection stop waiting for its next request; requests
+// already running still get their response
+func (s *Server) stopAccepting() {
+	s.ready.Store(false)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, ln := range s.listeners {
+		ln.Close()
+	}
+	s.closing = true
+	for conn := range s.conns {
+		conn.SetReadDeadline(time.Now())
+	}
+}
+
+// closeConns cuts every open connection, abandoning requests in flight
+func (s *Server) closeConns() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+}
+
+// pubsubResult turns a PubSub error into a response message and success flag
+func pubsubResult(err error, ok string) (string, bool) {
+	switch {
+	case err == nil:
+		return ok, true
+	case errors.Is(err, kvstore.ErrNotSubscribed):
+		return protocol.MsgNotSubscribed, false
+	case errors.Is(err, path.ErrBadPattern):
+		return protocol.MsgBadPattern, false
+	default:
+		kvstore.RecordError("Error updating pub/sub log:", err)
+		return protocol.MsgServerError, false
+	}
+}
+
+// ttlMode maps a request's TTLMode to the store's
+func ttlMode(name string) (kvstore.TTLMode, bool) {
+	switch name {
+	case "":
+		return kvstore.TTLDefault, true
+	case protocol.TTLKeep:
+		return kvstore.TTLKeep, true
+	case protocol.TTLReset:
+		return kvstore.TTLReset, true
+	}
+	return kvstore.TTLDefault, false
+}
+
+// parseScores reads sorted set scores written by protocol.FormatScore
+func parseScores(values []string) ([]float64, bool) {
+	scores := make([]float64, len(values))
+	for i, value := range values {
+		score, err := protocol.ParseScore(value)
+		if err != nil {
+			return nil, false
+		}
+		scores[i] = score
+	}
+	return scores, true
+}
+
+// scoredValues lists each of members followed by its score, for Values
+func scoredValues(members []kvstore.ScoredMember) []string {
+	values := make([]string, 0, 2*len(members))
+	for _, m := range members {
+		values = append(values, m.Member, protocol.FormatScore(m.Score))
+	}
+	return values
+}
+
+// parseGeoArea reads the area of a GEOSEARCH from its Values: a centre and
+// a radius, or a centre, a width and a height
+func parseGeoArea(values []string) (kvstore.GeoArea, bool) {
+	v, ok := parseScores(values)
+	if !ok || len(v) != 3 && len(v) != 4 || !kvstore.ValidGeo(v[0], v[1]) {
+		return kvstore.GeoArea{}, false
+	}
+	for _, size := range v[2:] {
+		if size <= 0 {
+			return kvstore.GeoArea{}, false
+		}
+	}
+	area := kvstore.GeoArea{Lon: v[0], Lat: v[1]}
+	if len(v) == 3 {
+		area.Radius = v[2]
+	} else {
+		area.Width, area.Height = v[2], v[3]
+	}
+	return area, true
+}
+
+// capabilities lists what this server supports, for HELLO
+func capabilities() []string {
+	caps := []string{
+		protocol.CapPersistent,
+		protocol.CodecCapability(protocol.Gob.Name()),
+		protocol.CodecCapability(protocol.JSON.Name()),
+		protocol.CapLocks,
+		protocol.CapWindows,
+		protocol.CapPubSub,
+		protocol.CapJournal,
+		protocol.CapDiagnose,
+		protocol.CapPins,
+		protocol.CapCluster,
+		protocol.CapChecksums,
+		protocol.CapUpdateTTL,
+		protocol.CapAdmin,
+		protocol.CapList,
+		protocol.CapCommands,
+		protocol.CapDBSize,
+		protocol.CapRename,
+		protocol.CapScan,
+		protocol.CapRange,
+		protocol.CapBatch,
+		protocol.CapCounters,
+		protocol.CapAppend,
+		protocol.CapSetNX,
+		protocol.CapGetSet,
+		protocol.CapCAS,
+		protocol.CapRevisions,
+		protocol.CapReadTx,
+		protocol.CapHistory,
+		protocol.CapMulti,
+		protocol.CapWatch,
+		protocol.CapWriteBatch,
+		protocol.CapLeases,
+		protocol.CapHashes,
+		protocol.CapLists,
+		protocol.CapSets,
+		protocol.CapZSets,
+		protocol.CapStreams,
+		protocol.CapBitmaps,
+		protocol.CapGeo,
+		protocol.CapJSON,
+		protocol.CapSearch,
+		protocol.CapScripting,
+		protocol.CapPing,
+		protocol.CapTracking,
+		protocol.CapReplicas,
+		protocol.CapFailover,
+		protocol.CapStaleness,
+	}
+	for _, name := range Commands() {
+		caps = append(caps, protocol.CommandCapability(name))
+	}
+	return caps
+}
@@ -0,0 +1,42 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+)
+
+// newTestServer starts a server of kvs on a free local port, or on addrs
+// if given, keeping nothing on disk, and stops it when the test ends
+func newTestServer(t testing.TB, kvs *kvstore.KeyValueStore, addrs ...string) *Server {
+	t.Helper()
+	if len(addrs) == 0 {
+		addrs = []string{"127.0.0.1:0"}
+	}
+	s := NewServerWithStore(kvs, addrs...)
+	s.SetPersister(kvstore.NoPersistence{})
+	s.SetFiles(Files{})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// listenAddr returns the address the i-th listener of s took
+func listenAddr(s *Server, i int) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.listeners[i].Addr().String()
+}
+
+// newTestClient returns a client of the first listener of s, closed when
+// the test ends
+func newTestClient(t testing.TB, s *Server, opts ...kvsclient.Option) *kvsclient.Client {
+	t.Helper()
+	c := kvsclient.NewClient(listenAddr(s, 0), opts...)
+	t.Cleanup(func() { c.Close() })
+	return c
+}
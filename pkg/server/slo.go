@@ -0,0 +1,275 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// SLO is a latency objective: the Quantile of Action's latencies, the time
+// the server takes to handle a request not counting the network, must
+// stay under Max
+type SLO struct {
+	Action   string
+	Quantile float64 // e.g. 0.99
+	Max      time.Duration
+}
+
+// String formats o as ParseSLOs reads it, e.g. "GET:p99<5ms"
+func (o SLO) String() string {
+	return fmt.Sprintf("%s:p%s<%s", o.Action, strconv.FormatFloat(o.Quantile*100, 'f', -1, 64), o.Max)
+}
+
+// ParseSLOs parses comma-separated objectives such as
+// "GET:p99<5ms,SET:p99.9<20ms"
+func ParseSLOs(s string) ([]SLO, error) {
+	var slos []SLO
+	for _, item := range strings.Split(s, ",") {
+		if item == "" {
+			continue
+		}
+		action, rest, ok1 := strings.Cut(item, ":")
+		quantile, max, ok2 := strings.Cut(rest, "<")
+		if !ok1 || !ok2 || !strings.HasPrefix(quantile, "p") {
+			return nil, fmt.Errorf("invalid SLO %q, expected action:pNN<duration", item)
+		}
+		q, err := strconv.ParseFloat(quantile[1:], 64)
+		if err != nil || q <= 0 || q > 100 {
+			return nil, fmt.Errorf("invalid quantile in SLO %q", item)
+		}
+		d, err := time.ParseDuration(max)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid latency in SLO %q", item)
+		}
+		slos = append(slos, SLO{Action: strings.ToUpper(action), Quantile: q / 100, Max: d})
+	}
+	return slos, nil
+}
+
+// Degradation is a way of shedding load while SLOs are missed
+type Degradation int
+
+const (
+	// DegradeListings refuses LIST, DBSIZE, RANGE and SEARCH, which visit
+	// many keys at once, with protocol.MsgDegraded
+	DegradeListings Degradation = iota
+	// DegradeScan makes SCAN examine at most DegradedScanCount keys a page
+	DegradeScan
+	// DegradeShed refuses requests marked LowPriority with
+	// protocol.MsgDegraded
+	DegradeShed
+)
+
+var degradationNames = []string{"listings", "scan", "shed"}
+
+func (d Degradation) String() string {
+	if d < DegradeListings || d > DegradeShed {
+		return fmt.Sprintf("Degradation(%d)", int(d))
+	}
+	return degradationNames[d]
+}
+
+// ParseDegradations parses comma-separated degradation names as printed
+// by String
+func ParseDegradations(s string) ([]Degradation, error) {
+	var ds []Degradation
+	for _, name := range strings.Split(s, ",") {
+		if name == "" {
+			continue
+		}
+		i := slices.Index(degradationNames, name)
+		if i < 0 {
+			return nil, fmt.Errorf("unknown degradation %q, expected listings, scan or shed", name)
+		}
+		ds = append(ds, Degradation(i))
+	}
+	return ds, nil
+}
+
+// DegradedScanCount is how many keys a SCAN page examines at most under
+// DegradeScan
+const DegradedScanCount = 10
+
+// DefaultSLOCheckInterval is how often SLOs are checked unless
+// SLOGuard.Interval says otherwise
+const DefaultSLOCheckInterval = 5 * time.Second
+
+// sloRecoverChecks is how many checks in a row must meet every SLO before
+// the degradations are lifted, so they don't flap
+const sloRecoverChecks = 3
+
+// maxLatencySamples bounds the latencies kept per action between checks;
+// past it a random sample of them is kept
+const maxLatencySamples = 4096
+
+// SLOGuard degrades the server while it misses its latency objectives.
+// Every Interval it checks the latencies since the last check against
+// SLOs, and as soon as one is missed it turns on Degrade, until every SLO
+// has been met for several checks in a row. Both are logged, and ADMIN
+// STATS shows the state and the latest measurements. No SLOs turn it off.
+type SLOGuard struct {
+	SLOs     []SLO
+	Degrade  []Degradation
+	Interval time.Duration
+}
+
+// SetSLOGuard sets the latency objectives and what to do when they are
+// missed; call it before Start
+func (s *Server) SetSLOGuard(g SLOGuard) {
+	if g.Interval <= 0 {
+		g.Interval = DefaultSLOCheckInterval
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.slo = g
+	s.latencies.samples = make(map[string]*latencySamples)
+	for _, o := range g.SLOs {
+		s.latencies.samples[o.Action] = &latencySamples{}
+	}
+}
+
+// latencies collects request latencies of the actions with an SLO
+type latencies struct {
+	mu      sync.Mutex
+	samples map[string]*latencySamples // set before Start, read-only after
+	report  []string                   // the latest check, for ADMIN STATS
+}
+
+// latencySamples is a reservoir sample of an action's latencies
+type latencySamples struct {
+	d    []time.Duration
+	seen int
+}
+
+// observe records that a request for action took d
+func (s *Server) observe(action string, d time.Duration) {
+	ls := s.latencies.samples[action]
+	if ls == nil {
+		return
+	}
+	s.latencies.mu.Lock()
+	defer s.latencies.mu.Unlock()
+	ls.seen++
+	if len(ls.d) < maxLatencySamples {
+		ls.d = append(ls.d, d)
+	} else if i := rand.Intn(ls.seen); i < maxLatencySamples {
+		ls.d[i] = d
+	}
+}
+
+// watchSLOs checks the SLOs every interval until ctx is done
+func (s *Server) watchSLOs(ctx context.Context, g SLOGuard) {
+	ticker := time.NewTicker(g.Interval)
+	defer ticker.Stop()
+	met := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		missed := s.checkSLOs(g)
+		switch {
+		case len(missed) > 0:
+			met = 0
+			if !s.degraded.Swap(true) {
+				kvstore.RecordError("Latency objectives missed:", fmt.Errorf("%s; degrading: %s", strings.Join(missed, ", "), degradationList(g.Degrade)))
+			}
+		case s.degraded.Load():
+			if met++; met >= sloRecoverChecks {
+				s.degraded.Store(false)
+				kvstore.Logf(kvstore.LogWarn, "Latency objectives met for %d checks, degradations lifted", met)
+			}
+		}
+	}
+}
+
+// checkSLOs measures the latencies since the last check and returns the
+// SLOs they miss; an action without requests meets its SLOs
+func (s *Server) checkSLOs(g SLOGuard) (missed []string) {
+	s.latencies.mu.Lock()
+	taken := make(map[string][]time.Duration, len(s.latencies.samples))
+	for action, ls := range s.latencies.samples {
+		taken[action] = ls.d
+		ls.d, ls.seen = nil, 0
+	}
+	s.latencies.mu.Unlock()
+
+	report := make([]string, 0, len(g.SLOs))
+	for _, o := range g.SLOs {
+		d := taken[o.Action]
+		if len(d) == 0 {
+			report = append(report, fmt.Sprintf("%s: no requests", o))
+			continue
+		}
+		slices.Sort(d)
+		got := d[int(math.Ceil(o.Quantile*float64(len(d))))-1]
+		state := "met"
+		if got > o.Max {
+			state = "missed"
+			missed = append(missed, fmt.Sprintf("%s at %s", o, got))
+		}
+		report = append(report, fmt.Sprintf("%s: %s %s", o, got, state))
+	}
+	s.latencies.mu.Lock()
+	s.latencies.report = report
+	s.latencies.mu.Unlock()
+	return missed
+}
+
+// degradedBy reports whether d is in force
+func (s *Server) degradedBy(d Degradation) bool {
+	return s.degraded.Load() && slices.Contains(s.slo.Degrade, d)
+}
+
+// degradedRefusal reports whether request is refused by a degradation
+func (s *Server) degradedRefusal(request protocol.Request) bool {
+	if !s.degraded.Load() {
+		return false
+	}
+	switch {
+	case request.LowPriority && s.degradedBy(DegradeShed):
+		return true
+	case request.Action == protocol.ActionList || request.Action == protocol.ActionDBSize || request.Action == protocol.ActionRange || request.Action == protocol.ActionSearch:
+		return s.degradedBy(DegradeListings)
+	}
+	return false
+}
+
+// sloStats are the ADMIN STATS lines of the SLO guard
+func (s *Server) sloStats() []string {
+	if len(s.slo.SLOs) == 0 {
+		return nil
+	}
+	state := "off"
+	if s.degraded.Load() {
+		state = degradationList(s.slo.Degrade)
+	}
+	lines := []string{"degraded: " + state}
+	s.latencies.mu.Lock()
+	defer s.latencies.mu.Unlock()
+	for _, line := range s.latencies.report {
+		lines = append(lines, "slo: "+line)
+	}
+	return lines
+}
+
+func degradationList(ds []Degradation) string {
+	if len(ds) == 0 {
+		return "none"
+	}
+	names := make([]string, len(ds))
+	for i, d := range ds {
+		names[i] = d.String()
+	}
+	return strings.Join(names, ",")
+}
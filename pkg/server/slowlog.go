@@ -0,0 +1,129 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultSlowLogThreshold is how long a request takes to be slow, as
+// kvs-server sets it up; a Server keeps no slow log until SetSlowLog
+const DefaultSlowLogThreshold = 10 * time.Millisecond
+
+// DefaultSlowLogSize is how many slow requests the slow log keeps unless
+// SetSlowLog says otherwise
+const DefaultSlowLogSize = 128
+
+// maxSlowLogKey is how much of a key the slow log keeps
+const maxSlowLogKey = 128
+
+// slowRequest is a request that took at least the slow log's threshold
+type slowRequest struct {
+	id     uint64
+	start  time.Time
+	took   time.Duration
+	action string
+	key    string
+	client string
+}
+
+func (r slowRequest) String() string {
+	return fmt.Sprintf("id=%d start=%s took=%s action=%s key=%q client=%s",
+		r.id, r.start.UTC().Format(time.RFC3339Nano), r.took, r.action, r.key, r.client)
+}
+
+// slowLog keeps the latest slow requests in a ring
+type slowLog struct {
+	threshold atomic.Int64 // nanoseconds, 0 is off
+	mu        sync.Mutex   // guards the rest
+	ring      []slowRequest
+	next      int    // where the next one goes
+	logged    uint64 // since the start, the id of the latest
+}
+
+// SetSlowLog makes the server keep the latest size requests that took at
+// least threshold to run, for ADMIN SLOWLOG; size <= 0 is
+// DefaultSlowLogSize and threshold <= 0 turns the log off. The time is
+// the handler's, after any Middleware. Changing the size empties the log.
+func (s *Server) SetSlowLog(threshold time.Duration, size int) {
+	if size <= 0 {
+		size = DefaultSlowLogSize
+	}
+	l := &s.slowLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.threshold.Store(int64(max(threshold, 0)))
+	if len(l.ring) != size {
+		l.ring, l.next = make([]slowRequest, 0, size), 0
+	}
+}
+
+// record logs request, from client, if it took at least the threshold
+func (l *slowLog) record(client string, request protocol.Request, took time.Duration) {
+	if threshold := l.threshold.Load(); threshold == 0 || int64(took) < threshold {
+		return
+	}
+	keys := request.Keys
+	if request.Key != "" {
+		keys = append([]string{request.Key}, keys...)
+	}
+	var key string
+	if len(keys) > 0 {
+		key = keys[0]
+		if len(key) > maxSlowLogKey {
+			key = key[:maxSlowLogKey] + "..."
+		}
+		if len(keys) > 1 {
+			key += fmt.Sprintf(" (+%d)", len(keys)-1)
+		}
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.logged++
+	r := slowRequest{id: l.logged, start: time.Now().Add(-took), took: took, action: request.Action, key: key, client: client}
+	if len(l.ring) < cap(l.ring) {
+		l.ring = append(l.ring, r)
+		return
+	}
+	l.ring[l.next] = r
+	l.next = (l.next + 1) % len(l.ring)
+}
+
+// entries lists the logged requests, newest first
+func (l *slowLog) entries() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	lines := make([]string, 0, len(l.ring))
+	for i := range l.ring {
+		// the newest is just before next, wrapping around
+		lines = append(lines, l.ring[(l.next-1-i+2*len(l.ring))%len(l.ring)].String())
+	}
+	return lines
+}
+
+// reset empties the log and returns how many requests it held
+func (l *slowLog) reset() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	n := len(l.ring)
+	l.ring, l.next = l.ring[:0], 0
+	return n
+}
+
+// slowStats describes the slow log for ADMIN STATS
+func (s *Server) slowStats() []string {
+	l := &s.slowLog
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	threshold := "off"
+	if t := time.Duration(l.threshold.Load()); t > 0 {
+		threshold = t.String()
+	}
+	return []string{
+		fmt.Sprintf("slowlog_threshold: %s", threshold),
+		fmt.Sprintf("slow_requests: %d", l.logged),
+	}
+}
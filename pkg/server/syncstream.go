@@ -0,0 +1,110 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// snapshot streaming defaults
+const (
+	// SyncChunkSize is how many bytes of the snapshot a replica asks for
+	// with each SYNC
+	SyncChunkSize = 1 << 20
+	// SyncStreamTTL is how long a primary keeps a snapshot it is
+	// streaming after the replica last asked for a chunk of it, for the
+	// replica to resume from where its connection dropped
+	SyncStreamTTL = time.Minute
+)
+
+// syncStream is a snapshot a primary streams to a replica in chunks
+type syncStream struct {
+	data []byte
+	id   string // replication ID
+	seq  uint64
+	used time.Time
+}
+
+// syncChunk runs a SYNC with Limit or Continue: the first chunk of a new
+// snapshot of up to Limit bytes, or the one after the chunk whose Continue
+// it is; caller fills in Epoch and checks that this is the primary
+func (s *Server) syncChunk(request protocol.Request, response protocol.Response) protocol.Response {
+	name, offset, stream := s.syncResume(request.Continue)
+	if request.Continue != "" && stream == nil {
+		// expired, or from before a restart
+		response.Message = protocol.MsgResync
+		return response
+	}
+	if stream == nil {
+		snapshot, id, seq := s.kvs.ReplicationSnapshot()
+		data, err := json.Marshal(snapshot)
+		if err != nil {
+			kvstore.RecordError("Error syncing a replica:", err)
+			response.Message = protocol.MsgServerError
+			return response
+		}
+		var b [8]byte
+		rand.Read(b[:])
+		name, stream = hex.EncodeToString(b[:]), &syncStream{data: data, id: id, seq: seq, used: time.Now()}
+		s.mu.Lock()
+		s.syncs[name] = stream
+		s.mu.Unlock()
+	}
+	limit := request.Limit
+	if limit <= 0 {
+		limit = SyncChunkSize
+	}
+	end := min(offset+limit, len(stream.data))
+	chunk := string(stream.data[offset:end])
+	response.Value, response.Checksum, response.Revision = chunk, protocol.Checksum(chunk), stream.seq
+	response.Success = true
+	if end < len(stream.data) {
+		response.More, response.Continue = true, name+":"+strconv.Itoa(end)
+		return response
+	}
+	response.Continue = stream.id
+	s.mu.Lock()
+	delete(s.syncs, name)
+	s.mu.Unlock()
+	return response
+}
+
+// syncResume finds the stream a SYNC Continue token names and the offset
+// it continues at, forgetting the streams unused for SyncStreamTTL; stream
+// is nil if there is none
+func (s *Server) syncResume(token string) (name string, offset int, stream *syncStream) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, stream := range s.syncs {
+		if time.Since(stream.used) > SyncStreamTTL {
+			delete(s.syncs, name)
+		}
+	}
+	name, at, ok := strings.Cut(token, ":")
+	if !ok {
+		return "", 0, nil
+	}
+	stream = s.syncs[name]
+	offset, err := strconv.Atoi(at)
+	if stream == nil || err != nil || offset < 0 || offset > len(stream.data) {
+		return "", 0, nil
+	}
+	stream.used = time.Now()
+	return name, offset, stream
+}
+
+// syncReceived describes how far a replica is through the snapshot it is
+// loading, for replicationInfo; caller holds r.mu
+func (r *replica) syncReceived() string {
+	if r.syncToken == "" {
+		return "none"
+	}
+	return fmt.Sprintf("%d bytes", len(r.syncData))
+}
@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvsclient"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// newSyncPrimary returns a primary of 100 keys
+func newSyncPrimary(t *testing.T) *Server {
+	t.Helper()
+	kvs := kvstore.NewKeyValueStore()
+	for i := 0; i < 100; i++ {
+		kvs.SET(fmt.Sprintf("key/%d", i), "value")
+	}
+	return newTestServer(t, kvs)
+}
+
+func TestSyncStreamsTheSnapshotInChunks(t *testing.T) {
+	s := newSyncPrimary(t)
+	const limit = 256
+	var data []byte
+	var chunks int
+	request := protocol.Request{Action: protocol.ActionSync, Limit: limit}
+	for {
+		response := s.sync("replica", request)
+		if !response.Success {
+			t.Fatalf("SYNC after %d bytes: %s", len(data), response.Message)
+		}
+		if len(response.Value) > limit {
+			t.Fatalf("chunk of %d bytes, limit %d", len(response.Value), limit)
+		}
+		if response.Checksum != protocol.Checksum(response.Value) {
+			t.Fatalf("chunk after %d bytes fails its checksum", len(data))
+		}
+		data = append(data, response.Value...)
+		chunks++
+		if !response.More {
+			// the last chunk names the history to PSYNC from
+			if _, id, _ := s.kvs.ReplicationSnapshot(); response.Continue != id {
+				t.Errorf("last chunk's Continue %q, want the replication ID %q", response.Continue, id)
+			}
+			break
+		}
+		request.Continue = response.Continue
+		if chunks == 1 {
+			// a chunk asked for again, as after a dropped connection, is the same
+			again, next := s.sync("replica", request), s.sync("replica", request)
+			if !again.Success || next.Value != again.Value {
+				t.Fatalf("chunk after %s differs when asked for again", response.Continue)
+			}
+		}
+	}
+	if chunks < 2 {
+		t.Fatalf("snapshot of %d bytes in %d chunk", len(data), chunks)
+	}
+	var snapshot kvstore.BackupSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		t.Fatalf("chunks don't join up: %v", err)
+	}
+	if len(snapshot.Data) != 100 {
+		t.Errorf("snapshot of %d keys, want 100", len(snapshot.Data))
+	}
+	// the stream is forgotten once sent
+	if response := s.sync("replica", request); response.Message != protocol.MsgResync {
+		t.Errorf("SYNC of a finished stream = %+v, want %s", response, protocol.MsgResync)
+	}
+}
+
+func TestFullSyncResumes(t *testing.T) {
+	primary := newSyncPrimary(t)
+	client := kvsclient.NewClient(listenAddr(primary, 0))
+	defer client.Close()
+	ctx := context.Background()
+
+	// a replica whose connection dropped after the first chunk
+	first := primary.sync("replica", protocol.Request{Action: protocol.ActionSync, Limit: 256})
+	if !first.More {
+		t.Fatal("snapshot sent in one chunk")
+	}
+	// the rest of the snapshot is of the keys when it was taken
+	primary.kvs.SET("key/100", "value")
+	kvs := kvstore.NewKeyValueStore()
+	follower := NewServerWithStore(kvs)
+	r := &replica{primary: listenAddr(primary, 0), syncToken: first.Continue, syncData: []byte(first.Value)}
+	if err := follower.fullSync(ctx, client, r); err != nil {
+		t.Fatal(err)
+	}
+	if kvs.Len() != 100 {
+		t.Fatalf("%d keys after resuming the snapshot, want the 100 it was taken with", kvs.Len())
+	}
+	if r.syncToken != "" || r.syncData != nil {
+		t.Error("the chunks received were kept after the snapshot loaded")
+	}
+
+	// one the primary no longer has starts again
+	kvs = kvstore.NewKeyValueStore()
+	follower = NewServerWithStore(kvs)
+	r = &replica{primary: listenAddr(primary, 0), syncToken: "gone:10", syncData: []byte("0123456789")}
+	if err := follower.fullSync(ctx, client, r); err != nil {
+		t.Fatal(err)
+	}
+	if kvs.Len() != 101 {
+		t.Fatalf("%d keys after starting the snapshot again, want 101", kvs.Len())
+	}
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+// DefaultMaxKeyLen is the longest key, in bytes, the server takes unless
+// SetMaxKeyLen says otherwise
+const DefaultMaxKeyLen = 4096
+
+// RequestError is why a request was refused with BAD_REQUEST before it
+// ran: Field is the request field at fault and Reason what is wrong with it
+type RequestError struct {
+	Field  string
+	Reason string
+}
+
+func (e *RequestError) Error() string {
+	return e.Field + ": " + e.Reason
+}
+
+// SetMaxKeyLen bounds the bytes of a key, DefaultMaxKeyLen if n is zero.
+// A request naming a longer key is refused with BAD_REQUEST, as is one
+// naming an empty key or a key with ASCII control characters.
+func (s *Server) SetMaxKeyLen(n int) {
+	s.maxKeyLen.Store(int64(n))
+}
+
+// MaxKeyLen returns the limit of SetMaxKeyLen
+func (s *Server) MaxKeyLen() int {
+	if n := s.maxKeyLen.Load(); n > 0 {
+		return int(n)
+	}
+	return DefaultMaxKeyLen
+}
+
+// validate checks that the server knows request's action and the keys it
+// names, if it is an action on keys, and returns a *RequestError for the
+// unknown action or the first key that is not a valid key
+func (s *Server) validate(request protocol.Request) error {
+	keyed := keyActions[request.Action]
+	if !builtinActions[request.Action] {
+		cmd, ok := command(request.Action)
+		if !ok {
+			return &RequestError{"Action", fmt.Sprintf("unknown action %q", request.Action)}
+		}
+		keyed = cmd.Keyed
+	}
+	if keyed {
+		if err := s.validKey("Key", request.Key); err != nil {
+			return err
+		}
+	}
+	field, keys := "Keys", multiKeys(request)
+	switch request.Action {
+	case protocol.ActionRename, protocol.ActionCopy:
+		field = "Value"
+	case protocol.ActionMGet, protocol.ActionMSet:
+		keys = request.Keys
+	}
+	for _, key := range keys {
+		if err := s.validKey(field, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validKey returns a *RequestError naming field if key is empty, too long
+// or has a control character
+func (s *Server) validKey(field, key string) error {
+	if key == "" {
+		return &RequestError{field, "empty key"}
+	}
+	if max := s.MaxKeyLen(); len(key) > max {
+		return &RequestError{field, fmt.Sprintf("key of %d bytes, at most %d", len(key), max)}
+	}
+	for i := 0; i < len(key); i++ {
+		if c := key[i]; c < 0x20 || c == 0x7f {
+			return &RequestError{field, fmt.Sprintf("control character %#02x in key at byte %d", c, i)}
+		}
+	}
+	return nil
+}
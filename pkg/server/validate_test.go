@@ -0,0 +1,51 @@
+package server
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestValidateRefusesBadRequests(t *testing.T) {
+	s := NewServer()
+	for _, tc := range []struct {
+		request protocol.Request
+		field   string
+	}{
+		{protocol.Request{Action: "NOPE", Key: "k"}, "Action"},
+		{protocol.Request{Action: ""}, "Action"},
+		{protocol.Request{Action: protocol.ActionSet, Key: "", Value: "v"}, "Key"},
+		{protocol.Request{Action: protocol.ActionSet, Key: "a\nb", Value: "v"}, "Key"},
+		{protocol.Request{Action: protocol.ActionSet, Key: strings.Repeat("k", DefaultMaxKeyLen+1), Value: "v"}, "Key"},
+		{protocol.Request{Action: protocol.ActionMGet, Keys: []string{"a", ""}}, "Keys"},
+		{protocol.Request{Action: protocol.ActionRename, Key: "a", Value: "\x7f"}, "Value"},
+	} {
+		response := s.handle(context.Background(), "test", tc.request, false)
+		if response.Message != protocol.MsgBadRequest || !strings.HasPrefix(response.Value, tc.field+": ") {
+			t.Errorf("%s %q: got %s %q, want %s naming %s", tc.request.Action, tc.request.Key, response.Message, response.Value, protocol.MsgBadRequest, tc.field)
+		}
+	}
+}
+
+func TestValidateTakesCustomCommands(t *testing.T) {
+	s := NewServer()
+	if err := s.validate(protocol.Request{Action: "VALIDATE_TEST"}); err == nil {
+		t.Fatal("unregistered action validated")
+	}
+	RegisterCommand("VALIDATE_TEST", Command{Keyed: true, Run: func(ctx context.Context, store *Store, request protocol.Request) protocol.Response {
+		return protocol.Response{Success: true}
+	}})
+	defer func() {
+		commandsMu.Lock()
+		defer commandsMu.Unlock()
+		delete(commands, "VALIDATE_TEST")
+	}()
+	if err := s.validate(protocol.Request{Action: "VALIDATE_TEST", Key: "k"}); err != nil {
+		t.Fatalf("registered action refused: %v", err)
+	}
+	if err := s.validate(protocol.Request{Action: "VALIDATE_TEST"}); err == nil {
+		t.Fatal("keyed custom command validated without a key")
+	}
+}
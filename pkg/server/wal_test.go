@@ -0,0 +1,29 @@
+package server
+
+import (
+	"context"
+	"testing"
+
+	"github.com/nishantpratap1/key-value-store-golang/pkg/kvstore"
+	"github.com/nishantpratap1/key-value-store-golang/pkg/protocol"
+)
+
+func TestWriteFailsWhenWALFsyncFails(t *testing.T) {
+	kvs := kvstore.NewKeyValueStore()
+	// writes to /dev/null succeed and fsyncs of it fail
+	if _, err := kvs.OpenWAL("/dev/null", nil); err != nil {
+		t.Fatal(err)
+	}
+	defer kvs.CloseWAL()
+	s := newTestServer(t, kvs)
+	set := protocol.Request{Action: protocol.ActionSet, Key: "k", Value: "v"}
+
+	kvs.SetWALSync(kvstore.WALSyncOS)
+	if response := s.handle(context.Background(), "test", set, false); !response.Success {
+		t.Fatalf("SET without fsync: %s", response.Message)
+	}
+	kvs.SetWALSync(kvstore.WALSyncAlways)
+	if response := s.handle(context.Background(), "test", set, false); response.Success || response.Message != protocol.MsgServerError {
+		t.Fatalf("SET with a failing fsync = %+v, want %s", response, protocol.MsgServerError)
+	}
+}